@@ -0,0 +1,78 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+)
+
+// A DocumentNode is a node of the result of EvaluateAcross, with the
+// index of its document.
+type DocumentNode struct {
+	Doc  int
+	Node NodeNavigator
+}
+
+// An AcrossResult is the result of an expression over many documents,
+// returned by EvaluateAcross.
+type AcrossResult struct {
+	// Values are the results of the documents, in their order: a
+	// float64, a string or a bool, or nil for a node-set, whose nodes are
+	// in Nodes.
+	Values []interface{}
+	// Nodes are the nodes of the node-sets, document by document, each in
+	// document order.
+	Nodes []DocumentNode
+	// Sum is the sum of the numbers of Values, such as the number of
+	// errors of count(//error) in all the documents. The numbers that are
+	// NaN are left out.
+	Sum float64
+	// Matched is the number of documents whose result is true as a
+	// boolean, such as a non-empty node-set.
+	Matched int
+}
+
+// EvaluateAcross evaluates expr from the root of each document of docs,
+// within the limits of opts for each of them, and merges the results,
+// for the statistics of a corpus such as count(//error) over the files
+// of a crawl. The error of a document is returned with its index, and
+// the evaluation stops there.
+func EvaluateAcross(docs []NodeNavigator, expr *Expr, opts EvalOptions) (*AcrossResult, error) {
+	r := &AcrossResult{Values: make([]interface{}, len(docs))}
+	for i, doc := range docs {
+		v, err := expr.EvaluateWithOptions(doc, opts)
+		if err != nil {
+			return nil, fmt.Errorf("xpath: document %d: %w", i, err)
+		}
+		switch v := v.(type) {
+		case *NodeIterator:
+			n := len(r.Nodes)
+			for v.MoveNext() {
+				r.Nodes = append(r.Nodes, DocumentNode{Doc: i, Node: v.Current().Copy()})
+			}
+			if err := v.Err(); err != nil {
+				return nil, fmt.Errorf("xpath: document %d: %w", i, err)
+			}
+			if len(r.Nodes) > n {
+				r.Matched++
+			}
+			continue
+		case float64:
+			if !math.IsNaN(v) {
+				r.Sum += v
+				if v != 0 {
+					r.Matched++
+				}
+			}
+		case string:
+			if v != "" {
+				r.Matched++
+			}
+		case bool:
+			if v {
+				r.Matched++
+			}
+		}
+		r.Values[i] = v
+	}
+	return r, nil
+}
@@ -0,0 +1,31 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvaluateAcross(t *testing.T) {
+	docs := []NodeNavigator{createNavigator(book_example), createNavigator(employee_example), createNavigator(book_example)}
+
+	r, err := EvaluateAcross(docs, MustCompile("count(//book)"), EvalOptions{})
+	assertNoErr(t, err)
+	assertEqual(t, float64(8), r.Sum)
+	assertEqual(t, 2, r.Matched)
+	assertEqual(t, []interface{}{float64(4), float64(0), float64(4)}, r.Values)
+
+	r, err = EvaluateAcross(docs, MustCompile("//book[@category = 'web']/title"), EvalOptions{})
+	assertNoErr(t, err)
+	assertEqual(t, 4, len(r.Nodes))
+	assertEqual(t, []int{0, 0, 2, 2}, []int{r.Nodes[0].Doc, r.Nodes[1].Doc, r.Nodes[2].Doc, r.Nodes[3].Doc})
+	assertEqual(t, "Learning XML", r.Nodes[3].Node.Value())
+	assertEqual(t, 2, r.Matched)
+	assertEqual(t, []interface{}{nil, nil, nil}, r.Values)
+
+	r, err = EvaluateAcross(docs, MustCompile("boolean(//employee)"), EvalOptions{})
+	assertNoErr(t, err)
+	assertEqual(t, 1, r.Matched)
+
+	_, err = EvaluateAcross(docs, MustCompile("count(reverse(//*))"), EvalOptions{MaxBufferedNodes: 1})
+	assertTrue(t, errors.Is(err, ErrResourceLimit))
+}
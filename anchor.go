@@ -0,0 +1,49 @@
+package xpath
+
+// An Anchor is where the relative location paths of an expression start,
+// see CompileOptions.Anchor.
+type Anchor int
+
+const (
+	// AnchorContext starts the relative paths at the node the expression
+	// is evaluated from, as XPath does: bookstore/book selects the books
+	// of the bookstore from the document node, as if it were absolute,
+	// but none from a book.
+	AnchorContext Anchor = iota
+
+	// AnchorRoot starts the relative paths at the root of the document
+	// of the node the expression is evaluated from, as if they began with
+	// /: bookstore/book selects the same books from any node. The paths
+	// of predicates and those after a filter, such as title in
+	// book[title] or (book)/title, still start at their own context
+	// node.
+	AnchorRoot
+)
+
+// anchorPaths returns n with its relative paths started at the root with
+// AnchorRoot. The paths of predicates are left as they are.
+func anchorPaths(n node, anchor Anchor) node {
+	if anchor != AnchorRoot {
+		return n
+	}
+	switch n := n.(type) {
+	case *axisNode:
+		if n.Input == nil {
+			n.Input = newRootNode("/")
+		} else {
+			n.Input = anchorPaths(n.Input, anchor)
+		}
+	case *filterNode:
+		n.Input = anchorPaths(n.Input, anchor)
+	case *groupNode:
+		n.Input = anchorPaths(n.Input, anchor)
+	case *operatorNode:
+		n.Left = anchorPaths(n.Left, anchor)
+		n.Right = anchorPaths(n.Right, anchor)
+	case *functionNode:
+		for i, arg := range n.Args {
+			n.Args[i] = anchorPaths(arg, anchor)
+		}
+	}
+	return n
+}
@@ -0,0 +1,33 @@
+package xpath
+
+import "testing"
+
+func TestAnchor(t *testing.T) {
+	// The second book.
+	iter := MustCompile("//book[2]").Select(createNavigator(book_example))
+	assertTrue(t, iter.MoveNext())
+	book := iter.Current().Copy()
+
+	root := CompileOptions{Anchor: AnchorRoot}
+	for _, tc := range []struct {
+		expr            string
+		context, anchor interface{}
+	}{
+		{"count(bookstore/book)", float64(0), float64(4)},
+		{"count(title)", float64(1), float64(0)},
+		{"count(bookstore/book[title = 'Harry Potter'])", float64(0), float64(1)},
+		{"count(bookstore/book[year = 2005] | bookstore/book)", float64(0), float64(4)},
+		{"count((bookstore/book)/title)", float64(0), float64(4)},
+		{"string(../book[1]/title)", "Everyday Italian", ""},
+		{"string(/bookstore/book[last()]/title)", "Learning XML", "Learning XML"},
+		{"count(.)", float64(1), float64(1)},
+		{"string(price)", "29.99", ""},
+	} {
+		assertEqual(t, tc.context, MustCompile(tc.expr).Evaluate(book.Copy()))
+		expr, err := CompileWithOptions(tc.expr, root)
+		assertNoErr(t, err)
+		if got := expr.Evaluate(book.Copy()); got != tc.anchor {
+			t.Errorf("%s with AnchorRoot = %v, want %v", tc.expr, got, tc.anchor)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package benchmarks
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+func navigator(w Workload) xpath.NodeNavigator {
+	var nav xpath.NodeNavigator = dom.CreateNavigator(w.Doc())
+	if w.Index != "" {
+		nav = xpath.IndexAttr(nav, w.Index)
+	}
+	return nav
+}
+
+// evaluate evaluates exp and returns the number of nodes selected, or the
+// value of the expression if it is not a node-set.
+func evaluate(exp *xpath.Expr, nav xpath.NodeNavigator) interface{} {
+	v := exp.Evaluate(nav)
+	if iter, ok := v.(*xpath.NodeIterator); ok {
+		n := 0
+		for iter.MoveNext() {
+			n++
+		}
+		return n
+	}
+	return v
+}
+
+func TestGenerators(t *testing.T) {
+	for _, tc := range []struct {
+		doc  *dom.Node
+		expr string
+		want float64
+	}{
+		{Wide(100), `count(/catalog/item)`, 100},
+		{Wide(100), `count(//item[name and price and tags/tag])`, 100},
+		{Deep(50), `count(//section)`, 50},
+		{Deep(50), `count(//section[@level='50']/ancestor::section)`, 49},
+		{Deep(50), `count(//p)`, 99},
+		{AttributeHeavy(10, 5), `count(//record/@*)`, 60},
+	} {
+		if got := xpath.MustCompile(tc.expr).Evaluate(dom.CreateNavigator(tc.doc)); got != tc.want {
+			t.Errorf("%s: expected %v, got %v", tc.expr, tc.want, got)
+		}
+	}
+	if Wide(100).FirstChild.LastChild.OutputXML(true) != Wide(100).FirstChild.LastChild.OutputXML(true) {
+		t.Error("expected the generated documents to be the same on each call")
+	}
+}
+
+func TestWorkloads(t *testing.T) {
+	// The indexed workloads give the same results as the scans.
+	results := make(map[string]interface{})
+	for _, w := range Workloads {
+		got := evaluate(xpath.MustCompile(w.Expr), navigator(w))
+		if got == 0 || got == float64(0) {
+			t.Errorf("%s: %s selected nothing", w.Name, w.Expr)
+		}
+		if want, ok := results[w.Expr]; ok && want != got {
+			t.Errorf("%s: expected %v, got %v", w.Name, want, got)
+		}
+		results[w.Expr] = got
+	}
+}
+
+func BenchmarkWorkloads(b *testing.B) {
+	for _, w := range Workloads {
+		w := w
+		b.Run(w.Name, func(b *testing.B) {
+			exp := xpath.MustCompile(w.Expr)
+			nav := navigator(w)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				evaluate(exp, nav.Copy())
+			}
+		})
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	b.ReportAllocs()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		for _, w := range Workloads {
+			xpath.MustCompile(w.Expr)
+		}
+	}
+	b.ReportMetric(float64(b.N*len(Workloads))/time.Since(start).Seconds(), "exprs/s")
+}
+
+// BenchmarkCompileDynamic compiles a different expression each time, as a
+// service building expressions from its requests does.
+func BenchmarkCompileDynamic(b *testing.B) {
+	exprs := make([]string, 1000)
+	for i := range exprs {
+		exprs[i] = fmt.Sprintf(`//item[@category = '%s' and price < %d]/name[contains(., '%s')]`, categories[i%len(categories)], i, words[i%len(words)])
+	}
+	b.ReportAllocs()
+	start := time.Now()
+	for i := 0; i < b.N; i++ {
+		if _, err := xpath.Compile(exprs[i%len(exprs)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "exprs/s")
+}
@@ -0,0 +1,194 @@
+// Package benchmarks generates large synthetic documents and defines the
+// query workloads used to measure the performance of the xpath package.
+//
+// The documents are built with the dom package and are the same for the
+// same arguments, so results can be compared between runs:
+//
+//	go test ./benchmarks -bench . -benchmem
+package benchmarks
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+
+	"github.com/antchfx/xpath/dom"
+)
+
+var (
+	categories = []string{"books", "music", "garden", "toys", "tools", "sports", "food", "health"}
+	words      = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliet", "kilo", "lima"}
+)
+
+// Wide returns a product catalog with n item elements directly under the
+// catalog element, each with a few attributes and child elements:
+//
+//	<catalog>
+//	  <item id="i0" category="books" stock="12">
+//	    <name>alpha bravo</name>
+//	    <price currency="EUR">12.50</price>
+//	    <tags><tag>charlie</tag><tag>delta</tag></tags>
+//	  </item>
+//	  ...
+//	</catalog>
+func Wide(n int) *dom.Node {
+	r := rand.New(rand.NewSource(1))
+	doc := dom.NewDocument()
+	catalog := dom.NewElement("catalog")
+	doc.AppendChild(catalog)
+	for i := 0; i < n; i++ {
+		item := dom.NewElement("item")
+		item.SetAttribute("id", "i"+strconv.Itoa(i))
+		item.SetAttribute("category", categories[r.Intn(len(categories))])
+		item.SetAttribute("stock", strconv.Itoa(r.Intn(100)))
+		catalog.AppendChild(item)
+
+		name := dom.NewElement("name")
+		name.AppendChild(dom.NewText(words[r.Intn(len(words))] + " " + words[r.Intn(len(words))]))
+		item.AppendChild(name)
+
+		price := dom.NewElement("price")
+		price.SetAttribute("currency", "EUR")
+		price.AppendChild(dom.NewText(fmt.Sprintf("%d.%02d", 1+r.Intn(200), r.Intn(100))))
+		item.AppendChild(price)
+
+		tags := dom.NewElement("tags")
+		for j, m := 0, 1+r.Intn(3); j < m; j++ {
+			tag := dom.NewElement("tag")
+			tag.AppendChild(dom.NewText(words[r.Intn(len(words))]))
+			tags.AppendChild(tag)
+		}
+		item.AppendChild(tags)
+	}
+	return doc
+}
+
+// Deep returns a document of sections nested depth levels deep. Each
+// section has a title, a paragraph before and after its nested section,
+// and its level as an attribute:
+//
+//	<doc>
+//	  <section level="1">
+//	    <title>Section 1</title>
+//	    <p>...</p>
+//	    <section level="2">...</section>
+//	    <p>...</p>
+//	  </section>
+//	</doc>
+func Deep(depth int) *dom.Node {
+	r := rand.New(rand.NewSource(2))
+	doc := dom.NewDocument()
+	parent := dom.NewElement("doc")
+	doc.AppendChild(parent)
+	paragraph := func() *dom.Node {
+		p := dom.NewElement("p")
+		p.AppendChild(dom.NewText(words[r.Intn(len(words))] + " " + words[r.Intn(len(words))] + " " + words[r.Intn(len(words))]))
+		return p
+	}
+	for i := 1; i <= depth; i++ {
+		section := dom.NewElement("section")
+		section.SetAttribute("level", strconv.Itoa(i))
+		title := dom.NewElement("title")
+		title.AppendChild(dom.NewText("Section " + strconv.Itoa(i)))
+		section.AppendChild(title)
+		section.AppendChild(paragraph())
+		parent.AppendChild(section)
+		// The closing paragraph follows the nested section once it is
+		// appended.
+		if parent.Type == dom.ElementNode && parent.Data == "section" {
+			parent.AppendChild(paragraph())
+		}
+		parent = section
+	}
+	return doc
+}
+
+// AttributeHeavy returns n empty record elements with attrs attributes
+// each, named a0, a1 and so on, as found in data exports:
+//
+//	<records>
+//	  <record id="r0" a0="golf" a1="17" a2="kilo" .../>
+//	  ...
+//	</records>
+func AttributeHeavy(n, attrs int) *dom.Node {
+	r := rand.New(rand.NewSource(3))
+	doc := dom.NewDocument()
+	records := dom.NewElement("records")
+	doc.AppendChild(records)
+	for i := 0; i < n; i++ {
+		record := dom.NewElement("record")
+		record.SetAttribute("id", "r"+strconv.Itoa(i))
+		for j := 0; j < attrs; j++ {
+			var v string
+			if j%2 == 0 {
+				v = words[r.Intn(len(words))]
+			} else {
+				v = strconv.Itoa(r.Intn(100))
+			}
+			record.SetAttribute("a"+strconv.Itoa(j), v)
+		}
+		records.AppendChild(record)
+	}
+	return doc
+}
+
+// A Workload is an expression evaluated against one of the generated
+// documents.
+type Workload struct {
+	Name string
+	Expr string
+	// Doc returns the document the expression is evaluated against. It
+	// is generated on the first call and shared by the workloads.
+	Doc func() *dom.Node
+	// Index names the attribute the navigator is wrapped with
+	// xpath.IndexAttr for, if not empty.
+	Index string
+}
+
+var (
+	wideDoc  = shared(func() *dom.Node { return Wide(10000) })
+	deepDoc  = shared(func() *dom.Node { return Deep(1000) })
+	attrsDoc = shared(func() *dom.Node { return AttributeHeavy(5000, 20) })
+)
+
+func shared(f func() *dom.Node) func() *dom.Node {
+	var (
+		once sync.Once
+		doc  *dom.Node
+	)
+	return func() *dom.Node {
+		once.Do(func() { doc = f() })
+		return doc
+	}
+}
+
+// Workloads are the canonical queries, covering the axes, predicates and
+// functions commonly found in stylesheets and scrapers.
+var Workloads = []Workload{
+	{Name: "wide/child-path", Expr: `/catalog/item/name`, Doc: wideDoc},
+	{Name: "wide/descendant", Expr: `//tag`, Doc: wideDoc},
+	{Name: "wide/attribute-predicate", Expr: `//item[@category='garden']`, Doc: wideDoc},
+	{Name: "wide/attribute-predicate-indexed", Expr: `//item[@category='garden']`, Doc: wideDoc, Index: "category"},
+	{Name: "wide/id", Expr: `id('i5000')/name`, Doc: wideDoc},
+	{Name: "wide/id-indexed", Expr: `id('i5000')/name`, Doc: wideDoc, Index: "id"},
+	{Name: "wide/first", Expr: `/catalog/item[1]`, Doc: wideDoc},
+	{Name: "wide/last", Expr: `/catalog/item[last()]`, Doc: wideDoc},
+	{Name: "wide/numeric-predicate", Expr: `//item[price > 150 and @stock < 10]`, Doc: wideDoc},
+	{Name: "wide/string-predicate", Expr: `//item[contains(name, 'echo')]`, Doc: wideDoc},
+	{Name: "wide/count", Expr: `count(//item[tags/tag = 'kilo'])`, Doc: wideDoc},
+	{Name: "wide/sum", Expr: `sum(//price)`, Doc: wideDoc},
+	{Name: "wide/union", Expr: `//name | //price`, Doc: wideDoc},
+	{Name: "wide/following-sibling", Expr: `/catalog/item[@id='i10']/following-sibling::item[1]`, Doc: wideDoc},
+	{Name: "wide/translate", Expr: `//name[translate(., 'abcdefghijklmnopqrstuvwxyz', 'ABCDEFGHIJKLMNOPQRSTUVWXYZ') = 'ALPHA BRAVO']`, Doc: wideDoc},
+	{Name: "deep/descendant", Expr: `//section[@level='900']/title`, Doc: deepDoc},
+	{Name: "deep/ancestor", Expr: `//title[. = 'Section 1000']/ancestor::section`, Doc: deepDoc},
+	{Name: "deep/preceding", Expr: `count(//section[@level='500']/preceding::p)`, Doc: deepDoc},
+	{Name: "deep/nested-predicate", Expr: `//section[section/section[@level mod 100 = 0]]`, Doc: deepDoc},
+	{Name: "deep/normalize-space", Expr: `count(//p[normalize-space(.) = .])`, Doc: deepDoc},
+	{Name: "attrs/all", Expr: `count(//record/@*)`, Doc: attrsDoc},
+	{Name: "attrs/predicate", Expr: `//record[@a7 = '42']`, Doc: attrsDoc},
+	{Name: "attrs/predicate-indexed", Expr: `//record[@a7 = '42']`, Doc: attrsDoc, Index: "a7"},
+	{Name: "attrs/wildcard", Expr: `//record[@* = 'lima']`, Doc: attrsDoc},
+	{Name: "attrs/last-attribute", Expr: `//record[@a19 > 90]/@id`, Doc: attrsDoc},
+}
@@ -2,7 +2,7 @@ package xpath
 
 import (
 	"errors"
-	"fmt"
+	"math"
 )
 
 type flag int
@@ -13,12 +13,16 @@ var flagsEnum = struct {
 	PosFilter flag
 	Filter    flag
 	Condition flag
+	// NoPosFilter is set with Filter when the predicate does not depend
+	// on the position of the nodes.
+	NoPosFilter flag
 }{
-	None:      0,
-	SmartDesc: 1,
-	PosFilter: 2,
-	Filter:    4,
-	Condition: 8,
+	None:        0,
+	SmartDesc:   1,
+	PosFilter:   2,
+	Filter:      4,
+	Condition:   8,
+	NoPosFilter: 16,
 }
 
 type builderProp int
@@ -41,21 +45,50 @@ var builderProps = struct {
 type builder struct {
 	parseDepth int
 	firstInput query
+
+	// plan records the queries for Expr.Plan if not nil, with args
+	// collecting the queries built for the node being processed.
+	plan *planInfo
+	args *[]query
+
+	// decl are the variables and functions an expression checked by
+	// Validate may refer to, if not nil.
+	decl *ValidateOptions
+
+	// namespaces are those of the expression, for the prefixes of the
+	// extension functions.
+	namespaces map[string]string
+
+	// compat is the CompileOptions.Compat of the expression.
+	compat Compat
+	// compareDates is CompileOptions.CompareDates.
+	compareDates bool
 }
 
 // axisPredicate creates a predicate to predicating for this axis node.
+// The node test is copied, so that the query does not keep the parse tree
+// alive.
 func axisPredicate(root *axisNode) func(NodeNavigator) bool {
-	nametest := root.LocalName != "" || root.Prefix != ""
+	var (
+		typeTest        = root.typeTest
+		name            = root.LocalName
+		prefix          = root.Prefix
+		namespaceURI    = root.namespaceURI
+		hasNamespaceURI = root.hasNamespaceURI
+		nametest        = name != "" || prefix != ""
+	)
 	predicate := func(n NodeNavigator) bool {
-		if root.typeTest == n.NodeType() || root.typeTest == allNode {
+		if typeTest == n.NodeType() || typeTest == allNode {
 			if nametest {
 				type namespaceURL interface {
 					NamespaceURL() string
 				}
-				if ns, ok := n.(namespaceURL); ok && root.hasNamespaceURI {
-					return root.LocalName == n.LocalName() && root.namespaceURI == ns.NamespaceURL()
+				// An empty local name is the prefix:* wildcard.
+				localName := name == "" || name == n.LocalName()
+				if ns, ok := n.(namespaceURL); ok && hasNamespaceURI {
+					return localName && namespaceURI == ns.NamespaceURL()
 				}
-				if root.LocalName == n.LocalName() && root.Prefix == n.Prefix() {
+				if localName && prefix == n.Prefix() {
 					return true
 				}
 			} else {
@@ -68,6 +101,11 @@ func axisPredicate(root *axisNode) func(NodeNavigator) bool {
 	return predicate
 }
 
+// isNodeTest reports whether the axis step has the node() node test.
+func isNodeTest(root *axisNode) bool {
+	return root.typeTest == allNode && root.LocalName == "" && root.Prefix == ""
+}
+
 // processAxis processes a query for the XPath axis node.
 func (b *builder) processAxis(root *axisNode, flags flag, props *builderProp) (query, error) {
 	var (
@@ -83,9 +121,11 @@ func (b *builder) processAxis(root *axisNode, flags flag, props *builderProp) (q
 		*props = builderProps.None
 	} else {
 		inputFlags := flagsEnum.None
-		if (flags & flagsEnum.Filter) == 0 {
+		// descendant-or-self::node()/child::x is descendant::x, unless a
+		// predicate on the child step depends on the position.
+		if (flags&flagsEnum.Filter) == 0 || (flags&flagsEnum.NoPosFilter) != 0 {
 			if root.AxisType == "child" && (root.Input.Type() == nodeAxis) {
-				if input := root.Input.(*axisNode); input.AxisType == "descendant-or-self" {
+				if input := root.Input.(*axisNode); input.AxisType == "descendant-or-self" && isNodeTest(input) {
 					var qyGrandInput query
 					if input.Input != nil {
 						qyGrandInput, err = b.processNode(input.Input, flagsEnum.SmartDesc, props)
@@ -96,10 +136,13 @@ func (b *builder) processAxis(root *axisNode, flags flag, props *builderProp) (q
 						qyGrandInput = &contextQuery{}
 					}
 					qyOutput = &descendantQuery{name: root.LocalName, Input: qyGrandInput, Predicate: predicate, Self: false}
+					b.plan.note(qyOutput, "collapsed from descendant-or-self::node()/child::")
 					*props |= builderProps.NonFlat
 					return qyOutput, nil
 				}
 			}
+		}
+		if (flags & flagsEnum.Filter) == 0 {
 			if root.AxisType == "descendant" || root.AxisType == "descendant-or-self" {
 				inputFlags |= flagsEnum.SmartDesc
 			}
@@ -111,18 +154,24 @@ func (b *builder) processAxis(root *axisNode, flags flag, props *builderProp) (q
 		}
 	}
 
+	// Whether a predicate on the step depends on the positions.
+	positional := (flags&flagsEnum.Filter) != 0 && (flags&flagsEnum.NoPosFilter) == 0
 	switch root.AxisType {
 	case "ancestor":
-		qyOutput = &ancestorQuery{name: root.LocalName, Input: qyInput, Predicate: predicate}
+		qyOutput = &ancestorQuery{name: root.LocalName, Input: qyInput, Predicate: predicate, Repeat: positional}
 		*props |= builderProps.NonFlat
 	case "ancestor-or-self":
-		qyOutput = &ancestorQuery{name: root.LocalName, Input: qyInput, Predicate: predicate, Self: true}
+		qyOutput = &ancestorQuery{name: root.LocalName, Input: qyInput, Predicate: predicate, Self: true, Repeat: positional}
 		*props |= builderProps.NonFlat
 	case "attribute":
 		qyOutput = &attributeQuery{name: root.LocalName, Input: qyInput, Predicate: predicate}
 	case "child":
 		if (*props & builderProps.NonFlat) == 0 {
 			qyOutput = &childQuery{name: root.LocalName, Input: qyInput, Predicate: predicate}
+		} else if o := orderOf(qyInput); o.sorted && !o.disjoint && ((flags&flagsEnum.Filter) == 0 || (flags&flagsEnum.NoPosFilter) != 0) {
+			// Without positional predicates, as the positions of the
+			// children of nested nodes would not follow each other.
+			qyOutput = &orderedChildQuery{name: root.LocalName, Input: qyInput, Predicate: predicate}
 		} else {
 			qyOutput = &cachedChildQuery{name: root.LocalName, Input: qyInput, Predicate: predicate}
 		}
@@ -146,7 +195,29 @@ func (b *builder) processAxis(root *axisNode, flags flag, props *builderProp) (q
 	case "following-sibling":
 		qyOutput = &followingQuery{Input: qyInput, Predicate: predicate, Sibling: true}
 	case "parent":
-		qyOutput = &parentQuery{Input: qyInput, Predicate: predicate}
+		// The nodes with a child of the step are selected rather than the
+		// parent of each child, see hasChildQuery.
+		step := "self::node()"
+		switch in := qyInput.(type) {
+		case *childQuery:
+			qyOutput = &hasChildQuery{Input: in.Input, Child: in.Predicate, Predicate: predicate}
+		case *cachedChildQuery:
+			qyOutput = &hasChildQuery{Input: in.Input, Child: in.Predicate, Predicate: predicate}
+		case *orderedChildQuery:
+			qyOutput = &hasChildQuery{Input: in.Input, Child: in.Predicate, Predicate: predicate}
+		case *descendantQuery:
+			if !in.Self {
+				qyOutput = &hasChildQuery{Descendants: true, Input: in.Input, Child: in.Predicate, Predicate: predicate}
+				step = "descendant-or-self::node()"
+			}
+		}
+		if qyOutput != nil {
+			if b.plan != nil {
+				b.plan.note(qyOutput, "selects "+step+"[child::"+b.plan.labels[qyInput]+"]")
+			}
+		} else {
+			qyOutput = &parentQuery{Input: qyInput, Predicate: predicate}
+		}
 	case "preceding":
 		qyOutput = &precedingQuery{Input: qyInput, Predicate: predicate}
 		*props |= builderProps.NonFlat
@@ -157,7 +228,7 @@ func (b *builder) processAxis(root *axisNode, flags flag, props *builderProp) (q
 	case "namespace":
 		// haha,what will you do someting??
 	default:
-		err = fmt.Errorf("unknown axe type: %s", root.AxisType)
+		err = newError(ErrSyntax, nil, "unknown axe type: %s", root.AxisType)
 		return nil, err
 	}
 	return qyOutput, nil
@@ -174,7 +245,14 @@ func canBeNumber(q query) bool {
 func (b *builder) processFilter(root *filterNode, flags flag, props *builderProp) (query, error) {
 	first := (flags & flagsEnum.Filter) == 0
 
-	qyInput, err := b.processNode(root.Input, (flags | flagsEnum.Filter), props)
+	// The step may only be collapsed if none of its predicates depends on
+	// the position. The nodes its predicates reject may have descendants
+	// that pass them: the nested nodes are not skipped.
+	inputFlags := (flags | flagsEnum.Filter) &^ (flagsEnum.NoPosFilter | flagsEnum.SmartDesc)
+	if (first || (flags&flagsEnum.NoPosFilter) != 0) && !positionalPredicate(root.Condition) {
+		inputFlags |= flagsEnum.NoPosFilter
+	}
+	qyInput, err := b.processNode(root.Input, inputFlags, props)
 	if err != nil {
 		return nil, err
 	}
@@ -261,6 +339,11 @@ func (b *builder) processFilter(root *filterNode, flags flag, props *builderProp
 					parent = axisQuery.Input
 					axisQuery.Input = rootQuery
 				}
+			case *hasChildQuery:
+				if _, ok := axisQuery.Input.(*contextQuery); !ok {
+					parent = axisQuery.Input
+					axisQuery.Input = rootQuery
+				}
 			case *selfQuery:
 				if _, ok := axisQuery.Input.(*contextQuery); !ok {
 					parent = axisQuery.Input
@@ -278,7 +361,10 @@ func (b *builder) processFilter(root *filterNode, flags flag, props *builderProp
 				}
 			}
 			b.firstInput = nil
-			child := &filterQuery{Input: qyInput, Predicate: cond, NoPosition: false}
+			var child query = &filterQuery{Input: qyInput, Predicate: cond, NoPosition: false, MaxPosition: maxPosition(root.Condition), Position: literalPosition(root.Condition)}
+			if from, to, ok := lastWindow(root.Condition); ok && lastInput(qyInput) {
+				child = &lastQuery{from: from, to: to, Input: qyInput}
+			}
 			if parent != nil {
 				return &mergeQuery{Input: parent, Child: child}, nil
 			}
@@ -287,113 +373,394 @@ func (b *builder) processFilter(root *filterNode, flags flag, props *builderProp
 		b.firstInput = nil
 	}
 
+	if from, to, ok := lastWindow(root.Condition); ok && lastInput(qyInput) {
+		return &lastQuery{from: from, to: to, Input: qyInput}, nil
+	}
 	resultQuery := &filterQuery{
-		Input:      qyInput,
-		Predicate:  cond,
-		NoPosition: (propsCond & builderProps.HasPosition) == 0,
+		Input:       qyInput,
+		Predicate:   cond,
+		NoPosition:  (propsCond & builderProps.HasPosition) == 0,
+		MaxPosition: maxPosition(root.Condition),
+		Position:    literalPosition(root.Condition),
 	}
 	return resultQuery, nil
 }
 
+// literalPosition returns N for the predicate condition [N], which only
+// matches the node at position N, or 0 for any other condition.
+func literalPosition(cond node) int {
+	if _, ok := cond.(*operandNode); !ok {
+		return 0
+	}
+	return maxPosition(cond)
+}
+
+// maxPosition returns the largest position that can satisfy the predicate
+// condition, for conditions like [3] or [position() <= 3], or 0 if there
+// is no such bound.
+func maxPosition(cond node) int {
+	number := func(n node) (float64, bool) {
+		if n, ok := n.(*operandNode); ok {
+			v, ok := n.Val.(float64)
+			return v, ok
+		}
+		return 0, false
+	}
+	isPosition := func(n node) bool {
+		f, ok := n.(*functionNode)
+		return ok && f.FuncName == "position" && f.Prefix == "" && len(f.Args) == 0
+	}
+
+	var bound float64
+	switch n := cond.(type) {
+	case *operandNode:
+		v, ok := number(n)
+		if !ok || v != math.Floor(v) {
+			return 0
+		}
+		bound = v
+	case *operatorNode:
+		op, left, right := n.Op, n.Left, n.Right
+		if !isPosition(left) {
+			// N >= position() is position() <= N.
+			left, right = right, left
+			switch op {
+			case ">=":
+				op = "<="
+			case ">":
+				op = "<"
+			}
+		}
+		v, ok := number(right)
+		if !ok || !isPosition(left) {
+			return 0
+		}
+		switch op {
+		case "=":
+			if v != math.Floor(v) {
+				return 0
+			}
+			bound = v
+		case "<=":
+			bound = math.Floor(v)
+		case "<":
+			bound = math.Ceil(v) - 1
+		default:
+			return 0
+		}
+	default:
+		return 0
+	}
+	if bound < 1 || bound > math.MaxInt32 {
+		return 0
+	}
+	return int(bound)
+}
+
+// lastWindow returns the range of last() - position() that the predicate
+// condition selects, for conditions like [last()], [last() - 1] or
+// [position() > last() - 3], or ok false for any other condition.
+func lastWindow(cond node) (from, to int, ok bool) {
+	isFunc := func(n node, name string) bool {
+		f, ok := n.(*functionNode)
+		return ok && f.FuncName == name && f.Prefix == "" && len(f.Args) == 0
+	}
+	// offset returns N for last() - N.
+	offset := func(n node) (int, bool) {
+		if isFunc(n, "last") {
+			return 0, true
+		}
+		op, ok := n.(*operatorNode)
+		if !ok || op.Op != "-" || !isFunc(op.Left, "last") {
+			return 0, false
+		}
+		v, ok := op.Right.(*operandNode)
+		if !ok {
+			return 0, false
+		}
+		f, ok := v.Val.(float64)
+		if !ok || f != math.Floor(f) || f < 0 || f > math.MaxInt32 {
+			return 0, false
+		}
+		return int(f), true
+	}
+
+	if n, ok := offset(cond); ok {
+		return n, n, true
+	}
+	n, ok := cond.(*operatorNode)
+	if !ok {
+		return 0, 0, false
+	}
+	op, left, right := n.Op, n.Left, n.Right
+	if !isFunc(left, "position") {
+		// last() - N < position() is position() > last() - N.
+		left, right = right, left
+		switch op {
+		case "<":
+			op = ">"
+		case "<=":
+			op = ">="
+		}
+	}
+	k, ok := offset(right)
+	if !ok || !isFunc(left, "position") {
+		return 0, 0, false
+	}
+	switch op {
+	case "=":
+		return k, k, true
+	case ">=":
+		return 0, k, true
+	case ">":
+		if k > 0 {
+			return 0, k - 1, true
+		}
+	}
+	return 0, 0, false
+}
+
+// lastInput reports whether the positions of q restart at 1 for each
+// context, as a lastQuery needs to find where a context ends.
+func lastInput(q query) bool {
+	switch q.(type) {
+	case *childQuery, *cachedChildQuery, *descendantQuery, *attributeQuery, *ancestorQuery, *followingQuery, *precedingQuery,
+		*parentQuery, *hasChildQuery, *selfQuery, *filterQuery, *groupQuery, *lastQuery:
+		return true
+	}
+	return false
+}
+
+// positionalPredicate reports whether the predicate condition may depend
+// on the context position or size, because it can be a number or it calls
+// position() or last() outside of a nested predicate.
+func positionalPredicate(cond node) bool {
+	switch n := cond.(type) {
+	case *operandNode:
+		_, ok := n.Val.(float64)
+		return ok
+	case *operatorNode:
+		switch n.Op {
+		case "=", "!=", "<", "<=", ">", ">=", "and", "or", "|":
+			return hasPositionCall(n.Left) || hasPositionCall(n.Right)
+		}
+		return true
+	case *functionNode:
+		switch n.FuncName {
+		case "true", "false", "not", "boolean", "contains", "starts-with", "ends-with", "compare",
+			"matches", "string", "concat", "normalize-space", "lower-case", "substring",
+			"substring-before", "substring-after", "translate", "replace", "name",
+			"local-name", "namespace-uri", "string-join", "reverse":
+			if n.Prefix != "" {
+				return true
+			}
+			for _, arg := range n.Args {
+				if hasPositionCall(arg) {
+					return true
+				}
+			}
+			return false
+		}
+		return true
+	case *groupNode:
+		return positionalPredicate(n.Input)
+	case *axisNode, *filterNode, *rootNode:
+		return false
+	}
+	return true
+}
+
+// hasPositionCall reports whether the expression calls position() or
+// last() for the context it is evaluated in.
+func hasPositionCall(n node) bool {
+	switch n := n.(type) {
+	case *functionNode:
+		if n.FuncName == "position" || n.FuncName == "last" {
+			return true
+		}
+		for _, arg := range n.Args {
+			if hasPositionCall(arg) {
+				return true
+			}
+		}
+	case *operatorNode:
+		return hasPositionCall(n.Left) || (n.Right != nil && hasPositionCall(n.Right))
+	case *groupNode:
+		return hasPositionCall(n.Input)
+	}
+	return false
+}
+
+// isContextItem reports whether n is the `.` expression.
+func isContextItem(n node) bool {
+	a, ok := n.(*axisNode)
+	return ok && a.AxisType == "self" && a.Input == nil && isNodeTest(a)
+}
+
+// processStringArg builds the function argument n, which is only used for
+// its string value. The context item `.` is built as a contextValueQuery so
+// that its string value is computed once per predicate evaluation.
+func (b *builder) processStringArg(n node, props *builderProp) (query, error) {
+	if isContextItem(n) {
+		*props = builderProps.None
+		q := &contextValueQuery{}
+		b.plan.add(q, n, b.args)
+		return q, nil
+	}
+	return b.processArg(n, props, false)
+}
+
+// processCollationArg builds the collation argument i of the call root,
+// if it has one.
+func (b *builder) processCollationArg(root *functionNode, i int, props *builderProp) (*collationArg, error) {
+	if i >= len(root.Args) {
+		return nil, nil
+	}
+	arg, err := b.processArg(root.Args[i], props, false)
+	if err != nil {
+		return nil, err
+	}
+	return newCollationArg(arg)
+}
+
+// processArg builds the function argument n. If it is a node-set, its
+// nodes are selected in document order without duplicates, or only
+// without duplicates if unique, for the functions that do not depend on
+// their order.
+func (b *builder) processArg(n node, props *builderProp, unique bool) (query, error) {
+	q, err := b.processNode(n, flagsEnum.None, props)
+	if err != nil {
+		return nil, err
+	}
+	return documentOrder(q, unique), nil
+}
+
 // processFunctionNode processes query for the XPath function node.
 func (b *builder) processFunction(root *functionNode, props *builderProp) (query, error) {
 	// Reset builder props
 	*props = builderProps.None
+	if uri, ok := b.namespaces[root.Prefix]; ok && root.Prefix != "" && extensionFunctions[uri] != nil {
+		return b.processExtension(root, uri, props)
+	}
+	if err := checkCall(root); err != nil {
+		return nil, err
+	}
 
 	var qyOutput query
 	switch root.FuncName {
 	case "lower-case":
-		arg, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		arg, err := b.processStringArg(root.Args[0], props)
 		if err != nil {
 			return nil, err
 		}
 		qyOutput = &functionQuery{Func: lowerCaseFunc(arg)}
 	case "starts-with":
-		arg1, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		arg1, err := b.processStringArg(root.Args[0], props)
 		if err != nil {
 			return nil, err
 		}
-		arg2, err := b.processNode(root.Args[1], flagsEnum.None, props)
+		arg2, err := b.processStringArg(root.Args[1], props)
 		if err != nil {
 			return nil, err
 		}
-		qyOutput = &functionQuery{Func: startwithFunc(arg1, arg2)}
+		coll, err := b.processCollationArg(root, 2, props)
+		if err != nil {
+			return nil, err
+		}
+		qyOutput = &functionQuery{Func: startwithFunc(arg1, arg2, coll)}
 	case "ends-with":
-		arg1, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		arg1, err := b.processStringArg(root.Args[0], props)
 		if err != nil {
 			return nil, err
 		}
-		arg2, err := b.processNode(root.Args[1], flagsEnum.None, props)
+		arg2, err := b.processStringArg(root.Args[1], props)
 		if err != nil {
 			return nil, err
 		}
-		qyOutput = &functionQuery{Func: endwithFunc(arg1, arg2)}
+		coll, err := b.processCollationArg(root, 2, props)
+		if err != nil {
+			return nil, err
+		}
+		qyOutput = &functionQuery{Func: endwithFunc(arg1, arg2, coll)}
 	case "contains":
-		arg1, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		arg1, err := b.processStringArg(root.Args[0], props)
 		if err != nil {
 			return nil, err
 		}
-		arg2, err := b.processNode(root.Args[1], flagsEnum.None, props)
+		arg2, err := b.processStringArg(root.Args[1], props)
 		if err != nil {
 			return nil, err
 		}
-		qyOutput = &functionQuery{Func: containsFunc(arg1, arg2)}
-	case "matches":
-		//matches(string , pattern)
-		if len(root.Args) != 2 {
-			return nil, errors.New("xpath: matches function must have two parameters")
+		coll, err := b.processCollationArg(root, 2, props)
+		if err != nil {
+			return nil, err
+		}
+		qyOutput = &functionQuery{Func: containsFunc(arg1, arg2, coll)}
+	case "compare":
+		arg1, err := b.processStringArg(root.Args[0], props)
+		if err != nil {
+			return nil, err
 		}
+		arg2, err := b.processStringArg(root.Args[1], props)
+		if err != nil {
+			return nil, err
+		}
+		coll, err := b.processCollationArg(root, 2, props)
+		if err != nil {
+			return nil, err
+		}
+		qyOutput = &functionQuery{Func: compareFunc(arg1, arg2, coll)}
+	case "matches":
+		//matches(string , pattern [, flags])
 		var (
-			arg1, arg2 query
-			err        error
+			arg1, arg2, arg3 query
+			err              error
 		)
-		if arg1, err = b.processNode(root.Args[0], flagsEnum.None, props); err != nil {
+		if arg1, err = b.processStringArg(root.Args[0], props); err != nil {
 			return nil, err
 		}
-		if arg2, err = b.processNode(root.Args[1], flagsEnum.None, props); err != nil {
+		if arg2, err = b.processArg(root.Args[1], props, false); err != nil {
 			return nil, err
 		}
-		// Issue #92, testing the regular expression before.
-		if q, ok := arg2.(*constantQuery); ok {
-			if _, err = getRegexp(q.Val.(string)); err != nil {
-				return nil, fmt.Errorf("matches() got error. %v", err)
+		if len(root.Args) == 3 {
+			if arg3, err = b.processArg(root.Args[2], props, false); err != nil {
+				return nil, err
 			}
 		}
-		qyOutput = &functionQuery{Func: matchesFunc(arg1, arg2)}
+		// Issue #92, testing the regular expression before.
+		patterns, err := newRegexpArg(arg2, arg3, b.compat.XSDRegexps)
+		if err != nil {
+			return nil, newError(ErrInvalidRegexp, err, "matches() got error. %v", err)
+		}
+		qyOutput = &functionQuery{Func: matchesFunc(arg1, arg2, arg3, patterns)}
 	case "substring":
 		//substring( string , start [, length] )
-		if len(root.Args) < 2 {
-			return nil, errors.New("xpath: substring function must have at least two parameter")
-		}
 		var (
 			arg1, arg2, arg3 query
 			err              error
 		)
-		if arg1, err = b.processNode(root.Args[0], flagsEnum.None, props); err != nil {
+		if arg1, err = b.processStringArg(root.Args[0], props); err != nil {
 			return nil, err
 		}
-		if arg2, err = b.processNode(root.Args[1], flagsEnum.None, props); err != nil {
+		if arg2, err = b.processArg(root.Args[1], props, false); err != nil {
 			return nil, err
 		}
 		if len(root.Args) == 3 {
-			if arg3, err = b.processNode(root.Args[2], flagsEnum.None, props); err != nil {
+			if arg3, err = b.processArg(root.Args[2], props, false); err != nil {
 				return nil, err
 			}
 		}
 		qyOutput = &functionQuery{Func: substringFunc(arg1, arg2, arg3)}
 	case "substring-before", "substring-after":
 		//substring-xxxx( haystack, needle )
-		if len(root.Args) != 2 {
-			return nil, errors.New("xpath: substring-before function must have two parameters")
-		}
 		var (
 			arg1, arg2 query
 			err        error
 		)
-		if arg1, err = b.processNode(root.Args[0], flagsEnum.None, props); err != nil {
+		if arg1, err = b.processStringArg(root.Args[0], props); err != nil {
 			return nil, err
 		}
-		if arg2, err = b.processNode(root.Args[1], flagsEnum.None, props); err != nil {
+		if arg2, err = b.processStringArg(root.Args[1], props); err != nil {
 			return nil, err
 		}
 		qyOutput = &functionQuery{
@@ -401,10 +768,7 @@ func (b *builder) processFunction(root *functionNode, props *builderProp) (query
 		}
 	case "string-length":
 		// string-length( [string] )
-		if len(root.Args) < 1 {
-			return nil, errors.New("xpath: string-length function must have at least one parameter")
-		}
-		arg1, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		arg1, err := b.processStringArg(root.Args[0], props)
 		if err != nil {
 			return nil, err
 		}
@@ -416,68 +780,68 @@ func (b *builder) processFunction(root *functionNode, props *builderProp) (query
 		} else {
 			arg = newAxisNode("self", allNode, "", "", "", nil)
 		}
-		arg1, err := b.processNode(arg, flagsEnum.None, props)
+		arg1, err := b.processStringArg(arg, props)
 		if err != nil {
 			return nil, err
 		}
 		qyOutput = &functionQuery{Func: normalizespaceFunc(arg1)}
 	case "replace":
-		//replace( string , string, string )
-		if len(root.Args) != 3 {
-			return nil, errors.New("xpath: replace function must have three parameters")
-		}
+		//replace( string , string, string [, flags] )
 		var (
-			arg1, arg2, arg3 query
-			err              error
+			arg1, arg2, arg3, arg4 query
+			err                    error
 		)
-		if arg1, err = b.processNode(root.Args[0], flagsEnum.None, props); err != nil {
+		if arg1, err = b.processStringArg(root.Args[0], props); err != nil {
 			return nil, err
 		}
-		if arg2, err = b.processNode(root.Args[1], flagsEnum.None, props); err != nil {
+		if arg2, err = b.processArg(root.Args[1], props, false); err != nil {
 			return nil, err
 		}
-		if arg3, err = b.processNode(root.Args[2], flagsEnum.None, props); err != nil {
+		if arg3, err = b.processArg(root.Args[2], props, false); err != nil {
 			return nil, err
 		}
-		qyOutput = &functionQuery{Func: replaceFunc(arg1, arg2, arg3)}
+		if len(root.Args) == 4 {
+			if arg4, err = b.processArg(root.Args[3], props, false); err != nil {
+				return nil, err
+			}
+		}
+		patterns, err := newRegexpArg(arg2, arg4, b.compat.XSDRegexps)
+		if err != nil {
+			return nil, newError(ErrInvalidRegexp, err, "replace() got error. %v", err)
+		}
+		if b.compat.XSDRegexps && patterns.literal != nil && patterns.literal.MatchString("") {
+			return nil, newError(ErrInvalidRegexp, nil, "replace() got error. the pattern matches the empty string")
+		}
+		qyOutput = &functionQuery{Func: replaceFunc(arg1, arg2, arg3, arg4, patterns)}
 	case "translate":
 		//translate( string , string, string )
-		if len(root.Args) != 3 {
-			return nil, errors.New("xpath: translate function must have three parameters")
-		}
 		var (
 			arg1, arg2, arg3 query
 			err              error
 		)
-		if arg1, err = b.processNode(root.Args[0], flagsEnum.None, props); err != nil {
+		if arg1, err = b.processStringArg(root.Args[0], props); err != nil {
 			return nil, err
 		}
-		if arg2, err = b.processNode(root.Args[1], flagsEnum.None, props); err != nil {
+		if arg2, err = b.processArg(root.Args[1], props, false); err != nil {
 			return nil, err
 		}
-		if arg3, err = b.processNode(root.Args[2], flagsEnum.None, props); err != nil {
+		if arg3, err = b.processArg(root.Args[2], props, false); err != nil {
 			return nil, err
 		}
 		qyOutput = &functionQuery{Func: translateFunc(arg1, arg2, arg3)}
 	case "not":
-		if len(root.Args) == 0 {
-			return nil, errors.New("xpath: not function must have at least one parameter")
-		}
 		argQuery, err := b.processNode(root.Args[0], flagsEnum.None, props)
 		if err != nil {
 			return nil, err
 		}
 		qyOutput = &functionQuery{Func: notFunc(argQuery)}
 	case "name", "local-name", "namespace-uri":
-		if len(root.Args) > 1 {
-			return nil, fmt.Errorf("xpath: %s function must have at most one parameter", root.FuncName)
-		}
 		var (
 			arg query
 			err error
 		)
 		if len(root.Args) == 1 {
-			arg, err = b.processNode(root.Args[0], flagsEnum.None, props)
+			arg, err = b.processArg(root.Args[0], props, false)
 			if err != nil {
 				return nil, err
 			}
@@ -505,11 +869,14 @@ func (b *builder) processFunction(root *functionNode, props *builderProp) (query
 		*props |= builderProps.HasPosition
 	case "boolean", "number", "string":
 		var inp query
-		if len(root.Args) > 1 {
-			return nil, fmt.Errorf("xpath: %s function must have at most one parameter", root.FuncName)
-		}
 		if len(root.Args) == 1 {
-			argQuery, err := b.processNode(root.Args[0], flagsEnum.None, props)
+			process := b.processStringArg
+			if root.FuncName == "boolean" {
+				process = func(n node, props *builderProp) (query, error) {
+					return b.processNode(n, flagsEnum.None, props)
+				}
+			}
+			argQuery, err := process(root.Args[0], props)
 			if err != nil {
 				return nil, err
 			}
@@ -524,28 +891,19 @@ func (b *builder) processFunction(root *functionNode, props *builderProp) (query
 			qyOutput = &functionQuery{Func: numberFunc(inp)}
 		}
 	case "count":
-		if len(root.Args) == 0 {
-			return nil, fmt.Errorf("xpath: count(node-sets) function must with have parameters node-sets")
-		}
-		argQuery, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		argQuery, err := b.processArg(root.Args[0], props, true)
 		if err != nil {
 			return nil, err
 		}
 		qyOutput = &functionQuery{Func: countFunc(argQuery)}
 	case "sum":
-		if len(root.Args) == 0 {
-			return nil, fmt.Errorf("xpath: sum(node-sets) function must with have parameters node-sets")
-		}
-		argQuery, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		argQuery, err := b.processArg(root.Args[0], props, true)
 		if err != nil {
 			return nil, err
 		}
 		qyOutput = &functionQuery{Func: sumFunc(argQuery)}
 	case "ceiling", "floor", "round":
-		if len(root.Args) == 0 {
-			return nil, fmt.Errorf("xpath: ceiling(node-sets) function must with have parameters node-sets")
-		}
-		argQuery, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		argQuery, err := b.processArg(root.Args[0], props, false)
 		if err != nil {
 			return nil, err
 		}
@@ -558,12 +916,9 @@ func (b *builder) processFunction(root *functionNode, props *builderProp) (query
 			qyOutput = &functionQuery{Func: roundFunc(argQuery)}
 		}
 	case "concat":
-		if len(root.Args) < 2 {
-			return nil, fmt.Errorf("xpath: concat() must have at least two arguments")
-		}
 		var args []query
 		for _, v := range root.Args {
-			q, err := b.processNode(v, flagsEnum.None, props)
+			q, err := b.processStringArg(v, props)
 			if err != nil {
 				return nil, err
 			}
@@ -571,29 +926,51 @@ func (b *builder) processFunction(root *functionNode, props *builderProp) (query
 		}
 		qyOutput = &functionQuery{Func: concatFunc(args...)}
 	case "reverse":
-		if len(root.Args) == 0 {
-			return nil, fmt.Errorf("xpath: reverse(node-sets) function must with have parameters node-sets")
-		}
-		argQuery, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		argQuery, err := b.processArg(root.Args[0], props, false)
 		if err != nil {
 			return nil, err
 		}
 		qyOutput = &transformFunctionQuery{Input: argQuery, Func: reverseFunc}
-	case "string-join":
-		if len(root.Args) != 2 {
-			return nil, fmt.Errorf("xpath: string-join(node-sets, separator) function requires node-set and argument")
+	case "id":
+		argQuery, err := b.processArg(root.Args[0], props, true)
+		if err != nil {
+			return nil, err
 		}
-		input, err := b.processNode(root.Args[0], flagsEnum.None, props)
+		qyOutput = &transformFunctionQuery{Input: argQuery, Func: idFunc}
+	case "doc", "document":
+		argQuery, err := b.processArg(root.Args[0], props, false)
 		if err != nil {
 			return nil, err
 		}
-		arg1, err := b.processNode(root.Args[1], flagsEnum.None, props)
+		if root.FuncName == "doc" {
+			qyOutput = &transformFunctionQuery{Input: argQuery, Func: docFunc}
+		} else {
+			qyOutput = &transformFunctionQuery{Input: argQuery, Func: documentFunc}
+		}
+	case "unparsed-text":
+		argQuery, err := b.processArg(root.Args[0], props, false)
+		if err != nil {
+			return nil, err
+		}
+		qyOutput = &functionQuery{Func: unparsedTextFunc(argQuery)}
+	case "string-join":
+		input, err := b.processArg(root.Args[0], props, false)
+		if err != nil {
+			return nil, err
+		}
+		arg1, err := b.processArg(root.Args[1], props, false)
 		if err != nil {
 			return nil, err
 		}
 		qyOutput = &functionQuery{Func: stringJoinFunc(input, arg1)}
 	default:
-		return nil, fmt.Errorf("not yet support this function %s()", root.FuncName)
+		name := qualifiedName(root.Prefix, root.FuncName)
+		if b.decl != nil {
+			if f, ok := b.decl.Functions[name]; ok {
+				return b.declaredCall(root, name, f, props)
+			}
+		}
+		return nil, &UnknownFunctionError{Name: name, Suggestion: closestName(root.FuncName, builtinFunctions)}
 	}
 	return qyOutput, nil
 }
@@ -604,11 +981,22 @@ func (b *builder) processOperator(root *operatorNode, props *builderProp) (query
 		rightProp builderProp
 	)
 
-	left, err := b.processNode(root.Left, flagsEnum.None, &leftProp)
+	process := func(n, other node, props *builderProp) (query, error) {
+		// . = "literal" only needs the string value of the context node.
+		if root.Op == "=" || root.Op == "!=" {
+			if o, ok := other.(*operandNode); ok {
+				if _, ok := o.Val.(string); ok {
+					return b.processStringArg(n, props)
+				}
+			}
+		}
+		return b.processNode(n, flagsEnum.None, props)
+	}
+	left, err := process(root.Left, root.Right, &leftProp)
 	if err != nil {
 		return nil, err
 	}
-	right, err := b.processNode(root.Right, flagsEnum.None, &rightProp)
+	right, err := process(root.Right, root.Left, &rightProp)
 	if err != nil {
 		return nil, err
 	}
@@ -647,6 +1035,9 @@ func (b *builder) processOperator(root *operatorNode, props *builderProp) (query
 		case "!=":
 			exprFunc = neFunc
 		}
+		if b.compareDates {
+			exprFunc = dateFunc(root.Op)
+		}
 		qyOutput = &logicalQuery{Left: left, Right: right, Do: exprFunc}
 	case "or", "and":
 		isOr := false
@@ -663,10 +1054,23 @@ func (b *builder) processOperator(root *operatorNode, props *builderProp) (query
 
 func (b *builder) processNode(root node, flags flag, props *builderProp) (q query, err error) {
 	if b.parseDepth = b.parseDepth + 1; b.parseDepth > 1024 {
-		err = errors.New("the xpath expressions is too complex")
+		err = newError(ErrTooComplex, nil, "the xpath expressions is too complex")
 		return
 	}
 	*props = builderProps.None
+	if b.plan != nil {
+		parent, args := b.args, []query{}
+		b.args = &args
+		defer func() {
+			b.args = parent
+			if err == nil {
+				b.plan.add(q, root, parent)
+				if root.Type() == nodeFunction {
+					b.plan.args[q] = args
+				}
+			}
+		}()
+	}
 	switch root.Type() {
 	case nodeConstantOperand:
 		n := root.(*operandNode)
@@ -678,17 +1082,28 @@ func (b *builder) processNode(root node, flags flag, props *builderProp) (q quer
 		b.firstInput = q
 	case nodeFilter:
 		q, err = b.processFilter(root.(*filterNode), flags, props)
+		if err == nil && (flags&flagsEnum.Filter) == 0 {
+			if step, attr, value, ok := attrLookup(root.(*filterNode)); ok {
+				q = &indexQuery{prefix: attr.Prefix, localName: attr.LocalName, value: value, Input: q, Predicate: axisPredicate(step)}
+			}
+		}
 		b.firstInput = q
 	case nodeFunction:
 		q, err = b.processFunction(root.(*functionNode), props)
 	case nodeOperator:
 		q, err = b.processOperator(root.(*operatorNode), props)
+	case nodeVariable:
+		if b.decl != nil && b.decl.declares(root.(*variableNode)) {
+			q = nopQuery{}
+			break
+		}
+		err = newError(ErrUnboundVariable, nil, "undeclared variable in XPath expression: $%s", root)
 	case nodeGroup:
 		q, err = b.processNode(root.(*groupNode).Input, flagsEnum.None, props)
 		if err != nil {
 			return
 		}
-		q = &groupQuery{Input: q}
+		q = &groupQuery{Input: documentOrder(q, false)}
 		if b.firstInput == nil {
 			b.firstInput = q
 		}
@@ -697,8 +1112,58 @@ func (b *builder) processNode(root node, flags flag, props *builderProp) (q quer
 	return
 }
 
-// build builds a specified XPath expressions expr.
-func build(expr string, namespaces map[string]string) (q query, err error) {
+// attrLookup reports whether root selects the elements of the document
+// with an attribute value, as in //name[@attr = "value"], so that they can
+// be looked up in an index. It returns the element step, the attribute
+// step and the value.
+func attrLookup(root *filterNode) (step, attr *axisNode, value string, ok bool) {
+	step, ok = root.Input.(*axisNode)
+	if !ok || step.typeTest != ElementNode {
+		return nil, nil, "", false
+	}
+	switch step.AxisType {
+	case "child":
+		input, ok := step.Input.(*axisNode)
+		if !ok || input.AxisType != "descendant-or-self" || !isNodeTest(input) {
+			return nil, nil, "", false
+		}
+		if _, ok := input.Input.(*rootNode); !ok {
+			return nil, nil, "", false
+		}
+	case "descendant", "descendant-or-self":
+		if _, ok := step.Input.(*rootNode); !ok {
+			return nil, nil, "", false
+		}
+	default:
+		return nil, nil, "", false
+	}
+
+	cond, ok := root.Condition.(*operatorNode)
+	if !ok || cond.Op != "=" {
+		return nil, nil, "", false
+	}
+	for _, c := range [][2]node{{cond.Left, cond.Right}, {cond.Right, cond.Left}} {
+		a, ok := c[0].(*axisNode)
+		if !ok || a.AxisType != "attribute" || a.Input != nil || a.LocalName == "" || a.hasNamespaceURI {
+			continue
+		}
+		if o, ok := c[1].(*operandNode); ok {
+			if s, ok := o.Val.(string); ok {
+				return step, a, s, true
+			}
+		}
+	}
+	return nil, nil, "", false
+}
+
+// build builds a specified XPath expressions expr. If plan is not nil,
+// what is needed to describe the query is recorded in it.
+func build(expr string, opts CompileOptions, plan *planInfo) (q query, err error) {
+	return (&builder{plan: plan}).build(expr, opts)
+}
+
+// build builds the query of expr with b.
+func (b *builder) build(expr string, opts CompileOptions) (q query, err error) {
 	defer func() {
 		if e := recover(); e != nil {
 			switch x := e.(type) {
@@ -711,8 +1176,22 @@ func build(expr string, namespaces map[string]string) (q query, err error) {
 			}
 		}
 	}()
-	root := parse(expr, namespaces)
-	b := &builder{}
+	b.namespaces, b.compat, b.compareDates = opts.Namespaces, opts.Compat, opts.CompareDates
+	// The parse tree is not used once the query is built.
+	arena := getArena()
+	defer putArena(arena)
+	root := optimize(anchorPaths(parse(expr, opts.Namespaces, arena), opts.Anchor), opts)
+	if opts.ReorderPredicates {
+		root = reorderPredicates(root, opts)
+		if b.plan != nil {
+			b.plan.exprNotes = append(b.plan.exprNotes, "predicates reordered by estimated cost")
+		}
+	}
 	props := builderProps.None
-	return b.processNode(root, flagsEnum.None, &props)
+	q, err = b.processNode(root, flagsEnum.None, &props)
+	if err != nil || q == nil {
+		return q, err
+	}
+	// The nodes of a node-set are in document order without duplicates.
+	return documentOrder(q, false), nil
 }
@@ -78,3 +78,80 @@ func getRegexp(pattern string) (*regexp.Regexp, error) {
 	}
 	return exp.(*regexp.Regexp), nil
 }
+
+// maxExprRegexps is the number of dynamic patterns cached by a regexpArg.
+const maxExprRegexps = 64
+
+// regexpArg holds the compiled regular expressions of the pattern argument
+// of a function like matches(), with its flags. A literal pattern with
+// literal flags is compiled once when the expression is built; patterns
+// computed at runtime are kept in a small cache owned by the expression,
+// which is reset when full.
+type regexpArg struct {
+	literal *regexp.Regexp
+	// xsd reads the patterns as XPath regular expressions, see
+	// Compat.XSDRegexps.
+	xsd bool
+
+	mu sync.Mutex
+	m  map[string]*regexp.Regexp
+}
+
+// newRegexpArg returns the regexpArg of the pattern argument arg with the
+// flags argument, which may be nil, or an error if they are literals that
+// are not a valid pattern.
+func newRegexpArg(arg, flags query, xsd bool) (*regexpArg, error) {
+	r := &regexpArg{xsd: xsd}
+	var literalFlags string
+	if flags != nil {
+		q, ok := flags.(*constantQuery)
+		if !ok {
+			return r, nil
+		}
+		if literalFlags, ok = q.Val.(string); !ok {
+			return r, nil
+		}
+	}
+	if q, ok := arg.(*constantQuery); ok {
+		if pattern, ok := q.Val.(string); ok {
+			re, err := r.compile(pattern, literalFlags)
+			if err != nil {
+				return nil, err
+			}
+			r.literal = re
+		}
+	}
+	return r, nil
+}
+
+func (r *regexpArg) get(pattern, flags string) (*regexp.Regexp, error) {
+	if r.literal != nil {
+		return r.literal, nil
+	}
+	key := flags + "/" + pattern
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if re, ok := r.m[key]; ok {
+		return re, nil
+	}
+	re, err := r.compile(pattern, flags)
+	if err != nil {
+		return nil, err
+	}
+	if r.m == nil || len(r.m) >= maxExprRegexps {
+		r.m = make(map[string]*regexp.Regexp)
+	}
+	r.m[key] = re
+	return re, nil
+}
+
+func (r *regexpArg) compile(pattern, flags string) (*regexp.Regexp, error) {
+	if flags == "" && !r.xsd {
+		return getRegexp(pattern)
+	}
+	src, err := regexpSource(pattern, flags, r.xsd)
+	if err != nil {
+		return nil, err
+	}
+	return getRegexp(src)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"regexp"
 	"strconv"
 	"testing"
 )
@@ -63,6 +64,46 @@ func TestLoadingCache(t *testing.T) {
 	assertEqual(t, "artificial error: 21", err.Error())
 }
 
+func TestRegexpArg(t *testing.T) {
+	r, err := newRegexpArg(&constantQuery{Val: "^a+$"}, nil, false)
+	assertNoErr(t, err)
+	assertTrue(t, r.literal != nil)
+	re, err := r.get("ignored", "")
+	assertNoErr(t, err)
+	assertTrue(t, re == r.literal)
+
+	_, err = newRegexpArg(&constantQuery{Val: "[invalid"}, nil, false)
+	assertErr(t, err)
+
+	r, err = newRegexpArg(&contextQuery{}, nil, false)
+	assertNoErr(t, err)
+	re, err = r.get("b+", "")
+	assertNoErr(t, err)
+	again, _ := r.get("b+", "")
+	assertTrue(t, re == again)
+	assertEqual(t, 1, len(r.m))
+	for i := 0; i < maxExprRegexps; i++ {
+		_, err = r.get(strconv.Itoa(i), "")
+		assertNoErr(t, err)
+	}
+	// over capacity, m is reset
+	assertEqual(t, 1, len(r.m))
+	_, err = r.get("[invalid", "")
+	assertErr(t, err)
+
+	// Each distinct dynamic pattern is compiled once per expression.
+	loads := 0
+	saved := RegexpCache
+	defer func() { RegexpCache = saved }()
+	RegexpCache = NewLoadingCache(func(key interface{}) (interface{}, error) {
+		loads++
+		return regexp.Compile(key.(string))
+	}, 0)
+	expr := MustCompile(`count(//book[matches(@category, concat("^", substring(@category, 1, 1)))])`)
+	assertEqual(t, float64(4), expr.Evaluate(createNavigator(book_example)))
+	assertEqual(t, 2, loads) // ^c and ^w
+}
+
 const (
 	benchLoadingCacheRandSeed    = 12345
 	benchLoadingCacheConcurrency = 5
@@ -0,0 +1,111 @@
+package xpath
+
+import "sort"
+
+// Features describes what the engine supports, as returned by
+// Capabilities, so that a program embedding it can check the expressions
+// of its users or adapt to the version of the engine it is built with.
+type Features struct {
+	// Version is the version of XPath the engine implements.
+	Version string
+	// Functions are the supported functions, by name.
+	Functions []string
+	// Extensions are the functions in Functions that are not XPath 1.0
+	// functions, such as lower-case from XPath 2.0.
+	Extensions []string
+	// Signatures are the arguments the functions take, by name, as
+	// checked by Compile.
+	Signatures map[string]FunctionSignature
+	// Axes are the supported axes, by name.
+	Axes []string
+	// NodeTests are the supported node type tests, such as text().
+	NodeTests []string
+	// Operators are the supported operators.
+	Operators []string
+}
+
+// A FunctionSignature is the number of arguments of a function and the
+// types they can have, such as "node-set" or "string".
+type FunctionSignature struct {
+	// MinArgs and MaxArgs are the number of arguments. MaxArgs is -1 if
+	// there is no maximum.
+	MinArgs, MaxArgs int
+	// Params are the types each argument can have, nil for any type. The
+	// last one is that of the arguments after it.
+	Params [][]string
+	// Result is the type of the value, or "value" if it depends on the
+	// arguments.
+	Result string
+}
+
+// xpath10Functions are the functions of XPath 1.0.
+var xpath10Functions = map[string]bool{
+	"boolean":          true,
+	"ceiling":          true,
+	"concat":           true,
+	"contains":         true,
+	"count":            true,
+	"false":            true,
+	"floor":            true,
+	"id":               true,
+	"lang":             true,
+	"last":             true,
+	"local-name":       true,
+	"name":             true,
+	"namespace-uri":    true,
+	"normalize-space":  true,
+	"not":              true,
+	"number":           true,
+	"position":         true,
+	"round":            true,
+	"starts-with":      true,
+	"string":           true,
+	"string-length":    true,
+	"substring":        true,
+	"substring-after":  true,
+	"substring-before": true,
+	"sum":              true,
+	"translate":        true,
+	"true":             true,
+}
+
+// operators are the operators of the parser, by increasing precedence.
+var operators = []string{"or", "and", "=", "!=", "<", "<=", ">", ">=", "+", "-", "*", "div", "mod", "|"}
+
+// Capabilities returns what the engine supports. The lists are sorted,
+// except for the operators, which are by increasing precedence.
+func Capabilities() Features {
+	f := Features{
+		Version:    "1.0",
+		NodeTests:  []string{"comment()", "node()", "processing-instruction()", "text()"},
+		Operators:  append([]string(nil), operators...),
+		Signatures: make(map[string]FunctionSignature, len(functionSignatures)),
+	}
+	for name, sig := range functionSignatures {
+		s := FunctionSignature{MinArgs: sig.min, MaxArgs: sig.max, Result: sig.result.String()}
+		for _, types := range sig.params {
+			var names []string
+			for _, t := range types {
+				names = append(names, t.String())
+			}
+			s.Params = append(s.Params, names)
+		}
+		f.Signatures[name] = s
+	}
+	for name := range builtinFunctions {
+		f.Functions = append(f.Functions, name)
+		if !xpath10Functions[name] {
+			f.Extensions = append(f.Extensions, name)
+		}
+	}
+	for name := range axisNames {
+		// The namespace axis is parsed, but it is not built.
+		if name != "namespace" {
+			f.Axes = append(f.Axes, name)
+		}
+	}
+	sort.Strings(f.Functions)
+	sort.Strings(f.Extensions)
+	sort.Strings(f.Axes)
+	return f
+}
@@ -0,0 +1,44 @@
+package xpath
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	f := Capabilities()
+	assertEqual(t, "1.0", f.Version)
+	assertTrue(t, sort.StringsAreSorted(f.Functions))
+	assertEqual(t, []string{"compare", "doc", "document", "ends-with", "lower-case", "matches", "replace", "reverse", "string-join", "unparsed-text"}, f.Extensions)
+	assertEqual(t, 12, len(f.Axes))
+
+	// The reported functions, axes, node tests and operators compile.
+	for _, name := range f.Functions {
+		_, err := Compile(name + "()")
+		assertFalse(t, errors.Is(err, ErrUnknownFunction))
+		_, ok := f.Signatures[name]
+		assertTrue(t, ok)
+	}
+	assertEqual(t, FunctionSignature{MinArgs: 2, MaxArgs: 3, Params: [][]string{nil, nil, {"string"}}, Result: "boolean"}, f.Signatures["starts-with"])
+	assertEqual(t, FunctionSignature{MinArgs: 1, MaxArgs: 1, Params: [][]string{nil}, Result: "node-set"}, f.Signatures["id"])
+	for _, axis := range f.Axes {
+		_, err := Compile(axis + "::*")
+		assertNoErr(t, err)
+	}
+	for _, test := range f.NodeTests {
+		_, err := Compile("//" + test)
+		assertNoErr(t, err)
+	}
+	for _, op := range f.Operators {
+		_, err := Compile("1 " + op + " 2")
+		if op == "|" {
+			_, err = Compile("a " + op + " b")
+		}
+		assertNoErr(t, err)
+	}
+
+	// The lists are copies.
+	f.Operators[0] = "xor"
+	assertEqual(t, "or", Capabilities().Operators[0])
+}
@@ -0,0 +1,258 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A Warning is a probable mistake in an expression found by Expr.Check.
+// The expression is valid, but it does not do what it seems to. It is also
+// a result of an evaluation that is not the one XPath 1.0 specifies,
+// reported to EvalOptions.Warn.
+type Warning struct {
+	Kind WarningKind
+	// Msg describes the mistake, such as "count() = 1.5 is never true,
+	// count() is a whole number from 0".
+	Msg string
+}
+
+func (w Warning) String() string {
+	return w.Msg
+}
+
+// WarningKind is the kind of mistake a Warning is about.
+type WarningKind int
+
+const (
+	// NeverTrue is a comparison that is false for every value of its
+	// operands, such as count(x) = 1.5 or @a = number('x').
+	NeverTrue WarningKind = iota
+	// StringOfNumber is a call of a string function on a number, such as
+	// string-length(count(x)).
+	StringOfNumber
+	// FalsePredicate is a predicate that selects no node, such as [0] or
+	// [false()].
+	FalsePredicate
+	// NonStandard is a result of an evaluation that another engine would
+	// not give, such as string-length('é'), which counts 2 bytes rather
+	// than 1 character.
+	NonStandard
+)
+
+// Check returns the probable mistakes in the expression, such as a
+// comparison that can never be true, a string function applied to the
+// result of count() or a predicate that is always false, in the order
+// they appear in the expression. The expression is not evaluated, so a
+// mistake that depends on the document is not found.
+func (expr *Expr) Check() (warnings []Warning) {
+	defer func() {
+		// The expression did not compile, see MustCompile.
+		if recover() != nil {
+			warnings = nil
+		}
+	}()
+	// Constants are folded first, so that [1 = 2] is seen as [false()].
+	check(optimize(anchorPaths(parse(expr.s, expr.opts.Namespaces, nil), expr.opts.Anchor), expr.opts), &warnings)
+	return warnings
+}
+
+// staticType is the type of the value of an expression, as far as it is
+// known without evaluating it.
+type staticType int
+
+const (
+	unknownType staticType = iota
+	nodeSetType
+	numberType
+	stringType
+	boolType
+)
+
+// stringFunctions are the functions whose first argument is a string.
+var stringFunctions = map[string]bool{
+	"compare":          true,
+	"contains":         true,
+	"ends-with":        true,
+	"lower-case":       true,
+	"matches":          true,
+	"normalize-space":  true,
+	"replace":          true,
+	"starts-with":      true,
+	"string-length":    true,
+	"substring":        true,
+	"substring-after":  true,
+	"substring-before": true,
+	"translate":        true,
+}
+
+// wholeNumberFunctions are the functions that return a whole number, with
+// the smallest number they return.
+var wholeNumberFunctions = map[string]float64{
+	"compare":       -1,
+	"count":         0,
+	"last":          1,
+	"position":      1,
+	"string-length": 0,
+}
+
+func typeOf(n node) staticType {
+	switch n := n.(type) {
+	case *rootNode, *axisNode, *filterNode:
+		return nodeSetType
+	case *operandNode:
+		if _, ok := n.Val.(string); ok {
+			return stringType
+		}
+		return numberType
+	case *functionNode:
+		if n.Prefix == "" {
+			return functionSignatures[n.FuncName].result
+		}
+	case *operatorNode:
+		switch n.Op {
+		case "|":
+			return nodeSetType
+		case "+", "-", "*", "div", "mod":
+			return numberType
+		}
+		return boolType
+	case *groupNode:
+		return typeOf(n.Input)
+	}
+	return unknownType
+}
+
+// check appends the warnings for n and its sub-expressions to warnings.
+func check(n node, warnings *[]Warning) {
+	warn := func(kind WarningKind, format string, args ...interface{}) {
+		*warnings = append(*warnings, Warning{Kind: kind, Msg: fmt.Sprintf(format, args...)})
+	}
+	switch n := n.(type) {
+	case *operatorNode:
+		check(n.Left, warnings)
+		check(n.Right, warnings)
+		if msg := neverTrue(n.Op, n.Left, n.Right); msg != "" {
+			warn(NeverTrue, "%s", msg)
+		}
+	case *functionNode:
+		for _, arg := range n.Args {
+			check(arg, warnings)
+		}
+		if n.Prefix == "" && stringFunctions[n.FuncName] && len(n.Args) > 0 && typeOf(n.Args[0]) == numberType {
+			if _, ok := n.Args[0].(*operandNode); !ok {
+				warn(StringOfNumber, "%s() is applied to the number %s, not to a string", n.FuncName, exprLabel(n.Args[0]))
+			}
+		}
+	case *groupNode:
+		check(n.Input, warnings)
+	case *filterNode:
+		check(n.Input, warnings)
+		check(n.Condition, warnings)
+		switch c := n.Condition.(type) {
+		case *operandNode:
+			if v, ok := c.Val.(float64); ok && (v < 1 || v != math.Trunc(v)) {
+				warn(FalsePredicate, "predicate [%s] is always false, positions are whole numbers from 1", formatNumber(v))
+			} else if c.Val == "" {
+				warn(FalsePredicate, "predicate [''] is always false")
+			}
+		case *functionNode:
+			if isConstant(c) && c.FuncName == "false" {
+				warn(FalsePredicate, "predicate is always false")
+			}
+		}
+	case *axisNode:
+		if n.Input != nil {
+			check(n.Input, warnings)
+		}
+	}
+}
+
+// neverTrue returns why left op right is false whatever the values of its
+// operands, or "" if it may be true.
+func neverTrue(op string, left, right node) string {
+	switch op {
+	case "=", "<", "<=", ">", ">=":
+	default:
+		return ""
+	}
+	lit, ok := right.(*operandNode)
+	other := left
+	if !ok {
+		lit, ok = left.(*operandNode)
+		other = right
+		// Swap the comparison, so that it reads other op lit.
+		switch op {
+		case "<":
+			op = ">"
+		case "<=":
+			op = ">="
+		case ">":
+			op = "<"
+		case ">=":
+			op = "<="
+		}
+	}
+	if !ok {
+		return ""
+	}
+	v, ok := lit.Val.(float64)
+	if !ok {
+		return ""
+	}
+	if math.IsNaN(v) {
+		return fmt.Sprintf("comparison of %s with NaN is never true", exprLabel(other))
+	}
+	cmp := exprLabel(other) + " " + op + " " + formatNumber(v)
+	f, ok := other.(*functionNode)
+	if !ok || f.Prefix != "" {
+		return ""
+	}
+	if min, ok := wholeNumberFunctions[f.FuncName]; ok {
+		never := false
+		switch op {
+		case "=":
+			never = v < min || v != math.Trunc(v)
+		case "<":
+			never = v <= min
+		case "<=":
+			never = v < min
+		}
+		if never {
+			return fmt.Sprintf("%s is never true, %s() is a whole number from %s", cmp, f.FuncName, formatNumber(min))
+		}
+	}
+	if f.FuncName == "name" || f.FuncName == "local-name" {
+		return fmt.Sprintf("%s is never true, a name is not a number", cmp)
+	}
+	return ""
+}
+
+// exprLabel returns a short description of n for warnings.
+func exprLabel(n node) string {
+	switch n := n.(type) {
+	case *functionNode:
+		return n.FuncName + "()"
+	case *operandNode:
+		if s, ok := n.Val.(string); ok {
+			return strconv.Quote(s)
+		}
+		return formatNumber(n.Val.(float64))
+	case *operatorNode:
+		return "the result of " + n.Op
+	case *groupNode:
+		return exprLabel(n.Input)
+	case *axisNode:
+		if n.AxisType == "attribute" {
+			return "@" + nodeTestLabel(n)
+		}
+		return nodeTestLabel(n)
+	case *filterNode:
+		return exprLabel(n.Input)
+	}
+	return "the node-set"
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
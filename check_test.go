@@ -0,0 +1,38 @@
+package xpath
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		kind WarningKind
+		msg  string
+	}{
+		{`//a[count(b) = 1.5]`, NeverTrue, "count() = 1.5 is never true, count() is a whole number from 0"},
+		{`//a[position() = 0]`, NeverTrue, "position() = 0 is never true, position() is a whole number from 1"},
+		{`-1 > count(//a)`, NeverTrue, "count() < -1 is never true, count() is a whole number from 0"},
+		{`//a[@x = number('x')]`, NeverTrue, "comparison of @x with NaN is never true"},
+		{`//a[name() = 3]`, NeverTrue, "name() = 3 is never true, a name is not a number"},
+		{`string-length(count(//a))`, StringOfNumber, "string-length() is applied to the number count(), not to a string"},
+		{`substring(sum(//a), 1)`, StringOfNumber, "substring() is applied to the number sum(), not to a string"},
+		{`//a[0]`, FalsePredicate, "predicate [0] is always false, positions are whole numbers from 1"},
+		{`(//a)[1.5]`, FalsePredicate, "predicate [1.5] is always false, positions are whole numbers from 1"},
+		{`//a[1 = 2]`, FalsePredicate, "predicate is always false"},
+		{`//a['']`, FalsePredicate, "predicate [''] is always false"},
+	} {
+		warnings := MustCompile(tc.expr).Check()
+		if len(warnings) != 1 {
+			t.Errorf("%s: expected one warning, got %v", tc.expr, warnings)
+			continue
+		}
+		assertEqual(t, tc.kind, warnings[0].Kind)
+		assertEqual(t, tc.msg, warnings[0].Msg)
+	}
+	for _, expr := range []string{`//a[1]`, `count(//a) = 1`, `2 > count(//a)`, `//a[@x = 1]`, `string-length(name())`, `//a[count(b) < 1]`} {
+		if warnings := MustCompile(expr).Check(); len(warnings) != 0 {
+			t.Errorf("%s: expected no warning, got %v", expr, warnings)
+		}
+	}
+	// An expression that did not compile has no warnings.
+	assertEqual(t, 0, len(MustCompile(`//a[`).Check()))
+}
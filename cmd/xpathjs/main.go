@@ -0,0 +1,126 @@
+//go:build js && wasm
+// +build js,wasm
+
+// Command xpathjs is the engine of the xpath package for JavaScript, as a
+// WebAssembly module, so that the tools of a browser, such as selector
+// debuggers, evaluate expressions as the servers do:
+//
+//	GOOS=js GOARCH=wasm go build -o xpath.wasm ./cmd/xpathjs
+//
+// Once the module is run with the wasm_exec.js of the Go distribution, the
+// global xpath object has the functions:
+//
+//	xpath.compile(expr, namespaces) returns {error} if expr is not valid,
+//	or an object whose evaluate(node) method evaluates it.
+//	xpath.evaluate(expr, node, namespaces) compiles expr and evaluates it
+//	from node.
+//
+// namespaces, which may be left out, is an object of the namespace URIs
+// of the prefixes of expr. An evaluation returns {value}, a number, a
+// string, a boolean, or an array of the nodes of a node-set in document
+// order, attributes included, or {error}. The nodes are DOM nodes, or
+// objects with the same properties, see navigator.
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/antchfx/xpath"
+)
+
+func main() {
+	js.Global().Set("xpath", js.ValueOf(map[string]interface{}{
+		"compile": js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+			expr, err := compile(args)
+			if err != nil {
+				return errorResult(err)
+			}
+			return map[string]interface{}{
+				"evaluate": js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+					if len(args) < 1 {
+						return errorResult(errMissingNode)
+					}
+					return evaluate(expr, args[0])
+				}),
+			}
+		}),
+		"evaluate": js.FuncOf(func(_ js.Value, args []js.Value) interface{} {
+			if len(args) < 2 {
+				return errorResult(errMissingNode)
+			}
+			expr, err := compile([]js.Value{args[0], arg(args, 2)})
+			if err != nil {
+				return errorResult(err)
+			}
+			return evaluate(expr, args[1])
+		}),
+	}))
+	select {}
+}
+
+// errMissingNode is the error of an evaluation without a node.
+var errMissingNode = jsError("xpathjs: missing node")
+
+type jsError string
+
+func (e jsError) Error() string { return string(e) }
+
+// arg returns the argument i of args, or undefined if there are fewer.
+func arg(args []js.Value, i int) js.Value {
+	if i < len(args) {
+		return args[i]
+	}
+	return js.Undefined()
+}
+
+// compile compiles the expression of the arguments expr and namespaces.
+func compile(args []js.Value) (*xpath.Expr, error) {
+	if arg(args, 0).Type() != js.TypeString {
+		return nil, jsError("xpathjs: the expression is not a string")
+	}
+	var ns map[string]string
+	if v := arg(args, 1); v.Type() == js.TypeObject {
+		keys := js.Global().Get("Object").Call("keys", v)
+		ns = make(map[string]string, keys.Length())
+		for i := 0; i < keys.Length(); i++ {
+			prefix := keys.Index(i).String()
+			ns[prefix] = v.Get(prefix).String()
+		}
+	}
+	return xpath.CompileWithNS(args[0].String(), ns)
+}
+
+// evaluate returns the result object of expr evaluated from node.
+func evaluate(expr *xpath.Expr, node js.Value) interface{} {
+	if !isNode(node) {
+		return errorResult(errMissingNode)
+	}
+	v, err := expr.EvaluateWithOptions(newNavigator(node), xpath.EvalOptions{RecoverPanics: true})
+	if err != nil {
+		return errorResult(err)
+	}
+	iter, ok := v.(*xpath.NodeIterator)
+	if !ok {
+		return map[string]interface{}{"value": v}
+	}
+	var nodes []interface{}
+	for iter.MoveNext() {
+		nav := iter.Current()
+		for {
+			w, ok := nav.(interface{ Unwrap() xpath.NodeNavigator })
+			if !ok {
+				break
+			}
+			nav = w.Unwrap()
+		}
+		nodes = append(nodes, nav.(*navigator).current())
+	}
+	if err := iter.Err(); err != nil {
+		return errorResult(err)
+	}
+	return map[string]interface{}{"value": nodes}
+}
+
+func errorResult(err error) interface{} {
+	return map[string]interface{}{"error": err.Error()}
+}
@@ -0,0 +1,80 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"syscall/js"
+	"testing"
+)
+
+// tree is a document of objects with the properties of DOM nodes.
+const tree = `
+function node(type, props, children) {
+	const n = Object.assign({nodeType: type, prefix: null, namespaceURI: null, attributes: [], parentNode: null,
+		firstChild: null, previousSibling: null, nextSibling: null, nodeValue: null}, props);
+	let prev = null;
+	for (const c of children || []) {
+		c.parentNode = n;
+		c.previousSibling = prev;
+		if (prev) { prev.nextSibling = c } else { n.firstChild = c }
+		prev = c;
+	}
+	return n;
+}
+const el = (name, attrs, children) => node(1, {localName: name, attributes: attrs.map(
+	([name, value]) => ({localName: name, prefix: null, namespaceURI: name == 'xmlns' ? 'http://www.w3.org/2000/xmlns/' : null, value}))}, children);
+const text = (s) => node(3, {nodeValue: s});
+return node(9, {}, [node(10, {}), el('ul', [['xmlns', 'urn:x'], ['id', 'l']], [
+	el('li', [['class', 'a']], [text('one')]),
+	node(8, {nodeValue: 'c'}),
+	el('li', [], [text('two'), node(7, {nodeName: 'pi'})]),
+])]);
+`
+
+func TestEvaluate(t *testing.T) {
+	doc := js.Global().Get("Function").New(tree).Invoke()
+	for _, tc := range []struct {
+		expr string
+		want interface{}
+	}{
+		{`count(/node())`, 1.0},
+		{`count(//li)`, 2.0},
+		{`string(/ul)`, "onetwo"},
+		{`count(/ul/@*)`, 1.0},
+		{`//li[2] = 'two' and //comment() = 'c'`, true},
+		{`name(//li[@class]/following-sibling::*)`, "li"},
+	} {
+		expr, err := compile([]js.Value{js.ValueOf(tc.expr)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := js.ValueOf(evaluate(expr, doc))
+		if e := got.Get("error"); !e.IsUndefined() {
+			t.Errorf("%s: %s", tc.expr, e)
+			continue
+		}
+		if v := got.Get("value"); !v.Equal(js.ValueOf(tc.want)) {
+			t.Errorf("%s = %v, want %v", tc.expr, v, tc.want)
+		}
+	}
+
+	// The nodes of node-sets are the objects of the tree.
+	expr, _ := compile([]js.Value{js.ValueOf(`//li[1] | //@id`)})
+	nodes := js.ValueOf(evaluate(expr, doc)).Get("value")
+	ul := doc.Get("firstChild").Get("nextSibling")
+	if nodes.Length() != 2 || !nodes.Index(0).Equal(ul.Get("attributes").Index(1)) || !nodes.Index(1).Equal(ul.Get("firstChild")) {
+		t.Errorf("unexpected nodes %v", nodes)
+	}
+
+	if _, err := compile([]js.Value{js.ValueOf(`//li[`)}); err == nil {
+		t.Error("expected a syntax error")
+	}
+	if _, err := compile([]js.Value{js.ValueOf(`//x:li`), js.ValueOf(map[string]interface{}{"x": "urn:x"})}); err != nil {
+		t.Error(err)
+	}
+	expr, _ = compile([]js.Value{js.ValueOf(`sum(//li)`)})
+	if js.ValueOf(evaluate(expr, js.Null())).Get("error").IsUndefined() {
+		t.Error("expected an error evaluating from null")
+	}
+}
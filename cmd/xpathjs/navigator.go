@@ -0,0 +1,214 @@
+//go:build js && wasm
+// +build js,wasm
+
+package main
+
+import (
+	"syscall/js"
+
+	"github.com/antchfx/xpath"
+)
+
+// The nodeType values of DOM nodes.
+const (
+	elementNode               = 1
+	textNode                  = 3
+	cdataSectionNode          = 4
+	processingInstructionNode = 7
+	commentNode               = 8
+	documentNode              = 9
+	documentTypeNode          = 10
+	documentFragmentNode      = 11
+)
+
+const xmlnsURI = "http://www.w3.org/2000/xmlns/"
+
+// navigator is an xpath.NodeNavigator over a DOM node, or over an object
+// with the same properties: nodeType, localName, prefix, namespaceURI,
+// nodeValue, attributes, parentNode, firstChild, previousSibling and
+// nextSibling. The attributes are an array-like object of objects with a
+// localName, a prefix, a namespaceURI and a value.
+type navigator struct {
+	root, curr js.Value
+	attr       int
+}
+
+func newNavigator(top js.Value) *navigator {
+	root := top
+	for p := root.Get("parentNode"); isNode(p); p = root.Get("parentNode") {
+		root = p
+	}
+	return &navigator{root: root, curr: top, attr: -1}
+}
+
+// isNode reports whether v is a node rather than null or undefined.
+func isNode(v js.Value) bool {
+	return v.Type() == js.TypeObject
+}
+
+// str returns the string property name of v, or "" if it is null or
+// undefined.
+func str(v js.Value, name string) string {
+	p := v.Get(name)
+	if p.Type() != js.TypeString {
+		return ""
+	}
+	return p.String()
+}
+
+func (n *navigator) attribute() js.Value {
+	return n.curr.Get("attributes").Index(n.attr)
+}
+
+// current returns the node or the attribute of the navigator position.
+func (n *navigator) current() js.Value {
+	if n.attr != -1 {
+		return n.attribute()
+	}
+	return n.curr
+}
+
+func (n *navigator) NodeType() xpath.NodeType {
+	if n.attr != -1 {
+		return xpath.AttributeNode
+	}
+	switch n.curr.Get("nodeType").Int() {
+	case documentNode, documentFragmentNode:
+		return xpath.RootNode
+	case textNode, cdataSectionNode:
+		return xpath.TextNode
+	case commentNode:
+		return xpath.CommentNode
+	}
+	return xpath.ElementNode
+}
+
+func (n *navigator) LocalName() string {
+	switch n.NodeType() {
+	case xpath.AttributeNode:
+		return str(n.attribute(), "localName")
+	case xpath.ElementNode:
+		return str(n.curr, "localName")
+	}
+	return ""
+}
+
+func (n *navigator) Prefix() string {
+	return str(n.current(), "prefix")
+}
+
+// NamespaceURL returns the namespace URI of the current node.
+func (n *navigator) NamespaceURL() string {
+	return str(n.current(), "namespaceURI")
+}
+
+func (n *navigator) Value() string {
+	switch n.NodeType() {
+	case xpath.AttributeNode:
+		return str(n.attribute(), "value")
+	case xpath.ElementNode, xpath.RootNode:
+		var b []byte
+		return string(appendText(b, n.curr))
+	}
+	return str(n.curr, "nodeValue")
+}
+
+// appendText appends the text of the descendants of node to b.
+func appendText(b []byte, node js.Value) []byte {
+	for c := node.Get("firstChild"); isNode(c); c = c.Get("nextSibling") {
+		switch c.Get("nodeType").Int() {
+		case textNode, cdataSectionNode:
+			b = append(b, str(c, "nodeValue")...)
+		case elementNode:
+			b = appendText(b, c)
+		}
+	}
+	return b
+}
+
+func (n *navigator) Copy() xpath.NodeNavigator {
+	n2 := *n
+	return &n2
+}
+
+func (n *navigator) MoveToRoot() {
+	n.curr = n.root
+	n.attr = -1
+}
+
+func (n *navigator) MoveToParent() bool {
+	if n.attr != -1 {
+		n.attr = -1
+		return true
+	}
+	if p := n.curr.Get("parentNode"); isNode(p) {
+		n.curr = p
+		return true
+	}
+	return false
+}
+
+func (n *navigator) MoveToNextAttribute() bool {
+	if n.attr == -1 && n.NodeType() != xpath.ElementNode || !isNode(n.curr.Get("attributes")) {
+		return false
+	}
+	attrs := n.curr.Get("attributes")
+	for i := n.attr + 1; i < attrs.Length(); i++ {
+		if str(attrs.Index(i), "namespaceURI") != xmlnsURI {
+			n.attr = i
+			return true
+		}
+	}
+	return false
+}
+
+// visible returns node, or the first of its siblings in the direction
+// next that is a node of the XPath tree, or null. The doctype and the
+// processing instructions are not, as in the dom package.
+func visible(node js.Value, next string) js.Value {
+	for ; isNode(node); node = node.Get(next) {
+		if t := node.Get("nodeType").Int(); t != documentTypeNode && t != processingInstructionNode {
+			return node
+		}
+	}
+	return js.Null()
+}
+
+func (n *navigator) moveTo(node js.Value) bool {
+	if n.attr != -1 || !isNode(node) {
+		return false
+	}
+	n.curr = node
+	return true
+}
+
+func (n *navigator) MoveToChild() bool {
+	return n.moveTo(visible(n.curr.Get("firstChild"), "nextSibling"))
+}
+
+func (n *navigator) MoveToFirst() bool {
+	if p := n.curr.Get("parentNode"); n.attr == -1 && isNode(p) {
+		first := visible(p.Get("firstChild"), "nextSibling")
+		if !first.Equal(n.curr) {
+			return n.moveTo(first)
+		}
+	}
+	return false
+}
+
+func (n *navigator) MoveToNext() bool {
+	return n.moveTo(visible(n.curr.Get("nextSibling"), "nextSibling"))
+}
+
+func (n *navigator) MoveToPrevious() bool {
+	return n.moveTo(visible(n.curr.Get("previousSibling"), "previousSibling"))
+}
+
+func (n *navigator) MoveTo(other xpath.NodeNavigator) bool {
+	node, ok := other.(*navigator)
+	if !ok {
+		return false
+	}
+	*n = *node
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+// functionDocs describe the built-in functions, shown on hover with
+// their signature from xpath.Capabilities.
+var functionDocs = map[string]string{
+	"boolean":          "Converts the argument to a boolean: a number is true if it is neither zero nor NaN, a string or a node-set if it is not empty.",
+	"ceiling":          "Returns the smallest integer that is not less than the number.",
+	"concat":           "Returns the concatenation of the string values of its arguments.",
+	"compare":          "Returns -1, 0 or 1 as the first string is before, equal to or after the second, in the collation of the URI of the third argument if any.",
+	"contains":         "Reports whether the first string contains the second, in the collation of the URI of the third argument if any, such as that of xpath.CollationCaseFold.",
+	"count":            "Returns the number of nodes of the node-set.",
+	"doc":              "Returns the document node of the URI, read with the DocumentResolver of the evaluation; it fails without one.",
+	"document":         "Returns the document nodes of the URIs, the string values of the nodes of a node-set or a string, read with the DocumentResolver of the evaluation.",
+	"ends-with":        "Reports whether the first string ends with the second, in the collation of the URI of the third argument if any.",
+	"false":            "Returns false.",
+	"floor":            "Returns the largest integer that is not greater than the number.",
+	"id":               "Returns the elements whose id attribute is one of the whitespace-separated IDs of the argument.",
+	"last":             "Returns the size of the context, the number of nodes the predicate is applied to.",
+	"local-name":       "Returns the local name of the first node of the node-set, or of the context node, without its prefix.",
+	"lower-case":       "Returns the string in lower case.",
+	"matches":          "Reports whether the string matches the regular expression, in the syntax of the Go regexp package, with the flags i, m, s, x and q of XPath as the third argument.",
+	"name":             "Returns the qualified name of the first node of the node-set, or of the context node.",
+	"namespace-uri":    "Returns the namespace URI of the first node of the node-set, or of the context node.",
+	"normalize-space":  "Returns the string, or the string value of the context node, with leading and trailing whitespace removed and inner whitespace collapsed to single spaces.",
+	"not":              "Returns the negation of the boolean value of the argument.",
+	"number":           "Converts the argument, or the string value of the context node, to a number; a string that is not a number is NaN.",
+	"position":         "Returns the position of the context node in the context, from 1.",
+	"replace":          "Returns the string with the matches of the regular expression replaced, with $1 for the first group in the replacement, and the flags of matches() as the fourth argument.",
+	"reverse":          "Returns the nodes of the node-set in reverse document order.",
+	"round":            "Returns the integer closest to the number, the greater one for halves.",
+	"starts-with":      "Reports whether the first string starts with the second, in the collation of the URI of the third argument if any.",
+	"string":           "Converts the argument, or the context node, to a string; a node-set is the string value of its first node.",
+	"string-join":      "Returns the string values of the nodes of the node-set, joined with the separator.",
+	"string-length":    "Returns the number of characters of the string, or of the string value of the context node.",
+	"substring":        "Returns the characters of the string from the position, from 1, and of the length if any.",
+	"substring-after":  "Returns the part of the first string after the first occurrence of the second, or the empty string.",
+	"substring-before": "Returns the part of the first string before the first occurrence of the second, or the empty string.",
+	"sum":              "Returns the sum of the numbers of the string values of the nodes of the node-set.",
+	"translate":        "Returns the first string with the characters of the second replaced by those at the same position in the third, or removed if there is none.",
+	"true":             "Returns true.",
+	"unparsed-text":    "Returns the text of the URI, read with the DocumentResolver of the evaluation.",
+}
+
+// axisDocs describe the axes, shown on hover.
+var axisDocs = map[string]string{
+	"ancestor":           "The parent of the context node, its parent, and so on up to the document node.",
+	"ancestor-or-self":   "The context node and its ancestors.",
+	"attribute":          "The attributes of the context node; @ is its abbreviation.",
+	"child":              "The children of the context node; it is the axis of a step without one.",
+	"descendant":         "The children of the context node, their children, and so on.",
+	"descendant-or-self": "The context node and its descendants; // is /descendant-or-self::node()/.",
+	"following":          "The nodes after the context node in document order, except its descendants.",
+	"following-sibling":  "The siblings after the context node.",
+	"parent":             "The parent of the context node; .. is parent::node().",
+	"preceding":          "The nodes before the context node in document order, except its ancestors.",
+	"preceding-sibling":  "The siblings before the context node.",
+	"self":               "The context node; . is self::node().",
+}
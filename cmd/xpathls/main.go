@@ -0,0 +1,87 @@
+// Command xpathls is a language server for XPath expressions, speaking
+// the Language Server Protocol on its standard input and output, so that
+// editors check and complete the expressions as this engine compiles
+// them rather than as another dialect would:
+//
+//	xpathls [-sample file] [-html] [-ns prefix=uri ...]
+//
+// A document holds expressions separated by blank lines, so that an
+// expression may span several lines. The server publishes for each one
+// the error of xpath.Compile, the probable mistakes of Expr.Check and the
+// idioms of xpath.Lint as diagnostics; it shows the signature and a
+// description of the functions and axes under the cursor on hover; and
+// it completes the names of the functions, axes and node tests, and the
+// names of the elements and attributes of the sample document.
+//
+// The flags are:
+//
+//	-sample file
+//		reads the element and attribute names completed from the XML
+//		file, whose namespaces are bound to their prefixes, see
+//		xpath.DiscoverNamespaces.
+//	-html
+//		parses the sample file as HTML.
+//	-ns prefix=uri
+//		binds the prefix of the expressions to the namespace URI; it
+//		may be repeated.
+//
+// The client may also set them in the initializationOptions of its
+// initialize request, as {"sample": "file", "html": true, "namespaces":
+// {"prefix": "uri"}}.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// namespaceFlag is the value of the repeated -ns flag.
+type namespaceFlag map[string]string
+
+func (f namespaceFlag) String() string {
+	return fmt.Sprint(map[string]string(f))
+}
+
+func (f namespaceFlag) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return fmt.Errorf("%q is not prefix=uri", s)
+	}
+	f[s[:i]] = s[i+1:]
+	return nil
+}
+
+// run serves the requests read from stdin with the arguments args, and
+// returns the exit status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("xpathls", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	sample := flags.String("sample", "", "the XML `file` whose names are completed")
+	html := flags.Bool("html", false, "parse the sample file as HTML")
+	namespaces := namespaceFlag{}
+	flags.Var(namespaces, "ns", "bind a `prefix=uri` of the expressions; repeatable")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	s := newServer(stdout)
+	if err := s.configure(settings{Sample: *sample, HTML: *html, Namespaces: namespaces}); err != nil {
+		fmt.Fprintf(stderr, "xpathls: %v\n", err)
+		return 2
+	}
+	if err := s.serve(stdin); err != nil {
+		fmt.Fprintf(stderr, "xpathls: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// exchange runs the server with args on the messages, and returns the
+// messages it writes and its exit status.
+func exchange(t *testing.T, args []string, messages ...string) ([]map[string]interface{}, int) {
+	var in, out, stderr bytes.Buffer
+	for _, m := range messages {
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(m), m)
+	}
+	status := run(args, &in, &out, &stderr)
+	if stderr.Len() > 0 {
+		t.Log(stderr.String())
+	}
+	var list []map[string]interface{}
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readBody(r)
+		if err == io.EOF {
+			break
+		}
+		var v map[string]interface{}
+		if err == nil {
+			err = json.Unmarshal(body, &v)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, v)
+	}
+	return list, status
+}
+
+func TestServer(t *testing.T) {
+	sample := filepath.Join(t.TempDir(), "feed.xml")
+	if err := os.WriteFile(sample, []byte(`<feed xmlns="urn:f"><item id="1" xml:lang="en"><title>a</title></item></feed>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	text := "count(//ns1:item) = 1.5\n\n//ns1:item[\n\nupper-case(.)\n\n@a != 'v'"
+	msgs, status := exchange(t, []string{"-sample", sample},
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		fmt.Sprintf(`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///q.xpath","text":%q}}}`, text),
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///q.xpath"},"position":{"line":0,"character":2}}}`,
+		`{"jsonrpc":"2.0","id":3,"method":"textDocument/completion","params":{"textDocument":{"uri":"file:///q.xpath"},"position":{"line":2,"character":7}}}`,
+		`{"jsonrpc":"2.0","id":4,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///q.xpath"},"position":{"line":2,"character":4}}}`,
+		`{"jsonrpc":"2.0","id":5,"method":"workspace/symbol","params":{}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	if status != 0 || len(msgs) != 7 {
+		t.Fatalf("status %d, %d messages, want 0, 7: %v", status, len(msgs), msgs)
+	}
+
+	var diags []string
+	for _, d := range msgs[1]["params"].(map[string]interface{})["diagnostics"].([]interface{}) {
+		d := d.(map[string]interface{})
+		start := d["range"].(map[string]interface{})["start"].(map[string]interface{})
+		diags = append(diags, fmt.Sprintf("%v:%v %v %v", start["line"], start["character"], d["severity"], d["message"]))
+	}
+	want := []string{
+		"0:0 2 count() = 1.5 is never true, count() is a whole number from 0",
+		"2:11 1 syntax error: expected expression, found end of expression",
+		"4:0 1 unknown function upper-case(), did you mean lower-case()?",
+		"6:0 3 ",
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("diagnostics %q, want %q", diags, want)
+	}
+	for i := range want {
+		if !strings.HasPrefix(diags[i], want[i]) {
+			t.Errorf("diagnostic %q, want %q", diags[i], want[i])
+		}
+	}
+
+	hover := msgs[2]["result"].(map[string]interface{})["contents"].(map[string]interface{})["value"].(string)
+	if !strings.Contains(hover, "count(node-set) as number") || !strings.Contains(hover, "number of nodes") {
+		t.Errorf("hover of count: %q", hover)
+	}
+	var labels []string
+	for _, item := range msgs[3]["result"].([]interface{}) {
+		labels = append(labels, item.(map[string]interface{})["label"].(string))
+	}
+	if strings.Join(labels, " ") != "ns1:item" {
+		t.Errorf("completion of ns1:i: %q, want ns1:item", labels)
+	}
+	if msgs[4]["result"] != nil {
+		t.Errorf("hover of a name test: %v, want null", msgs[4]["result"])
+	}
+	if msgs[5]["error"].(map[string]interface{})["code"].(float64) != methodNotFound {
+		t.Errorf("unsupported method: %v", msgs[5])
+	}
+}
+
+func TestComplete(t *testing.T) {
+	s := newServer(nil)
+	s.elements, s.attributes = []string{"item", "title"}, []string{"id", "xml:lang"}
+	for _, test := range []struct {
+		text string
+		want string
+	}{
+		{"//item/@", "id xml:lang"},
+		{"//item[@x", "xml:lang"},
+		{"count(//i", "id item"},
+		{"child::t", "text() title"},
+		{"st", "starts-with string string-join string-length"},
+		{"desc", "descendant descendant-or-self"},
+	} {
+		var labels []string
+		for _, item := range s.complete(test.text, positionAt(test.text, len(test.text))) {
+			labels = append(labels, item.Label)
+		}
+		if got := strings.Join(labels, " "); got != test.want {
+			t.Errorf("completions of %q: %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	text := "a\n€𝄞b"
+	for offset, p := range map[int]position{0: {0, 0}, 2: {1, 0}, 5: {1, 1}, 9: {1, 3}, 10: {1, 4}} {
+		if got := positionAt(text, offset); got != p {
+			t.Errorf("positionAt(%d) = %v, want %v", offset, got, p)
+		}
+		if got := offsetAt(text, p); got != offset {
+			t.Errorf("offsetAt(%v) = %d, want %d", p, got, offset)
+		}
+	}
+	if got := offsetAt(text, position{0, 9}); got != 1 {
+		t.Errorf("offsetAt past the line = %d, want 1", got)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A message is a request or a notification, which has no ID.
+type message struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+// A response is the result of a request, or its error.
+type response struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *responseError   `json:"error,omitempty"`
+}
+
+type responseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type notification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+// The JSON-RPC error codes.
+const (
+	parseError     = -32700
+	invalidParams  = -32602
+	methodNotFound = -32601
+	invalidRequest = -32600
+)
+
+// readMessage reads a message with its Content-Length header from r. The
+// message is nil if the header cannot be read, and the error is io.EOF at
+// the end of r.
+func readMessage(r *bufio.Reader) (*message, error) {
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+	var m message
+	return &m, json.Unmarshal(body, &m)
+}
+
+// readBody reads the body of a message with its Content-Length header
+// from r.
+func readBody(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if err == io.EOF && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("reading the header: %v", err)
+	}
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil || n < 0 {
+		return nil, errors.New("invalid Content-Length")
+	}
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, fmt.Errorf("reading the body: %v", err)
+	}
+	return body, nil
+}
+
+// writeMessage writes the response or notification m with its
+// Content-Length header to w.
+func writeMessage(w io.Writer, m interface{}) error {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// The types of the parameters and results of the protocol, with the
+// fields the server uses.
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type textDocumentItem struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type textDocumentPositionParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     position               `json:"position"`
+}
+
+type initializeParams struct {
+	InitializationOptions *settings `json:"initializationOptions"`
+}
+
+type didOpenParams struct {
+	TextDocument textDocumentItem `json:"textDocument"`
+}
+
+type didChangeParams struct {
+	TextDocument   textDocumentIdentifier `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+}
+
+type didCloseParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+}
+
+type diagnostic struct {
+	Range    textRange `json:"range"`
+	Severity int       `json:"severity"`
+	Source   string    `json:"source"`
+	Message  string    `json:"message"`
+}
+
+// The severities of the diagnostics.
+const (
+	severityError       = 1
+	severityWarning     = 2
+	severityInformation = 3
+)
+
+type publishDiagnosticsParams struct {
+	URI         string       `json:"uri"`
+	Diagnostics []diagnostic `json:"diagnostics"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type hover struct {
+	Contents markupContent `json:"contents"`
+	Range    textRange     `json:"range"`
+}
+
+type completionItem struct {
+	Label      string `json:"label"`
+	Kind       int    `json:"kind"`
+	Detail     string `json:"detail,omitempty"`
+	InsertText string `json:"insertText,omitempty"`
+}
+
+// The kinds of the completion items.
+const (
+	kindFunction = 3
+	kindField    = 5
+	kindProperty = 10
+	kindKeyword  = 14
+)
+
+// positionAt returns the position of the byte offset in text. The
+// characters of the positions are UTF-16 code units, as in the protocol.
+func positionAt(text string, offset int) position {
+	var p position
+	for _, r := range text[:offset] {
+		if r == '\n' {
+			p.Line++
+			p.Character = 0
+		} else {
+			p.Character += utf16Len(r)
+		}
+	}
+	return p
+}
+
+// offsetAt returns the byte offset of the position p in text, at the end
+// of its line if the character is past it.
+func offsetAt(text string, p position) int {
+	offset := 0
+	for line := 0; line < p.Line; line++ {
+		i := strings.IndexByte(text[offset:], '\n')
+		if i < 0 {
+			return len(text)
+		}
+		offset += i + 1
+	}
+	for n := 0; n < p.Character && offset < len(text) && text[offset] != '\n'; {
+		r, size := utf8.DecodeRuneInString(text[offset:])
+		n += utf16Len(r)
+		offset += size
+	}
+	return offset
+}
+
+// utf16Len returns the number of UTF-16 code units of r.
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// settings are the options of the server, set by the flags or by the
+// initializationOptions of the client.
+type settings struct {
+	Sample     string            `json:"sample"`
+	HTML       bool              `json:"html"`
+	Namespaces map[string]string `json:"namespaces"`
+}
+
+type server struct {
+	w        io.Writer
+	settings settings
+	// namespaces are those of the expressions: the namespaces of the
+	// sample document, and those of the settings.
+	namespaces map[string]string
+	// elements and attributes are the sorted names of the sample.
+	elements, attributes []string
+	docs                 map[string]string
+	shutdown             bool
+}
+
+func newServer(w io.Writer) *server {
+	return &server{w: w, docs: make(map[string]string)}
+}
+
+// configure applies the settings, reading the sample document if any.
+func (s *server) configure(set settings) error {
+	namespaces := make(map[string]string)
+	var elements, attributes []string
+	if set.Sample != "" {
+		f, err := os.Open(strings.TrimPrefix(set.Sample, "file://"))
+		if err != nil {
+			return err
+		}
+		doc, err := dom.ParseWithOptions(f, dom.ParseOptions{HTML: set.HTML})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", set.Sample, err)
+		}
+		namespaces = xpath.DiscoverNamespaces(dom.CreateNavigator(doc))
+		for prefix, uri := range set.Namespaces {
+			namespaces[prefix] = uri
+		}
+		elements, attributes = sampleNames(doc, namespaces)
+	}
+	for prefix, uri := range set.Namespaces {
+		namespaces[prefix] = uri
+	}
+	s.settings, s.namespaces, s.elements, s.attributes = set, namespaces, elements, attributes
+	return nil
+}
+
+// sampleNames returns the sorted names of the elements and attributes of
+// doc, with the prefixes the namespaces bind, but for the namespace
+// declarations.
+func sampleNames(doc *dom.Node, namespaces map[string]string) (elements, attributes []string) {
+	prefixes := make(map[string]string)
+	for prefix, uri := range namespaces {
+		if p, ok := prefixes[uri]; !ok || prefix < p {
+			prefixes[uri] = prefix
+		}
+	}
+	name := func(uri, local string) string {
+		if uri == "" {
+			return local
+		}
+		return prefixes[uri] + ":" + local
+	}
+	seenElements, seenAttributes := make(map[string]bool), make(map[string]bool)
+	var walk func(n *dom.Node)
+	walk = func(n *dom.Node) {
+		if n.Type == dom.ElementNode {
+			seenElements[name(n.NamespaceURI, n.Data)] = true
+			for _, a := range n.Attr {
+				if a.Prefix != "xmlns" && !(a.Prefix == "" && a.Name == "xmlns") {
+					seenAttributes[name(a.NamespaceURI, a.Name)] = true
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return sortedKeys(seenElements), sortedKeys(seenAttributes)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// serve handles the messages read from r until the exit notification or
+// the end of r.
+func (s *server) serve(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for {
+		m, err := readMessage(br)
+		if err == io.EOF {
+			return nil
+		}
+		if m == nil {
+			return err
+		}
+		if err != nil {
+			s.respond(nil, nil, &responseError{parseError, err.Error()})
+			continue
+		}
+		if m.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit before shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(m)
+		if m.ID != nil {
+			if err := s.respond(m.ID, result, rerr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *server) respond(id *json.RawMessage, result interface{}, rerr *responseError) error {
+	resp := response{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		raw := json.RawMessage(data)
+		resp.Result = &raw
+	}
+	return writeMessage(s.w, resp)
+}
+
+func (s *server) notify(method string, params interface{}) error {
+	return writeMessage(s.w, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// handle returns the result of the request or notification m.
+func (s *server) handle(m *message) (interface{}, *responseError) {
+	if s.shutdown && m.ID != nil {
+		return nil, &responseError{invalidRequest, "the server is shut down"}
+	}
+	params := func(v interface{}) *responseError {
+		if err := json.Unmarshal(m.Params, v); err != nil {
+			return &responseError{invalidParams, err.Error()}
+		}
+		return nil
+	}
+	switch m.Method {
+	case "initialize":
+		var p initializeParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		if opts := p.InitializationOptions; opts != nil {
+			set := s.settings
+			if opts.Sample != "" {
+				set.Sample, set.HTML = opts.Sample, opts.HTML
+			}
+			if opts.Namespaces != nil {
+				set.Namespaces = opts.Namespaces
+			}
+			if err := s.configure(set); err != nil {
+				return nil, &responseError{invalidParams, err.Error()}
+			}
+		}
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": 1,
+				"hoverProvider":    true,
+				"completionProvider": map[string]interface{}{
+					"triggerCharacters": []string{"/", "@", ":", "["},
+				},
+			},
+			"serverInfo": map[string]string{"name": "xpathls"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		s.docs[p.TextDocument.URI] = p.TextDocument.Text
+		s.publish(p.TextDocument.URI)
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		// The changes are whole documents, as the server declares.
+		if n := len(p.ContentChanges); n > 0 {
+			s.docs[p.TextDocument.URI] = p.ContentChanges[n-1].Text
+			s.publish(p.TextDocument.URI)
+		}
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []diagnostic{}})
+	case "textDocument/hover":
+		var p textDocumentPositionParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		if text, ok := s.docs[p.TextDocument.URI]; ok {
+			if h := hoverAt(text, p.Position); h != nil {
+				return h, nil
+			}
+		}
+	case "textDocument/completion":
+		var p textDocumentPositionParams
+		if err := params(&p); err != nil {
+			return nil, err
+		}
+		return s.complete(s.docs[p.TextDocument.URI], p.Position), nil
+	default:
+		if m.ID != nil {
+			return nil, &responseError{methodNotFound, "unsupported method " + m.Method}
+		}
+	}
+	return nil, nil
+}
+
+// publish sends the diagnostics of the document uri.
+func (s *server) publish(uri string) {
+	text := s.docs[uri]
+	diags := []diagnostic{}
+	for _, sp := range expressions(text) {
+		diags = append(diags, s.diagnose(text, sp)...)
+	}
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+// A span is the byte offsets of an expression of a document.
+type span struct {
+	start, end int
+}
+
+// expressions returns the expressions of text, which are separated by
+// blank lines, without their leading and trailing whitespace.
+func expressions(text string) []span {
+	var list []span
+	start, end := -1, 0
+	for offset := 0; ; {
+		lineEnd := len(text)
+		if i := strings.IndexByte(text[offset:], '\n'); i >= 0 {
+			lineEnd = offset + i
+		}
+		line := text[offset:lineEnd]
+		if trimmed := strings.TrimSpace(line); trimmed == "" {
+			if start >= 0 {
+				list = append(list, span{start, end})
+				start = -1
+			}
+		} else {
+			if start < 0 {
+				start = offset + strings.Index(line, trimmed)
+			}
+			end = offset + len(strings.TrimRight(line, " \t\r"))
+		}
+		if lineEnd == len(text) {
+			break
+		}
+		offset = lineEnd + 1
+	}
+	if start >= 0 {
+		list = append(list, span{start, end})
+	}
+	return list
+}
+
+// diagnose returns the diagnostics of the expression sp of text.
+func (s *server) diagnose(text string, sp span) []diagnostic {
+	expr := text[sp.start:sp.end]
+	var diags []diagnostic
+	add := func(start, end, severity int, msg string) {
+		r := textRange{positionAt(text, sp.start+start), positionAt(text, sp.start+end)}
+		diags = append(diags, diagnostic{Range: r, Severity: severity, Source: "xpath", Message: msg})
+	}
+	exp, err := xpath.CompileWithNS(expr, s.namespaces)
+	if err != nil {
+		start, end, msg := errorRange(expr, err)
+		add(start, end, severityError, msg)
+	} else {
+		for _, w := range exp.Check() {
+			add(0, len(expr), severityWarning, w.Msg)
+		}
+	}
+	for _, issue := range xpath.Lint(expr) {
+		msg := issue.Msg
+		if issue.Rewrite != "" {
+			msg += "; probably meant: " + issue.Rewrite
+		}
+		add(issue.Offset, tokenEnd(expr, issue.Offset), severityInformation, msg)
+	}
+	return diags
+}
+
+// errorRange returns the offsets in expr of the compile error err, and
+// its message.
+func errorRange(expr string, err error) (start, end int, msg string) {
+	switch err := err.(type) {
+	case *xpath.SyntaxError:
+		// The first line, without the offset and the caret of the
+		// terminal.
+		msg = strings.SplitN(err.Error(), "\n", 2)[0]
+		msg = "syntax error: " + strings.TrimPrefix(msg, fmt.Sprintf("xpath: syntax error at offset %d: ", err.Offset))
+		return err.Offset, err.Offset + len(err.Token), msg
+	case *xpath.UnknownFunctionError:
+		if i := strings.Index(expr, err.Name); i >= 0 {
+			return i, i + len(err.Name), strings.TrimPrefix(err.Error(), "xpath: ")
+		}
+	}
+	return 0, len(expr), strings.TrimPrefix(err.Error(), "xpath: ")
+}
+
+// isNameChar reports whether c is a character of a name, but for the
+// colon of a prefix.
+func isNameChar(c byte) bool {
+	return c == '-' || c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// tokenEnd returns the end of the token of expr at offset: a name, or a
+// single character.
+func tokenEnd(expr string, offset int) int {
+	end := offset
+	for end < len(expr) && isNameChar(expr[end]) {
+		end++
+	}
+	if end == offset && end < len(expr) {
+		end++
+	}
+	return end
+}
+
+// hoverAt describes the function or axis at p in text, or returns nil.
+func hoverAt(text string, p position) *hover {
+	offset := offsetAt(text, p)
+	start, end := offset, offset
+	for start > 0 && isNameChar(text[start-1]) {
+		start--
+	}
+	for end < len(text) && isNameChar(text[end]) {
+		end++
+	}
+	name := text[start:end]
+	if name == "" || start > 0 && text[start-1] == ':' && (start < 2 || text[start-2] != ':') {
+		// A local name after a prefix is not a built-in.
+		return nil
+	}
+	var value string
+	next := strings.TrimLeft(text[end:], " \t\r\n")
+	switch {
+	case strings.HasPrefix(next, "::"):
+		doc, ok := axisDocs[name]
+		if !ok {
+			return nil
+		}
+		value = fmt.Sprintf("```xpath\n%s::\n```\n\n%s", name, doc)
+	case strings.HasPrefix(next, "("):
+		sig, ok := xpath.Capabilities().Signatures[name]
+		if !ok {
+			return nil
+		}
+		value = fmt.Sprintf("```xpath\n%s\n```\n\n%s", formatSignature(name, sig), functionDocs[name])
+	default:
+		return nil
+	}
+	return &hover{
+		Contents: markupContent{Kind: "markdown", Value: value},
+		Range:    textRange{positionAt(text, start), positionAt(text, end)},
+	}
+}
+
+// formatSignature returns the signature of the function name, such as
+// substring(string, number, number?) as string.
+func formatSignature(name string, sig xpath.FunctionSignature) string {
+	n := sig.MaxArgs
+	if n < 0 {
+		n = sig.MinArgs
+		if len(sig.Params) > n {
+			n = len(sig.Params)
+		}
+		if n == 0 {
+			n = 1
+		}
+	}
+	var params []string
+	for i := 0; i < n; i++ {
+		param := "value"
+		if len(sig.Params) > 0 {
+			if types := sig.Params[min(i, len(sig.Params)-1)]; types != nil {
+				param = strings.Join(types, "|")
+			}
+		}
+		if i >= sig.MinArgs {
+			param += "?"
+		}
+		params = append(params, param)
+	}
+	if sig.MaxArgs < 0 {
+		params = append(params, "...")
+	}
+	return fmt.Sprintf("%s(%s) as %s", name, strings.Join(params, ", "), sig.Result)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// complete returns the completions of the name before p in text.
+func (s *server) complete(text string, p position) []completionItem {
+	offset := offsetAt(text, p)
+	start := offset
+	for start > 0 && (isNameChar(text[start-1]) || text[start-1] == ':') {
+		start--
+	}
+	word := text[start:offset]
+	if i := strings.LastIndex(word, "::"); i >= 0 {
+		start += i + 2
+		word = text[start:offset]
+	}
+	before := strings.TrimRight(text[:start], " \t\r\n")
+	items := []completionItem{}
+	add := func(label string, kind int, detail, insert string) {
+		if strings.HasPrefix(label, word) {
+			items = append(items, completionItem{Label: label, Kind: kind, Detail: detail, InsertText: insert})
+		}
+	}
+	if strings.HasSuffix(before, "@") || strings.HasSuffix(before, "attribute::") {
+		for _, name := range s.attributes {
+			add(name, kindProperty, "attribute", "")
+		}
+		return items
+	}
+	caps := xpath.Capabilities()
+	if !strings.HasSuffix(before, "::") {
+		for _, name := range caps.Functions {
+			add(name, kindFunction, formatSignature(name, caps.Signatures[name]), name+"(")
+		}
+		for _, name := range caps.Axes {
+			add(name, kindKeyword, "axis", name+"::")
+		}
+	}
+	for _, test := range caps.NodeTests {
+		add(test, kindKeyword, "node test", "")
+	}
+	for _, name := range s.elements {
+		add(name, kindField, "element", "")
+	}
+	return items
+}
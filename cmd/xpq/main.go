@@ -0,0 +1,405 @@
+// Command xpq evaluates an XPath expression on XML or HTML files, or on
+// the standard input, as xmllint --xpath does, with the xpath package:
+//
+//	xpq [flags] expr [file ...]
+//	xpq -i [flags] file
+//	xpq sel [sel-flags] -t template-options... [file ...]
+//	xpq serve [serve-flags]
+//
+// The expression is evaluated from the document node of each file; the
+// file - is the standard input, which is also read if there are no files.
+// The files whose name ends with .html or .htm, and all of them with
+// -html, are parsed as HTML close to XML, see dom.ParseOptions.HTML.
+//
+// The flags are:
+//
+//	-ns prefix=uri
+//		binds the prefix of the expression to the namespace URI; it
+//		may be repeated.
+//	-var name=value
+//		binds the variable $name to value, a number if it is one, or
+//		else a string; it may be repeated.
+//	-html
+//		parses all the files as HTML.
+//	-i
+//		explores the file interactively: the expressions entered are
+//		evaluated from a context node, which the command cd moves, and
+//		the paths of the nodes are printed with their values. The tab
+//		key completes the names of the functions and axes; help lists
+//		the commands.
+//	-o mode
+//		prints the nodes of a node-set, or the value of another result,
+//		as:
+//		values	their string-values, one per line (the default)
+//		count	their number
+//		xml	their serializations, one per line
+//		json	a JSON object per file, whose result is the value, or
+//			the array of the path and the string-value of the nodes
+//		csv	a CSV table per file, with a header, of the value, or of
+//			the path and the string-value of the nodes
+//
+// The sel mode runs templates as the sel command of xmlstarlet does, so
+// that its scripts can use the package: the template options are -m expr
+// (for each node of expr), -i expr, --elif expr and --else (conditions),
+// which -b or the end of the template ends, -v expr (the string-value),
+// -c expr (a copy of the nodes), -o text and -n (a new line). Each -t
+// starts a template, run in turn on each file. The namespaces declared
+// by the document element are bound, its default one to _, with those of
+// -N prefix=uri; -T outputs text rather than escaped XML, -Q nothing but
+// the exit status, and --html parses the files as HTML.
+//
+// The serve mode is an HTTP service evaluating expressions on the
+// documents posted to it, for the programs that cannot embed the
+// package; see the flags of xpq serve -h for its address and limits. A
+// POST to /evaluate of the JSON object
+//
+//	{"document": "<r>...</r>", "html": false, "namespaces": {"a": "urn:a"},
+//	 "expressions": ["//a:b", "count(//c)"]}
+//
+// returns {"results": [...]}, with for each expression an object with the
+// expr and either the result, as in the json mode, or the error. The
+// evaluations have no document resolver, so doc() fails.
+//
+// With several files, each line of the values, count and xml modes starts
+// with the name of the file, as with grep. The exit status is 0 if a
+// node-set is not empty or the result is not a node-set, 1 if the
+// node-sets are empty, and 2 if there is an error.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// bindings is the value of a repeated prefix=uri or name=value flag.
+type bindings map[string]string
+
+func (b bindings) String() string {
+	var list []string
+	for _, k := range sortedKeys(b) {
+		list = append(list, k+"="+b[k])
+	}
+	return strings.Join(list, ",")
+}
+
+func (b bindings) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return errors.New("want name=value")
+	}
+	b[k] = v
+	return nil
+}
+
+// params returns the values of the variables of b for xpath.Bind: a
+// number if the value is one, or else a string.
+func (b bindings) params() map[string]interface{} {
+	params := make(map[string]interface{}, len(b))
+	for k, v := range b {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			params[k] = f
+		} else {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+func sortedKeys(m map[string]string) []string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// run runs the command with the arguments args, and returns its exit
+// status.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "sel":
+			return sel(args[1:], stdin, stdout, stderr)
+		case "serve":
+			return serve(args[1:], stderr)
+		}
+	}
+	flags := flag.NewFlagSet("xpq", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: xpq [flags] expr [file ...]\n       xpq -i [flags] file\n       xpq sel [sel-flags] -t template-options... [file ...]\n       xpq serve [serve-flags]")
+		flags.PrintDefaults()
+	}
+	namespaces, vars := bindings{}, bindings{}
+	flags.Var(namespaces, "ns", "bind a namespace `prefix=uri` of the expression")
+	flags.Var(vars, "var", "bind the variable `name=value`")
+	html := flags.Bool("html", false, "parse the files as HTML")
+	mode := flags.String("o", "values", "output `mode`: values, count, xml, json or csv")
+	interactive := flags.Bool("i", false, "explore the file interactively")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *interactive {
+		if flags.NArg() != 1 {
+			flags.Usage()
+			return 2
+		}
+		doc, err := parseFile(flags.Arg(0), nil, *html)
+		if err != nil {
+			fmt.Fprintf(stderr, "xpq: %v\n", err)
+			return 2
+		}
+		var r lineReader = &scanner{bufio.NewScanner(stdin)}
+		if f, ok := stdin.(*os.File); ok {
+			r = newLineReader(f, stdin, stdout)
+		}
+		repl(r, stdout, doc, namespaces, vars)
+		return 0
+	}
+	if flags.NArg() < 1 {
+		flags.Usage()
+		return 2
+	}
+	out, ok := outputs[*mode]
+	if !ok {
+		fmt.Fprintf(stderr, "xpq: unknown output mode %q\n", *mode)
+		return 2
+	}
+
+	exp, err := compile(flags.Arg(0), namespaces, vars)
+	if err != nil {
+		fmt.Fprintf(stderr, "xpq: %v\n", err)
+		return 2
+	}
+
+	files := flags.Args()[1:]
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	status := 1
+	for _, name := range files {
+		doc, err := parseFile(name, stdin, *html)
+		if err != nil {
+			fmt.Fprintf(stderr, "xpq: %s: %v\n", name, err)
+			status = 2
+			continue
+		}
+		prefix := ""
+		if len(files) > 1 {
+			prefix = name + ":"
+		}
+		found, err := out(stdout, prefix, name, exp, doc)
+		if err != nil {
+			fmt.Fprintf(stderr, "xpq: %s: %v\n", name, err)
+			status = 2
+			continue
+		}
+		if found && status == 1 {
+			status = 0
+		}
+	}
+	return status
+}
+
+// parseFile parses the file name, or stdin for -.
+func parseFile(name string, stdin io.Reader, html bool) (*dom.Node, error) {
+	r := stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".html", ".htm":
+		html = true
+	}
+	return dom.ParseWithOptions(r, dom.ParseOptions{HTML: html})
+}
+
+// compile compiles expr with the prefixes of namespaces and the values
+// of vars.
+func compile(expr string, namespaces, vars bindings) (*xpath.Expr, error) {
+	expr, err := xpath.Bind(expr, vars.params())
+	if err != nil {
+		return nil, err
+	}
+	return xpath.CompileWithNS(expr, namespaces)
+}
+
+// An output prints the result of exp on doc, the document of the file
+// name, to w, with prefix at the start of the lines, and reports whether
+// the result is a node-set that is not empty or another value.
+type output func(w io.Writer, prefix, name string, exp *xpath.Expr, doc *dom.Node) (bool, error)
+
+var outputs = map[string]output{
+	"values": func(w io.Writer, prefix, name string, exp *xpath.Expr, doc *dom.Node) (bool, error) {
+		return eachNode(w, prefix, exp, doc, func(nav *dom.NodeNavigator) string {
+			return nav.Value()
+		})
+	},
+	"count": func(w io.Writer, prefix, name string, exp *xpath.Expr, doc *dom.Node) (bool, error) {
+		nodes, err := evaluate(exp, dom.CreateNavigator(doc))
+		if err != nil {
+			return false, err
+		}
+		list, ok := nodes.([]*dom.NodeNavigator)
+		if !ok {
+			return false, errors.New("the expression is not a node-set")
+		}
+		_, err = fmt.Fprintf(w, "%s%d\n", prefix, len(list))
+		return len(list) > 0, err
+	},
+	"xml": func(w io.Writer, prefix, name string, exp *xpath.Expr, doc *dom.Node) (bool, error) {
+		return eachNode(w, prefix, exp, doc, serialize)
+	},
+	"json": func(w io.Writer, prefix, name string, exp *xpath.Expr, doc *dom.Node) (bool, error) {
+		result, found, err := marshal(exp, doc, xpath.ResultJSON)
+		if err != nil {
+			return false, err
+		}
+		data, err := json.Marshal(struct {
+			File   string          `json:"file"`
+			Result json.RawMessage `json:"result"`
+		}{name, result})
+		if err != nil {
+			return false, err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return found, err
+	},
+	"csv": func(w io.Writer, prefix, name string, exp *xpath.Expr, doc *dom.Node) (bool, error) {
+		result, found, err := marshal(exp, doc, xpath.ResultCSV)
+		if err != nil {
+			return false, err
+		}
+		_, err = w.Write(result)
+		return found, err
+	},
+}
+
+// marshal returns the result of exp on doc in the format, as
+// xpath.MarshalResults does, and whether it is a node-set that is not
+// empty or another value.
+func marshal(exp *xpath.Expr, doc *dom.Node, format xpath.ResultFormat) ([]byte, bool, error) {
+	v, err := evaluate(exp, dom.CreateNavigator(doc))
+	if err != nil {
+		return nil, false, err
+	}
+	return marshalValue(v, format)
+}
+
+// marshalValue is marshal for the value v of evaluate.
+func marshalValue(v interface{}, format xpath.ResultFormat) ([]byte, bool, error) {
+	found := true
+	if list, ok := v.([]*dom.NodeNavigator); ok {
+		nodes := make([]xpath.NodeNavigator, len(list))
+		for i, nav := range list {
+			nodes[i] = nav
+		}
+		found, v = len(list) > 0, nodes
+	}
+	data, err := xpath.MarshalResults(v, xpath.MarshalOptions{Format: format})
+	return data, found, err
+}
+
+// evaluate returns the value of exp from the node of nav, with the nodes
+// of a node-set as a []*dom.NodeNavigator.
+func evaluate(exp *xpath.Expr, nav *dom.NodeNavigator) (interface{}, error) {
+	return evaluateWithOptions(exp, nav, xpath.EvalOptions{RecoverPanics: true})
+}
+
+// evaluateWithOptions is evaluate with opts.
+func evaluateWithOptions(exp *xpath.Expr, nav *dom.NodeNavigator, opts xpath.EvalOptions) (interface{}, error) {
+	v, err := exp.EvaluateWithOptions(nav.Copy(), opts)
+	if err != nil {
+		return nil, err
+	}
+	iter, ok := v.(*xpath.NodeIterator)
+	if !ok {
+		return v, nil
+	}
+	list := []*dom.NodeNavigator{}
+	for iter.MoveNext() {
+		nav := iter.Current().Copy()
+		for {
+			w, ok := nav.(interface{ Unwrap() xpath.NodeNavigator })
+			if !ok {
+				break
+			}
+			nav = w.Unwrap()
+		}
+		list = append(list, nav.(*dom.NodeNavigator))
+	}
+	return list, iter.Err()
+}
+
+// eachNode prints the text of each node of the node-set of exp on doc, or
+// the value of exp if it is not a node-set, on a line.
+func eachNode(w io.Writer, prefix string, exp *xpath.Expr, doc *dom.Node, text func(*dom.NodeNavigator) string) (bool, error) {
+	v, err := evaluate(exp, dom.CreateNavigator(doc))
+	if err != nil {
+		return false, err
+	}
+	list, ok := v.([]*dom.NodeNavigator)
+	if !ok {
+		_, err := fmt.Fprintf(w, "%s%s\n", prefix, formatValue(v))
+		return true, err
+	}
+	for _, nav := range list {
+		if _, err := fmt.Fprintf(w, "%s%s\n", prefix, text(nav)); err != nil {
+			return false, err
+		}
+	}
+	return len(list) > 0, nil
+}
+
+// formatValue formats a number, string or boolean as the string function
+// converts it.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// serialize returns the XML of the node of nav: name="value" for an
+// attribute, and the children of the document node.
+func serialize(nav *dom.NodeNavigator) string {
+	if a := nav.CurrentAttr(); a != nil {
+		return a.QName() + `="` + strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;").Replace(a.Value) + `"`
+	}
+	n := nav.Current()
+	return n.OutputXML(n.Type != dom.DocumentNode)
+}
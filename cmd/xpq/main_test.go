@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+const doc = `<r xmlns:a="urn:a"><a:b id="1">x</a:b><b id="2">y &amp; z</b><!--c--></r>`
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	xmlFile := filepath.Join(dir, "doc.xml")
+	htmlFile := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(xmlFile, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(htmlFile, []byte(`<p class=a>one<br>two</p>`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		args   []string
+		stdout string
+		status int
+	}{
+		{[]string{"//b"}, "y & z\n", 0},
+		{[]string{"-ns", "x=urn:a", "//x:b | //@id"}, "x\n1\n2\n", 0},
+		{[]string{"count(//b) + 1"}, "2\n", 0},
+		{[]string{"1 div 0"}, "Infinity\n", 0},
+		{[]string{"//b = 'q'"}, "false\n", 0},
+		{[]string{"//nothing"}, "", 1},
+		{[]string{"-o", "count", "//@*"}, "2\n", 0},
+		{[]string{"-o", "xml", "//b | //@id | //comment()"}, "id=\"1\"\n<b id=\"2\">y &amp; z</b>\nid=\"2\"\n<!--c-->\n", 0},
+		{[]string{"-o", "json", "//@id"}, `{"file":"-","result":[{"path":"/r[1]/a:b[1]/@id","value":"1"},{"path":"/r[1]/b[1]/@id","value":"2"}]}` + "\n", 0},
+		{[]string{"-o", "json", "count(//b)"}, `{"file":"-","result":1}` + "\n", 0},
+		{[]string{"-o", "csv", "//@id"}, "path,value\n/r[1]/a:b[1]/@id,1\n/r[1]/b[1]/@id,2\n", 0},
+		{[]string{"-o", "csv", "//b = 'q'"}, "value\nfalse\n", 0},
+		{[]string{"-var", "id=2", "-var", "text=it's \"q\"", "//b[@id = $id]/@id | //b[. != $text]/@id"}, "2\n", 0},
+		// The references in literals are not replaced.
+		{[]string{"-var", "id=q", "concat('$id', $id)"}, "$idq\n", 0},
+		{[]string{"-var", "n=-1", "3-$n"}, "4\n", 0},
+		{[]string{"string(//p)", htmlFile}, "onetwo\n", 0},
+		{[]string{"-o", "count", "//b", "-", htmlFile}, "-:1\n" + htmlFile + ":0\n", 0},
+		{[]string{"//b[", "-"}, "", 2},
+		{[]string{"$x"}, "", 2},
+		{[]string{"-o", "count", "1"}, "", 2},
+		{[]string{"-o", "yaml", "1"}, "", 2},
+		{[]string{"//b", filepath.Join(dir, "missing.xml")}, "", 2},
+		{[]string{"//b", htmlFile, "-"}, "-:y & z\n", 0},
+		{nil, "", 2},
+	} {
+		var stdout, stderr bytes.Buffer
+		status := run(test.args, strings.NewReader(doc), &stdout, &stderr)
+		if status != test.status || stdout.String() != test.stdout {
+			t.Errorf("xpq %q: status %d, output %q, want %d, %q\n%s", test.args, status, stdout.String(), test.status, test.stdout, stderr.String())
+		}
+	}
+}
+
+func TestSel(t *testing.T) {
+	const feed = `<feed xmlns="urn:f" xmlns:m="urn:m"><e n="1"><t>a &amp; b</t></e><e n="2"><t>c</t><m:x/></e></feed>`
+	for _, test := range []struct {
+		args   []string
+		stdout string
+		status int
+	}{
+		{[]string{"sel", "-t", "-m", "//_:e", "-v", "@n", "-o", ":", "-v", "_:t", "-n"}, "1:a &amp; b\n2:c\n", 0},
+		{[]string{"sel", "-T", "-t", "-m", "//_:e", "-v", "_:t", "-b", "-o", "end", "-n"}, "a & bc" + "end\n", 0},
+		{[]string{"sel", "-t", "-v", "count(//m:x)", "-t", "-o", "|", "-c", "//_:t[2]", "-v", "//nothing"}, "1|", 0},
+		{[]string{"sel", "-t", "-c", "//_:e[2]/_:t"}, `<t>c</t>`, 0},
+		{[]string{"sel", "-N", "f=urn:f", "-t", "-m", "//f:e", "-i", "@n = 1", "-o", "one", "--elif", "m:x", "-o", "x", "--else", "-o", "none", "-b", "-o", ";"}, "one;x;", 0},
+		{[]string{"sel", "-t", "-m", "//nothing", "-v", "."}, "", 1},
+		{[]string{"sel", "-Q", "-t", "-v", "1"}, "", 0},
+		{[]string{"sel", "-t", "-m", "//_:e["}, "", 2},
+		{[]string{"sel", "-t", "-b"}, "", 2},
+		{[]string{"sel", "-t", "--else"}, "", 2},
+		{[]string{"sel", "-t", "-x"}, "", 2},
+		{[]string{"sel", "-v", "1"}, "", 2},
+	} {
+		var stdout, stderr bytes.Buffer
+		status := run(test.args, strings.NewReader(feed), &stdout, &stderr)
+		if status != test.status || stdout.String() != test.stdout {
+			t.Errorf("xpq %q: status %d, output %q, want %d, %q\n%s", test.args, status, stdout.String(), test.status, test.stdout, stderr.String())
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	lim := serveLimits{maxBody: 256, maxExprs: 3, maxNodes: 100, maxStringBytes: 1 << 10, timeout: time.Second}
+	srv := httptest.NewServer(newServeHandler(lim))
+	defer srv.Close()
+	for _, test := range []struct {
+		method, body string
+		status       int
+		want         string
+	}{
+		{"POST", `{"document": "<r xmlns='urn:r'><a>1</a><a>2</a></r>", "namespaces": {"r": "urn:r"}, "expressions": ["sum(//r:a)", "//r:a[2]", "//r:a[", "doc('x.xml')"]}`, 400, `{"error":"more than 3 expressions"}`},
+		{"POST", `{"document": "<r xmlns='urn:r'><a>1</a><a>2</a></r>", "namespaces": {"r": "urn:r"}, "expressions": ["sum(//r:a)", "//r:a[2]", "//r:a["]}`, 200, `{"results":[{"expr":"sum(//r:a)","result":3},{"expr":"//r:a[2]","result":[{"path":"/r[1]/a[2]","value":"2"}]},{"expr":"//r:a[","error":"xpath: syntax error at offset 6: expected expression, found end of expression\n\t//r:a[\n\t      ^"}]}`},
+		{"POST", `{"document": "<p>a<br>b", "html": true, "expressions": ["count(//br)", "count(doc('x.xml'))"]}`, 200, `{"results":[{"expr":"count(//br)","result":1},{"expr":"count(doc('x.xml'))","error":"xpath: evaluating count(doc('x.xml')) at offset 0 of count(doc('x.xml')): xpath: doc(\"x.xml\"): xpath: no document resolver"}]}`},
+		{"POST", `{"document": "<r>", "expressions": ["1"]}`, 400, ""},
+		{"POST", `{"document": "` + strings.Repeat("x", 256) + `"}`, 413, ""},
+		{"POST", `{`, 400, ""},
+		{"GET", "", 405, `{"error":"use POST"}`},
+	} {
+		req, _ := http.NewRequest(test.method, srv.URL+"/evaluate", strings.NewReader(test.body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != test.status || test.want != "" && strings.TrimSpace(body.String()) != test.want {
+			t.Errorf("%s %s: %d %s, want %d %s", test.method, test.body, resp.StatusCode, body.String(), test.status, test.want)
+		}
+	}
+	doc, err := dom.Parse(strings.NewReader("<r>" + strings.Repeat("<a/>", 200) + "</r>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := serveEvaluate("count(reverse(//a))", nil, doc, xpath.EvalOptions{MaxBufferedNodes: 100, RecoverPanics: true})
+	if res.Error == "" {
+		t.Errorf("count(reverse(//a)) over 200 nodes: %s, want an error of the limit", res.Result)
+	}
+}
+
+func TestParams(t *testing.T) {
+	params := bindings{"n": "1.5", "m": "-2", "s": "abc", "nan": "NaN"}.params()
+	for k, want := range map[string]interface{}{"n": 1.5, "m": float64(-2), "s": "abc", "nan": "NaN"} {
+		if got := params[k]; got != want {
+			t.Errorf("params()[%q] = %#v, want %#v", k, got, want)
+		}
+	}
+}
+
+func TestInteractive(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "doc.xml")
+	if err := os.WriteFile(file, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	input := strings.Join([]string{
+		"ls",
+		"cd r",
+		"pwd",
+		"ls",
+		"b",
+		"count(*)",
+		"cd *",
+		"cd b",
+		"ns x=urn:a",
+		"cd ../x:b",
+		"@id",
+		"var v=1",
+		"../*[@id = $v]",
+		"cd",
+		"pwd",
+		"//b[",
+		"quit",
+		"1",
+	}, "\n")
+	var stdout, stderr bytes.Buffer
+	if status := run([]string{"-i", file}, strings.NewReader(input), &stdout, &stderr); status != 0 {
+		t.Fatalf("status %d: %s", status, stderr.String())
+	}
+	want := `r[1]
+/r[1]
+a:b[1]
+b[1]
+comment()[1]
+/r[1]/b[1]	"y & z"
+(1 nodes)
+2
+* selects 2 nodes, want 1
+/r[1]/a:b[1]/@id	"1"
+(1 nodes)
+/r[1]/a:b[1]	"x"
+(1 nodes)
+/
+`
+	got := stdout.String()
+	if i := strings.LastIndex(got, "/\n"); i >= 0 {
+		// The syntax error, last, is that of Compile.
+		got = got[:i+2]
+	}
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	for _, test := range []struct {
+		line string
+		want []string
+	}{
+		{"//a[starts-w", []string{"//a[starts-with("}},
+		{"//a[conc", []string{"//a[concat("}},
+		{"following", []string{"following-sibling::", "following::"}},
+		{"c", []string{"cd ", "ceiling(", "child::", "compare(", "concat(", "contains(", "count("}},
+		{"//b[c", []string{"//b[ceiling(", "//b[child::", "//b[compare(", "//b[concat(", "//b[contains(", "//b[count("}},
+		{"//bo", []string{"//boolean("}},
+		{"//zz", nil},
+		{"", nil},
+	} {
+		got := complete(test.line)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("complete(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+	if p := commonPrefix([]string{"following-sibling::", "following::"}); p != "following" {
+		t.Errorf("commonPrefix = %q", p)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+const replHelp = `Enter an expression to evaluate it from the context node, or a command:
+  cd [expr]        make the node of expr the context node, or the document
+  pwd              print the path of the context node
+  ls [expr]        list the children of the context node, or the nodes of expr
+  ns prefix=uri    bind a namespace prefix
+  var name=value   bind a variable
+  help             print this help
+  quit             exit
+The names of the commands are element names after ./ or child::, as ./cd.
+Tab completes the names of the functions, axes and commands.
+`
+
+// A session is the state of the interactive mode: the document, its
+// context node, and the bindings of the expressions.
+type session struct {
+	w          io.Writer
+	ctx        *dom.NodeNavigator
+	namespaces bindings
+	vars       bindings
+}
+
+// repl runs the interactive mode on doc, reading the lines with r until
+// the end of the input or the quit command.
+func repl(r lineReader, w io.Writer, doc *dom.Node, namespaces, vars bindings) {
+	s := &session{w: w, ctx: dom.CreateNavigator(doc), namespaces: namespaces, vars: vars}
+	for {
+		line, err := r.readLine(xpath.NodePath(s.ctx) + "> ")
+		if err != nil {
+			return
+		}
+		if !s.exec(strings.TrimSpace(line)) {
+			return
+		}
+	}
+}
+
+// commands are the commands of the interactive mode.
+var commands = []string{"cd", "help", "ls", "ns", "pwd", "quit", "var"}
+
+// exec executes the line, and reports whether to read another one.
+func (s *session) exec(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "":
+	case "quit", "exit":
+		return false
+	case "help":
+		fmt.Fprint(s.w, replHelp)
+	case "pwd":
+		fmt.Fprintln(s.w, xpath.NodePath(s.ctx))
+	case "cd":
+		if arg == "" {
+			s.ctx.MoveToRoot()
+			break
+		}
+		nodes, err := s.nodes(arg)
+		switch {
+		case err != nil:
+			fmt.Fprintln(s.w, err)
+		case len(nodes) != 1:
+			fmt.Fprintf(s.w, "%s selects %d nodes, want 1\n", arg, len(nodes))
+		default:
+			s.ctx = nodes[0]
+		}
+	case "ls":
+		if arg == "" {
+			arg = "node()"
+		}
+		nodes, err := s.nodes(arg)
+		if err != nil {
+			fmt.Fprintln(s.w, err)
+			break
+		}
+		// The children are listed by their path from the context node.
+		dir := strings.TrimSuffix(xpath.NodePath(s.ctx), "/") + "/"
+		for _, nav := range nodes {
+			if arg == "node()" {
+				fmt.Fprintln(s.w, strings.TrimPrefix(xpath.NodePath(nav), dir))
+			} else {
+				fmt.Fprintln(s.w, xpath.NodePath(nav))
+			}
+		}
+	case "ns", "var":
+		b := s.namespaces
+		if name == "var" {
+			b = s.vars
+		}
+		if arg == "" {
+			fmt.Fprintln(s.w, b)
+		} else if err := b.Set(arg); err != nil {
+			fmt.Fprintf(s.w, "%s: %v\n", name, err)
+		}
+	default:
+		s.eval(line)
+	}
+	return true
+}
+
+// compile compiles expr with the bindings of the session.
+func (s *session) compile(expr string) (*xpath.Expr, error) {
+	return compile(expr, s.namespaces, s.vars)
+}
+
+// nodes returns the nodes of the node-set of expr.
+func (s *session) nodes(expr string) ([]*dom.NodeNavigator, error) {
+	exp, err := s.compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	v, err := evaluate(exp, s.ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]*dom.NodeNavigator)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a node-set", expr)
+	}
+	return nodes, nil
+}
+
+// eval prints the value of expr, and the path and the value of each node
+// of a node-set.
+func (s *session) eval(expr string) {
+	exp, err := s.compile(expr)
+	if err != nil {
+		fmt.Fprintln(s.w, err)
+		return
+	}
+	v, err := evaluate(exp, s.ctx)
+	if err != nil {
+		fmt.Fprintln(s.w, err)
+		return
+	}
+	nodes, ok := v.([]*dom.NodeNavigator)
+	if !ok {
+		fmt.Fprintln(s.w, formatValue(v))
+		return
+	}
+	for _, nav := range nodes {
+		fmt.Fprintf(s.w, "%s\t%q\n", xpath.NodePath(nav), nav.Value())
+	}
+	fmt.Fprintf(s.w, "(%d nodes)\n", len(nodes))
+}
+
+// complete returns the completions of the last word of line: the names of
+// the functions, followed by (, of the axes, followed by ::, and of the
+// commands at the start of the line.
+func complete(line string) []string {
+	i := len(line)
+	for i > 0 && isWordChar(line[i-1]) {
+		i--
+	}
+	word := line[i:]
+	if word == "" {
+		return nil
+	}
+	var list []string
+	if i == 0 {
+		for _, name := range commands {
+			if strings.HasPrefix(name, word) {
+				list = append(list, name+" ")
+			}
+		}
+	}
+	for name := range xpath.Capabilities().Signatures {
+		if strings.HasPrefix(name, word) {
+			list = append(list, name+"(")
+		}
+	}
+	for _, name := range axes {
+		if strings.HasPrefix(name, word) {
+			list = append(list, name+"::")
+		}
+	}
+	sort.Strings(list)
+	for j := range list {
+		list[j] = line[:i] + list[j]
+	}
+	return list
+}
+
+// isWordChar reports whether c is a character of the names complete
+// completes, which have no prefix.
+func isWordChar(c byte) bool {
+	return c == '_' || c == '-' || c >= 0x80 ||
+		'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+var axes = []string{
+	"ancestor", "ancestor-or-self", "attribute", "child", "descendant",
+	"descendant-or-self", "following", "following-sibling", "namespace",
+	"parent", "preceding", "preceding-sibling", "self",
+}
+
+// commonPrefix returns the longest prefix of the strings of list.
+func commonPrefix(list []string) string {
+	p := list[0]
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, p) {
+			p = p[:len(p)-1]
+		}
+	}
+	return p
+}
+
+// A lineReader reads the lines of the interactive mode.
+type lineReader interface {
+	// readLine returns the next line, without its end, or io.EOF.
+	readLine(prompt string) (string, error)
+}
+
+// newLineReader returns a lineReader editing the lines in the terminal
+// f, or reading them from r if f is not a terminal with a raw mode.
+func newLineReader(f *os.File, r io.Reader, w io.Writer) lineReader {
+	if restore, err := makeRaw(f); err == nil {
+		restore()
+		return &terminal{f: f, w: w}
+	}
+	return &scanner{bufio.NewScanner(r)}
+}
+
+// scanner is the lineReader of the lines not read from a terminal, with
+// no prompt.
+type scanner struct {
+	*bufio.Scanner
+}
+
+func (s *scanner) readLine(prompt string) (string, error) {
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return s.Text(), nil
+}
+
+// terminal is the lineReader of a terminal, in raw mode while it reads a
+// line, where the keys edit the line: backspace removes the last
+// character, tab completes the last word, Ctrl-C clears the line, and
+// Ctrl-D on an empty line ends the input.
+type terminal struct {
+	f *os.File
+	w io.Writer
+}
+
+func (t *terminal) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(t.f)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+	fmt.Fprint(t.w, prompt)
+	var line []byte
+	key := make([]byte, 1)
+	for {
+		if _, err := t.f.Read(key); err != nil {
+			return "", err
+		}
+		switch c := key[0]; c {
+		case '\r', '\n':
+			fmt.Fprint(t.w, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			line = line[:0]
+			fmt.Fprint(t.w, "^C\r\n"+prompt)
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(t.w, "\r\n")
+				return "", io.EOF
+			}
+		case 127, '\b':
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+				fmt.Fprint(t.w, "\b \b")
+			}
+		case '\t':
+			list := complete(string(line))
+			if len(list) == 0 {
+				break
+			}
+			if p := commonPrefix(list); len(p) > len(line) {
+				fmt.Fprint(t.w, p[len(line):])
+				line = []byte(p)
+				break
+			}
+			fmt.Fprint(t.w, "\r\n"+strings.Join(list, "  ")+"\r\n"+prompt+string(line))
+		case 27: // Escape: ignore the sequences of the arrows.
+			t.f.Read(key)
+			if key[0] == '[' {
+				t.f.Read(key)
+			}
+		default:
+			if c >= ' ' {
+				line = append(line, c)
+				t.w.Write(key)
+			}
+		}
+	}
+}
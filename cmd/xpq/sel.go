@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// A selStep is an option of a template of the sel mode: -m, -i, --elif
+// and --else with the steps of their block, -v, -c, -o or -n.
+type selStep struct {
+	op   string
+	arg  string
+	exp  *xpath.Expr
+	body []*selStep
+}
+
+// The long names of the options of the templates.
+var selOptions = map[string]string{
+	"--match":    "-m",
+	"--if":       "-i",
+	"--value-of": "-v",
+	"--copy-of":  "-c",
+	"--output":   "-o",
+	"--nl":       "-n",
+	"--break":    "-b",
+	"--template": "-t",
+}
+
+// selParser parses the templates of the arguments of the sel mode.
+type selParser struct {
+	args []string
+	pos  int
+}
+
+func (p *selParser) peek() string {
+	if p.pos < len(p.args) {
+		if op, ok := selOptions[p.args[p.pos]]; ok {
+			return op
+		}
+		return p.args[p.pos]
+	}
+	return ""
+}
+
+// block parses the steps up to the end of the block, at -b, or of the
+// template, and returns them. depth is the number of the blocks the
+// steps are in, and cond reports whether the block is that of -i or
+// --elif, which --elif and --else end.
+func (p *selParser) block(depth int, cond bool) ([]*selStep, error) {
+	var steps []*selStep
+	for {
+		op := p.peek()
+		switch op {
+		case "-m", "-i", "--elif", "--else":
+			if op == "--elif" || op == "--else" {
+				if cond {
+					return steps, nil
+				}
+				if len(steps) == 0 || steps[len(steps)-1].op != "-i" && steps[len(steps)-1].op != "--elif" {
+					return nil, fmt.Errorf("%s without -i", op)
+				}
+			}
+			step := &selStep{op: op}
+			p.pos++
+			if op != "--else" {
+				if p.pos == len(p.args) {
+					return nil, fmt.Errorf("%s needs an expression", op)
+				}
+				step.arg = p.args[p.pos]
+				p.pos++
+			}
+			body, err := p.block(depth+1, op == "-i" || op == "--elif")
+			if err != nil {
+				return nil, err
+			}
+			step.body = body
+			steps = append(steps, step)
+			if p.peek() == "-b" {
+				// -b ends the block of -m, or the -i, --elif and --else
+				// chain, which the end of the template ends too.
+				p.pos++
+			}
+		case "-v", "-c", "-o":
+			p.pos++
+			if p.pos == len(p.args) {
+				return nil, fmt.Errorf("%s needs an argument", op)
+			}
+			steps = append(steps, &selStep{op: op, arg: p.args[p.pos]})
+			p.pos++
+		case "-n":
+			p.pos++
+			steps = append(steps, &selStep{op: op})
+		case "-b":
+			if depth == 0 {
+				return nil, errors.New("-b outside of a block")
+			}
+			return steps, nil
+		default:
+			if strings.HasPrefix(op, "-") && op != "-" && op != "-t" {
+				return nil, fmt.Errorf("unknown template option %s", op)
+			}
+			return steps, nil
+		}
+	}
+}
+
+// sel runs the sel mode, compatible with that of xmlstarlet, with its
+// arguments args, and returns the exit status.
+func sel(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	namespaces := bindings{}
+	text, quiet, html := false, false, false
+	i := 0
+	for ; i < len(args) && args[i] != "-t" && args[i] != "--template"; i++ {
+		switch args[i] {
+		case "-T", "--text":
+			text = true
+		case "-Q", "--quiet":
+			quiet = true
+		case "--html":
+			html = true
+		case "-N":
+			i++
+			if i == len(args) {
+				fmt.Fprintln(stderr, "xpq sel: -N needs prefix=uri")
+				return 2
+			}
+			if err := namespaces.Set(args[i]); err != nil {
+				fmt.Fprintf(stderr, "xpq sel: -N %s: %v\n", args[i], err)
+				return 2
+			}
+		default:
+			fmt.Fprintf(stderr, "xpq sel: unknown option %s\n", args[i])
+			return 2
+		}
+	}
+	p := &selParser{args: args, pos: i}
+	var templates [][]*selStep
+	for p.peek() == "-t" {
+		p.pos++
+		steps, err := p.block(0, false)
+		if err != nil {
+			fmt.Fprintf(stderr, "xpq sel: %v\n", err)
+			return 2
+		}
+		templates = append(templates, steps)
+	}
+	if len(templates) == 0 {
+		fmt.Fprintln(stderr, "usage: xpq sel [-T] [-Q] [--html] [-N prefix=uri] -t template-options... [file ...]")
+		return 2
+	}
+	files := args[p.pos:]
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	w := stdout
+	if quiet {
+		w = io.Discard
+	}
+	status := 1
+	for _, name := range files {
+		doc, err := parseFile(name, stdin, html)
+		if err != nil {
+			fmt.Fprintf(stderr, "xpq sel: %s: %v\n", name, err)
+			status = 2
+			continue
+		}
+		ns := rootNamespaces(doc)
+		for prefix, uri := range namespaces {
+			ns[prefix] = uri
+		}
+		r := &selRun{w: w, text: text}
+		for _, steps := range templates {
+			if err = compileSteps(steps, ns); err != nil {
+				break
+			}
+			if err = r.run(steps, dom.CreateNavigator(doc)); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "xpq sel: %s: %v\n", name, err)
+			status = 2
+			continue
+		}
+		if r.found && status == 1 {
+			status = 0
+		}
+	}
+	return status
+}
+
+// rootNamespaces returns the namespaces declared by the document element
+// of doc, with the default namespace bound to _, as xmlstarlet binds
+// them.
+func rootNamespaces(doc *dom.Node) map[string]string {
+	ns := make(map[string]string)
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type != dom.ElementNode {
+			continue
+		}
+		for _, a := range n.Attr {
+			switch {
+			case a.Prefix == "xmlns":
+				ns[a.Name] = a.Value
+			case a.Prefix == "" && a.Name == "xmlns":
+				ns["_"] = a.Value
+			}
+		}
+	}
+	return ns
+}
+
+// compileSteps compiles the expressions of steps with the namespaces.
+func compileSteps(steps []*selStep, ns map[string]string) error {
+	for _, s := range steps {
+		switch s.op {
+		case "-m", "-i", "--elif", "-v", "-c":
+			exp, err := xpath.CompileWithNS(s.arg, ns)
+			if err != nil {
+				return fmt.Errorf("%s %s: %v", s.op, s.arg, err)
+			}
+			s.exp = exp
+		}
+		if err := compileSteps(s.body, ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// selRun is the output of the templates of sel on a document.
+type selRun struct {
+	w     io.Writer
+	text  bool
+	found bool // whether a node was matched or a value output
+}
+
+// write writes s, escaped unless the output is text, as that of XSLT.
+func (r *selRun) write(s string, escape bool) error {
+	if escape && !r.text {
+		s = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+	}
+	_, err := io.WriteString(r.w, s)
+	return err
+}
+
+// run runs steps from the node of nav.
+func (r *selRun) run(steps []*selStep, nav *dom.NodeNavigator) error {
+	done := false // whether a branch of the last -i ran
+	for _, s := range steps {
+		var err error
+		switch s.op {
+		case "-m":
+			var v interface{}
+			if v, err = evaluate(s.exp, nav); err != nil {
+				return fmt.Errorf("-m %s: %v", s.arg, err)
+			}
+			list, ok := v.([]*dom.NodeNavigator)
+			if !ok {
+				return fmt.Errorf("-m %s: the expression is not a node-set", s.arg)
+			}
+			for _, n := range list {
+				r.found = true
+				if err = r.run(s.body, n); err != nil {
+					return err
+				}
+			}
+		case "-i", "--elif", "--else":
+			if s.op == "-i" {
+				done = false
+			}
+			if done {
+				continue
+			}
+			ok := true
+			if s.exp != nil {
+				var v interface{}
+				if v, err = evaluate(s.exp, nav); err != nil {
+					return fmt.Errorf("%s %s: %v", s.op, s.arg, err)
+				}
+				ok = truth(v)
+			}
+			if ok {
+				done = true
+				err = r.run(s.body, nav)
+			}
+		case "-v", "-c":
+			var v interface{}
+			if v, err = evaluate(s.exp, nav); err != nil {
+				return fmt.Errorf("%s %s: %v", s.op, s.arg, err)
+			}
+			list, ok := v.([]*dom.NodeNavigator)
+			switch {
+			case !ok:
+				r.found = true
+				err = r.write(formatValue(v), true)
+			case len(list) == 0:
+			case s.op == "-v":
+				r.found = true
+				err = r.write(list[0].Value(), true)
+			default:
+				r.found = true
+				for _, n := range list {
+					if err = r.write(serialize(n), false); err != nil {
+						break
+					}
+				}
+			}
+		case "-o":
+			err = r.write(s.arg, true)
+		case "-n":
+			err = r.write("\n", false)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// truth returns the value v of evaluate as boolean() converts it.
+func truth(v interface{}) bool {
+	switch v := v.(type) {
+	case []*dom.NodeNavigator:
+		return len(v) > 0
+	case float64:
+		return v != 0 && v == v
+	case string:
+		return v != ""
+	}
+	return v == true
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// serveLimits are the limits of the requests of the serve mode.
+type serveLimits struct {
+	maxBody        int64
+	maxExprs       int
+	maxNodes       int
+	maxStringBytes int
+	timeout        time.Duration
+}
+
+// serve runs the serve mode with its arguments args, and returns the exit
+// status.
+func serve(args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("xpq serve", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	addr := flags.String("addr", "localhost:8080", "the `address` to listen on")
+	var lim serveLimits
+	flags.Int64Var(&lim.maxBody, "max-body", 10<<20, "the largest request body, in `bytes`")
+	flags.IntVar(&lim.maxExprs, "max-exprs", 100, "the largest `number` of expressions of a request")
+	flags.IntVar(&lim.maxNodes, "max-nodes", 1e6, "the `number` of nodes an evaluation may buffer, see xpath.EvalOptions")
+	flags.IntVar(&lim.maxStringBytes, "max-string-bytes", 64<<20, "the `bytes` of strings an evaluation may read or build")
+	flags.DurationVar(&lim.timeout, "timeout", 5*time.Second, "the `duration` of the evaluations of a request")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+	srv := &http.Server{Addr: *addr, Handler: newServeHandler(lim), ReadHeaderTimeout: 10 * time.Second}
+	fmt.Fprintf(stderr, "xpq: serving on %s\n", *addr)
+	if err := srv.ListenAndServe(); err != nil {
+		fmt.Fprintf(stderr, "xpq: %v\n", err)
+		return 2
+	}
+	return 0
+}
+
+// evaluateRequest is the body of a request to /evaluate.
+type evaluateRequest struct {
+	Document    string            `json:"document"`
+	HTML        bool              `json:"html"`
+	Namespaces  map[string]string `json:"namespaces"`
+	Expressions []string          `json:"expressions"`
+}
+
+// evaluateResult is the result of an expression of an evaluateRequest.
+type evaluateResult struct {
+	Expr   string          `json:"expr"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// newServeHandler returns the handler of the serve mode.
+func newServeHandler(lim serveLimits) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/evaluate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			serveError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+			return
+		}
+		var req evaluateRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, lim.maxBody)).Decode(&req); err != nil {
+			status := http.StatusBadRequest
+			if strings.Contains(err.Error(), "request body too large") {
+				status = http.StatusRequestEntityTooLarge
+			}
+			serveError(w, status, err)
+			return
+		}
+		if len(req.Expressions) > lim.maxExprs {
+			serveError(w, http.StatusBadRequest, fmt.Errorf("more than %d expressions", lim.maxExprs))
+			return
+		}
+		doc, err := dom.ParseWithOptions(strings.NewReader(req.Document), dom.ParseOptions{HTML: req.HTML})
+		if err != nil {
+			serveError(w, http.StatusBadRequest, fmt.Errorf("document: %v", err))
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), lim.timeout)
+		defer cancel()
+		opts := xpath.EvalOptions{
+			MaxBufferedNodes: lim.maxNodes,
+			MaxStringBytes:   lim.maxStringBytes,
+			RecoverPanics:    true,
+			Context:          ctx,
+		}
+		results := make([]evaluateResult, len(req.Expressions))
+		for i, expr := range req.Expressions {
+			results[i] = serveEvaluate(expr, req.Namespaces, doc, opts)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Results []evaluateResult `json:"results"`
+		}{results})
+	})
+	return mux
+}
+
+// serveEvaluate returns the result of expr on doc.
+func serveEvaluate(expr string, namespaces map[string]string, doc *dom.Node, opts xpath.EvalOptions) evaluateResult {
+	res := evaluateResult{Expr: expr}
+	exp, err := xpath.CompileWithNS(expr, namespaces)
+	if err == nil {
+		var v interface{}
+		if v, err = evaluateWithOptions(exp, dom.CreateNavigator(doc), opts); err == nil {
+			res.Result, _, err = marshalValue(v, xpath.ResultJSON)
+		}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+func serveError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
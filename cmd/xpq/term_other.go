@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw fails: the lines are read without editing.
+func makeRaw(f *os.File) (restore func(), err error) {
+	return nil, errors.New("no raw mode for the terminal")
+}
@@ -0,0 +1,36 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(f *os.File, req uintptr, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), req, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// makeRaw puts the terminal f in raw mode, reading the keys one by one
+// without echoing them, and returns the function restoring its mode. It
+// fails if f is not a terminal.
+func makeRaw(f *os.File) (restore func(), err error) {
+	var old syscall.Termios
+	if err := ioctl(f, ioctlGetTermios, &old); err != nil {
+		return nil, err
+	}
+	raw := old
+	raw.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ISIG | syscall.IEXTEN
+	raw.Iflag &^= syscall.ICRNL | syscall.IXON
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(f, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+	return func() { ioctl(f, ioctlSetTermios, &old) }, nil
+}
@@ -0,0 +1,141 @@
+package xpath
+
+import (
+	"strings"
+	"unicode"
+)
+
+// The URIs of the collations of the optional third argument of contains(),
+// starts-with(), ends-with() and compare(), such as
+// contains(title, 'eclair', 'https://github.com/antchfx/xpath/collation/accent-insensitive'):
+//
+//   - CollationCodepoint compares the strings as they are, as without a
+//     collation.
+//   - CollationASCIICaseInsensitive ignores the case of the ASCII letters,
+//     as the names of HTML.
+//   - CollationCaseFold ignores the case of all the letters, with the
+//     simple case folding of Unicode.
+//   - CollationAccentInsensitive ignores the accents of the Latin letters
+//     and the combining marks, but not the case.
+const (
+	CollationCodepoint            = "http://www.w3.org/2005/xpath-functions/collation/codepoint"
+	CollationASCIICaseInsensitive = "http://www.w3.org/2005/xpath-functions/collation/html-ascii-case-insensitive"
+	CollationCaseFold             = "https://github.com/antchfx/xpath/collation/case-fold"
+	CollationAccentInsensitive    = "https://github.com/antchfx/xpath/collation/accent-insensitive"
+)
+
+// collations are the keys of the strings of the collations, by URI: two
+// strings are equal in a collation if their keys are, and are compared by
+// their keys. The key of the codepoint collation is nil, the string.
+var collations = map[string]func(string) string{
+	CollationCodepoint:            nil,
+	CollationASCIICaseInsensitive: asciiLower,
+	CollationCaseFold:             caseFold,
+	CollationAccentInsensitive:    removeAccents,
+}
+
+func asciiLower(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}, s)
+}
+
+// caseFold replaces each rune of s by the smallest rune it folds to.
+func caseFold(s string) string {
+	return strings.Map(func(r rune) rune {
+		min := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < min {
+				min = f
+			}
+		}
+		return min
+	}, s)
+}
+
+// accentBases are the letters of the accented Latin letters.
+var accentBases = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for _, letters := range []string{
+		"AÀÁÂÃÄÅĀĂĄǍǞǠǺȀȂȦẠẢẤẦẨẪẬẮẰẲẴẶ", "aàáâãäåāăąǎǟǡǻȁȃȧạảấầẩẫậắằẳẵặ",
+		"CÇĆĈĊČ", "cçćĉċč", "DĎĐ", "dďđ",
+		"EÈÉÊËĒĔĖĘĚȄȆȨẸẺẼẾỀỂỄỆ", "eèéêëēĕėęěȅȇȩẹẻẽếềểễệ",
+		"GĜĞĠĢǦǴ", "gĝğġģǧǵ", "HĤĦ", "hĥħ",
+		"IÌÍÎÏĨĪĬĮİǏȈȊỈỊ", "iìíîïĩīĭįıǐȉȋỉị", "JĴ", "jĵǰ", "KĶǨ", "kķǩ",
+		"LĹĻĽĿŁ", "lĺļľŀł", "NÑŃŅŇǸ", "nñńņňǹ",
+		"OÒÓÔÕÖØŌŎŐƠǑǪǬǾȌȎȪȬȮȰỌỎỐỒỔỖỘỚỜỞỠỢ", "oòóôõöøōŏőơǒǫǭǿȍȏȫȭȯȱọỏốồổỗộớờởỡợ",
+		"RŔŖŘȐȒ", "rŕŗřȑȓ", "SŚŜŞŠȘ", "sśŝşšș", "TŢŤŦȚ", "tţťŧț",
+		"UÙÚÛÜŨŪŬŮŰŲƯǓǕǗǙǛȔȖỤỦỨỪỬỮỰ", "uùúûüũūŭůűųưǔǖǘǚǜȕȗụủứừửữự",
+		"WŴ", "wŵ", "YÝŶŸỲỴỶỸ", "yýÿŷỳỵỷỹ", "ZŹŻŽ", "zźżž",
+	} {
+		base := []rune(letters)[0]
+		for _, r := range letters {
+			m[r] = base
+		}
+	}
+	return m
+}()
+
+// removeAccents replaces the accented Latin letters of s by their letter,
+// and removes the combining marks.
+func removeAccents(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+		if base, ok := accentBases[r]; ok {
+			return base
+		}
+		return r
+	}, s)
+}
+
+// A collationArg is the collation argument of a function, looked up when
+// the expression is compiled if it is a literal.
+type collationArg struct {
+	arg query
+	key func(string) string
+	// literal reports whether key is that of the literal arg.
+	literal bool
+}
+
+// newCollationArg returns the collation of the argument arg, which may be
+// nil for none, or an error if it is a literal URI of no collation.
+func newCollationArg(arg query) (*collationArg, error) {
+	if arg == nil {
+		return nil, nil
+	}
+	c := &collationArg{arg: arg}
+	if q, ok := arg.(*constantQuery); ok {
+		uri, _ := q.Val.(string)
+		key, ok := collations[uri]
+		if !ok {
+			return nil, newError(ErrUnknownCollation, nil, "unknown collation %q", uri)
+		}
+		c.key, c.literal = key, true
+	}
+	return c, nil
+}
+
+// keys returns the keys of a and b in the collation, or a and b if there
+// is none.
+func (c *collationArg) keys(t iterator, a, b string) (string, string) {
+	if c == nil {
+		return a, b
+	}
+	key := c.key
+	if !c.literal {
+		uri := asString(t, functionArgs(c.arg).Evaluate(t))
+		var ok bool
+		if key, ok = collations[uri]; !ok {
+			panic(newError(ErrUnknownCollation, nil, "unknown collation %q", uri))
+		}
+	}
+	if key == nil {
+		return a, b
+	}
+	return limitString(t, key(a)), limitString(t, key(b))
+}
@@ -0,0 +1,50 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCollations(t *testing.T) {
+	doc := createNode("", RootNode)
+	doc.createChildNode("title", ElementNode).createChildNode("Crème Brûlée ÉCLAIR", TextNode)
+	doc.createChildNode("uri", ElementNode).createChildNode("https://github.com/antchfx/xpath/collation/case-fold", TextNode)
+	nav := createNavigator(doc)
+	for _, tc := range []struct {
+		expr string
+		want interface{}
+	}{
+		{`contains(/title, 'brûlée')`, false},
+		{`contains(/title, 'BRÛLÉE', 'https://github.com/antchfx/xpath/collation/case-fold')`, true},
+		{`contains(/title, 'BRÛLÉE', 'http://www.w3.org/2005/xpath-functions/collation/html-ascii-case-insensitive')`, false},
+		{`contains(/title, 'CRème', 'http://www.w3.org/2005/xpath-functions/collation/html-ascii-case-insensitive')`, true},
+		{`starts-with(/title, 'Creme Brulee', 'https://github.com/antchfx/xpath/collation/accent-insensitive')`, true},
+		{`starts-with(/title, 'creme', 'https://github.com/antchfx/xpath/collation/accent-insensitive')`, false},
+		{`ends-with(/title, 'ECLAIR', 'https://github.com/antchfx/xpath/collation/accent-insensitive')`, true},
+		// Combining marks are ignored too.
+		{"contains('Crème', 'Creme', 'https://github.com/antchfx/xpath/collation/accent-insensitive')", true},
+		{`contains(/title, 'Crème', 'http://www.w3.org/2005/xpath-functions/collation/codepoint')`, true},
+		{`compare('a', 'b')`, float64(-1)},
+		{`compare('b', 'a')`, float64(1)},
+		{`compare('Straße', 'STRASSE', 'https://github.com/antchfx/xpath/collation/case-fold')`, float64(1)},
+		{`compare(/title, 'CRÈME BRÛLÉE ÉCLAIR', 'https://github.com/antchfx/xpath/collation/case-fold')`, float64(0)},
+		{`compare(/none, '')`, float64(0)},
+		{`compare('ǅ', 'ǆ', concat('https://github.com/antchfx/xpath/collation/', 'case-fold', substring(/title, 100)))`, float64(0)},
+	} {
+		if got := MustCompile(tc.expr).Evaluate(nav); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+
+	// A collation of no static type is converted to a string.
+	exp, err := CompileWithNS(`compare('ǅ', 'ǆ', exsl:node-set(/uri))`, EXSLTNamespaces())
+	assertNoErr(t, err)
+	assertEqual(t, float64(0), exp.Evaluate(nav))
+
+	_, err = Compile(`contains('a', 'b', 'urn:none')`)
+	assertTrue(t, errors.Is(err, ErrUnknownCollation))
+	_, err = MustCompile(`compare(/title, 'a', string(/title))`).EvaluateWithOptions(nav, EvalOptions{RecoverPanics: true})
+	assertTrue(t, errors.Is(err, ErrUnknownCollation))
+	_, err = Compile(`compare('a')`)
+	assertTrue(t, errors.Is(err, ErrArgumentCount))
+}
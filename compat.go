@@ -0,0 +1,542 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Compat is a set of behaviors of another engine that the expressions
+// compiled with it in CompileOptions.Compat reproduce, so that
+// expressions written and tested with that engine give the same results
+// here. SaxonHE and BaseX are those of these XPath 3.1 engines; both
+// select the first x of each parent with //x[1], as this engine does, so
+// the positional predicates need no shim.
+//
+// The results this engine still gives otherwise, such as the string of
+// 1e21, written 1.0E21 by an XPath 3.1 engine, are reported to
+// EvalOptions.Warn as NonStandard warnings naming the engine, but for the
+// constant sub-expressions computed as the expression is compiled, such
+// as string(1 div 0).
+type Compat struct {
+	// Engine is the name of the engine in the warnings, such as
+	// "Saxon-HE". The results that differ from its own are only reported
+	// if it is set.
+	Engine string
+
+	// Characters counts the characters of the strings in string-length()
+	// and substring(), rather than their bytes.
+	Characters bool
+
+	// DoubleNumbers converts strings to numbers as they are cast to
+	// xs:double, in number(), sum() and the arithmetic: a decimal with an
+	// optional sign and exponent, INF, -INF or NaN, between whitespace,
+	// where XPath 1.0 has neither the plus sign, the exponents nor INF.
+	DoubleNumbers bool
+
+	// XSDRegexps reads the patterns of matches() and replace() as the
+	// regular expressions of XPath rather than those of the Go regexp
+	// package: \d and \w are Unicode classes, the escapes \i and \c of
+	// XML names and the blocks such as \p{IsBasicLatin} are supported,
+	// as are the subtractions of classes such as [a-z-[aeiou]], and the
+	// syntax of Go that XPath does not have, such as (?i) or \b, is an
+	// error. In the replacements of replace(), \$ and \\ are a dollar and
+	// a backslash, and a pattern that matches the empty string is an
+	// error.
+	XSDRegexps bool
+}
+
+var (
+	// SaxonHE reproduces Saxon-HE.
+	SaxonHE = Compat{Engine: "Saxon-HE", Characters: true, DoubleNumbers: true, XSDRegexps: true}
+
+	// BaseX reproduces BaseX.
+	BaseX = Compat{Engine: "BaseX", Characters: true, DoubleNumbers: true, XSDRegexps: true}
+)
+
+// compatOf returns the Compat of the expression evaluated by t.
+func compatOf(t iterator) Compat {
+	if l := limitsOf(t); l != nil {
+		return l.compat
+	}
+	return Compat{}
+}
+
+// compatLimiter returns the limiter of an evaluation of expr without
+// options, or nil if it needs none: the functions find the Compat of the
+// expression, and its other options of the evaluation, in it.
+func (expr *Expr) compatLimiter() *limiter {
+	if !expr.opts.evaluated() {
+		return nil
+	}
+	l := newLimiter(EvalOptions{})
+	l.setOptions(expr.opts)
+	return l
+}
+
+// evaluated reports whether the options change the evaluation, rather
+// than only how the expression is compiled.
+func (opts CompileOptions) evaluated() bool {
+	return opts.Compat != (Compat{}) || opts.NumberStrings != (NumberStrings{}) || opts.StrictNumbers
+}
+
+// setOptions sets the options of the evaluation of an expression
+// compiled with opts.
+func (l *limiter) setOptions(opts CompileOptions) {
+	l.compat, l.numbers, l.strictNumbers = opts.Compat, opts.NumberStrings, opts.StrictNumbers
+}
+
+// warnsCompat reports whether the evaluation reports the results that
+// differ from those of the engine of its Compat.
+func (l *limiter) warnsCompat() bool {
+	return l.warns() && l.compat.Engine != ""
+}
+
+// warnCompat reports a result that the engine of the Compat does not
+// give, as described by format, which names the engine with %[1]s.
+func (l *limiter) warnCompat(format string, args ...interface{}) {
+	if l.warnsCompat() {
+		msg := fmt.Sprintf(format, append([]interface{}{l.compat.Engine}, args...)...)
+		l.opts.Warn(Warning{Kind: NonStandard, Msg: msg})
+	}
+}
+
+// parseNumber converts s to a number as the evaluation of t does, and
+// reports whether it is one.
+func parseNumber(t iterator, s string) (float64, bool) {
+	if compatOf(t).DoubleNumbers {
+		return parseDouble(s)
+	}
+	return xpathNumber(s)
+}
+
+// parseDouble converts s to a number as it is cast to xs:double.
+func parseDouble(s string) (float64, bool) {
+	s = strings.Trim(s, " \t\r\n")
+	switch s {
+	case "INF", "+INF":
+		return math.Inf(1), true
+	case "-INF":
+		return math.Inf(-1), true
+	case "NaN":
+		return math.NaN(), true
+	}
+	digits := strings.TrimLeft(s, "+-")
+	if len(s)-len(digits) > 1 {
+		return 0, false
+	}
+	mantissa, exp := digits, ""
+	if i := strings.IndexAny(digits, "eE"); i >= 0 {
+		mantissa, exp = digits[:i], strings.TrimLeft(digits[i+1:], "+-")
+		if exp == "" || len(digits[i+1:])-len(exp) > 1 || !isDigits(exp) {
+			return 0, false
+		}
+	}
+	whole, frac := mantissa, ""
+	if i := strings.IndexByte(mantissa, '.'); i >= 0 {
+		whole, frac = mantissa[:i], mantissa[i+1:]
+	}
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		// Out of range: ParseFloat returns the infinity or zero.
+		if e, ok := err.(*strconv.NumError); !ok || e.Err != strconv.ErrRange {
+			return 0, false
+		}
+	}
+	return v, true
+}
+
+// doubleString returns v as XPath 3.1 casts an xs:double to a string: a
+// decimal from 1e-6 to 1e6, and else a number with an exponent such as
+// 1.0E21.
+func doubleString(v float64) string {
+	switch a := math.Abs(v); {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "INF"
+	case math.IsInf(v, -1):
+		return "-INF"
+	case v == 0 || a >= 1e-6 && a < 1e6:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	s := strconv.FormatFloat(v, 'E', -1, 64)
+	i := strings.IndexByte(s, 'E')
+	mantissa, exp := s[:i], s[i+1:]
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	exp = strings.TrimPrefix(exp, "+")
+	if neg := strings.HasPrefix(exp, "-"); neg {
+		exp = "-" + strings.TrimLeft(exp[1:], "0")
+	} else {
+		exp = strings.TrimLeft(exp, "0")
+	}
+	return mantissa + "E" + exp
+}
+
+// substringChars is substring(s, start, length) over the characters of s,
+// with an infinite length without the third argument.
+func substringChars(s string, start, length float64) string {
+	// The characters are those whose position p, from 1, is such that
+	// round(start) <= p < round(start) + round(length).
+	first := math.Floor(start + 0.5)
+	end := first + math.Floor(length+0.5)
+	var b strings.Builder
+	p := 1.0
+	for _, r := range s {
+		if p >= first && p < end {
+			b.WriteRune(r)
+		}
+		p++
+	}
+	return b.String()
+}
+
+// regexpSource returns the Go regular expression of the pattern of
+// matches() or replace() with its flags, the letters of XPath: i, m and s
+// as in Go, x to remove the whitespace outside classes, and q to match
+// the pattern literally. With xsd the pattern is an XPath regular
+// expression, see Compat.XSDRegexps.
+func regexpSource(pattern, flags string, xsd bool) (string, error) {
+	var goFlags string
+	var extended, quote bool
+	for _, f := range flags {
+		switch f {
+		case 'i', 'm', 's':
+			if !strings.ContainsRune(goFlags, f) {
+				goFlags += string(f)
+			}
+		case 'x':
+			extended = true
+		case 'q':
+			quote = true
+		default:
+			return "", fmt.Errorf("invalid regular expression flag %q", f)
+		}
+	}
+	switch {
+	case quote:
+		pattern = regexp.QuoteMeta(pattern)
+	case xsd:
+		if extended {
+			pattern = removeWhitespace(pattern)
+		}
+		var err error
+		if pattern, err = translateXSD(pattern); err != nil {
+			return "", err
+		}
+	case extended:
+		pattern = removeWhitespace(pattern)
+	}
+	if goFlags != "" {
+		pattern = "(?" + goFlags + ")" + pattern
+	}
+	return pattern, nil
+}
+
+// removeWhitespace removes the whitespace of pattern outside its classes,
+// for the x flag.
+func removeWhitespace(pattern string) string {
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case c == '\\' && i+1 < len(pattern):
+			b.WriteByte(c)
+			i++
+			b.WriteByte(pattern[i])
+		case depth == 0 && (c == ' ' || c == '\t' || c == '\n' || c == '\r'):
+		default:
+			if c == '[' {
+				depth++
+			} else if c == ']' && depth > 0 {
+				depth--
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// The classes of the characters of XML names, for \i and \c.
+const (
+	nameStartChars = `:A-Z_a-z\x{C0}-\x{D6}\x{D8}-\x{F6}\x{F8}-\x{2FF}\x{370}-\x{37D}\x{37F}-\x{1FFF}\x{200C}-\x{200D}\x{2070}-\x{218F}\x{2C00}-\x{2FEF}\x{3001}-\x{D7FF}\x{F900}-\x{FDCF}\x{FDF0}-\x{FFFD}\x{10000}-\x{EFFFF}`
+	nameChars      = nameStartChars + `\-.0-9\x{B7}\x{300}-\x{36F}\x{203F}-\x{2040}`
+)
+
+// unicodeBlocks are the ranges of the blocks of the \p{IsBlock} escapes
+// supported.
+var unicodeBlocks = map[string]string{
+	"BasicLatin":                `\x00-\x7F`,
+	"Latin-1Supplement":         `\x{80}-\x{FF}`,
+	"LatinExtended-A":           `\x{100}-\x{17F}`,
+	"LatinExtended-B":           `\x{180}-\x{24F}`,
+	"IPAExtensions":             `\x{250}-\x{2AF}`,
+	"CombiningDiacriticalMarks": `\x{300}-\x{36F}`,
+	"Greek":                     `\x{370}-\x{3FF}`,
+	"GreekandCoptic":            `\x{370}-\x{3FF}`,
+	"Cyrillic":                  `\x{400}-\x{4FF}`,
+	"Armenian":                  `\x{530}-\x{58F}`,
+	"Hebrew":                    `\x{590}-\x{5FF}`,
+	"Arabic":                    `\x{600}-\x{6FF}`,
+	"Devanagari":                `\x{900}-\x{97F}`,
+	"Thai":                      `\x{E00}-\x{E7F}`,
+	"GeneralPunctuation":        `\x{2000}-\x{206F}`,
+	"CurrencySymbols":           `\x{20A0}-\x{20CF}`,
+	"Hiragana":                  `\x{3040}-\x{309F}`,
+	"Katakana":                  `\x{30A0}-\x{30FF}`,
+	"CJKUnifiedIdeographs":      `\x{4E00}-\x{9FFF}`,
+	"HangulSyllables":           `\x{AC00}-\x{D7AF}`,
+}
+
+// xsdTranslator translates an XPath regular expression to Go.
+type xsdTranslator struct {
+	s string
+	i int
+}
+
+// translateXSD returns the Go regular expression of the XPath regular
+// expression pattern.
+func translateXSD(pattern string) (string, error) {
+	x := &xsdTranslator{s: pattern}
+	var b strings.Builder
+	for x.i < len(x.s) {
+		switch c := x.s[x.i]; c {
+		case '\\':
+			s, err := x.escape(false)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+		case '[':
+			s, err := x.class()
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+		case '(':
+			if strings.HasPrefix(x.s[x.i:], "(?") && !strings.HasPrefix(x.s[x.i:], "(?:") {
+				return "", fmt.Errorf("%q at offset %d is not XPath regular expression syntax", x.s[x.i:x.i+2], x.i)
+			}
+			b.WriteByte(c)
+			x.i++
+		default:
+			b.WriteByte(c)
+			x.i++
+		}
+	}
+	return b.String(), nil
+}
+
+// escape translates the escape at x.i, within a class if inClass.
+func (x *xsdTranslator) escape(inClass bool) (string, error) {
+	if x.i+1 >= len(x.s) {
+		return "", fmt.Errorf("trailing backslash")
+	}
+	c := x.s[x.i+1]
+	x.i += 2
+	class := func(chars string, negated bool) (string, error) {
+		switch {
+		case !inClass && negated:
+			return "[^" + chars + "]", nil
+		case !inClass:
+			return "[" + chars + "]", nil
+		case negated:
+			return "", fmt.Errorf(`\%c within a class is not supported`, c)
+		}
+		return chars, nil
+	}
+	switch c {
+	case 'n', 'r', 't':
+		return `\` + string(c), nil
+	case '\\', '|', '.', '?', '*', '+', '(', ')', '{', '}', '$', '-', '[', ']', '^':
+		return `\` + string(c), nil
+	case 's', 'S':
+		return class(`\t\n\r `, c == 'S')
+	case 'i', 'I':
+		return class(nameStartChars, c == 'I')
+	case 'c', 'C':
+		return class(nameChars, c == 'C')
+	case 'd':
+		return `\p{Nd}`, nil
+	case 'D':
+		return `\P{Nd}`, nil
+	case 'w':
+		return class(`\p{P}\p{Z}\p{C}`, true)
+	case 'W':
+		return class(`\p{P}\p{Z}\p{C}`, false)
+	case 'p', 'P':
+		end := strings.IndexByte(x.s[x.i:], '}')
+		if !strings.HasPrefix(x.s[x.i:], "{") || end < 0 {
+			return "", fmt.Errorf(`\%c without {name}`, c)
+		}
+		name := x.s[x.i+1 : x.i+end]
+		x.i += end + 1
+		if !strings.HasPrefix(name, "Is") {
+			return `\` + string(c) + "{" + name + "}", nil
+		}
+		block, ok := unicodeBlocks[name[2:]]
+		if !ok {
+			return "", fmt.Errorf(`the block of \%c{%s} is not supported`, c, name)
+		}
+		return class(block, c == 'P')
+	}
+	return "", fmt.Errorf(`\%c is not an XPath regular expression escape`, c)
+}
+
+// class translates the class at x.i, subtracting the nested class of
+// [base-[sub]] from its ranges.
+func (x *xsdTranslator) class() (string, error) {
+	start := x.i
+	x.i++
+	var b strings.Builder
+	b.WriteByte('[')
+	if strings.HasPrefix(x.s[x.i:], "^") {
+		b.WriteByte('^')
+		x.i++
+	}
+	for {
+		if x.i >= len(x.s) {
+			return "", fmt.Errorf("unclosed class at offset %d", start)
+		}
+		switch c := x.s[x.i]; {
+		case c == ']':
+			x.i++
+			b.WriteByte(']')
+			return b.String(), nil
+		case c == '-' && strings.HasPrefix(x.s[x.i:], "-["):
+			x.i++
+			sub, err := x.class()
+			if err != nil {
+				return "", err
+			}
+			if !strings.HasPrefix(x.s[x.i:], "]") {
+				return "", fmt.Errorf("a subtraction does not end its class at offset %d", x.i)
+			}
+			x.i++
+			b.WriteByte(']')
+			return subtractClasses(b.String(), sub)
+		case c == '\\':
+			s, err := x.escape(true)
+			if err != nil {
+				return "", err
+			}
+			b.WriteString(s)
+		case c == '[':
+			b.WriteString(`\[`)
+			x.i++
+		default:
+			_, size := utf8.DecodeRuneInString(x.s[x.i:])
+			b.WriteString(x.s[x.i : x.i+size])
+			x.i += size
+		}
+	}
+}
+
+// subtractClasses returns the Go class of the characters of the Go class
+// base that are not in the Go class sub.
+func subtractClasses(base, sub string) (string, error) {
+	a, err := classRanges(base)
+	if err != nil {
+		return "", err
+	}
+	b, err := classRanges(sub)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	out.WriteByte('[')
+	for i := 0; i < len(a); i += 2 {
+		lo, hi := a[i], a[i+1]
+		for j := 0; j < len(b) && lo <= hi; j += 2 {
+			if b[j+1] < lo || b[j] > hi {
+				continue
+			}
+			if b[j] > lo {
+				fmt.Fprintf(&out, `\x{%X}-\x{%X}`, lo, b[j]-1)
+			}
+			lo = b[j+1] + 1
+		}
+		if lo <= hi {
+			fmt.Fprintf(&out, `\x{%X}-\x{%X}`, lo, hi)
+		}
+	}
+	if out.Len() == 1 {
+		// No character: the complement of all of them.
+		return `[^\x00-\x{10FFFF}]`, nil
+	}
+	out.WriteByte(']')
+	return out.String(), nil
+}
+
+// classRanges returns the sorted ranges of the characters of the Go class
+// cls, as pairs of runes.
+func classRanges(cls string) ([]rune, error) {
+	re, err := syntax.Parse(cls, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	switch re.Op {
+	case syntax.OpCharClass:
+		return re.Rune, nil
+	case syntax.OpLiteral:
+		var list []rune
+		for _, r := range re.Rune {
+			list = append(list, r, r)
+		}
+		return list, nil
+	case syntax.OpAnyChar:
+		return []rune{0, utf8.MaxRune}, nil
+	case syntax.OpAnyCharNotNL:
+		return []rune{0, '\n' - 1, '\n' + 1, utf8.MaxRune}, nil
+	case syntax.OpNoMatch:
+		return nil, nil
+	}
+	return nil, fmt.Errorf("%s is not a class", cls)
+}
+
+// xsdReplacement returns the replacement of Regexp.Expand for the
+// replacement r of replace() with an XPath regular expression of n
+// groups: $N is the group N, \$ a dollar and \\ a backslash.
+func xsdReplacement(r string, n int) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(r); i++ {
+		switch c := r[i]; c {
+		case '\\':
+			if i+1 >= len(r) || r[i+1] != '\\' && r[i+1] != '$' {
+				return "", fmt.Errorf("the backslash at offset %d of the replacement does not escape \\ or $", i)
+			}
+			i++
+			if r[i] == '$' {
+				b.WriteString("$$")
+			} else {
+				b.WriteByte('\\')
+			}
+		case '$':
+			// The longest group number that is at most n.
+			j := i + 1
+			for j < len(r) && r[j] >= '0' && r[j] <= '9' {
+				if v, _ := strconv.Atoi(r[i+1 : j+1]); v > n && j > i+1 {
+					break
+				}
+				j++
+			}
+			if j == i+1 {
+				return "", fmt.Errorf("the $ at offset %d of the replacement is not followed by a group number", i)
+			}
+			fmt.Fprintf(&b, "${%s}", r[i+1:j])
+			i = j - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,121 @@
+package xpath
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCompat(t *testing.T) {
+	doc := createNode("", RootNode)
+	r := doc.createChildNode("r", ElementNode)
+	r.createChildNode("s", ElementNode).createChildNode("héllo", TextNode)
+	r.createChildNode("s", ElementNode).createChildNode("né", TextNode)
+	r.createChildNode("n", ElementNode).createChildNode("INF", TextNode)
+	r.createChildNode("n", ElementNode).createChildNode("0x1p4", TextNode)
+	r.createChildNode("m", ElementNode).createChildNode("1e21", TextNode)
+	r.createChildNode("m", ElementNode).createChildNode("1.25e-7", TextNode)
+	r.createChildNode("m", ElementNode).createChildNode("1.5", TextNode)
+
+	for _, tc := range []struct {
+		expr       string
+		want, base interface{}
+	}{
+		{`string-length(//s)`, float64(5), float64(6)},
+		{`substring(//s, 2, 3)`, "éll", "él"},
+		{`substring('12345', 1.5, 2.6)`, "234", "234"},
+		{`substring('12345', 0, 3)`, "12", "12"},
+		{`substring('12345', -42, 1 div 0)`, "12345", "12345"},
+		{`string-length('héllo')`, float64(5), float64(6)},
+		{`substring('12345', -1 div 0, 1 div 0)`, "", ""},
+		{`count(//s[string-length(.) = 2])`, float64(1), float64(0)},
+		{`number(' 1e3 ')`, float64(1000), math.NaN()},
+		{`number('+1')`, float64(1), math.NaN()},
+		{`number('.5e-1')`, 0.05, math.NaN()},
+		{`number('0x1p4')`, math.NaN(), math.NaN()},
+		{`number('Inf')`, math.NaN(), math.NaN()},
+		{`number('1_000')`, math.NaN(), math.NaN()},
+		{`number(' 12 ')`, float64(12), float64(12)},
+		{`number('-.5')`, -0.5, -0.5},
+		{`number('1e')`, math.NaN(), math.NaN()},
+		{`number('--1')`, math.NaN(), math.NaN()},
+		{`number(//n[1])`, math.Inf(1), math.NaN()},
+		{`sum(//n[2])`, math.NaN(), math.NaN()},
+		{`matches('aBc', 'b', 'i')`, true, true},
+		{`matches('a.c', '.', 'q')`, true, true},
+		{`matches('abc', '.', 'q')`, false, false},
+		{`matches('abc', ' a b c ', 'x')`, true, true},
+		{`matches('é', '^\w$')`, true, false},
+		{`matches('٣', '^\d$')`, true, false},
+		{`matches('x-1', '^\i\c*$')`, true, nil},
+		{`matches('b', '^[a-z-[aeiou]]$')`, true, false},
+		{`matches('e', '^[a-z-[aeiou]]$')`, false, false},
+		{`matches('é', '\p{IsBasicLatin}')`, false, nil},
+		{`matches('(a)', '^\(\p{Ll}\)$')`, true, true},
+		{`matches('ab', '^(?:a)b$')`, true, true},
+		{`replace('abc', 'b', '\$')`, "a$c", "a\\$c"},
+		{`replace('abc', '(b)', '[$1]')`, "a[b]c", "a[b]c"},
+		{`replace('abc', '(b)', '$12')`, "ab2c", "ab2c"},
+		{`replace('aBc', 'b', 'x', 'i')`, "axc", "axc"},
+	} {
+		for _, compat := range []Compat{SaxonHE, {}} {
+			want := tc.want
+			if compat == (Compat{}) {
+				want = tc.base
+			}
+			expr, err := CompileWithOptions(tc.expr, CompileOptions{Compat: compat})
+			if want == nil {
+				assertErr(t, err)
+				continue
+			}
+			assertNoErr(t, err)
+			v := expr.Evaluate(createNavigator(doc))
+			if f, ok := want.(float64); ok && math.IsNaN(f) {
+				if !math.IsNaN(v.(float64)) {
+					t.Errorf("%s with %q = %v, want NaN", tc.expr, compat.Engine, v)
+				}
+			} else if v != want {
+				t.Errorf("%s with %q = %#v, want %#v", tc.expr, compat.Engine, v, want)
+			}
+		}
+	}
+
+	for _, expr := range []string{
+		`matches('a', '(?i)A')`,
+		`matches('a', '\bA')`,
+		`matches('a', '[\w-]')`,
+		`matches('a', '\p{IsKlingon}')`,
+		`matches('a', '[a-[b]x]')`,
+		`matches('a', 'a', 'z')`,
+		`replace('abc', 'x*', '-')`,
+	} {
+		if _, err := CompileWithOptions(expr, CompileOptions{Compat: BaseX}); err == nil {
+			t.Errorf("%s compiled with BaseX", expr)
+		}
+	}
+	_, err := Compile(`matches('a', '(?i)A')`)
+	assertNoErr(t, err)
+	_, err = Compile(`matches('a', 'a', 'z')`)
+	assertErr(t, err)
+
+	for _, tc := range []struct {
+		expr     string
+		warnings []string
+	}{
+		{`string(number(//n[1]))`, []string{"number() of 'INF' is +Inf, XPath 1.0 gives NaN", `the string of the number +Inf is "+Inf", Saxon-HE gives "INF" for a double`}},
+		{`string(//m[1] * 1)`, []string{"number() of '1e21' is 1e+21, XPath 1.0 gives NaN", `the string of the number 1e+21 is "1e+21", Saxon-HE gives "1.0E21" for a double`}},
+		{`string(number(//m[2]))`, []string{"number() of '1.25e-7' is 1.25e-07, XPath 1.0 gives NaN", `the string of the number 1.25e-07 is "1.25e-07", Saxon-HE gives "1.25E-7" for a double`}},
+		{`string(number(//m[3]))`, nil},
+		{`string(1 div 0)`, nil},
+		{`boolean(number(//s))`, nil},
+	} {
+		var got []string
+		expr, err := CompileWithOptions(tc.expr, CompileOptions{Compat: SaxonHE})
+		assertNoErr(t, err)
+		_, err = expr.EvaluateWithOptions(createNavigator(doc), EvalOptions{Warn: func(w Warning) {
+			assertEqual(t, NonStandard, w.Kind)
+			got = append(got, w.String())
+		}})
+		assertNoErr(t, err)
+		assertEqual(t, tc.warnings, got)
+	}
+}
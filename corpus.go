@@ -0,0 +1,120 @@
+package xpath
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Recorder, if not nil, is called by Compile, CompileWithNS and
+// CompileWithOptions with each expression they compile, valid or not, such
+// as the Record method of a Corpus. It is for recording the expressions
+// an application uses in production, to replay them on new versions of
+// the package, see xpathtest.RunReplay. It must be safe for concurrent
+// use.
+var Recorder func(expr string)
+
+// A Corpus is the set of the expressions recorded, with the number of
+// times each one was. The zero value is an empty corpus, safe for
+// concurrent use.
+type Corpus struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// A CorpusEntry is an expression of a Corpus.
+type CorpusEntry struct {
+	// Hash is the ExprHash of Expr, which identifies it.
+	Hash  string
+	Expr  string
+	Count int
+}
+
+// ExprHash returns the first 16 hexadecimal digits of the SHA-256 hash of
+// the expression, which identify it in a Corpus.
+func ExprHash(expr string) string {
+	sum := sha256.Sum256([]byte(expr))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Record adds the expression to the corpus.
+func (c *Corpus) Record(expr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	c.counts[expr]++
+}
+
+// Entries returns the expressions of the corpus, by hash.
+func (c *Corpus) Entries() []CorpusEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]CorpusEntry, 0, len(c.counts))
+	for expr, n := range c.counts {
+		entries = append(entries, CorpusEntry{Hash: ExprHash(expr), Expr: expr, Count: n})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Hash < entries[j].Hash })
+	return entries
+}
+
+// WriteTo writes the corpus to w, a line per expression with its hash,
+// its count and the expression quoted as a Go string, such as
+//
+//	3c0e3b1bbd403fbf 12 "//book[@id = 'b1']"
+//
+// The lines are in the order of Entries, so the files of the same
+// corpus are the same.
+func (c *Corpus) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, e := range c.Entries() {
+		m, err := fmt.Fprintf(w, "%s %d %s\n", e.Hash, e.Count, strconv.Quote(e.Expr))
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadCorpus reads a corpus written by Corpus.WriteTo. The hashes are
+// not checked, and the counts of the same expression are added.
+func ReadCorpus(r io.Reader) (*Corpus, error) {
+	c := new(Corpus)
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		fields := strings.SplitN(s.Text(), " ", 3)
+		if len(fields) == 1 && fields[0] == "" {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("xpath: corpus line %d: want a hash, a count and an expression", line)
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("xpath: corpus line %d: count: %v", line, err)
+		}
+		expr, err := strconv.Unquote(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("xpath: corpus line %d: expression: %v", line, err)
+		}
+		c.mu.Lock()
+		if c.counts == nil {
+			c.counts = make(map[string]int)
+		}
+		c.counts[expr] += n
+		c.mu.Unlock()
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
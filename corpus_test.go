@@ -0,0 +1,52 @@
+package xpath
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCorpus(t *testing.T) {
+	var c Corpus
+	Recorder = c.Record
+	defer func() { Recorder = nil }()
+	MustCompile(`//book`)
+	Compile(`//book`)
+	CompileWithNS(`//b:book[`, map[string]string{"b": "urn:b"})
+	Select(createNavigator(book_example), "count(//book)")
+	// The expressions the package compiles itself are not recorded.
+	Lint(`//book[@id='a'b']`)
+	Recorder = nil
+	Compile(`/`)
+
+	entries := c.Entries()
+	assertEqual(t, 3, len(entries))
+	counts := make(map[string]int)
+	for _, e := range entries {
+		assertEqual(t, ExprHash(e.Expr), e.Hash)
+		counts[e.Expr] = e.Count
+	}
+	assertEqual(t, map[string]int{`//book`: 2, `//b:book[`: 1, `count(//book)`: 1}, counts)
+
+	var b bytes.Buffer
+	_, err := c.WriteTo(&b)
+	assertNoErr(t, err)
+	assertEqual(t, ExprHash(`//book`)+` 2 "//book"`, strings.Split(b.String(), "\n")[indexOfEntry(entries, `//book`)])
+	read, err := ReadCorpus(&b)
+	assertNoErr(t, err)
+	assertEqual(t, entries, read.Entries())
+
+	_, err = ReadCorpus(strings.NewReader("0123 x \"/\"\n"))
+	assertTrue(t, err != nil)
+	_, err = ReadCorpus(strings.NewReader("0123 1 /\n"))
+	assertTrue(t, err != nil)
+}
+
+func indexOfEntry(entries []CorpusEntry, expr string) int {
+	for i, e := range entries {
+		if e.Expr == expr {
+			return i
+		}
+	}
+	return -1
+}
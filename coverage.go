@@ -0,0 +1,127 @@
+package xpath
+
+import (
+	"sort"
+	"sync"
+)
+
+// A Coverage counts the constructs of the expressions recorded: the axes,
+// functions, operators and node tests of Capabilities, by feature names
+// such as "axis:child", "function:count", "operator:|" and
+// "node-test:text()". A step counts its axis even if it is abbreviated,
+// such as the descendant-or-self axis of //. It is for guiding the
+// generators of the property tests toward the constructs they exercise
+// the least, see xpathtest.Config.Coverage; its Record method can be the
+// Recorder, to count the expressions the tests compile. The zero value is
+// an empty coverage, safe for concurrent use.
+type Coverage struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// Record adds the constructs of the expression to the coverage. The
+// expressions that are not valid XPath are ignored.
+func (c *Coverage) Record(expr string) {
+	counts := make(map[string]int)
+	if !countFeatures(expr, counts) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	for feature, n := range counts {
+		c.counts[feature] += n
+	}
+}
+
+// Count returns the number of times the feature was recorded.
+func (c *Coverage) Count(feature string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[feature]
+}
+
+// Uncovered returns the features of Capabilities that were not recorded,
+// sorted.
+func (c *Coverage) Uncovered() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var list []string
+	for _, feature := range CoverageFeatures() {
+		if c.counts[feature] == 0 {
+			list = append(list, feature)
+		}
+	}
+	return list
+}
+
+// CoverageFeatures returns the names of the features a Coverage counts,
+// sorted.
+func CoverageFeatures() []string {
+	f := Capabilities()
+	var list []string
+	for _, name := range f.Axes {
+		list = append(list, "axis:"+name)
+	}
+	for _, name := range f.Functions {
+		list = append(list, "function:"+name)
+	}
+	for _, name := range f.Operators {
+		list = append(list, "operator:"+name)
+	}
+	for _, name := range f.NodeTests {
+		list = append(list, "node-test:"+name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// countFeatures adds the features of expr to counts, and reports whether
+// expr is valid XPath.
+func countFeatures(expr string, counts map[string]int) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	if expr == "" {
+		return false
+	}
+	var count func(n node)
+	count = func(n node) {
+		switch n := n.(type) {
+		case *axisNode:
+			counts["axis:"+n.AxisType]++
+			if n.Prop != "" {
+				counts["node-test:"+n.Prop+"()"]++
+			}
+			if n.Input != nil {
+				count(n.Input)
+			}
+		case *filterNode:
+			count(n.Input)
+			count(n.Condition)
+		case *operatorNode:
+			if v, ok := n.Right.(*operandNode); ok && n.Op == "*" && v.Val == float64(-1) {
+				// -x, see formatter.format.
+				counts["operator:-"]++
+				count(n.Left)
+				break
+			}
+			counts["operator:"+n.Op]++
+			count(n.Left)
+			count(n.Right)
+		case *groupNode:
+			count(n.Input)
+		case *functionNode:
+			counts["function:"+qualifiedName(n.Prefix, n.FuncName)]++
+			for _, arg := range n.Args {
+				count(arg)
+			}
+		}
+	}
+	count(parse(expr, nil, nil))
+	return true
+}
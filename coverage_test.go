@@ -0,0 +1,37 @@
+package xpath
+
+import "testing"
+
+func TestCoverage(t *testing.T) {
+	var c Coverage
+	Recorder = c.Record
+	defer func() { Recorder = nil }()
+	MustCompile(`//book[@id = 'b1']/title | //a[-count(ancestor::*)]`)
+	Compile(`//book[`)
+	Recorder = nil
+	c.Record(`p:a/text() + 1`)
+
+	for feature, n := range map[string]int{
+		"axis:child":              5,
+		"axis:descendant-or-self": 2,
+		"axis:attribute":          1,
+		"axis:ancestor":           1,
+		"operator:|":              1,
+		"operator:=":              1,
+		"operator:-":              1,
+		"operator:*":              0,
+		"operator:+":              1,
+		"function:count":          1,
+		"node-test:text()":        1,
+		"node-test:node()":        0,
+		"axis:following":          0,
+	} {
+		assertEqual(t, n, c.Count(feature))
+	}
+
+	uncovered := c.Uncovered()
+	assertEqual(t, len(CoverageFeatures())-10, len(uncovered))
+	for _, feature := range uncovered {
+		assertEqual(t, 0, c.Count(feature))
+	}
+}
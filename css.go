@@ -0,0 +1,464 @@
+package xpath
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CompileCSS compiles a CSS3 selector, such as
+// "div.item > a[href^='https']", to the expression that selects the
+// elements it matches among the context node and its descendants. The
+// String of the Expr is that expression. The selector has the type and
+// universal selectors, the #id and .class selectors, the attribute
+// selectors with the operators =, ~=, |=, ^=, $= and *=, the combinators
+// of descendants, children (>) and siblings (+ and ~), groups separated
+// by commas, and the pseudo-classes :root, :empty, :not(), :first-child,
+// :last-child, :only-child, :nth-child(), :nth-last-child() and their
+// -of-type versions. Namespace prefixes and pseudo-elements are not
+// supported. Names are compared as they are written, as in XML. If the
+// selector is not valid, or not supported, the error is a *SyntaxError
+// with the selector as Expr.
+func CompileCSS(selector string) (*Expr, error) {
+	expr, err := cssToXPath(selector)
+	if err != nil {
+		return nil, err
+	}
+	return compile(expr, CompileOptions{})
+}
+
+// cssToXPath returns the expression of the selector for CompileCSS.
+func cssToXPath(selector string) (expr string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			serr, ok := e.(*SyntaxError)
+			if !ok {
+				panic(e)
+			}
+			err = serr
+		}
+	}()
+	p := &cssParser{s: selector}
+	var paths []string
+	for {
+		p.skipSpace()
+		paths = append(paths, p.selector())
+		p.skipSpace()
+		if p.pos == len(p.s) {
+			break
+		}
+		p.expect(",")
+	}
+	return strings.Join(paths, " | "), nil
+}
+
+// A cssParser reads a selector. Its methods panic with a *SyntaxError.
+type cssParser struct {
+	s   string
+	pos int
+}
+
+// fail panics with the syntax error of the character at the position.
+func (p *cssParser) fail(msg string, expected ...string) {
+	token := ""
+	if p.pos < len(p.s) {
+		_, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		token = p.s[p.pos : p.pos+size]
+	}
+	panic(&SyntaxError{Expr: p.s, Offset: p.pos, Token: token, Expected: expected, Msg: msg})
+}
+
+// unsupported panics with the syntax error of the token that starts at
+// start and ends at the position.
+func (p *cssParser) unsupported(start int, what string) {
+	panic(&SyntaxError{Expr: p.s, Offset: start, Token: p.s[start:p.pos], Msg: "unsupported " + what})
+}
+
+func (p *cssParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *cssParser) skipSpace() bool {
+	start := p.pos
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n\f", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+	return p.pos > start
+}
+
+// expect reads the string s.
+func (p *cssParser) expect(s string) {
+	if !strings.HasPrefix(p.s[p.pos:], s) {
+		p.fail("", strconv.Quote(s))
+	}
+	p.pos += len(s)
+}
+
+// selector reads compound selectors separated by combinators, and
+// returns the path of the elements they match.
+func (p *cssParser) selector() string {
+	test, conds := p.compound()
+	path := "descendant-or-self::" + cssStep(test, conds)
+	for {
+		space := p.skipSpace()
+		var combinator byte
+		switch c := p.peek(); c {
+		case '>', '+', '~':
+			combinator = c
+			p.pos++
+			p.skipSpace()
+		case ',', 0:
+			return path
+		default:
+			if !space {
+				p.fail("", "combinator")
+			}
+			combinator = ' '
+		}
+		test, conds := p.compound()
+		switch combinator {
+		case ' ':
+			path += "/descendant::" + cssStep(test, conds)
+		case '>':
+			path += "/" + cssStep(test, conds)
+		case '+':
+			path += "/following-sibling::*[1]/self::" + cssStep(test, conds)
+		case '~':
+			path += "/following-sibling::" + cssStep(test, conds)
+		}
+	}
+}
+
+// cssStep returns the step of the node test and the conditions.
+func cssStep(test string, conds []string) string {
+	if len(conds) == 0 {
+		return test
+	}
+	return test + "[" + strings.Join(conds, " and ") + "]"
+}
+
+// compound reads a type selector and the selectors that follow it, and
+// returns the node test and the conditions of the step of the elements
+// they match.
+func (p *cssParser) compound() (test string, conds []string) {
+	start := p.pos
+	test = "*"
+	switch c := p.peek(); {
+	case c == '*':
+		p.pos++
+	case isCSSNameStart(p.s[p.pos:]):
+		test = p.ident()
+	}
+	if p.peek() == '|' {
+		p.pos++
+		p.unsupported(p.pos-1, "namespace prefix")
+	}
+	for {
+		switch p.peek() {
+		case '#':
+			p.pos++
+			conds = append(conds, "@id = "+Quote(p.name()))
+		case '.':
+			p.pos++
+			conds = append(conds, "contains(concat(' ', normalize-space(@class), ' '), "+Quote(" "+p.ident()+" ")+")")
+		case '[':
+			p.pos++
+			conds = append(conds, p.attribute())
+		case ':':
+			p.pos++
+			conds = append(conds, p.pseudo(test))
+		default:
+			if p.pos == start {
+				p.fail("", "selector")
+			}
+			return test, conds
+		}
+	}
+}
+
+// attribute reads an attribute selector after its [, and returns its
+// condition.
+func (p *cssParser) attribute() string {
+	p.skipSpace()
+	name := p.ident()
+	if p.peek() == '|' && !strings.HasPrefix(p.s[p.pos:], "|=") {
+		p.pos++
+		p.unsupported(p.pos-1, "namespace prefix")
+	}
+	attr := "@" + name
+	p.skipSpace()
+	var op string
+	for _, s := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+		if strings.HasPrefix(p.s[p.pos:], s) {
+			op = s
+		}
+	}
+	if op == "" {
+		p.expect("]")
+		return attr
+	}
+	p.pos += len(op)
+	p.skipSpace()
+	var v string
+	if c := p.peek(); c == '"' || c == '\'' {
+		v = p.stringLiteral()
+	} else {
+		v = p.ident()
+	}
+	p.skipSpace()
+	p.expect("]")
+
+	lit := Quote(v)
+	switch op {
+	case "=":
+		return attr + " = " + lit
+	case "~=":
+		if v == "" || strings.ContainsAny(v, " \t\r\n\f") {
+			return "false()"
+		}
+		return "contains(concat(' ', normalize-space(" + attr + "), ' '), " + Quote(" "+v+" ") + ")"
+	case "|=":
+		return "(" + attr + " = " + lit + " or starts-with(" + attr + ", " + Quote(v+"-") + "))"
+	}
+	// The substring matches of the empty string match nothing.
+	if v == "" {
+		return "false()"
+	}
+	switch op {
+	case "^=":
+		return "starts-with(" + attr + ", " + lit + ")"
+	case "$=":
+		return "ends-with(" + attr + ", " + lit + ")"
+	}
+	return "contains(" + attr + ", " + lit + ")"
+}
+
+// pseudo reads a pseudo-class after its :, and returns its condition for
+// the elements of the node test.
+func (p *cssParser) pseudo(test string) string {
+	start := p.pos - 1
+	if p.peek() == ':' {
+		p.pos++
+		p.ident()
+		p.unsupported(start, "pseudo-element")
+	}
+	name := strings.ToLower(p.ident())
+	siblings := "*"
+	if strings.HasSuffix(name, "-of-type") {
+		if test == "*" {
+			p.unsupported(start, "pseudo-class without a type selector")
+		}
+		siblings = test
+	}
+	preceding := "count(preceding-sibling::" + siblings + ")"
+	following := "count(following-sibling::" + siblings + ")"
+
+	switch name {
+	case "root":
+		return "not(parent::*)"
+	case "empty":
+		return "not(*) and not(text())"
+	case "first-child", "first-of-type":
+		return "not(preceding-sibling::" + siblings + ")"
+	case "last-child", "last-of-type":
+		return "not(following-sibling::" + siblings + ")"
+	case "only-child", "only-of-type":
+		return "not(preceding-sibling::" + siblings + ") and not(following-sibling::" + siblings + ")"
+	case "not":
+		p.expect("(")
+		p.skipSpace()
+		test, conds := p.compound()
+		p.skipSpace()
+		p.expect(")")
+		return "not(self::" + cssStep(test, conds) + ")"
+	case "nth-child", "nth-of-type":
+		return nthCondition(preceding, p.nth())
+	case "nth-last-child", "nth-last-of-type":
+		return nthCondition(following, p.nth())
+	}
+	p.unsupported(start, "pseudo-class")
+	return ""
+}
+
+// nth reads the an+b argument of a pseudo-class, with its parentheses.
+func (p *cssParser) nth() [2]int {
+	p.expect("(")
+	p.skipSpace()
+	start := p.pos
+	end := strings.IndexByte(p.s[p.pos:], ')')
+	if end < 0 {
+		p.pos = len(p.s)
+		p.fail("", "')'")
+	}
+	arg := strings.ToLower(strings.Join(strings.Fields(p.s[p.pos:p.pos+end]), ""))
+	p.pos += end + 1
+	switch arg {
+	case "odd":
+		return [2]int{2, 1}
+	case "even":
+		return [2]int{2, 0}
+	}
+	var a, b int
+	var err error
+	i := strings.IndexByte(arg, 'n')
+	if i < 0 {
+		b, err = strconv.Atoi(arg)
+	} else {
+		switch s := arg[:i]; s {
+		case "", "+":
+			a = 1
+		case "-":
+			a = -1
+		default:
+			a, err = strconv.Atoi(s)
+		}
+		if s := arg[i+1:]; err == nil && s != "" {
+			if s[0] != '+' && s[0] != '-' {
+				err = strconv.ErrSyntax
+			} else {
+				b, err = strconv.Atoi(s)
+			}
+		}
+	}
+	if err != nil || arg == "" {
+		p.pos = start
+		p.fail("", "an+b")
+	}
+	return [2]int{a, b}
+}
+
+// nthCondition returns the condition of the elements whose position is
+// a*n+b for some n >= 0, index being their position minus one.
+func nthCondition(index string, ab [2]int) string {
+	a, b := ab[0], ab[1]
+	if a == 0 {
+		if b < 1 {
+			return "false()"
+		}
+		return index + " = " + strconv.Itoa(b-1)
+	}
+	var conds []string
+	if a < 0 {
+		if b < 1 {
+			return "false()"
+		}
+		conds = append(conds, index+" <= "+strconv.Itoa(b-1))
+		a = -a
+	} else if b > 1 {
+		conds = append(conds, index+" >= "+strconv.Itoa(b-1))
+	}
+	if a != 1 {
+		// index + 1 - b is a multiple of a.
+		if off := ((1-b)%a + a) % a; off == 0 {
+			conds = append(conds, index+" mod "+strconv.Itoa(a)+" = 0")
+		} else {
+			conds = append(conds, "("+index+" + "+strconv.Itoa(off)+") mod "+strconv.Itoa(a)+" = 0")
+		}
+	}
+	if len(conds) == 0 {
+		return "true()"
+	}
+	return strings.Join(conds, " and ")
+}
+
+// isCSSNameStart reports whether s starts with an identifier.
+func isCSSNameStart(s string) bool {
+	if strings.HasPrefix(s, "-") {
+		s = s[1:]
+	}
+	if s == "" {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(s)
+	return r == '_' || r == '\\' || r >= 0x80 || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+// ident reads an identifier.
+func (p *cssParser) ident() string {
+	if !isCSSNameStart(p.s[p.pos:]) {
+		p.fail("", "name")
+	}
+	return p.name()
+}
+
+// name reads the characters of a name, with their escapes.
+func (p *cssParser) name() string {
+	var b strings.Builder
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		switch {
+		case c == '\\':
+			p.pos++
+			b.WriteString(p.escape())
+		case c == '-' || c == '_' || c >= 0x80 || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			b.WriteByte(c)
+			p.pos++
+		default:
+			if b.Len() == 0 {
+				p.fail("", "name")
+			}
+			return b.String()
+		}
+	}
+	if b.Len() == 0 {
+		p.fail("", "name")
+	}
+	return b.String()
+}
+
+// escape reads an escape after its backslash: up to 6 hexadecimal digits
+// and an optional space, or a character.
+func (p *cssParser) escape() string {
+	n := 0
+	for n < 6 && p.pos+n < len(p.s) && strings.IndexByte("0123456789abcdefABCDEF", p.s[p.pos+n]) >= 0 {
+		n++
+	}
+	if n > 0 {
+		v, _ := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+		p.pos += n
+		if p.pos < len(p.s) && strings.IndexByte(" \t\r\n\f", p.s[p.pos]) >= 0 {
+			p.pos++
+		}
+		return string(rune(v))
+	}
+	if p.pos == len(p.s) {
+		p.fail("", "escaped character")
+	}
+	r, size := utf8.DecodeRuneInString(p.s[p.pos:])
+	p.pos += size
+	return string(r)
+}
+
+// stringLiteral reads a quoted string, with its escapes.
+func (p *cssParser) stringLiteral() string {
+	quote := p.s[p.pos]
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos == len(p.s) {
+			p.pos = start
+			p.fail("unclosed string")
+		}
+		c := p.s[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String()
+		case c == '\\':
+			p.pos++
+			if p.peek() == '\n' {
+				// An escaped newline continues the string.
+				p.pos++
+				continue
+			}
+			b.WriteString(p.escape())
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package xpath
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompileCSS(t *testing.T) {
+	doc := parseXML(`<html id="html">
+<body id="body" class="page">
+  <div id="d1" class="item  first">
+    <a id="a1" href="https://x" lang="en-GB">1</a>
+    <a id="a2" href="http://y" rel="nofollow external">2</a>
+  </div>
+  <p id="p1"></p>
+  <div id="d2" class="item">
+    <span id="s1">it's</span>
+    <a id="a3" href="https://z/doc.pdf">3</a>
+    <p id="p2">x</p>
+    <span id="s2"><!--c--></span>
+  </div>
+</body>
+</html>`)
+	ids := func(exp *Expr) string {
+		var list []string
+		for _, n := range iterateNodes(exp.Select(createNavigator(doc))) {
+			for _, a := range n.Attr {
+				if a.Key == "id" {
+					list = append(list, a.Value)
+				}
+			}
+		}
+		return strings.Join(list, " ")
+	}
+	for _, tc := range []struct {
+		selector, ids string
+	}{
+		{`div.item > a[href^='https']`, "a1 a3"},
+		{`a`, "a1 a2 a3"},
+		{`#d2 *`, "s1 a3 p2 s2"},
+		{`.first a`, "a1 a2"},
+		{`a[rel~=external]`, "a2"},
+		{`a[lang|="en"]`, "a1"},
+		{`a[href$=".pdf"], a[href*=y]`, "a2 a3"},
+		{`a[href^=""]`, ""},
+		{`div + p`, "p1"},
+		{`div ~ p`, "p1"},
+		{`span + a`, "a3"},
+		{`div > :first-child`, "a1 s1"},
+		{`div > :last-child`, "a2 s2"},
+		{`div :nth-child(2)`, "a2 a3"},
+		{`body > :nth-child(odd)`, "d1 d2"},
+		{`#d2 > :nth-child(2n)`, "a3 s2"},
+		{`#d2 > :nth-last-child(-n+2)`, "p2 s2"},
+		{`#d2 span:nth-of-type(2)`, "s2"},
+		{`#d2 > span:last-of-type`, "s2"},
+		{`#d2 a:only-of-type`, "a3"},
+		{`div:not(.first) > *:not(span)`, "a3 p2"},
+		{`:root`, "html"},
+		{`p:empty, span:empty`, "p1 s2"},
+		{`body.page>div#d1`, "d1"},
+	} {
+		exp, err := CompileCSS(tc.selector)
+		if err != nil {
+			t.Errorf("%s: %v", tc.selector, err)
+			continue
+		}
+		if got := ids(exp); got != tc.ids {
+			t.Errorf("%s = %s, want %s (%s)", tc.selector, got, tc.ids, exp)
+		}
+	}
+
+	exp, err := CompileCSS(`div.item > a`)
+	assertNoErr(t, err)
+	assertEqual(t, `descendant-or-self::div[contains(concat(' ', normalize-space(@class), ' '), ' item ')]/a`, exp.String())
+
+	for _, tc := range []struct {
+		selector string
+		offset   int
+		token    string
+	}{
+		{``, 0, ""},
+		{`div >`, 5, ""},
+		{`a[href`, 6, ""},
+		{`a[href='x]`, 7, "'"},
+		{`a:hover`, 1, ":hover"},
+		{`a::before`, 1, "::before"},
+		{`:nth-of-type(1)`, 0, ":nth-of-type"},
+		{`a:nth-child(x)`, 12, "x"},
+		{`svg|rect`, 3, "|"},
+		{`a,`, 2, ""},
+	} {
+		_, err := CompileCSS(tc.selector)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: error %v, want a *SyntaxError", tc.selector, err)
+			continue
+		}
+		assertEqual(t, tc.selector, syntaxErr.Expr)
+		assertEqual(t, tc.offset, syntaxErr.Offset)
+		assertEqual(t, tc.token, syntaxErr.Token)
+	}
+}
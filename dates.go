@@ -0,0 +1,97 @@
+package xpath
+
+import (
+	"strings"
+	"time"
+)
+
+// dateLayouts are the ISO-8601 dates and date-times of
+// CompileOptions.CompareDates, with their optional time zone. The
+// fractions of seconds need no layout.
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05Z07:00",
+}
+
+// parseDate returns the time of the ISO-8601 date or date-time s, in UTC
+// if it has no time zone.
+func parseDate(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if len(s) < len("2006-01-02") || s[4] != '-' || s[7] != '-' {
+		return time.Time{}, false
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// dateValue is a string compared with CompileOptions.CompareDates, and
+// its time if it is a date.
+type dateValue struct {
+	s    string
+	t    time.Time
+	date bool
+}
+
+// dateValues returns the strings of v, a string or a node-set, as they
+// are compared.
+func dateValues(t iterator, v interface{}) []dateValue {
+	var list []string
+	if s, ok := v.(string); ok {
+		list = []string{s}
+	} else {
+		q := v.(query)
+		for node := q.Select(t); node != nil; node = q.Select(t) {
+			list = append(list, nodeValue(t, node))
+		}
+	}
+	values := make([]dateValue, len(list))
+	for i, s := range list {
+		values[i].s = s
+		values[i].t, values[i].date = parseDate(s)
+	}
+	return values
+}
+
+// dateFunc returns the comparison op of CompileOptions.CompareDates: a
+// string or node-set compared with another is true if one of their pairs
+// of strings compares true, chronologically if both are dates, and as
+// strings otherwise. The comparisons with numbers and booleans are those
+// without the option.
+func dateFunc(op string) func(iterator, interface{}, interface{}) interface{} {
+	return func(t iterator, m, n interface{}) interface{} {
+		t1, t2 := getXPathType(m), getXPathType(n)
+		if t1 != xpathResultType.String && t1 != xpathResultType.NodeSet ||
+			t2 != xpathResultType.String && t2 != xpathResultType.NodeSet {
+			return logicalFuncs[t1][t2](t, op, m, n)
+		}
+		left, right := dateValues(t, m), dateValues(t, n)
+		for _, a := range left {
+			for _, b := range right {
+				if !a.date || !b.date {
+					if cmpStringStringF(op, a.s, b.s) {
+						return true
+					}
+					continue
+				}
+				c := 0
+				if a.t.Before(b.t) {
+					c = -1
+				} else if a.t.After(b.t) {
+					c = 1
+				}
+				if cmpNumberNumberF(op, float64(c), 0) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,49 @@
+package xpath
+
+import "testing"
+
+func TestCompareDates(t *testing.T) {
+	doc := createNode("", RootNode)
+	feed := doc.createChildNode("feed", ElementNode)
+	for _, published := range []string{"2023-12-31", "2024-01-01T10:00:00+02:00", "2024-03-05T08:00:00.5Z", "soon"} {
+		feed.createChildNode("item", ElementNode).addAttribute("published", published)
+	}
+	nav := createNavigator(doc)
+	for _, tc := range []struct {
+		expr string
+		want interface{}
+	}{
+		// soon is not a date, and is after 2024 as a string.
+		{`count(//item[@published > '2024-01-01'])`, float64(3)},
+		{`count(//item[@published >= '2024-01-01T08:00Z'][@published != 'soon'])`, float64(2)},
+		{`count(//item[@published = '2024-01-01T08:00:00Z'])`, float64(1)},
+		{`count(//item['2024-01-01' < @published])`, float64(3)},
+		{`count(//item[@published != '2023-12-31T00:00:00Z'])`, float64(3)},
+		{`count(//item[@published > 'r'])`, float64(1)},
+		{`'2024-01-01T00:00:00+01:00' < '2023-12-31T23:30:00Z'`, true},
+		{`//item/@published < //item/@published`, true},
+		// Numbers compare as numbers.
+		{`3000 > '2024'`, true},
+	} {
+		expr, err := CompileWithOptions(tc.expr, CompileOptions{CompareDates: true})
+		assertNoErr(t, err)
+		if got := expr.Evaluate(nav); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+	// Without the option, the dates are strings.
+	assertEqual(t, false, MustCompile(`'2024-01-01T00:00:00+01:00' < '2023-12-31T23:30:00Z'`).Evaluate(nav))
+
+	for s, ok := range map[string]bool{
+		"2024-02-29":             true,
+		" 2024-02-29T23:59:59Z ": true,
+		"2024-01-01Z":            true,
+		"2023-02-29":             false,
+		"2024-1-1":               false,
+		"2024-01-01T25:00":       false,
+		"20240101":               false,
+	} {
+		_, got := parseDate(s)
+		assertEqual(t, ok, got)
+	}
+}
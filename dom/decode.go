@@ -0,0 +1,333 @@
+package dom
+
+import (
+	"encoding"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antchfx/xpath"
+)
+
+// Unmarshal stores in the struct v points to the values of the
+// expressions of the xpath tags of its fields, evaluated from n:
+//
+//	type Item struct {
+//		ID     string   `xpath:"@id"`
+//		Title  string   `xpath:"normalize-space(title)"`
+//		Price  float64  `xpath:"price"`
+//		Tags   []string `xpath:"tags/tag"`
+//		Offers []Offer  `xpath:"offer"`
+//		Seller *Seller  `xpath:"seller"`
+//		Node   *Node    `xpath:"."`
+//	}
+//
+// A field of a string, boolean or number type gets the value of its
+// expression converted to its type, the string value of the first node
+// for a node-set; a struct, or a pointer to one, is unmarshaled from the
+// first node; a *Node gets the node itself, and a type implementing
+// encoding.TextUnmarshaler its string value. A field is left as it is if
+// the node-set is empty. A slice gets an element for each node. The
+// fields without a tag, or with the tag "-", are skipped.
+func Unmarshal(n *Node, v interface{}) error {
+	return UnmarshalWithNS(n, v, nil)
+}
+
+// UnmarshalWithNS is Unmarshal with the prefixes of the expressions bound
+// to the namespace URIs.
+func UnmarshalWithNS(n *Node, v interface{}, namespaces map[string]string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("dom: Unmarshal of %T, not a pointer to a struct", v)
+	}
+	if err := unmarshalStruct(rv.Elem(), CreateNavigator(n), namespaces); err != nil {
+		return fmt.Errorf("dom: %v", err)
+	}
+	return nil
+}
+
+// A Decoder reads the elements a pattern matches in an XML stream, and
+// unmarshals each of them once it ends, as Unmarshal does. Only the
+// elements matched and those being read are kept, see Stream, so large
+// documents such as feeds are decoded in little memory. An element is
+// removed from its document before it is decoded, so its expressions cannot
+// refer to its ancestors.
+type Decoder struct {
+	// Namespaces binds the prefixes of the expressions of the tags.
+	Namespaces map[string]string
+
+	d     *xml.Decoder
+	s     *Stream
+	queue []*Node
+	err   error
+}
+
+// NewDecoder returns a decoder of the elements of the XML read from r
+// that the pattern matches. The pattern must be streamable, see
+// xpath.Pattern.Streamable.
+func NewDecoder(r io.Reader, p *xpath.Pattern) (*Decoder, error) {
+	d := &Decoder{d: xml.NewDecoder(r), s: NewStream()}
+	err := d.s.Subscribe(p, func(n *Node) error {
+		d.queue = append(d.queue, n)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Decode unmarshals the next element into the struct v points to. It
+// returns io.EOF once the stream ends.
+func (d *Decoder) Decode(v interface{}) error {
+	for len(d.queue) == 0 {
+		if d.err != nil {
+			return d.err
+		}
+		tok, err := d.d.RawToken()
+		switch {
+		case err == io.EOF:
+			if d.err = d.s.Close(); d.err == nil {
+				d.err = io.EOF
+			}
+		case err != nil:
+			d.err = err
+		default:
+			d.err = d.s.Push(tok)
+		}
+	}
+	n := d.queue[0]
+	d.queue = d.queue[1:]
+	return UnmarshalWithNS(n, v, d.Namespaces)
+}
+
+// A structField is a field of a struct with an xpath tag.
+type structField struct {
+	index int
+	name  string
+	expr  *xpath.Expr
+}
+
+type structKey struct {
+	t reflect.Type
+	// namespaces are the namespaces of the expressions, printed.
+	namespaces string
+}
+
+// structFields caches the fields of the structs, by structKey.
+var structFields sync.Map
+
+// fieldsOf returns the tagged fields of the struct type t, with their
+// expressions compiled with the namespaces.
+func fieldsOf(t reflect.Type, namespaces map[string]string) ([]structField, error) {
+	key := structKey{t, fmt.Sprint(namespaces)}
+	if fields, ok := structFields.Load(key); ok {
+		return fields.([]structField), nil
+	}
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, ok := f.Tag.Lookup("xpath")
+		if !ok || tag == "-" {
+			continue
+		}
+		if f.PkgPath != "" {
+			return nil, fmt.Errorf("field %s of %s has an xpath tag but is not exported", f.Name, t)
+		}
+		expr, err := xpath.CompileWithNS(tag, namespaces)
+		if err != nil {
+			return nil, fmt.Errorf("field %s of %s: %v", f.Name, t, err)
+		}
+		fields = append(fields, structField{index: i, name: f.Name, expr: expr})
+	}
+	structFields.Store(key, fields)
+	return fields, nil
+}
+
+var (
+	nodeType            = reflect.TypeOf((*Node)(nil))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// unmarshalStruct sets the tagged fields of the struct v from nav.
+func unmarshalStruct(v reflect.Value, nav *NodeNavigator, namespaces map[string]string) error {
+	fields, err := fieldsOf(v.Type(), namespaces)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		if err := unmarshalField(v.Field(f.index), f.expr, nav, namespaces); err != nil {
+			return fmt.Errorf("field %s of %s: %v", f.name, v.Type(), err)
+		}
+	}
+	return nil
+}
+
+// unmarshalField sets v to the value of expr evaluated from nav.
+func unmarshalField(v reflect.Value, expr *xpath.Expr, nav *NodeNavigator, namespaces map[string]string) error {
+	res, err := expr.EvaluateWithOptions(nav.Copy(), xpath.EvalOptions{RecoverPanics: true})
+	if err != nil {
+		return err
+	}
+	t, ok := res.(*xpath.NodeIterator)
+	if !ok {
+		if v.Kind() == reflect.Slice && v.Type() != nodeSliceType {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(elem, res); err != nil {
+				return err
+			}
+			v.Set(reflect.Append(v.Slice(0, 0), elem))
+			return nil
+		}
+		return setValue(v, res)
+	}
+	var nodes []*NodeNavigator
+	for t.MoveNext() {
+		n, ok := unwrap(t.Current()).(*NodeNavigator)
+		if !ok {
+			return errors.New("expression selected a foreign node")
+		}
+		nodes = append(nodes, n.Copy().(*NodeNavigator))
+		if v.Kind() != reflect.Slice {
+			break
+		}
+	}
+	if err := t.Err(); err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	if v.Kind() != reflect.Slice {
+		return setNode(v, nodes[0], namespaces)
+	}
+	list := reflect.MakeSlice(v.Type(), len(nodes), len(nodes))
+	for i, n := range nodes {
+		if err := setNode(list.Index(i), n, namespaces); err != nil {
+			return err
+		}
+	}
+	v.Set(list)
+	return nil
+}
+
+var nodeSliceType = reflect.TypeOf([]*Node(nil))
+
+// setNode sets v from the node of nav.
+func setNode(v reflect.Value, nav *NodeNavigator, namespaces map[string]string) error {
+	if v.Type() == nodeType {
+		v.Set(reflect.ValueOf(nav.node()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setNode(v.Elem(), nav, namespaces)
+	}
+	if v.Kind() == reflect.Struct && !v.Addr().Type().Implements(textUnmarshalerType) {
+		return unmarshalStruct(v, nav, namespaces)
+	}
+	return setValue(v, nav.Value())
+}
+
+// setValue sets v to the string, number or boolean x, converted to the
+// type of v.
+func setValue(v reflect.Value, x interface{}) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setValue(v.Elem(), x)
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(valueString(x)))
+	}
+	s, isString := x.(string)
+	s = strings.TrimSpace(s)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(valueString(x))
+		return nil
+	case reflect.Bool:
+		switch x := x.(type) {
+		case bool:
+			v.SetBool(x)
+		case float64:
+			v.SetBool(x != 0 && !math.IsNaN(x))
+		default:
+			// The lexical forms of xs:boolean.
+			switch s {
+			case "true", "1":
+				v.SetBool(true)
+			case "false", "0":
+				v.SetBool(false)
+			default:
+				return fmt.Errorf("%q is not a boolean", s)
+			}
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if isString {
+			i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+			if err != nil {
+				return err
+			}
+			v.SetInt(i)
+			return nil
+		}
+		f, ok := x.(float64)
+		if !ok || f != math.Trunc(f) || v.OverflowInt(int64(f)) {
+			return fmt.Errorf("%v is not a %s", x, v.Type())
+		}
+		v.SetInt(int64(f))
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if isString {
+			i, err := strconv.ParseUint(s, 10, v.Type().Bits())
+			if err != nil {
+				return err
+			}
+			v.SetUint(i)
+			return nil
+		}
+		f, ok := x.(float64)
+		if !ok || f != math.Trunc(f) || f < 0 || v.OverflowUint(uint64(f)) {
+			return fmt.Errorf("%v is not a %s", x, v.Type())
+		}
+		v.SetUint(uint64(f))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f, ok := x.(float64)
+		if isString {
+			var err error
+			if f, err = strconv.ParseFloat(s, v.Type().Bits()); err != nil {
+				return err
+			}
+		} else if !ok {
+			return fmt.Errorf("%v is not a %s", x, v.Type())
+		}
+		v.SetFloat(f)
+		return nil
+	}
+	return fmt.Errorf("cannot unmarshal into a %s", v.Type())
+}
+
+// valueString returns the string of the value x of an expression.
+func valueString(x interface{}) string {
+	switch x := x.(type) {
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(x)
+	}
+	return fmt.Sprint(x)
+}
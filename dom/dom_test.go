@@ -0,0 +1,558 @@
+package dom
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/antchfx/xpath"
+)
+
+const bookstore = `<?xml version="1.0" encoding="UTF-8"?>
+<bookstore xmlns:b="urn:books">
+  <!-- store -->
+  <book category="cooking"><title lang="en">Everyday Italian</title><price>30.00</price></book>
+  <b:book category="web"><title lang="en">Learning XML</title><price>39.95</price></b:book>
+</bookstore>`
+
+func mustParse(t *testing.T, s string) *Node {
+	t.Helper()
+	doc, err := ParseBytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestParse(t *testing.T) {
+	doc := mustParse(t, bookstore)
+	root := doc.FirstChild
+	if root.Type != ElementNode || root.Data != "bookstore" {
+		t.Fatalf("unexpected root element %q", root.Data)
+	}
+	book, err := Query(doc, "//b:book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if book == nil || book.NamespaceURI != "urn:books" || book.Prefix != "b" {
+		t.Fatalf("namespaced element was not parsed correctly: %+v", book)
+	}
+	if v, _ := book.SelectAttr("category"); v != "web" {
+		t.Fatalf("expected category 'web', got %q", v)
+	}
+
+	for _, s := range []string{`<a><b></a>`, `<a>`, `<p:a/>`, `text<a/>`} {
+		if _, err := ParseBytes([]byte(s)); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	doc := mustParse(t, bookstore)
+	list, err := QueryAll(doc, "//title/@lang")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Type != AttributeNode || list[0].InnerText() != "en" {
+		t.Fatalf("unexpected attribute results: %v", list)
+	}
+	if list[0].Parent.Data != "title" {
+		t.Fatalf("attribute parent should be the owner element")
+	}
+
+	// xmlns declarations are not attributes in the XPath data model.
+	v := xpath.MustCompile("count(/bookstore/@*)").Evaluate(CreateNavigator(doc))
+	if v.(float64) != 0 {
+		t.Fatalf("expected no attributes on bookstore, got %v", v)
+	}
+	v = xpath.MustCompile("sum(//price)").Evaluate(CreateNavigator(doc))
+	if v.(float64) != 69.95 {
+		t.Fatalf("expected sum 69.95, got %v", v)
+	}
+	exp, _ := xpath.CompileWithNS("//x:book/title", map[string]string{"x": "urn:books"})
+	list, _ = Select(doc, exp)
+	if len(list) != 1 || list[0].InnerText() != "Learning XML" {
+		t.Fatalf("namespace query failed: %v", list)
+	}
+	if _, err := QueryAll(doc, "//["); err == nil {
+		t.Fatal("expected compile error")
+	}
+}
+
+func TestMutation(t *testing.T) {
+	doc := NewDocument()
+	root := NewElement("root")
+	doc.AppendChild(root)
+	b := NewElement("b")
+	root.AppendChild(b)
+	a := NewElement("a")
+	root.InsertBefore(a, b)
+	c := NewElement("c")
+	root.InsertBefore(c, nil)
+	b.SetAttribute("id", "1")
+	b.SetAttribute("id", "2")
+	a.AppendChild(NewText("x < y"))
+	root.AppendChild(NewComment(" end "))
+
+	if got, want := doc.OutputXML(true), `<root><a>x &lt; y</a><b id="2"/><c/><!-- end --></root>`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	root.RemoveChild(b)
+	b.RemoveAttribute("id")
+	if len(b.Attr) != 0 || b.Parent != nil {
+		t.Fatal("b was not detached")
+	}
+	// Moving a node removes it from its previous parent.
+	a.AppendChild(c)
+	if got, want := root.OutputXML(false), `<a>x &lt; y<c/></a><!-- end -->`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic removing a non-child")
+			}
+		}()
+		root.RemoveChild(b)
+	}()
+}
+
+func TestRoundTrip(t *testing.T) {
+	s := `<a x="1&amp;2"><?pi data?><b>t</b><!--c--></a>`
+	doc := mustParse(t, s)
+	if got := doc.OutputXML(false); got != s {
+		t.Fatalf("got %s, want %s", got, s)
+	}
+	// Processing instructions are invisible to XPath.
+	v := xpath.MustCompile("count(/a/node())").Evaluate(CreateNavigator(doc))
+	if v.(float64) != 2 {
+		t.Fatalf("expected 2 child nodes, got %v", v)
+	}
+	if !strings.Contains(doc.FirstChild.InnerText(), "t") {
+		t.Fatal("unexpected inner text")
+	}
+}
+
+func TestCDATA(t *testing.T) {
+	s := `<a>x<![CDATA[<y>]]>z</a>`
+	doc := mustParse(t, s)
+	// By default CDATA is merged into a single text node.
+	a := doc.FirstChild
+	if a.FirstChild != a.LastChild || a.FirstChild.Data != "x<y>z" {
+		t.Fatalf("CDATA was not merged: %q", a.OutputXML(false))
+	}
+
+	doc, err := ParseWithOptions(strings.NewReader(s), ParseOptions{KeepCDATA: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a = doc.FirstChild
+	if a.FirstChild.NextSibling.Type != CDATASectionNode {
+		t.Fatal("expected a CDATA section node")
+	}
+	if got := doc.OutputXML(false); got != s {
+		t.Fatalf("got %s, want %s", got, s)
+	}
+	nav := CreateNavigator(doc)
+	if v := xpath.MustCompile("count(/a/text())").Evaluate(nav); v.(float64) != 3 {
+		t.Fatalf("expected 3 text nodes, got %v", v)
+	}
+	if v := xpath.MustCompile("string(/a/text()[2])").Evaluate(nav); v != "<y>" {
+		t.Fatalf("expected CDATA text, got %v", v)
+	}
+	if v := xpath.MustCompile("string(/a)").Evaluate(nav); v != "x<y>z" {
+		t.Fatalf("unexpected string value %v", v)
+	}
+}
+
+func TestEntityReferences(t *testing.T) {
+	s := `<a>1 &lt; &custom; &#65;&unknown;!</a>`
+	if _, err := ParseBytes([]byte(s)); err == nil {
+		t.Fatal("expected error for undeclared entity")
+	}
+	doc, err := ParseWithOptions(strings.NewReader(s), ParseOptions{
+		Entities:             map[string]string{"custom": "C"},
+		KeepEntityReferences: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := doc.FirstChild
+	if got := a.InnerText(); got != "1 < C A&unknown;!" {
+		t.Fatalf("unexpected inner text %q", got)
+	}
+	ref := a.FirstChild.NextSibling
+	if ref.Type != EntityReferenceNode || ref.Data != "unknown" {
+		t.Fatalf("expected entity reference node, got %+v", ref)
+	}
+	if got, want := a.OutputXML(true), `<a>1 &lt; C A&unknown;!</a>`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+
+	doc, err = ParseWithOptions(strings.NewReader(`<a>&custom;</a>`), ParseOptions{
+		Entities: map[string]string{"custom": "C"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.FirstChild.InnerText(); got != "C" {
+		t.Fatalf("entity was not expanded: %q", got)
+	}
+}
+
+func TestParseHTML(t *testing.T) {
+	s := `<!DOCTYPE html>
+<HTML><head><meta charset=utf-8><title>A &amp; B&nbsp;&copy;</title></head>
+<body><P class=intro>one<br>two<p>three</b></div></p></P><img src="a.png" xlink:href="b">
+<ul><li>1<li>2</ul></body>`
+	if _, err := ParseBytes([]byte(s)); err == nil {
+		t.Fatal("expected error parsing HTML as XML")
+	}
+	doc, err := ParseWithOptions(strings.NewReader(s), ParseOptions{HTML: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nav := CreateNavigator(doc)
+	for _, test := range []struct {
+		expr string
+		want interface{}
+	}{
+		{"string(/html/head/title)", "A & B\u00a0\u00a9"},
+		{"string(//meta/@charset)", "utf-8"},
+		{"string(//p[@class='intro'])", "onetwothree"},
+		{"count(//p/br)", float64(1)},
+		{"count(//p//p)", float64(1)},
+		{"string(//img/@xlink:href)", "b"},
+		{"count(//img/node())", float64(0)},
+		// The elements left open are closed at the next end tag of an
+		// ancestor, or at the end of the document.
+		{"count(//ul/li/li)", float64(1)},
+		{"string(//ul)", "12"},
+	} {
+		exp, err := xpath.CompileWithNS(test.expr, map[string]string{"xlink": ""})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := exp.Evaluate(nav); got != test.want {
+			t.Errorf("%s = %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	doc, err := ParseJSONBytes([]byte(`{"a": [1, "x", true, null, ""], "b c": {"d": 2.50}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nav := CreateNavigator(doc)
+	for _, test := range []struct {
+		expr string
+		want interface{}
+	}{
+		{"count(/a/*)", float64(5)},
+		{"string(/a/*[1])", "1"},
+		{"string(/a/*[3])", "true"},
+		{"count(/a/*[4]/node())", float64(0)},
+		{"count(/a/*[5]/node())", float64(0)},
+		{"string(/*[name() = 'b c']/d)", "2.50"},
+	} {
+		if got := xpath.MustCompile(test.expr).Evaluate(nav); got != test.want {
+			t.Errorf("%s = %v, want %v", test.expr, got, test.want)
+		}
+	}
+	for _, s := range []string{``, `{"a": `, `[1] 2`, `{"a" 1}`} {
+		if _, err := ParseJSONBytes([]byte(s)); err == nil {
+			t.Errorf("ParseJSON(%q): expected error", s)
+		}
+	}
+}
+
+func TestSelectFragment(t *testing.T) {
+	doc := mustParse(t, bookstore)
+	books, err := QueryAll(doc, "/bookstore/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := SelectFragment(books[1:], xpath.MustCompile("//title"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].InnerText() != "Learning XML" {
+		t.Fatalf("unexpected fragment results: %v", list)
+	}
+	if _, err := SelectFragment(books, xpath.MustCompile("/")); err == nil {
+		t.Fatal("expected error selecting the fragment root")
+	}
+
+	// Wrapped navigators are unwrapped in results.
+	list, _ = Select(doc, xpath.MustCompile("//price"))
+	nav := xpath.SubtreeOnly(CreateNavigator(books[0]))
+	iter := xpath.MustCompile("//price").Select(nav)
+	if !iter.MoveNext() || unwrap(iter.Current()).(*NodeNavigator).Current() != list[0] {
+		t.Fatal("expected the first price element")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	doc := mustParse(t, bookstore)
+	snap := Snapshot(CreateNavigator(doc))
+	// Namespace declarations are recreated where they are used.
+	want := strings.NewReplacer(` xmlns:b="urn:books"`, "", `b:book category="web"`, `b:book category="web" xmlns:b="urn:books"`).Replace(doc.OutputXML(false))
+	if got := snap.OutputXML(false); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	book, _ := Query(snap, "//b:book")
+	if book == nil || book.NamespaceURI != "urn:books" {
+		t.Fatal("namespace was not copied")
+	}
+
+	// Mutating the source leaves the snapshot unchanged.
+	doc.FirstChild.RemoveChild(doc.FirstChild.LastChild.PrevSibling)
+	if list, _ := QueryAll(snap, "//book | //b:book"); len(list) != 2 {
+		t.Fatalf("expected 2 books in snapshot, got %d", len(list))
+	}
+
+	// Snapshots can be taken through any navigator, on any node.
+	nav := xpath.ElementsOnly(CreateNavigator(doc))
+	iter := xpath.MustCompile("//book").Select(nav)
+	if !iter.MoveNext() {
+		t.Fatal("expected a book")
+	}
+	if got, want := Snapshot(iter.Current()).OutputXML(true), `<book category="cooking"><title lang="en"/><price/></book>`; got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	iter = xpath.MustCompile("//@lang").Select(CreateNavigator(doc))
+	if !iter.MoveNext() {
+		t.Fatal("expected an attribute")
+	}
+	if attr := Snapshot(iter.Current()); attr.Type != AttributeNode || attr.InnerText() != "en" {
+		t.Fatalf("unexpected attribute snapshot %+v", attr)
+	}
+}
+
+func TestUnionDocumentOrder(t *testing.T) {
+	doc := mustParse(t, bookstore)
+	list, err := QueryAll(doc, "//price | //@category | //title | //*[@category]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, n := range list {
+		got = append(got, n.Data)
+	}
+	want := "book category title price book category title price"
+	if s := strings.Join(got, " "); s != want {
+		t.Fatalf("expected %q, got %q", want, s)
+	}
+
+	nav := CreateNavigator(doc)
+	other := CreateNavigator(doc)
+	other.MoveToChild()
+	if c, ok := nav.ComparePosition(other); !ok || c != -1 {
+		t.Fatalf("expected the root before the document element, got %d, %v", c, ok)
+	}
+	if _, ok := nav.ComparePosition(CreateNavigator(mustParse(t, bookstore))); ok {
+		t.Fatal("nodes of different documents should not compare")
+	}
+}
+
+func mustPattern(t *testing.T, s string) *xpath.Pattern {
+	t.Helper()
+	p, err := xpath.CompilePattern(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestStream(t *testing.T) {
+	const feed = `<feed><title>News</title>` +
+		`<item id="1"><price>5</price><note>a</note></item>` +
+		`<item id="2"><price>20</price><note>b</note></item>` +
+		`<item id="3"><price>30</price></item></feed>`
+	s := NewStream()
+	var got []string
+	err := s.Subscribe(mustPattern(t, "feed/item[price > 10]"), func(item *Node) error {
+		// The items before were removed, but the matched one is whole.
+		if item.PrevSibling != nil && item.PrevSibling.Data == "item" {
+			t.Errorf("item %v was kept", item.PrevSibling.Attr)
+		}
+		id, _ := item.SelectAttr("id")
+		got = append(got, id+":"+item.InnerText())
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Subscribe(mustPattern(t, "title/text()"), func(n *Node) error {
+		got = append(got, n.Data)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Parse(strings.NewReader(feed)); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.Join(got, " "); s != "News 2:20b 3:30" {
+		t.Fatalf("unexpected stream matches %q", s)
+	}
+
+	if err := NewStream().Subscribe(mustPattern(t, "item[last()]"), nil); err == nil {
+		t.Error("expected error subscribing to a positional pattern")
+	}
+	for _, s := range []string{`<a><b></a>`, `<a>`, `text<a/>`} {
+		if err := NewStream().Parse(strings.NewReader(s)); err == nil {
+			t.Errorf("expected error streaming %q", s)
+		}
+	}
+}
+
+func TestResolvers(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.xml":     {Data: []byte(`<a><ref>b.xml</ref><ref>big.xml</ref></a>`)},
+		"b.xml":     {Data: []byte(`<b>bee</b>`)},
+		"big.xml":   {Data: []byte(`<big>` + strings.Repeat("x", 100) + `</big>`)},
+		"notes.txt": {Data: []byte("some notes")},
+	}
+	eval := func(r xpath.DocumentResolver, expr string) (interface{}, error) {
+		return xpath.MustCompile(expr).EvaluateWithOptions(CreateNavigator(NewDocument()), xpath.EvalOptions{Resolver: r})
+	}
+	r := &FSResolver{FS: fsys, MaxBytes: 64}
+	if v, err := eval(r, `string(doc('/a.xml')/a/ref[1]) = 'b.xml' and doc(doc('a.xml')/a/ref[1]) = 'bee'`); err != nil || v != true {
+		t.Errorf("unexpected documents of the file system: %v, %v", v, err)
+	}
+	if v, err := eval(r, `unparsed-text('file:notes.txt')`); err != nil || v != "some notes" {
+		t.Errorf("unexpected text of the file system: %v, %v", v, err)
+	}
+	for _, expr := range []string{`count(doc('big.xml'))`, `count(doc('c.xml'))`} {
+		if _, err := eval(r, expr); err == nil {
+			t.Errorf("expected error evaluating %s", expr)
+		}
+	}
+
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests++
+		b, err := fsys.ReadFile(strings.TrimPrefix(req.URL.Path, "/"))
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+	h := &HTTPResolver{Client: srv.Client(), MaxBytes: 64, Cache: true}
+	for i := 0; i < 2; i++ {
+		if v, err := eval(h, `string(doc('`+srv.URL+`/b.xml'))`); err != nil || v != "bee" {
+			t.Errorf("unexpected document of the server: %v, %v", v, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the response to be cached, got %d requests", requests)
+	}
+	for _, uri := range []string{"/big.xml", "/c.xml"} {
+		if _, err := eval(h, `unparsed-text('`+srv.URL+uri+`')`); err == nil {
+			t.Errorf("expected error requesting %s", uri)
+		}
+	}
+}
+
+type testSeller struct {
+	Name string `xpath:"@name"`
+}
+
+type testOffer struct {
+	Price    float64 `xpath:"."`
+	Currency string  `xpath:"@currency"`
+}
+
+type testItem struct {
+	ID      int         `xpath:"@id"`
+	Title   string      `xpath:"normalize-space(title)"`
+	OnSale  bool        `xpath:"@sale"`
+	Tags    []string    `xpath:"tag"`
+	Offers  []testOffer `xpath:"offer"`
+	Seller  *testSeller `xpath:"seller"`
+	Count   uint8       `xpath:"count(offer)"`
+	Node    *Node       `xpath:"."`
+	Missing *testSeller `xpath:"missing"`
+	Skipped string      `xpath:"-"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	doc := mustParse(t, `<item id="7" sale="1"><title> A  book </title><tag>x</tag><tag>y</tag>`+
+		`<offer currency="EUR">9.5</offer><offer currency="USD">11</offer><seller name="Ann"/></item>`)
+	var item testItem
+	if err := Unmarshal(doc.FirstChild, &item); err != nil {
+		t.Fatal(err)
+	}
+	if item.ID != 7 || item.Title != "A book" || !item.OnSale || item.Count != 2 {
+		t.Errorf("unexpected scalars %+v", item)
+	}
+	if strings.Join(item.Tags, ",") != "x,y" {
+		t.Errorf("unexpected tags %q", item.Tags)
+	}
+	if len(item.Offers) != 2 || item.Offers[0] != (testOffer{9.5, "EUR"}) || item.Offers[1] != (testOffer{11, "USD"}) {
+		t.Errorf("unexpected offers %+v", item.Offers)
+	}
+	if item.Seller == nil || item.Seller.Name != "Ann" {
+		t.Errorf("unexpected seller %+v", item.Seller)
+	}
+	if item.Node != doc.FirstChild || item.Missing != nil {
+		t.Errorf("unexpected node %v or missing %v", item.Node, item.Missing)
+	}
+
+	var bad struct {
+		ID int `xpath:"title"`
+	}
+	if err := Unmarshal(doc.FirstChild, &bad); err == nil || !strings.Contains(err.Error(), "field ID") {
+		t.Errorf("expected error on field ID, got %v", err)
+	}
+	var unexported struct {
+		id string `xpath:"@id"`
+	}
+	if err := Unmarshal(doc, &unexported); err == nil {
+		t.Error("expected error unmarshaling an unexported field")
+	}
+	if err := Unmarshal(doc, item); err == nil {
+		t.Error("expected error unmarshaling into a struct value")
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	const feed = `<feed xmlns:m="urn:m"><title>News</title>` +
+		`<item id="1"><title>One</title><m:tag>a</m:tag></item>` +
+		`<item id="2"><title>Two</title></item></feed>`
+	d, err := NewDecoder(strings.NewReader(feed), mustPattern(t, "feed/item"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Namespaces = map[string]string{"x": "urn:m"}
+	var got []string
+	for {
+		var item struct {
+			ID    string   `xpath:"@id"`
+			Title string   `xpath:"title"`
+			Tags  []string `xpath:"x:tag"`
+		}
+		err := d.Decode(&item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, item.ID+":"+item.Title+":"+strings.Join(item.Tags, ","))
+	}
+	if s := strings.Join(got, " "); s != "1:One:a 2:Two:" {
+		t.Errorf("unexpected items %q", s)
+	}
+	if _, err := NewDecoder(strings.NewReader(feed), mustPattern(t, "item[1]")); err == nil {
+		t.Error("expected error decoding with a positional pattern")
+	}
+}
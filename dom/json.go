@@ -0,0 +1,77 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ParseJSON returns the document tree of the JSON value read from r, for
+// xpath.CompileJSONPath. The members of an object are elements named by
+// their keys, as they are, and the items of an array are elements with no
+// name; the elements of an object or array value are the children of
+// that of the member or item, and those of the value itself the children
+// of the document. A string, number or boolean is the text of its
+// element, as written for a number; null and the empty string have no
+// text. The names need not be XML names: the document is for evaluating
+// expressions, not for writing XML.
+func ParseJSON(r io.Reader) (*Node, error) {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	doc := NewDocument()
+	if err := parseJSONValue(d, doc); err != nil {
+		return nil, err
+	}
+	if _, err := d.Token(); err != io.EOF {
+		return nil, fmt.Errorf("dom: json: data after the value")
+	}
+	return doc, nil
+}
+
+// ParseJSONBytes returns the document tree of the JSON value in b.
+func ParseJSONBytes(b []byte) (*Node, error) {
+	return ParseJSON(bytes.NewReader(b))
+}
+
+// parseJSONValue reads a value and appends its nodes to n.
+func parseJSONValue(d *json.Decoder, n *Node) error {
+	tok, err := d.Token()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("dom: json: %v", err)
+	}
+	switch tok := tok.(type) {
+	case json.Delim:
+		array := tok == '['
+		for d.More() {
+			child := &Node{Type: ElementNode}
+			if !array {
+				key, err := d.Token()
+				if err != nil {
+					return fmt.Errorf("dom: json: %v", err)
+				}
+				child.Data = key.(string)
+			}
+			n.AppendChild(child)
+			if err := parseJSONValue(d, child); err != nil {
+				return err
+			}
+		}
+		// The closing delimiter.
+		if _, err := d.Token(); err != nil {
+			return fmt.Errorf("dom: json: %v", err)
+		}
+	case string:
+		if tok != "" {
+			n.AppendChild(NewText(tok))
+		}
+	case json.Number:
+		n.AppendChild(NewText(tok.String()))
+	case bool:
+		n.AppendChild(NewText(fmt.Sprint(tok)))
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package dom
+
+import (
+	"errors"
+
+	"github.com/antchfx/xpath"
+)
+
+// CreateNavigator returns a new xpath.NodeNavigator positioned at top.
+// The root of the navigator is the topmost ancestor of top.
+func CreateNavigator(top *Node) *NodeNavigator {
+	root := top
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return &NodeNavigator{root: root, curr: top, attr: -1}
+}
+
+// NodeNavigator is an xpath.NodeNavigator over a Node tree.
+type NodeNavigator struct {
+	root, curr *Node
+	attr       int
+}
+
+// Current returns the node at the navigator position. When positioned on
+// an attribute, it returns the element that owns the attribute.
+func (n *NodeNavigator) Current() *Node {
+	return n.curr
+}
+
+// CurrentAttr returns the attribute at the navigator position, or nil
+// when the navigator is not positioned on an attribute.
+func (n *NodeNavigator) CurrentAttr() *Attr {
+	if n.attr == -1 {
+		return nil
+	}
+	return &n.curr.Attr[n.attr]
+}
+
+func (n *NodeNavigator) NodeType() xpath.NodeType {
+	if n.attr != -1 {
+		return xpath.AttributeNode
+	}
+	switch n.curr.Type {
+	case DocumentNode:
+		return xpath.RootNode
+	case TextNode, CDATASectionNode, EntityReferenceNode:
+		return xpath.TextNode
+	case CommentNode:
+		return xpath.CommentNode
+	}
+	return xpath.ElementNode
+}
+
+func (n *NodeNavigator) LocalName() string {
+	if n.attr != -1 {
+		return n.curr.Attr[n.attr].Name
+	}
+	if n.curr.Type == ElementNode {
+		return n.curr.Data
+	}
+	return ""
+}
+
+func (n *NodeNavigator) Prefix() string {
+	if n.attr != -1 {
+		return n.curr.Attr[n.attr].Prefix
+	}
+	return n.curr.Prefix
+}
+
+// NamespaceURL returns the namespace URI of the current node.
+func (n *NodeNavigator) NamespaceURL() string {
+	if n.attr != -1 {
+		return n.curr.Attr[n.attr].NamespaceURI
+	}
+	return n.curr.NamespaceURI
+}
+
+func (n *NodeNavigator) Value() string {
+	if n.attr != -1 {
+		return n.curr.Attr[n.attr].Value
+	}
+	return n.curr.InnerText()
+}
+
+func (n *NodeNavigator) Copy() xpath.NodeNavigator {
+	n2 := *n
+	return &n2
+}
+
+func (n *NodeNavigator) MoveToRoot() {
+	n.curr = n.root
+	n.attr = -1
+}
+
+func (n *NodeNavigator) MoveToParent() bool {
+	if n.attr != -1 {
+		n.attr = -1
+		return true
+	}
+	if n.curr.Parent != nil {
+		n.curr = n.curr.Parent
+		return true
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToNextAttribute() bool {
+	for i := n.attr + 1; i < len(n.curr.Attr); i++ {
+		if !n.curr.Attr[i].isNamespaceDecl() {
+			n.attr = i
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToChild() bool {
+	if n.attr != -1 {
+		return false
+	}
+	for node := n.curr.FirstChild; node != nil; node = node.NextSibling {
+		if isVisible(node) {
+			n.curr = node
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToFirst() bool {
+	if n.attr != -1 {
+		return false
+	}
+	moved := false
+	for node := n.curr.PrevSibling; node != nil; node = node.PrevSibling {
+		if isVisible(node) {
+			n.curr = node
+			moved = true
+		}
+	}
+	return moved
+}
+
+func (n *NodeNavigator) MoveToNext() bool {
+	if n.attr != -1 {
+		return false
+	}
+	for node := n.curr.NextSibling; node != nil; node = node.NextSibling {
+		if isVisible(node) {
+			n.curr = node
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToPrevious() bool {
+	if n.attr != -1 {
+		return false
+	}
+	for node := n.curr.PrevSibling; node != nil; node = node.PrevSibling {
+		if isVisible(node) {
+			n.curr = node
+			return true
+		}
+	}
+	return false
+}
+
+// MoveTo moves n to the node of other, which may be in another document,
+// such as one loaded by doc().
+func (n *NodeNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	node, ok := other.(*NodeNavigator)
+	if !ok {
+		return false
+	}
+	n.root = node.root
+	n.curr = node.curr
+	n.attr = node.attr
+	return true
+}
+
+// ComparePosition implements xpath.DocumentOrderNavigator.
+func (n *NodeNavigator) ComparePosition(other xpath.NodeNavigator) (int, bool) {
+	node, ok := other.(*NodeNavigator)
+	if !ok || node.root != n.root {
+		return 0, false
+	}
+	return comparePosition(n.curr, n.attr, node.curr, node.attr), true
+}
+
+// comparePosition returns the document order of the attribute i of the
+// node a, or a itself when i is -1, and the attribute j of b.
+func comparePosition(a *Node, i int, b *Node, j int) int {
+	if a == b {
+		return compareInts(i, j)
+	}
+	depth := func(n *Node) int {
+		d := 0
+		for ; n.Parent != nil; n = n.Parent {
+			d++
+		}
+		return d
+	}
+	da, db := depth(a), depth(b)
+	for ; da > db; da-- {
+		if a = a.Parent; a == b {
+			return 1
+		}
+	}
+	for ; db > da; db-- {
+		if b = b.Parent; b == a {
+			return -1
+		}
+	}
+	for a.Parent != b.Parent {
+		a, b = a.Parent, b.Parent
+	}
+	// Search both directions at once, so the cost depends on the distance
+	// between the siblings rather than on their number.
+	for next, prev := a.NextSibling, a.PrevSibling; next != nil || prev != nil; {
+		if next == b {
+			return -1
+		}
+		if prev == b {
+			return 1
+		}
+		if next != nil {
+			next = next.NextSibling
+		}
+		if prev != nil {
+			prev = prev.PrevSibling
+		}
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (n *NodeNavigator) String() string {
+	return n.Value()
+}
+
+// isVisible reports whether the node is part of the XPath data model.
+func isVisible(n *Node) bool {
+	return n.Type != ProcessingInstructionNode
+}
+
+// Query returns the first node matching the XPath expression, or nil.
+func Query(top *Node, expr string) (*Node, error) {
+	list, err := QueryAll(top, expr)
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+// QueryAll returns all nodes matching the XPath expression. Matched
+// attributes are returned as detached nodes of type AttributeNode whose
+// Parent is the owner element.
+func QueryAll(top *Node, expr string) ([]*Node, error) {
+	exp, err := xpath.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return Select(top, exp)
+}
+
+// Select returns all nodes matching the compiled expression.
+func Select(top *Node, exp *xpath.Expr) ([]*Node, error) {
+	var list []*Node
+	t := exp.Select(CreateNavigator(top))
+	for t.MoveNext() {
+		nav, ok := unwrap(t.Current()).(*NodeNavigator)
+		if !ok {
+			return nil, errors.New("dom: expression selected a foreign node")
+		}
+		list = append(list, nav.node())
+	}
+	return list, nil
+}
+
+// SelectFragment returns all nodes matching the compiled expression when
+// evaluated over the list of nodes as a document fragment, see
+// xpath.FragmentNavigator.
+func SelectFragment(nodes []*Node, exp *xpath.Expr) ([]*Node, error) {
+	navs := make([]xpath.NodeNavigator, len(nodes))
+	for i, n := range nodes {
+		navs[i] = CreateNavigator(n)
+	}
+	var list []*Node
+	t := exp.Select(xpath.FragmentNavigator(navs...))
+	for t.MoveNext() {
+		nav, ok := unwrap(t.Current()).(*NodeNavigator)
+		if !ok {
+			return nil, errors.New("dom: expression selected the fragment root")
+		}
+		list = append(list, nav.node())
+	}
+	return list, nil
+}
+
+// unwrap returns the navigator wrapped by the xpath navigator wrappers
+// such as xpath.SubtreeOnly.
+func unwrap(nav xpath.NodeNavigator) xpath.NodeNavigator {
+	for {
+		w, ok := nav.(interface{ Unwrap() xpath.NodeNavigator })
+		if !ok {
+			return nav
+		}
+		nav = w.Unwrap()
+	}
+}
+
+// node returns the node at the navigator position, materializing a
+// detached attribute node when positioned on an attribute.
+func (n *NodeNavigator) node() *Node {
+	if n.attr == -1 {
+		return n.curr
+	}
+	attr := n.curr.Attr[n.attr]
+	text := NewText(attr.Value)
+	node := &Node{
+		Type:         AttributeNode,
+		Data:         attr.Name,
+		Prefix:       attr.Prefix,
+		NamespaceURI: attr.NamespaceURI,
+		Parent:       n.curr,
+		FirstChild:   text,
+		LastChild:    text,
+	}
+	text.Parent = node
+	return node
+}
@@ -0,0 +1,240 @@
+// Package dom is a lightweight in-memory XML document model with a built-in
+// xpath.NodeNavigator, so the xpath package can be used standalone without
+// htmlquery or xmlquery.
+package dom
+
+import (
+	"bytes"
+	"strings"
+)
+
+// NodeType is the type of a Node.
+type NodeType uint
+
+const (
+	// DocumentNode is a document object that, as the root of the document
+	// tree, provides access to the entire XML document.
+	DocumentNode NodeType = iota
+	// ElementNode is an element, such as <element>.
+	ElementNode
+	// TextNode is the text content of a node.
+	TextNode
+	// CommentNode is a comment node, such as <!-- my comment -->.
+	CommentNode
+	// ProcessingInstructionNode is a processing instruction, such as
+	// <?target data?>. It is kept for serialization but is not visible
+	// to XPath expressions.
+	ProcessingInstructionNode
+	// AttributeNode is an attribute selected by a query. Attributes of an
+	// element are stored in Node.Attr; nodes of this type are detached
+	// and only report the owner element as Parent.
+	AttributeNode
+	// CDATASectionNode is a CDATA section, such as <![CDATA[text]]>. It is
+	// only created when ParseOptions.KeepCDATA is set, and is seen by XPath
+	// as a text node.
+	CDATASectionNode
+	// EntityReferenceNode is an unresolved entity reference, such as
+	// &name;, with Data holding the entity name. It is only created when
+	// ParseOptions.KeepEntityReferences is set, and is seen by XPath as a
+	// text node whose value is the reference itself.
+	EntityReferenceNode
+)
+
+// Attr is an attribute of an element node.
+type Attr struct {
+	Prefix       string
+	Name         string // local name
+	NamespaceURI string
+	Value        string
+}
+
+// QName returns the qualified name of the attribute.
+func (a *Attr) QName() string {
+	if a.Prefix == "" {
+		return a.Name
+	}
+	return a.Prefix + ":" + a.Name
+}
+
+// isNamespaceDecl reports whether the attribute is a xmlns declaration.
+func (a *Attr) isNamespaceDecl() bool {
+	return a.Prefix == "xmlns" || (a.Prefix == "" && a.Name == "xmlns")
+}
+
+// Node is a node of the document tree.
+type Node struct {
+	Parent, FirstChild, LastChild, PrevSibling, NextSibling *Node
+
+	Type         NodeType
+	Data         string // local name for elements, content otherwise
+	Prefix       string
+	NamespaceURI string
+	Attr         []Attr
+}
+
+// NewDocument returns a new empty document node.
+func NewDocument() *Node {
+	return &Node{Type: DocumentNode}
+}
+
+// NewElement returns a new element node. The name may be qualified
+// ("prefix:local").
+func NewElement(name string) *Node {
+	prefix, local := splitName(name)
+	return &Node{Type: ElementNode, Data: local, Prefix: prefix}
+}
+
+// NewText returns a new text node.
+func NewText(data string) *Node {
+	return &Node{Type: TextNode, Data: data}
+}
+
+// NewComment returns a new comment node.
+func NewComment(data string) *Node {
+	return &Node{Type: CommentNode, Data: data}
+}
+
+func splitName(name string) (prefix, local string) {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// QName returns the qualified name of an element node.
+func (n *Node) QName() string {
+	if n.Prefix == "" {
+		return n.Data
+	}
+	return n.Prefix + ":" + n.Data
+}
+
+// InnerText returns the concatenated text of the node and all its
+// descendants, which is the XPath string-value of an element.
+func (n *Node) InnerText() string {
+	switch n.Type {
+	case TextNode, CDATASectionNode, CommentNode, ProcessingInstructionNode:
+		return n.Data
+	case EntityReferenceNode:
+		return "&" + n.Data + ";"
+	}
+	var b bytes.Buffer
+	var output func(*Node)
+	output = func(node *Node) {
+		switch node.Type {
+		case TextNode, CDATASectionNode:
+			b.WriteString(node.Data)
+			return
+		case EntityReferenceNode:
+			b.WriteString("&" + node.Data + ";")
+			return
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			output(child)
+		}
+	}
+	output(n)
+	return b.String()
+}
+
+// AppendChild adds n as the last child of parent. If n already has a
+// parent it is removed from it first.
+func (parent *Node) AppendChild(n *Node) {
+	if n.Parent != nil {
+		n.Parent.RemoveChild(n)
+	}
+	n.Parent = parent
+	n.NextSibling = nil
+	if parent.FirstChild == nil {
+		parent.FirstChild = n
+		n.PrevSibling = nil
+	} else {
+		parent.LastChild.NextSibling = n
+		n.PrevSibling = parent.LastChild
+	}
+	parent.LastChild = n
+}
+
+// InsertBefore inserts n as a child of parent, immediately before ref.
+// If ref is nil, n is appended as the last child. It panics if ref is not
+// a child of parent.
+func (parent *Node) InsertBefore(n, ref *Node) {
+	if ref == nil {
+		parent.AppendChild(n)
+		return
+	}
+	if ref.Parent != parent {
+		panic("dom: InsertBefore called for a reference node that is not a child")
+	}
+	if n.Parent != nil {
+		n.Parent.RemoveChild(n)
+	}
+	n.Parent = parent
+	n.NextSibling = ref
+	n.PrevSibling = ref.PrevSibling
+	if ref.PrevSibling != nil {
+		ref.PrevSibling.NextSibling = n
+	} else {
+		parent.FirstChild = n
+	}
+	ref.PrevSibling = n
+}
+
+// RemoveChild removes n from the children of parent. It panics if n is
+// not a child of parent.
+func (parent *Node) RemoveChild(n *Node) {
+	if n.Parent != parent {
+		panic("dom: RemoveChild called for a non-child node")
+	}
+	if parent.FirstChild == n {
+		parent.FirstChild = n.NextSibling
+	}
+	if n.NextSibling != nil {
+		n.NextSibling.PrevSibling = n.PrevSibling
+	}
+	if parent.LastChild == n {
+		parent.LastChild = n.PrevSibling
+	}
+	if n.PrevSibling != nil {
+		n.PrevSibling.NextSibling = n.NextSibling
+	}
+	n.Parent = nil
+	n.PrevSibling = nil
+	n.NextSibling = nil
+}
+
+// SelectAttr returns the value of the attribute with the given qualified
+// name, and whether it exists.
+func (n *Node) SelectAttr(name string) (string, bool) {
+	if i := n.attrIndex(name); i >= 0 {
+		return n.Attr[i].Value, true
+	}
+	return "", false
+}
+
+// SetAttribute sets the attribute with the given qualified name,
+// replacing any existing value.
+func (n *Node) SetAttribute(name, value string) {
+	if i := n.attrIndex(name); i >= 0 {
+		n.Attr[i].Value = value
+		return
+	}
+	prefix, local := splitName(name)
+	n.Attr = append(n.Attr, Attr{Prefix: prefix, Name: local, Value: value})
+}
+
+// RemoveAttribute removes the attribute with the given qualified name.
+func (n *Node) RemoveAttribute(name string) {
+	if i := n.attrIndex(name); i >= 0 {
+		n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+	}
+}
+
+func (n *Node) attrIndex(name string) int {
+	for i := range n.Attr {
+		if n.Attr[i].QName() == name {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,82 @@
+package dom
+
+import (
+	"bytes"
+	"strings"
+)
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+// OutputXML returns the XML serialization of the node. If self is true
+// the node itself is included, otherwise only its children are.
+func (n *Node) OutputXML(self bool) string {
+	var b bytes.Buffer
+	if self && n.Type != DocumentNode {
+		outputXML(&b, n)
+	} else {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			outputXML(&b, child)
+		}
+	}
+	return b.String()
+}
+
+func outputXML(b *bytes.Buffer, n *Node) {
+	switch n.Type {
+	case TextNode:
+		b.WriteString(textEscaper.Replace(n.Data))
+		return
+	case CDATASectionNode:
+		b.WriteString("<![CDATA[")
+		b.WriteString(n.Data)
+		b.WriteString("]]>")
+		return
+	case EntityReferenceNode:
+		b.WriteString("&" + n.Data + ";")
+		return
+	case CommentNode:
+		b.WriteString("<!--")
+		b.WriteString(n.Data)
+		b.WriteString("-->")
+		return
+	case ProcessingInstructionNode:
+		b.WriteString("<?")
+		b.WriteString(n.Data)
+		b.WriteString("?>")
+		return
+	case AttributeNode:
+		b.WriteString(n.QName())
+		b.WriteString(`="`)
+		b.WriteString(attrEscaper.Replace(n.InnerText()))
+		b.WriteByte('"')
+		return
+	case DocumentNode:
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			outputXML(b, child)
+		}
+		return
+	}
+	b.WriteByte('<')
+	b.WriteString(n.QName())
+	for _, attr := range n.Attr {
+		b.WriteByte(' ')
+		b.WriteString(attr.QName())
+		b.WriteString(`="`)
+		b.WriteString(attrEscaper.Replace(attr.Value))
+		b.WriteByte('"')
+	}
+	if n.FirstChild == nil {
+		b.WriteString("/>")
+		return
+	}
+	b.WriteByte('>')
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		outputXML(b, child)
+	}
+	b.WriteString("</")
+	b.WriteString(n.QName())
+	b.WriteByte('>')
+}
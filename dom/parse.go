@@ -0,0 +1,318 @@
+package dom
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	xmlNamespaceURI = "http://www.w3.org/XML/1998/namespace"
+	xmlnsURI        = "http://www.w3.org/2000/xmlns/"
+)
+
+// ParseOptions controls how a document is parsed.
+type ParseOptions struct {
+	// KeepCDATA keeps each CDATA section as a separate CDATASectionNode.
+	// By default CDATA content is merged into the adjacent text, as in
+	// the XPath data model.
+	KeepCDATA bool
+
+	// Entities maps entity names to their replacement text, in addition
+	// to the predefined XML entities.
+	Entities map[string]string
+
+	// KeepEntityReferences keeps references to entities that are neither
+	// predefined nor in Entities as EntityReferenceNode nodes, instead of
+	// failing. References inside attribute values are kept verbatim.
+	KeepEntityReferences bool
+
+	// HTML parses HTML that is close to XML, such as that of most
+	// templates, rather than XML: the names are lowercased, the HTML
+	// entities are defined, attributes may have no value, the void
+	// elements such as <br> and the elements left open are closed, the end
+	// tags of no open element are ignored, and undeclared prefixes are
+	// kept in no namespace. It is not an HTML5 parser: the contents of
+	// <script> elements, for example, must still be escaped.
+	HTML bool
+}
+
+// Parse returns the document tree parsed from the XML read from r.
+func Parse(r io.Reader) (*Node, error) {
+	return ParseWithOptions(r, ParseOptions{})
+}
+
+// ParseBytes returns the document tree parsed from the XML in b.
+func ParseBytes(b []byte) (*Node, error) {
+	return parseBytes(b, ParseOptions{})
+}
+
+// ParseWithOptions returns the document tree parsed from the XML read
+// from r, using the given options.
+func ParseWithOptions(r io.Reader, opts ParseOptions) (*Node, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseBytes(b, opts)
+}
+
+func parseBytes(b []byte, opts ParseOptions) (*Node, error) {
+	p := &parser{
+		data:    b,
+		decoder: xml.NewDecoder(bytes.NewReader(b)),
+		doc:     NewDocument(),
+		opts:    opts,
+	}
+	p.decoder.Strict = !opts.KeepEntityReferences && !opts.HTML
+	p.decoder.Entity = opts.Entities
+	if opts.HTML {
+		p.decoder.Entity = make(map[string]string)
+		for name, text := range xml.HTMLEntity {
+			p.decoder.Entity[name] = text
+		}
+		for name, text := range opts.Entities {
+			p.decoder.Entity[name] = text
+		}
+	}
+	p.scopes = []map[string]string{{"xml": xmlNamespaceURI}}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return p.doc, nil
+}
+
+type parser struct {
+	data    []byte
+	decoder *xml.Decoder
+	doc     *Node
+	opts    ParseOptions
+	scopes  []map[string]string
+}
+
+func (p *parser) lookup(prefix string) (string, bool) {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if uri, ok := p.scopes[i][prefix]; ok {
+			return uri, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) parse() error {
+	curr := p.doc
+	for {
+		start := p.decoder.InputOffset()
+		tok, err := p.decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n, err := p.startElement(tok)
+			if err != nil {
+				return err
+			}
+			curr.AppendChild(n)
+			curr = n
+			if p.opts.HTML && isVoidElement(n.Data) {
+				p.scopes = p.scopes[:len(p.scopes)-1]
+				curr = curr.Parent
+			}
+		case xml.EndElement:
+			name := tok.Name.Local
+			if tok.Name.Space != "" {
+				name = tok.Name.Space + ":" + name
+			}
+			if p.opts.HTML {
+				curr = p.closeHTML(curr, strings.ToLower(name))
+				continue
+			}
+			if curr.Type != ElementNode || curr.QName() != name {
+				return fmt.Errorf("dom: unexpected end element </%s>", name)
+			}
+			p.scopes = p.scopes[:len(p.scopes)-1]
+			curr = curr.Parent
+		case xml.CharData:
+			if curr == p.doc {
+				if len(bytes.TrimSpace(tok)) != 0 {
+					return errors.New("dom: text content outside of the root element")
+				}
+				continue
+			}
+			raw := p.data[start:p.decoder.InputOffset()]
+			switch {
+			case bytes.HasPrefix(raw, []byte("<![CDATA[")):
+				if p.opts.KeepCDATA {
+					curr.AppendChild(&Node{Type: CDATASectionNode, Data: string(tok)})
+				} else {
+					appendText(curr, string(tok))
+				}
+			case p.opts.KeepEntityReferences:
+				if err := p.charData(curr, string(raw)); err != nil {
+					return err
+				}
+			default:
+				appendText(curr, string(tok))
+			}
+		case xml.Comment:
+			curr.AppendChild(NewComment(string(tok)))
+		case xml.ProcInst:
+			if tok.Target == "xml" {
+				continue
+			}
+			curr.AppendChild(&Node{Type: ProcessingInstructionNode, Data: tok.Target + " " + string(tok.Inst)})
+		}
+	}
+	if curr != p.doc && !p.opts.HTML {
+		return fmt.Errorf("dom: unclosed element <%s>", curr.QName())
+	}
+	return nil
+}
+
+// isVoidElement reports whether the HTML element name has no end tag.
+func isVoidElement(name string) bool {
+	for _, s := range xml.HTMLAutoClose {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// closeHTML closes the element name, for its end tag, and the elements
+// open in it, and returns the parent of name. If name is not open, curr
+// stays open.
+func (p *parser) closeHTML(curr *Node, name string) *Node {
+	for n, depth := curr, 1; n != p.doc; n, depth = n.Parent, depth+1 {
+		if n.QName() == name {
+			p.scopes = p.scopes[:len(p.scopes)-depth]
+			return n.Parent
+		}
+	}
+	return curr
+}
+
+func (p *parser) startElement(tok xml.StartElement) (*Node, error) {
+	if p.opts.HTML {
+		tok.Name.Local = strings.ToLower(tok.Name.Local)
+		tok.Name.Space = strings.ToLower(tok.Name.Space)
+		for i := range tok.Attr {
+			tok.Attr[i].Name.Local = strings.ToLower(tok.Attr[i].Name.Local)
+			tok.Attr[i].Name.Space = strings.ToLower(tok.Attr[i].Name.Space)
+		}
+	}
+	scope := make(map[string]string)
+	for _, a := range tok.Attr {
+		switch {
+		case a.Name.Space == "xmlns":
+			scope[a.Name.Local] = a.Value
+		case a.Name.Space == "" && a.Name.Local == "xmlns":
+			scope[""] = a.Value
+		}
+	}
+	p.scopes = append(p.scopes, scope)
+
+	n := &Node{Type: ElementNode, Data: tok.Name.Local, Prefix: tok.Name.Space}
+	uri, ok := p.lookup(n.Prefix)
+	if !ok && n.Prefix != "" && !p.opts.HTML {
+		return nil, fmt.Errorf("dom: undeclared namespace prefix %q", n.Prefix)
+	}
+	n.NamespaceURI = uri
+	for _, a := range tok.Attr {
+		attr := Attr{Prefix: a.Name.Space, Name: a.Name.Local, Value: a.Value}
+		switch {
+		case attr.isNamespaceDecl():
+			attr.NamespaceURI = xmlnsURI
+		case attr.Prefix != "":
+			uri, ok := p.lookup(attr.Prefix)
+			if !ok && !p.opts.HTML {
+				return nil, fmt.Errorf("dom: undeclared namespace prefix %q", attr.Prefix)
+			}
+			attr.NamespaceURI = uri
+		}
+		n.Attr = append(n.Attr, attr)
+	}
+	return n, nil
+}
+
+// appendText adds s to the last child of n if it is a text node, or as a
+// new text node otherwise.
+func appendText(n *Node, s string) {
+	if last := n.LastChild; last != nil && last.Type == TextNode {
+		last.Data += s
+	} else {
+		n.AppendChild(NewText(s))
+	}
+}
+
+var predefinedEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": `"`,
+}
+
+// charData decodes the raw character data s, adding text nodes and
+// EntityReferenceNode nodes for unresolved references to n.
+func (p *parser) charData(n *Node, s string) error {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '&')
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:i])
+		j := strings.IndexByte(s[i:], ';')
+		if j < 0 {
+			return errors.New("dom: unterminated entity reference")
+		}
+		name := s[i+1 : i+j]
+		s = s[i+j+1:]
+		if text, ok := p.resolveEntity(name); ok {
+			b.WriteString(text)
+			continue
+		}
+		if b.Len() > 0 {
+			appendText(n, b.String())
+			b.Reset()
+		}
+		n.AppendChild(&Node{Type: EntityReferenceNode, Data: name})
+	}
+	if b.Len() > 0 {
+		appendText(n, b.String())
+	}
+	return nil
+}
+
+func (p *parser) resolveEntity(name string) (string, bool) {
+	if strings.HasPrefix(name, "#") {
+		var (
+			v   uint64
+			err error
+		)
+		if strings.HasPrefix(name, "#x") {
+			v, err = strconv.ParseUint(name[2:], 16, 32)
+		} else {
+			v, err = strconv.ParseUint(name[1:], 10, 32)
+		}
+		if err != nil {
+			return "", false
+		}
+		return string(rune(v)), true
+	}
+	if text, ok := predefinedEntities[name]; ok {
+		return text, true
+	}
+	text, ok := p.opts.Entities[name]
+	return text, ok
+}
@@ -0,0 +1,156 @@
+package dom
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/antchfx/xpath"
+)
+
+// FSResolver is an xpath.DocumentResolver of the files of FS, such as the
+// assets of an embed.FS, by their path. A leading / or file: is ignored.
+type FSResolver struct {
+	FS fs.FS
+
+	// MaxBytes, if not 0, is the size of the largest file read.
+	MaxBytes int64
+
+	// Options are the options the documents are parsed with.
+	Options ParseOptions
+}
+
+// ResolveDocument returns a navigator of the document parsed from the
+// file at uri.
+func (r *FSResolver) ResolveDocument(ctx context.Context, uri string) (xpath.NodeNavigator, error) {
+	b, err := r.read(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return parseResolved(b, r.Options)
+}
+
+// ResolveText returns the text of the file at uri.
+func (r *FSResolver) ResolveText(ctx context.Context, uri string) (string, error) {
+	b, err := r.read(ctx, uri)
+	return string(b), err
+}
+
+func (r *FSResolver) read(ctx context.Context, uri string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	name := strings.TrimLeft(strings.TrimPrefix(uri, "file:"), "/")
+	f, err := r.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readLimited(f, uri, r.MaxBytes)
+}
+
+// HTTPResolver is an xpath.DocumentResolver of the resources of http and
+// https URLs, requested with GET and the context of the evaluation.
+// Responses other than 2xx are errors. It is safe for concurrent use.
+type HTTPResolver struct {
+	// Client makes the requests, or http.DefaultClient if it is nil.
+	Client *http.Client
+
+	// MaxBytes, if not 0, is the size of the largest body read.
+	MaxBytes int64
+
+	// Options are the options the documents are parsed with.
+	Options ParseOptions
+
+	// Cache keeps the bodies of the responses, so that each URL is
+	// requested once for the life of the resolver, whatever the headers
+	// of the response.
+	Cache bool
+
+	mu    sync.Mutex
+	cache map[string][]byte
+}
+
+// ResolveDocument returns a navigator of the document parsed from the
+// body of the response for uri.
+func (r *HTTPResolver) ResolveDocument(ctx context.Context, uri string) (xpath.NodeNavigator, error) {
+	b, err := r.get(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return parseResolved(b, r.Options)
+}
+
+// ResolveText returns the body of the response for uri.
+func (r *HTTPResolver) ResolveText(ctx context.Context, uri string) (string, error) {
+	b, err := r.get(ctx, uri)
+	return string(b), err
+}
+
+func (r *HTTPResolver) get(ctx context.Context, uri string) ([]byte, error) {
+	if r.Cache {
+		r.mu.Lock()
+		b, ok := r.cache[uri]
+		r.mu.Unlock()
+		if ok {
+			return b, nil
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("dom: GET %s: %s", uri, resp.Status)
+	}
+	b, err := readLimited(resp.Body, uri, r.MaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if r.Cache {
+		r.mu.Lock()
+		if r.cache == nil {
+			r.cache = make(map[string][]byte)
+		}
+		r.cache[uri] = b
+		r.mu.Unlock()
+	}
+	return b, nil
+}
+
+// readLimited reads the resource at uri from rd, failing if it is larger
+// than max bytes, unless max is 0.
+func readLimited(rd io.Reader, uri string, max int64) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(rd)
+	}
+	b, err := io.ReadAll(io.LimitReader(rd, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, fmt.Errorf("dom: %s is larger than %d bytes", uri, max)
+	}
+	return b, nil
+}
+
+func parseResolved(b []byte, opts ParseOptions) (xpath.NodeNavigator, error) {
+	doc, err := parseBytes(b, opts)
+	if err != nil {
+		return nil, err
+	}
+	return CreateNavigator(doc), nil
+}
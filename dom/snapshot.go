@@ -0,0 +1,91 @@
+package dom
+
+import "github.com/antchfx/xpath"
+
+// Snapshot returns a deep copy of the node nav is positioned on and all of
+// its descendants, read through any xpath.NodeNavigator implementation.
+// The copy does not share any state with the source document, so it stays
+// valid when the source is mutated or reused. Call nav.MoveToRoot first
+// to copy the whole document.
+//
+// A root node is copied as a DocumentNode, and an attribute as a detached
+// AttributeNode without parent.
+func Snapshot(nav xpath.NodeNavigator) *Node {
+	return snapshot(nav.Copy(), nil)
+}
+
+// snapshot copies the node at nav. Navigators do not expose namespace
+// declarations, so they are recreated from the namespace URIs, with
+// scope holding the prefixes declared by the ancestors of the copy.
+func snapshot(nav xpath.NodeNavigator, scope map[string]string) *Node {
+	var n *Node
+	switch nav.NodeType() {
+	case xpath.RootNode:
+		n = NewDocument()
+	case xpath.ElementNode:
+		n = &Node{Type: ElementNode, Data: nav.LocalName(), Prefix: nav.Prefix(), NamespaceURI: namespaceURL(nav)}
+		attr := nav.Copy()
+		for attr.MoveToNextAttribute() {
+			n.Attr = append(n.Attr, Attr{
+				Prefix:       attr.Prefix(),
+				Name:         attr.LocalName(),
+				NamespaceURI: namespaceURL(attr),
+				Value:        attr.Value(),
+			})
+		}
+		scope = declareNamespaces(n, scope)
+	case xpath.AttributeNode:
+		n = &Node{Type: AttributeNode, Data: nav.LocalName(), Prefix: nav.Prefix(), NamespaceURI: namespaceURL(nav)}
+		n.AppendChild(NewText(nav.Value()))
+		return n
+	case xpath.TextNode:
+		return NewText(nav.Value())
+	case xpath.CommentNode:
+		return NewComment(nav.Value())
+	default:
+		return nil
+	}
+	for ok := nav.MoveToChild(); ok; ok = nav.MoveToNext() {
+		if child := snapshot(nav.Copy(), scope); child != nil {
+			n.AppendChild(child)
+		}
+	}
+	return n
+}
+
+func namespaceURL(nav xpath.NodeNavigator) string {
+	if ns, ok := nav.(interface{ NamespaceURL() string }); ok {
+		return ns.NamespaceURL()
+	}
+	return ""
+}
+
+// declareNamespaces adds the xmlns attributes n needs for its prefixes
+// that are not bound to the same URI in scope, and returns the scope of
+// the children of n.
+func declareNamespaces(n *Node, scope map[string]string) map[string]string {
+	declare := func(prefix, uri string) {
+		if prefix == "xml" || (uri == "" && prefix != "") || scope[prefix] == uri {
+			return
+		}
+		next := make(map[string]string, len(scope)+1)
+		for k, v := range scope {
+			next[k] = v
+		}
+		next[prefix] = uri
+		scope = next
+		if prefix == "" {
+			n.Attr = append(n.Attr, Attr{Name: "xmlns", NamespaceURI: xmlnsURI, Value: uri})
+		} else {
+			n.Attr = append(n.Attr, Attr{Prefix: "xmlns", Name: prefix, NamespaceURI: xmlnsURI, Value: uri})
+		}
+	}
+	count := len(n.Attr)
+	declare(n.Prefix, n.NamespaceURI)
+	for i := 0; i < count; i++ {
+		if a := n.Attr[i]; a.Prefix != "" && !a.isNamespaceDecl() {
+			declare(a.Prefix, a.NamespaceURI)
+		}
+	}
+	return scope
+}
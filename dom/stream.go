@@ -0,0 +1,218 @@
+package dom
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antchfx/xpath"
+)
+
+// A Stream reads a document as a stream of tokens, and calls the
+// functions subscribed to patterns with the nodes they match as the
+// nodes end, so that large documents, such as feeds, can be filtered
+// without building their tree. Only the open elements, with their
+// attributes, and the content of those a pattern may match are kept: the
+// other nodes are removed from the tree once they end.
+//
+//	s := dom.NewStream()
+//	p, _ := xpath.CompilePattern("feed/item[price > 10]")
+//	s.Subscribe(p, func(item *dom.Node) error {
+//		fmt.Println(item.OutputXML(true))
+//		return nil
+//	})
+//	err := s.Parse(r)
+type Stream struct {
+	p    *parser
+	curr *Node
+	// keep reports, for the document and each open element, whether its
+	// content is kept.
+	keep []bool
+	text []byte
+	subs []subscription
+	err  error
+}
+
+type subscription struct {
+	pattern *xpath.Pattern
+	fn      func(*Node) error
+}
+
+// NewStream returns a stream of the tokens of a new document.
+func NewStream() *Stream {
+	doc := NewDocument()
+	p := &parser{doc: doc, scopes: []map[string]string{{"xml": xmlNamespaceURI}}}
+	return &Stream{p: p, curr: doc}
+}
+
+// Subscribe calls fn with each node the pattern matches, at its end, with
+// its content and its ancestors. The node is in the tree during the call,
+// and fn may keep it, but not its ancestors, whose content may be
+// removed. Patterns are matched in the order of the subscriptions, before
+// the first token is pushed. The pattern must be streamable, see
+// xpath.Pattern.Streamable. Attributes are not nodes of their own here:
+// subscribe to their elements, as in item[@id]. If fn returns an error,
+// the stream stops with it.
+func (s *Stream) Subscribe(p *xpath.Pattern, fn func(*Node) error) error {
+	if !p.Streamable() {
+		return fmt.Errorf("dom: pattern %s is not streamable", p)
+	}
+	s.subs = append(s.subs, subscription{p, fn})
+	return nil
+}
+
+// Parse pushes the tokens of the XML read from r, then closes the stream.
+func (s *Stream) Parse(r io.Reader) error {
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.Push(tok); err != nil {
+			return err
+		}
+	}
+	return s.Close()
+}
+
+// Push reads the next token of the document, as returned by the RawToken
+// method of an xml.Decoder, with the prefixes of the names in their
+// Space. The functions of the nodes it ends are called before it
+// returns.
+func (s *Stream) Push(tok xml.Token) error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.keep) == 0 {
+		s.keep = append(s.keep, s.opens(s.p.doc))
+	}
+	if text, ok := tok.(xml.CharData); ok {
+		s.text = append(s.text, text...)
+		return nil
+	}
+	s.err = s.push(tok)
+	return s.err
+}
+
+func (s *Stream) push(tok xml.Token) error {
+	if err := s.flush(); err != nil {
+		return err
+	}
+	switch tok := tok.(type) {
+	case xml.StartElement:
+		n, err := s.p.startElement(tok)
+		if err != nil {
+			return err
+		}
+		s.curr.AppendChild(n)
+		s.curr = n
+		s.keep = append(s.keep, s.keep[len(s.keep)-1] || s.opens(n))
+	case xml.EndElement:
+		name := tok.Name.Local
+		if tok.Name.Space != "" {
+			name = tok.Name.Space + ":" + name
+		}
+		if s.curr.Type != ElementNode || s.curr.QName() != name {
+			return fmt.Errorf("dom: unexpected end element </%s>", name)
+		}
+		s.p.scopes = s.p.scopes[:len(s.p.scopes)-1]
+		n := s.curr
+		s.curr = n.Parent
+		s.keep = s.keep[:len(s.keep)-1]
+		return s.end(n)
+	case xml.Comment:
+		n := NewComment(string(tok))
+		s.curr.AppendChild(n)
+		return s.end(n)
+	case xml.ProcInst:
+		if tok.Target == "xml" {
+			return nil
+		}
+		n := &Node{Type: ProcessingInstructionNode, Data: tok.Target + " " + string(tok.Inst)}
+		s.curr.AppendChild(n)
+		return s.end(n)
+	}
+	return nil
+}
+
+// Close ends the document, and calls the functions of the patterns that
+// match it, such as /.
+func (s *Stream) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	if len(s.keep) == 0 {
+		s.keep = append(s.keep, s.opens(s.p.doc))
+	}
+	if s.err = s.flush(); s.err != nil {
+		return s.err
+	}
+	if s.curr != s.p.doc {
+		s.err = fmt.Errorf("dom: unclosed element <%s>", s.curr.QName())
+		return s.err
+	}
+	s.err = s.match(s.p.doc)
+	return s.err
+}
+
+// flush adds the text read since the last node.
+func (s *Stream) flush() error {
+	if len(s.text) == 0 {
+		return nil
+	}
+	text := string(s.text)
+	s.text = s.text[:0]
+	if s.curr == s.p.doc {
+		if strings.TrimSpace(text) != "" {
+			return errors.New("dom: text content outside of the root element")
+		}
+		return nil
+	}
+	n := NewText(text)
+	s.curr.AppendChild(n)
+	return s.end(n)
+}
+
+// opens reports whether a pattern may match n, whose content is not
+// known yet.
+func (s *Stream) opens(n *Node) bool {
+	nav := CreateNavigator(n)
+	for _, sub := range s.subs {
+		if sub.pattern.MatchesOpen(nav) {
+			return true
+		}
+	}
+	return false
+}
+
+// match calls the functions of the patterns that match n.
+func (s *Stream) match(n *Node) error {
+	nav := CreateNavigator(n)
+	for _, sub := range s.subs {
+		if sub.pattern.Matches(nav) {
+			if err := sub.fn(n); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// end calls the functions of the patterns that match n, which ended, and
+// removes it unless the content of its parent is kept.
+func (s *Stream) end(n *Node) error {
+	parent := n.Parent
+	if err := s.match(n); err != nil {
+		return err
+	}
+	if !s.keep[len(s.keep)-1] && n.Parent == parent {
+		parent.RemoveChild(n)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+module github.com/antchfx/xpath/etreenav
+
+go 1.18
+
+require (
+	github.com/antchfx/xpath v0.0.0
+	github.com/beevik/etree v1.4.0
+)
+
+replace github.com/antchfx/xpath => ../
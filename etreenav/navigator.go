@@ -0,0 +1,277 @@
+// Package etreenav evaluates XPath expressions on the documents of
+// beevik/etree, so that the programs holding etree documents query them
+// with this engine without serializing and parsing them again:
+//
+//	doc := etree.NewDocument()
+//	_ = doc.ReadFromFile("books.xml")
+//	nav := etreenav.CreateNavigator(doc)
+//	total := xpath.MustCompile("sum(//book/price)").Evaluate(nav)
+//	books := etreenav.FindElements(doc.Root(), xpath.MustCompile("//book[price > 35]"))
+//
+// It is a module of its own, so that the xpath module does not depend on
+// etree.
+package etreenav
+
+import (
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"github.com/beevik/etree"
+)
+
+// CreateNavigator returns a new xpath.NodeNavigator positioned at the
+// document node of doc.
+func CreateNavigator(doc *etree.Document) *NodeNavigator {
+	return CreateElementNavigator(&doc.Element)
+}
+
+// CreateElementNavigator returns a new xpath.NodeNavigator positioned at
+// e. The root of the navigator is the topmost ancestor of e, which is the
+// document node if it is the element of an etree.Document, without a
+// tag.
+func CreateElementNavigator(e *etree.Element) *NodeNavigator {
+	root := e
+	for root.Parent() != nil {
+		root = root.Parent()
+	}
+	return &NodeNavigator{root: root, curr: e, attr: -1}
+}
+
+// NodeNavigator is an xpath.NodeNavigator over an etree document. The
+// processing instructions, directives and namespace declarations are not
+// nodes of the tree, and the adjacent character data tokens, such as a
+// text and a CDATA section, are text nodes of their own.
+type NodeNavigator struct {
+	root *etree.Element
+	curr etree.Token
+	attr int
+}
+
+// Current returns the token at the navigator position. When positioned on
+// an attribute, it returns the element that owns the attribute.
+func (n *NodeNavigator) Current() etree.Token {
+	return n.curr
+}
+
+// Attr returns the attribute the navigator is positioned on, or nil.
+func (n *NodeNavigator) Attr() *etree.Attr {
+	if n.attr == -1 {
+		return nil
+	}
+	return &n.curr.(*etree.Element).Attr[n.attr]
+}
+
+func (n *NodeNavigator) NodeType() xpath.NodeType {
+	if n.attr != -1 {
+		return xpath.AttributeNode
+	}
+	switch t := n.curr.(type) {
+	case *etree.CharData:
+		return xpath.TextNode
+	case *etree.Comment:
+		return xpath.CommentNode
+	case *etree.Element:
+		if t == n.root && t.Tag == "" {
+			return xpath.RootNode
+		}
+	}
+	return xpath.ElementNode
+}
+
+func (n *NodeNavigator) LocalName() string {
+	if n.attr != -1 {
+		return n.Attr().Key
+	}
+	if e, ok := n.curr.(*etree.Element); ok {
+		return e.Tag
+	}
+	return ""
+}
+
+func (n *NodeNavigator) Prefix() string {
+	if n.attr != -1 {
+		return n.Attr().Space
+	}
+	if e, ok := n.curr.(*etree.Element); ok {
+		return e.Space
+	}
+	return ""
+}
+
+// NamespaceURL returns the namespace URI of the element or attribute, as
+// etree resolves its prefix.
+func (n *NodeNavigator) NamespaceURL() string {
+	if n.attr != -1 {
+		return n.Attr().NamespaceURI()
+	}
+	if e, ok := n.curr.(*etree.Element); ok && n.NodeType() == xpath.ElementNode {
+		return e.NamespaceURI()
+	}
+	return ""
+}
+
+func (n *NodeNavigator) Value() string {
+	if n.attr != -1 {
+		return n.Attr().Value
+	}
+	switch t := n.curr.(type) {
+	case *etree.CharData:
+		return t.Data
+	case *etree.Comment:
+		return t.Data
+	case *etree.Element:
+		var b strings.Builder
+		writeText(&b, t)
+		return b.String()
+	}
+	return ""
+}
+
+// writeText writes the text of the descendants of e to b.
+func writeText(b *strings.Builder, e *etree.Element) {
+	for _, c := range e.Child {
+		switch c := c.(type) {
+		case *etree.CharData:
+			b.WriteString(c.Data)
+		case *etree.Element:
+			writeText(b, c)
+		}
+	}
+}
+
+func (n *NodeNavigator) Copy() xpath.NodeNavigator {
+	n2 := *n
+	return &n2
+}
+
+func (n *NodeNavigator) MoveToRoot() {
+	n.curr = n.root
+	n.attr = -1
+}
+
+func (n *NodeNavigator) MoveToParent() bool {
+	if n.attr != -1 {
+		n.attr = -1
+		return true
+	}
+	if p := n.curr.Parent(); p != nil {
+		n.curr = p
+		return true
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToNextAttribute() bool {
+	e, ok := n.curr.(*etree.Element)
+	if !ok {
+		return false
+	}
+	for i := n.attr + 1; i < len(e.Attr); i++ {
+		if !isNamespaceDecl(e.Attr[i]) {
+			n.attr = i
+			return true
+		}
+	}
+	return false
+}
+
+// isNamespaceDecl reports whether the attribute is a xmlns declaration,
+// which is not an attribute node.
+func isNamespaceDecl(a etree.Attr) bool {
+	return a.Space == "xmlns" || a.Space == "" && a.Key == "xmlns"
+}
+
+func (n *NodeNavigator) MoveToChild() bool {
+	if n.attr != -1 {
+		return false
+	}
+	e, ok := n.curr.(*etree.Element)
+	if !ok {
+		return false
+	}
+	return n.moveToSibling(e.Child, 0, 1)
+}
+
+func (n *NodeNavigator) MoveToFirst() bool {
+	if n.attr != -1 || n.curr.Parent() == nil {
+		return false
+	}
+	siblings := n.curr.Parent().Child
+	for i := 0; i < n.curr.Index(); i++ {
+		if isVisible(siblings[i]) {
+			n.curr = siblings[i]
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToNext() bool {
+	if n.attr != -1 || n.curr.Parent() == nil {
+		return false
+	}
+	return n.moveToSibling(n.curr.Parent().Child, n.curr.Index()+1, 1)
+}
+
+func (n *NodeNavigator) MoveToPrevious() bool {
+	if n.attr != -1 || n.curr.Parent() == nil {
+		return false
+	}
+	return n.moveToSibling(n.curr.Parent().Child, n.curr.Index()-1, -1)
+}
+
+// moveToSibling moves to the first node of the tree among the tokens,
+// from the index i in the direction dir.
+func (n *NodeNavigator) moveToSibling(tokens []etree.Token, i, dir int) bool {
+	for ; i >= 0 && i < len(tokens); i += dir {
+		if isVisible(tokens[i]) {
+			n.curr = tokens[i]
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	node, ok := other.(*NodeNavigator)
+	if !ok {
+		return false
+	}
+	n.root = node.root
+	n.curr = node.curr
+	n.attr = node.attr
+	return true
+}
+
+// isVisible reports whether the token is a node of the XPath tree.
+func isVisible(t etree.Token) bool {
+	switch t.(type) {
+	case *etree.Element, *etree.CharData, *etree.Comment:
+		return true
+	}
+	return false
+}
+
+// FindElements returns the elements expr selects from e, in document
+// order and each once. An attribute or a text node is replaced by its
+// element. It panics if expr is not a node-set, as Select does.
+func FindElements(e *etree.Element, expr *xpath.Expr) []*etree.Element {
+	var list []*etree.Element
+	seen := make(map[*etree.Element]bool)
+	for iter := expr.Select(CreateElementNavigator(e)); iter.MoveNext(); {
+		n, ok := iter.Current().(*NodeNavigator)
+		if !ok {
+			continue
+		}
+		el, ok := n.curr.(*etree.Element)
+		if !ok {
+			el = n.curr.Parent()
+		}
+		if el == nil || el == n.root && el.Tag == "" || seen[el] {
+			continue
+		}
+		seen[el] = true
+		list = append(list, el)
+	}
+	return list
+}
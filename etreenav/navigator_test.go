@@ -0,0 +1,50 @@
+package etreenav
+
+import (
+	"testing"
+
+	"github.com/antchfx/xpath"
+	"github.com/beevik/etree"
+)
+
+const books = `<?xml version="1.0"?><!-- store -->` +
+	`<store xmlns:b="urn:books"><book id="1"><title>Go</title><price>30</price></book>` +
+	`<b:book id="2"><title>XML <![CDATA[&]]> XPath</title><price>40</price></b:book></store>`
+
+func TestNavigator(t *testing.T) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(books); err != nil {
+		t.Fatal(err)
+	}
+	nav := CreateNavigator(doc)
+	for expr, want := range map[string]interface{}{
+		"sum(//price)":                       float64(70),
+		"count(/node())":                     float64(2), // the comment and store
+		"string(//*[@id=2]/title)":           "XML & XPath",
+		"count(//*[@id=2]/title/text())":     float64(3),
+		"name(/store/*[2])":                  "b:book",
+		"namespace-uri(/store/*[2])":         "urn:books",
+		"local-name(//book[1]/@id)":          "id",
+		"count(/store/@*)":                   float64(0),
+		"string(/comment())":                 " store ",
+		"count(//title/preceding::price)":    float64(1),
+		"string(//price[. > 35]/../@id)":     "2",
+		"count(//book/following-sibling::*)": float64(1),
+	} {
+		if got := xpath.MustCompile(expr).Evaluate(nav); got != want {
+			t.Errorf("%s: got %v, want %v", expr, got, want)
+		}
+	}
+
+	// An attribute or a text is replaced by its element, kept once.
+	list := FindElements(doc.Root(), xpath.MustCompile("//title/text() | //@id | //title"))
+	if len(list) != 4 || list[0].Tag != "book" || list[1].Tag != "title" || list[2].Space != "b" {
+		t.Fatalf("unexpected elements %v", list)
+	}
+	// The root of a detached element is the element.
+	title := doc.FindElement("//title")
+	title.Parent().RemoveChild(title)
+	if got := xpath.MustCompile("string(/)").Evaluate(CreateElementNavigator(title)); got != "Go" {
+		t.Fatalf("unexpected string-value of a detached element %v", got)
+	}
+}
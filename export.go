@@ -0,0 +1,359 @@
+package xpath
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MarshalBinary encodes the expression, its compile options and its plan
+// as the Query message of the protocol buffers schema in plan.proto, to
+// ship it to another service or inspect it with other tools.
+func (expr *Expr) MarshalBinary() ([]byte, error) {
+	var w protoWriter
+	w.string(1, expr.s)
+	prefixes := make([]string, 0, len(expr.opts.Namespaces))
+	for prefix := range expr.opts.Namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		w.message(2, func(w *protoWriter) {
+			w.string(1, prefix)
+			w.string(2, expr.opts.Namespaces[prefix])
+		})
+	}
+	w.bool(3, expr.opts.ReorderPredicates)
+	if c := expr.opts.Compat; c != (Compat{}) {
+		w.message(4, func(w *protoWriter) {
+			w.string(1, c.Engine)
+			w.bool(2, c.Characters)
+			w.bool(3, c.DoubleNumbers)
+			w.bool(4, c.XSDRegexps)
+		})
+	}
+	w.message(5, expr.Plan().marshal)
+	w.bool(6, expr.opts.CompareDates)
+	if ns := expr.opts.NumberStrings; ns != (NumberStrings{}) {
+		w.message(7, func(w *protoWriter) {
+			w.string(1, ns.NaN)
+			w.string(2, ns.Infinity)
+			w.string(3, ns.NegativeInfinity)
+		})
+	}
+	w.bool(8, expr.opts.StrictNumbers)
+	if expr.opts.Anchor != AnchorContext {
+		w.tag(9, wireVarint)
+		w.varint(uint64(expr.opts.Anchor))
+	}
+	return w.b, nil
+}
+
+// UnmarshalBinary compiles the expression a Query message encodes, as
+// returned by MarshalBinary, with its options, into expr. The plan of the
+// message is ignored.
+func (expr *Expr) UnmarshalBinary(data []byte) error {
+	var (
+		s    string
+		opts CompileOptions
+	)
+	err := readMessage(data, func(r *protoReader, field int) (err error) {
+		switch field {
+		case 1:
+			s, err = r.string()
+		case 2:
+			var prefix, uri string
+			err = r.message(func(r *protoReader, field int) (err error) {
+				switch field {
+				case 1:
+					prefix, err = r.string()
+				case 2:
+					uri, err = r.string()
+				default:
+					return r.skip()
+				}
+				return err
+			})
+			if opts.Namespaces == nil {
+				opts.Namespaces = make(map[string]string)
+			}
+			opts.Namespaces[prefix] = uri
+		case 3:
+			opts.ReorderPredicates, err = r.bool()
+		case 4:
+			err = r.message(func(r *protoReader, field int) (err error) {
+				c := &opts.Compat
+				switch field {
+				case 1:
+					c.Engine, err = r.string()
+				case 2:
+					c.Characters, err = r.bool()
+				case 3:
+					c.DoubleNumbers, err = r.bool()
+				case 4:
+					c.XSDRegexps, err = r.bool()
+				default:
+					return r.skip()
+				}
+				return err
+			})
+		case 6:
+			opts.CompareDates, err = r.bool()
+		case 7:
+			err = r.message(func(r *protoReader, field int) (err error) {
+				ns := &opts.NumberStrings
+				switch field {
+				case 1:
+					ns.NaN, err = r.string()
+				case 2:
+					ns.Infinity, err = r.string()
+				case 3:
+					ns.NegativeInfinity, err = r.string()
+				default:
+					return r.skip()
+				}
+				return err
+			})
+		case 8:
+			opts.StrictNumbers, err = r.bool()
+		case 9:
+			var anchor uint64
+			anchor, err = r.varint()
+			opts.Anchor = Anchor(anchor)
+		default:
+			return r.skip()
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("xpath: decoding query: %w", err)
+	}
+	e, err := CompileWithOptions(s, opts)
+	if err != nil {
+		return err
+	}
+	*expr = *e
+	return nil
+}
+
+// MarshalBinary encodes the plan as the Plan message of the protocol
+// buffers schema in plan.proto.
+func (p *Plan) MarshalBinary() ([]byte, error) {
+	var w protoWriter
+	p.marshal(&w)
+	return w.b, nil
+}
+
+func (p *Plan) marshal(w *protoWriter) {
+	w.string(1, p.Op)
+	for _, note := range p.Notes {
+		w.bytes(2, []byte(note))
+	}
+	w.bool(3, p.Streamable)
+	for _, in := range p.Inputs {
+		w.message(4, in.marshal)
+	}
+	if p.traced {
+		w.tag(5, wireVarint)
+		w.varint(uint64(p.Nodes))
+	}
+}
+
+// UnmarshalBinary decodes a Plan message, as returned by MarshalBinary,
+// into p.
+func (p *Plan) UnmarshalBinary(data []byte) error {
+	*p = Plan{}
+	if err := readMessage(data, p.unmarshal); err != nil {
+		return fmt.Errorf("xpath: decoding plan: %w", err)
+	}
+	return nil
+}
+
+func (p *Plan) unmarshal(r *protoReader, field int) (err error) {
+	switch field {
+	case 1:
+		p.Op, err = r.string()
+	case 2:
+		var note string
+		note, err = r.string()
+		p.Notes = append(p.Notes, note)
+	case 3:
+		p.Streamable, err = r.bool()
+	case 4:
+		in := new(Plan)
+		err = r.message(in.unmarshal)
+		p.Inputs = append(p.Inputs, in)
+	case 5:
+		var n uint64
+		n, err = r.varint()
+		p.Nodes, p.traced = int(n), true
+	default:
+		return r.skip()
+	}
+	return err
+}
+
+// The wire types of the protocol buffers encoding.
+const (
+	wireVarint = 0
+	wire64     = 1
+	wireBytes  = 2
+	wire32     = 5
+)
+
+// A protoWriter appends the fields of a protocol buffers message to b.
+// The fields with the zero value of their type are left out, as proto3
+// does.
+type protoWriter struct {
+	b []byte
+}
+
+func (w *protoWriter) varint(x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.b = append(w.b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+func (w *protoWriter) tag(field, wire int) {
+	w.varint(uint64(field<<3 | wire))
+}
+
+// bytes appends a length-delimited field, even if b is empty, for the
+// elements of repeated fields.
+func (w *protoWriter) bytes(field int, b []byte) {
+	w.tag(field, wireBytes)
+	w.varint(uint64(len(b)))
+	w.b = append(w.b, b...)
+}
+
+func (w *protoWriter) string(field int, s string) {
+	if s != "" {
+		w.bytes(field, []byte(s))
+	}
+}
+
+func (w *protoWriter) bool(field int, v bool) {
+	if v {
+		w.tag(field, wireVarint)
+		w.varint(1)
+	}
+}
+
+// message appends the message the function writes as a field.
+func (w *protoWriter) message(field int, write func(*protoWriter)) {
+	var m protoWriter
+	write(&m)
+	w.bytes(field, m.b)
+}
+
+var errTruncated = errors.New("truncated message")
+
+// A protoReader reads the fields of a protocol buffers message from b.
+type protoReader struct {
+	b    []byte
+	wire int // of the field being read
+}
+
+// readMessage calls the function for each field of the message in data,
+// which reads the value of the field or skips it.
+func readMessage(data []byte, field func(r *protoReader, field int) error) error {
+	r := &protoReader{b: data}
+	for len(r.b) > 0 {
+		tag, err := r.uvarint()
+		if err != nil {
+			return err
+		}
+		if tag>>3 == 0 || tag>>3 > 1<<29-1 {
+			return fmt.Errorf("invalid field number %d", tag>>3)
+		}
+		r.wire = int(tag & 7)
+		if err := field(r, int(tag>>3)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *protoReader) uvarint() (uint64, error) {
+	x, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.b = r.b[n:]
+	return x, nil
+}
+
+func (r *protoReader) want(wire int) error {
+	if r.wire != wire {
+		return fmt.Errorf("unexpected wire type %d", r.wire)
+	}
+	return nil
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	if err := r.want(wireVarint); err != nil {
+		return 0, err
+	}
+	return r.uvarint()
+}
+
+func (r *protoReader) bool() (bool, error) {
+	x, err := r.varint()
+	return x != 0, err
+}
+
+func (r *protoReader) bytes() ([]byte, error) {
+	if err := r.want(wireBytes); err != nil {
+		return nil, err
+	}
+	n, err := r.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.b)) {
+		return nil, errTruncated
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b, nil
+}
+
+func (r *protoReader) string() (string, error) {
+	b, err := r.bytes()
+	return string(b), err
+}
+
+// message reads a field of a message type, calling the function for each
+// of its fields.
+func (r *protoReader) message(field func(r *protoReader, field int) error) error {
+	b, err := r.bytes()
+	if err != nil {
+		return err
+	}
+	return readMessage(b, field)
+}
+
+// skip skips the value of a field that is unknown, added to the schema
+// after this version.
+func (r *protoReader) skip() error {
+	var n int
+	switch r.wire {
+	case wireVarint:
+		_, err := r.uvarint()
+		return err
+	case wireBytes:
+		_, err := r.bytes()
+		return err
+	case wire64:
+		n = 8
+	case wire32:
+		n = 4
+	default:
+		return fmt.Errorf("unexpected wire type %d", r.wire)
+	}
+	if len(r.b) < n {
+		return errTruncated
+	}
+	r.b = r.b[n:]
+	return nil
+}
@@ -0,0 +1,90 @@
+package xpath
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	expr, err := CompileWithOptions(`string-length(//b:book[1]/@title)`, CompileOptions{
+		Namespaces:        map[string]string{"b": "urn:books", "a": "urn:a"},
+		ReorderPredicates: true,
+		CompareDates:      true,
+		Compat:            SaxonHE,
+		NumberStrings:     XSDNumberStrings,
+		StrictNumbers:     true,
+		Anchor:            AnchorRoot,
+	})
+	assertNoErr(t, err)
+	data, err := expr.MarshalBinary()
+	assertNoErr(t, err)
+	again, err := expr.MarshalBinary()
+	assertNoErr(t, err)
+	assertTrue(t, bytes.Equal(data, again))
+
+	var decoded Expr
+	assertNoErr(t, decoded.UnmarshalBinary(data))
+	assertEqual(t, expr.String(), decoded.String())
+	assertEqual(t, "urn:books", decoded.opts.Namespaces["b"])
+	assertEqual(t, 2, len(decoded.opts.Namespaces))
+	assertTrue(t, decoded.opts.ReorderPredicates)
+	assertTrue(t, decoded.opts.CompareDates)
+	assertEqual(t, SaxonHE, decoded.opts.Compat)
+	assertEqual(t, XSDNumberStrings, decoded.opts.NumberStrings)
+	assertTrue(t, decoded.opts.StrictNumbers)
+	assertEqual(t, AnchorRoot, decoded.opts.Anchor)
+	assertEqual(t, expr.Plan().String(), decoded.Plan().String())
+
+	// The Plan field is the plan of the expression.
+	var plan Plan
+	assertNoErr(t, readMessage(data, func(r *protoReader, field int) error {
+		if field == 5 {
+			return r.message(plan.unmarshal)
+		}
+		return r.skip()
+	}))
+	assertEqual(t, expr.Plan().String(), plan.String())
+
+	// Unknown fields are skipped.
+	var w protoWriter
+	w.string(1, "count(//a)")
+	w.tag(9, wireVarint)
+	w.varint(300)
+	w.tag(10, wire32)
+	w.b = append(w.b, 1, 2, 3, 4)
+	w.string(11, "later")
+	assertNoErr(t, decoded.UnmarshalBinary(w.b))
+	assertEqual(t, "count(//a)", decoded.String())
+
+	for _, data := range [][]byte{
+		{0x0a, 0x05, 'a'},       // truncated string
+		{0x08},                  // truncated varint
+		{0x0d, 1, 2},            // truncated fixed32 of a string field
+		{0x00, 0x01},            // field 0
+		{0x0a, 0x01, '/', 0x1b}, // wire type 3
+	} {
+		if err := decoded.UnmarshalBinary(data); err == nil {
+			t.Errorf("expected error decoding %x", data)
+		}
+	}
+	w = protoWriter{}
+	w.string(1, "//a[")
+	err = decoded.UnmarshalBinary(w.b)
+	assertTrue(t, errors.Is(err, ErrSyntax))
+}
+
+func TestPlanMarshalBinary(t *testing.T) {
+	data, err := (&Plan{Op: "root", Streamable: true}).MarshalBinary()
+	assertNoErr(t, err)
+	assertEqual(t, "\x0a\x04root\x18\x01", string(data))
+
+	trace := MustCompile(`count(//book[price > 35])`).Trace()
+	trace.Evaluate(createNavigator(book_example))
+	traced := trace.Plan()
+	data, err = traced.MarshalBinary()
+	assertNoErr(t, err)
+	var p Plan
+	assertNoErr(t, p.UnmarshalBinary(data))
+	assertEqual(t, traced.String(), p.String())
+}
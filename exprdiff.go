@@ -0,0 +1,286 @@
+package xpath
+
+import (
+	"errors"
+	"strings"
+)
+
+// ExprChangeKind is the kind of an ExprChange.
+type ExprChangeKind int
+
+const (
+	// StepAdded is a step of a path in the new expression only.
+	StepAdded ExprChangeKind = iota
+	// StepRemoved is a step of a path in the old expression only.
+	StepRemoved
+	// StepChanged is a step replaced by another, such as book by
+	// chapter, or child::a by descendant::a.
+	StepChanged
+	// PredicateAdded is a predicate of a step in the new expression only.
+	PredicateAdded
+	// PredicateRemoved is a predicate of a step in the old expression
+	// only.
+	PredicateRemoved
+	// PredicateChanged is a predicate of a step replaced by another.
+	PredicateChanged
+	// ExprChanged is any other subexpression replaced by another, such as
+	// an operand of a comparison.
+	ExprChanged
+)
+
+// An ExprChange is a difference between two expressions found by
+// DiffExprs.
+type ExprChange struct {
+	Kind ExprChangeKind
+	// Old and New are the steps, predicates or subexpressions changed, as
+	// Format prints them, such as "book[@id = 1]" or "[@id = 1]". Old is
+	// "" for an addition and New for a removal.
+	Old, New string
+	// Path is the path of the new expression the change is in, without
+	// its predicates, as far as the step changed or added, the step of
+	// the predicate, or the step before the one removed. It is "" for an
+	// ExprChanged.
+	Path string
+}
+
+func (c ExprChange) String() string {
+	switch c.Kind {
+	case StepAdded:
+		return "step " + c.New + " added: " + c.Path
+	case StepRemoved:
+		if c.Path == "" {
+			return "step " + c.Old + " removed at the start"
+		}
+		return "step " + c.Old + " removed after " + c.Path
+	case StepChanged:
+		return "step " + c.Old + " changed to " + c.New + ": " + c.Path
+	case PredicateAdded:
+		return "predicate " + c.New + " added to " + c.Path
+	case PredicateRemoved:
+		return "predicate " + c.Old + " removed from " + c.Path
+	case PredicateChanged:
+		return "predicate " + c.Old + " changed to " + c.New + " on " + c.Path
+	}
+	return c.Old + " changed to " + c.New
+}
+
+// DiffExprs returns the differences between the expressions old and new,
+// in the order they appear in new: the steps of the paths added, removed
+// or changed, the predicates added, removed or changed on the other
+// steps, and the other subexpressions changed. Expressions that differ
+// only in their spacing, quotes or abbreviations have no differences. If
+// an expression is not valid XPath, the error is a *SyntaxError.
+func DiffExprs(old, new string) (changes []ExprChange, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch x := e.(type) {
+			case string:
+				err = errors.New(x)
+			case error:
+				err = x
+			default:
+				err = errors.New("unknown panic")
+			}
+		}
+	}()
+	if old == "" || new == "" {
+		return nil, newError(ErrSyntax, nil, "expr expression is nil")
+	}
+	var d exprDiffer
+	d.diff(parse(old, nil, nil), parse(new, nil, nil))
+	return d.changes, nil
+}
+
+type exprDiffer struct {
+	changes []ExprChange
+}
+
+// formatNode returns n as Format prints it.
+func formatNode(n node) string {
+	var b strings.Builder
+	(&formatter{b: &b}).format(n)
+	return b.String()
+}
+
+// diff appends the differences between the subexpressions a and b.
+func (d *exprDiffer) diff(a, b node) {
+	if formatNode(a) == formatNode(b) {
+		return
+	}
+	if sa, sb := pathSteps(a), pathSteps(b); sa != nil && sb != nil {
+		d.steps(sa, sb)
+		return
+	}
+	switch a := a.(type) {
+	case *operatorNode:
+		if b, ok := b.(*operatorNode); ok && a.Op == b.Op {
+			d.diff(a.Left, b.Left)
+			d.diff(a.Right, b.Right)
+			return
+		}
+	case *functionNode:
+		if b, ok := b.(*functionNode); ok && a.Prefix == b.Prefix && a.FuncName == b.FuncName && len(a.Args) == len(b.Args) {
+			for i := range a.Args {
+				d.diff(a.Args[i], b.Args[i])
+			}
+			return
+		}
+	case *groupNode:
+		if b, ok := b.(*groupNode); ok {
+			d.diff(a.Input, b.Input)
+			return
+		}
+	}
+	d.changes = append(d.changes, ExprChange{Kind: ExprChanged, Old: formatNode(a), New: formatNode(b)})
+}
+
+// An exprStep is a step of a path, with its predicates.
+type exprStep struct {
+	test  string // the step without predicates, "" for //
+	preds []string
+}
+
+func (s exprStep) String() string {
+	test := s.test
+	if test == "" {
+		test = "//"
+	}
+	return test + strings.Join(s.preds, "")
+}
+
+// pathSteps returns the steps of the path n, or nil if n is not a path.
+// A path that starts with another expression, such as id('a')/b, has it
+// as its first step.
+func pathSteps(n node) []exprStep {
+	primary := func(n node) []exprStep {
+		if s := pathSteps(n); s != nil {
+			return s
+		}
+		return []exprStep{{test: formatNode(n)}}
+	}
+	switch n := n.(type) {
+	case *rootNode:
+		return []exprStep{{test: "/"}}
+	case *axisNode:
+		var steps []exprStep
+		if n.Input != nil {
+			steps = primary(n.Input)
+		}
+		step := *n
+		step.Input = nil
+		return append(steps, exprStep{test: formatNode(&step)})
+	case *filterNode:
+		steps := primary(n.Input)
+		last := &steps[len(steps)-1]
+		last.preds = append(last.preds, "["+formatNode(n.Condition)+"]")
+		return steps
+	}
+	return nil
+}
+
+// joinSteps returns the path of the steps without their predicates.
+func joinSteps(steps []exprStep) string {
+	var b strings.Builder
+	for i, s := range steps {
+		if i > 0 && steps[i-1].test != "/" {
+			b.WriteString("/")
+		}
+		b.WriteString(s.test)
+	}
+	if len(steps) > 0 && steps[len(steps)-1].test == "" {
+		// The second slash of //.
+		b.WriteString("/")
+	}
+	return b.String()
+}
+
+// steps appends the differences between the steps of two paths. The
+// steps are matched by their node tests, the others are added, removed
+// or changed.
+func (d *exprDiffer) steps(a, b []exprStep) {
+	tests := func(steps []exprStep) []string {
+		s := make([]string, len(steps))
+		for i, step := range steps {
+			s[i] = step.test
+		}
+		return s
+	}
+	i, j := 0, 0
+	for _, m := range append(commonSubsequence(tests(a), tests(b)), [2]int{len(a), len(b)}) {
+		for i < m[0] || j < m[1] {
+			switch {
+			case i < m[0] && j < m[1]:
+				d.changes = append(d.changes, ExprChange{Kind: StepChanged, Old: a[i].String(), New: b[j].String(), Path: joinSteps(b[:j+1])})
+				i, j = i+1, j+1
+			case i < m[0]:
+				d.changes = append(d.changes, ExprChange{Kind: StepRemoved, Old: a[i].String(), Path: joinSteps(b[:j])})
+				i++
+			default:
+				d.changes = append(d.changes, ExprChange{Kind: StepAdded, New: b[j].String(), Path: joinSteps(b[:j+1])})
+				j++
+			}
+		}
+		if i < len(a) {
+			d.predicates(a[i].preds, b[j].preds, joinSteps(b[:j+1]))
+			i, j = i+1, j+1
+		}
+	}
+}
+
+// predicates appends the differences between the predicates of a step
+// matched in both paths, whose path is path.
+func (d *exprDiffer) predicates(a, b []string, path string) {
+	i, j := 0, 0
+	for _, m := range append(commonSubsequence(a, b), [2]int{len(a), len(b)}) {
+		for i < m[0] || j < m[1] {
+			switch {
+			case i < m[0] && j < m[1]:
+				d.changes = append(d.changes, ExprChange{Kind: PredicateChanged, Old: a[i], New: b[j], Path: path})
+				i, j = i+1, j+1
+			case i < m[0]:
+				d.changes = append(d.changes, ExprChange{Kind: PredicateRemoved, Old: a[i], Path: path})
+				i++
+			default:
+				d.changes = append(d.changes, ExprChange{Kind: PredicateAdded, New: b[j], Path: path})
+				j++
+			}
+		}
+		i, j = i+1, j+1
+	}
+}
+
+// commonSubsequence returns the indexes in a and b of the elements of one
+// of their longest common subsequences.
+func commonSubsequence(a, b []string) [][2]int {
+	// n[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	n := make([][]int, len(a)+1)
+	for i := range n {
+		n[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				n[i][j] = n[i+1][j+1] + 1
+			case n[i+1][j] >= n[i][j+1]:
+				n[i][j] = n[i+1][j]
+			default:
+				n[i][j] = n[i][j+1]
+			}
+		}
+	}
+	var pairs [][2]int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			pairs = append(pairs, [2]int{i, j})
+			i, j = i+1, j+1
+		case n[i+1][j] >= n[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return pairs
+}
@@ -0,0 +1,57 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiffExprs(t *testing.T) {
+	for _, tc := range []struct {
+		old, new string
+		changes  []string
+	}{
+		{`//book[@id="1"]`, `//book [ @id = '1' ]`, nil},
+		{`/bookstore/book/title`, `/child::bookstore/book/title`, nil},
+		{`/bookstore/book`, `/bookstore/book/title`, []string{"step title added: /bookstore/book/title"}},
+		{`/bookstore/book/title`, `/bookstore/title`, []string{"step book removed after /bookstore"}},
+		{`a/b/c`, `b/c`, []string{"step a removed at the start"}},
+		{`/bookstore/book`, `//book`, []string{"step bookstore changed to //: //"}},
+		{`//book/title`, `//book/descendant::title`, []string{"step title changed to descendant::title: //book/descendant::title"}},
+		{`//book[@category = 'web']`, `//book[@category = 'cooking']`, []string{"predicate [@category = 'web'] changed to [@category = 'cooking'] on //book"}},
+		{`//book/title`, `//book[price > 35]/title[1]`, []string{
+			"predicate [price > 35] added to //book",
+			"predicate [1] added to //book/title",
+		}},
+		{`//book[1][@lang]`, `//book[@lang]`, []string{"predicate [1] removed from //book"}},
+		{`count(//book) > 2`, `count(//book/title) > 3`, []string{
+			"step title added: //book/title",
+			"2 changed to 3",
+		}},
+		{`//a | //b`, `//a | //c`, []string{"step b changed to c: //c"}},
+		{`string(//a)`, `concat(//a, 'x')`, []string{"string(//a) changed to concat(//a, 'x')"}},
+		{`id('x')/a`, `id('y')/a`, []string{"step id('x') changed to id('y'): id('y')"}},
+	} {
+		changes, err := DiffExprs(tc.old, tc.new)
+		assertNoErr(t, err)
+		var got []string
+		for _, c := range changes {
+			got = append(got, c.String())
+		}
+		assertEqual(t, len(tc.changes), len(got))
+		for i := range got {
+			if i < len(tc.changes) {
+				assertEqual(t, tc.changes[i], got[i])
+			}
+		}
+	}
+
+	changes, err := DiffExprs(`//book[@id = 1]`, `//book[@id = 2]/title`)
+	assertNoErr(t, err)
+	assertEqual(t, 2, len(changes))
+	assertEqual(t, ExprChange{Kind: PredicateChanged, Old: "[@id = 1]", New: "[@id = 2]", Path: "//book"}, changes[0])
+	assertEqual(t, ExprChange{Kind: StepAdded, New: "title", Path: "//book/title"}, changes[1])
+
+	_, err = DiffExprs(`//a`, `//a[`)
+	var syntaxErr *SyntaxError
+	assertTrue(t, errors.As(err, &syntaxErr))
+}
@@ -0,0 +1,470 @@
+package xpath
+
+import (
+	"math"
+	"strings"
+	"time"
+)
+
+// The namespace URIs of the EXSLT modules whose functions the engine
+// supports. A call of a function with a prefix bound to one of them in the
+// namespaces of the expression is a call of the EXSLT function:
+//
+//	exsl:node-set(node-set), exsl:object-type(object)
+//	str:split(string, pattern?), str:tokenize(string, delimiters?),
+//	str:replace(string, search, replace), str:concat(node-set)
+//	date:date-time()
+//	math:min(node-set), math:max(node-set), math:highest(node-set),
+//	math:lowest(node-set), math:abs(number), math:sqrt(number),
+//	math:power(number, number), math:log(number), math:exp(number)
+//	set:difference(node-set, node-set), set:intersection(node-set, node-set),
+//	set:distinct(node-set), set:has-same-node(node-set, node-set),
+//	set:leading(node-set, node-set), set:trailing(node-set, node-set)
+//
+// As there are no result tree fragments, exsl:node-set only takes a
+// node-set. The tokens of str:split and str:tokenize are token elements
+// of a document of their own. str:replace replaces a single string.
+const (
+	EXSLTCommon  = "http://exslt.org/common"
+	EXSLTStrings = "http://exslt.org/strings"
+	EXSLTDates   = "http://exslt.org/dates-and-times"
+	EXSLTMath    = "http://exslt.org/math"
+	EXSLTSets    = "http://exslt.org/sets"
+)
+
+// EXSLTNamespaces returns the prefixes the EXSLT modules are usually
+// bound to, exsl, str, date, math and set, for compiling the expressions
+// written for libxslt with CompileWithNS. The map is a new one, to which
+// other namespaces can be added.
+func EXSLTNamespaces() map[string]string {
+	return map[string]string{
+		"exsl": EXSLTCommon,
+		"str":  EXSLTStrings,
+		"date": EXSLTDates,
+		"math": EXSLTMath,
+		"set":  EXSLTSets,
+	}
+}
+
+// exsltCommon are the functions of the EXSLTCommon module, by name.
+var exsltCommon = map[string]extensionFunction{
+	"node-set": {signature{1, 1, [][]staticType{nodeSetArg}, nodeSetType}, func(args []query) query {
+		return &transformFunctionQuery{Input: args[0], Func: setFunc("exsl:node-set", nil, func(_ iterator, a, _ []orderedNode) []orderedNode {
+			return a
+		})}
+	}},
+	"object-type": {signature{1, 1, [][]staticType{anyArg}, stringType}, func(args []query) query {
+		return &functionQuery{Func: objectTypeFunc(args[0])}
+	}},
+}
+
+// exsltStrings are the functions of the EXSLTStrings module, by name.
+var exsltStrings = map[string]extensionFunction{
+	"split": {signature{1, 2, [][]staticType{anyArg}, nodeSetType}, func(args []query) query {
+		return &transformFunctionQuery{Input: args[0], Func: tokensFunc(optionalArg(args, 1), " ", strings.Split)}
+	}},
+	"tokenize": {signature{1, 2, [][]staticType{anyArg}, nodeSetType}, func(args []query) query {
+		return &transformFunctionQuery{Input: args[0], Func: tokensFunc(optionalArg(args, 1), " \t\r\n", func(s, delimiters string) []string {
+			return strings.FieldsFunc(s, func(r rune) bool { return strings.ContainsRune(delimiters, r) })
+		})}
+	}},
+	"replace": {signature{3, 3, [][]staticType{anyArg}, stringType}, func(args []query) query {
+		return &functionQuery{Func: strReplaceFunc(args[0], args[1], args[2])}
+	}},
+	"concat": {signature{1, 1, [][]staticType{nodeSetArg}, stringType}, func(args []query) query {
+		return &functionQuery{Func: strConcatFunc(args[0])}
+	}},
+}
+
+// exsltDates are the functions of the EXSLTDates module, by name.
+var exsltDates = map[string]extensionFunction{
+	"date-time": {signature{0, 0, nil, stringType}, func(args []query) query {
+		return &functionQuery{Func: func(query, iterator) interface{} {
+			return now().Format("2006-01-02T15:04:05-07:00")
+		}}
+	}},
+}
+
+// exsltMath are the functions of the EXSLTMath module, by name.
+var exsltMath = map[string]extensionFunction{
+	"min": {signature{1, 1, [][]staticType{nodeSetArg}, numberType}, func(args []query) query {
+		return &functionQuery{Func: extremeFunc("math:min", args[0], -1)}
+	}},
+	"max": {signature{1, 1, [][]staticType{nodeSetArg}, numberType}, func(args []query) query {
+		return &functionQuery{Func: extremeFunc("math:max", args[0], 1)}
+	}},
+	"highest": {signature{1, 1, [][]staticType{nodeSetArg}, nodeSetType}, func(args []query) query {
+		return &transformFunctionQuery{Input: args[0], Func: setFunc("math:highest", nil, extremeNodes(1))}
+	}},
+	"lowest": {signature{1, 1, [][]staticType{nodeSetArg}, nodeSetType}, func(args []query) query {
+		return &transformFunctionQuery{Input: args[0], Func: setFunc("math:lowest", nil, extremeNodes(-1))}
+	}},
+	"abs":  mathFunction(math.Abs),
+	"sqrt": mathFunction(math.Sqrt),
+	"log":  mathFunction(math.Log),
+	"exp":  mathFunction(math.Exp),
+	"power": {signature{2, 2, [][]staticType{anyArg}, numberType}, func(args []query) query {
+		return &functionQuery{Func: func(_ query, t iterator) interface{} {
+			return math.Pow(asNumber(t, functionArgs(args[0]).Evaluate(t)), asNumber(t, functionArgs(args[1]).Evaluate(t)))
+		}}
+	}},
+}
+
+// exsltSets are the functions of the EXSLTSets module, by name.
+var exsltSets = map[string]extensionFunction{
+	"difference": setFunction("set:difference", func(_ iterator, a, b []orderedNode) []orderedNode {
+		_, out := partitionNodes(a, b)
+		return out
+	}),
+	"intersection": setFunction("set:intersection", func(_ iterator, a, b []orderedNode) []orderedNode {
+		in, _ := partitionNodes(a, b)
+		return in
+	}),
+	"distinct": {signature{1, 1, [][]staticType{nodeSetArg}, nodeSetType}, func(args []query) query {
+		return &transformFunctionQuery{Input: args[0], Func: setFunc("set:distinct", nil, distinctNodes)}
+	}},
+	"has-same-node": {signature{2, 2, [][]staticType{nodeSetArg}, boolType}, func(args []query) query {
+		return &functionQuery{Func: func(_ query, t iterator) interface{} {
+			in, _ := partitionNodes(exsltNodes(t, args[0], "set:has-same-node"), exsltNodes(t, args[1], "set:has-same-node"))
+			return len(in) > 0
+		}}
+	}},
+	"leading": setFunction("set:leading", func(_ iterator, a, b []orderedNode) []orderedNode {
+		if len(b) == 0 {
+			return a
+		}
+		for i := range a {
+			if a[i].compare(&b[0]) == 0 {
+				return a[:i]
+			}
+		}
+		return nil
+	}),
+	"trailing": setFunction("set:trailing", func(_ iterator, a, b []orderedNode) []orderedNode {
+		if len(b) == 0 {
+			return a
+		}
+		for i := range a {
+			if a[i].compare(&b[0]) == 0 {
+				return a[i+1:]
+			}
+		}
+		return nil
+	}),
+}
+
+// now is the time of date:date-time.
+var now = time.Now
+
+// exsltNodes returns the nodes of the argument q of the EXSLT function
+// name in document order.
+func exsltNodes(t iterator, q query, name string) []orderedNode {
+	v, ok := functionArgs(q).Evaluate(t).(query)
+	if !ok {
+		panic(newError(ErrTypeMismatch, nil, "xpath: the argument of %s() must be a node-set", name))
+	}
+	return orderedNodes(v, t, true)
+}
+
+// nodeList returns an iterator over the nodes of list, for a
+// transformFunctionQuery.
+func nodeList(list []orderedNode) func() NodeNavigator {
+	return func() NodeNavigator {
+		if len(list) == 0 {
+			return nil
+		}
+		n := list[0].node
+		list = list[1:]
+		return n
+	}
+}
+
+// setFunction is the EXSLT function name of two node-sets, whose nodes
+// are those op returns.
+func setFunction(name string, op func(t iterator, a, b []orderedNode) []orderedNode) extensionFunction {
+	return extensionFunction{signature{2, 2, [][]staticType{nodeSetArg}, nodeSetType}, func(args []query) query {
+		return &transformFunctionQuery{Input: args[0], Func: setFunc(name, args[1], op)}
+	}}
+}
+
+// setFunc is the function of a transformFunctionQuery selecting the nodes
+// op returns from those of its input and those of arg, if not nil.
+func setFunc(name string, arg query, op func(t iterator, a, b []orderedNode) []orderedNode) func(query, iterator) func() NodeNavigator {
+	return func(q query, t iterator) func() NodeNavigator {
+		a := exsltNodes(t, q, name)
+		var b []orderedNode
+		if arg != nil {
+			b = exsltNodes(t, arg, name)
+		}
+		return nodeList(op(t, a, b))
+	}
+}
+
+// partitionNodes returns the nodes of a that are in b, and those that are
+// not, in document order.
+func partitionNodes(a, b []orderedNode) (in, out []orderedNode) {
+	j := 0
+	for i := range a {
+		for j < len(b) && b[j].compare(&a[i]) < 0 {
+			j++
+		}
+		if j < len(b) && b[j].compare(&a[i]) == 0 {
+			in = append(in, a[i])
+		} else {
+			out = append(out, a[i])
+		}
+	}
+	return in, out
+}
+
+// distinctNodes returns the first node of a of each string value, for
+// set:distinct.
+func distinctNodes(t iterator, a, _ []orderedNode) []orderedNode {
+	seen := make(map[string]bool)
+	var list []orderedNode
+	for _, n := range a {
+		if s := nodeValue(t, n.node); !seen[s] {
+			seen[s] = true
+			list = append(list, n)
+		}
+	}
+	return list
+}
+
+// objectTypeFunc is exsl:object-type(object), the type of its argument.
+func objectTypeFunc(arg query) func(query, iterator) interface{} {
+	return func(_ query, t iterator) interface{} {
+		switch functionArgs(arg).Evaluate(t).(type) {
+		case query:
+			return "node-set"
+		case float64:
+			return "number"
+		case bool:
+			return "boolean"
+		}
+		return "string"
+	}
+}
+
+// tokensFunc is the function of str:split or str:tokenize, which split
+// the string of its input with that of arg, or def if it is nil. An empty
+// pattern splits the string into its characters.
+func tokensFunc(arg query, def string, split func(s, pattern string) []string) func(query, iterator) func() NodeNavigator {
+	return func(q query, t iterator) func() NodeNavigator {
+		s := asString(t, functionArgs(q).Evaluate(t))
+		pattern := def
+		if arg != nil {
+			pattern = asString(t, functionArgs(arg).Evaluate(t))
+		}
+		var tokens []string
+		if pattern == "" {
+			tokens = strings.Split(s, "")
+		} else {
+			for _, token := range split(s, pattern) {
+				if token != "" {
+					tokens = append(tokens, token)
+				}
+			}
+		}
+		limitsOf(t).addNodes(len(tokens))
+		doc := &tokenDocument{tokens: tokens}
+		i := 0
+		return func() NodeNavigator {
+			if i >= len(tokens) {
+				return nil
+			}
+			i++
+			return &tokenNavigator{doc: doc, i: i - 1}
+		}
+	}
+}
+
+// strReplaceFunc is str:replace(string, search, replace), which replaces
+// each occurrence of search in string.
+func strReplaceFunc(arg1, arg2, arg3 query) func(query, iterator) interface{} {
+	return func(_ query, t iterator) interface{} {
+		s := asString(t, functionArgs(arg1).Evaluate(t))
+		search := asString(t, functionArgs(arg2).Evaluate(t))
+		if search == "" {
+			return s
+		}
+		return limitString(t, strings.ReplaceAll(s, search, asString(t, functionArgs(arg3).Evaluate(t))))
+	}
+}
+
+// strConcatFunc is str:concat(node-set), the string values of its nodes
+// concatenated.
+func strConcatFunc(arg query) func(query, iterator) interface{} {
+	return func(_ query, t iterator) interface{} {
+		var b strings.Builder
+		for _, n := range exsltNodes(t, arg, "str:concat") {
+			b.WriteString(nodeValue(t, n.node))
+		}
+		return limitString(t, b.String())
+	}
+}
+
+// mathFunction is an EXSLT math function of a number.
+func mathFunction(f func(float64) float64) extensionFunction {
+	return extensionFunction{signature{1, 1, [][]staticType{anyArg}, numberType}, func(args []query) query {
+		return &functionQuery{Func: func(_ query, t iterator) interface{} {
+			return f(asNumber(t, functionArgs(args[0]).Evaluate(t)))
+		}}
+	}}
+}
+
+// extremeFunc is math:min or math:max, as sign is -1 or 1: the smallest
+// or largest number of the nodes of arg, or NaN if there are none or one
+// is not a number.
+func extremeFunc(name string, arg query, sign float64) func(query, iterator) interface{} {
+	return func(_ query, t iterator) interface{} {
+		v := math.NaN()
+		for i, n := range exsltNodes(t, arg, name) {
+			x := asNumber(t, nodeValue(t, n.node))
+			if math.IsNaN(x) {
+				return x
+			}
+			if i == 0 || x*sign > v*sign {
+				v = x
+			}
+		}
+		return v
+	}
+}
+
+// extremeNodes returns the function of math:lowest or math:highest, as
+// sign is -1 or 1: the nodes of the smallest or largest number, or none if
+// one is not a number.
+func extremeNodes(sign float64) func(t iterator, a, _ []orderedNode) []orderedNode {
+	return func(t iterator, a, _ []orderedNode) []orderedNode {
+		var list []orderedNode
+		v := math.NaN()
+		for _, n := range a {
+			x := asNumber(t, nodeValue(t, n.node))
+			switch {
+			case math.IsNaN(x):
+				return nil
+			case len(list) == 0 || x*sign > v*sign:
+				list, v = append(list[:0], n), x
+			case x == v:
+				list = append(list, n)
+			}
+		}
+		return list
+	}
+}
+
+// A tokenDocument is the document of the tokens of str:split and
+// str:tokenize: a token element for each of them, with its text.
+type tokenDocument struct {
+	tokens []string
+}
+
+// A tokenNavigator is a NodeNavigator of a tokenDocument.
+type tokenNavigator struct {
+	doc  *tokenDocument
+	i    int  // the token of the current node, or -1 at the root
+	text bool // whether the current node is the text of the token
+}
+
+func (n *tokenNavigator) NodeType() NodeType {
+	switch {
+	case n.text:
+		return TextNode
+	case n.i < 0:
+		return RootNode
+	}
+	return ElementNode
+}
+
+func (n *tokenNavigator) LocalName() string {
+	if n.NodeType() == ElementNode {
+		return "token"
+	}
+	return ""
+}
+
+func (n *tokenNavigator) Prefix() string {
+	return ""
+}
+
+func (n *tokenNavigator) Value() string {
+	if n.i < 0 {
+		return strings.Join(n.doc.tokens, "")
+	}
+	return n.doc.tokens[n.i]
+}
+
+func (n *tokenNavigator) Copy() NodeNavigator {
+	c := *n
+	return &c
+}
+
+func (n *tokenNavigator) MoveToRoot() {
+	n.i, n.text = -1, false
+}
+
+func (n *tokenNavigator) MoveToParent() bool {
+	switch {
+	case n.text:
+		n.text = false
+	case n.i >= 0:
+		n.i = -1
+	default:
+		return false
+	}
+	return true
+}
+
+func (n *tokenNavigator) MoveToNextAttribute() bool {
+	return false
+}
+
+func (n *tokenNavigator) MoveToChild() bool {
+	switch {
+	case n.text:
+		return false
+	case n.i >= 0:
+		n.text = true
+	case len(n.doc.tokens) > 0:
+		n.i = 0
+	default:
+		return false
+	}
+	return true
+}
+
+func (n *tokenNavigator) MoveToFirst() bool {
+	if n.i < 0 {
+		return false
+	}
+	if !n.text {
+		n.i = 0
+	}
+	return true
+}
+
+func (n *tokenNavigator) MoveToNext() bool {
+	if n.text || n.i < 0 || n.i+1 >= len(n.doc.tokens) {
+		return false
+	}
+	n.i++
+	return true
+}
+
+func (n *tokenNavigator) MoveToPrevious() bool {
+	if n.text || n.i <= 0 {
+		return false
+	}
+	n.i--
+	return true
+}
+
+func (n *tokenNavigator) MoveTo(other NodeNavigator) bool {
+	o, ok := other.(*tokenNavigator)
+	if !ok || o.doc != n.doc {
+		return false
+	}
+	*n = *o
+	return true
+}
@@ -0,0 +1,103 @@
+package xpath
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEXSLT(t *testing.T) {
+	doc := parseXML(`<r><n>3</n><n x="1">-1</n><n>7</n><n x="1">7</n><s>a, b,,c</s><m>x</m></r>`)
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(2001, 7, 4, 12, 8, 56, 0, time.FixedZone("", -7*3600)) }
+	// The value of an expression, with the values of the nodes of a
+	// node-set separated by commas.
+	eval := func(expr string) (interface{}, error) {
+		exp, err := CompileWithNS(expr, EXSLTNamespaces())
+		if err != nil {
+			return nil, err
+		}
+		v := exp.Evaluate(createNavigator(doc))
+		if iter, ok := v.(*NodeIterator); ok {
+			var list []string
+			for iter.MoveNext() {
+				list = append(list, iter.Current().Value())
+			}
+			return strings.Join(list, ","), nil
+		}
+		return v, nil
+	}
+	for _, tc := range []struct {
+		expr string
+		want interface{}
+	}{
+		{`exsl:node-set(//n[@x])`, "-1,7"},
+		{`exsl:object-type(//n)`, "node-set"},
+		{`exsl:object-type(1)`, "number"},
+		{`exsl:object-type('')`, "string"},
+		{`exsl:object-type(1 = 1)`, "boolean"},
+		{`str:split(//s, ',')`, "a, b,c"},
+		{`str:split(//s)`, "a,,b,,c"},
+		{`str:split('abc', '')`, "a,b,c"},
+		{`str:tokenize(//s, ', ')`, "a,b,c"},
+		{`(str:split(//s, ', '))[2]`, "b,,c"},
+		{`count(str:tokenize(//s, ',')/self::token)`, float64(3)},
+		{`name(str:tokenize('a b')[1])`, "token"},
+		{`count(str:tokenize(''))`, float64(0)},
+		{`str:replace('a.b.c', '.', '::')`, "a::b::c"},
+		{`str:replace('abc', '', 'x')`, "abc"},
+		{`str:concat(//n)`, "3-177"},
+		{`date:date-time()`, "2001-07-04T12:08:56-07:00"},
+		{`math:min(//n)`, float64(-1)},
+		{`math:max(//n)`, float64(7)},
+		{`math:max(//m | //n)`, math.NaN()},
+		{`math:min(/nothing)`, math.NaN()},
+		{`count(math:highest(//n))`, float64(2)},
+		{`math:lowest(//n)/@x = 1`, true},
+		{`count(math:highest(//n | //m))`, float64(0)},
+		{`math:abs(-2)`, float64(2)},
+		{`math:sqrt(16)`, float64(4)},
+		{`math:power(2, 10)`, float64(1024)},
+		{`math:log(1)`, float64(0)},
+		{`math:exp(0)`, float64(1)},
+		{`set:difference(//n, //n[@x])`, "3,7"},
+		{`set:intersection(//n, //*[@x])`, "-1,7"},
+		{`set:distinct(//n)`, "3,-1,7"},
+		{`set:has-same-node(//n, //*[@x])`, true},
+		{`set:has-same-node(//n, //s)`, false},
+		{`set:leading(//n, //n[3])`, "3,-1"},
+		{`set:leading(//n, //s)`, ""},
+		{`set:leading(//n, /nothing)`, "3,-1,7,7"},
+		{`set:trailing(//n, //n[3])`, "7"},
+		{`count(set:trailing(//*, //n[@x]))`, float64(4)},
+	} {
+		got, err := eval(tc.expr)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if f, ok := tc.want.(float64); ok && math.IsNaN(f) {
+			if g, ok := got.(float64); !ok || !math.IsNaN(g) {
+				t.Errorf("%s = %v, want NaN", tc.expr, got)
+			}
+		} else if got != tc.want {
+			t.Errorf("%s = %#v, want %#v", tc.expr, got, tc.want)
+		}
+	}
+
+	// The prefixes must be bound to the namespaces of the modules.
+	_, err := Compile(`str:split('a b')`)
+	var uerr *UnknownFunctionError
+	assertTrue(t, errors.As(err, &uerr))
+	_, err = CompileWithNS(`strings:split('a b')`, map[string]string{"strings": EXSLTStrings})
+	assertNoErr(t, err)
+	_, err = CompileWithNS(`str:splitt('a b')`, EXSLTNamespaces())
+	assertTrue(t, errors.As(err, &uerr))
+	assertEqual(t, "str:split", uerr.Suggestion)
+	_, err = CompileWithNS(`set:difference(//n)`, EXSLTNamespaces())
+	assertTrue(t, errors.Is(err, ErrArgumentCount))
+	_, err = CompileWithNS(`exsl:node-set('a')`, EXSLTNamespaces())
+	assertTrue(t, errors.Is(err, ErrTypeMismatch))
+}
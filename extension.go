@@ -0,0 +1,60 @@
+package xpath
+
+// An extensionFunction is a function of a namespace of extension
+// functions, such as an EXSLT module.
+type extensionFunction struct {
+	sig signature
+	// query returns the query of a call, with the queries of its
+	// arguments.
+	query func(args []query) query
+}
+
+// extensionFunctions are the extension functions, by namespace URI and
+// name. A call of a function whose prefix is bound to one of the URIs in
+// the namespaces of the expression is a call of one of them.
+var extensionFunctions = map[string]map[string]extensionFunction{
+	EXSLTCommon:   exsltCommon,
+	EXSLTStrings:  exsltStrings,
+	EXSLTDates:    exsltDates,
+	EXSLTMath:     exsltMath,
+	EXSLTSets:     exsltSets,
+	HTMLFunctions: htmlFunctions,
+}
+
+// processExtension builds the call root of an extension function of the
+// namespace uri.
+func (b *builder) processExtension(root *functionNode, uri string, props *builderProp) (query, error) {
+	name := qualifiedName(root.Prefix, root.FuncName)
+	f, ok := extensionFunctions[uri][root.FuncName]
+	if !ok {
+		names := make(map[string]bool)
+		for name := range extensionFunctions[uri] {
+			names[name] = true
+		}
+		err := &UnknownFunctionError{Name: name}
+		if s := closestName(root.FuncName, names); s != "" {
+			err.Suggestion = qualifiedName(root.Prefix, s)
+		}
+		return nil, err
+	}
+	if err := f.sig.check(root, name); err != nil {
+		return nil, err
+	}
+	args := make([]query, len(root.Args))
+	for i, arg := range root.Args {
+		q, err := b.processArg(arg, props, false)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = q
+	}
+	return f.query(args), nil
+}
+
+// optionalArg returns the argument i of args, or nil if there are fewer.
+func optionalArg(args []query, i int) query {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package xpath
+
+// SelectOne returns the first node Select would return, or nil if there
+// is none, stopping the evaluation at it: the nodes after it are not
+// visited, and the nodes of a path that selects them out of document
+// order, such as //*/../.., are scanned for the first one rather than
+// sorted.
+func (expr *Expr) SelectOne(root NodeNavigator) NodeNavigator {
+	return firstNode(expr.Select(root))
+}
+
+// SelectOneWithOptions is SelectOne within the limits of opts, returning
+// the error of SelectWithOptions.
+func (expr *Expr) SelectOneWithOptions(root NodeNavigator, opts EvalOptions) (NodeNavigator, error) {
+	iter := expr.SelectWithOptions(root, opts)
+	return firstNode(iter), iter.Err()
+}
+
+// firstNode returns a copy of the first node of iter, with its query
+// replaced by a firstNodeQuery.
+func firstNode(iter *NodeIterator) NodeNavigator {
+	iter.query = &firstNodeQuery{Input: iter.query}
+	if !iter.MoveNext() {
+		return nil
+	}
+	return iter.Current().Copy()
+}
+
+// firstQuery is implemented by node-set queries that can select their
+// first node more cheaply than they select all of them in order.
+type firstQuery interface {
+	first(t iterator) NodeNavigator
+}
+
+// firstNodeQuery selects the first node of Input, for Expr.SelectOne: the
+// nodes after it are not selected.
+type firstNodeQuery struct {
+	Input query
+	done  bool
+}
+
+func (f *firstNodeQuery) Select(t iterator) NodeNavigator {
+	if f.done {
+		return nil
+	}
+	f.done = true
+	if q, ok := f.Input.(firstQuery); ok {
+		return q.first(t)
+	}
+	return f.Input.Select(t)
+}
+
+func (f *firstNodeQuery) Evaluate(t iterator) interface{} {
+	f.Input.Evaluate(t)
+	f.done = false
+	return f
+}
+
+func (f *firstNodeQuery) Clone() query {
+	return &firstNodeQuery{Input: f.Input.Clone()}
+}
+
+func (f *firstNodeQuery) ValueType() resultType {
+	return xpathResultType.NodeSet
+}
+
+func (f *firstNodeQuery) Properties() queryProp {
+	return f.Input.Properties()
+}
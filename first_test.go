@@ -0,0 +1,38 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSelectOne(t *testing.T) {
+	nav := createNavigator(book_example)
+	for expr, want := range map[string]string{
+		"//book":                           "/bookstore[1]/book[1]",
+		"//book[@category = 'web']":        "/bookstore[1]/book[3]",
+		"//author/..":                      "/bookstore[1]/book[1]",
+		"//book[last()]/title/ancestor::*": "/bookstore[1]",
+		"(//year | //title)[2]":            "/bookstore[1]/book[1]/year[1]",
+		"reverse(//book)":                  "/bookstore[1]/book[4]",
+	} {
+		node := MustCompile(expr).SelectOne(nav)
+		if node == nil {
+			t.Errorf("%s: got nil, want %s", expr, want)
+		} else if got := NodePath(node); got != want {
+			t.Errorf("%s: got %s, want %s", expr, got, want)
+		}
+	}
+	assertTrue(t, MustCompile("//nothing").SelectOne(nav) == nil)
+
+	// The evaluation stops at the first node.
+	var one, all Stats
+	node, err := MustCompile("//title").SelectOneWithOptions(nav, EvalOptions{Stats: &one})
+	assertNoErr(t, err)
+	assertEqual(t, "Everyday Italian", node.Value())
+	for iter := MustCompile("//title").SelectWithOptions(nav, EvalOptions{Stats: &all}); iter.MoveNext(); {
+	}
+	assertTrue(t, one.NodesVisited < all.NodesVisited/2)
+
+	_, err = MustCompile("reverse(//*)").SelectOneWithOptions(nav, EvalOptions{MaxBufferedNodes: 2})
+	assertTrue(t, errors.Is(err, ErrResourceLimit))
+}
@@ -0,0 +1,260 @@
+package xpath
+
+import (
+	"errors"
+	"strings"
+)
+
+// FormatOptions controls how Format prints an expression.
+type FormatOptions struct {
+	// ExpandAbbreviations prints the abbreviated steps in full: // as
+	// /descendant-or-self::node()/, @ as attribute::, . as self::node(),
+	// .. as parent::node() and a step without axis with child::.
+	ExpandAbbreviations bool
+}
+
+// Format parses expr and prints it in a normal form, with one space
+// around the operators and after the commas of function calls, and none
+// in the steps of paths, so that expressions that differ only in their
+// spacing or quotes format the same. If expr is not valid XPath, the
+// error is a *SyntaxError.
+func Format(expr string) (string, error) {
+	return FormatWithOptions(expr, FormatOptions{})
+}
+
+// FormatWithOptions is Format with the given options.
+func FormatWithOptions(expr string, opts FormatOptions) (s string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch x := e.(type) {
+			case string:
+				err = errors.New(x)
+			case error:
+				err = x
+			default:
+				err = errors.New("unknown panic")
+			}
+		}
+	}()
+	if expr == "" {
+		return "", newError(ErrSyntax, nil, "expr expression is nil")
+	}
+	var b strings.Builder
+	f := formatter{b: &b, expand: opts.ExpandAbbreviations}
+	f.format(parse(expr, nil, nil))
+	return b.String(), nil
+}
+
+type formatter struct {
+	b      *strings.Builder
+	expand bool
+}
+
+func (f *formatter) format(n node) {
+	switch n := n.(type) {
+	case *rootNode:
+		f.b.WriteString("/")
+	case *axisNode:
+		f.step(n)
+	case *filterNode:
+		f.format(n.Input)
+		f.b.WriteString("[")
+		f.format(n.Condition)
+		f.b.WriteString("]")
+	case *operatorNode:
+		if isNegation(n) {
+			// The parser reads -x as x * -1, there is no negative
+			// literal otherwise.
+			f.b.WriteString("-")
+			f.operand(n.Left, precedence(n))
+			break
+		}
+		// The operators are left-associative: the right operand of the
+		// precedence of n is in parentheses too.
+		f.operand(n.Left, precedence(n))
+		f.b.WriteString(" " + n.Op + " ")
+		f.operand(n.Right, precedence(n)+1)
+	case *operandNode:
+		if s, ok := n.Val.(string); ok {
+			f.b.WriteString(Quote(s))
+		} else {
+			f.b.WriteString(formatNumber(n.Val.(float64)))
+		}
+	case *groupNode:
+		f.b.WriteString("(")
+		f.format(n.Input)
+		f.b.WriteString(")")
+	case *variableNode:
+		f.b.WriteString("$" + qualifiedName(n.Prefix, n.Name))
+	case *functionNode:
+		f.b.WriteString(qualifiedName(n.Prefix, n.FuncName) + "(")
+		for i, arg := range n.Args {
+			if i > 0 {
+				f.b.WriteString(", ")
+			}
+			f.format(arg)
+		}
+		f.b.WriteString(")")
+	}
+}
+
+// operand prints the operand n of an operator, in parentheses if it is
+// an operator of a lower precedence than prec. The parsed expressions
+// have their parentheses, but not those changed, such as by Mutations.
+func (f *formatter) operand(n node, prec int) {
+	if op, ok := n.(*operatorNode); ok && precedence(op) < prec {
+		f.b.WriteString("(")
+		f.format(n)
+		f.b.WriteString(")")
+		return
+	}
+	f.format(n)
+}
+
+// isNegation reports whether n is -x, which the parser reads as x * -1.
+func isNegation(n *operatorNode) bool {
+	v, ok := n.Right.(*operandNode)
+	return ok && n.Op == "*" && v.Val == float64(-1)
+}
+
+// precedence returns the precedence of the operator n, from 1 for or to
+// 8 for |, -x being 7.
+func precedence(n *operatorNode) int {
+	if isNegation(n) {
+		return 7
+	}
+	switch n.Op {
+	case "or":
+		return 1
+	case "and":
+		return 2
+	case "=", "!=":
+		return 3
+	case "<", "<=", ">", ">=":
+		return 4
+	case "+", "-":
+		return 5
+	case "*", "div", "mod":
+		return 6
+	}
+	return 8
+}
+
+// isAbbreviated reports whether the step n is written //, . or .. in the
+// expression. Such steps have no node test, unlike node().
+func isAbbreviated(n node) bool {
+	a, ok := n.(*axisNode)
+	return ok && a.typeTest == allNode && a.Prop == "" && a.LocalName == "" && a.Prefix == "" &&
+		(a.AxisType == "descendant-or-self" || a.AxisType == "self" || a.AxisType == "parent")
+}
+
+// step prints the path to the step n, then n.
+func (f *formatter) step(n *axisNode) {
+	if n.Input != nil {
+		f.path(n.Input)
+	}
+	f.nodeStep(n)
+}
+
+// nodeStep prints the step n without the path to it.
+func (f *formatter) nodeStep(n *axisNode) {
+	if isAbbreviated(n) && !f.expand {
+		switch n.AxisType {
+		case "self":
+			f.b.WriteString(".")
+		case "parent":
+			f.b.WriteString("..")
+		}
+		// descendant-or-self is the second slash of //, see path.
+		return
+	}
+	switch {
+	case f.expand:
+		f.b.WriteString(n.AxisType + "::")
+	case n.AxisType == "attribute":
+		f.b.WriteString("@")
+	case n.AxisType != "child":
+		f.b.WriteString(n.AxisType + "::")
+	}
+	switch {
+	case isAbbreviated(n):
+		f.b.WriteString("node()")
+	case n.Prop == "processing-instruction" && n.LocalName != "":
+		f.b.WriteString("processing-instruction(" + Quote(n.LocalName) + ")")
+	case n.Prop != "":
+		f.b.WriteString(n.Prop + "()")
+	case n.LocalName == "" || n.LocalName == "*":
+		f.b.WriteString(qualifiedName(n.Prefix, "*"))
+	default:
+		f.b.WriteString(qualifiedName(n.Prefix, n.LocalName))
+	}
+}
+
+// path prints n followed by the slash to the next step.
+func (f *formatter) path(n node) {
+	switch {
+	case n.Type() == nodeRoot:
+		// The second slash of // is printed by its descendant-or-self
+		// step.
+	case isAbbreviated(n) && n.(*axisNode).AxisType == "descendant-or-self" && !f.expand:
+		f.path(n.(*axisNode).Input)
+	default:
+		f.format(n)
+	}
+	f.b.WriteString("/")
+}
+
+// A Step is a location step of an expression, in its abbreviated and its
+// expanded forms, see Expr.Steps.
+type Step struct {
+	// Axis is the axis of the step, such as child or attribute, and
+	// NodeTest its node test, such as a, p:*, text() or node().
+	Axis, NodeTest string
+	// Abbreviated is the step as Format prints it, such as a, @href or
+	// .., and // for the descendant-or-self::node() step of //. Expanded
+	// is the step as FormatWithOptions prints it with
+	// ExpandAbbreviations, such as child::a, attribute::href or
+	// parent::node().
+	Abbreviated, Expanded string
+}
+
+// Steps returns the location steps of the expression, in the order they
+// appear in it, so that tools can show what the abbreviations of a path
+// such as //a/@href mean: descendant-or-self::node(), child::a and
+// attribute::href. The steps of the predicates of a step, and those of
+// the arguments of a function, follow the step and the function call.
+func (expr *Expr) Steps() []Step {
+	var steps []Step
+	var walk func(n node)
+	walk = func(n node) {
+		switch n := n.(type) {
+		case *axisNode:
+			if n.Input != nil {
+				walk(n.Input)
+			}
+			var abbr, full strings.Builder
+			(&formatter{b: &abbr}).nodeStep(n)
+			(&formatter{b: &full, expand: true}).nodeStep(n)
+			step := Step{Axis: n.AxisType, Abbreviated: abbr.String(), Expanded: full.String()}
+			step.NodeTest = strings.TrimPrefix(step.Expanded, n.AxisType+"::")
+			if step.Abbreviated == "" {
+				step.Abbreviated = "//"
+			}
+			steps = append(steps, step)
+		case *filterNode:
+			walk(n.Input)
+			walk(n.Condition)
+		case *operatorNode:
+			walk(n.Left)
+			walk(n.Right)
+		case *groupNode:
+			walk(n.Input)
+		case *functionNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(parse(expr.s, expr.opts.Namespaces, nil))
+	return steps
+}
@@ -0,0 +1,79 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	for _, tc := range []struct {
+		expr, formatted, expanded string
+	}{
+		{`//book`, `//book`, `/descendant-or-self::node()/child::book`},
+		{`/bookstore/book[ @category = "web" ]//title`, `/bookstore/book[@category = 'web']//title`, `/child::bookstore/child::book[attribute::category = 'web']/descendant-or-self::node()/child::title`},
+		{`.//author/..`, `.//author/..`, `self::node()/descendant-or-self::node()/child::author/parent::node()`},
+		{`count( //book|//title )>2 and not ( @x )`, `count(//book | //title) > 2 and not(@x)`, `count(/descendant-or-self::node()/child::book | /descendant-or-self::node()/child::title) > 2 and not(attribute::x)`},
+		{`(//book)[1]`, `(//book)[1]`, `(/descendant-or-self::node()/child::book)[1]`},
+		{`- price * 2`, `-price * 2`, `-child::price * 2`},
+		{`(1+2)*3`, `(1 + 2) * 3`, `(1 + 2) * 3`},
+		{`child::book/attribute::lang`, `book/@lang`, `child::book/attribute::lang`},
+		{`self::node()`, `self::node()`, `self::node()`},
+		{`//processing-instruction("x")`, `//processing-instruction('x')`, `/descendant-or-self::node()/child::processing-instruction('x')`},
+		{`$v/p:*`, `$v/p:*`, `$v/child::p:*`},
+		{`"it's"`, `"it's"`, `"it's"`},
+		{`1.50`, `1.5`, `1.5`},
+		{`/`, `/`, `/`},
+	} {
+		s, err := Format(tc.expr)
+		assertNoErr(t, err)
+		assertEqual(t, tc.formatted, s)
+		s, err = FormatWithOptions(tc.expr, FormatOptions{ExpandAbbreviations: true})
+		assertNoErr(t, err)
+		assertEqual(t, tc.expanded, s)
+
+		// The formatted expressions select the same nodes.
+		for _, s := range []string{tc.formatted, tc.expanded} {
+			assertEqual(t, MustCompile(tc.expr).Evaluate(createNavigator(book_example)) == nil, MustCompile(s).Evaluate(createNavigator(book_example)) == nil)
+		}
+	}
+	test_xpath_elements(t, book_example, mustFormat(t, `//book[ price>35 ]/title`), 16, 26)
+
+	_, err := Format(`//book[`)
+	var se *SyntaxError
+	assertTrue(t, errors.As(err, &se))
+	_, err = Format(``)
+	assertTrue(t, errors.Is(err, ErrSyntax))
+}
+
+func mustFormat(t *testing.T, expr string) string {
+	s, err := FormatWithOptions(expr, FormatOptions{ExpandAbbreviations: true})
+	assertNoErr(t, err)
+	return s
+}
+
+func TestSteps(t *testing.T) {
+	for _, tc := range []struct {
+		expr  string
+		steps []Step
+	}{
+		{`//a/@href`, []Step{
+			{"descendant-or-self", "node()", "//", "descendant-or-self::node()"},
+			{"child", "a", "a", "child::a"},
+			{"attribute", "href", "@href", "attribute::href"},
+		}},
+		{`../b[. = 'x']/text()`, []Step{
+			{"parent", "node()", "..", "parent::node()"},
+			{"child", "b", "b", "child::b"},
+			{"self", "node()", ".", "self::node()"},
+			{"child", "text()", "text()", "child::text()"},
+		}},
+		{`count(ancestor::p:*) + 1`, []Step{
+			{"ancestor", "p:*", "ancestor::p:*", "ancestor::p:*"},
+		}},
+		{`1 + 2`, nil},
+	} {
+		expr, err := CompileWithOptions(tc.expr, CompileOptions{Namespaces: map[string]string{"p": "urn:p"}})
+		assertNoErr(t, err)
+		assertEqual(t, tc.steps, expr.Steps())
+	}
+}
@@ -1,13 +1,13 @@
 package xpath
 
 import (
-	"errors"
 	"fmt"
 	"math"
-	"strconv"
+	"sort"
 	"strings"
 	"sync"
 	"unicode"
+	"unicode/utf8"
 )
 
 // Defined an interface of stringBuilder that compatible with
@@ -43,6 +43,14 @@ func positionFunc() func(query, iterator) interface{} {
 			count = 1
 			node  = t.Current().Copy()
 		)
+		p, ok := t.(*predicateIterator)
+		if !ok {
+			// Outside of a predicate, the context is the node only.
+			return float64(1)
+		}
+		if p.position > 0 {
+			return float64(p.position)
+		}
 		test := predicate(q)
 		for node.MoveToPrevious() {
 			if test(node) {
@@ -60,7 +68,39 @@ func lastFunc() func(query, iterator) interface{} {
 			count = 0
 			node  = t.Current().Copy()
 		)
+		if _, ok := t.(*predicateIterator); !ok {
+			// Outside of a predicate, the context is the node only.
+			return float64(1)
+		}
 		test := predicate(q)
+		switch q.(type) {
+		case *parentQuery, *hasChildQuery, *selfQuery:
+			return float64(1)
+		case *ancestorQuery:
+			// The ancestors after the node, up to the root.
+			if p, ok := t.(*predicateIterator); ok && p.position > 0 {
+				count = p.position
+				for node.MoveToParent() {
+					if test(node) {
+						count++
+					}
+				}
+				return float64(count)
+			}
+		}
+		if node.NodeType() == AttributeNode {
+			// The attributes of the element, or the computed one.
+			node.MoveToParent()
+			for node.MoveToNextAttribute() {
+				if test(node) {
+					count++
+				}
+			}
+			if count == 0 {
+				count = 1
+			}
+			return float64(count)
+		}
 		node.MoveToFirst()
 		for {
 			if test(node) {
@@ -79,14 +119,9 @@ func countFunc(arg query) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
 		var count = 0
 		q := functionArgs(arg)
-		test := predicate(q)
 		switch typ := q.Evaluate(t).(type) {
 		case query:
-			for node := typ.Select(t); node != nil; node = typ.Select(t) {
-				if test(node) {
-					count++
-				}
-			}
+			count = countNodes(typ, t)
 		}
 		return float64(count)
 	}
@@ -99,16 +134,20 @@ func sumFunc(arg query) func(query, iterator) interface{} {
 		switch typ := functionArgs(arg).Evaluate(t).(type) {
 		case query:
 			for node := typ.Select(t); node != nil; node = typ.Select(t) {
-				if v, err := strconv.ParseFloat(node.Value(), 64); err == nil {
-					sum += v
+				s := nodeValue(t, node)
+				v, ok := parseNumber(t, s)
+				if !ok {
+					strictNumber(t, "sum()", s, false)
+					v = math.NaN()
 				}
+				sum += v
 			}
 		case float64:
 			sum = typ
 		case string:
-			v, err := strconv.ParseFloat(typ, 64)
-			if err != nil {
-				panic(errors.New("sum() function argument type must be a node-set or number"))
+			v, ok := parseNumber(t, typ)
+			if !ok {
+				panic(newError(ErrTypeMismatch, nil, "sum() function argument type must be a node-set or number"))
 			}
 			sum = v
 		}
@@ -121,18 +160,30 @@ func asNumber(t iterator, o interface{}) float64 {
 	case query:
 		node := typ.Select(t)
 		if node == nil {
+			strictNumber(t, "number()", "", true)
 			return math.NaN()
 		}
-		if v, err := strconv.ParseFloat(node.Value(), 64); err == nil {
+		s := nodeValue(t, node)
+		v, ok := parseNumber(t, s)
+		warnNumber(t, "number()", s, v, ok)
+		if ok {
 			return v
 		}
+		strictNumber(t, "number()", s, false)
 	case float64:
 		return typ
+	case bool:
+		if typ {
+			return 1
+		}
+		return 0
 	case string:
-		v, err := strconv.ParseFloat(typ, 64)
-		if err == nil {
+		v, ok := parseNumber(t, typ)
+		warnNumber(t, "number()", typ, v, ok)
+		if ok {
 			return v
 		}
+		strictNumber(t, "number()", typ, false)
 	}
 	return math.NaN()
 }
@@ -142,7 +193,7 @@ func ceilingFunc(arg query) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
 		val := asNumber(t, functionArgs(arg).Evaluate(t))
 		// if math.IsNaN(val) {
-		// 	panic(errors.New("ceiling() function argument type must be a valid number"))
+		// 	panic(newError(ErrTypeMismatch, nil, "ceiling() function argument type must be a valid number"))
 		// }
 		return math.Ceil(val)
 	}
@@ -235,13 +286,13 @@ func asBool(t iterator, v interface{}) bool {
 	case bool:
 		return v
 	case float64:
-		return v != 0
+		return v != 0 && !math.IsNaN(v)
 	case string:
 		return v != ""
 	case query:
-		return v.Select(t) != nil
+		return exists(v, t)
 	default:
-		panic(fmt.Errorf("unexpected type: %T", v))
+		panic(newError(ErrTypeMismatch, nil, "unexpected type: %T", v))
 	}
 }
 
@@ -255,7 +306,13 @@ func asString(t iterator, v interface{}) string {
 		}
 		return "false"
 	case float64:
-		return strconv.FormatFloat(v, 'g', -1, 64)
+		s := formatNumberOf(t, v)
+		if lim := limitsOf(t); lim.warnsCompat() {
+			if want := doubleString(v); want != s && strings.ContainsAny(s, "eI") {
+				lim.warnCompat("the string of the number %[2]v is %[3]q, %[1]s gives %[4]q for a double", v, s, want)
+			}
+		}
+		return s
 	case string:
 		return v
 	case query:
@@ -263,9 +320,9 @@ func asString(t iterator, v interface{}) string {
 		if node == nil {
 			return ""
 		}
-		return node.Value()
+		return nodeValue(t, node)
 	default:
-		panic(fmt.Errorf("unexpected type: %T", v))
+		panic(newError(ErrTypeMismatch, nil, "unexpected type: %T", v))
 	}
 }
 
@@ -280,6 +337,9 @@ func booleanFunc(arg1 query) func(query, iterator) interface{} {
 // numberFunc is a XPath functions number([node-set]).
 func numberFunc(arg1 query) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
+		if arg1 == nil {
+			return asNumber(t, nodeValue(t, t.Current()))
+		}
 		v := functionArgs(arg1).Evaluate(t)
 		return asNumber(t, v)
 	}
@@ -289,120 +349,81 @@ func numberFunc(arg1 query) func(query, iterator) interface{} {
 func stringFunc(arg1 query) func(query, iterator) interface{} {
 	return func(q query, t iterator) interface{} {
 		if arg1 == nil {
-			return t.Current().Value()
+			return nodeValue(t, t.Current())
 		}
 		v := functionArgs(arg1).Evaluate(t)
 		return asString(t, v)
 	}
 }
 
-// startwithFunc is a XPath functions starts-with(string, string).
-func startwithFunc(arg1, arg2 query) func(query, iterator) interface{} {
+// startwithFunc is a XPath functions starts-with(string, string, collation?).
+func startwithFunc(arg1, arg2 query, coll *collationArg) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
-		var (
-			m, n string
-			ok   bool
-		)
-		switch typ := functionArgs(arg1).Evaluate(t).(type) {
-		case string:
-			m = typ
-		case query:
-			node := typ.Select(t)
-			if node == nil {
-				return false
-			}
-			m = node.Value()
-		default:
-			panic(errors.New("starts-with() function argument type must be string"))
-		}
-		n, ok = functionArgs(arg2).Evaluate(t).(string)
-		if !ok {
-			panic(errors.New("starts-with() function argument type must be string"))
-		}
+		m := asString(t, functionArgs(arg1).Evaluate(t))
+		n := asString(t, functionArgs(arg2).Evaluate(t))
+		m, n = coll.keys(t, m, n)
 		return strings.HasPrefix(m, n)
 	}
 }
 
-// endwithFunc is a XPath functions ends-with(string, string).
-func endwithFunc(arg1, arg2 query) func(query, iterator) interface{} {
+// endwithFunc is a XPath functions ends-with(string, string, collation?).
+func endwithFunc(arg1, arg2 query, coll *collationArg) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
-		var (
-			m, n string
-			ok   bool
-		)
-		switch typ := functionArgs(arg1).Evaluate(t).(type) {
-		case string:
-			m = typ
-		case query:
-			node := typ.Select(t)
-			if node == nil {
-				return false
-			}
-			m = node.Value()
-		default:
-			panic(errors.New("ends-with() function argument type must be string"))
-		}
-		n, ok = functionArgs(arg2).Evaluate(t).(string)
-		if !ok {
-			panic(errors.New("ends-with() function argument type must be string"))
-		}
+		m := asString(t, functionArgs(arg1).Evaluate(t))
+		n := asString(t, functionArgs(arg2).Evaluate(t))
+		m, n = coll.keys(t, m, n)
 		return strings.HasSuffix(m, n)
 	}
 }
 
-// containsFunc is a XPath functions contains(string or @attr, string).
-func containsFunc(arg1, arg2 query) func(query, iterator) interface{} {
+// containsFunc is a XPath functions contains(string or @attr, string, collation?).
+func containsFunc(arg1, arg2 query, coll *collationArg) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
-		var (
-			m, n string
-			ok   bool
-		)
-		switch typ := functionArgs(arg1).Evaluate(t).(type) {
+		m := asString(t, functionArgs(arg1).Evaluate(t))
+		n := asString(t, functionArgs(arg2).Evaluate(t))
+		m, n = coll.keys(t, m, n)
+		return strings.Contains(m, n)
+	}
+}
+
+// compareFunc is the XPath 2.0 function compare(string, string,
+// collation?), -1, 0 or 1 as the first string is before, equal to or
+// after the second in the collation. An empty node-set is the empty
+// string.
+func compareFunc(arg1, arg2 query, coll *collationArg) func(query, iterator) interface{} {
+	value := func(t iterator, arg query) string {
+		switch v := functionArgs(arg).Evaluate(t).(type) {
 		case string:
-			m = typ
+			return v
 		case query:
-			node := typ.Select(t)
-			if node == nil {
-				return false
+			if node := v.Select(t); node != nil {
+				return nodeValue(t, node)
 			}
-			m = node.Value()
-		default:
-			panic(errors.New("contains() function argument type must be string"))
-		}
-
-		n, ok = functionArgs(arg2).Evaluate(t).(string)
-		if !ok {
-			panic(errors.New("contains() function argument type must be string"))
+			return ""
 		}
-
-		return strings.Contains(m, n)
+		panic(newError(ErrTypeMismatch, nil, "compare() function argument type must be string"))
+	}
+	return func(_ query, t iterator) interface{} {
+		m, n := coll.keys(t, value(t, arg1), value(t, arg2))
+		return float64(strings.Compare(m, n))
 	}
 }
 
 // matchesFunc is an XPath function that tests a given string against a regexp pattern.
-// Note: does not support https://www.w3.org/TR/xpath-functions-31/#func-matches 3rd optional `flags` argument; if
-// needed, directly put flags in the regexp pattern, such as `(?i)^pattern$` for `i` flag.
-func matchesFunc(arg1, arg2 query) func(query, iterator) interface{} {
+// The optional flags of https://www.w3.org/TR/xpath-functions-31/#func-matches are the letters
+// i, m, s, x and q, see regexpSource; the flags of Go can also be put in the pattern, such as
+// `(?i)^pattern$`, unless it is read as an XPath regular expression.
+func matchesFunc(arg1, arg2, arg3 query, patterns *regexpArg) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
-		var s string
-		switch typ := functionArgs(arg1).Evaluate(t).(type) {
-		case string:
-			s = typ
-		case query:
-			node := typ.Select(t)
-			if node == nil {
-				return ""
-			}
-			s = node.Value()
-		}
+		s := asString(t, functionArgs(arg1).Evaluate(t))
 		var pattern string
 		var ok bool
 		if pattern, ok = functionArgs(arg2).Evaluate(t).(string); !ok {
-			panic(errors.New("matches() function second argument type must be string"))
+			panic(newError(ErrTypeMismatch, nil, "matches() function second argument type must be string"))
 		}
-		re, err := getRegexp(pattern)
+		re, err := patterns.get(pattern, regexpFlags(t, "matches()", arg3))
 		if err != nil {
-			panic(fmt.Errorf("matches() function second argument is not a valid regexp pattern, err: %s", err.Error()))
+			panic(newError(ErrInvalidRegexp, err, "matches() function second argument is not a valid regexp pattern, err: %s", err.Error()))
 		}
 		return re.MatchString(s)
 	}
@@ -420,29 +441,73 @@ func normalizespaceFunc(arg1 query) func(query, iterator) interface{} {
 			if node == nil {
 				return ""
 			}
-			m = node.Value()
-		}
-		var b = builderPool.Get().(stringBuilder)
-		b.Grow(len(m))
-
-		runeStr := []rune(strings.TrimSpace(m))
-		l := len(runeStr)
-		for i := range runeStr {
-			r := runeStr[i]
-			isSpace := unicode.IsSpace(r)
-			if !(isSpace && (i+1 < l && unicode.IsSpace(runeStr[i+1]))) {
-				if isSpace {
-					r = ' '
-				}
-				b.WriteRune(r)
+			m = nodeValue(t, node)
+		default:
+			m = asString(t, typ)
+		}
+		return normalizeSpace(m)
+	}
+}
+
+// normalizeSpace strips the leading and trailing whitespace of s and
+// replaces each run of whitespace with a single space. A string that is
+// already normalized is returned as is, without allocating.
+func normalizeSpace(s string) string {
+	if isNormalizedSpace(s) {
+		return s
+	}
+	var b = builderPool.Get().(stringBuilder)
+	b.Grow(len(s))
+
+	space, started := false, false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = started
+			continue
+		}
+		if space {
+			b.WriteRune(' ')
+			space = false
+		}
+		b.WriteRune(r)
+		started = true
+	}
+	result := b.String()
+	b.Reset()
+	builderPool.Put(b)
+	return result
+}
+
+// isNormalizedSpace reports whether normalizeSpace would return s
+// unchanged.
+func isNormalizedSpace(s string) bool {
+	space := true // at the start, a space is not allowed either
+	for i := 0; i < len(s); {
+		r, size := rune(s[i]), 1
+		if r >= utf8.RuneSelf {
+			r, size = utf8.DecodeRuneInString(s[i:])
+		}
+		if unicode.IsSpace(r) {
+			if space || r != ' ' {
+				return false
 			}
+			space = true
+		} else {
+			space = false
 		}
-		result := b.String()
-		b.Reset()
-		builderPool.Put(b)
+		i += size
+	}
+	return !space || len(s) == 0
+}
 
-		return result
+// stringLength returns the length of s, in bytes unless the evaluation
+// of t counts characters.
+func stringLength(t iterator, s string) float64 {
+	if compatOf(t).Characters {
+		return float64(utf8.RuneCountInString(s))
 	}
+	warnBytes(t, "string-length()", s)
+	return float64(len(s))
 }
 
 // substringFunc is XPath functions substring function returns a part of a given string.
@@ -457,79 +522,51 @@ func substringFunc(arg1, arg2, arg3 query) func(query, iterator) interface{} {
 			if node == nil {
 				return ""
 			}
-			m = node.Value()
+			m = nodeValue(t, node)
+		default:
+			m = asString(t, typ)
+		}
+		chars := compatOf(t).Characters
+		if !chars {
+			warnBytes(t, "substring()", m)
 		}
 
-		var start, length float64
-		var ok bool
-		if start, ok = functionArgs(arg2).Evaluate(t).(float64); !ok {
-			panic(errors.New("substring() function first argument type must be number"))
+		var length float64
+		start := asNumber(t, functionArgs(arg2).Evaluate(t))
+		if chars {
+			length = math.Inf(1)
+			if arg3 != nil {
+				length = asNumber(t, functionArgs(arg3).Evaluate(t))
+			}
+			return substringChars(m, start, length)
 		}
 		// fix https://github.com/antchfx/xpath/issues/109
-		start = math.Round(start)
-		if start > float64(len(m)) {
-			return ""
+		// The bytes are those whose position p, from 1, is such that
+		// round(start) <= p < round(start) + round(length).
+		first := math.Floor(start + 0.5)
+		end := math.Inf(1)
+		if arg3 != nil {
+			end = first + math.Floor(asNumber(t, functionArgs(arg3).Evaluate(t))+0.5)
 		}
-		if arg3 == nil {
-			if start <= 0 {
-				return m
-			}
-			return m[int(start)-1:]
+		if first < 1 {
+			first = 1
 		}
-
-		if length, ok = functionArgs(arg3).Evaluate(t).(float64); !ok {
-			panic(errors.New("substring() function second argument type must be number"))
+		if end > float64(len(m)+1) {
+			end = float64(len(m) + 1)
 		}
-		length = math.Round(length)
-		if length <= 0 {
+		if !(first < end) {
 			return ""
 		}
-		if length > float64(len(m)) {
-			length = float64(len(m))
-		}
-		if start < 0 {
-			length = length - math.Abs(start)
-			if length <= 1 {
-				return ""
-			}
-			return m[:int(length-1)]
-		}
-		if start == 0 {
-			return m[:int(length-1)]
-		}
-		return m[int(start-1):int(length+start-1)]
+		return m[int(first)-1 : int(end)-1]
 	}
 }
 
 // substringIndFunc is XPath functions substring-before/substring-after function returns a part of a given string.
 func substringIndFunc(arg1, arg2 query, after bool) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
-		var str string
-		switch v := functionArgs(arg1).Evaluate(t).(type) {
-		case string:
-			str = v
-		case query:
-			node := v.Select(t)
-			if node == nil {
-				return ""
-			}
-			str = node.Value()
-		}
-		var word string
-		switch v := functionArgs(arg2).Evaluate(t).(type) {
-		case string:
-			word = v
-		case query:
-			node := v.Select(t)
-			if node == nil {
-				return ""
-			}
-			word = node.Value()
-		}
-		if word == "" {
-			return ""
-		}
-
+		// An empty node-set is the empty string, found at the start of str.
+		str := asString(t, functionArgs(arg1).Evaluate(t))
+		word := asString(t, functionArgs(arg2).Evaluate(t))
 		i := strings.Index(str, word)
 		if i < 0 {
 			return ""
@@ -547,13 +584,15 @@ func stringLengthFunc(arg1 query) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
 		switch v := functionArgs(arg1).Evaluate(t).(type) {
 		case string:
-			return float64(len(v))
+			return stringLength(t, v)
 		case query:
 			node := v.Select(t)
 			if node == nil {
 				break
 			}
-			return float64(len(node.Value()))
+			return stringLength(t, nodeValue(t, node))
+		default:
+			return stringLength(t, asString(t, v))
 		}
 		return float64(0)
 	}
@@ -561,41 +600,155 @@ func stringLengthFunc(arg1 query) func(query, iterator) interface{} {
 
 // translateFunc is XPath functions translate() function returns a replaced string.
 func translateFunc(arg1, arg2, arg3 query) func(query, iterator) interface{} {
+	// The mapping is computed once when it is given by literals, as in
+	// translate(., 'ABC', 'abc').
+	var constant func(string) string
+	if c2, ok := arg2.(*constantQuery); ok {
+		if c3, ok := arg3.(*constantQuery); ok {
+			constant = newTranslator(asString(nil, c2.Val), asString(nil, c3.Val))
+		}
+	}
 	return func(_ query, t iterator) interface{} {
 		str := asString(t, functionArgs(arg1).Evaluate(t))
+		if constant != nil {
+			return limitString(t, constant(str))
+		}
 		src := asString(t, functionArgs(arg2).Evaluate(t))
 		dst := asString(t, functionArgs(arg3).Evaluate(t))
-
-		replace := make([]string, 0, len(src))
-		for i, s := range src {
-			d := ""
-			if i < len(dst) {
-				d = string(dst[i])
+		return limitString(t, newTranslator(src, dst)(str))
+	}
+}
+
+// newTranslator returns a function that replaces each character of its
+// argument found in src with the character at the same position in dst,
+// or removes it if dst is shorter. The first occurrence of a character in
+// src is used. Strings that need no change are returned as is.
+func newTranslator(src, dst string) func(string) string {
+	if isASCII(src) && isASCII(dst) {
+		return newASCIITranslator(src, dst)
+	}
+	mapping := make(map[rune]rune)
+	to := []rune(dst)
+	i := 0
+	for _, r := range src {
+		if _, ok := mapping[r]; !ok {
+			mapping[r] = -1
+			if i < len(to) {
+				mapping[r] = to[i]
+			}
+		}
+		i++
+	}
+	return func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if m, ok := mapping[r]; ok {
+				return m
 			}
-			replace = append(replace, string(s), d)
+			return r
+		}, s)
+	}
+}
+
+// newASCIITranslator is newTranslator for ASCII src and dst, using a byte
+// table. The bytes of other characters are all outside of the table.
+func newASCIITranslator(src, dst string) func(string) string {
+	const (
+		keep = iota
+		replace
+		remove
+	)
+	var (
+		op [utf8.RuneSelf]uint8
+		to [utf8.RuneSelf]byte
+	)
+	for i := 0; i < len(src); i++ {
+		c := src[i]
+		if op[c] != keep {
+			continue
+		}
+		if i < len(dst) {
+			op[c], to[c] = replace, dst[i]
+		} else {
+			op[c] = remove
 		}
-		return strings.NewReplacer(replace...).Replace(str)
 	}
+	return func(s string) string {
+		i := 0
+		for i < len(s) && (s[i] >= utf8.RuneSelf || op[s[i]] == keep) {
+			i++
+		}
+		if i == len(s) {
+			return s
+		}
+		b := make([]byte, i, len(s))
+		copy(b, s[:i])
+		for ; i < len(s); i++ {
+			c := s[i]
+			if c >= utf8.RuneSelf {
+				b = append(b, c)
+				continue
+			}
+			switch op[c] {
+			case keep:
+				b = append(b, c)
+			case replace:
+				b = append(b, to[c])
+			}
+		}
+		return string(b)
+	}
+}
+
+// isASCII reports whether s only contains ASCII characters.
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
 }
 
 // replaceFunc is XPath functions replace() function returns a replaced string.
-func replaceFunc(arg1, arg2, arg3 query) func(query, iterator) interface{} {
+func replaceFunc(arg1, arg2, arg3, arg4 query, patterns *regexpArg) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
 		str := asString(t, functionArgs(arg1).Evaluate(t))
 		src := asString(t, functionArgs(arg2).Evaluate(t))
 		dst := asString(t, functionArgs(arg3).Evaluate(t))
-		e, err := getRegexp(src)
+		e, err := patterns.get(src, regexpFlags(t, "replace()", arg4))
 		if err != nil {
-			panic(fmt.Errorf("replace() function second argument is not a valid regexp pattern, err: %s", err.Error()))
+			panic(newError(ErrInvalidRegexp, err, "replace() function second argument is not a valid regexp pattern, err: %s", err.Error()))
 		}
 
-		// replace all $i to ${i} for golang regexp.Expand
-		for idx := e.NumSubexp(); idx > 0; idx-- {
-			dst = strings.ReplaceAll(dst, fmt.Sprintf("$%d", idx), fmt.Sprintf("${%d}", idx))
+		if patterns.xsd {
+			if e.MatchString("") {
+				panic(newError(ErrInvalidRegexp, nil, "replace() function second argument %s matches the empty string", src))
+			}
+			if dst, err = xsdReplacement(dst, e.NumSubexp()); err != nil {
+				panic(newError(ErrInvalidRegexp, err, "replace() function third argument is not a valid replacement, err: %s", err.Error()))
+			}
+		} else {
+			// replace all $i to ${i} for golang regexp.Expand
+			for idx := e.NumSubexp(); idx > 0; idx-- {
+				dst = strings.ReplaceAll(dst, fmt.Sprintf("$%d", idx), fmt.Sprintf("${%d}", idx))
+			}
 		}
 
-		return e.ReplaceAllString(str, dst)
+		return limitString(t, e.ReplaceAllString(str, dst))
+	}
+}
+
+// regexpFlags returns the flags argument arg of the function fn, or "" if
+// it is nil.
+func regexpFlags(t iterator, fn string, arg query) string {
+	if arg == nil {
+		return ""
 	}
+	flags, ok := functionArgs(arg).Evaluate(t).(string)
+	if !ok {
+		panic(newError(ErrTypeMismatch, nil, "%s function flags argument type must be string", fn))
+	}
+	return flags
 }
 
 // notFunc is XPATH functions not(expression) function operation.
@@ -605,10 +758,9 @@ func notFunc(arg1 query) func(query, iterator) interface{} {
 		case bool:
 			return !v
 		case query:
-			node := v.Select(t)
-			return node == nil
+			return !exists(v, t)
 		default:
-			return false
+			return !asBool(t, v)
 		}
 	}
 }
@@ -628,15 +780,17 @@ func concatFunc(args ...query) func(query, iterator) interface{} {
 			case query:
 				node := v.Select(t)
 				if node != nil {
-					b.WriteString(node.Value())
+					b.WriteString(nodeValue(t, node))
 				}
+			default:
+				b.WriteString(asString(t, v))
 			}
 		}
 		result := b.String()
 		b.Reset()
 		builderPool.Put(b)
 
-		return result
+		return limitString(t, result)
 	}
 }
 
@@ -650,11 +804,13 @@ func functionArgs(q query) query {
 
 func reverseFunc(q query, t iterator) func() NodeNavigator {
 	var list []NodeNavigator
+	lim := limitsOf(t)
 	for {
 		node := q.Select(t)
 		if node == nil {
 			break
 		}
+		lim.addNodes(1)
 		list = append(list, node.Copy())
 	}
 	i := len(list)
@@ -668,6 +824,62 @@ func reverseFunc(q query, t iterator) func() NodeNavigator {
 	}
 }
 
+// idFunc is the XPath function id(object), which selects the elements
+// whose ID is one of the whitespace-separated values of its argument.
+// There is no DTD to declare ID attributes, so the id attribute is used,
+// looked up in the index of the document if there is one.
+func idFunc(q query, t iterator) func() NodeNavigator {
+	ids := make(map[string]bool)
+	switch v := functionArgs(q).Evaluate(t).(type) {
+	case query:
+		for node := v.Select(t); node != nil; node = v.Select(t) {
+			for _, id := range strings.Fields(nodeValue(t, node)) {
+				ids[id] = true
+			}
+		}
+	default:
+		for _, id := range strings.Fields(asString(t, v)) {
+			ids[id] = true
+		}
+	}
+
+	var list []indexedNode
+	root := t.Current().Copy()
+	root.MoveToRoot()
+	indexed := false
+	if ix, ok := root.(attrIndexer); ok {
+		indexed = true
+		for id := range ids {
+			nodes, ok := ix.lookupAttr("", "id", id)
+			if !ok {
+				indexed = false
+				list = nil
+				break
+			}
+			limitsOf(t).addNodes(len(nodes))
+			list = append(list, nodes...)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].ord < list[j].ord })
+	}
+	if !indexed {
+		walkAttributes(root, "", "id", func(ord int, n NodeNavigator, value string) {
+			if ids[value] {
+				limitsOf(t).addNodes(1)
+				list = append(list, indexedNode{ord: ord, node: n.Copy()})
+			}
+		})
+	}
+
+	i := 0
+	return func() NodeNavigator {
+		if i >= len(list) {
+			return nil
+		}
+		i++
+		return list[i-1].node
+	}
+}
+
 // string-join is a XPath Node Set functions string-join(node-set, separator).
 func stringJoinFunc(q, arg1 query) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
@@ -678,7 +890,7 @@ func stringJoinFunc(q, arg1 query) func(query, iterator) interface{} {
 		case query:
 			node := v.Select(t)
 			if node != nil {
-				separator = node.Value()
+				separator = nodeValue(t, node)
 			}
 		}
 
@@ -691,11 +903,11 @@ func stringJoinFunc(q, arg1 query) func(query, iterator) interface{} {
 		case query:
 			for node := v.Select(t); node != nil; node = v.Select(t) {
 				if test(node) {
-					parts = append(parts, node.Value())
+					parts = append(parts, nodeValue(t, node))
 				}
 			}
 		}
-		return strings.Join(parts, separator)
+		return limitString(t, strings.Join(parts, separator))
 	}
 }
 
@@ -703,6 +915,6 @@ func stringJoinFunc(q, arg1 query) func(query, iterator) interface{} {
 func lowerCaseFunc(arg1 query) func(query, iterator) interface{} {
 	return func(_ query, t iterator) interface{} {
 		v := functionArgs(arg1).Evaluate(t)
-		return strings.ToLower(asString(t, v))
+		return limitString(t, strings.ToLower(asString(t, v)))
 	}
 }
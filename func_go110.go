@@ -7,8 +7,14 @@ import (
 	"strings"
 )
 
-func round(f float64) int {
-	return int(math.Round(f))
+// round rounds f to the nearest integer, and the halves towards positive
+// infinity, as the XPath round function does.
+func round(f float64) float64 {
+	r := math.Floor(f)
+	if f-r >= 0.5 {
+		r++
+	}
+	return r
 }
 
 func newStringBuilder() stringBuilder {
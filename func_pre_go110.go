@@ -7,14 +7,14 @@ import (
 	"math"
 )
 
-// math.Round() is supported by Go 1.10+,
-// This method just compatible for version <1.10.
-// https://github.com/golang/go/issues/20100
-func round(f float64) int {
-	if math.Abs(f) < 0.5 {
-		return 0
+// round rounds f to the nearest integer, and the halves towards positive
+// infinity, as the XPath round function does.
+func round(f float64) float64 {
+	r := math.Floor(f)
+	if f-r >= 0.5 {
+		r++
 	}
-	return int(f + math.Copysign(0.5, f))
+	return r
 }
 
 func newStringBuilder() stringBuilder {
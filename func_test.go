@@ -0,0 +1,30 @@
+package xpath
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAsNumber(t *testing.T) {
+	assertEqual(t, float64(1), asNumber(nil, true))
+	assertEqual(t, float64(0), asNumber(nil, false))
+	assertEqual(t, 2.5, asNumber(nil, 2.5))
+	assertTrue(t, math.IsNaN(asNumber(nil, "x")))
+
+	test_xpath_eval(t, empty_example, `number(true())`, float64(1))
+	test_xpath_eval(t, empty_example, `number(false())`, float64(0))
+	test_xpath_eval(t, empty_example, `true() + 1`, float64(2))
+	test_xpath_eval(t, empty_example, `floor(1 = 1)`, float64(1))
+}
+
+func TestAsBool(t *testing.T) {
+	assertFalse(t, asBool(nil, math.NaN()))
+	assertFalse(t, asBool(nil, float64(0)))
+	assertTrue(t, asBool(nil, math.Inf(-1)))
+	assertTrue(t, asBool(nil, 0.5))
+
+	test_xpath_eval(t, empty_example, `boolean(0 div 0)`, false)
+	test_xpath_eval(t, empty_example, `boolean(number('x'))`, false)
+	test_xpath_eval(t, empty_example, `not(0 div 0)`, true)
+	test_xpath_eval(t, empty_example, `boolean(1 div 0)`, true)
+}
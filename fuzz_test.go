@@ -0,0 +1,118 @@
+package xpath
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// fuzzSeeds are real-world expressions, seeding the corpus of the fuzz
+// targets.
+var fuzzSeeds = []string{
+	`/`,
+	`.`,
+	`//book`,
+	`/bookstore/book[1]/title`,
+	`//book[@category='web'][last()]`,
+	`//book[price > 35]/title/text()`,
+	`//book[position() <= 2 and @category != 'cooking']`,
+	`//*[local-name()='book' and namespace-uri()='']`,
+	`//title[@lang]`,
+	`//@lang`,
+	`count(//book) + sum(//price) div 2`,
+	`//book/descendant::*[not(self::price)]`,
+	`//price/ancestor-or-self::*`,
+	`//book[1]/following-sibling::book[2]/preceding-sibling::*`,
+	`//book/following::title | //book/preceding::price`,
+	`(//book | //title)[3]`,
+	`//a[starts-with(@href, 'http') or contains(@class, 'nav')]`,
+	`//div[@id='main']//p[normalize-space(.) != '']`,
+	`//li[position() mod 2 = 0]`,
+	`concat(substring-before('a-b', '-'), translate('abc', 'b', 'B'))`,
+	`substring('12345', 2, 3)`,
+	`string-length(normalize-space(//title))`,
+	`boolean(//book[author = 'J K. Rowling'])`,
+	`round(12.5) - floor(-1.5) + ceiling(1.2)`,
+	`-(//price[1]) * 3`,
+	`//book[year = 2005]/title = 'Harry Potter'`,
+	`//node()[self::text() or self::comment()]`,
+	`//processing-instruction()`,
+	`name(//*[last()])`,
+	`reverse(//book)`,
+	`lower-case(//title[1])`,
+	`ends-with(//title[1], 'Italian')`,
+	`matches(//title[1], '^Every.*')`,
+	`replace(//title[1], 'a', 'b')`,
+	`string-join(//title, ', ')`,
+	`//book[@category='web' and (year > 2003 or price < 40)]`,
+	`//*[contains(translate(., 'ABCDEFGHIJKLMNOPQRSTUVWXYZ', 'abcdefghijklmnopqrstuvwxyz'), 'potter')]`,
+	`//book[`,
+	`//book[@`,
+	`foo()`,
+	`$x`,
+	`1 div 0`,
+	`'unterminated`,
+}
+
+func FuzzCompile(f *testing.F) {
+	for _, expr := range fuzzSeeds {
+		f.Add(expr)
+	}
+	f.Fuzz(func(t *testing.T, expr string) {
+		if _, err := Compile(expr); err != nil {
+			return
+		}
+		// The formatted expression compiles too.
+		s, err := Format(expr)
+		if err != nil {
+			t.Fatalf("Format(%q): %v", expr, err)
+		}
+		if _, err := Compile(s); err != nil {
+			t.Fatalf("%q compiles but its format %q does not: %v", expr, s, err)
+		}
+	})
+}
+
+func FuzzEvaluate(f *testing.F) {
+	for _, expr := range fuzzSeeds {
+		f.Add(expr)
+	}
+	f.Fuzz(func(t *testing.T, expr string) {
+		e, err := Compile(expr)
+		if err != nil {
+			return
+		}
+		for _, doc := range []*TNode{book_example, html_example} {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			opts := EvalOptions{
+				MaxBufferedNodes: 100000,
+				MaxStringBytes:   1 << 20,
+				RecoverPanics:    true,
+				Context:          ctx,
+			}
+			v, err := e.EvaluateWithOptions(createNavigator(doc), opts)
+			if iter, ok := v.(*NodeIterator); ok && err == nil {
+				for iter.MoveNext() {
+				}
+				err = iter.Err()
+			}
+			cancel()
+			// The errors of expressions that do not apply to the
+			// document, such as sum('a'), and of the limits are expected;
+			// those of the runtime, such as an index out of range, and
+			// the timeouts of expressions that do not terminate are not.
+			var evalErr *EvalError
+			if errors.As(err, &evalErr) {
+				if _, ok := evalErr.Value.(runtime.Error); ok {
+					t.Fatalf("%s: %v", expr, err)
+				}
+			}
+			var timeout *TimeoutError
+			if errors.As(err, &timeout) {
+				t.Fatalf("%s: %v", expr, err)
+			}
+		}
+	})
+}
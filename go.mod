@@ -2,4 +2,4 @@ module github.com/antchfx/xpath
 
 go 1.18
 
-require pgregory.net/rapid v1.2.0 // test
+require pgregory.net/rapid v1.2.0
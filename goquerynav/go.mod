@@ -0,0 +1,13 @@
+module github.com/antchfx/xpath/goquerynav
+
+go 1.18
+
+require (
+	github.com/PuerkitoBio/goquery v1.8.1
+	github.com/antchfx/xpath v0.0.0
+	golang.org/x/net v0.7.0
+)
+
+require github.com/andybalholm/cascadia v1.3.1 // indirect
+
+replace github.com/antchfx/xpath => ../
@@ -0,0 +1,245 @@
+// Package goquerynav evaluates XPath expressions on the documents of
+// goquery, so that CSS selectors and XPath expressions can be mixed on
+// the same tree without parsing it again:
+//
+//	doc, _ := goquery.NewDocumentFromReader(r)
+//	links := goquerynav.Find(doc.Find("nav"), xpath.MustCompile(".//a[@href]"))
+//	links.Each(func(i int, s *goquery.Selection) { ... })
+//
+// It is a module of its own, so that the xpath module does not depend on
+// goquery and golang.org/x/net/html.
+package goquerynav
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xpath"
+	"golang.org/x/net/html"
+)
+
+// CreateNavigator returns a new xpath.NodeNavigator positioned at top.
+// The root of the navigator is the topmost ancestor of top.
+func CreateNavigator(top *html.Node) *NodeNavigator {
+	root := top
+	for root.Parent != nil {
+		root = root.Parent
+	}
+	return &NodeNavigator{root: root, curr: top, attr: -1}
+}
+
+// NodeNavigator is an xpath.NodeNavigator over an html.Node tree. The
+// doctype is not a node of the tree, and the namespace of the attributes
+// of foreign elements, such as xlink, is their prefix.
+type NodeNavigator struct {
+	root, curr *html.Node
+	attr       int
+}
+
+// Current returns the node at the navigator position. When positioned on
+// an attribute, it returns the element that owns the attribute.
+func (n *NodeNavigator) Current() *html.Node {
+	return n.curr
+}
+
+func (n *NodeNavigator) NodeType() xpath.NodeType {
+	if n.attr != -1 {
+		return xpath.AttributeNode
+	}
+	switch n.curr.Type {
+	case html.DocumentNode:
+		return xpath.RootNode
+	case html.TextNode:
+		return xpath.TextNode
+	case html.CommentNode:
+		return xpath.CommentNode
+	}
+	return xpath.ElementNode
+}
+
+func (n *NodeNavigator) LocalName() string {
+	if n.attr != -1 {
+		return n.curr.Attr[n.attr].Key
+	}
+	if n.curr.Type == html.ElementNode {
+		return n.curr.Data
+	}
+	return ""
+}
+
+func (n *NodeNavigator) Prefix() string {
+	if n.attr != -1 {
+		return n.curr.Attr[n.attr].Namespace
+	}
+	return ""
+}
+
+func (n *NodeNavigator) Value() string {
+	switch {
+	case n.attr != -1:
+		return n.curr.Attr[n.attr].Val
+	case n.curr.Type == html.TextNode || n.curr.Type == html.CommentNode:
+		return n.curr.Data
+	}
+	var b strings.Builder
+	writeText(&b, n.curr)
+	return b.String()
+}
+
+// writeText writes the text of the descendants of n to b.
+func writeText(b *strings.Builder, n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.TextNode {
+			b.WriteString(c.Data)
+		} else {
+			writeText(b, c)
+		}
+	}
+}
+
+func (n *NodeNavigator) Copy() xpath.NodeNavigator {
+	n2 := *n
+	return &n2
+}
+
+func (n *NodeNavigator) MoveToRoot() {
+	n.curr = n.root
+	n.attr = -1
+}
+
+func (n *NodeNavigator) MoveToParent() bool {
+	if n.attr != -1 {
+		n.attr = -1
+		return true
+	}
+	if n.curr.Parent != nil {
+		n.curr = n.curr.Parent
+		return true
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToNextAttribute() bool {
+	if n.curr.Type != html.ElementNode || n.attr+1 >= len(n.curr.Attr) {
+		return false
+	}
+	n.attr++
+	return true
+}
+
+func (n *NodeNavigator) MoveToChild() bool {
+	if n.attr != -1 {
+		return false
+	}
+	for node := n.curr.FirstChild; node != nil; node = node.NextSibling {
+		if isVisible(node) {
+			n.curr = node
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToFirst() bool {
+	if n.attr != -1 {
+		return false
+	}
+	moved := false
+	for node := n.curr.PrevSibling; node != nil; node = node.PrevSibling {
+		if isVisible(node) {
+			n.curr = node
+			moved = true
+		}
+	}
+	return moved
+}
+
+func (n *NodeNavigator) MoveToNext() bool {
+	if n.attr != -1 {
+		return false
+	}
+	for node := n.curr.NextSibling; node != nil; node = node.NextSibling {
+		if isVisible(node) {
+			n.curr = node
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveToPrevious() bool {
+	if n.attr != -1 {
+		return false
+	}
+	for node := n.curr.PrevSibling; node != nil; node = node.PrevSibling {
+		if isVisible(node) {
+			n.curr = node
+			return true
+		}
+	}
+	return false
+}
+
+func (n *NodeNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	node, ok := other.(*NodeNavigator)
+	if !ok {
+		return false
+	}
+	n.root = node.root
+	n.curr = node.curr
+	n.attr = node.attr
+	return true
+}
+
+// isVisible reports whether the node is a node of the XPath tree.
+func isVisible(n *html.Node) bool {
+	return n.Type != html.DoctypeNode && n.Type != html.ErrorNode && n.Type != html.RawNode
+}
+
+// FromSelection returns the navigators of the nodes of s, such as the
+// document node of a goquery.Document, to evaluate expressions from.
+func FromSelection(s *goquery.Selection) []xpath.NodeNavigator {
+	list := make([]xpath.NodeNavigator, len(s.Nodes))
+	for i, n := range s.Nodes {
+		list[i] = CreateNavigator(n)
+	}
+	return list
+}
+
+// ToSelection returns the selection of the nodes of the navigators, which
+// NodeNavigator or wrap one, as the results of an evaluation with
+// xpath.EvalOptions do, in their order and each once. An attribute is
+// replaced by its element, since it is not a node of html.Node trees.
+// The selection has its own nodes: s, the selection the nodes were
+// evaluated from, is left as it is.
+func ToSelection(s *goquery.Selection, nodes []xpath.NodeNavigator) *goquery.Selection {
+	list := make([]*html.Node, 0, len(nodes))
+	seen := make(map[*html.Node]bool, len(nodes))
+	for _, nav := range nodes {
+		for {
+			w, ok := nav.(interface{ Unwrap() xpath.NodeNavigator })
+			if !ok {
+				break
+			}
+			nav = w.Unwrap()
+		}
+		if n, ok := nav.(*NodeNavigator); ok && !seen[n.curr] {
+			seen[n.curr] = true
+			list = append(list, n.curr)
+		}
+	}
+	return &goquery.Selection{Nodes: list}
+}
+
+// Find returns the selection of the nodes expr selects from each node of
+// s, in their order and each once. An attribute is replaced by its
+// element. It panics if expr is not a node-set, as Select does.
+func Find(s *goquery.Selection, expr *xpath.Expr) *goquery.Selection {
+	var nodes []xpath.NodeNavigator
+	for _, nav := range FromSelection(s) {
+		for iter := expr.Select(nav); iter.MoveNext(); {
+			nodes = append(nodes, iter.Current().Copy())
+		}
+	}
+	return ToSelection(s, nodes)
+}
@@ -0,0 +1,57 @@
+package goquerynav
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/antchfx/xpath"
+)
+
+const page = `<!DOCTYPE html><html><body>` +
+	`<nav><a href="/a">A</a><a>B</a></nav>` +
+	`<div class="post"><p>One <b>two</b></p><a href="/c">C</a></div></body></html>`
+
+func TestFind(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := Find(doc.Find("nav"), xpath.MustCompile(".//a[@href]"))
+	if links.Length() != 1 || links.Text() != "A" {
+		t.Fatalf("unexpected links %q", links.Text())
+	}
+	// An attribute is replaced by its element, and the elements are kept
+	// once.
+	hrefs := Find(doc.Selection, xpath.MustCompile("//a/@href | //a[@href]"))
+	if hrefs.Length() != 2 || hrefs.Text() != "AC" {
+		t.Fatalf("unexpected elements of the attributes %q", hrefs.Text())
+	}
+	// CSS selectors apply to the results, and XPath to those of selectors.
+	if s := Find(doc.Find(".post"), xpath.MustCompile("p")).Find("b").Text(); s != "two" {
+		t.Fatalf("unexpected text %q", s)
+	}
+	// The selection evaluated from keeps its nodes.
+	post := doc.Find(".post")
+	Find(post, xpath.MustCompile("p | a"))
+	if post.Length() != 1 || post.Nodes[0].Data != "div" {
+		t.Fatalf("unexpected selection after Find %v", post.Nodes)
+	}
+
+	nav := FromSelection(doc.Selection)[0]
+	if v := xpath.MustCompile("string(//div/p)").Evaluate(nav); v != "One two" {
+		t.Fatalf("unexpected string-value %v", v)
+	}
+	if v := xpath.MustCompile("count(/node())").Evaluate(nav); v != float64(1) {
+		t.Fatalf("expected the doctype to be hidden, got %v nodes", v)
+	}
+	// The nodes of evaluations with options wrap the navigators.
+	iter := xpath.MustCompile("//b").SelectWithOptions(nav, xpath.EvalOptions{Stats: new(xpath.Stats)})
+	var nodes []xpath.NodeNavigator
+	for iter.MoveNext() {
+		nodes = append(nodes, iter.Current().Copy())
+	}
+	if s := ToSelection(doc.Selection, nodes); s.Length() != 1 || s.Text() != "two" {
+		t.Fatalf("unexpected selection of wrapped navigators %q", s.Text())
+	}
+}
@@ -0,0 +1,78 @@
+package xpath
+
+import "fmt"
+
+// A Group is the nodes of a node-set that have one value of the key of
+// GroupBy, in document order.
+type Group struct {
+	Key   string
+	Nodes []NodeNavigator
+}
+
+// GroupBy evaluates expr from root, which must select a node-set, and
+// groups its nodes by the value of key evaluated from each of them, as
+// xsl:for-each-group with group-by does, in place of the Muenchian
+// grouping of XPath 1.0 such as
+// //book[not(@category = preceding::book/@category)]:
+//
+//	groups, err := xpath.GroupBy(xpath.MustCompile("//book"), xpath.MustCompile("@category"), root, xpath.EvalOptions{})
+//
+// The groups are in the order of their first node. A key that is a
+// node-set puts the node in a group for each of the string values of its
+// nodes, and in none if it is empty; a number, a string or a boolean is
+// converted to a string as string() does. Both expressions are evaluated
+// within the limits of opts.
+func GroupBy(expr, key *Expr, root NodeNavigator, opts EvalOptions) ([]Group, error) {
+	v, err := expr.EvaluateWithOptions(root, opts)
+	if err != nil {
+		return nil, err
+	}
+	iter, ok := v.(*NodeIterator)
+	if !ok {
+		return nil, fmt.Errorf("xpath: GroupBy: %s is a %T, not a node-set", expr, v)
+	}
+	var groups []Group
+	index := make(map[string]int)
+	for iter.MoveNext() {
+		node := iter.Current().Copy()
+		keys, err := groupKeys(key, node, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			i, ok := index[k]
+			if !ok {
+				i = len(groups)
+				index[k] = i
+				groups = append(groups, Group{Key: k})
+			}
+			groups[i].Nodes = append(groups[i].Nodes, node)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// groupKeys returns the distinct values of key evaluated from node, in
+// the order of its result.
+func groupKeys(key *Expr, node NodeNavigator, opts EvalOptions) ([]string, error) {
+	v, err := key.EvaluateWithOptions(node.Copy(), opts)
+	if err != nil {
+		return nil, err
+	}
+	iter, ok := v.(*NodeIterator)
+	if !ok {
+		return []string{asString(&NodeIterator{lim: key.compatLimiter()}, v)}, nil
+	}
+	var keys []string
+	seen := make(map[string]bool)
+	for iter.MoveNext() {
+		if k := iter.Current().Value(); !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys, iter.Err()
+}
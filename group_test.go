@@ -0,0 +1,41 @@
+package xpath
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroupBy(t *testing.T) {
+	nav := createNavigator(book_example)
+	books := MustCompile("//book")
+	for _, tc := range []struct {
+		key  string
+		want []string
+	}{
+		{"@category", []string{"cooking: Everyday Italian", "children: Harry Potter", "web: XQuery Kick Start, Learning XML"}},
+		{"year", []string{"2005: Everyday Italian, Harry Potter", "2003: XQuery Kick Start, Learning XML"}},
+		{"price > 35", []string{"false: Everyday Italian, Harry Potter", "true: XQuery Kick Start, Learning XML"}},
+		{"count(author)", []string{"1: Everyday Italian, Harry Potter, Learning XML", "5: XQuery Kick Start"}},
+		// A book is in the group of each of its authors, and in none
+		// without one.
+		{"author[starts-with(., 'J')]", []string{"J K. Rowling: Harry Potter", "James McGovern: XQuery Kick Start", "James Linn: XQuery Kick Start"}},
+		{"@missing", nil},
+	} {
+		groups, err := GroupBy(books, MustCompile(tc.key), nav, EvalOptions{})
+		assertNoErr(t, err)
+		var got []string
+		for _, g := range groups {
+			var titles []string
+			for _, n := range g.Nodes {
+				titles = append(titles, MustCompile("string(title)").Evaluate(n).(string))
+			}
+			got = append(got, g.Key+": "+strings.Join(titles, ", "))
+		}
+		if strings.Join(got, "; ") != strings.Join(tc.want, "; ") {
+			t.Errorf("GroupBy(%s) = %q, want %q", tc.key, got, tc.want)
+		}
+	}
+
+	_, err := GroupBy(MustCompile("count(//book)"), MustCompile("@category"), nav, EvalOptions{})
+	assertErr(t, err)
+}
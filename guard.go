@@ -0,0 +1,132 @@
+package xpath
+
+import "sync"
+
+// rebuiltQueries are the queries of an expression with its operations
+// wrapped, for EvalOptions.RecoverPanics and VerifyOrder, each built the
+// first time it is used: guarded, verified, and both.
+type rebuiltQueries [3]struct {
+	once sync.Once
+	q    query
+}
+
+// rebuilt returns the query of expr with its operations guarded if guard
+// is true, and their order verified if verify is true.
+func (expr *Expr) rebuilt(guard, verify bool) query {
+	if expr.rebuild == nil {
+		// The expression did not compile, see MustCompile.
+		return expr.q.Clone()
+	}
+	r := &expr.rebuild[0]
+	switch {
+	case guard && verify:
+		r = &expr.rebuild[2]
+	case verify:
+		r = &expr.rebuild[1]
+	}
+	r.once.Do(func() {
+		// The expression is built again, as for Plan, for the labels of
+		// the operations, as the queries don't keep them.
+		info := newPlanInfo()
+		q, err := build(expr.s, expr.opts, info)
+		if err != nil || q == nil {
+			info, q = nil, expr.q.Clone()
+		}
+		// The order is known from the queries before they are wrapped.
+		sorted := make(map[query]bool)
+		rewriteQuery(q, func(q query) query {
+			if o := orderOf(q); o.sorted && !o.single {
+				sorted[q] = true
+			}
+			return q
+		})
+		r.q = rewriteQuery(q, func(q query) query {
+			op := info.describe(q).Op
+			w := q
+			if verify && sorted[q] {
+				w = &verifyQuery{query: w, expr: expr.s, op: op}
+			}
+			if guard {
+				g := &guardQuery{query: w, expr: expr.s, op: op, offset: -1}
+				if info != nil {
+					if span, ok := info.spans[q]; ok {
+						g.offset, g.end = span[0], span[1]
+					}
+				}
+				w = g
+			}
+			return w
+		})
+	})
+	return r.q.Clone()
+}
+
+// guardQuery turns the panics of a query, other than those of the
+// evaluation limits, into an *EvalError naming the query.
+type guardQuery struct {
+	query
+	expr        string
+	op          string // the label of the query in the plan
+	offset, end int    // the query in expr, or -1 if not known
+}
+
+func (q *guardQuery) recover() {
+	e := recover()
+	switch e.(type) {
+	case nil:
+		return
+	case *ResourceLimitError, *TimeoutError, *CanceledError, *OrderError, *ComparisonError, *EvalError:
+		// The error of the innermost operation is kept.
+	default:
+		e = &EvalError{Expr: q.expr, Op: q.op, Offset: q.offset, End: q.end, Value: e}
+	}
+	panic(e)
+}
+
+func (q *guardQuery) Select(t iterator) NodeNavigator {
+	defer q.recover()
+	return q.query.Select(t)
+}
+
+func (q *guardQuery) Evaluate(t iterator) interface{} {
+	defer q.recover()
+	v := q.query.Evaluate(t)
+	if v == interface{}(q.query) {
+		// The node-set is the query itself, it is selected through q.
+		return q
+	}
+	return v
+}
+
+func (q *guardQuery) Clone() query {
+	return &guardQuery{query: q.query.Clone(), expr: q.expr, op: q.op, offset: q.offset, end: q.end}
+}
+
+func (q *guardQuery) exists(t iterator) bool {
+	defer q.recover()
+	return exists(q.query, t)
+}
+
+func (q *guardQuery) count(t iterator) int {
+	defer q.recover()
+	return countNodes(q.query, t)
+}
+
+func (q *guardQuery) position() int {
+	return getNodePosition(q.query)
+}
+
+func (q *guardQuery) hasPosition() bool {
+	return hasPosition(q.query)
+}
+
+// Test is the node test of q, for position() and last(), see predicate.
+func (q *guardQuery) Test(n NodeNavigator) bool {
+	return predicate(q.query)(n)
+}
+
+func (q *guardQuery) skipContext() {
+	if s, ok := q.query.(contextSkipper); ok {
+		s.skipContext()
+	}
+}
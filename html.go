@@ -0,0 +1,132 @@
+package xpath
+
+import "strings"
+
+// HTMLFunctions is the namespace URI of the functions for HTML documents,
+// which expressions call with a prefix bound to it, such as html with
+// CompileWithNS(expr, map[string]string{"html": xpath.HTMLFunctions}):
+//
+//	html:has-class([node-set,] class) reports whether the class
+//	attribute of the context node, or of one of the nodes, has the
+//	class among its classes.
+//	html:inner-text([node-set]) is the text of the context node, or of
+//	the first node, without that of its script and style elements.
+//	html:attr-or(name, default) is the value of the attribute name of
+//	the context node, or default if it has none.
+//
+// The names of elements and attributes are compared without case.
+const HTMLFunctions = "https://github.com/antchfx/xpath/html"
+
+// htmlFunctions are the functions of HTMLFunctions, by name.
+var htmlFunctions = map[string]extensionFunction{
+	"has-class": {signature{1, 2, [][]staticType{anyArg}, boolType}, func(args []query) query {
+		if len(args) == 1 {
+			return &functionQuery{Func: hasClassFunc(nil, args[0])}
+		}
+		return &functionQuery{Func: hasClassFunc(args[0], args[1])}
+	}},
+	"inner-text": {signature{0, 1, [][]staticType{nodeSetArg}, stringType}, func(args []query) query {
+		return &functionQuery{Func: innerTextFunc(optionalArg(args, 0))}
+	}},
+	"attr-or": {signature{2, 2, [][]staticType{anyArg}, stringType}, func(args []query) query {
+		return &functionQuery{Func: attrOrFunc(args[0], args[1])}
+	}},
+}
+
+// htmlNodes returns the nodes of the argument q of the function name, or
+// the context node if q is nil.
+func htmlNodes(t iterator, q query, name string) []NodeNavigator {
+	if q == nil {
+		return []NodeNavigator{t.Current().Copy()}
+	}
+	v, ok := functionArgs(q).Evaluate(t).(query)
+	if !ok {
+		panic(newError(ErrTypeMismatch, nil, "xpath: the first argument of %s() must be a node-set", name))
+	}
+	var list []NodeNavigator
+	for node := v.Select(t); node != nil; node = v.Select(t) {
+		list = append(list, node.Copy())
+	}
+	return list
+}
+
+// htmlAttr returns the value of the attribute name of the element n, which
+// is not moved.
+func htmlAttr(n NodeNavigator, name string) (string, bool) {
+	if n.NodeType() != ElementNode {
+		return "", false
+	}
+	attr := n.Copy()
+	for attr.MoveToNextAttribute() {
+		if strings.EqualFold(attr.LocalName(), name) {
+			return attr.Value(), true
+		}
+	}
+	return "", false
+}
+
+// hasClassFunc is html:has-class([node-set,] class).
+func hasClassFunc(arg1, arg2 query) func(query, iterator) interface{} {
+	return func(_ query, t iterator) interface{} {
+		class := asString(t, functionArgs(arg2).Evaluate(t))
+		for _, n := range htmlNodes(t, arg1, "html:has-class") {
+			classes, _ := htmlAttr(n, "class")
+			for _, c := range strings.Fields(classes) {
+				if c == class {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// innerTextFunc is html:inner-text([node-set]).
+func innerTextFunc(arg query) func(query, iterator) interface{} {
+	return func(_ query, t iterator) interface{} {
+		nodes := htmlNodes(t, arg, "html:inner-text")
+		if len(nodes) == 0 {
+			return ""
+		}
+		n := nodes[0]
+		if n.NodeType() == AttributeNode {
+			return nodeValue(t, n)
+		}
+		var b strings.Builder
+		writeInnerText(&b, n)
+		return limitString(t, b.String())
+	}
+}
+
+// writeInnerText writes the text of n, but for that of its script and
+// style elements, to b. n is moved back to where it is.
+func writeInnerText(b *strings.Builder, n NodeNavigator) {
+	switch n.NodeType() {
+	case TextNode:
+		b.WriteString(n.Value())
+	case ElementNode, RootNode:
+		if n.NodeType() == ElementNode && (strings.EqualFold(n.LocalName(), "script") || strings.EqualFold(n.LocalName(), "style")) {
+			return
+		}
+		if !n.MoveToChild() {
+			return
+		}
+		for {
+			writeInnerText(b, n)
+			if !n.MoveToNext() {
+				break
+			}
+		}
+		n.MoveToParent()
+	}
+}
+
+// attrOrFunc is html:attr-or(name, default).
+func attrOrFunc(arg1, arg2 query) func(query, iterator) interface{} {
+	return func(_ query, t iterator) interface{} {
+		if v, ok := htmlAttr(t.Current(), asString(t, functionArgs(arg1).Evaluate(t))); ok {
+			return limitString(t, v)
+		}
+		return asString(t, functionArgs(arg2).Evaluate(t))
+	}
+}
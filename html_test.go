@@ -0,0 +1,47 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHTMLFunctions(t *testing.T) {
+	doc := parseXML(`<html><body><div class=" item  first" id="d1">One <script>var x;</script><b>two</b><STYLE>b {}</STYLE><!--c--></div>` +
+		`<div class="items"><a href="/x">x</a><a>y</a></div></body></html>`)
+	ns := map[string]string{"html": HTMLFunctions}
+	for _, tc := range []struct {
+		expr string
+		want interface{}
+	}{
+		{`count(//div[html:has-class('item')])`, float64(1)},
+		{`count(//div[html:has-class('first')])`, float64(1)},
+		{`count(//div[html:has-class('ite')])`, float64(0)},
+		{`html:has-class(//div, 'items')`, true},
+		{`html:has-class(//a, 'items')`, false},
+		{`html:inner-text(//div)`, "One two"},
+		{`html:inner-text(/)`, "One twoxy"},
+		{`html:inner-text(//div/@id)`, "d1"},
+		{`html:inner-text(/nothing)`, ""},
+		{`string(//div[1][html:inner-text() = 'One two']/@id)`, "d1"},
+		{`string(//a[html:attr-or('href', '#') = '#'])`, "y"},
+		{`string(//a[html:attr-or('HREF', '') = '/x'])`, "x"},
+	} {
+		exp, err := CompileWithNS(tc.expr, ns)
+		if err != nil {
+			t.Errorf("%s: %v", tc.expr, err)
+			continue
+		}
+		if got := exp.Evaluate(createNavigator(doc)); got != tc.want {
+			t.Errorf("%s = %#v, want %#v", tc.expr, got, tc.want)
+		}
+	}
+
+	// The functions are only those of a prefix bound to the namespace.
+	_, err := Compile(`html:inner-text()`)
+	var uerr *UnknownFunctionError
+	assertTrue(t, errors.As(err, &uerr))
+	_, err = CompileWithNS(`html:inner-text('a')`, ns)
+	assertTrue(t, errors.Is(err, ErrTypeMismatch))
+	_, err = CompileWithNS(`html:has-class()`, ns)
+	assertTrue(t, errors.Is(err, ErrArgumentCount))
+}
@@ -0,0 +1,451 @@
+package xpath
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// CompileJSONPath compiles a JSONPath, such as
+// "$.store.book[?(@.price < 10)].title", to the expression that selects
+// the elements of the values it matches in a document of dom.ParseJSON,
+// from its document node, and whose String is that expression. The path
+// has the member names after a dot or quoted in brackets, the wildcards
+// .* and [*], the descendants .., the array indexes, the slices
+// [start:end:step] with a positive step, the unions of names or indexes
+// separated by commas, and the filters [?(...)]. The index i of an item
+// is its position() i+1 among the elements of the array, and a negative
+// one counts from last(), -1 being last(); the indexes select the members
+// of an object value in their order too. The filters have the operators
+// ||, &&, !, ==, !=, <, <=, > and >= on the paths from @ or $, the
+// numbers, the strings, true and false: == and != compare the text of
+// the values, the others the first values of the paths as numbers. The
+// script expressions, =~ and null are not supported, and are reported as
+// a *SyntaxError with the path as Expr, as the paths that are not valid.
+func CompileJSONPath(path string) (*Expr, error) {
+	expr, err := jsonPathToXPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return compile(expr, CompileOptions{})
+}
+
+// jsonPathToXPath returns the expression of the path for CompileJSONPath.
+func jsonPathToXPath(path string) (expr string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			serr, ok := e.(*SyntaxError)
+			if !ok {
+				panic(e)
+			}
+			err = serr
+		}
+	}()
+	p := &jsonPathParser{s: path}
+	p.skipSpace()
+	expr = p.path()
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		p.fail("", "'.'", "'['")
+	}
+	return expr, nil
+}
+
+// A jsonPathParser reads a JSONPath. Its methods panic with a
+// *SyntaxError.
+type jsonPathParser struct {
+	s   string
+	pos int
+}
+
+// fail panics with the syntax error of the character at the position.
+func (p *jsonPathParser) fail(msg string, expected ...string) {
+	token := ""
+	if p.pos < len(p.s) {
+		_, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		token = p.s[p.pos : p.pos+size]
+	}
+	panic(&SyntaxError{Expr: p.s, Offset: p.pos, Token: token, Expected: expected, Msg: msg})
+}
+
+// unsupported panics with the syntax error of the token that starts at
+// start and ends at the position.
+func (p *jsonPathParser) unsupported(start int, what string) {
+	panic(&SyntaxError{Expr: p.s, Offset: start, Token: p.s[start:p.pos], Msg: "unsupported " + what})
+}
+
+func (p *jsonPathParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *jsonPathParser) skipSpace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// consume reads s if it is next.
+func (p *jsonPathParser) consume(s string) bool {
+	if strings.HasPrefix(p.s[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *jsonPathParser) expect(s string) {
+	if !p.consume(s) {
+		p.fail("", strconv.Quote(s))
+	}
+}
+
+// path reads a path from $ or, in a filter, from @, and returns its
+// expression.
+func (p *jsonPathParser) path() string {
+	var expr string
+	switch {
+	case p.consume("$"):
+		expr = ""
+	case p.consume("@"):
+		expr = "."
+	default:
+		p.fail("", "'$'")
+	}
+	for {
+		switch {
+		case p.consume(".."):
+			switch {
+			case p.consume("*"):
+				expr += "/descendant::*"
+			case p.peek() == '[':
+				expr += "/descendant-or-self::node()/" + p.bracket()
+			default:
+				expr += "/descendant::" + jsonNameTest(p.name())
+			}
+		case p.consume("."):
+			if p.consume("*") {
+				expr += "/*"
+			} else {
+				expr += "/" + jsonNameTest(p.name())
+			}
+		case p.peek() == '[':
+			expr += "/" + p.bracket()
+		default:
+			switch {
+			case expr == "":
+				return "/"
+			case expr == ".":
+				return expr
+			}
+			return strings.TrimPrefix(expr, "./")
+		}
+	}
+}
+
+// jsonName matches the names that are name tests as they are.
+var jsonName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// jsonNameTest returns the step of the elements of the members named
+// name.
+func jsonNameTest(name string) string {
+	if jsonName.MatchString(name) {
+		return name
+	}
+	return "*[name() = " + Quote(name) + "]"
+}
+
+// name reads a member name after a dot.
+func (p *jsonPathParser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		r, size := utf8.DecodeRuneInString(p.s[p.pos:])
+		if r != '_' && r != '-' && r < 0x80 && !('0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') {
+			break
+		}
+		p.pos += size
+	}
+	if p.pos == start {
+		p.fail("", "name", "'*'")
+	}
+	return p.s[start:p.pos]
+}
+
+// bracket reads the selectors of a [...], and returns their step.
+func (p *jsonPathParser) bracket() string {
+	p.expect("[")
+	p.skipSpace()
+	if p.consume("*") {
+		p.skipSpace()
+		p.expect("]")
+		return "*"
+	}
+	if p.consume("?") {
+		p.skipSpace()
+		p.expect("(")
+		cond := p.or()
+		p.skipSpace()
+		p.expect(")")
+		p.skipSpace()
+		p.expect("]")
+		return "*[" + cond + "]"
+	}
+	if p.peek() == '(' {
+		start := p.pos
+		p.pos++
+		p.unsupported(start, "script expression")
+	}
+	var conds []string
+	var names []string
+	for {
+		p.skipSpace()
+		if c := p.peek(); c == '\'' || c == '"' {
+			name := p.stringLiteral()
+			names = append(names, name)
+			conds = append(conds, "name() = "+Quote(name))
+		} else {
+			conds = append(conds, p.indexOrSlice())
+		}
+		p.skipSpace()
+		if p.consume("]") {
+			break
+		}
+		p.expect(",")
+	}
+	switch {
+	case len(conds) > 1:
+		return "*[" + strings.Join(conds, " or ") + "]"
+	case len(names) == 1:
+		return jsonNameTest(names[0])
+	case strings.HasPrefix(conds[0], "position() = "):
+		return "*[" + strings.TrimPrefix(conds[0], "position() = ") + "]"
+	}
+	return "*[" + conds[0] + "]"
+}
+
+// indexOrSlice reads an index or a slice, and returns the condition of
+// the positions it selects.
+func (p *jsonPathParser) indexOrSlice() string {
+	start := p.pos
+	var bounds []string
+	for {
+		p.skipSpace()
+		i := p.pos
+		if p.peek() == '-' || p.peek() == '+' {
+			p.pos++
+		}
+		for p.pos < len(p.s) && '0' <= p.s[p.pos] && p.s[p.pos] <= '9' {
+			p.pos++
+		}
+		bounds = append(bounds, p.s[i:p.pos])
+		p.skipSpace()
+		if len(bounds) == 3 || !p.consume(":") {
+			break
+		}
+	}
+	ints := make([]int, len(bounds))
+	for i, b := range bounds {
+		if b == "" && (len(bounds) > 1) {
+			continue
+		}
+		n, err := strconv.Atoi(b)
+		if err != nil {
+			p.pos = start
+			p.fail("", "index", "name")
+		}
+		ints[i] = n
+	}
+	// position(), from 1, of the index i from 0, or from the end if
+	// negative.
+	position := func(i int) string {
+		switch {
+		case i >= 0:
+			return strconv.Itoa(i + 1)
+		case i == -1:
+			return "last()"
+		}
+		return "last() - " + strconv.Itoa(-i-1)
+	}
+	if len(bounds) == 1 {
+		return "position() = " + position(ints[0])
+	}
+	var conds []string
+	if bounds[0] != "" && ints[0] != 0 {
+		conds = append(conds, "position() >= "+position(ints[0]))
+	}
+	if bounds[1] != "" {
+		conds = append(conds, "position() < "+position(ints[1]))
+	}
+	if len(bounds) == 3 && bounds[2] != "" && ints[2] != 1 {
+		if ints[2] < 1 {
+			p.unsupported(start, "slice step")
+		}
+		first := "1"
+		if bounds[0] != "" {
+			first = position(ints[0])
+		}
+		if strings.Contains(first, " ") {
+			first = "(" + first + ")"
+		}
+		conds = append(conds, "(position() - "+first+") mod "+strconv.Itoa(ints[2])+" = 0")
+	}
+	if len(conds) == 0 {
+		return "true()"
+	}
+	return "(" + strings.Join(conds, " and ") + ")"
+}
+
+// stringLiteral reads a quoted string, with the escapes of JSON strings.
+func (p *jsonPathParser) stringLiteral() string {
+	quote := p.s[p.pos]
+	start := p.pos
+	p.pos++
+	var b strings.Builder
+	for {
+		if p.pos == len(p.s) {
+			p.pos = start
+			p.fail("unclosed string")
+		}
+		c := p.s[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String()
+		case c == '\\' && p.pos+1 < len(p.s):
+			e := p.s[p.pos+1]
+			if e == 'u' {
+				if p.pos+6 <= len(p.s) {
+					if v, err := strconv.ParseUint(p.s[p.pos+2:p.pos+6], 16, 16); err == nil {
+						b.WriteRune(rune(v))
+						p.pos += 6
+						continue
+					}
+				}
+				p.fail("invalid escape")
+			}
+			if i := strings.IndexByte(`'"\/bfnrt`, e); i >= 0 {
+				b.WriteByte("'\"\\/\b\f\n\r\t"[i])
+				p.pos += 2
+				continue
+			}
+			p.fail("invalid escape")
+		default:
+			b.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// or reads the || of the ands of a filter.
+func (p *jsonPathParser) or() string {
+	expr := p.and()
+	for {
+		p.skipSpace()
+		if !p.consume("||") {
+			return expr
+		}
+		expr += " or " + p.and()
+	}
+}
+
+func (p *jsonPathParser) and() string {
+	expr := p.unary()
+	for {
+		p.skipSpace()
+		if !p.consume("&&") {
+			return expr
+		}
+		expr += " and " + p.unary()
+	}
+}
+
+func (p *jsonPathParser) unary() string {
+	p.skipSpace()
+	if p.peek() == '!' && !strings.HasPrefix(p.s[p.pos:], "!=") {
+		p.pos++
+		return "not(" + p.unary() + ")"
+	}
+	return p.comparison()
+}
+
+// jsonComparisons are the comparison operators and their XPath ones,
+// longest first.
+var jsonComparisons = [][2]string{{"==", "="}, {"!=", "!="}, {"<=", "<="}, {">=", ">="}, {"<", "<"}, {">", ">"}}
+
+func (p *jsonPathParser) comparison() string {
+	left := p.operand()
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], "=~") {
+		start := p.pos
+		p.pos += 2
+		p.unsupported(start, "operator")
+	}
+	for _, op := range jsonComparisons {
+		if p.consume(op[0]) {
+			right := p.operand()
+			if op[1] != "=" && op[1] != "!=" {
+				// The values of the paths are compared as numbers, as
+				// the engine compares two node-sets as strings.
+				left, right = jsonNumber(left), jsonNumber(right)
+			}
+			return left + " " + op[1] + " " + right
+		}
+	}
+	return left
+}
+
+// jsonNumber returns the number of the first value of the path expr, or
+// expr if it is not a path.
+func jsonNumber(expr string) string {
+	switch c := expr[0]; {
+	case c == '\'' || c == '"' || c == '(' || c == '-' || '0' <= c && c <= '9':
+		return expr
+	}
+	return "number(" + expr + ")"
+}
+
+// operand reads a path, a literal or a parenthesized filter.
+func (p *jsonPathParser) operand() string {
+	p.skipSpace()
+	switch c := p.peek(); {
+	case c == '@' || c == '$':
+		return p.path()
+	case c == '\'' || c == '"':
+		return Quote(p.stringLiteral())
+	case c == '(':
+		p.pos++
+		expr := p.or()
+		p.skipSpace()
+		p.expect(")")
+		return "(" + expr + ")"
+	case c == '-' || '0' <= c && c <= '9':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.s) && strings.IndexByte("0123456789.eE+-", p.s[p.pos]) >= 0 {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+		if err != nil {
+			p.pos = start
+			p.fail("", "number")
+		}
+		return formatNumber(v)
+	}
+	start := p.pos
+	for p.pos < len(p.s) && 'a' <= p.s[p.pos] && p.s[p.pos] <= 'z' {
+		p.pos++
+	}
+	switch word := p.s[start:p.pos]; word {
+	case "true", "false":
+		// The text of the values.
+		return "'" + word + "'"
+	case "null":
+		p.unsupported(start, "null")
+	}
+	p.pos = start
+	p.fail("", "path", "literal")
+	return ""
+}
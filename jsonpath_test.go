@@ -0,0 +1,106 @@
+package xpath_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+const storeJSON = `{"store": {
+  "book": [
+    {"category": "reference", "author": "Nigel Rees", "title": "Sayings of the Century", "price": 8.95},
+    {"category": "fiction", "author": "Evelyn Waugh", "title": "Sword of Honour", "price": 12.99},
+    {"category": "fiction", "author": "Herman Melville", "title": "Moby Dick", "isbn": "0-553-21311-3", "price": 8.99},
+    {"category": "fiction", "author": "J. R. R. Tolkien", "title": "The Lord of the Rings", "isbn": "0-395-19395-8", "price": 22.99}
+  ],
+  "bicycle": {"color": "red", "price": 19.95, "in stock": true}
+}, "expensive": 10}`
+
+func TestCompileJSONPath(t *testing.T) {
+	doc, err := dom.ParseJSON(strings.NewReader(storeJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		path, values string
+	}{
+		{`$.store.book[*].author`, "Nigel Rees|Evelyn Waugh|Herman Melville|J. R. R. Tolkien"},
+		{`$..author`, "Nigel Rees|Evelyn Waugh|Herman Melville|J. R. R. Tolkien"},
+		{`$.store.*.color`, "red"},
+		{`$.store..price`, "8.95|12.99|8.99|22.99|19.95"},
+		{`$..book[2].title`, "Moby Dick"},
+		{`$..book[-1].title`, "The Lord of the Rings"},
+		{`$..book[-2:].title`, "Moby Dick|The Lord of the Rings"},
+		{`$..book[:2].title`, "Sayings of the Century|Sword of Honour"},
+		{`$..book[0,3].title`, "Sayings of the Century|The Lord of the Rings"},
+		{`$..book[::2].title`, "Sayings of the Century|Moby Dick"},
+		{`$..book[1:4:2].title`, "Sword of Honour|The Lord of the Rings"},
+		{`$..book[?(@.isbn)].title`, "Moby Dick|The Lord of the Rings"},
+		{`$..book[?(@.price < $.expensive)].title`, "Sayings of the Century|Moby Dick"},
+		{`$..book[?(@.category == 'fiction' && !(@.price > 20))].title`, "Sword of Honour|Moby Dick"},
+		{`$..book[?(@.author == "Nigel Rees" || @.price >= 22.99)].price`, "8.95|22.99"},
+		{`$.store.bicycle['in stock']`, "true"},
+		{`$.store.bicycle[?(@['in stock'] == true)].color`, ""},
+		{`$.store[?(@['in stock'] == true)].color`, "red"},
+		{`$['store']['bicycle','expensive']`, "red19.95true"},
+		{`$.expensive`, "10"},
+		{`$..[0].category`, "reference"},
+	} {
+		exp, err := xpath.CompileJSONPath(tc.path)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		nodes, err := dom.Select(doc, exp)
+		if err != nil {
+			t.Errorf("%s: %v", tc.path, err)
+			continue
+		}
+		var values []string
+		for _, n := range nodes {
+			values = append(values, n.InnerText())
+		}
+		if got := strings.Join(values, "|"); got != tc.values {
+			t.Errorf("%s = %s, want %s (%s)", tc.path, got, tc.values, exp)
+		}
+	}
+
+	exp, err := xpath.CompileJSONPath(`$.store.book[0]['the title']`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := exp.String(), `/store/book/*[1]/*[name() = 'the title']`; got != want {
+		t.Errorf("String() = %s, want %s", got, want)
+	}
+
+	for _, tc := range []struct {
+		path   string
+		offset int
+		token  string
+	}{
+		{``, 0, ""},
+		{`store`, 0, "s"},
+		{`$.`, 2, ""},
+		{`$.a[`, 4, ""},
+		{`$.a[x]`, 4, "x"},
+		{`$.a['b]`, 4, "'"},
+		{`$.a[(@.length-1)]`, 4, "("},
+		{`$.a[?(@.b =~ /x/)]`, 10, "=~"},
+		{`$.a[?(@.b == null)]`, 13, "null"},
+		{`$.a[::-1]`, 4, "::-1"},
+		{`$.a b`, 4, "b"},
+	} {
+		_, err := xpath.CompileJSONPath(tc.path)
+		var syntaxErr *xpath.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: error %v, want a *SyntaxError", tc.path, err)
+			continue
+		}
+		if syntaxErr.Expr != tc.path || syntaxErr.Offset != tc.offset || syntaxErr.Token != tc.token {
+			t.Errorf("%s: error at %d %q, want at %d %q", tc.path, syntaxErr.Offset, syntaxErr.Token, tc.offset, tc.token)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package xpath
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A LintIssue is an idiom found by Lint that often does not mean what it
+// seems to.
+type LintIssue struct {
+	// Offset is the byte offset of the idiom in the expression.
+	Offset int
+	// Msg explains what the idiom means.
+	Msg string
+	// Rewrite is the expression rewritten to what was probably meant, or
+	// "" if there is no single rewrite.
+	Rewrite string
+}
+
+// Lint returns the idioms of expr whose meaning is often not the intended
+// one, in the order they appear in expr:
+//
+//	//x[1]         selects the first x of each parent, not the first x
+//	@a != 'v'      is false for nodes without an a attribute
+//	'it's'         ends the string at the apostrophe
+//	[//x]          searches the whole document, not the context node
+//
+// expr does not need to compile; Lint works on its tokens, so that it
+// also explains why an expression such as 'it's' does not compile. The
+// tokens after such a string are not linted.
+func Lint(expr string) []LintIssue {
+	toks := lintTokens(expr)
+	var issues []LintIssue
+	add := func(offset int, rewrite, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{Offset: offset, Msg: fmt.Sprintf(format, args...), Rewrite: rewrite})
+	}
+	text := func(i, j int) string {
+		return strings.TrimSpace(expr[toks[i].start:toks[j].end])
+	}
+	depth := 0
+Tokens:
+	for i, t := range toks {
+		next := func(k int, typ itemType) bool {
+			return i+k < len(toks) && toks[i+k].typ == typ
+		}
+		switch t.typ {
+		case itemLBracket:
+			depth++
+		case itemRBracket:
+			depth--
+		case itemString:
+			// 'it's' is scanned as the string 'it', the name s and an
+			// unclosed string.
+			if !next(1, itemName) || toks[i+1].start != t.end {
+				break
+			}
+			// The string is taken to end at the first quote after which
+			// the expression compiles.
+			q, rewrite := expr[t.start], ""
+			for k := t.end; k < len(expr); k++ {
+				if expr[k] != q {
+					continue
+				}
+				s := expr[:t.start] + Quote(expr[t.start+1:k]) + expr[k+1:]
+				if _, err := compile(s, CompileOptions{}); err == nil {
+					rewrite = s
+					break
+				}
+			}
+			add(t.start, rewrite, "the string %s ends at the %c before %s; a string cannot contain its own quote", expr[t.start:t.end], q, toks[i+1].text)
+			// The items after it are not the ones meant.
+			break Tokens
+		case itemSlashSlash:
+			// //x[1]
+			if startsOperand(toks, i) && (next(1, itemName) || next(1, itemStar)) && next(2, itemLBracket) &&
+				(next(3, itemNumber) || next(3, itemName) && toks[i+3].text == "last") {
+				step, pos := text(i, i+1), toks[i+3].text
+				if toks[i+3].typ == itemName {
+					pos = "last()"
+				}
+				add(t.start, expr[:t.start]+"("+step+")"+expr[toks[i+2].start:],
+					"%s[%s] selects the %s of each parent, not of the document; (%[1]s)[%[2]s] selects the %[3]s of the document",
+					step, pos, positionName(toks[i+1], toks[i+3]))
+				break
+			}
+			// [//x]
+			if depth > 0 && startsOperand(toks, i) {
+				add(t.start, expr[:t.start]+"."+expr[t.start:],
+					"// in a predicate searches the whole document, not the descendants of the node being tested; .// searches them")
+			}
+		case itemNe:
+			// @a != 'v'
+			if i < 2 || toks[i-2].typ != itemAt || toks[i-1].typ != itemName || !startsOperand(toks, i-2) ||
+				!next(1, itemString) && !next(1, itemNumber) {
+				break
+			}
+			attr, v := text(i-2, i-1), text(i+1, i+1)
+			add(toks[i-2].start, expr[:toks[i-2].start]+"not("+attr+" = "+v+")"+expr[toks[i+1].end:],
+				"%s != %s is false for nodes without the attribute; not(%[1]s = %[2]s) is true for them", attr, v)
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Offset < issues[j].Offset })
+	return issues
+}
+
+// lintToken is an item of an expression, for Lint.
+type lintToken struct {
+	typ        itemType
+	start, end int
+	text       string // the name, or the token for other items
+}
+
+// lintTokens returns the items of expr, up to the first one that cannot
+// be scanned.
+func lintTokens(expr string) (toks []lintToken) {
+	defer func() {
+		recover()
+	}()
+	s := &scanner{text: expr}
+	s.nextChar()
+	for s.nextItem() {
+		tok := s.token()
+		t := lintToken{typ: s.typ, start: s.start, end: s.start + len(tok), text: tok}
+		if s.typ == itemName {
+			t.text = s.name
+		}
+		toks = append(toks, t)
+	}
+	return toks
+}
+
+// startsOperand reports whether toks[i] is at the start of an operand,
+// rather than after a step or a value.
+func startsOperand(toks []lintToken, i int) bool {
+	return i == 0 || !endsOperand(toks, i-1)
+}
+
+// endsOperand reports whether an operand can end with toks[i], so that the
+// next item is an operator or a step.
+func endsOperand(toks []lintToken, i int) bool {
+	switch t := toks[i]; t.typ {
+	case itemRBracket, itemRParens, itemString, itemNumber, itemDot, itemDotDot:
+		return true
+	case itemStar:
+		// * is a name test at the start of an operand, else it is the
+		// multiplication.
+		return startsOperand(toks, i)
+	case itemName:
+		switch t.text {
+		case "and", "or", "div", "mod":
+			return startsOperand(toks, i)
+		}
+		return true
+	}
+	return false
+}
+
+// positionName returns what the position predicate pos selects of the
+// nodes matching test, for Lint.
+func positionName(test, pos lintToken) string {
+	name := test.text
+	if test.typ == itemStar {
+		name = "element"
+	}
+	switch {
+	case pos.typ == itemName:
+		return "last " + name
+	case pos.text == "1":
+		return "first " + name
+	}
+	return name + " at position " + pos.text
+}
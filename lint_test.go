@@ -0,0 +1,39 @@
+package xpath
+
+import "testing"
+
+func TestLint(t *testing.T) {
+	for _, tc := range []struct {
+		expr    string
+		offset  int
+		msg     string
+		rewrite string
+	}{
+		{`//book[1]`, 0, "//book[1] selects the first book of each parent, not of the document; (//book)[1] selects the first book of the document", `(//book)[1]`},
+		{`//*[last()]/title`, 0, "//*[last()] selects the last element of each parent, not of the document; (//*)[last()] selects the last element of the document", `(//*)[last()]/title`},
+		{`//book[@lang != 'en']`, 7, "@lang != 'en' is false for nodes without the attribute; not(@lang = 'en') is true for them", `//book[not(@lang = 'en')]`},
+		{`//book[@title='it's']`, 14, "the string 'it' ends at the ' before s; a string cannot contain its own quote", `//book[@title="it's"]`},
+		{`//a[@t="say "hi" now"]`, 7, `the string "say " ends at the " before hi; a string cannot contain its own quote`, `//a[@t='say "hi" now']`},
+		{`//a[@t='it's and "x"' and @u='v']`, 7, "the string 'it' ends at the ' before s; a string cannot contain its own quote", `//a[@t=concat('it', "'", 's and "x"') and @u='v']`},
+		{`//a[@t='it's`, 7, "the string 'it' ends at the ' before s; a string cannot contain its own quote", ""},
+		{`//book[//author]`, 7, "// in a predicate searches the whole document, not the descendants of the node being tested; .// searches them", `//book[.//author]`},
+	} {
+		issues := Lint(tc.expr)
+		if len(issues) != 1 {
+			t.Errorf("%s: expected one issue, got %v", tc.expr, issues)
+			continue
+		}
+		assertEqual(t, tc.offset, issues[0].Offset)
+		assertEqual(t, tc.msg, issues[0].Msg)
+		assertEqual(t, tc.rewrite, issues[0].Rewrite)
+	}
+	for _, expr := range []string{`(//book)[1]`, `/bookstore//book[1]`, `//book[.//author]`, `//book[@a != @b]`, `//book[not(@lang = 'en')]`, `//book[price * 2 > 10]`, `//book[title = "it's"]`} {
+		if issues := Lint(expr); len(issues) != 0 {
+			t.Errorf("%s: expected no issue, got %v", expr, issues)
+		}
+	}
+	issues := Lint(`//book[1][@lang != 'en']`)
+	assertEqual(t, 2, len(issues))
+	assertEqual(t, 0, issues[0].Offset)
+	assertEqual(t, 10, issues[1].Offset)
+}
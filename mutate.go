@@ -0,0 +1,157 @@
+package xpath
+
+import (
+	"errors"
+	"strings"
+)
+
+// A Mutation is an expression derived from another by Mutations.
+type Mutation struct {
+	Expr string
+	// Kind names the change: "expand" for the abbreviations written in
+	// full, "commute" for the operands of a commutative operator swapped,
+	// "swap-predicates" for two predicates of a step swapped, and
+	// "position+1" or "position-1" for a position predicate changed.
+	Kind string
+	// Preserving reports whether the mutation keeps the value of the
+	// expression on every document. The others may change it.
+	Preserving bool
+}
+
+// Mutations returns the mutations of expr, as Format prints them,
+// for testing the engine against an oracle: the value of a preserving
+// mutation is that of expr, and the value of the others changes on a
+// document for the engine exactly when it does for the oracle. If expr
+// is not valid XPath, the error is a *SyntaxError.
+func Mutations(expr string) (list []Mutation, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch x := e.(type) {
+			case string:
+				err = errors.New(x)
+			case error:
+				err = x
+			default:
+				err = errors.New("unknown panic")
+			}
+		}
+	}()
+	if expr == "" {
+		return nil, newError(ErrSyntax, nil, "expr expression is nil")
+	}
+	root := parse(expr, nil, nil)
+	s := formatNode(root)
+	seen := map[string]bool{s: true}
+	var b strings.Builder
+	f := formatter{b: &b, expand: true}
+	f.format(root)
+	if e := b.String(); !seen[e] {
+		seen[e] = true
+		list = append(list, Mutation{Expr: e, Kind: "expand", Preserving: true})
+	}
+	for _, m := range mutations(root) {
+		s := formatNode(m.n)
+		if !seen[s] && isValid(s) {
+			seen[s] = true
+			list = append(list, Mutation{Expr: s, Kind: m.kind, Preserving: m.preserving})
+		}
+	}
+	return list, nil
+}
+
+// A mutant is a tree returned by mutations, and its mutation.
+type mutant struct {
+	n          node
+	kind       string
+	preserving bool
+}
+
+// commutative are the operators whose operands can be swapped without
+// changing the value.
+var commutative = map[string]bool{"and": true, "or": true, "=": true, "!=": true, "+": true, "*": true, "|": true}
+
+// mutations returns the trees one mutation away from n. They share the
+// subtrees of n that are not changed.
+func mutations(n node) []mutant {
+	var list []mutant
+	switch n := n.(type) {
+	case *operatorNode:
+		if isNegation(n) {
+			for _, m := range mutations(n.Left) {
+				c := *n
+				c.Left = m.n
+				list = append(list, mutant{&c, m.kind, m.preserving})
+			}
+			break
+		}
+		if commutative[n.Op] {
+			c := *n
+			c.Left, c.Right = n.Right, n.Left
+			list = append(list, mutant{&c, "commute", true})
+		}
+		for _, m := range mutations(n.Left) {
+			c := *n
+			c.Left = m.n
+			list = append(list, mutant{&c, m.kind, m.preserving})
+		}
+		for _, m := range mutations(n.Right) {
+			c := *n
+			c.Right = m.n
+			list = append(list, mutant{&c, m.kind, m.preserving})
+		}
+	case *axisNode:
+		if n.Input != nil {
+			for _, m := range mutations(n.Input) {
+				c := *n
+				c.Input = m.n
+				list = append(list, mutant{&c, m.kind, m.preserving})
+			}
+		}
+	case *filterNode:
+		if v, ok := n.Condition.(*operandNode); ok {
+			if pos, ok := v.Val.(float64); ok {
+				for _, d := range []float64{1, -1} {
+					c := *n
+					c.Condition = newOperandNode(pos + d)
+					kind := "position+1"
+					if d < 0 {
+						kind = "position-1"
+					}
+					list = append(list, mutant{&c, kind, false})
+				}
+			}
+		}
+		if f, ok := n.Input.(*filterNode); ok {
+			// a[p][q] is a[q][p] if neither depends on the position.
+			inner := *f
+			inner.Condition = n.Condition
+			c := *n
+			c.Input, c.Condition = &inner, f.Condition
+			list = append(list, mutant{&c, "swap-predicates", !positionalPredicate(n.Condition) && !positionalPredicate(f.Condition)})
+		}
+		for _, m := range mutations(n.Input) {
+			c := *n
+			c.Input = m.n
+			list = append(list, mutant{&c, m.kind, m.preserving})
+		}
+		for _, m := range mutations(n.Condition) {
+			c := *n
+			c.Condition = m.n
+			list = append(list, mutant{&c, m.kind, m.preserving})
+		}
+	case *groupNode:
+		for _, m := range mutations(n.Input) {
+			list = append(list, mutant{newGroupNode(m.n), m.kind, m.preserving})
+		}
+	case *functionNode:
+		for i, arg := range n.Args {
+			for _, m := range mutations(arg) {
+				c := *n
+				c.Args = append([]node(nil), n.Args...)
+				c.Args[i] = m.n
+				list = append(list, mutant{&c, m.kind, m.preserving})
+			}
+		}
+	}
+	return list
+}
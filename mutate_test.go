@@ -0,0 +1,48 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMutations(t *testing.T) {
+	for _, tc := range []struct {
+		expr      string
+		mutations []Mutation
+	}{
+		{`//a[2]`, []Mutation{
+			{`/descendant-or-self::node()/child::a[2]`, "expand", true},
+			{`//a[3]`, "position+1", false},
+			{`//a[1]`, "position-1", false},
+		}},
+		{`a[@x][b = 1]`, []Mutation{
+			{`child::a[attribute::x][child::b = 1]`, "expand", true},
+			{`a[b = 1][@x]`, "swap-predicates", true},
+			{`a[@x][1 = b]`, "commute", true},
+		}},
+		{`a[last()][1]`, []Mutation{
+			{`child::a[last()][1]`, "expand", true},
+			{`a[last()][2]`, "position+1", false},
+			{`a[last()][0]`, "position-1", false},
+			{`a[1][last()]`, "swap-predicates", false},
+		}},
+		{`-count(a | b)`, []Mutation{
+			{`-count(child::a | child::b)`, "expand", true},
+			{`-count(b | a)`, "commute", true},
+		}},
+		{`1 - 2`, nil},
+	} {
+		list, err := Mutations(tc.expr)
+		assertNoErr(t, err)
+		assertEqual(t, len(tc.mutations), len(list))
+		for i := range list {
+			if i < len(tc.mutations) {
+				assertEqual(t, tc.mutations[i], list[i])
+			}
+		}
+	}
+
+	_, err := Mutations(`//a[`)
+	var syntaxErr *SyntaxError
+	assertTrue(t, errors.As(err, &syntaxErr))
+}
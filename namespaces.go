@@ -0,0 +1,73 @@
+package xpath
+
+import "strconv"
+
+// xmlNamespace is the namespace of the xml prefix, bound in every
+// document.
+const xmlNamespace = "http://www.w3.org/XML/1998/namespace"
+
+// DiscoverNamespaces returns the prefixes of the namespaces of the
+// elements and attributes of the subtree of nav, if the navigator has a
+// NamespaceURL method, and of its namespace
+// declarations, for CompileWithNS or CompileOptions.Namespaces. Each
+// namespace has the prefix it is first declared or used with, in document
+// order. The default namespace, whose elements have no prefix in the
+// document, and the namespaces whose prefix is taken by another, get a
+// generated one, ns1, ns2 and so on, since a name without a prefix is in
+// no namespace in XPath. The xml namespace is left out.
+func DiscoverNamespaces(nav NodeNavigator) map[string]string {
+	d := namespaceDiscovery{prefixes: make(map[string]string), uris: make(map[string]string)}
+	n := nav.Copy()
+	for depth := 0; ; {
+		if n.NodeType() == ElementNode {
+			d.add(n.Prefix(), navNamespaceURL(n))
+			attr := n.Copy()
+			for attr.MoveToNextAttribute() {
+				switch prefix, name := attr.Prefix(), attr.LocalName(); {
+				case prefix == "xmlns":
+					d.add(name, attr.Value())
+				case prefix == "" && name == "xmlns":
+					d.add("", attr.Value())
+				default:
+					d.add(prefix, navNamespaceURL(attr))
+				}
+			}
+		}
+		if n.MoveToChild() {
+			depth++
+			continue
+		}
+		for depth > 0 && !n.MoveToNext() {
+			n.MoveToParent()
+			depth--
+		}
+		if depth == 0 {
+			return d.uris
+		}
+	}
+}
+
+// namespaceDiscovery is the state of DiscoverNamespaces.
+type namespaceDiscovery struct {
+	prefixes map[string]string // by namespace URI
+	uris     map[string]string // by prefix
+	next     int               // the number of the last generated prefix
+}
+
+// add binds a prefix to uri, if it has none, preferably prefix.
+func (d *namespaceDiscovery) add(prefix, uri string) {
+	if uri == "" || uri == xmlNamespace || d.prefixes[uri] != "" {
+		return
+	}
+	if _, taken := d.uris[prefix]; prefix == "" || taken || prefix == "xml" || prefix == "xmlns" {
+		for {
+			d.next++
+			prefix = "ns" + strconv.Itoa(d.next)
+			if _, taken := d.uris[prefix]; !taken {
+				break
+			}
+		}
+	}
+	d.prefixes[uri] = prefix
+	d.uris[prefix] = uri
+}
@@ -0,0 +1,25 @@
+package xpath
+
+import "testing"
+
+func TestDiscoverNamespaces(t *testing.T) {
+	doc := parseXML(`<feed xmlns="urn:atom" xmlns:m="urn:media">` +
+		`<entry xml:lang="en"><m:thumb m:url="u"/><x:a xmlns:x="urn:x1"/><x:b xmlns:x="urn:x2"/></entry></feed>`)
+	// The declarations of parseXML have no xmlns prefix, so those of the
+	// namespaces no node is in are added as they are by other navigators.
+	doc.FirstChild.addAttributeNS("xmlns", "unused", "", "urn:unused")
+	doc.FirstChild.FirstChild.addAttributeNS("xmlns", "ns1", "", "urn:n")
+	ns := DiscoverNamespaces(createNavigator(doc))
+	assertEqual(t, map[string]string{
+		"ns1":    "urn:atom",
+		"m":      "urn:media",
+		"unused": "urn:unused",
+		"x":      "urn:x1",
+		"ns2":    "urn:n",
+		"ns3":    "urn:x2",
+	}, ns)
+
+	expr, err := CompileWithNS(`count(/ns1:feed/ns1:entry/m:thumb[@m:url])`, ns)
+	assertNoErr(t, err)
+	assertEqual(t, float64(1), expr.Evaluate(createNavigator(doc)))
+}
@@ -0,0 +1,707 @@
+package xpath
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// The navigators in this file wrap another NodeNavigator to change which
+// nodes the engine can see, without modifying the backing document. They
+// can be composed, e.g. ElementsOnly(SubtreeOnly(nav)).
+//
+// Nodes returned by a NodeIterator over a wrapped navigator are wrappers
+// too; use their Unwrap method to get back the underlying navigator.
+
+// navNamespaceURL returns the namespace URI of the node nav is positioned on,
+// if the navigator provides it.
+func navNamespaceURL(nav NodeNavigator) string {
+	if ns, ok := nav.(interface{ NamespaceURL() string }); ok {
+		return ns.NamespaceURL()
+	}
+	return ""
+}
+
+// FilterNavigator returns a navigator that hides every child, sibling and
+// attribute for which keep returns false. The document root and the node
+// nav is positioned on are always visible. Values are not affected, so
+// the string value of an element still includes hidden text.
+func FilterNavigator(nav NodeNavigator, keep func(NodeNavigator) bool) NodeNavigator {
+	return &filterNavigator{NodeNavigator: nav, keep: keep}
+}
+
+// WithoutComments returns a navigator that hides comment nodes.
+func WithoutComments(nav NodeNavigator) NodeNavigator {
+	return FilterNavigator(nav, func(n NodeNavigator) bool {
+		return n.NodeType() != CommentNode
+	})
+}
+
+// ElementsOnly returns a navigator that hides every node that is neither
+// an element nor an attribute, such as text and comment nodes.
+func ElementsOnly(nav NodeNavigator) NodeNavigator {
+	return FilterNavigator(nav, func(n NodeNavigator) bool {
+		typ := n.NodeType()
+		return typ == ElementNode || typ == AttributeNode
+	})
+}
+
+// WithoutWhitespace returns a navigator that hides text nodes consisting
+// only of XML whitespace, such as the indentation of a pretty-printed
+// document, so they don't count as children, in positions or in text(),
+// as with parsers that drop ignorable whitespace (xmllint --noblanks).
+func WithoutWhitespace(nav NodeNavigator) NodeNavigator {
+	return FilterNavigator(nav, func(n NodeNavigator) bool {
+		return n.NodeType() != TextNode || !isWhitespace(n.Value())
+	})
+}
+
+// isWhitespace reports whether s only contains XML whitespace characters.
+func isWhitespace(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '\n', '\r':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type filterNavigator struct {
+	NodeNavigator
+	keep func(NodeNavigator) bool
+}
+
+// Unwrap returns the underlying navigator.
+func (f *filterNavigator) Unwrap() NodeNavigator {
+	return f.NodeNavigator
+}
+
+func (f *filterNavigator) NamespaceURL() string {
+	return navNamespaceURL(f.NodeNavigator)
+}
+
+func (f *filterNavigator) Copy() NodeNavigator {
+	return &filterNavigator{NodeNavigator: f.NodeNavigator.Copy(), keep: f.keep}
+}
+
+func (f *filterNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*filterNavigator); ok {
+		other = o.NodeNavigator
+	}
+	return f.NodeNavigator.MoveTo(other)
+}
+
+// skip moves forward with next until a visible node is reached. The
+// navigator is restored to its original position if there is none.
+func (f *filterNavigator) skip(first, next func() bool) bool {
+	saved := f.NodeNavigator.Copy()
+	for ok := first(); ok; ok = next() {
+		if f.keep(f.NodeNavigator) {
+			return true
+		}
+	}
+	f.NodeNavigator.MoveTo(saved)
+	return false
+}
+
+func (f *filterNavigator) MoveToNextAttribute() bool {
+	return f.skip(f.NodeNavigator.MoveToNextAttribute, f.NodeNavigator.MoveToNextAttribute)
+}
+
+func (f *filterNavigator) MoveToChild() bool {
+	return f.skip(f.NodeNavigator.MoveToChild, f.NodeNavigator.MoveToNext)
+}
+
+func (f *filterNavigator) MoveToNext() bool {
+	return f.skip(f.NodeNavigator.MoveToNext, f.NodeNavigator.MoveToNext)
+}
+
+func (f *filterNavigator) MoveToPrevious() bool {
+	return f.skip(f.NodeNavigator.MoveToPrevious, f.NodeNavigator.MoveToPrevious)
+}
+
+func (f *filterNavigator) MoveToFirst() bool {
+	return f.skip(f.NodeNavigator.MoveToFirst, f.NodeNavigator.MoveToNext)
+}
+
+// SubtreeOnly returns a navigator that only sees the subtree of the node
+// nav is positioned on. That node acts as the root: MoveToRoot returns to
+// it, and its parent and siblings are unreachable, so an expression such
+// as //p only selects nodes inside the subtree.
+func SubtreeOnly(nav NodeNavigator) NodeNavigator {
+	return &subtreeNavigator{NodeNavigator: nav.Copy(), root: nav.Copy()}
+}
+
+type subtreeNavigator struct {
+	NodeNavigator
+	root  NodeNavigator
+	depth int // depth of the current node below root
+}
+
+// Unwrap returns the underlying navigator.
+func (s *subtreeNavigator) Unwrap() NodeNavigator {
+	return s.NodeNavigator
+}
+
+func (s *subtreeNavigator) NamespaceURL() string {
+	return navNamespaceURL(s.NodeNavigator)
+}
+
+func (s *subtreeNavigator) Copy() NodeNavigator {
+	return &subtreeNavigator{NodeNavigator: s.NodeNavigator.Copy(), root: s.root, depth: s.depth}
+}
+
+func (s *subtreeNavigator) MoveTo(other NodeNavigator) bool {
+	o, ok := other.(*subtreeNavigator)
+	if !ok || !s.NodeNavigator.MoveTo(o.NodeNavigator) {
+		return false
+	}
+	s.depth = o.depth
+	return true
+}
+
+func (s *subtreeNavigator) MoveToRoot() {
+	s.NodeNavigator.MoveTo(s.root)
+	s.depth = 0
+}
+
+func (s *subtreeNavigator) MoveToParent() bool {
+	if s.NodeNavigator.NodeType() == AttributeNode {
+		return s.NodeNavigator.MoveToParent()
+	}
+	if s.depth == 0 || !s.NodeNavigator.MoveToParent() {
+		return false
+	}
+	s.depth--
+	return true
+}
+
+func (s *subtreeNavigator) MoveToChild() bool {
+	if !s.NodeNavigator.MoveToChild() {
+		return false
+	}
+	s.depth++
+	return true
+}
+
+func (s *subtreeNavigator) MoveToFirst() bool {
+	return s.depth > 0 && s.NodeNavigator.MoveToFirst()
+}
+
+func (s *subtreeNavigator) MoveToNext() bool {
+	return s.depth > 0 && s.NodeNavigator.MoveToNext()
+}
+
+func (s *subtreeNavigator) MoveToPrevious() bool {
+	return s.depth > 0 && s.NodeNavigator.MoveToPrevious()
+}
+
+// SyncNavigator returns a navigator that serializes every call into nav,
+// and into all copies made from it, with a single mutex. Use it when the
+// navigators of a document are not safe for concurrent use, for example
+// because they build the tree lazily, and several goroutines query the
+// same document. Each goroutine still needs its own copy of the returned
+// navigator.
+func SyncNavigator(nav NodeNavigator) NodeNavigator {
+	return &syncNavigator{nav: nav, mu: new(sync.Mutex)}
+}
+
+type syncNavigator struct {
+	nav NodeNavigator
+	mu  *sync.Mutex
+}
+
+// Unwrap returns the underlying navigator.
+func (s *syncNavigator) Unwrap() NodeNavigator {
+	return s.nav
+}
+
+func (s *syncNavigator) NodeType() NodeType {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.NodeType()
+}
+
+func (s *syncNavigator) LocalName() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.LocalName()
+}
+
+func (s *syncNavigator) Prefix() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.Prefix()
+}
+
+func (s *syncNavigator) NamespaceURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return navNamespaceURL(s.nav)
+}
+
+func (s *syncNavigator) Value() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.Value()
+}
+
+func (s *syncNavigator) Copy() NodeNavigator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &syncNavigator{nav: s.nav.Copy(), mu: s.mu}
+}
+
+func (s *syncNavigator) MoveToRoot() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nav.MoveToRoot()
+}
+
+func (s *syncNavigator) MoveToParent() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.MoveToParent()
+}
+
+func (s *syncNavigator) MoveToNextAttribute() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.MoveToNextAttribute()
+}
+
+func (s *syncNavigator) MoveToChild() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.MoveToChild()
+}
+
+func (s *syncNavigator) MoveToFirst() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.MoveToFirst()
+}
+
+func (s *syncNavigator) MoveToNext() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.MoveToNext()
+}
+
+func (s *syncNavigator) MoveToPrevious() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.MoveToPrevious()
+}
+
+func (s *syncNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*syncNavigator); ok {
+		other = o.nav
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nav.MoveTo(other)
+}
+
+// CopyOnWrite returns a navigator for which Copy is cheap: copies share
+// nav, and the first copy to move makes a private copy of nav before
+// moving it. Use it when Copy is expensive for nav, for example because it
+// copies a path from the root, since the engine copies the navigator to
+// keep positions that are often never moved from. The underlying
+// navigators are moved and copied as usual, so copies can be used from
+// different goroutines only if nav can.
+func CopyOnWrite(nav NodeNavigator) NodeNavigator {
+	return &cowNavigator{shared: &cowShared{nav: nav, refs: 1}}
+}
+
+// cowShared is a navigator shared by refs copy-on-write navigators.
+type cowShared struct {
+	nav  NodeNavigator
+	refs int32
+}
+
+type cowNavigator struct {
+	shared *cowShared
+}
+
+// own gives c a private navigator if it shares one, before a move.
+func (c *cowNavigator) own() NodeNavigator {
+	s := c.shared
+	if atomic.LoadInt32(&s.refs) > 1 {
+		c.shared = &cowShared{nav: s.nav.Copy(), refs: 1}
+		atomic.AddInt32(&s.refs, -1)
+	}
+	return c.shared.nav
+}
+
+// Unwrap returns the underlying navigator, which may be shared with
+// copies of c and must not be moved.
+func (c *cowNavigator) Unwrap() NodeNavigator {
+	return c.shared.nav
+}
+
+func (c *cowNavigator) NodeType() NodeType {
+	return c.shared.nav.NodeType()
+}
+
+func (c *cowNavigator) LocalName() string {
+	return c.shared.nav.LocalName()
+}
+
+func (c *cowNavigator) Prefix() string {
+	return c.shared.nav.Prefix()
+}
+
+func (c *cowNavigator) NamespaceURL() string {
+	return navNamespaceURL(c.shared.nav)
+}
+
+func (c *cowNavigator) Value() string {
+	return c.shared.nav.Value()
+}
+
+func (c *cowNavigator) Copy() NodeNavigator {
+	atomic.AddInt32(&c.shared.refs, 1)
+	return &cowNavigator{shared: c.shared}
+}
+
+func (c *cowNavigator) MoveToRoot() {
+	c.own().MoveToRoot()
+}
+
+func (c *cowNavigator) MoveToParent() bool {
+	return c.own().MoveToParent()
+}
+
+func (c *cowNavigator) MoveToNextAttribute() bool {
+	return c.own().MoveToNextAttribute()
+}
+
+func (c *cowNavigator) MoveToChild() bool {
+	return c.own().MoveToChild()
+}
+
+func (c *cowNavigator) MoveToFirst() bool {
+	return c.own().MoveToFirst()
+}
+
+func (c *cowNavigator) MoveToNext() bool {
+	return c.own().MoveToNext()
+}
+
+func (c *cowNavigator) MoveToPrevious() bool {
+	return c.own().MoveToPrevious()
+}
+
+func (c *cowNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*cowNavigator); ok {
+		if o.shared == c.shared {
+			return true
+		}
+		other = o.shared.nav
+	}
+	return c.own().MoveTo(other)
+}
+
+func (c *cowNavigator) lookupAttr(prefix, localName, value string) ([]indexedNode, bool) {
+	ix, ok := c.shared.nav.(attrIndexer)
+	if !ok {
+		return nil, false
+	}
+	list, ok := ix.lookupAttr(prefix, localName, value)
+	for i := range list {
+		list[i].node = CopyOnWrite(list[i].node)
+	}
+	return list, ok
+}
+
+// IndexAttr returns a navigator that indexes the elements of the document
+// of nav by the value of their attribute name, which may be qualified
+// (xml:id). Expressions such as //*[@id='x'] and id('x') evaluated from
+// the returned navigator, or from copies of it, look the elements up in
+// the index instead of scanning the document, which pays off for repeated
+// queries against the same document. Several attributes can be indexed by
+// wrapping the navigator once for each.
+//
+// The index is built when IndexAttr is called and is not updated when the
+// document changes. Virtual attributes are not indexed.
+func IndexAttr(nav NodeNavigator, name string) NodeNavigator {
+	index := &attrIndex{nodes: make(map[string][]indexedNode)}
+	index.prefix, index.localName = "", name
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		index.prefix, index.localName = name[:i], name[i+1:]
+	}
+
+	walkAttributes(nav, index.prefix, index.localName, func(ord int, n NodeNavigator, value string) {
+		index.nodes[value] = append(index.nodes[value], indexedNode{ord: ord, node: n.Copy()})
+	})
+	return &indexNavigator{NodeNavigator: nav, index: index}
+}
+
+// walkAttributes calls f in document order for each element of the
+// document of nav that has the attribute prefix:localName, with the
+// position of the element in document order and the attribute value.
+func walkAttributes(nav NodeNavigator, prefix, localName string, f func(ord int, n NodeNavigator, value string)) {
+	n := nav.Copy()
+	n.MoveToRoot()
+	for ord, depth := 0, 0; ; ord++ {
+		if n.NodeType() == ElementNode {
+			attr := n.Copy()
+			for attr.MoveToNextAttribute() {
+				if attr.LocalName() == localName && attr.Prefix() == prefix {
+					f(ord, n, attr.Value())
+				}
+			}
+		}
+		if n.MoveToChild() {
+			depth++
+			continue
+		}
+		for depth > 0 && !n.MoveToNext() {
+			n.MoveToParent()
+			depth--
+		}
+		if depth == 0 {
+			return
+		}
+	}
+}
+
+// attrIndexer is implemented by the navigators that can look up elements
+// by the value of an attribute.
+type attrIndexer interface {
+	// lookupAttr returns the elements in document order whose attribute
+	// prefix:localName has the value, and whether the attribute is
+	// indexed.
+	lookupAttr(prefix, localName, value string) ([]indexedNode, bool)
+}
+
+// indexedNode is an element found in an index, with ord giving its
+// position in document order.
+type indexedNode struct {
+	ord  int
+	node NodeNavigator
+}
+
+type attrIndex struct {
+	prefix, localName string
+	nodes             map[string][]indexedNode
+}
+
+type indexNavigator struct {
+	NodeNavigator
+	index *attrIndex
+}
+
+// Unwrap returns the underlying navigator.
+func (n *indexNavigator) Unwrap() NodeNavigator {
+	return n.NodeNavigator
+}
+
+func (n *indexNavigator) NamespaceURL() string {
+	return navNamespaceURL(n.NodeNavigator)
+}
+
+func (n *indexNavigator) Copy() NodeNavigator {
+	return &indexNavigator{NodeNavigator: n.NodeNavigator.Copy(), index: n.index}
+}
+
+func (n *indexNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*indexNavigator); ok {
+		other = o.NodeNavigator
+	}
+	return n.NodeNavigator.MoveTo(other)
+}
+
+func (n *indexNavigator) lookupAttr(prefix, localName, value string) ([]indexedNode, bool) {
+	if prefix == n.index.prefix && localName == n.index.localName {
+		list := make([]indexedNode, len(n.index.nodes[value]))
+		for i, e := range n.index.nodes[value] {
+			list[i] = indexedNode{ord: e.ord, node: &indexNavigator{NodeNavigator: e.node.Copy(), index: n.index}}
+		}
+		return list, true
+	}
+	ix, ok := n.NodeNavigator.(attrIndexer)
+	if !ok {
+		return nil, false
+	}
+	list, ok := ix.lookupAttr(prefix, localName, value)
+	for i := range list {
+		list[i].node = &indexNavigator{NodeNavigator: list[i].node, index: n.index}
+	}
+	return list, ok
+}
+
+// FragmentNavigator returns a navigator over a virtual root node whose
+// children are the given nodes, for example the result of a prior query.
+// Expressions evaluated from it treat the list as a document fragment: /p
+// selects the nodes named p in the list and //p also their descendants,
+// instead of re-anchoring at the root of the original document.
+//
+// The nodes should be in document order and none should be a descendant
+// of another, otherwise results may be out of order or repeated.
+func FragmentNavigator(nodes ...NodeNavigator) NodeNavigator {
+	f := &fragment{}
+	for _, n := range nodes {
+		f.roots = append(f.roots, n.Copy())
+	}
+	return &fragmentNavigator{fragment: f, index: -1}
+}
+
+type fragment struct {
+	roots []NodeNavigator
+}
+
+type fragmentNavigator struct {
+	*fragment
+	curr  NodeNavigator // nil on the virtual root
+	index int           // index of the fragment root curr is in, or -1
+	depth int           // depth of curr below its fragment root
+}
+
+// Unwrap returns the underlying navigator, or nil when positioned on the
+// virtual root.
+func (f *fragmentNavigator) Unwrap() NodeNavigator {
+	return f.curr
+}
+
+func (f *fragmentNavigator) NodeType() NodeType {
+	if f.curr == nil {
+		return RootNode
+	}
+	return f.curr.NodeType()
+}
+
+func (f *fragmentNavigator) LocalName() string {
+	if f.curr == nil {
+		return ""
+	}
+	return f.curr.LocalName()
+}
+
+func (f *fragmentNavigator) Prefix() string {
+	if f.curr == nil {
+		return ""
+	}
+	return f.curr.Prefix()
+}
+
+func (f *fragmentNavigator) NamespaceURL() string {
+	if f.curr == nil {
+		return ""
+	}
+	return navNamespaceURL(f.curr)
+}
+
+func (f *fragmentNavigator) Value() string {
+	if f.curr != nil {
+		return f.curr.Value()
+	}
+	var s string
+	for _, n := range f.roots {
+		if typ := n.NodeType(); typ != CommentNode && typ != AttributeNode {
+			s += n.Value()
+		}
+	}
+	return s
+}
+
+func (f *fragmentNavigator) Copy() NodeNavigator {
+	n := *f
+	if f.curr != nil {
+		n.curr = f.curr.Copy()
+	}
+	return &n
+}
+
+func (f *fragmentNavigator) MoveToRoot() {
+	f.curr, f.index, f.depth = nil, -1, 0
+}
+
+// moveToRoot moves to the i-th fragment root.
+func (f *fragmentNavigator) moveToRoot(i int) bool {
+	if i < 0 || i >= len(f.roots) {
+		return false
+	}
+	f.curr, f.index, f.depth = f.roots[i].Copy(), i, 0
+	return true
+}
+
+func (f *fragmentNavigator) MoveToParent() bool {
+	switch {
+	case f.curr == nil:
+		return false
+	case f.curr.NodeType() == AttributeNode:
+		return f.curr.MoveToParent()
+	case f.depth == 0:
+		f.MoveToRoot()
+		return true
+	case f.curr.MoveToParent():
+		f.depth--
+		return true
+	}
+	return false
+}
+
+func (f *fragmentNavigator) MoveToNextAttribute() bool {
+	return f.curr != nil && f.curr.MoveToNextAttribute()
+}
+
+func (f *fragmentNavigator) MoveToChild() bool {
+	if f.curr == nil {
+		return f.moveToRoot(0)
+	}
+	if !f.curr.MoveToChild() {
+		return false
+	}
+	f.depth++
+	return true
+}
+
+func (f *fragmentNavigator) MoveToFirst() bool {
+	switch {
+	case f.curr == nil:
+		return false
+	case f.depth == 0:
+		return f.index > 0 && f.moveToRoot(0)
+	}
+	return f.curr.MoveToFirst()
+}
+
+func (f *fragmentNavigator) MoveToNext() bool {
+	switch {
+	case f.curr == nil:
+		return false
+	case f.depth == 0:
+		return f.curr.NodeType() != AttributeNode && f.moveToRoot(f.index+1)
+	}
+	return f.curr.MoveToNext()
+}
+
+func (f *fragmentNavigator) MoveToPrevious() bool {
+	switch {
+	case f.curr == nil:
+		return false
+	case f.depth == 0:
+		return f.curr.NodeType() != AttributeNode && f.moveToRoot(f.index-1)
+	}
+	return f.curr.MoveToPrevious()
+}
+
+func (f *fragmentNavigator) MoveTo(other NodeNavigator) bool {
+	o, ok := other.(*fragmentNavigator)
+	if !ok || o.fragment != f.fragment {
+		return false
+	}
+	if o.curr == nil {
+		f.MoveToRoot()
+		return true
+	}
+	if f.curr == nil || !f.curr.MoveTo(o.curr) {
+		f.curr = o.curr.Copy()
+	}
+	f.index, f.depth = o.index, o.depth
+	return true
+}
@@ -0,0 +1,316 @@
+package xpath
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWithoutComments(t *testing.T) {
+	doc := createNode("", RootNode)
+	a := doc.createChildNode("a", ElementNode)
+	a.createChildNode("c1", CommentNode)
+	b := a.createChildNode("b", ElementNode)
+	b.createChildNode("text", TextNode)
+	a.createChildNode("c2", CommentNode)
+	a.createChildNode("c", ElementNode)
+
+	nav := WithoutComments(createNavigator(doc))
+	assertEqual(t, float64(0), MustCompile(`count(//comment())`).Evaluate(nav))
+	assertEqual(t, float64(2), MustCompile(`count(/a/node())`).Evaluate(nav))
+	assertEqual(t, "b", MustCompile(`name(/a/node()[1])`).Evaluate(nav))
+	assertEqual(t, "b", MustCompile(`name(/a/c/preceding-sibling::node()[1])`).Evaluate(nav))
+	// The backing document is unchanged.
+	assertEqual(t, float64(2), MustCompile(`count(//comment())`).Evaluate(createNavigator(doc)))
+}
+
+func TestElementsOnly(t *testing.T) {
+	nav := ElementsOnly(createNavigator(employee_example))
+	assertEqual(t, float64(0), MustCompile(`count(//text())`).Evaluate(nav))
+	assertEqual(t, float64(3), MustCompile(`count(/empinfo/node())`).Evaluate(nav))
+	assertEqual(t, float64(9), MustCompile(`count(//@*)`).Evaluate(nav))
+
+	iter := MustCompile(`//name`).Select(nav)
+	assertTrue(t, iter.MoveNext())
+	u, ok := iter.Current().(interface{ Unwrap() NodeNavigator })
+	assertTrue(t, ok)
+	assertEqual(t, 4, u.Unwrap().(*TNodeNavigator).curr.lines)
+}
+
+func TestSubtreeOnly(t *testing.T) {
+	emp := selectNode(employee_example, `//employee[@id=2]`)
+	sub := func() NodeNavigator {
+		return SubtreeOnly(&TNodeNavigator{curr: emp, root: employee_example, attr: -1})
+	}
+
+	var names []string
+	for iter := MustCompile(`//name`).Select(sub()); iter.MoveNext(); {
+		names = append(names, iter.Current().Value())
+	}
+	assertEqual(t, []string{"Max Miller"}, names)
+	assertEqual(t, float64(0), MustCompile(`count(..)`).Evaluate(sub()))
+	assertEqual(t, float64(0), MustCompile(`count(following-sibling::*)`).Evaluate(sub()))
+	assertEqual(t, float64(0), MustCompile(`count(//name/ancestor::empinfo)`).Evaluate(sub()))
+	assertEqual(t, float64(1), MustCompile(`count(//name/ancestor::employee)`).Evaluate(sub()))
+	assertEqual(t, "2", MustCompile(`string(/@id)`).Evaluate(sub()))
+	assertEqual(t, "CA", MustCompile(`string(//name/@from)`).Evaluate(sub()))
+
+	// Wrappers compose.
+	assertEqual(t, float64(0), MustCompile(`count(//text())`).Evaluate(ElementsOnly(sub())))
+	assertEqual(t, float64(3), MustCompile(`count(//name)`).Evaluate(SubtreeOnly(createNavigator(employee_example))))
+}
+
+// exclusiveNavigator fails the test when it is used by two goroutines at
+// the same time.
+type exclusiveNavigator struct {
+	*TNodeNavigator
+	t    *testing.T
+	busy *int32
+}
+
+func (n *exclusiveNavigator) enter() func() {
+	if !atomic.CompareAndSwapInt32(n.busy, 0, 1) {
+		n.t.Error("concurrent access to navigator")
+	}
+	runtime.Gosched()
+	return func() { atomic.StoreInt32(n.busy, 0) }
+}
+
+func (n *exclusiveNavigator) Value() string {
+	defer n.enter()()
+	return n.TNodeNavigator.Value()
+}
+
+func (n *exclusiveNavigator) MoveToChild() bool {
+	defer n.enter()()
+	return n.TNodeNavigator.MoveToChild()
+}
+
+func (n *exclusiveNavigator) MoveToNext() bool {
+	defer n.enter()()
+	return n.TNodeNavigator.MoveToNext()
+}
+
+func (n *exclusiveNavigator) Copy() NodeNavigator {
+	return &exclusiveNavigator{TNodeNavigator: n.TNodeNavigator.Copy().(*TNodeNavigator), t: n.t, busy: n.busy}
+}
+
+func (n *exclusiveNavigator) MoveTo(other NodeNavigator) bool {
+	o, ok := other.(*exclusiveNavigator)
+	return ok && n.TNodeNavigator.MoveTo(o.TNodeNavigator)
+}
+
+func TestSyncNavigator(t *testing.T) {
+	nav := SyncNavigator(&exclusiveNavigator{TNodeNavigator: createNavigator(employee_example), t: t, busy: new(int32)})
+	count := MustCompile(`count(//employee[name])`)
+	names := MustCompile(`//name`)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(nav NodeNavigator) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if v := count.Evaluate(nav.Copy()); v != float64(3) {
+					t.Errorf("expected 3, got %v", v)
+				}
+				var n int
+				for iter := names.Select(nav.Copy()); iter.MoveNext(); {
+					n++
+				}
+				if n != 3 {
+					t.Errorf("expected 3 nodes, got %d", n)
+				}
+			}
+		}(nav.Copy())
+	}
+	wg.Wait()
+}
+
+func TestFragmentNavigator(t *testing.T) {
+	var list []NodeNavigator
+	for iter := MustCompile(`//employee[@id>1]`).Select(createNavigator(employee_example)); iter.MoveNext(); {
+		list = append(list, iter.Current().Copy())
+	}
+	frag := func() NodeNavigator { return FragmentNavigator(list...) }
+
+	var names []string
+	for iter := MustCompile(`//name`).Select(frag()); iter.MoveNext(); {
+		names = append(names, iter.Current().Value())
+	}
+	assertEqual(t, []string{"Max Miller", "Beccaa Moss"}, names)
+	assertEqual(t, float64(2), MustCompile(`count(/employee)`).Evaluate(frag()))
+	assertEqual(t, float64(2), MustCompile(`count(//employee)`).Evaluate(frag()))
+	assertEqual(t, float64(0), MustCompile(`count(//empinfo)`).Evaluate(frag()))
+	assertEqual(t, "3", MustCompile(`string(/employee[2]/@id)`).Evaluate(frag()))
+	assertEqual(t, "3", MustCompile(`string(//employee[1]/following-sibling::*/@id)`).Evaluate(frag()))
+	// The ancestors are the employee and the virtual root.
+	assertEqual(t, float64(2), MustCompile(`count(//name[.='Max Miller']/ancestor::node())`).Evaluate(frag()))
+	assertEqual(t, float64(6), MustCompile(`count(/*/* | //designation)`).Evaluate(frag()))
+
+	iter := MustCompile(`//email`).Select(frag())
+	assertTrue(t, iter.MoveNext())
+	u := iter.Current().(interface{ Unwrap() NodeNavigator })
+	assertEqual(t, 11, u.Unwrap().(*TNodeNavigator).curr.lines)
+
+	assertEqual(t, float64(0), MustCompile(`count(//*)`).Evaluate(FragmentNavigator()))
+}
+
+func TestWithoutWhitespace(t *testing.T) {
+	// <a>\n  <b>x</b>\n  <b> </b>\n</a>
+	doc := createNode("", RootNode)
+	a := doc.createChildNode("a", ElementNode)
+	a.createChildNode("\n  ", TextNode)
+	a.createChildNode("b", ElementNode).createChildNode("x", TextNode)
+	a.createChildNode("\n  ", TextNode)
+	a.createChildNode("b", ElementNode).createChildNode(" ", TextNode)
+	a.createChildNode("\n", TextNode)
+
+	assertEqual(t, float64(5), MustCompile(`count(/a/node())`).Evaluate(createNavigator(doc)))
+	assertEqual(t, float64(5), MustCompile(`count(//text())`).Evaluate(createNavigator(doc)))
+
+	nav := func() NodeNavigator { return WithoutWhitespace(createNavigator(doc)) }
+	assertEqual(t, float64(2), MustCompile(`count(/a/node())`).Evaluate(nav()))
+	assertEqual(t, float64(1), MustCompile(`count(//text())`).Evaluate(nav()))
+	assertEqual(t, "b", MustCompile(`name(/a/node()[2])`).Evaluate(nav()))
+	assertEqual(t, float64(0), MustCompile(`count(/a/b[2]/node())`).Evaluate(nav()))
+	assertEqual(t, "", MustCompile(`string(/a/b[1]/following-sibling::node()[1]/text())`).Evaluate(nav()))
+}
+
+func TestIndexAttr(t *testing.T) {
+	moves := 0
+	counting := FilterNavigator(createNavigator(employee_example), func(NodeNavigator) bool {
+		moves++
+		return true
+	})
+	nav := IndexAttr(IndexAttr(counting, "id"), "discipline")
+
+	for _, tc := range []struct {
+		expr string
+		want []string
+	}{
+		{`//employee[@id='2']/name`, []string{"Max Miller"}},
+		{`//*["3" = @id]/name`, []string{"Beccaa Moss"}},
+		{`//name[@id='2']`, nil},
+		{`//employee[@id='4']`, nil},
+		{`//designation[@discipline='web']`, []string{"Senior Engineer"}},
+		{`id('3 1 3')/name`, []string{"Opal Kole", "Beccaa Moss"}},
+		{`id(//employee[@id='2']/@id)/email`, []string{"maxmiller@email.com"}},
+	} {
+		var want []string
+		for _, n := range selectNodes(employee_example, tc.expr) {
+			want = append(want, n.Value())
+		}
+		assertEqual(t, tc.want, want)
+
+		moves = 0
+		var got []string
+		for iter := MustCompile(tc.expr).Select(nav.Copy()); iter.MoveNext(); {
+			got = append(got, iter.Current().Value())
+		}
+		assertEqual(t, tc.want, got)
+		// The elements are looked up instead of scanning the document.
+		if moves > 10 {
+			t.Errorf("%s: expected an index lookup, got %d moves", tc.expr, moves)
+		}
+	}
+
+	// Attributes that are not indexed are still found by scanning.
+	assertEqual(t, float64(1), MustCompile(`count(//name[@from='CA'])`).Evaluate(nav.Copy()))
+	assertEqual(t, float64(2), MustCompile(`count(id('1 2'))`).Evaluate(createNavigator(employee_example)))
+	assertEqual(t, float64(3), MustCompile(`count(//employee[id(@id)])`).Evaluate(nav.Copy()))
+}
+
+// copyingNavigator counts the copies made of it and of its copies.
+type copyingNavigator struct {
+	*TNodeNavigator
+	copies *int
+}
+
+func (n *copyingNavigator) Copy() NodeNavigator {
+	*n.copies++
+	return &copyingNavigator{TNodeNavigator: n.TNodeNavigator.Copy().(*TNodeNavigator), copies: n.copies}
+}
+
+func (n *copyingNavigator) MoveTo(other NodeNavigator) bool {
+	o, ok := other.(*copyingNavigator)
+	return ok && n.TNodeNavigator.MoveTo(o.TNodeNavigator)
+}
+
+func (n *copyingNavigator) ComparePosition(other NodeNavigator) (int, bool) {
+	if o, ok := other.(*copyingNavigator); ok {
+		return n.TNodeNavigator.ComparePosition(o.TNodeNavigator)
+	}
+	return 0, false
+}
+
+func TestCopyOnWrite(t *testing.T) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 200; i++ {
+		x := root.createChildNode("x", ElementNode)
+		x.lines = i + 1
+		if i%2 == 0 {
+			x.createChildNode("a", ElementNode)
+		} else {
+			x.createChildNode("b", ElementNode)
+		}
+	}
+
+	for _, tc := range []struct {
+		expr string
+		want int
+	}{
+		{`/r/x/a/..`, 100},
+		{`//x[a or b]`, 200},
+		{`//x[a and /r]`, 100},
+		{`//x[a | b]`, 200},
+		{`/r//a`, 100},
+		{`//a/parent::x[b]`, 0},
+	} {
+		copies := 0
+		nav := &copyingNavigator{TNodeNavigator: createNavigator(doc), copies: &copies}
+		test_xpath_count(t, doc, tc.expr, tc.want)
+		var got []int
+		for iter := MustCompile(tc.expr).Select(nav); iter.MoveNext(); {
+			got = append(got, iter.Current().(*copyingNavigator).curr.lines)
+		}
+		assertEqual(t, tc.want, len(got))
+		// The positions kept for each context node reuse their navigators.
+		if copies > 20 {
+			t.Errorf("%s: expected a few copies, made %d", tc.expr, copies)
+		}
+
+		copies = 0
+		cow := CopyOnWrite(&copyingNavigator{TNodeNavigator: createNavigator(doc), copies: &copies})
+		var cowGot []int
+		for iter := MustCompile(tc.expr).Select(cow); iter.MoveNext(); {
+			u := iter.Current().(interface{ Unwrap() NodeNavigator })
+			cowGot = append(cowGot, u.Unwrap().(*copyingNavigator).curr.lines)
+		}
+		assertEqual(t, got, cowGot)
+	}
+
+	// Copies share the navigator until they move.
+	copies := 0
+	nav := CopyOnWrite(&copyingNavigator{TNodeNavigator: createNavigator(doc), copies: &copies})
+	nav.MoveToChild()
+	c1, c2 := nav.Copy(), nav.Copy()
+	assertEqual(t, 0, copies)
+	assertEqual(t, "r", c1.LocalName())
+	assertTrue(t, c1.MoveTo(c2))
+	assertTrue(t, c1.MoveToChild())
+	assertEqual(t, 1, copies)
+	assertEqual(t, "x", c1.LocalName())
+	assertEqual(t, "r", nav.LocalName())
+	assertEqual(t, "r", c2.LocalName())
+	assertTrue(t, c2.MoveToParent())
+	assertEqual(t, 2, copies)
+	assertEqual(t, RootNode, c2.NodeType())
+	// nav is the last to use its navigator and moves it without a copy.
+	assertTrue(t, nav.MoveToChild())
+	assertEqual(t, 2, copies)
+	assertEqual(t, "x", nav.LocalName())
+	assertTrue(t, nav.MoveTo(c1))
+	assertEqual(t, float64(1), MustCompile(`count(self::x[a])`).Evaluate(nav))
+}
@@ -0,0 +1,166 @@
+package xpath
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NodePath returns a path selecting the node n and only it, with the
+// position of each step among the siblings of the same name or type, such
+// as /bookstore[1]/book[2]/@category or /bookstore[1]/book[1]/text()[1].
+// Two navigators on the same node give the same path, whatever engine
+// found them, so the paths identify the nodes of results to compare. n is
+// not moved.
+func NodePath(n NodeNavigator) string {
+	n = n.Copy()
+	var steps []string
+	for n.NodeType() != RootNode {
+		steps = append(steps, pathStep(n))
+		if !n.MoveToParent() {
+			break
+		}
+	}
+	var b strings.Builder
+	for i := len(steps) - 1; i >= 0; i-- {
+		b.WriteString("/" + steps[i])
+	}
+	if b.Len() == 0 {
+		return "/"
+	}
+	return b.String()
+}
+
+// pathStep returns the step of NodePath for the node n.
+func pathStep(n NodeNavigator) string {
+	var test string
+	switch n.NodeType() {
+	case AttributeNode:
+		return "@" + qualifiedName(n.Prefix(), n.LocalName())
+	case TextNode:
+		test = "text()"
+	case CommentNode:
+		test = "comment()"
+	default:
+		test = qualifiedName(n.Prefix(), n.LocalName())
+	}
+	typ, prefix, name := n.NodeType(), n.Prefix(), n.LocalName()
+	sib, i := n.Copy(), 1
+	for sib.MoveToPrevious() {
+		if sib.NodeType() == typ && (typ != ElementNode || sib.Prefix() == prefix && sib.LocalName() == name) {
+			i++
+		}
+	}
+	return test + "[" + strconv.Itoa(i) + "]"
+}
+
+// A NodeSetDiff is the difference between the node-set an expression was
+// expected to select, such as that of another engine, and the one it
+// selected, see DiffNodeSets. The nodes are identified by their NodePath.
+type NodeSetDiff struct {
+	// Missing are the nodes expected but not selected, in the expected
+	// order.
+	Missing []string
+	// Extra are the nodes selected but not expected, in the selected
+	// order. A node selected more often than expected is extra too.
+	Extra []string
+	// Moved are the fewest nodes that, taken out of both node-sets, leave
+	// the other nodes in the same order, in the selected order.
+	Moved []string
+}
+
+// DiffNodeSets returns the difference between the nodes want that an
+// expression was expected to select and the nodes got it selected.
+func DiffNodeSets(want, got []NodeNavigator) NodeSetDiff {
+	paths := func(nodes []NodeNavigator) []string {
+		s := make([]string, len(nodes))
+		for i, n := range nodes {
+			s[i] = NodePath(n)
+		}
+		return s
+	}
+	return DiffPaths(paths(want), paths(got))
+}
+
+// DiffPaths is DiffNodeSets for nodes given by their NodePath, as for the
+// results of another engine.
+func DiffPaths(want, got []string) NodeSetDiff {
+	var d NodeSetDiff
+	// The indexes in want of each path not matched yet.
+	pending := make(map[string][]int)
+	for i, p := range want {
+		pending[p] = append(pending[p], i)
+	}
+	var common []int // the index in want of the nodes of got in it
+	var commonPaths []string
+	for _, p := range got {
+		if len(pending[p]) == 0 {
+			d.Extra = append(d.Extra, p)
+			continue
+		}
+		common = append(common, pending[p][0])
+		commonPaths = append(commonPaths, p)
+		pending[p] = pending[p][1:]
+	}
+	for i, p := range want {
+		if k := pending[p]; len(k) > 0 && k[0] == i {
+			d.Missing = append(d.Missing, p)
+			pending[p] = k[1:]
+		}
+	}
+	inOrder := longestIncreasing(common)
+	for i, p := range commonPaths {
+		if !inOrder[i] {
+			d.Moved = append(d.Moved, p)
+		}
+	}
+	return d
+}
+
+// longestIncreasing returns which of the elements of s are in one of its
+// longest increasing subsequences.
+func longestIncreasing(s []int) []bool {
+	// tails[k] is the index in s of the smallest last element of the
+	// increasing subsequences of length k+1 found so far.
+	var tails []int
+	prev := make([]int, len(s))
+	for i, v := range s {
+		k := sort.Search(len(tails), func(k int) bool { return s[tails[k]] >= v })
+		prev[i] = -1
+		if k > 0 {
+			prev[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+	in := make([]bool, len(s))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			in[i] = true
+		}
+	}
+	return in
+}
+
+// Empty reports whether the node-sets are the same, in the same order.
+func (d NodeSetDiff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Moved) == 0
+}
+
+// String returns a line for each node of d, such as
+// "missing /bookstore[1]/book[2]", or "" if d is empty.
+func (d NodeSetDiff) String() string {
+	var b strings.Builder
+	for _, l := range []struct {
+		name  string
+		paths []string
+	}{{"missing", d.Missing}, {"extra", d.Extra}, {"moved", d.Moved}} {
+		for _, p := range l.paths {
+			b.WriteString(l.name + " " + p + "\n")
+		}
+	}
+	return b.String()
+}
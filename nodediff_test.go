@@ -0,0 +1,57 @@
+package xpath
+
+import "testing"
+
+func TestNodePath(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		path string
+	}{
+		{`/`, `/`},
+		{`/bookstore`, `/bookstore[1]`},
+		{`//book[2]`, `/bookstore[1]/book[2]`},
+		{`//book[2]/@category`, `/bookstore[1]/book[2]/@category`},
+		{`//book[3]/author[2]`, `/bookstore[1]/book[3]/author[2]`},
+		{`//book[1]/title/text()`, `/bookstore[1]/book[1]/title[1]/text()[1]`},
+	} {
+		iter := MustCompile(tc.expr).Select(createNavigator(book_example))
+		assertTrue(t, iter.MoveNext())
+		assertEqual(t, tc.path, NodePath(iter.Current()))
+	}
+
+	// Each path selects its node, and only it.
+	for iter := MustCompile(`//node() | //@*`).Select(createNavigator(employee_example)); iter.MoveNext(); {
+		path := NodePath(iter.Current())
+		var got []NodeNavigator
+		for it := MustCompile(path).Select(createNavigator(employee_example)); it.MoveNext(); {
+			got = append(got, it.Current().Copy())
+		}
+		assertEqual(t, 1, len(got))
+		assertEqual(t, path, NodePath(got[0]))
+	}
+}
+
+func TestDiffNodeSets(t *testing.T) {
+	nodes := func(expr string) []NodeNavigator {
+		var list []NodeNavigator
+		for iter := MustCompile(expr).Select(createNavigator(book_example)); iter.MoveNext(); {
+			list = append(list, iter.Current().Copy())
+		}
+		return list
+	}
+	d := DiffNodeSets(nodes(`//title`), nodes(`//title`))
+	assertTrue(t, d.Empty())
+	assertEqual(t, "", d.String())
+
+	d = DiffNodeSets(nodes(`//book[position() < 4]/title`), nodes(`reverse(//book[position() > 1]/title)`))
+	assertFalse(t, d.Empty())
+	assertEqual(t, []string{"/bookstore[1]/book[1]/title[1]"}, d.Missing)
+	assertEqual(t, []string{"/bookstore[1]/book[4]/title[1]"}, d.Extra)
+	assertEqual(t, []string{"/bookstore[1]/book[3]/title[1]"}, d.Moved)
+	assertEqual(t, "missing /bookstore[1]/book[1]/title[1]\nextra /bookstore[1]/book[4]/title[1]\nmoved /bookstore[1]/book[3]/title[1]\n", d.String())
+
+	d = DiffPaths([]string{"/a[1]", "/a[1]/b[1]"}, []string{"/a[1]/b[1]", "/a[1]", "/a[1]"})
+	assertEqual(t, 0, len(d.Missing))
+	assertEqual(t, []string{"/a[1]"}, d.Extra)
+	assertEqual(t, []string{"/a[1]/b[1]"}, d.Moved)
+}
@@ -0,0 +1,68 @@
+package xpath
+
+import (
+	"math"
+	"strconv"
+)
+
+// NumberStrings are the strings of NaN and the infinities in the
+// conversions of numbers to strings, such as string(0 div 0) or
+// concat('x', 1 div 0), for CompileOptions.NumberStrings. An empty field
+// is the string of Go: NaN, +Inf or -Inf.
+type NumberStrings struct {
+	NaN              string
+	Infinity         string
+	NegativeInfinity string
+}
+
+var (
+	// XPathNumberStrings are the strings of XPath 1.0: NaN, Infinity and
+	// -Infinity.
+	XPathNumberStrings = NumberStrings{NaN: "NaN", Infinity: "Infinity", NegativeInfinity: "-Infinity"}
+
+	// XSDNumberStrings are the strings of an xs:double, those of XPath
+	// 2.0 and later: NaN, INF and -INF.
+	XSDNumberStrings = NumberStrings{NaN: "NaN", Infinity: "INF", NegativeInfinity: "-INF"}
+)
+
+// format returns the string of v, which is NaN or an infinity, or "" if
+// the field for it is empty.
+func (ns NumberStrings) format(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return ns.NaN
+	case math.IsInf(v, 1):
+		return ns.Infinity
+	case math.IsInf(v, -1):
+		return ns.NegativeInfinity
+	}
+	return ""
+}
+
+// formatNumberOf returns the string of the number v in the evaluation of
+// t.
+func formatNumberOf(t iterator, v float64) string {
+	if l := limitsOf(t); l != nil && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		if s := l.numbers.format(v); s != "" {
+			return s
+		}
+	}
+	if v == 0 {
+		// Negative zero is "0" too.
+		return "0"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// strictNumber panics with ErrNotANumber if the evaluation of t has
+// CompileOptions.StrictNumbers, for the conversion by op of the string s,
+// which is not a number, or of an empty node-set if empty is set.
+func strictNumber(t iterator, op, s string, empty bool) {
+	if l := limitsOf(t); l == nil || !l.strictNumbers {
+		return
+	}
+	if empty {
+		panic(newError(ErrNotANumber, nil, "%s of an empty node-set is NaN", op))
+	}
+	panic(newError(ErrNotANumber, nil, "%s of %s is NaN", op, Quote(s)))
+}
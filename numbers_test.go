@@ -0,0 +1,89 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNumberStrings(t *testing.T) {
+	doc := createNode("", RootNode)
+	doc.createChildNode("a", ElementNode).createChildNode("x", TextNode)
+	nav := createNavigator(doc)
+	for _, tc := range []struct {
+		opts NumberStrings
+		expr string
+		want string
+	}{
+		{NumberStrings{}, `concat(string(0 div 0), ' ', string(1 div 0), ' ', string(-1 div 0))`, "NaN +Inf -Inf"},
+		{XPathNumberStrings, `concat(string(0 div 0), ' ', string(1 div 0), ' ', string(-1 div 0))`, "NaN Infinity -Infinity"},
+		{XSDNumberStrings, `concat(string(0 div 0), ' ', string(1 div 0), ' ', string(-1 div 0))`, "NaN INF -INF"},
+		{XPathNumberStrings, `string(number(/a))`, "NaN"},
+		{XPathNumberStrings, `string(1.5)`, "1.5"},
+		// An empty field is the string of Go.
+		{NumberStrings{NaN: "n/a"}, `concat(string(number(/a)), ' ', string(1 div 0))`, "n/a +Inf"},
+	} {
+		expr, err := CompileWithOptions(tc.expr, CompileOptions{NumberStrings: tc.opts})
+		assertNoErr(t, err)
+		assertEqual(t, tc.want, expr.Evaluate(nav))
+	}
+}
+
+func TestStrictNumbers(t *testing.T) {
+	doc := createNode("", RootNode)
+	r := doc.createChildNode("r", ElementNode)
+	r.createChildNode("a", ElementNode).createChildNode("1", TextNode)
+	r.createChildNode("b", ElementNode).createChildNode("n/a", TextNode)
+	nav := createNavigator(doc)
+	strict := CompileOptions{StrictNumbers: true}
+	for expr, want := range map[string]interface{}{
+		`number(/r/a) + 1`:  float64(2),
+		`sum(/r/a)`:         float64(1),
+		`0 div 0 = 0 div 0`: false,
+	} {
+		e, err := CompileWithOptions(expr, strict)
+		assertNoErr(t, err)
+		v, err := e.EvaluateWithOptions(nav, EvalOptions{RecoverPanics: true})
+		assertNoErr(t, err)
+		assertEqual(t, want, v)
+	}
+	for _, expr := range []string{
+		`number(/r/b) > 1`,
+		`/r/b + 1`,
+		`number('x')`,
+		`sum(/r/*)`,
+		`number(/r/none)`,
+	} {
+		e, err := CompileWithOptions(expr, strict)
+		assertNoErr(t, err)
+		if _, err := e.EvaluateWithOptions(nav, EvalOptions{RecoverPanics: true}); !errors.Is(err, ErrNotANumber) {
+			t.Errorf("%s: got %v, want ErrNotANumber", expr, err)
+		}
+		// Without the option, the value is NaN.
+		_, err = MustCompile(expr).EvaluateWithOptions(nav, EvalOptions{RecoverPanics: true})
+		assertNoErr(t, err)
+	}
+}
+
+func TestNumericComparisonNotANumber(t *testing.T) {
+	doc := createNode("", RootNode)
+	r := doc.createChildNode("r", ElementNode)
+	r.createChildNode("p", ElementNode).addAttribute("price", "n/a")
+	r.createChildNode("p", ElementNode).addAttribute("price", "12")
+	nav := createNavigator(doc)
+	for expr, want := range map[string]float64{
+		`count(//p[@price > 10])`:  1,
+		`count(//p[@price < 100])`: 1,
+		`count(//p[@price = 12])`:  1,
+		`count(//p[10 < @price])`:  1,
+		`count(//p[@price != 12])`: 1,
+		`count(//p['x' < 1])`:      0,
+	} {
+		// The values that are not numbers are NaN.
+		assertEqual(t, want, MustCompile(expr).Evaluate(nav))
+		e, err := CompileWithOptions(expr, CompileOptions{StrictNumbers: true})
+		assertNoErr(t, err)
+		if _, err := e.EvaluateWithOptions(nav, EvalOptions{RecoverPanics: true}); !errors.Is(err, ErrNotANumber) {
+			t.Errorf("%s: got %v, want ErrNotANumber", expr, err)
+		}
+	}
+}
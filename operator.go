@@ -1,7 +1,7 @@
 package xpath
 
 import (
-	"strconv"
+	"math"
 )
 
 // The XPath number operator function list.
@@ -9,10 +9,10 @@ import (
 type logical func(iterator, string, interface{}, interface{}) bool
 
 var logicalFuncs = [][]logical{
-	{cmpBooleanBoolean, nil, nil, nil},
-	{nil, cmpNumericNumeric, cmpNumericString, cmpNumericNodeSet},
-	{nil, cmpStringNumeric, cmpStringString, cmpStringNodeSet},
-	{nil, cmpNodeSetNumeric, cmpNodeSetString, cmpNodeSetNodeSet},
+	{cmpBooleanBoolean, cmpBooleanAny, cmpBooleanAny, cmpBooleanAny},
+	{cmpAnyBoolean, cmpNumericNumeric, cmpNumericString, cmpNumericNodeSet},
+	{cmpAnyBoolean, cmpStringNumeric, cmpStringString, cmpStringNodeSet},
+	{cmpAnyBoolean, cmpNodeSetNumeric, cmpNodeSetString, cmpNodeSetNodeSet},
 }
 
 // number vs number
@@ -59,8 +59,30 @@ func cmpBooleanBooleanF(op string, a, b bool) bool {
 		return a || b
 	case "and":
 		return a && b
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
 	}
-	return false
+	return cmpNumberNumberF(op, boolNumber(a), boolNumber(b))
+}
+
+// boolNumber is the number of a boolean, 1 for true and 0 for false.
+func boolNumber(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// comparedNumber is the number of the value v compared with a boolean
+// by <, <=, > or >=: that of the boolean of a node-set, or else v
+// converted to a number.
+func comparedNumber(t iterator, v interface{}) float64 {
+	if _, ok := v.(query); ok {
+		return boolNumber(asBool(t, v))
+	}
+	return asNumber(t, v)
 }
 
 func cmpNumericNumeric(t iterator, op string, m, n interface{}) bool {
@@ -69,14 +91,22 @@ func cmpNumericNumeric(t iterator, op string, m, n interface{}) bool {
 	return cmpNumberNumberF(op, a, b)
 }
 
+// comparedString converts the string s, or the string-value of a node,
+// compared by op with a number, to a number: NaN if it is not one, or the
+// ErrNotANumber of strictNumber.
+func comparedString(t iterator, op, s string) float64 {
+	v, ok := parseNumber(t, s)
+	if !ok {
+		strictNumber(t, "the "+op+" comparison", s, false)
+		return math.NaN()
+	}
+	return v
+}
+
 func cmpNumericString(t iterator, op string, m, n interface{}) bool {
 	a := m.(float64)
 	b := n.(string)
-	num, err := strconv.ParseFloat(b, 64)
-	if err != nil {
-		panic(err)
-	}
-	return cmpNumberNumberF(op, a, num)
+	return cmpNumberNumberF(op, a, comparedString(t, op, b))
 }
 
 func cmpNumericNodeSet(t iterator, op string, m, n interface{}) bool {
@@ -88,11 +118,7 @@ func cmpNumericNodeSet(t iterator, op string, m, n interface{}) bool {
 		if node == nil {
 			break
 		}
-		num, err := strconv.ParseFloat(node.Value(), 64)
-		if err != nil {
-			panic(err)
-		}
-		if cmpNumberNumberF(op, a, num) {
+		if cmpNumberNumberF(op, a, comparedString(t, op, nodeValue(t, node))) {
 			return true
 		}
 	}
@@ -107,11 +133,7 @@ func cmpNodeSetNumeric(t iterator, op string, m, n interface{}) bool {
 		if node == nil {
 			break
 		}
-		num, err := strconv.ParseFloat(node.Value(), 64)
-		if err != nil {
-			panic(err)
-		}
-		if cmpNumberNumberF(op, num, b) {
+		if cmpNumberNumberF(op, comparedString(t, op, nodeValue(t, node)), b) {
 			return true
 		}
 	}
@@ -126,7 +148,7 @@ func cmpNodeSetString(t iterator, op string, m, n interface{}) bool {
 		if node == nil {
 			break
 		}
-		if cmpStringStringF(op, b, node.Value()) {
+		if cmpStringStringF(op, nodeValue(t, node), b) {
 			return true
 		}
 	}
@@ -148,7 +170,7 @@ func cmpNodeSetNodeSet(t iterator, op string, m, n interface{}) bool {
 		}
 
 		for {
-			if cmpStringStringF(op, x.Value(), y.Value()) {
+			if cmpStringStringF(op, nodeValue(t, x), nodeValue(t, y)) {
 				return true
 			}
 			if y = b.Select(t); y == nil {
@@ -163,11 +185,7 @@ func cmpNodeSetNodeSet(t iterator, op string, m, n interface{}) bool {
 func cmpStringNumeric(t iterator, op string, m, n interface{}) bool {
 	a := m.(string)
 	b := n.(float64)
-	num, err := strconv.ParseFloat(a, 64)
-	if err != nil {
-		panic(err)
-	}
-	return cmpNumberNumberF(op, b, num)
+	return cmpNumberNumberF(op, comparedString(t, op, a), b)
 }
 
 func cmpStringString(t iterator, op string, m, n interface{}) bool {
@@ -184,7 +202,7 @@ func cmpStringNodeSet(t iterator, op string, m, n interface{}) bool {
 		if node == nil {
 			break
 		}
-		if cmpStringStringF(op, a, node.Value()) {
+		if cmpStringStringF(op, a, nodeValue(t, node)) {
 			return true
 		}
 	}
@@ -197,6 +215,27 @@ func cmpBooleanBoolean(t iterator, op string, m, n interface{}) bool {
 	return cmpBooleanBooleanF(op, a, b)
 }
 
+// cmpBooleanAny compares a boolean with a number, a string or a node-set,
+// such as the result of the comparison with 'a' of 'a' = 'a' in
+// 'a' = 'a' = 'a': = and != convert the other value to a boolean, the
+// other operators convert both to numbers.
+func cmpBooleanAny(t iterator, op string, m, n interface{}) bool {
+	a := m.(bool)
+	if op == "=" || op == "!=" {
+		return cmpBooleanBooleanF(op, a, asBool(t, n))
+	}
+	return cmpNumberNumberF(op, boolNumber(a), comparedNumber(t, n))
+}
+
+// cmpAnyBoolean is cmpBooleanAny with the boolean on the right.
+func cmpAnyBoolean(t iterator, op string, m, n interface{}) bool {
+	b := n.(bool)
+	if op == "=" || op == "!=" {
+		return cmpBooleanBooleanF(op, asBool(t, m), b)
+	}
+	return cmpNumberNumberF(op, comparedNumber(t, m), boolNumber(b))
+}
+
 // eqFunc is an `=` operator.
 func eqFunc(t iterator, m, n interface{}) interface{} {
 	t1 := getXPathType(m)
@@ -283,6 +322,6 @@ var divFunc = func(t iterator, m, n interface{}) interface{} {
 // modFunc is an 'MOD' operator.
 var modFunc = func(t iterator, m, n interface{}) interface{} {
 	return numericExpr(t, m, n, func(a, b float64) float64 {
-		return float64(int(a) % int(b))
+		return math.Mod(a, b)
 	})
 }
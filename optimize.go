@@ -0,0 +1,296 @@
+package xpath
+
+import "sort"
+
+// pureFunctions are the functions whose result only depends on their
+// arguments, when they are called with at least one argument.
+var pureFunctions = map[string]bool{
+	"boolean":          true,
+	"ceiling":          true,
+	"compare":          true,
+	"concat":           true,
+	"contains":         true,
+	"ends-with":        true,
+	"floor":            true,
+	"lower-case":       true,
+	"normalize-space":  true,
+	"not":              true,
+	"number":           true,
+	"replace":          true,
+	"round":            true,
+	"starts-with":      true,
+	"string":           true,
+	"string-length":    true,
+	"substring":        true,
+	"substring-after":  true,
+	"substring-before": true,
+	"translate":        true,
+}
+
+// optimize simplifies the expression tree n before it is built: constant
+// sub-expressions and calls of pure functions with constant arguments are
+// computed, predicates that are always true are removed, and not(not(e))
+// becomes boolean(e). The calls are computed with the behaviors of the
+// options.
+func optimize(n node, opts CompileOptions) node {
+	switch n := n.(type) {
+	case *operatorNode:
+		n.Left = optimize(n.Left, opts)
+		n.Right = optimize(n.Right, opts)
+		if n.Op == "|" || !isConstant(n.Left) || !isConstant(n.Right) {
+			break
+		}
+		if n.Op == "and" || n.Op == "or" {
+			// Boolean operators need a context node to evaluate.
+			left, right := asBool(nil, constantValue(n.Left)), asBool(nil, constantValue(n.Right))
+			if n.Op == "and" {
+				return newBoolNode(left && right)
+			}
+			return newBoolNode(left || right)
+		}
+		return fold(n, opts)
+	case *functionNode:
+		for i, arg := range n.Args {
+			n.Args[i] = optimize(arg, opts)
+		}
+		if n.Prefix != "" {
+			break
+		}
+		if n.FuncName == "not" && len(n.Args) == 1 {
+			if arg, ok := n.Args[0].(*functionNode); ok && arg.FuncName == "not" && arg.Prefix == "" && len(arg.Args) == 1 {
+				return optimize(newFunctionNode("boolean", "", arg.Args), opts)
+			}
+		}
+		if !pureFunctions[n.FuncName] || len(n.Args) == 0 {
+			break
+		}
+		for _, arg := range n.Args {
+			if !isConstant(arg) {
+				return n
+			}
+		}
+		return fold(n, opts)
+	case *groupNode:
+		n.Input = optimize(n.Input, opts)
+		if isConstant(n.Input) {
+			return n.Input
+		}
+	case *filterNode:
+		n.Input = optimize(n.Input, opts)
+		n.Condition = optimize(n.Condition, opts)
+		if isTrue(n.Condition) {
+			return n.Input
+		}
+	case *axisNode:
+		if n.Input != nil {
+			n.Input = optimize(n.Input, opts)
+		}
+	}
+	return n
+}
+
+// isConstant reports whether n is a literal, true() or false().
+func isConstant(n node) bool {
+	switch n := n.(type) {
+	case *operandNode:
+		return true
+	case *functionNode:
+		return (n.FuncName == "true" || n.FuncName == "false") && n.Prefix == "" && len(n.Args) == 0
+	}
+	return false
+}
+
+// isTrue reports whether n is a predicate that holds for every node. A
+// number is a position, so it is not.
+func isTrue(n node) bool {
+	switch n := n.(type) {
+	case *operandNode:
+		s, ok := n.Val.(string)
+		return ok && s != ""
+	case *functionNode:
+		return n.FuncName == "true" && n.Prefix == "" && len(n.Args) == 0
+	}
+	return false
+}
+
+// fold returns the constant value of n, or n itself if it cannot be
+// computed at compile time, for example because it fails; the error is
+// then reported at runtime as before.
+func fold(n node, opts CompileOptions) (folded node) {
+	defer func() {
+		if recover() != nil {
+			folded = n
+		}
+	}()
+	props := builderProps.None
+	q, err := (&builder{compat: opts.Compat, compareDates: opts.CompareDates}).processNode(n, flagsEnum.None, &props)
+	if err != nil {
+		return n
+	}
+	var t iterator
+	if opts.evaluated() {
+		lim := newLimiter(EvalOptions{})
+		lim.setOptions(opts)
+		t = &NodeIterator{lim: lim}
+	}
+	switch v := q.Evaluate(t).(type) {
+	case float64, string:
+		return newOperandNode(v)
+	case bool:
+		return newBoolNode(v)
+	}
+	return n
+}
+
+// constantValue returns the value of the constant n.
+func constantValue(n node) interface{} {
+	if n, ok := n.(*operandNode); ok {
+		return n.Val
+	}
+	return n.(*functionNode).FuncName == "true"
+}
+
+// newBoolNode returns a call of true() or false().
+func newBoolNode(v bool) node {
+	if v {
+		return newFunctionNode("true", "", nil)
+	}
+	return newFunctionNode("false", "", nil)
+}
+
+// reorderPredicates sorts the predicates of each step of n by their
+// estimated cost. Predicates are only moved within runs of predicates that
+// do not depend on the position and cannot fail, and the operands of and
+// and or are left in their order, since the left one may guard the right
+// one, as in @price != "" and @price > 10 with StrictNumbers.
+func reorderPredicates(n node, opts CompileOptions) node {
+	switch n := n.(type) {
+	case *operatorNode:
+		n.Left = reorderPredicates(n.Left, opts)
+		n.Right = reorderPredicates(n.Right, opts)
+	case *functionNode:
+		for i, arg := range n.Args {
+			n.Args[i] = reorderPredicates(arg, opts)
+		}
+	case *groupNode:
+		n.Input = reorderPredicates(n.Input, opts)
+	case *axisNode:
+		if n.Input != nil {
+			n.Input = reorderPredicates(n.Input, opts)
+		}
+	case *filterNode:
+		// step[c1][c2]... is parsed as filter(filter(step, c1), c2).
+		var conds []node
+		var input node = n
+		for f, ok := input.(*filterNode); ok; f, ok = input.(*filterNode) {
+			conds = append([]node{reorderPredicates(f.Condition, opts)}, conds...)
+			input = f.Input
+		}
+		input = reorderPredicates(input, opts)
+		for i := 0; i < len(conds); {
+			j := i
+			for j < len(conds) && !positionalPredicate(conds[j]) && !mayFail(conds[j], opts) {
+				j++
+			}
+			run := conds[i:j]
+			sort.SliceStable(run, func(a, b int) bool { return estimateCost(run[a]) < estimateCost(run[b]) })
+			i = j + 1
+		}
+		for _, cond := range conds {
+			input = newFilterNode(input, cond)
+		}
+		return input
+	}
+	return n
+}
+
+// mayFail reports whether the evaluation of n may stop with an error,
+// which the predicates before it can avoid by filtering the nodes out: n
+// reads a document, matches a pattern or uses a collation that is not a
+// constant, calls a function that is not built in, or converts a value to
+// a number with StrictNumbers.
+func mayFail(n node, opts CompileOptions) bool {
+	switch n := n.(type) {
+	case *operatorNode:
+		switch n.Op {
+		case "and", "or", "|":
+		default:
+			if opts.StrictNumbers {
+				return true
+			}
+		}
+		return mayFail(n.Left, opts) || n.Right != nil && mayFail(n.Right, opts)
+	case *functionNode:
+		if n.Prefix != "" || !builtinFunctions[n.FuncName] {
+			return true
+		}
+		constants := 0
+		switch n.FuncName {
+		case "doc", "document", "unparsed-text":
+			return true
+		case "number", "sum", "floor", "ceiling", "round", "substring":
+			if opts.StrictNumbers {
+				return true
+			}
+		case "matches", "replace":
+			// The pattern, and the replacement and the flags.
+			constants = 1
+		case "compare", "contains", "starts-with", "ends-with":
+			// The collation.
+			constants = 2
+		}
+		for i, arg := range n.Args {
+			if _, ok := arg.(*operandNode); constants > 0 && i >= constants && !ok {
+				return true
+			}
+			if mayFail(arg, opts) {
+				return true
+			}
+		}
+	case *axisNode:
+		return n.Input != nil && mayFail(n.Input, opts)
+	case *filterNode:
+		return mayFail(n.Input, opts) || mayFail(n.Condition, opts)
+	case *groupNode:
+		return mayFail(n.Input, opts)
+	}
+	return false
+}
+
+// estimateCost returns the relative cost of evaluating n for one node.
+func estimateCost(n node) int {
+	switch n := n.(type) {
+	case *operatorNode:
+		return 1 + estimateCost(n.Left) + estimateCost(n.Right)
+	case *functionNode:
+		cost := 2
+		switch n.FuncName {
+		case "matches", "replace", "tokenize":
+			cost = 30
+		}
+		for _, arg := range n.Args {
+			cost += estimateCost(arg)
+		}
+		return cost
+	case *axisNode:
+		cost := 0
+		if n.Input != nil {
+			cost = estimateCost(n.Input)
+		}
+		switch n.AxisType {
+		case "self", "attribute", "parent":
+			return cost + 1
+		case "child", "following-sibling", "preceding-sibling":
+			return cost + 5
+		}
+		return cost + 50
+	case *filterNode:
+		return estimateCost(n.Input) + 2*estimateCost(n.Condition)
+	case *groupNode:
+		return estimateCost(n.Input)
+	case *rootNode:
+		// An absolute path is evaluated from the root for each node.
+		return 50
+	}
+	return 0
+}
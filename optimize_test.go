@@ -0,0 +1,118 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestOptimizeConstants(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want interface{}
+	}{
+		{`1 + 2 * 3`, float64(7)},
+		{`-(2 - 5)`, float64(3)},
+		{`concat("a", "b", string(1 + 1))`, "ab2"},
+		{`string-length(concat("ab", "c"))`, float64(3)},
+		{`lower-case(substring("HELLO", 2, 3))`, "ell"},
+		{`1 < 2 and "a" = "a"`, true},
+		{`not(true())`, false},
+		{`(1 + 1)`, float64(2)},
+	} {
+		q := MustCompile(tc.expr).q
+		c, ok := q.(*constantQuery)
+		if !ok {
+			if f, ok := q.(*functionQuery); !ok || f.Input != nil {
+				t.Errorf("%s: expected a constant, got %T", tc.expr, q)
+			}
+		} else {
+			assertEqual(t, tc.want, c.Val)
+		}
+		test_xpath_eval(t, empty_example, tc.expr, tc.want)
+	}
+	if v := MustCompile(`0 div 0`).Evaluate(createNavigator(empty_example)).(float64); !math.IsNaN(v) {
+		t.Errorf("expected NaN, got %v", v)
+	}
+}
+
+func TestOptimizePredicates(t *testing.T) {
+	q := MustCompile(`//book[true()]["web"]`).q
+	if _, ok := q.(*descendantQuery); !ok {
+		t.Errorf("expected the predicates to be removed, got %T", q)
+	}
+	test_xpath_count(t, book_example, `//book[true()]["web"]`, 4)
+	test_xpath_count(t, book_example, `//book[false()]`, 0)
+	test_xpath_count(t, book_example, `//book[""]`, 0)
+	test_xpath_count(t, book_example, `//book[1 + 1]`, 1)
+	test_xpath_count(t, book_example, `//book[not(not(@category = "web"))]`, 2)
+	test_xpath_eval(t, book_example, `not(not(//book))`, true)
+	test_xpath_eval(t, book_example, `not(not(//nothing))`, false)
+}
+
+func TestOptimizeKeepsRuntimeErrors(t *testing.T) {
+	// Calls that fail are not folded, and fail when evaluated as before.
+	expr, err := Compile(`sum(concat("a", "x"))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertPanic(t, func() { expr.Evaluate(createNavigator(empty_example)) })
+}
+
+func TestReorderPredicates(t *testing.T) {
+	for _, tc := range []struct {
+		expr, want string
+	}{
+		{`//book[.//author = "x"][@category = "web"]`, `child::book[attribute::category=web][child::author=x]`},
+		{`//book[matches(title, "^X")][@category]`, `child::book[attribute::category][matches(child::title,^X)]`},
+		{`//book[.//author][1][@category]`, `child::book[child::author][1][attribute::category]`},
+		{`//book[title and @category]`, `child::book[child::titleandattribute::category]`},
+	} {
+		s := fmt.Sprint(reorderPredicates(optimize(parse(tc.expr, nil, nil), CompileOptions{}), CompileOptions{}))
+		if s != tc.want {
+			t.Errorf("%s: expected %s, got %s", tc.expr, tc.want, s)
+		}
+	}
+
+	for _, expr := range []string{
+		`//book[.//author = "Per Bothner"][@category = "web"]`,
+		`//book[matches(title, "^X")][@category]`,
+		`//book[author][2][@category = "web"]`,
+		`//book[price > 30][last()][title]`,
+		`//book[year = 2005 and @category = "children"]/title`,
+		`//author[position() > 1][contains(., "a")][1]`,
+	} {
+		exp, err := CompileWithOptions(expr, CompileOptions{ReorderPredicates: true})
+		assertNoErr(t, err)
+		want := selectNodes(book_example, expr)
+		var got []*TNode
+		for iter := exp.Select(createNavigator(book_example)); iter.MoveNext(); {
+			got = append(got, iter.Current().(*TNodeNavigator).curr)
+		}
+		if len(got) != len(want) {
+			t.Errorf("%s: expected %d nodes, got %d", expr, len(want), len(got))
+			continue
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Errorf("%s: node %d differs", expr, i)
+			}
+		}
+	}
+
+	// The predicates and the operands that guard those that can fail are
+	// kept before them.
+	doc := parseXML(`<r><b price="5">x</b><b price="">z</b><b price="20">y</b><b price="8">y</b></r>`)
+	for _, expr := range []string{
+		`//b[normalize-space(@price) != '' and @price > 10]`,
+		`//b[contains(., 'y') and @price > 10]`,
+		`//b[starts-with(., 'y')][@price > 10]`,
+	} {
+		exp, err := CompileWithOptions(expr, CompileOptions{ReorderPredicates: true, StrictNumbers: true})
+		assertNoErr(t, err)
+		assertEqual(t, 1, len(iterateNodes(exp.Select(createNavigator(doc)))))
+	}
+	exp, err := CompileWithOptions(`-/descendant::* and doc(.)`, CompileOptions{ReorderPredicates: true})
+	assertNoErr(t, err)
+	assertEqual(t, false, exp.Evaluate(createNavigator(doc)))
+}
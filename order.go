@@ -0,0 +1,672 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// nodeOrder is what is known of the order of the nodes a query selects
+// when it is built, before it is evaluated.
+type nodeOrder struct {
+	sorted   bool // in document order, without duplicates
+	unique   bool // without duplicates
+	single   bool // at most one node
+	disjoint bool // no node is a descendant of another
+	inside   bool // in the subtree of the context node
+	// children are the children or attributes of the nodes of a sorted,
+	// unique and disjoint node-set, or the attributes of those of a sorted
+	// and unique one, those of each node together.
+	children bool
+	// grouped is sorted but for nodes repeated right after themselves.
+	grouped bool
+}
+
+// orderOf returns the order of the nodes q selects from a single context
+// node. Most paths are known to select their nodes in document order, for
+// the others the nodes are sorted, see documentOrder.
+func orderOf(q query) nodeOrder {
+	switch q := q.(type) {
+	case *contextQuery:
+		return nodeOrder{sorted: true, unique: true, single: true, disjoint: true, inside: true}
+	case *absoluteQuery:
+		return nodeOrder{sorted: true, unique: true, single: true, disjoint: true}
+	case *childQuery:
+		return childOrder(orderOf(q.Input))
+	case *cachedChildQuery:
+		return childOrder(orderOf(q.Input))
+	case *orderedChildQuery:
+		in := orderOf(q.Input)
+		o := childOrder(in)
+		o.sorted = in.sorted
+		return o
+	case *attributeQuery:
+		// The attributes of an element come after it and before its
+		// children, so they are together by element even if the elements
+		// are nested.
+		in := orderOf(q.Input)
+		return nodeOrder{sorted: in.sorted, unique: in.unique, disjoint: true, inside: in.inside, children: in.sorted && in.unique}
+	case *hasChildQuery:
+		if q.Descendants {
+			return descendantOrder(orderOf(q.Input))
+		}
+		// Some of the nodes of Input, in the same order.
+		in := orderOf(q.Input)
+		return nodeOrder{sorted: in.sorted, unique: in.unique, single: in.single, disjoint: in.disjoint, inside: in.inside}
+	case *selfQuery:
+		return orderOf(q.Input)
+	case *filterQuery:
+		return orderOf(q.Input)
+	case *lastQuery:
+		return orderOf(q.Input)
+	case *indexQuery:
+		return orderOf(q.Input)
+	case *groupQuery:
+		return orderOf(q.Input)
+	case *descendantQuery:
+		return descendantOrder(orderOf(q.Input))
+	case *descendantOverDescendantQuery:
+		return descendantOrder(orderOf(q.Input))
+	case *followingQuery:
+		in := orderOf(q.Input)
+		return nodeOrder{sorted: in.single, unique: in.single, disjoint: in.single && q.Sibling}
+	case *precedingQuery:
+		// The nodes are selected in reverse document order.
+		in := orderOf(q.Input)
+		return nodeOrder{unique: in.single, disjoint: in.single && q.Sibling}
+	case *parentQuery:
+		in := orderOf(q.Input)
+		if in.children && !in.single {
+			// The parents are the nodes the children were selected from,
+			// which may be nested for attributes.
+			return nodeOrder{grouped: true, inside: in.inside}
+		}
+		return nodeOrder{sorted: in.single, unique: in.single, single: in.single, disjoint: in.single}
+	case *ancestorQuery:
+		// The nodes are selected in reverse document order, each of them
+		// once unless they are selected again for each context node.
+		return nodeOrder{unique: !q.Repeat}
+	case *mergeQuery:
+		in, child := orderOf(q.Input), orderOf(q.Child)
+		return nodeOrder{
+			sorted:   in.sorted && in.disjoint && child.sorted && child.inside,
+			unique:   in.unique && in.disjoint && child.unique && child.inside,
+			single:   in.single && child.single,
+			disjoint: in.disjoint && child.disjoint && child.inside,
+			inside:   in.inside && child.inside,
+		}
+	case *unionQuery:
+		return nodeOrder{sorted: true, unique: true}
+	case *documentOrderQuery:
+		return nodeOrder{sorted: true, unique: true}
+	}
+	return nodeOrder{}
+}
+
+// childOrder is the order of the children of nodes in the order in.
+func childOrder(in nodeOrder) nodeOrder {
+	// The children of a node come before the nodes after it, but not
+	// before its own descendants.
+	return nodeOrder{
+		sorted:   in.sorted && in.disjoint,
+		unique:   in.unique,
+		disjoint: in.disjoint,
+		inside:   in.inside,
+		children: parentsOrder(in),
+	}
+}
+
+// parentsOrder reports whether the children of nodes in the order in
+// are together by parent, as nodeOrder.children.
+func parentsOrder(in nodeOrder) bool {
+	return in.sorted && in.unique && in.disjoint
+}
+
+// descendantOrder is the order of the descendants of nodes in the order in.
+func descendantOrder(in nodeOrder) nodeOrder {
+	return nodeOrder{
+		sorted: in.sorted && in.disjoint,
+		unique: in.unique && in.disjoint,
+		inside: in.inside,
+	}
+}
+
+// documentOrder returns q, or a query selecting the nodes of q in document
+// order without duplicates if q is a node-set that may not be, such as
+// //*/../.. or ancestor::*. If unique is true, the nodes only need to be
+// without duplicates, as for count().
+func documentOrder(q query, unique bool) query {
+	if q.ValueType() != xpathResultType.NodeSet {
+		return q
+	}
+	o := orderOf(q)
+	if o.sorted || unique && o.unique {
+		return q
+	}
+	return &documentOrderQuery{Input: q, adjacent: o.grouped}
+}
+
+// documentOrderQuery selects the nodes of Input in document order without
+// duplicates.
+type documentOrderQuery struct {
+	Input query
+	// adjacent is set if the nodes of Input are grouped, see nodeOrder;
+	// they are then only compared with the previous one.
+	adjacent bool
+	partial  bool // the node-set of the result, see markPartial
+
+	nodes  []orderedNode
+	sorted bool
+	prev   cursor
+	seen   bool // prev is the previous node of Input
+}
+
+func (d *documentOrderQuery) Select(t iterator) NodeNavigator {
+	if d.adjacent {
+		for {
+			node := d.Input.Select(t)
+			if node == nil {
+				return nil
+			}
+			if d.seen && (&orderedNode{node: d.prev.nav}).compare(&orderedNode{node: node}) == 0 {
+				continue
+			}
+			d.prev.moveTo(node)
+			d.seen = true
+			return node
+		}
+	}
+	if !d.sorted {
+		nodes := orderedNodes
+		if d.partial {
+			nodes = partialNodes
+		}
+		d.nodes, d.sorted = nodes(d.Input, t, true), true
+	}
+	if len(d.nodes) == 0 {
+		return nil
+	}
+	node := d.nodes[0].node
+	d.nodes = d.nodes[1:]
+	return node
+}
+
+func (d *documentOrderQuery) exists(t iterator) bool {
+	return exists(d.Input, t)
+}
+
+func (d *documentOrderQuery) Evaluate(t iterator) interface{} {
+	d.Input.Evaluate(t)
+	d.nodes, d.sorted, d.seen = nil, false, false
+	return d
+}
+
+func (d *documentOrderQuery) Clone() query {
+	return &documentOrderQuery{Input: d.Input.Clone(), adjacent: d.adjacent}
+}
+
+func (d *documentOrderQuery) ValueType() resultType {
+	return xpathResultType.NodeSet
+}
+
+func (d *documentOrderQuery) Properties() queryProp {
+	return queryProps.Merge
+}
+
+// first returns the first node of Input in document order, keeping only
+// the first one found so far rather than sorting them all.
+func (d *documentOrderQuery) first(t iterator) NodeNavigator {
+	if d.adjacent {
+		return d.Input.Select(t)
+	}
+	var first *orderedNode
+	for node := d.Input.Select(t); node != nil; node = d.Input.Select(t) {
+		n := &orderedNode{node: node.Copy()}
+		if first == nil || n.compare(first) < 0 {
+			first = n
+		}
+	}
+	if first == nil {
+		return nil
+	}
+	return first.node
+}
+
+// orderedChildQuery is the child axis over an Input that selects its nodes
+// in document order but may select nodes inside others, as //a/b. The
+// children of a node are then selected until a child comes after the next
+// node of Input, whose children come first, so that the children are in
+// document order without being collected.
+type orderedChildQuery struct {
+	name  string
+	posit int
+
+	// frames are the nodes whose children are being selected, the
+	// innermost last; frames[depth:] are kept for their cursors.
+	frames []childFrame
+	depth  int
+	// next is the next node of Input, selected ahead if peeked.
+	next    cursor
+	hasNext bool
+	peeked  bool
+	// outside is the index of the first frame next is known to be after
+	// the subtree of, or -1.
+	outside int
+
+	Input     query
+	Predicate func(NodeNavigator) bool
+}
+
+// A childFrame is a node of the Input of an orderedChildQuery whose
+// children are being selected.
+type childFrame struct {
+	node  cursor // on the node, then on its current child
+	end   cursor // on the first node after the subtree of the node
+	ended bool   // the node has no nodes after its subtree
+	first bool   // node has not moved to a child yet
+	ready bool   // node is on a child that was not selected yet
+	posit int
+}
+
+func (c *orderedChildQuery) Select(t iterator) NodeNavigator {
+	for {
+		if c.depth > 0 && !c.frames[c.depth-1].ready && !c.nextChild(&c.frames[c.depth-1]) {
+			c.depth--
+			continue
+		}
+		if !c.peeked {
+			c.peeked, c.hasNext, c.outside = true, false, -1
+			if node := c.Input.Select(t); node != nil {
+				c.next.moveTo(node)
+				c.hasNext = true
+			}
+		}
+		if c.hasNext && (c.depth == 0 || c.before(c.depth-1)) {
+			c.push(c.next.nav)
+			c.peeked = false
+			continue
+		}
+		if c.depth == 0 {
+			return nil
+		}
+		f := &c.frames[c.depth-1]
+		f.ready = false
+		f.posit++
+		c.posit = f.posit
+		return f.node.nav
+	}
+}
+
+// before reports whether the next node of Input comes before the current
+// child of frames[i].
+func (c *orderedChildQuery) before(i int) bool {
+	if c.outside >= 0 && i >= c.outside {
+		return false
+	}
+	f := &c.frames[i]
+	next := &orderedNode{node: c.next.nav}
+	if f.ended || next.compare(&orderedNode{node: f.end.nav}) < 0 {
+		return next.compare(&orderedNode{node: f.node.nav}) < 0
+	}
+	c.outside = i
+	return false
+}
+
+// push adds a frame for the children of node.
+func (c *orderedChildQuery) push(node NodeNavigator) {
+	if c.depth == len(c.frames) {
+		c.frames = append(c.frames, childFrame{})
+	}
+	f := &c.frames[c.depth]
+	c.depth++
+	f.node.moveTo(node)
+	f.first, f.ready, f.posit = true, false, 0
+	end := f.end.moveTo(node)
+	for f.ended = true; ; {
+		if end.MoveToNext() {
+			f.ended = false
+			break
+		}
+		if !end.MoveToParent() {
+			break
+		}
+	}
+	// The frames below are not known to be before the node.
+	if c.outside >= c.depth-1 {
+		c.outside = -1
+	}
+}
+
+// nextChild moves f to its next child matching the predicate.
+func (c *orderedChildQuery) nextChild(f *childFrame) bool {
+	node := f.node.nav
+	for {
+		if (f.first && !node.MoveToChild()) || (!f.first && !node.MoveToNext()) {
+			return false
+		}
+		f.first = false
+		if c.Predicate(node) {
+			f.ready = true
+			return true
+		}
+	}
+}
+
+func (c *orderedChildQuery) Evaluate(t iterator) interface{} {
+	c.Input.Evaluate(t)
+	c.depth, c.peeked = 0, false
+	return c
+}
+
+func (c *orderedChildQuery) position() int {
+	return c.posit
+}
+
+func (c *orderedChildQuery) Test(n NodeNavigator) bool {
+	return c.Predicate(n)
+}
+
+// skipContext skips the other children of the node of the last child
+// selected.
+func (c *orderedChildQuery) skipContext() {
+	if c.depth > 0 {
+		c.depth--
+	}
+}
+
+func (c *orderedChildQuery) Clone() query {
+	return &orderedChildQuery{name: c.name, Input: c.Input.Clone(), Predicate: c.Predicate}
+}
+
+func (c *orderedChildQuery) ValueType() resultType {
+	return xpathResultType.NodeSet
+}
+
+func (c *orderedChildQuery) Properties() queryProp {
+	return queryProps.Merge
+}
+
+// hasChildQuery is the parent axis over the child or descendant axis, as
+// a/b/.. or //b/..: it selects the nodes of Input, or with Descendants those
+// of their subtrees, that have a child the step would select. The parents
+// of the children would be repeated, and out of document order over the
+// descendant axis, while these are selected as Input or its descendants
+// are, without being collected.
+type hasChildQuery struct {
+	// node is on the node of Input or the descendant tested while active.
+	node   cursor
+	child  cursor
+	level  int
+	active bool
+	first  bool
+
+	Descendants bool
+	Input       query
+	Child       func(NodeNavigator) bool // the predicate of the step
+	Predicate   func(NodeNavigator) bool
+}
+
+func (h *hasChildQuery) Select(t iterator) NodeNavigator {
+	for {
+		if !h.active {
+			node := h.Input.Select(t)
+			if node == nil {
+				return nil
+			}
+			h.node.moveTo(node)
+			h.level = 0
+			h.active, h.first = true, true
+		}
+		if node := h.next(); node != nil {
+			return node
+		}
+		h.active = false
+	}
+}
+
+// next returns the next node with a child among the node of Input and,
+// with Descendants, its descendants, or nil.
+func (h *hasChildQuery) next() NodeNavigator {
+	node := h.node.nav
+	if h.first {
+		h.first = false
+		if h.Predicate(node) && h.hasChild(node) {
+			return node
+		}
+	}
+	if !h.Descendants {
+		return nil
+	}
+	for {
+		if node.MoveToChild() {
+			h.level++
+		} else {
+			for {
+				if h.level == 0 {
+					return nil
+				}
+				if node.MoveToNext() {
+					break
+				}
+				node.MoveToParent()
+				h.level--
+			}
+		}
+		if h.Predicate(node) && h.hasChild(node) {
+			return node
+		}
+	}
+}
+
+func (h *hasChildQuery) hasChild(node NodeNavigator) bool {
+	child := h.child.moveTo(node)
+	for ok := child.MoveToChild(); ok; ok = child.MoveToNext() {
+		if h.Child(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// position returns 1, as the parent is the only node of the axis.
+func (h *hasChildQuery) position() int {
+	return 1
+}
+
+func (h *hasChildQuery) Evaluate(t iterator) interface{} {
+	h.Input.Evaluate(t)
+	h.active = false
+	return h
+}
+
+func (h *hasChildQuery) Test(n NodeNavigator) bool {
+	return h.Predicate(n)
+}
+
+func (h *hasChildQuery) Clone() query {
+	return &hasChildQuery{Descendants: h.Descendants, Input: h.Input.Clone(), Child: h.Child, Predicate: h.Predicate}
+}
+
+func (h *hasChildQuery) ValueType() resultType {
+	return xpathResultType.NodeSet
+}
+
+func (h *hasChildQuery) Properties() queryProp {
+	return queryProps.Position | queryProps.Count | queryProps.Cached | queryProps.Merge
+}
+
+// verifyQuery checks that the nodes of a query are in document order, for
+// EvalOptions.VerifyOrder, and panics with an *OrderError otherwise.
+type verifyQuery struct {
+	query
+	expr string
+	op   string // the label of the query in the plan
+
+	prev cursor
+	seen bool // prev is the previous node selected
+}
+
+func (q *verifyQuery) Select(t iterator) NodeNavigator {
+	node := q.query.Select(t)
+	if node == nil {
+		return nil
+	}
+	if q.seen {
+		if c := (&orderedNode{node: q.prev.nav}).compare(&orderedNode{node: node}); c >= 0 {
+			panic(&OrderError{Expr: q.expr, Op: q.op, Duplicate: c == 0})
+		}
+	}
+	q.prev.moveTo(node)
+	q.seen = true
+	return node
+}
+
+func (q *verifyQuery) Evaluate(t iterator) interface{} {
+	q.seen = false
+	v := q.query.Evaluate(t)
+	if v == interface{}(q.query) {
+		// The node-set is the query itself, it is selected through q.
+		return q
+	}
+	return v
+}
+
+func (q *verifyQuery) Clone() query {
+	return &verifyQuery{query: q.query.Clone(), expr: q.expr, op: q.op}
+}
+
+func (q *verifyQuery) position() int {
+	return getNodePosition(q.query)
+}
+
+func (q *verifyQuery) hasPosition() bool {
+	return hasPosition(q.query)
+}
+
+// Test is the node test of q, for position() and last(), see predicate.
+func (q *verifyQuery) Test(n NodeNavigator) bool {
+	return predicate(q.query)(n)
+}
+
+func (q *verifyQuery) skipContext() {
+	if s, ok := q.query.(contextSkipper); ok {
+		s.skipContext()
+	}
+}
+
+// comparingNavigator checks each comparison of its nodes against their
+// positions among their siblings, for EvalOptions.VerifyComparisons, and
+// panics with a *ComparisonError if they disagree.
+type comparingNavigator struct {
+	NodeNavigator
+	expr string
+}
+
+// Unwrap returns the underlying navigator.
+func (c *comparingNavigator) Unwrap() NodeNavigator {
+	return c.NodeNavigator
+}
+
+func (c *comparingNavigator) NamespaceURL() string {
+	return navNamespaceURL(c.NodeNavigator)
+}
+
+func (c *comparingNavigator) Copy() NodeNavigator {
+	return &comparingNavigator{NodeNavigator: c.NodeNavigator.Copy(), expr: c.expr}
+}
+
+func (c *comparingNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*comparingNavigator); ok {
+		other = o.NodeNavigator
+	}
+	return c.NodeNavigator.MoveTo(other)
+}
+
+func (c *comparingNavigator) lookupAttr(prefix, localName, value string) ([]indexedNode, bool) {
+	ix, ok := c.NodeNavigator.(attrIndexer)
+	if !ok {
+		return nil, false
+	}
+	list, ok := ix.lookupAttr(prefix, localName, value)
+	for i := range list {
+		list[i].node = &comparingNavigator{NodeNavigator: list[i].node, expr: c.expr}
+	}
+	return list, ok
+}
+
+// ComparePosition compares the positions of the nodes, found by walking
+// up from them, with the result of the ComparePosition of the navigator
+// if it has one and it compares them. The positions are returned, as the
+// engine compares them otherwise.
+func (c *comparingNavigator) ComparePosition(other NodeNavigator) (int, bool) {
+	o, ok := other.(*comparingNavigator)
+	if !ok {
+		return 0, false
+	}
+	got, compared := 0, false
+	if d, ok := c.NodeNavigator.(DocumentOrderNavigator); ok {
+		got, compared = d.ComparePosition(o.NodeNavigator)
+	}
+	want := compareOrderKeys(c.orderKey(o), o.orderKey(c))
+	if compared && got != want {
+		panic(c.errorf(o, "ComparePosition returns %d, their positions %d", got, want))
+	}
+	return want, true
+}
+
+// orderKey returns the nodeOrderKey of the node, checked by walking down to
+// it from the root with MoveToChild, MoveToNext and MoveToNextAttribute.
+// other is the node it is compared with, for the error.
+func (c *comparingNavigator) orderKey(other *comparingNavigator) []int {
+	key := nodeOrderKey(c.NodeNavigator.Copy())
+	n := c.NodeNavigator.Copy()
+	n.MoveToRoot()
+	for _, k := range key {
+		moved := false
+		if k < 0 {
+			moved = n.MoveToNextAttribute()
+			for k -= math.MinInt32; moved && k > 0; k-- {
+				moved = n.MoveToNextAttribute()
+			}
+		} else {
+			moved = n.MoveToChild()
+			for ; moved && k > 0; k-- {
+				moved = n.MoveToNext()
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	m := c.NodeNavigator
+	if n.NodeType() != m.NodeType() || n.Prefix() != m.Prefix() || n.LocalName() != m.LocalName() || n.Value() != m.Value() {
+		panic(c.errorf(other, "walking down from the root to its position %s reaches another node", formatOrderKey(key)))
+	}
+	return key
+}
+
+// formatOrderKey returns the positions of a nodeOrderKey from 1, as in
+// "/1/3/@2" for the second attribute of the third child of the first child
+// of the root.
+func formatOrderKey(key []int) string {
+	var b strings.Builder
+	for _, k := range key {
+		if k < 0 {
+			fmt.Fprintf(&b, "/@%d", k-math.MinInt32+1)
+		} else {
+			fmt.Fprintf(&b, "/%d", k+1)
+		}
+	}
+	if b.Len() == 0 {
+		return "/"
+	}
+	return b.String()
+}
+
+func (c *comparingNavigator) errorf(other *comparingNavigator, format string, args ...interface{}) *ComparisonError {
+	return &ComparisonError{
+		Expr:  c.expr,
+		Nodes: [2]string{NodePath(c.NodeNavigator), NodePath(other.NodeNavigator)},
+		Msg:   fmt.Sprintf(format, args...),
+	}
+}
@@ -2,9 +2,10 @@ package xpath
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 	"unicode"
 	"unicode/utf8"
 )
@@ -46,6 +47,52 @@ const (
 	itemEOF                        // END
 )
 
+// String describes the item in syntax errors.
+func (t itemType) String() string {
+	switch t {
+	case itemName:
+		return "name"
+	case itemString:
+		return "string"
+	case itemNumber:
+		return "number"
+	case itemAxe:
+		return "axis"
+	case itemEOF:
+		return "end of expression"
+	}
+	return itemTokens[t]
+}
+
+var itemTokens = [...]string{
+	itemComma:      "','",
+	itemSlash:      "'/'",
+	itemAt:         "'@'",
+	itemDot:        "'.'",
+	itemLParens:    "'('",
+	itemRParens:    "')'",
+	itemLBracket:   "'['",
+	itemRBracket:   "']'",
+	itemStar:       "'*'",
+	itemPlus:       "'+'",
+	itemMinus:      "'-'",
+	itemEq:         "'='",
+	itemLt:         "'<'",
+	itemGt:         "'>'",
+	itemBang:       "'!'",
+	itemDollar:     "'$'",
+	itemApos:       `"'"`,
+	itemQuote:      `'"'`,
+	itemUnion:      "'|'",
+	itemNe:         "'!='",
+	itemLe:         "'<='",
+	itemGe:         "'>='",
+	itemAnd:        "'&&'",
+	itemOr:         "'||'",
+	itemDotDot:     "'..'",
+	itemSlashSlash: "'//'",
+}
+
 // A node is an XPath node in the parse tree.
 type node interface {
 	Type() nodeType
@@ -73,33 +120,22 @@ type parser struct {
 	r          *scanner
 	d          int
 	namespaces map[string]string
+	arena      *nodeArena
 }
 
 // newOperatorNode returns new operator node OperatorNode.
 func newOperatorNode(op string, left, right node) node {
-	return &operatorNode{nodeType: nodeOperator, Op: op, Left: left, Right: right}
+	return (*nodeArena)(nil).newOperatorNode(op, left, right)
 }
 
 // newOperand returns new constant operand node OperandNode.
 func newOperandNode(v interface{}) node {
-	return &operandNode{nodeType: nodeConstantOperand, Val: v}
+	return (*nodeArena)(nil).newOperandNode(v)
 }
 
 // newAxisNode returns new axis node AxisNode.
-func newAxisNode(axisType string, typeTest NodeType, localName, prefix, prop string, n node, opts ...func(p *axisNode)) node {
-	a := axisNode{
-		nodeType:  nodeAxis,
-		typeTest:  typeTest,
-		LocalName: localName,
-		Prefix:    prefix,
-		AxisType:  axisType,
-		Prop:      prop,
-		Input:     n,
-	}
-	for _, o := range opts {
-		o(&a)
-	}
-	return &a
+func newAxisNode(axisType string, typeTest NodeType, localName, prefix, prop string, n node) node {
+	return (*nodeArena)(nil).newAxisNode(axisType, typeTest, localName, prefix, prop, n)
 }
 
 // newVariableNode returns new variable node VariableNode.
@@ -109,7 +145,7 @@ func newVariableNode(prefix, name string) node {
 
 // newFilterNode returns a new filter node FilterNode.
 func newFilterNode(n, m node) node {
-	return &filterNode{nodeType: nodeFilter, Input: n, Condition: m}
+	return (*nodeArena)(nil).newFilterNode(n, m)
 }
 
 func newGroupNode(n node) node {
@@ -123,7 +159,173 @@ func newRootNode(s string) node {
 
 // newFunctionNode returns function call node.
 func newFunctionNode(name, prefix string, args []node) node {
-	return &functionNode{nodeType: nodeFunction, Prefix: prefix, FuncName: name, Args: args}
+	return (*nodeArena)(nil).newFunctionNode(name, prefix, args)
+}
+
+// nodeArena holds the nodes of parse trees, so that compiling does not
+// allocate each node on its own. The queries built from a tree don't refer
+// to its nodes, so an arena is reused once the query is built, see
+// getArena. Nodes past the capacity of the arena are allocated on their
+// own, and the arena grows to hold them the next time it is used. A nil
+// arena allocates each node on its own.
+type nodeArena struct {
+	axes      []axisNode
+	filters   []filterNode
+	operators []operatorNode
+	operands  []operandNode
+	functions []functionNode
+
+	// The number of nodes of each type of the last tree, to grow the
+	// arena to.
+	numAxes, numFilters, numOperators, numOperands, numFunctions int
+}
+
+// maxArenaNodes is the largest number of nodes of a type an arena keeps,
+// so that a large expression does not hold on to memory.
+const maxArenaNodes = 256
+
+var arenaPool = sync.Pool{
+	New: func() interface{} {
+		return new(nodeArena)
+	},
+}
+
+// getArena returns an empty arena, to be returned with putArena once the
+// nodes allocated from it are no longer used.
+func getArena() *nodeArena {
+	return arenaPool.Get().(*nodeArena)
+}
+
+// putArena clears the nodes of a and returns it to the pool.
+func putArena(a *nodeArena) {
+	for i := range a.axes {
+		a.axes[i] = axisNode{}
+	}
+	for i := range a.filters {
+		a.filters[i] = filterNode{}
+	}
+	for i := range a.operators {
+		a.operators[i] = operatorNode{}
+	}
+	for i := range a.operands {
+		a.operands[i] = operandNode{}
+	}
+	for i := range a.functions {
+		a.functions[i] = functionNode{}
+	}
+	if n := arenaSize(a.numAxes, cap(a.axes)); n > cap(a.axes) {
+		a.axes = make([]axisNode, 0, n)
+	}
+	if n := arenaSize(a.numFilters, cap(a.filters)); n > cap(a.filters) {
+		a.filters = make([]filterNode, 0, n)
+	}
+	if n := arenaSize(a.numOperators, cap(a.operators)); n > cap(a.operators) {
+		a.operators = make([]operatorNode, 0, n)
+	}
+	if n := arenaSize(a.numOperands, cap(a.operands)); n > cap(a.operands) {
+		a.operands = make([]operandNode, 0, n)
+	}
+	if n := arenaSize(a.numFunctions, cap(a.functions)); n > cap(a.functions) {
+		a.functions = make([]functionNode, 0, n)
+	}
+	a.axes, a.filters, a.operators, a.operands, a.functions = a.axes[:0], a.filters[:0], a.operators[:0], a.operands[:0], a.functions[:0]
+	a.numAxes, a.numFilters, a.numOperators, a.numOperands, a.numFunctions = 0, 0, 0, 0, 0
+	arenaPool.Put(a)
+}
+
+// arenaSize returns the capacity to hold n nodes of a type, up to
+// maxArenaNodes, for an arena that holds size.
+func arenaSize(n, size int) int {
+	if n <= size {
+		return size
+	}
+	if n > maxArenaNodes {
+		return maxArenaNodes
+	}
+	return n
+}
+
+func (a *nodeArena) newOperatorNode(op string, left, right node) node {
+	var n *operatorNode
+	if a != nil && len(a.operators) < cap(a.operators) {
+		a.operators = a.operators[:len(a.operators)+1]
+		n = &a.operators[len(a.operators)-1]
+	} else {
+		n = new(operatorNode)
+	}
+	if a != nil {
+		a.numOperators++
+	}
+	*n = operatorNode{nodeType: nodeOperator, Op: op, Left: left, Right: right}
+	return n
+}
+
+func (a *nodeArena) newOperandNode(v interface{}) node {
+	var n *operandNode
+	if a != nil && len(a.operands) < cap(a.operands) {
+		a.operands = a.operands[:len(a.operands)+1]
+		n = &a.operands[len(a.operands)-1]
+	} else {
+		n = new(operandNode)
+	}
+	if a != nil {
+		a.numOperands++
+	}
+	*n = operandNode{nodeType: nodeConstantOperand, Val: v}
+	return n
+}
+
+func (a *nodeArena) newAxisNode(axisType string, typeTest NodeType, localName, prefix, prop string, input node) *axisNode {
+	var n *axisNode
+	if a != nil && len(a.axes) < cap(a.axes) {
+		a.axes = a.axes[:len(a.axes)+1]
+		n = &a.axes[len(a.axes)-1]
+	} else {
+		n = new(axisNode)
+	}
+	if a != nil {
+		a.numAxes++
+	}
+	*n = axisNode{
+		nodeType:  nodeAxis,
+		typeTest:  typeTest,
+		LocalName: localName,
+		Prefix:    prefix,
+		AxisType:  axisType,
+		Prop:      prop,
+		Input:     input,
+	}
+	return n
+}
+
+func (a *nodeArena) newFilterNode(input, cond node) node {
+	var n *filterNode
+	if a != nil && len(a.filters) < cap(a.filters) {
+		a.filters = a.filters[:len(a.filters)+1]
+		n = &a.filters[len(a.filters)-1]
+	} else {
+		n = new(filterNode)
+	}
+	if a != nil {
+		a.numFilters++
+	}
+	*n = filterNode{nodeType: nodeFilter, Input: input, Condition: cond}
+	return n
+}
+
+func (a *nodeArena) newFunctionNode(name, prefix string, args []node) node {
+	var n *functionNode
+	if a != nil && len(a.functions) < cap(a.functions) {
+		a.functions = a.functions[:len(a.functions)+1]
+		n = &a.functions[len(a.functions)-1]
+	} else {
+		n = new(functionNode)
+	}
+	if a != nil {
+		a.numFunctions++
+	}
+	*n = functionNode{nodeType: nodeFunction, Prefix: prefix, FuncName: name, Args: args}
+	return n
 }
 
 // testOp reports whether current item name is an operand op.
@@ -149,6 +351,66 @@ func isNodeType(r *scanner) bool {
 	return false
 }
 
+// axisNames are the supported axes.
+var axisNames = map[string]bool{
+	"ancestor":           true,
+	"ancestor-or-self":   true,
+	"attribute":          true,
+	"child":              true,
+	"descendant":         true,
+	"descendant-or-self": true,
+	"following":          true,
+	"following-sibling":  true,
+	"namespace":          true,
+	"parent":             true,
+	"preceding":          true,
+	"preceding-sibling":  true,
+	"self":               true,
+}
+
+// closestName returns the name in names closest to name, for suggestions
+// in errors, or "" if none is close enough to be a misspelling of name.
+func closestName(name string, names map[string]bool) string {
+	best, bestDist := "", len(name)/3+1
+	for n := range names {
+		if d := editDistance(name, n); d < bestDist || (d == bestDist && best != "" && n < best) {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b, in bytes.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
 func isStep(item itemType) bool {
 	switch item {
 	case itemDot, itemDotDot, itemAt, itemAxe, itemStar, itemName:
@@ -159,14 +421,14 @@ func isStep(item itemType) bool {
 
 func checkItem(r *scanner, typ itemType) {
 	if r.typ != typ {
-		panic(fmt.Sprintf("%s has an invalid token", r.text))
+		panic(r.unexpected(typ.String()))
 	}
 }
 
 // parseExpression parsing the expression with input node n.
 func (p *parser) parseExpression(n node) node {
 	if p.d = p.d + 1; p.d > 200 {
-		panic("the xpath query is too complex(depth > 200)")
+		panic(newError(ErrTooComplex, nil, "the xpath query is too complex(depth > 200)"))
 	}
 	n = p.parseOrExpr(n)
 	p.d--
@@ -178,6 +440,19 @@ func (p *parser) next() bool {
 	return p.r.nextItem()
 }
 
+// span records that the operator or function call n starts at the offset
+// start and ends with the previous item, for the errors of its evaluation.
+func (p *parser) span(n node, start int) node {
+	end := start + len(strings.TrimRightFunc(p.r.text[start:p.r.end], unicode.IsSpace))
+	switch n := n.(type) {
+	case *operatorNode:
+		n.start, n.end = start, end
+	case *functionNode:
+		n.start, n.end = start, end
+	}
+	return n
+}
+
 func (p *parser) skipItem(typ itemType) {
 	checkItem(p.r, typ)
 	p.next()
@@ -185,32 +460,35 @@ func (p *parser) skipItem(typ itemType) {
 
 // OrExpr ::= AndExpr | OrExpr 'or' AndExpr
 func (p *parser) parseOrExpr(n node) node {
+	start := p.r.start
 	opnd := p.parseAndExpr(n)
 	for {
 		if !testOp(p.r, "or") {
 			break
 		}
 		p.next()
-		opnd = newOperatorNode("or", opnd, p.parseAndExpr(n))
+		opnd = p.span(p.arena.newOperatorNode("or", opnd, p.parseAndExpr(n)), start)
 	}
 	return opnd
 }
 
 // AndExpr ::= EqualityExpr	| AndExpr 'and' EqualityExpr
 func (p *parser) parseAndExpr(n node) node {
+	start := p.r.start
 	opnd := p.parseEqualityExpr(n)
 	for {
 		if !testOp(p.r, "and") {
 			break
 		}
 		p.next()
-		opnd = newOperatorNode("and", opnd, p.parseEqualityExpr(n))
+		opnd = p.span(p.arena.newOperatorNode("and", opnd, p.parseEqualityExpr(n)), start)
 	}
 	return opnd
 }
 
 // EqualityExpr ::= RelationalExpr | EqualityExpr '=' RelationalExpr | EqualityExpr '!=' RelationalExpr
 func (p *parser) parseEqualityExpr(n node) node {
+	start := p.r.start
 	opnd := p.parseRelationalExpr(n)
 Loop:
 	for {
@@ -224,7 +502,7 @@ Loop:
 			break Loop
 		}
 		p.next()
-		opnd = newOperatorNode(op, opnd, p.parseRelationalExpr(n))
+		opnd = p.span(p.arena.newOperatorNode(op, opnd, p.parseRelationalExpr(n)), start)
 	}
 	return opnd
 }
@@ -234,6 +512,7 @@ Loop:
 //	| RelationalExpr '<=' AdditiveExpr
 //	| RelationalExpr '>=' AdditiveExpr
 func (p *parser) parseRelationalExpr(n node) node {
+	start := p.r.start
 	opnd := p.parseAdditiveExpr(n)
 Loop:
 	for {
@@ -251,13 +530,14 @@ Loop:
 			break Loop
 		}
 		p.next()
-		opnd = newOperatorNode(op, opnd, p.parseAdditiveExpr(n))
+		opnd = p.span(p.arena.newOperatorNode(op, opnd, p.parseAdditiveExpr(n)), start)
 	}
 	return opnd
 }
 
 // AdditiveExpr	::= MultiplicativeExpr	| AdditiveExpr '+' MultiplicativeExpr | AdditiveExpr '-' MultiplicativeExpr
 func (p *parser) parseAdditiveExpr(n node) node {
+	start := p.r.start
 	opnd := p.parseMultiplicativeExpr(n)
 Loop:
 	for {
@@ -271,7 +551,7 @@ Loop:
 			break Loop
 		}
 		p.next()
-		opnd = newOperatorNode(op, opnd, p.parseMultiplicativeExpr(n))
+		opnd = p.span(p.arena.newOperatorNode(op, opnd, p.parseMultiplicativeExpr(n)), start)
 	}
 	return opnd
 }
@@ -280,6 +560,7 @@ Loop:
 //
 //	| MultiplicativeExpr 'div' UnaryExpr | MultiplicativeExpr 'mod' UnaryExpr
 func (p *parser) parseMultiplicativeExpr(n node) node {
+	start := p.r.start
 	opnd := p.parseUnaryExpr(n)
 Loop:
 	for {
@@ -292,28 +573,34 @@ Loop:
 			break Loop
 		}
 		p.next()
-		opnd = newOperatorNode(op, opnd, p.parseUnaryExpr(n))
+		opnd = p.span(p.arena.newOperatorNode(op, opnd, p.parseUnaryExpr(n)), start)
 	}
 	return opnd
 }
 
 // UnaryExpr ::= UnionExpr | '-' UnaryExpr
 func (p *parser) parseUnaryExpr(n node) node {
-	minus := false
-	// ignore '-' sequence
+	start := p.r.start
+	minus := 0
 	for p.r.typ == itemMinus {
 		p.next()
-		minus = !minus
+		minus++
 	}
 	opnd := p.parseUnionExpr(n)
-	if minus {
-		opnd = newOperatorNode("*", opnd, newOperandNode(float64(-1)))
+	// -x is read as x * -1, and --x, which converts x to a number, as
+	// x * -1 * -1.
+	if minus > 2 {
+		minus = 2 - minus%2
+	}
+	for ; minus > 0; minus-- {
+		opnd = p.span(p.arena.newOperatorNode("*", opnd, p.arena.newOperandNode(float64(-1))), start)
 	}
 	return opnd
 }
 
 // UnionExpr ::= PathExpr | UnionExpr '|' PathExpr
 func (p *parser) parseUnionExpr(n node) node {
+	start := p.r.start
 	opnd := p.parsePathExpr(n)
 Loop:
 	for {
@@ -323,7 +610,7 @@ Loop:
 		p.next()
 		opnd2 := p.parsePathExpr(n)
 		// Checking the node type that must be is node set type?
-		opnd = newOperatorNode("|", opnd, opnd2)
+		opnd = p.span(p.arena.newOperatorNode("|", opnd, opnd2), start)
 	}
 	return opnd
 }
@@ -339,7 +626,7 @@ func (p *parser) parsePathExpr(n node) node {
 			opnd = p.parseRelativeLocationPath(opnd)
 		case itemSlashSlash:
 			p.next()
-			opnd = p.parseRelativeLocationPath(newAxisNode("descendant-or-self", allNode, "", "", "", opnd))
+			opnd = p.parseRelativeLocationPath(p.arena.newAxisNode("descendant-or-self", allNode, "", "", "", opnd))
 		}
 	} else {
 		opnd = p.parseLocationPath(nil)
@@ -351,7 +638,7 @@ func (p *parser) parsePathExpr(n node) node {
 func (p *parser) parseFilterExpr(n node) node {
 	opnd := p.parsePrimaryExpr(n)
 	if p.r.typ == itemLBracket {
-		opnd = newFilterNode(opnd, p.parsePredicate(opnd))
+		opnd = p.arena.newFilterNode(opnd, p.parsePredicate(opnd))
 	}
 	return opnd
 }
@@ -376,7 +663,7 @@ func (p *parser) parseLocationPath(n node) (opnd node) {
 	case itemSlashSlash:
 		p.next()
 		opnd = newRootNode("//")
-		opnd = p.parseRelativeLocationPath(newAxisNode("descendant-or-self", allNode, "", "", "", opnd))
+		opnd = p.parseRelativeLocationPath(p.arena.newAxisNode("descendant-or-self", allNode, "", "", "", opnd))
 	default:
 		opnd = p.parseRelativeLocationPath(n)
 	}
@@ -392,7 +679,7 @@ Loop:
 		switch p.r.typ {
 		case itemSlashSlash:
 			p.next()
-			opnd = newAxisNode("descendant-or-self", allNode, "", "", "", opnd)
+			opnd = p.arena.newAxisNode("descendant-or-self", allNode, "", "", "", opnd)
 		case itemSlash:
 			p.next()
 		default:
@@ -406,9 +693,9 @@ Loop:
 func (p *parser) parseStep(n node) (opnd node) {
 	if p.r.typ == itemDot || p.r.typ == itemDotDot {
 		if p.r.typ == itemDot {
-			opnd = newAxisNode("self", allNode, "", "", "", n)
+			opnd = p.arena.newAxisNode("self", allNode, "", "", "", n)
 		} else {
-			opnd = newAxisNode("parent", allNode, "", "", "", n)
+			opnd = p.arena.newAxisNode("parent", allNode, "", "", "", n)
 		}
 		p.next()
 		if p.r.typ != itemLBracket {
@@ -416,24 +703,37 @@ func (p *parser) parseStep(n node) (opnd node) {
 		}
 	} else {
 		axisType := "child" // default axes value.
+		explicit := true
 		switch p.r.typ {
 		case itemAt:
 			axisType = "attribute"
 			p.next()
 		case itemAxe:
 			axisType = p.r.name
+			if !axisNames[axisType] {
+				err := p.r.invalid("unknown axis")
+				if s := closestName(axisType, axisNames); s != "" {
+					err.Suggestion = s + "::"
+				}
+				panic(err)
+			}
 			p.next()
 		case itemLParens:
 			return p.parseSequence(n)
+		default:
+			explicit = false
 		}
 		matchType := ElementNode
 		if axisType == "attribute" {
 			matchType = AttributeNode
 		}
+		if !explicit && !isStep(p.r.typ) {
+			panic(p.r.unexpected("expression"))
+		}
 		opnd = p.parseNodeTest(n, axisType, matchType)
 	}
 	for p.r.typ == itemLBracket {
-		opnd = newFilterNode(opnd, p.parsePredicate(opnd))
+		opnd = p.arena.newFilterNode(opnd, p.parsePredicate(opnd))
 	}
 	return opnd
 }
@@ -448,7 +748,7 @@ func (p *parser) parseSequence(n node) (opnd node) {
 		}
 		p.next()
 		opnd2 := p.parseStep(n)
-		opnd = newOperatorNode("|", opnd, opnd2)
+		opnd = p.arena.newOperatorNode("|", opnd, opnd2)
 	}
 	p.skipItem(itemRParens)
 	return opnd
@@ -485,30 +785,35 @@ func (p *parser) parseNodeTest(n node, axeTyp string, matchType NodeType) (opnd
 				matchType = RootNode
 			}
 
-			opnd = newAxisNode(axeTyp, matchType, name, "", prop, n)
+			opnd = p.arena.newAxisNode(axeTyp, matchType, name, "", prop, n)
 		} else {
 			prefix := p.r.prefix
 			name := p.r.name
+			start := p.r.start
 			p.next()
-			if p.r.name == "*" {
+			if name == "*" {
 				name = ""
 			}
-			opnd = newAxisNode(axeTyp, matchType, name, prefix, "", n, func(a *axisNode) {
-				if prefix != "" && p.namespaces != nil {
-					if ns, ok := p.namespaces[prefix]; ok {
-						a.hasNamespaceURI = true
-						a.namespaceURI = ns
-					} else {
-						panic(fmt.Sprintf("prefix %s not defined.", prefix))
-					}
+			a := p.arena.newAxisNode(axeTyp, matchType, name, prefix, "", n)
+			if prefix == "" && name != "" && p.namespaces != nil {
+				// With the namespaces of CompileWithNS, a name of no prefix
+				// is in no namespace, as in XPath 1.0.
+				a.hasNamespaceURI = true
+			} else if prefix != "" && p.namespaces != nil {
+				if ns, ok := p.namespaces[prefix]; ok {
+					a.hasNamespaceURI = true
+					a.namespaceURI = ns
+				} else {
+					panic(&SyntaxError{Expr: p.r.text, Offset: start, Token: prefix + ":" + name, Msg: "undefined namespace prefix"})
 				}
-			})
+			}
+			opnd = a
 		}
 	case itemStar:
-		opnd = newAxisNode(axeTyp, matchType, "", "", "", n)
+		opnd = p.arena.newAxisNode(axeTyp, matchType, "", "", "", n)
 		p.next()
 	default:
-		panic("expression must evaluate to a node-set")
+		panic(p.r.unexpected("name", "'*'", "node type test"))
 	}
 	return opnd
 }
@@ -517,10 +822,10 @@ func (p *parser) parseNodeTest(n node, axeTyp string, matchType NodeType) (opnd
 func (p *parser) parsePrimaryExpr(n node) (opnd node) {
 	switch p.r.typ {
 	case itemString:
-		opnd = newOperandNode(p.r.strval)
+		opnd = p.arena.newOperandNode(p.r.strval)
 		p.next()
 	case itemNumber:
-		opnd = newOperandNode(p.r.numval)
+		opnd = p.arena.newOperandNode(p.r.numval)
 		p.next()
 	case itemDollar:
 		p.next()
@@ -547,6 +852,7 @@ func (p *parser) parseMethod(n node) node {
 	var args []node
 	name := p.r.name
 	prefix := p.r.prefix
+	start := p.r.start
 
 	p.skipItem(itemName)
 	p.skipItem(itemLParens)
@@ -560,16 +866,19 @@ func (p *parser) parseMethod(n node) node {
 		}
 	}
 	p.skipItem(itemRParens)
-	return newFunctionNode(name, prefix, args)
+	return p.span(p.arena.newFunctionNode(name, prefix, args), start)
 }
 
 // Parse parsing the XPath express string expr and returns a tree node.
-func parse(expr string, namespaces map[string]string) node {
+// The nodes are allocated from arena, if not nil.
+func parse(expr string, namespaces map[string]string, arena *nodeArena) node {
 	r := &scanner{text: expr}
 	r.nextChar()
 	r.nextItem()
-	p := &parser{r: r, namespaces: namespaces}
-	return p.parseExpression(nil)
+	p := &parser{r: r, namespaces: namespaces, arena: arena}
+	n := p.parseExpression(nil)
+	checkItem(r, itemEOF)
+	return n
 }
 
 // rootNode holds a top-level node of tree.
@@ -587,6 +896,7 @@ type operatorNode struct {
 	nodeType
 	Op          string
 	Left, Right node
+	start, end  int // offsets in the expression, if parsed
 }
 
 func (o *operatorNode) String() string {
@@ -667,9 +977,10 @@ func (v *variableNode) String() string {
 // functionNode holds a function call.
 type functionNode struct {
 	nodeType
-	Args     []node
-	Prefix   string
-	FuncName string // function name
+	Args       []node
+	Prefix     string
+	FuncName   string // function name
+	start, end int    // offsets in the expression, if parsed
 }
 
 func (f *functionNode) String() string {
@@ -690,6 +1001,8 @@ func (f *functionNode) String() string {
 type scanner struct {
 	text, name, prefix string
 
+	start     int // offset of the current item
+	end       int // end of the previous item, with the spaces after it
 	pos       int
 	curr      rune
 	currSize  int
@@ -718,12 +1031,18 @@ func (s *scanner) nextChar() bool {
 }
 
 func (s *scanner) nextItem() bool {
+	s.end = s.pos - s.currSize
+	if s.curr == 0 {
+		s.end = s.pos
+	}
 	s.skipSpace()
+	s.start = s.pos - s.currSize
 	switch s.curr {
 	case 0:
+		s.start = len(s.text)
 		s.typ = itemEOF
 		return false
-	case ',', '@', '(', ')', '|', '*', '[', ']', '+', '-', '=', '#', '$':
+	case ',', '@', '(', ')', '|', '*', '[', ']', '+', '-', '=', '$':
 		s.typ = asItemType(s.curr)
 		s.nextChar()
 	case '<':
@@ -792,7 +1111,7 @@ func (s *scanner) nextItem() bool {
 					} else if isName(s.curr) {
 						s.name = s.scanName()
 					} else {
-						panic(fmt.Sprintf("%s has an invalid qualified name.", s.text))
+						panic(s.invalid("invalid qualified name"))
 					}
 				}
 			} else {
@@ -804,19 +1123,39 @@ func (s *scanner) nextItem() bool {
 						s.nextChar()
 						s.typ = itemAxe
 					} else {
-						panic(fmt.Sprintf("%s has an invalid qualified name.", s.text))
+						panic(s.invalid("invalid qualified name"))
 					}
 				}
 			}
 			s.skipSpace()
 			s.canBeFunc = s.curr == '('
 		} else {
-			panic(fmt.Sprintf("%s has an invalid token.", s.text))
+			panic(&SyntaxError{Expr: s.text, Offset: s.start, Token: string(s.curr), Msg: "invalid character"})
 		}
 	}
 	return true
 }
 
+// token returns the text of the current item, as far as it is scanned.
+func (s *scanner) token() string {
+	end := s.pos - s.currSize
+	if s.curr == 0 {
+		end = s.pos
+	}
+	return strings.TrimRightFunc(s.text[s.start:end], unicode.IsSpace)
+}
+
+// unexpected returns the error for the current item, where the parser
+// expected one of the given items.
+func (s *scanner) unexpected(expected ...string) *SyntaxError {
+	return &SyntaxError{Expr: s.text, Offset: s.start, Token: s.token(), Expected: expected}
+}
+
+// invalid returns the error for the current item, which cannot be scanned.
+func (s *scanner) invalid(msg string) *SyntaxError {
+	return &SyntaxError{Expr: s.text, Offset: s.start, Token: s.token(), Msg: msg}
+}
+
 func (s *scanner) skipSpace() {
 Loop:
 	for {
@@ -875,7 +1214,7 @@ func (s *scanner) scanString() string {
 	c := s.currSize
 	for s.curr != end {
 		if !s.nextChar() {
-			panic(errors.New("xpath: scanString got unclosed string"))
+			panic(s.invalid("unclosed string"))
 		}
 		c += s.currSize
 	}
@@ -903,8 +1242,23 @@ func (s *scanner) scanName() string {
 }
 
 func isName(r rune) bool {
-	return string(r) != ":" && string(r) != "/" &&
-		(unicode.Is(first, r) || unicode.Is(second, r) || string(r) == "*")
+	if r < utf8.RuneSelf {
+		return asciiNames[r]
+	}
+	return isNameRune(r)
+}
+
+// asciiNames caches isName for the ASCII runes, which most expressions are
+// made of.
+var asciiNames = func() (names [utf8.RuneSelf]bool) {
+	for r := range names {
+		names[r] = isNameRune(rune(r))
+	}
+	return names
+}()
+
+func isNameRune(r rune) bool {
+	return r != ':' && r != '/' && (unicode.Is(first, r) || unicode.Is(second, r) || r == '*')
 }
 
 func isDigit(r rune) bool {
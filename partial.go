@@ -0,0 +1,62 @@
+package xpath
+
+// markPartial makes the query q of an expression, evaluated with
+// EvalOptions.PartialResults, return the nodes it holds when the
+// evaluation is stopped, if it sorts or merges them.
+func markPartial(q query) {
+	for {
+		switch x := q.(type) {
+		case *guardQuery:
+			q = x.query
+		case *verifyQuery:
+			q = x.query
+		case *documentOrderQuery:
+			x.partial = true
+			return
+		case *unionQuery:
+			x.partial = true
+			return
+		default:
+			return
+		}
+	}
+}
+
+// partialNodes is orderedNodes for the node-set of the result with
+// EvalOptions.PartialResults: if a limit or the context stops the
+// evaluation, the nodes selected so far are returned, and the evaluation
+// is stopped, see limiter.stopped. The nodes are always copied.
+func partialNodes(q query, t iterator, _ bool) (list []orderedNode) {
+	lim := limitsOf(t)
+	if lim == nil {
+		return orderedNodes(q, t, true)
+	}
+	if lim.stopped != nil {
+		return nil
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if progressOf(e) == nil {
+				panic(e)
+			}
+			lim.stopped = e.(error)
+			list = sortNodes(list)
+		}
+	}()
+	collectNodes(q, t, true, &list)
+	return sortNodes(list)
+}
+
+// progressOf returns the Progress of the error e that stops an evaluation,
+// or nil if e is not one.
+func progressOf(e interface{}) *Progress {
+	switch e := e.(type) {
+	case *ResourceLimitError:
+		return &e.Progress
+	case *TimeoutError:
+		return &e.Progress
+	case *CanceledError:
+		return &e.Progress
+	}
+	return nil
+}
@@ -0,0 +1,475 @@
+package xpath
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// A Pattern is an XSLT 1.0 match pattern, such as "chapter/title" or
+// "section[@id]//para | /", compiled by CompilePattern. A pattern matches
+// a node rather than selecting nodes from a context: "title" matches the
+// title elements wherever they are.
+type Pattern struct {
+	s     string
+	paths []*patternPath
+}
+
+// The anchors of the first step of a pattern path, as written before it.
+const (
+	anchorNone   = iota // a, or //a, which matches the same nodes
+	anchorRoot          // /a
+	anchorID            // id('x')/a
+	anchorIDDesc        // id('x')//a
+)
+
+// A patternPath is a LocationPathPattern, one of the alternatives of a
+// Pattern.
+type patternPath struct {
+	s      string
+	anchor int
+	id     *Expr // id('x'), for anchorID and anchorIDDesc
+	steps  []patternStep
+	// simple is the step of the path, for its default priority, if it is
+	// a single step with no predicates, written with nothing before it.
+	simple *axisNode
+}
+
+// A patternStep is a StepPattern of a patternPath.
+type patternStep struct {
+	attribute bool
+	test      func(NodeNavigator) bool
+	// desc reports whether the step follows // rather than /.
+	desc bool
+	// self is the predicates of the step as self::node()[p], if none of
+	// them depends on the position. Otherwise from is the step with its
+	// predicates, from the parent of the node.
+	self, from *Expr
+	conds      []node
+}
+
+// CompilePattern compiles an XSLT 1.0 match pattern: alternatives
+// separated by |, each a path of child or attribute steps separated by /
+// or //, with predicates, that may start with /, // or id('x'). A
+// predicate of a step is evaluated as it is by the step from the parent of
+// the node, so a[2] matches the second a child of its parent. key()
+// patterns are not supported. If the pattern is not valid, the error is a
+// *SyntaxError.
+func CompilePattern(pattern string) (*Pattern, error) {
+	return CompilePatternWithNS(pattern, nil)
+}
+
+// CompilePatternWithNS compiles an XSLT 1.0 match pattern, using the given
+// namespaces map for its prefixes.
+func CompilePatternWithNS(pattern string, namespaces map[string]string) (p *Pattern, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch x := e.(type) {
+			case string:
+				err = errors.New(x)
+			case error:
+				err = x
+			default:
+				err = errors.New("unknown panic")
+			}
+		}
+	}()
+	if pattern == "" {
+		return nil, newError(ErrSyntax, nil, "expr expression is nil")
+	}
+	r := &scanner{text: pattern}
+	r.nextChar()
+	r.nextItem()
+	pp := &parser{r: r, namespaces: namespaces}
+	p = &Pattern{s: pattern}
+	for {
+		path, err := pp.parsePatternPath(CompileOptions{Namespaces: namespaces})
+		if err != nil {
+			return nil, err
+		}
+		p.paths = append(p.paths, path)
+		if r.typ != itemUnion {
+			break
+		}
+		r.nextItem()
+	}
+	checkItem(r, itemEOF)
+	return p, nil
+}
+
+// parsePatternPath parses the pattern path at the current item, with
+// opts for the expressions of its predicates.
+//
+//	LocationPathPattern ::= '/' RelativePathPattern?
+//		| IdKeyPattern (('/' | '//') RelativePathPattern)?
+//		| '//'? RelativePathPattern
+func (p *parser) parsePatternPath(opts CompileOptions) (*patternPath, error) {
+	start := p.r.start
+	path := &patternPath{}
+	steps, simple := true, true
+	switch p.r.typ {
+	case itemSlash:
+		p.next()
+		path.anchor = anchorRoot
+		steps = isStep(p.r.typ)
+	case itemSlashSlash:
+		p.next()
+		simple = false
+	case itemName:
+		if !p.r.canBeFunc || p.r.prefix != "" || isNodeType(p.r) {
+			break
+		}
+		if p.r.name != "id" {
+			panic(p.r.invalid("unsupported pattern"))
+		}
+		idStart := p.r.start
+		p.next()
+		p.skipItem(itemLParens)
+		checkItem(p.r, itemString)
+		p.next()
+		p.skipItem(itemRParens)
+		id, err := compile(p.r.text[idStart:p.r.end], opts)
+		if err != nil {
+			return nil, err
+		}
+		path.anchor, path.id = anchorID, id
+		switch p.r.typ {
+		case itemSlash:
+			p.next()
+		case itemSlashSlash:
+			p.next()
+			path.anchor = anchorIDDesc
+		default:
+			steps = false
+		}
+	}
+	for desc := false; steps; {
+		step, n, err := p.parseStepPattern(opts)
+		if err != nil {
+			return nil, err
+		}
+		step.desc = desc
+		path.steps = append(path.steps, step)
+		if simple && len(path.steps) == 1 && path.anchor == anchorNone && p.r.typ != itemSlash && p.r.typ != itemSlashSlash {
+			if a, ok := n.(*axisNode); ok {
+				path.simple = a
+			}
+		}
+		switch p.r.typ {
+		case itemSlash:
+			desc = false
+		case itemSlashSlash:
+			desc = true
+		default:
+			steps = false
+			continue
+		}
+		p.next()
+	}
+	path.s = strings.TrimRightFunc(p.r.text[start:p.r.end], unicode.IsSpace)
+	return path, nil
+}
+
+// parseStepPattern parses the step pattern at the current item, and
+// returns it with its tree.
+//
+//	StepPattern ::= ChildOrAttributeAxisSpecifier NodeTest Predicate*
+func (p *parser) parseStepPattern(opts CompileOptions) (patternStep, node, error) {
+	var step patternStep
+	axisType, matchType := "child", ElementNode
+	switch p.r.typ {
+	case itemAt:
+		p.next()
+		axisType, matchType = "attribute", AttributeNode
+	case itemAxe:
+		switch p.r.name {
+		case "attribute":
+			axisType, matchType = "attribute", AttributeNode
+		case "child":
+		default:
+			panic(p.r.invalid("invalid axis in a pattern"))
+		}
+		p.next()
+	case itemName, itemStar:
+	default:
+		panic(p.r.unexpected("name", "'*'", "'@'", "node type test"))
+	}
+	n := p.parseNodeTest(nil, axisType, matchType)
+	a := n.(*axisNode)
+	step.attribute = axisType == "attribute"
+	step.test = axisPredicate(a)
+	var conds []node
+	for p.r.typ == itemLBracket {
+		cond := p.parsePredicate(n)
+		conds = append(conds, cond)
+		n = p.arena.newFilterNode(n, cond)
+	}
+	if len(conds) == 0 {
+		return step, n, nil
+	}
+	positional := false
+	var self node = p.arena.newAxisNode("self", allNode, "", "", "", nil)
+	for _, cond := range conds {
+		positional = positional || positionalPredicate(cond)
+		self = p.arena.newFilterNode(self, cond)
+	}
+	step.conds = conds
+	var err error
+	if positional {
+		step.from, err = compile(formatNode(n), opts)
+	} else {
+		step.self, err = compile(formatNode(self), opts)
+	}
+	return step, n, err
+}
+
+// String returns the pattern.
+func (p *Pattern) String() string {
+	return p.s
+}
+
+// Alternatives returns the patterns separated by | in the pattern, or the
+// pattern itself if there are none. As in XSLT, a template rule with such
+// a pattern is a template rule for each of them, with its own priority.
+func (p *Pattern) Alternatives() []*Pattern {
+	if len(p.paths) == 1 {
+		return []*Pattern{p}
+	}
+	list := make([]*Pattern, len(p.paths))
+	for i, path := range p.paths {
+		list[i] = &Pattern{s: path.s, paths: []*patternPath{path}}
+	}
+	return list
+}
+
+// Priority returns the default priority of the pattern in XSLT: 0 for a
+// name, such as "title" or "@xml:lang", -0.25 for a prefix:* wildcard,
+// -0.5 for another node test, such as "*" or "text()", and 0.5 for the
+// other patterns. That of a pattern with alternatives is the highest of
+// theirs.
+func (p *Pattern) Priority() float64 {
+	priority := -0.5
+	for _, path := range p.paths {
+		if v := path.priority(); v > priority {
+			priority = v
+		}
+	}
+	return priority
+}
+
+func (path *patternPath) priority() float64 {
+	a := path.simple
+	switch {
+	case a == nil:
+		return 0.5
+	case a.Prop == "processing-instruction" && a.LocalName != "":
+		return 0
+	case a.Prop != "":
+		return -0.5
+	case a.LocalName != "":
+		return 0
+	case a.Prefix != "":
+		return -0.25
+	}
+	return -0.5
+}
+
+// Matches reports whether the pattern matches the node of nav, which is
+// not moved. The steps are matched from the node up to the root, so only
+// the ancestors of the node are visited, and the siblings of those with
+// predicates that depend on the position.
+func (p *Pattern) Matches(nav NodeNavigator) bool {
+	for _, path := range p.paths {
+		if path.matches(nav, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesOpen reports whether the pattern may match the node of nav once
+// its content is known, as at the start tag of an element in a stream:
+// whether it matches the node but for the predicates of its last step. A
+// node a Streamable pattern matches once its content is known is one it
+// matches when open.
+func (p *Pattern) MatchesOpen(nav NodeNavigator) bool {
+	for _, path := range p.paths {
+		if path.matches(nav, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// Streamable reports whether the pattern matches the nodes of a document
+// read as a stream, at their end: with their content, but only the
+// ancestors of the nodes and the attributes of the ancestors, as a stream
+// can drop the other nodes. The predicates of the last step of its paths
+// must not depend on the position or refer to other nodes than those of
+// the node and the values, and those of the other steps must only refer
+// to their attributes. The id('x') patterns are not streamable.
+func (p *Pattern) Streamable() bool {
+	for _, path := range p.paths {
+		if path.id != nil {
+			return false
+		}
+		for i, step := range path.steps {
+			if step.from != nil {
+				return false
+			}
+			for _, cond := range step.conds {
+				if !streamableCond(cond, i < len(path.steps)-1) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// streamableCond reports whether the predicate n of a streamable pattern
+// only refers to the nodes of its context node, or to its attributes if
+// attrs.
+func streamableCond(n node, attrs bool) bool {
+	switch n := n.(type) {
+	case *operandNode, *variableNode:
+		return true
+	case *operatorNode:
+		return streamableCond(n.Left, attrs) && streamableCond(n.Right, attrs)
+	case *groupNode:
+		return streamableCond(n.Input, attrs)
+	case *functionNode:
+		if n.Prefix == "" && n.FuncName == "id" {
+			return false
+		}
+		if attrs && len(n.Args) == 0 {
+			// The value of the context node, such as string(), is not
+			// known, but for its name.
+			switch n.FuncName {
+			case "true", "false", "name", "local-name", "namespace-uri":
+			default:
+				return false
+			}
+		}
+		for _, arg := range n.Args {
+			if !streamableCond(arg, attrs) {
+				return false
+			}
+		}
+		return true
+	case *filterNode:
+		return !attrs && streamableCond(n.Input, false) && streamableCond(n.Condition, false)
+	case *axisNode:
+		switch n.AxisType {
+		case "attribute":
+		case "child", "descendant", "descendant-or-self", "self":
+			if attrs {
+				return false
+			}
+		default:
+			return false
+		}
+		return n.Input == nil || streamableCond(n.Input, attrs)
+	}
+	return false
+}
+
+func (path *patternPath) matches(nav NodeNavigator, open bool) bool {
+	if len(path.steps) == 0 {
+		if path.anchor == anchorRoot {
+			return nav.NodeType() == RootNode
+		}
+		return path.inID(nav)
+	}
+	return path.match(len(path.steps)-1, nav.Copy(), open)
+}
+
+// match reports whether the steps up to i match with n for the step i,
+// but for the predicates of the step if open. n may be moved.
+func (path *patternPath) match(i int, n NodeNavigator, open bool) bool {
+	step := &path.steps[i]
+	if !step.matches(n, !open) {
+		return false
+	}
+	if !n.MoveToParent() {
+		return false
+	}
+	if i == 0 {
+		switch path.anchor {
+		case anchorRoot:
+			return n.NodeType() == RootNode
+		case anchorID:
+			return path.inID(n)
+		case anchorIDDesc:
+			for {
+				if path.inID(n) {
+					return true
+				}
+				if !n.MoveToParent() {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	if !step.desc {
+		return path.match(i-1, n, false)
+	}
+	for {
+		if path.match(i-1, n.Copy(), false) {
+			return true
+		}
+		if !n.MoveToParent() {
+			return false
+		}
+	}
+}
+
+// inID reports whether n is one of the nodes of the id('x') of the path.
+func (path *patternPath) inID(n NodeNavigator) bool {
+	root := n.Copy()
+	root.MoveToRoot()
+	return contains(path.id.Select(root), n)
+}
+
+// matches reports whether the node n, which is not moved, matches the
+// step, and its predicates if preds.
+func (step *patternStep) matches(n NodeNavigator, preds bool) bool {
+	switch n.NodeType() {
+	case RootNode:
+		return false
+	case AttributeNode:
+		if !step.attribute {
+			return false
+		}
+	default:
+		if step.attribute {
+			return false
+		}
+	}
+	if !step.test(n) {
+		return false
+	}
+	if !preds {
+		return true
+	}
+	if step.self != nil {
+		return step.self.Select(n.Copy()).MoveNext()
+	}
+	if step.from != nil {
+		parent := n.Copy()
+		return parent.MoveToParent() && contains(step.from.Select(parent), n)
+	}
+	return true
+}
+
+// contains reports whether the nodes of iter include n.
+func contains(iter *NodeIterator, n NodeNavigator) bool {
+	target := &orderedNode{node: n.Copy()}
+	for iter.MoveNext() {
+		if (&orderedNode{node: iter.Current()}).compare(target) == 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package xpath
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	doc := parseXML(`<doc id="doc"><chapter id="c1"><title id="t1">One</title><para id="p1"/><para id="p2" role="note"/></chapter>` +
+		`<chapter id="c2"><section id="s1"><title id="t2"/><para id="p3"/></section><!--c--></chapter></doc>`)
+	// The label of a node: the id of an element, @name of an attribute,
+	// and the value of another node in quotes.
+	label := func(nav NodeNavigator) string {
+		switch nav.NodeType() {
+		case RootNode:
+			return "/"
+		case ElementNode:
+			return MustCompile("string(@id)").Evaluate(nav.Copy()).(string)
+		case AttributeNode:
+			return "@" + nav.LocalName()
+		}
+		return "'" + nav.Value() + "'"
+	}
+	var all []NodeNavigator
+	for iter := MustCompile("/ | //node() | //@role").Select(createNavigator(doc)); iter.MoveNext(); {
+		all = append(all, iter.Current().Copy())
+	}
+	for _, tc := range []struct {
+		pattern, want string
+		priority      float64
+	}{
+		{`para`, "p1 p2 p3", 0},
+		{`chapter/title`, "t1", 0.5},
+		{`chapter//title`, "t1 t2", 0.5},
+		{`/doc/chapter`, "c1 c2", 0.5},
+		{`//para`, "p1 p2 p3", 0.5},
+		{`/`, "/", 0.5},
+		{`para[2]`, "p2", 0.5},
+		{`para[last()]`, "p2 p3", 0.5},
+		{`chapter[2]//para[1]`, "p3", 0.5},
+		{`*[@role = 'note']`, "p2", 0.5},
+		{`@role`, "@role", 0},
+		{`attribute::*`, "@role", -0.5},
+		{`para/@role`, "@role", 0.5},
+		{`text()`, "'One'", -0.5},
+		{`comment() | title`, "t1 t2 'c'", 0},
+		{`child::node()`, "doc c1 t1 'One' p1 p2 c2 s1 t2 p3 'c'", -0.5},
+		{`*`, "doc c1 t1 p1 p2 c2 s1 t2 p3", -0.5},
+		{`id('c2')//title`, "t2", 0.5},
+		{`id('c1')/para`, "p1 p2", 0.5},
+		{`id('s1')`, "s1", 0.5},
+		{`section/para | chapter/*[1]`, "t1 s1 p3", 0.5},
+	} {
+		p, err := CompilePattern(tc.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tc.pattern, err)
+			continue
+		}
+		var got []string
+		for _, nav := range all {
+			if p.Matches(nav) {
+				got = append(got, label(nav))
+			}
+		}
+		if s := strings.Join(got, " "); s != tc.want {
+			t.Errorf("%s matches %s, want %s", tc.pattern, s, tc.want)
+		}
+		if got := p.Priority(); got != tc.priority {
+			t.Errorf("%s has priority %v, want %v", tc.pattern, got, tc.priority)
+		}
+	}
+
+	p, err := CompilePattern(`title | para[@role]`)
+	assertNoErr(t, err)
+	alts := p.Alternatives()
+	assertEqual(t, 2, len(alts))
+	assertEqual(t, "title", alts[0].String())
+	assertEqual(t, 0.0, alts[0].Priority())
+	assertEqual(t, "para[@role]", alts[1].String())
+	assertEqual(t, 0.5, alts[1].Priority())
+
+	p, err = CompilePatternWithNS(`b:*`, map[string]string{"b": "urn:b"})
+	assertNoErr(t, err)
+	assertEqual(t, -0.25, p.Priority())
+
+	for _, tc := range []struct {
+		pattern string
+		offset  int
+		token   string
+	}{
+		{`ancestor::a`, 0, "ancestor::"},
+		{`a/../b`, 2, ".."},
+		{`a | 1`, 4, "1"},
+		{`key('k', 'v')`, 0, "key"},
+		{`a[`, 2, ""},
+		{`a b`, 2, "b"},
+	} {
+		_, err := CompilePattern(tc.pattern)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("%s: expected a syntax error, got %v", tc.pattern, err)
+			continue
+		}
+		if serr.Offset != tc.offset || serr.Token != tc.token {
+			t.Errorf("%s: error at %d %q, want %d %q", tc.pattern, serr.Offset, serr.Token, tc.offset, tc.token)
+		}
+	}
+}
+
+func TestPatternStreamable(t *testing.T) {
+	for _, tc := range []struct {
+		pattern    string
+		streamable bool
+	}{
+		{`item`, true},
+		{`feed/item[price > 10]`, true},
+		{`item[.//price > 10 and not(@hidden)]`, true},
+		{`item[normalize-space() != '']`, true},
+		{`feed[@lang = 'en']//item`, true},
+		{`/ | text()`, true},
+		{`item[2]`, false},
+		{`item[last()]`, false},
+		{`feed[title = 'x']/item`, false},
+		{`feed[string() = 'x']/item`, false},
+		{`item[../@id]`, false},
+		{`item[following-sibling::item]`, false},
+		{`item[/feed/@id = @ref]`, false},
+		{`id('x')//item`, false},
+		{`item | item[1]`, false},
+	} {
+		p, err := CompilePattern(tc.pattern)
+		if err != nil {
+			t.Errorf("%s: %v", tc.pattern, err)
+			continue
+		}
+		if got := p.Streamable(); got != tc.streamable {
+			t.Errorf("%s: Streamable() = %v, want %v", tc.pattern, got, tc.streamable)
+		}
+	}
+
+	doc := parseXML(`<feed lang="en"><item><price>5</price></item></feed>`)
+	item := createNavigator(doc)
+	item.MoveToChild()
+	item.MoveToChild()
+	p, err := CompilePattern(`feed[@lang = 'en']/item[price > 10]`)
+	assertNoErr(t, err)
+	assertFalse(t, p.Matches(item))
+	assertTrue(t, p.MatchesOpen(item))
+	p, err = CompilePattern(`feed[@lang = 'fr']/item`)
+	assertNoErr(t, err)
+	assertFalse(t, p.MatchesOpen(item))
+}
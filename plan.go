@@ -0,0 +1,326 @@
+package xpath
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Plan describes how a compiled expression is evaluated, as returned by
+// Expr.Plan: a tree of operations with the optimizations that were
+// applied to them. Its String method prints it as an indented tree.
+type Plan struct {
+	// Op is the operation, such as "child::book", "filter", "union",
+	// "=" or "count()".
+	Op string
+	// Notes lists the optimizations applied to the operation, such as an
+	// index lookup or an early stop.
+	Notes []string
+	// Streamable reports whether the nodes are produced one at a time,
+	// without first collecting the node-set of the operation or of one of
+	// its inputs. It is true for operations that do not select nodes.
+	Streamable bool
+	// Inputs are the operations this one reads from. The predicate of a
+	// filter is its second input.
+	Inputs []*Plan
+	// Nodes is the number of nodes the operation produced, in a plan
+	// returned by Trace.Plan. The nodes that entered a filter are those
+	// of its first input, and Nodes survived it.
+	Nodes int
+
+	traced bool // Nodes is set
+}
+
+// String returns the plan as an indented tree, one operation per line.
+func (p *Plan) String() string {
+	var b strings.Builder
+	p.write(&b, 0)
+	return b.String()
+}
+
+func (p *Plan) write(b *strings.Builder, depth int) {
+	b.WriteString(strings.Repeat("  ", depth))
+	b.WriteString(p.Op)
+	if !p.Streamable {
+		b.WriteString(" [buffered]")
+	}
+	if len(p.Notes) > 0 {
+		b.WriteString(" (" + strings.Join(p.Notes, "; ") + ")")
+	}
+	if p.traced {
+		if p.Nodes == 1 {
+			b.WriteString(": 1 node")
+		} else {
+			fmt.Fprintf(b, ": %d nodes", p.Nodes)
+		}
+	}
+	b.WriteByte('\n')
+	for _, in := range p.Inputs {
+		in.write(b, depth+1)
+	}
+}
+
+// Plan returns a description of how the expression is evaluated, to see
+// why a query is slow or whether an optimization was applied.
+func (expr *Expr) Plan() *Plan {
+	// The expression is built again, recording what the queries do not
+	// keep, so that compiled expressions don't pay for it.
+	info := newPlanInfo()
+	q, err := build(expr.s, expr.opts, info)
+	if err != nil || q == nil {
+		return (*planInfo)(nil).describe(expr.q)
+	}
+	p := info.describe(q)
+	p.Notes = append(p.Notes, info.exprNotes...)
+	return p
+}
+
+// planInfo records what the builder knows about the queries it builds,
+// and the queries themselves do not keep, for Expr.Plan.
+type planInfo struct {
+	labels    map[query]string  // node test, function name or operator
+	args      map[query][]query // arguments of function calls
+	notes     map[query][]string
+	spans     map[query][2]int // offsets of operators and function calls
+	exprNotes []string
+}
+
+func newPlanInfo() *planInfo {
+	return &planInfo{
+		labels: make(map[query]string),
+		args:   make(map[query][]query),
+		notes:  make(map[query][]string),
+		spans:  make(map[query][2]int),
+	}
+}
+
+func (info *planInfo) note(q query, note string) {
+	if info != nil {
+		info.notes[q] = append(info.notes[q], note)
+	}
+}
+
+// add records the label of the query q built for the node n, and q as an
+// argument of the function call being built, if any.
+func (info *planInfo) add(q query, n node, parent *[]query) {
+	if info == nil || q == nil {
+		return
+	}
+	switch n := n.(type) {
+	case *axisNode:
+		info.labels[q] = nodeTestLabel(n)
+	case *functionNode:
+		info.labels[q] = n.FuncName
+		if n.end > 0 {
+			info.spans[q] = [2]int{n.start, n.end}
+		}
+	case *operatorNode:
+		info.labels[q] = n.Op
+		if n.end > 0 {
+			info.spans[q] = [2]int{n.start, n.end}
+		}
+	}
+	if parent != nil {
+		*parent = append(*parent, q)
+	}
+}
+
+// nodeTestLabel returns the node test of the step n, such as book, * or
+// text().
+func nodeTestLabel(n *axisNode) string {
+	switch {
+	case n.Prop != "":
+		return n.Prop + "()"
+	case n.LocalName == "" && n.Prefix == "":
+		if n.typeTest == allNode {
+			return "node()"
+		}
+		return "*"
+	case n.LocalName == "":
+		return n.Prefix + ":*"
+	case n.Prefix != "":
+		return n.Prefix + ":" + n.LocalName
+	}
+	return n.LocalName
+}
+
+// describe returns the plan of q.
+func (info *planInfo) describe(q query) *Plan {
+	if info == nil {
+		info = newPlanInfo()
+	}
+	p := &Plan{Streamable: true}
+	step := func(axis string, inputs ...query) {
+		test, ok := info.labels[q]
+		if !ok {
+			test = "node()"
+		}
+		p.Op = axis + "::" + test
+		for _, in := range inputs {
+			p.Inputs = append(p.Inputs, info.describe(in))
+		}
+	}
+	// streams is the number of leading inputs that produce the nodes of
+	// q; the others, such as predicates, don't affect Streamable.
+	streams := 0
+	switch q := q.(type) {
+	case *tracedQuery:
+		p = info.describe(q.query)
+		p.Nodes, p.traced = *q.nodes, true
+		return p
+	case *contextQuery:
+		p.Op = "context"
+	case *absoluteQuery:
+		p.Op = "root"
+	case *nopQuery:
+		p.Op = "empty"
+	case *ancestorQuery:
+		axis := "ancestor"
+		if q.Self {
+			axis = "ancestor-or-self"
+		}
+		step(axis, q.Input)
+		streams = 1
+	case *attributeQuery:
+		step("attribute", q.Input)
+		streams = 1
+	case *childQuery:
+		step("child", q.Input)
+		streams = 1
+	case *cachedChildQuery:
+		step("child", q.Input)
+		streams = 1
+	case *orderedChildQuery:
+		step("child", q.Input)
+		p.Notes = append(p.Notes, "selects the children of nested nodes in document order")
+		streams = 1
+	case *descendantQuery:
+		axis := "descendant"
+		if q.Self {
+			axis = "descendant-or-self"
+		}
+		step(axis, q.Input)
+		streams = 1
+	case *descendantOverDescendantQuery:
+		axis := "descendant"
+		if q.MatchSelf {
+			axis = "descendant-or-self"
+		}
+		step(axis, q.Input)
+		streams = 1
+	case *followingQuery:
+		axis := "following"
+		if q.Sibling {
+			axis = "following-sibling"
+		}
+		step(axis, q.Input)
+		streams = 1
+	case *precedingQuery:
+		axis := "preceding"
+		if q.Sibling {
+			axis = "preceding-sibling"
+		}
+		step(axis, q.Input)
+		streams = 1
+	case *parentQuery:
+		step("parent", q.Input)
+		streams = 1
+	case *hasChildQuery:
+		step("parent", q.Input)
+		streams = 1
+	case *selfQuery:
+		step("self", q.Input)
+		streams = 1
+	case *filterQuery:
+		p.Op = "filter"
+		if q.MaxPosition > 0 {
+			p.Notes = append(p.Notes, fmt.Sprintf("stops after position %d", q.MaxPosition))
+		}
+		if q.Position > 0 {
+			p.Notes = append(p.Notes, "compares positions without evaluating the predicate")
+		}
+		p.Inputs = []*Plan{info.describe(q.Input), info.describe(q.Predicate)}
+		streams = 1
+	case *lastQuery:
+		p.Op = "filter"
+		p.Streamable = false
+		p.Notes = append(p.Notes, fmt.Sprintf("keeps the last %d nodes of each context for last()", q.to+1))
+		p.Inputs = []*Plan{info.describe(q.Input)}
+		streams = 1
+	case *indexQuery:
+		p.Op = fmt.Sprintf("index lookup of @%s=%q", qualifiedName(q.prefix, q.localName), q.value)
+		p.Notes = append(p.Notes, "scans the document like its input if the navigator has no index for the attribute, see IndexAttr")
+		p.Inputs = []*Plan{info.describe(q.Input)}
+		streams = 1
+	case *mergeQuery:
+		p.Op = "for each"
+		p.Inputs = []*Plan{info.describe(q.Input), info.describe(q.Child)}
+		streams = 2
+	case *groupQuery:
+		p.Op = "group"
+		p.Inputs = []*Plan{info.describe(q.Input)}
+		streams = 1
+	case *documentOrderQuery:
+		p.Op = "document order"
+		if q.adjacent {
+			p.Notes = append(p.Notes, "removes the nodes that repeat the previous one")
+			streams = 1
+		} else {
+			p.Streamable = false
+			p.Notes = append(p.Notes, "sorts the nodes of its input and removes the duplicates")
+		}
+		p.Inputs = []*Plan{info.describe(q.Input)}
+	case *unionQuery:
+		p.Op = "union"
+		p.Streamable = false
+		p.Notes = append(p.Notes, "merges its inputs in document order")
+		p.Inputs = []*Plan{info.describe(q.Left), info.describe(q.Right)}
+	case *booleanQuery:
+		p.Op = "and"
+		if q.IsOr {
+			p.Op = "or"
+		}
+		p.Inputs = []*Plan{info.describe(q.Left), info.describe(q.Right)}
+	case *logicalQuery:
+		p.Op = info.labels[q]
+		p.Inputs = []*Plan{info.describe(q.Left), info.describe(q.Right)}
+	case *numericQuery:
+		p.Op = info.labels[q]
+		p.Inputs = []*Plan{info.describe(q.Left), info.describe(q.Right)}
+	case *functionQuery:
+		p.Op = info.labels[q] + "()"
+		for _, arg := range info.args[q] {
+			p.Inputs = append(p.Inputs, info.describe(arg))
+		}
+	case *transformFunctionQuery:
+		p.Op = info.labels[q] + "()"
+		p.Streamable = false
+		p.Inputs = []*Plan{info.describe(q.Input)}
+		streams = 1
+	case *lastFuncQuery:
+		p.Op = "last()"
+	case *contextValueQuery:
+		p.Op = "string(.)"
+		p.Notes = append(p.Notes, "computed once per predicate evaluation")
+	case *constantQuery:
+		if s, ok := q.Val.(string); ok {
+			p.Op = fmt.Sprintf("constant %q", s)
+		} else {
+			p.Op = fmt.Sprintf("constant %v", q.Val)
+		}
+	default:
+		p.Op = strings.TrimPrefix(fmt.Sprintf("%T", q), "*xpath.")
+	}
+	p.Notes = append(p.Notes, info.notes[q]...)
+	for i := 0; i < streams && i < len(p.Inputs); i++ {
+		p.Streamable = p.Streamable && p.Inputs[i].Streamable
+	}
+	return p
+}
+
+// qualifiedName returns prefix:localName, or localName without prefix.
+func qualifiedName(prefix, localName string) string {
+	if prefix == "" {
+		return localName
+	}
+	return prefix + ":" + localName
+}
@@ -0,0 +1,82 @@
+package xpath
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	for _, tc := range []struct {
+		expr       string
+		ops        []string // the operations from the top, first inputs only
+		note       string
+		streamable bool
+	}{
+		{`//book`, []string{"descendant::book", "root"}, "collapsed from descendant-or-self::node()/child::", true},
+		{`//book[@category='web']/title`, []string{"child::title", `index lookup of @category="web"`, "filter", "descendant::book"}, "see IndexAttr", true},
+		{`/bookstore/book[1]`, []string{"for each", "child::bookstore", "root"}, "", true},
+		{`//book[last()]`, []string{"document order", "for each", "descendant-or-self::node()", "root"}, "", false},
+		{`//book | //title`, []string{"union", "descendant::book"}, "merges its inputs in document order", false},
+		{`//book/..`, []string{"parent::node()", "root"}, "selects descendant-or-self::node()[child::book]", true},
+		{`count(//book)`, []string{"count()", "descendant::book"}, "", true},
+		{`//title[contains(., "a")]`, []string{"for each", "root"}, "", true},
+		{`/a/b[1]/c[x]/d[2]/e`, []string{"child::e", "for each"}, "", true},
+	} {
+		p := MustCompile(tc.expr).Plan()
+		assertEqual(t, tc.streamable, p.Streamable)
+		s := p.String()
+		if tc.note != "" && !strings.Contains(s, tc.note) {
+			t.Errorf("%s: expected note %q in\n%s", tc.expr, tc.note, s)
+		}
+		for i, op := range tc.ops {
+			if p == nil {
+				t.Fatalf("%s: missing operation %d, %q", tc.expr, i, op)
+			}
+			assertEqual(t, op, p.Op)
+			if len(p.Inputs) == 0 {
+				p = nil
+			} else {
+				p = p.Inputs[0]
+			}
+		}
+	}
+}
+
+func TestPlanNotes(t *testing.T) {
+	find := func(p *Plan, op string) *Plan {
+		var walk func(*Plan) *Plan
+		walk = func(p *Plan) *Plan {
+			if p.Op == op {
+				return p
+			}
+			for _, in := range p.Inputs {
+				if q := walk(in); q != nil {
+					return q
+				}
+			}
+			return nil
+		}
+		return walk(p)
+	}
+
+	p := MustCompile(`/bookstore/book[position() <= 2]`).Plan()
+	assertEqual(t, []string{"stops after position 2"}, find(p, "filter").Notes)
+	p = MustCompile(`/bookstore/book[2]`).Plan()
+	assertEqual(t, []string{"stops after position 2", "compares positions without evaluating the predicate"}, find(p, "filter").Notes)
+
+	p = MustCompile(`//book[last()]`).Plan()
+	f := find(p, "filter")
+	assertEqual(t, []string{"keeps the last 1 nodes of each context for last()"}, f.Notes)
+	assertTrue(t, find(f, "child::book") != nil)
+
+	p = MustCompile(`//title[contains(., "a")]`).Plan()
+	assertEqual(t, []string{"computed once per predicate evaluation"}, find(p, "string(.)").Notes)
+	assertEqual(t, 2, len(find(p, "contains()").Inputs))
+
+	exp, err := CompileWithOptions(`//book[@category = "web"][count(author) > 1]`, CompileOptions{ReorderPredicates: true})
+	assertNoErr(t, err)
+	p = exp.Plan()
+	assertEqual(t, []string{"predicates reordered by estimated cost"}, p.Notes[len(p.Notes)-1:])
+
+	assertTrue(t, strings.HasPrefix(MustCompile(`//a | //b`).Plan().String(), "union [buffered] (merges its inputs in document order)\n  descendant::a"))
+}
@@ -1,659 +1,338 @@
-package xpath
+package xpath_test
 
 import (
-	"bytes"
+	"errors"
+	"flag"
 	"fmt"
-	"os"
-	"os/exec"
 	"strings"
 	"testing"
 
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+	"github.com/antchfx/xpath/xpathtest"
 	"pgregory.net/rapid"
 )
 
-// Check if xmllint command exists and skip tests if not.
-func checkXmllintAvailability(t *testing.T) {
-	t.Helper()
-	_, err := exec.LookPath("xmllint")
-	if err != nil {
-		t.Skip("xmllint command not found in PATH, skipping differential tests.")
-	}
-}
+var reference = flag.Bool("reference", false, "compare with xpathtest.Reference in the differential tests if neither libxml2 nor xmllint is available")
 
-// Limited set of tags for generation to increase match probability.
-var htmlTags = []string{"div", "p", "span", "a", "b", "i", "table", "tr", "td"}
-
-// Limited set of attribute names.
-var htmlAttrs = []string{"id", "class", "href", "title", "style"}
+// TestPropertyDifferential checks that the expressions another engine,
+// the xpathtest.DefaultOracle, accepts compile, and that their values on a
+// random document are the same as those of the oracle: the same string,
+// and for a node-set the same nodes, in the same order, and the same
+// values of number(), boolean() and, for a node-set, count(). The
+// expressions are guided toward the constructs the run covers the least.
+func TestPropertyDifferential(t *testing.T) {
+	checkDifferential(t, xpathtest.DefaultConfig())
+}
 
-// genTNode generates a random TNode tree resembling simple HTML.
-// Declared at package level to allow recursive definition in init().
-var genTNode *rapid.Generator[*TNode]
+// TestPropertyDifferentialNamespaces is TestPropertyDifferential with
+// documents in default and prefixed namespaces, and prefixes bound in the
+// expressions.
+func TestPropertyDifferentialNamespaces(t *testing.T) {
+	cfg := xpathtest.DefaultConfig()
+	cfg.Namespaces = map[string]string{"": "urn:a", "a": "urn:a", "b": "urn:b"}
+	checkDifferential(t, cfg)
+}
 
-func init() {
-	genTNode = rapid.Custom(func(t *rapid.T) *TNode {
-		// Decide node type: element or text. Bias towards elements initially.
-		// Limit recursion depth implicitly by reducing probability of elements at deeper levels,
-		// or explicitly pass depth (more complex). Let's rely on rapid's size control for now.
-		isElement := rapid.Bool().Draw(t, "isElement")
-		if !isElement {
-			// Generate a text node from a limited set.
-			text := rapid.SampledFrom([]string{"", "foo", "bar"}).Draw(t, "textData")
-			return createNode(text, TextNode)
+func checkDifferential(testingT *testing.T, cfg xpathtest.Config) {
+	oracle := xpathtest.DefaultOracle()
+	if oracle == nil && *reference {
+		oracle = xpathtest.Reference()
+	}
+	if oracle == nil {
+		testingT.Skip("xmllint command not found in PATH and libxml2 build tag not set, skipping differential tests; run with -reference to compare with xpathtest.Reference.")
+	}
+	cfg.Coverage = new(xpath.Coverage)
+	exporter := xpathtest.NewReproExporter(testingT, "testdata/repro")
+
+	rapid.Check(exporter, func(t *rapid.T) {
+		// The document is parsed back, to evaluate the expressions on the
+		// same nodes as the oracle, without empty or adjacent text nodes.
+		data := []byte(cfg.Document().Draw(t, "doc").OutputXML(false))
+		doc, err := dom.ParseBytes(data)
+		if err != nil {
+			t.Fatalf("%v\n%s", err, data)
+		}
+		exprStr := cfg.Expr().Draw(t, "expr")
+		cfg.Coverage.Record(exprStr)
+		if strings.Contains(exprStr, "id(") {
+			// The oracles only know the IDs declared by a DTD.
+			return
 		}
 
-		// Generate an element node.
-		tag := rapid.SampledFrom(htmlTags).Draw(t, "tag")
-		node := createNode(tag, ElementNode)
+		want, err := oracle.Evaluate(data, exprStr, cfg.Namespaces)
+		if errors.Is(err, xpathtest.ErrRejected) {
+			// We assume the oracle is correct about syntax errors, and
+			// about the functions XPath 1.0 does not have.
+			t.Logf("%s rejected expr %q, skipping", oracle.Name(), exprStr)
+			return
+		}
+		if err != nil {
+			testingT.Fatal(err)
+		}
 
-		// Add attributes sometimes.
-		if rapid.Bool().Draw(t, "hasAttrs") {
-			numAttrs := rapid.IntRange(0, 3).Draw(t, "numAttrs")
-			for i := 0; i < numAttrs; i++ {
-				attrName := rapid.SampledFrom(htmlAttrs).Draw(t, fmt.Sprintf("attrName%d", i))
-				// Ensure unique attribute names for simplicity, though not strictly required by HTML/XML.
-				// This simple generator might add duplicate attrs, which is fine for crash testing.
-				// Generate attribute value from a limited set.
-				attrVal := rapid.SampledFrom([]string{"", "foo", "bar"}).Draw(t, fmt.Sprintf("attrVal%d", i))
-				node.addAttribute(attrName, attrVal)
-			}
+		got, err := xpathtest.Evaluate(doc, exprStr, cfg.Namespaces)
+		if err != nil {
+			t.Fatalf("failed to compile expr %q which %s accepted: %v\nDocument:\n%s", exprStr, oracle.Name(), err, data)
+		}
+		if d := xpathtest.Diff(want, got); d != "" {
+			exporter.Record(data, exprStr, cfg.Namespaces, want)
+			t.Fatalf("%s differs from %s:\n%sDocument:\n%s", exprStr, oracle.Name(), d, data)
 		}
 
-		// Add children sometimes. Limit depth and breadth via rapid's size control.
-		if rapid.Bool().Draw(t, "hasChildren") {
-			numChildren := rapid.IntRange(1, 5).Draw(t, "numChildren")
-			for i := 0; i < numChildren; i++ {
-				// Recursively generate child node using the already defined generator.
-				child := genTNode.Draw(t, fmt.Sprintf("child%d", i))
-				// Add the generated child node using the new AddChild method.
-				node.AddChild(child)
+		// The values are compared as strings: the conversions to numbers
+		// and booleans, and the counts of the node-sets, are compared too.
+		wrappers := []string{"number(%s)", "boolean(%s)"}
+		if want.NodeSet {
+			wrappers = append(wrappers, "count(%s)")
+		}
+		for _, wrapper := range wrappers {
+			wrapped := fmt.Sprintf(wrapper, exprStr)
+			want, err := oracle.Evaluate(data, wrapped, cfg.Namespaces)
+			if errors.Is(err, xpathtest.ErrRejected) {
+				continue
+			}
+			if err != nil {
+				testingT.Fatal(err)
+			}
+			got, err := xpathtest.Evaluate(doc, wrapped, cfg.Namespaces)
+			if err != nil {
+				t.Fatalf("failed to compile expr %q which %s accepted: %v\nDocument:\n%s", wrapped, oracle.Name(), err, data)
+			}
+			if d := xpathtest.Diff(want, got); d != "" {
+				exporter.Record(data, wrapped, cfg.Namespaces, want)
+				t.Fatalf("%s differs from %s:\n%sDocument:\n%s", wrapped, oracle.Name(), d, data)
 			}
 		}
-
-		return node
-	})
-}
-
-// genStringLiteral generates a random XPath string literal.
-func genStringLiteral() *rapid.Generator[string] {
-	// Using a limited set of simple strings for literals.
-	// Ensure generated strings don't contain the quote character used.
-	// Rapid's StringOf generator could be used for more complex strings,
-	// but requires careful handling of escaping.
-	return rapid.Custom(func(t *rapid.T) string {
-		quote := rapid.SampledFrom([]string{"'", "\""}).Draw(t, "quote")
-		// Simple content, avoiding the chosen quote. More robust generation
-		// would handle escaping or filter characters.
-		content := rapid.SampledFrom([]string{"", "foo", "bar", "test", "data"}).Draw(t, "content")
-		return quote + content + quote
 	})
+	testingT.Logf("not covered: %v", cfg.Coverage.Uncovered())
 }
 
-// genNumberLiteral generates a random XPath number literal (integer for simplicity).
-func genNumberLiteral() *rapid.Generator[string] {
-	return rapid.Custom(func(t *rapid.T) string {
-		// Generate small integers, positive and negative.
-		num := rapid.IntRange(-10, 100).Draw(t, "number")
-		return fmt.Sprintf("%d", num)
-	})
+// TestRepros runs the failing cases of the differential tests, exported
+// to testdata/repro, with the results of their oracle.
+func TestRepros(t *testing.T) {
+	xpathtest.RunRepros(t, "testdata/repro")
 }
 
-// Forward declaration for recursive use in generators.
-var genRelativePathExpr *rapid.Generator[string]
-var genPredicateContent *rapid.Generator[string]
-
-func init() {
-	// Define genRelativePathExpr here or ensure it's defined before use in genPredicateContent.
-	// We'll define it later, but the forward declaration allows compilation.
-
-	// genPredicateContent generates expressions suitable for inside [...].
-	genPredicateContent = rapid.Custom(func(t *rapid.T) string {
-		// Choose the type of predicate expression.
-		// Weights can be adjusted based on desired frequency.
-		return rapid.OneOf(
-			// Index predicate: [1], [last()]
-			rapid.Just("last()"),
-			genNumberLiteral(),
-			// Boolean predicate: [foo], [@id='bar'], [text()='foo'], [count(a)>0]
-			genRelativePathExpr, // Represents existence check, e.g., [element]
-			rapid.Custom(func(t *rapid.T) string { // Simple comparison: path = literal
-				// Generate a simple path, often an attribute or text()
-				lhsPath := rapid.OneOf(
-					rapid.Just("text()"),
-					rapid.Just("."),
-					rapid.Custom(func(t *rapid.T) string { return "@" + rapid.SampledFrom(htmlAttrs).Draw(t, "attrName") }),
-					rapid.SampledFrom(htmlTags), // Simple element name test
-				).Draw(t, "lhsPath")
-
-				// Add more comparison operators
-				op := rapid.SampledFrom([]string{"=", "!=", "<", "<=", ">", ">="}).Draw(t, "compOp")
-
-				// Generate a literal for the RHS.
-				// If LHS is text(), RHS should be a string for robust comparison.
-				// Otherwise, it can be a string or a number.
-				var rhsLiteral string
-				if lhsPath == "text()" {
-					rhsLiteral = genStringLiteral().Draw(t, "rhsLiteralString")
-				} else {
-					rhsLiteral = rapid.OneOf(genStringLiteral(), genNumberLiteral()).Draw(t, "rhsLiteralMixed")
-				}
-
-				return fmt.Sprintf("%s %s %s", lhsPath, op, rhsLiteral)
-			}),
-			rapid.Custom(func(t *rapid.T) string { // Function call predicate: [contains(., 'foo')]
-				funcName := rapid.SampledFrom([]string{"contains", "starts-with"}).Draw(t, "funcName")
-				// Argument 1: often context node or attribute/text
-				arg1 := rapid.OneOf(
-					rapid.Just("."),
-					rapid.Just("text()"),
-					rapid.Custom(func(t *rapid.T) string { return "@" + rapid.SampledFrom(htmlAttrs).Draw(t, "attrName") }),
-				).Draw(t, "funcArg1")
-				// Argument 2: string literal
-				arg2 := genStringLiteral().Draw(t, "funcArg2")
-				return fmt.Sprintf("%s(%s, %s)", funcName, arg1, arg2)
-			}),
-			// Add more complex predicates: position(), count(), boolean logic (and/or)
-		).Draw(t, "predicateContent")
-	})
-}
-
-// genPredicate generates a full predicate expression: '[' + content + ']'.
-func genPredicate() *rapid.Generator[string] {
-	return rapid.Custom(func(t *rapid.T) string {
-		content := genPredicateContent.Draw(t, "content")
-		return "[" + content + "]"
-	})
-}
-
-// genAxis generates a random XPath axis.
-func genAxis() *rapid.Generator[string] {
-	axes := []string{
-		"child", "descendant", "parent", "ancestor", "following-sibling",
-		"preceding-sibling", "following", "preceding", "attribute", "self",
-		"descendant-or-self", "ancestor-or-self",
-		// "namespace", // Deprecated and often unsupported
+// TestPropertyMutations checks that the preserving xpath.Mutations of the
+// expressions have their values on a random document, and, with the
+// oracle of TestPropertyDifferential, that the values of the others
+// change for the xpath package exactly when they do for the oracle.
+func TestPropertyMutations(testingT *testing.T) {
+	oracle := xpathtest.DefaultOracle()
+	if oracle == nil && *reference {
+		oracle = xpathtest.Reference()
 	}
-	return rapid.SampledFrom(axes)
-}
-
-// genNodeTest generates a random XPath node test (name test or kind test).
-func genNodeTest() *rapid.Generator[string] {
-	return rapid.OneOf(
-		// Name tests
-		rapid.Just("*"),
-		rapid.SampledFrom(htmlTags),
-		// Kind tests
-		rapid.Just("node()"),
-		rapid.Just("text()"),
-		// element() and attribute() are XPath 2.0/3.0, not 1.0
-		// rapid.Just("element()"),
-		// rapid.Just("attribute()"),
-		// More specific kind tests (less likely to match simple generated docs, and also XPath 1.0)
-		rapid.Just("comment()"), // Enable comment() node test
-		// rapid.Just("processing-instruction()"), // Often requires a name argument
-	)
-}
-
-// genStep generates a single XPath step (axis::nodetest[predicate1][predicate2]...).
-func genStep() *rapid.Generator[string] {
-	return rapid.Custom(func(t *rapid.T) string {
-		axis := genAxis().Draw(t, "axis")
-		nodeTest := genNodeTest().Draw(t, "nodeTest")
-		stepBase := ""
-		// Abbreviated syntax for common cases
-		// Ensure axis and nodeTest are compatible before potentially abbreviating.
-		canAbbreviateChild := axis == "child" && nodeTest != "attribute()" && nodeTest != "comment()" && nodeTest != "processing-instruction()"
-		canAbbreviateAttr := axis == "attribute" && nodeTest != "element()" && nodeTest != "text()" && nodeTest != "node()" && nodeTest != "comment()" && nodeTest != "processing-instruction()"
+	cfg := xpathtest.DefaultConfig()
 
-		useAbbreviation := rapid.Bool().Draw(t, "useAbbreviation")
-
-		if useAbbreviation && canAbbreviateChild {
-			stepBase = nodeTest // Abbreviated child axis
-		} else if useAbbreviation && canAbbreviateAttr {
-			if nodeTest == "attribute()" || nodeTest == "*" {
-				stepBase = "@*" // Abbreviated attribute::*
-			} else {
-				stepBase = "@" + nodeTest // Abbreviated attribute axis name test
-			}
-		} else {
-			// Default to full syntax if abbreviation is not chosen or not applicable
-			stepBase = axis + "::" + nodeTest
+	rapid.Check(testingT, func(t *rapid.T) {
+		data := []byte(cfg.Document().Draw(t, "doc").OutputXML(false))
+		doc, err := dom.ParseBytes(data)
+		if err != nil {
+			t.Fatalf("%v\n%s", err, data)
+		}
+		exprStr := cfg.Expr().Draw(t, "expr")
+		mutations, err := xpath.Mutations(exprStr)
+		if err != nil {
+			t.Fatalf("%s: %v", exprStr, err)
+		}
+		got, ok := evaluateRecovered(doc, exprStr)
+		if !ok {
+			// The evaluations that fail, such as that of sum() of a string
+			// that is not a number, are not compared.
+			return
+		}
+		var want xpathtest.Result
+		compare := oracle != nil && !strings.Contains(exprStr, "id(")
+		if compare {
+			want, err = oracle.Evaluate(data, exprStr, nil)
+			compare = err == nil
 		}
 
-		// Add predicates sometimes
-		predicates := ""
-		if rapid.Bool().Draw(t, "hasPredicates") {
-			numPredicates := rapid.IntRange(1, 2).Draw(t, "numPredicates") // 1 or 2 predicates
-			for i := 0; i < numPredicates; i++ {
-				// Ensure genPredicateContent is initialized before drawing from genPredicate
-				if genPredicateContent == nil {
-					// This might happen if init order is tricky. Log or handle.
-					// For now, assume init() worked correctly.
-					t.Fatalf("genPredicateContent is nil, initialization order issue?")
-				}
-				predicates += genPredicate().Draw(t, fmt.Sprintf("predicate%d", i))
+		for _, m := range mutations {
+			mgot, ok := evaluateRecovered(doc, m.Expr)
+			if !ok {
+				continue
+			}
+			changed := xpathtest.Diff(got, mgot)
+			if m.Preserving && changed != "" {
+				t.Fatalf("%s mutation %s of %s changes the value:\n%sDocument:\n%s", m.Kind, m.Expr, exprStr, changed, data)
+			}
+			if !compare {
+				continue
+			}
+			mwant, err := oracle.Evaluate(data, m.Expr, nil)
+			if err != nil {
+				continue
+			}
+			// The values of xpath are compared as the oracle gives them:
+			// Xmllint only has their strings.
+			changed = xpathtest.Diff(oracleView(got, want), oracleView(mgot, mwant))
+			if oracleChanged := xpathtest.Diff(want, mwant) != ""; oracleChanged != (changed != "") {
+				t.Fatalf("%s mutation %s of %s changes the value for %s: %v, for xpath: %v\nDocument:\n%s", m.Kind, m.Expr, exprStr, oracle.Name(), oracleChanged, changed != "", data)
 			}
 		}
-
-		return stepBase + predicates
 	})
 }
 
-// genRelativePathExpr generates a relative XPath expression (sequence of steps).
-// Now defined using the forward declaration.
-func init() {
-	// Assign the actual generator function to the forward-declared variable.
-	// This breaks the init cycle dependency if genPredicateContent needs genRelativePathExpr.
-	genRelativePathExpr = rapid.Custom(func(t *rapid.T) string {
-		// Generate the number of steps first.
-		numSteps := rapid.IntRange(1, 3).Draw(t, "numSteps") // Reduced max steps slightly
-		steps := make([]string, numSteps)
-		for i := 0; i < numSteps; i++ {
-			steps[i] = genStep().Draw(t, fmt.Sprintf("step%d", i))
-		}
-		// Join steps with / or //
-		separator := rapid.SampledFrom([]string{"/", "//"}).Draw(t, "separator")
-		// Avoid leading // if the path starts relative, although parser might handle it.
-		// Let's keep it simple: join all with the chosen separator.
-		return strings.Join(steps, separator)
-	})
+// oracleView returns the parts of the Result r of xpath that the Result o
+// of an oracle has: only the string if o is not a node-set, and no
+// fragments if o has none.
+func oracleView(r, o xpathtest.Result) xpathtest.Result {
+	if !o.NodeSet {
+		return xpathtest.Result{String: r.String}
+	}
+	if o.Fragments == nil {
+		r.Fragments = nil
+	}
+	return r
 }
 
-// genSimpleFunctionCall generates calls to common XPath functions.
-func genSimpleFunctionCall() *rapid.Generator[string] {
-	return rapid.Custom(func(t *rapid.T) string {
-		// Select a function name from the list supported in the README
-		funcName := rapid.SampledFrom([]string{
-			// Core XPath 1.0
-			"boolean", "ceiling", "concat", "contains", "count", "false", "floor",
-			"last", "local-name", "name", "namespace-uri", "normalize-space",
-			"not", "number", "position", "round", "starts-with", "string",
-			"string-length", "substring", "substring-after", "substring-before",
-			"sum", "translate", "true",
-			// Added from README (potentially XPath 2.0+)
-			"ends-with", "lower-case", "matches", "replace", "reverse", "string-join",
-			// lang() is explicitly marked as unsupported (✗) in the README.
-			// "lang",
-		}).Draw(t, "funcName")
-
-		// Generate arguments based on the function
-		// This is simplified; a real implementation needs function signatures.
-		args := ""
-		numArgs := 0
-		switch funcName {
-		// Functions that can take 0 or 1 argument (node-set/path)
-		case "string", "boolean", "number", "name", "namespace-uri", "local-name", "normalize-space":
-			if rapid.Bool().Draw(t, "hasArg") {
-				arg := rapid.OneOf(rapid.Just("."), genRelativePathExpr).Draw(t, "arg0")
-				args = arg
-				numArgs = 1
-			}
-		// count() and sum() MUST take exactly 1 argument (node-set)
-		case "count", "sum":
-			numArgs = 1
-			// Argument must evaluate to a node-set.
-			args = rapid.OneOf(rapid.Just("."), genRelativePathExpr).Draw(t, "arg0")
-		case "concat": // 2+ arguments
-			numArgs = rapid.IntRange(2, 4).Draw(t, "numConcatArgs")
-			argList := make([]string, numArgs)
-			for i := 0; i < numArgs; i++ {
-				// Args are typically strings or expressions evaluating to strings
-				argList[i] = rapid.OneOf(genStringLiteral(), genRelativePathExpr).Draw(t, fmt.Sprintf("concatArg%d", i))
-			}
-			args = strings.Join(argList, ", ")
-		case "starts-with", "contains": // 2 arguments (string, string)
-			numArgs = 2
-			arg1 := rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-			arg2 := genStringLiteral().Draw(t, "strArg2") // Second arg usually literal
-			args = fmt.Sprintf("%s, %s", arg1, arg2)
-		case "substring-before", "substring-after": // 2 arguments (string, string)
-			numArgs = 2
-			arg1 := rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-			arg2 := genStringLiteral().Draw(t, "strArg2")
-			args = fmt.Sprintf("%s, %s", arg1, arg2)
-		case "substring": // 2 or 3 arguments (string, number, number?)
-			numArgs = rapid.IntRange(2, 3).Draw(t, "numSubstringArgs")
-			arg1 := rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-			// XPath substring index is 1-based. Generate positive integers for start position.
-			// Use a similar range to genNumberLiteral's positive side.
-			startPos := rapid.IntRange(1, 100).Draw(t, "substringStartPos")
-			arg2 := fmt.Sprintf("%d", startPos) // Convert generated int to string
-			if numArgs == 3 {
-				// The third argument (length) can be any number, including negative/zero,
-				// though negative/zero length might result in empty strings. Use genNumberLiteral here.
-				arg3 := genNumberLiteral().Draw(t, "numArg3")
-				args = fmt.Sprintf("%s, %s, %s", arg1, arg2, arg3)
-			} else {
-				args = fmt.Sprintf("%s, %s", arg1, arg2)
-			}
-		case "string-length": // 1 argument (string) - Parser requires one argument.
-			numArgs = 1
-			// Argument needs to evaluate to string.
-			args = rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-		case "translate": // 3 arguments (string, string, string)
-			numArgs = 3
-			arg1 := rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-			arg2 := genStringLiteral().Draw(t, "strArg2")
-			arg3 := genStringLiteral().Draw(t, "strArg3")
-			args = fmt.Sprintf("%s, %s, %s", arg1, arg2, arg3)
-		case "not": // 1 argument (boolean)
-			numArgs = 1
-			// Argument needs to evaluate to boolean, e.g., a path, comparison, or function call
-			// For simplicity, use a relative path or another simple function for now.
-			arg := rapid.OneOf(genRelativePathExpr, rapid.Just("true()"), rapid.Just("false()")).Draw(t, "boolArg1")
-			args = arg
-		// case "lang": // Removed as it's unsupported by the library.
-		// 	numArgs = 1
-		// 	args = genStringLiteral().Draw(t, "langArg1")
-		// Functions with no arguments:
-		case "true", "false", "position", "last":
-			numArgs = 0
-		// Numeric functions often take node-sets:
-		case "floor", "ceiling", "round":
-			numArgs = 1
-			// Argument needs to evaluate to number. Use path or number literal.
-			args = rapid.OneOf(genRelativePathExpr, genNumberLiteral()).Draw(t, "numArg1")
-		// Handle newly added functions (simplified argument generation)
-		case "ends-with": // 2 args (string, string)
-			numArgs = 2
-			arg1 := rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-			arg2 := genStringLiteral().Draw(t, "strArg2")
-			args = fmt.Sprintf("%s, %s", arg1, arg2)
-		case "lower-case": // 1 arg (string)
-			numArgs = 1
-			args = rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-		case "matches": // 2-3 args (string, pattern, flags?) - Generate 2 args for simplicity
-			numArgs = 2
-			arg1 := rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-			// Pattern is a string literal (regex) - keep simple
-			arg2 := genStringLiteral().Draw(t, "regexPattern")
-			args = fmt.Sprintf("%s, %s", arg1, arg2)
-		case "replace": // 3 args (string, pattern, replacement)
-			numArgs = 3
-			arg1 := rapid.OneOf(rapid.Just("."), genRelativePathExpr, genStringLiteral()).Draw(t, "strArg1")
-			arg2 := genStringLiteral().Draw(t, "regexPattern")
-			arg3 := genStringLiteral().Draw(t, "replacementStr")
-			args = fmt.Sprintf("%s, %s, %s", arg1, arg2, arg3)
-		case "reverse": // 1 arg (node-set?) - Treat as string for simplicity? Spec unclear for 1.0 context.
-			// Let's assume it takes a path expression.
-			numArgs = 1
-			args = genRelativePathExpr.Draw(t, "pathArg1")
-		case "string-join": // 2 args (node-set?, separator)
-			numArgs = 2
-			// First arg is often path, second is string literal separator
-			arg1 := genRelativePathExpr.Draw(t, "pathArg1")
-			arg2 := genStringLiteral().Draw(t, "separatorStr")
-			args = fmt.Sprintf("%s, %s", arg1, arg2)
-
-		default:
-			// Fallback for functions not explicitly handled (likely 0 args like true, false, position, last)
-			// Check if the function *should* have args based on its name
-			// For now, assume 0 args if not explicitly handled above.
-			numArgs = 0
+// evaluateRecovered is xpathtest.Evaluate, reporting whether it succeeds
+// rather than failing or panicking.
+func evaluateRecovered(doc *dom.Node, expr string) (r xpathtest.Result, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
 		}
-
-		return fmt.Sprintf("%s(%s)", funcName, args)
-	})
+	}()
+	r, err := xpathtest.Evaluate(doc, expr, nil)
+	return r, err == nil
 }
 
-// genXPathExpr generates a simple absolute or relative XPath expression,
-// potentially starting with '/', '//', or being a function call.
-func genXPathExpr() *rapid.Generator[string] {
-	// Use OneOf to decide the top-level structure
-	return rapid.OneOf(
-		// Option 1: Path expression (absolute or relative)
-		rapid.Custom(func(t *rapid.T) string {
-			// Start with / or // or relative path
-			start := rapid.SampledFrom([]string{"/", "//", ""}).Draw(t, "start")
-			if start == "" && rapid.Bool().Draw(t, "forceAbsolute") {
-				// Ensure we don't generate empty expressions often
-				start = "/"
-			}
-
-			// Generate the relative path part
-			// Ensure genRelativePathExpr is initialized
-			if genRelativePathExpr == nil {
-				t.Fatalf("genRelativePathExpr is nil during genXPathExpr generation")
+// TestPropertyNamespaceNameTests checks that namespaced name tests select
+// the elements and attributes with the expected namespace URL and local
+// name, binding the document prefixes to different ones in the expression.
+func TestPropertyNamespaceNameTests(t *testing.T) {
+	cfg := xpathtest.DefaultConfig()
+	cfg.Namespaces = map[string]string{"a": "urn:a", "b": "urn:b"}
+	bindings := map[string]string{"x": "urn:a", "y": "urn:b"}
+	rename := map[string]string{"a": "x", "b": "y"}
+
+	rapid.Check(t, func(t *rapid.T) {
+		doc := cfg.Document().Draw(t, "doc")
+		attr := rapid.Bool().Draw(t, "attr")
+		test := cfg.NameTest(attr).Draw(t, "test")
+
+		prefix, local := "", test
+		if i := strings.IndexByte(test, ':'); i >= 0 {
+			prefix, local = test[:i], test[i+1:]
+		}
+		match := func(p, name string) bool {
+			if test == "*" {
+				return true
 			}
-			relativePath := genRelativePathExpr.Draw(t, "relativePath")
-
-			// Handle edge cases like "/" or "//" which might need a path following
-			if (start == "/" || start == "//") && relativePath == "" {
-				// Avoid generating just "/" or "//" if relativePath is empty.
-				// Append a simple node test if needed.
-				relativePath = "node()"
-			} else if start == "" && relativePath == "" {
-				// Avoid generating completely empty string. Default to context node.
-				return "."
+			return p == prefix && (local == "*" || name == local)
+		}
+		want := 0
+		var walk func(*dom.Node)
+		walk = func(n *dom.Node) {
+			if n.Type != dom.ElementNode {
+				return
 			}
-
-			// Combine start and relative path
-			// Need to be careful about "//" followed by potentially empty relative path
-			// or "/" followed by empty. The logic above tries to prevent empty relativePath
-			// when start is / or //.
-			return start + relativePath
-		}),
-		// Option 2: Top-level function call
-		genSimpleFunctionCall(),
-		// Option 3: Simple literal (less common as top-level expression but possible)
-		// genStringLiteral(),
-		// genNumberLiteral(),
-		// TODO: Add UnionExpr ('|'), Operators (+, -, =, etc.) at the top level
-	)
-
-	// Original simpler implementation:
-	/*
-	return rapid.Custom(func(t *rapid.T) string {
-		// Start with / or // or relative path
-		start := rapid.SampledFrom([]string{"/", "//", ""}).Draw(t, "start")
-		if start == "" && rapid.Bool().Draw(t, "forceAbsolute") {
-			// Ensure we don't generate empty expressions often
-			start = "/"
-		}
-		if start == "/" || start == "//" {
-			// Handle cases like "/" or "//" which might need a path following
-			if rapid.Bool().Draw(t, "hasRelativePath") || start == "//" { // // needs a path
-				relativePath := genRelativePathExpr().Draw(t, "relativePath")
-				// Avoid "//" followed by empty relative path if genRelativePathExpr could return empty
-				if relativePath == "" {
-					relativePath = "node()" // Default to something simple
+			if attr {
+				for _, a := range n.Attr {
+					if a.Prefix != "xmlns" && match(a.Prefix, a.Name) {
+						want++
+					}
 				}
-				return start + relativePath
+			} else if match(n.Prefix, n.Data) {
+				want++
+			}
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				walk(child)
 			}
-			// Just "/" is handled by the logic ensuring relativePath is non-empty if start is "/"
-			// return "/" // This case is now covered above
-
 		}
-		// Relative path start is handled when start == ""
-		// return genRelativePathExpr.Draw(t, "relativePath") // Covered by start + relativePath logic
-	})
-	*/
-}
-
-// Static XML content for basic xmllint syntax validation.
-const staticXMLContent = `<?xml version="1.0" encoding="UTF-8"?>
-<root>
-  <child id="a">foo</child>
-  <child id="b" value="123"/>
-  <!-- comment -->
-</root>
-`
-
-// TestPropertyXPathValidity checks that if xmllint successfully parses an XPath expression,
-// then antchfx/xpath also parses and evaluates it without errors or panics against a
-// randomly generated document. It does *not* compare the results.
-func TestPropertyXPathValidity(testingT *testing.T) {
-	checkXmllintAvailability(testingT)            // Skip if xmllint is not available
-	testingT.Log("Starting TestPropertyXPathValidity...") // Log entry into the test function
-
-	// Create a temporary file for the static XML content once for the test run.
-	tmpDir := testingT.TempDir()
-	staticTmpFile, err := os.CreateTemp(tmpDir, "static-xpath-test-*.xml")
-	if err != nil {
-		testingT.Fatalf("Failed to create static temp file: %v", err)
-	}
-	_, err = staticTmpFile.WriteString(staticXMLContent)
-	if err != nil {
-		staticTmpFile.Close()
-		testingT.Fatalf("Failed to write static temp file: %v", err)
-	}
-	err = staticTmpFile.Close()
-	if err != nil {
-		testingT.Fatalf("Failed to close static temp file: %v", err)
-	}
-	staticTmpFilePath := staticTmpFile.Name()
-	// Temp dir cleanup is handled by testingT.TempDir()
-
-	rapid.Check(testingT, func(t *rapid.T) { // Pass testingT to Check, use t for rapid.T
-		// 1. Generate a random document tree for antchfx evaluation later.
-		rootNode := genTNode.Filter(func(n *TNode) bool { return n.Type == ElementNode }).Draw(t, "doc")
-		// Wrap the root element in a document node? The tests seem to use element nodes directly as roots.
-		// Let's stick with element root for now.
-
-		// 2. Generate a random XPath expression string
-		exprStr := genXPathExpr().Draw(t, "expr")
-
-		// t.Logf("Testing expression: %s", exprStr)
-
-		// 3. Execute xmllint with the generated expression against the STATIC file
-		//    to check if xmllint considers the expression syntactically valid.
-		cmd := exec.Command("xmllint", "--xpath", exprStr, staticTmpFilePath)
-		var xmllintStdout, xmllintStderr bytes.Buffer
-		cmd.Stdout = &xmllintStdout
-		cmd.Stderr = &xmllintStderr
-		cmdErr := cmd.Run()
-
-		// Check xmllint execution status.
-		// libxml exit codes: http://xmlsoft.org/xmllint.html
-		exitCode := 0
-		if exitErr, ok := cmdErr.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		}
-		// Codes 1-9 are parsing/validation errors in the XML itself.
-		// Code 10 is "XPath evaluation returned no result".
-		// Code 11 is "Error evaluating the XPath expression".
-		// Code 12 is "Error building the context for XPath evaluation".
+		walk(doc.FirstChild)
 
-		if exitCode == 11 {
-			// xmllint considers the expression invalid. Skip antchfx check for this case.
-			// We assume xmllint is correct about syntax errors.
-			t.Logf("xmllint rejected expr %q (exit code 11), skipping antchfx check.\nStderr: %s", exprStr, xmllintStderr.String())
-			return // Skip to the next rapid iteration
+		if prefix != "" {
+			test = rename[prefix] + ":" + local
 		}
-
-		// Handle other xmllint errors (XML errors, context errors, command errors)
-		if cmdErr != nil && !(exitCode == 0 || exitCode == 10) {
-			// Fail if xmllint had errors other than "invalid XPath" (11) or "no result" (10).
-			// This could indicate problems with the static XML file, xmllint setup, etc.
-			testingT.Fatalf("xmllint failed unexpectedly (exit code %d) for expr %q on static file %s:\nStderr: %s\nStdout: %s\nStatic XML:\n%s",
-				exitCode, exprStr, staticTmpFilePath, xmllintStderr.String(), xmllintStdout.String(), staticXMLContent)
+		expr := "count(//" + test + ")"
+		if attr {
+			expr = "count(//@" + test + ")"
 		}
-
-		// If we reach here, xmllint exit code was 0 or 10, meaning it parsed the expression.
-		// Now, check if antchfx/xpath also parses and evaluates it without error/panic
-		// using the RANDOMLY generated document.
-
-		// 4. Compile the expression with antchfx/xpath
-		antchfxExpr, err := Compile(exprStr)
+		exp, err := xpath.CompileWithNS(expr, bindings)
 		if err != nil {
-			// xmllint accepted it, but antchfx didn't. This is a failure.
-			t.Fatalf("antchfx/xpath failed to compile expr %q which xmllint accepted (exit code %d):\nError: %v\nRandom Document XML:\n%s\nxmllint Stderr:\n%s",
-				exprStr, exitCode, err, nodeToXMLString(rootNode), xmllintStderr.String())
+			t.Fatalf("failed to compile %q: %v", expr, err)
 		}
+		if got := exp.Evaluate(dom.CreateNavigator(doc)); got != float64(want) {
+			t.Fatalf("%s = %v, want %d\n%s", expr, got, want, doc.OutputXML(false))
+		}
+	})
+}
 
-		// 5. Evaluate the expression with antchfx/xpath against the random document
-		//    The primary goal is to catch panics. The result is ignored.
-		nav := createNavigator(rootNode)
-		_ = antchfxExpr.Evaluate(nav)
-
-		// Optional: Also test Select/Iterate API to catch panics there too
-		// iter := antchfxExpr.Select(nav)
-		// for iter.MoveNext() {
-		//     // Just iterate to trigger potential panics
-		// }
+// TestPropertyFunctionCallsCompile checks that the calls that agree with
+// the signatures of the functions compile.
+func TestPropertyFunctionCallsCompile(t *testing.T) {
+	cfg := xpathtest.DefaultConfig()
+	rapid.Check(t, func(t *rapid.T) {
+		expr := cfg.FunctionCall().Draw(t, "expr")
+		if _, err := xpath.Compile(expr); err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
 	})
-	testingT.Logf("TestPropertyXPathValidity finished.") // Use testingT here
 }
 
-// Helper function to serialize the TNode tree to a string suitable for xmllint.
-// Adds an XML declaration and wraps content in a single <doc> root.
-func nodeToXMLString(node *TNode) string {
-	var sb strings.Builder
-	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n") // XML declaration
-	sb.WriteString("<doc>\n")                                       // Wrapper root element
+// TestPropertyEvaluationPaths checks that the ways of evaluating a
+// node-set agree: the nodes yielded by Select are those of
+// SelectWithOptions, and of the node-sets of Evaluate and
+// EvaluateWithOptions, in the same order; their number is
+// the value of count(); boolean() is true if there are any; and the
+// xpath.NodePath of each of them, evaluated from the document, selects
+// that node only.
+func TestPropertyEvaluationPaths(t *testing.T) {
+	cfg := xpathtest.DefaultConfig()
+	rapid.Check(t, func(t *rapid.T) {
+		doc := cfg.Document().Draw(t, "doc")
+		expr := rapid.OneOf(cfg.Path(), cfg.Union()).Draw(t, "expr")
+		exp, err := xpath.Compile(expr)
+		if err != nil {
+			t.Fatalf("failed to compile %q: %v", expr, err)
+		}
 
-	var printNode func(*TNode, int)
-	printNode = func(n *TNode, indent int) {
-		sb.WriteString(strings.Repeat("  ", indent))
-		switch n.Type {
-		case ElementNode:
-			// Ensure element names are XML-compatible (basic check)
-			tagName := n.Data
-			if tagName == "" {
-				tagName = "unknown" // Handle empty tag names if generator allows
-			}
-			sb.WriteString("<" + tagName)
-			// Keep track of added attribute names to avoid duplicates which xmllint might dislike
-			addedAttrs := make(map[string]bool)
-			for _, attr := range n.Attr {
-				// Ensure attr names are XML-compatible (basic check) and not duplicated
-				attrName := attr.Key
-				if attrName == "" || addedAttrs[attrName] {
-					continue // Skip empty or duplicate attribute names
-				}
-				addedAttrs[attrName] = true
-				// Use standard Go quoting which handles XML entities (&, <, >, ", ')
-				sb.WriteString(fmt.Sprintf(" %s=%q", attrName, attr.Value))
-			}
-			if n.FirstChild == nil {
-				sb.WriteString("/>\n")
-			} else {
-				sb.WriteString(">\n")
-				for child := n.FirstChild; child != nil; child = child.NextSibling {
-					printNode(child, indent+1) // Indent children
-				}
-				sb.WriteString(strings.Repeat("  ", indent)) // Indent closing tag
-				sb.WriteString("</" + tagName + ">\n")
+		// The iterators move the navigator they start from: each of them
+		// has its own.
+		paths := func(iter *xpath.NodeIterator) []string {
+			var list []string
+			for iter.MoveNext() {
+				list = append(list, xpath.NodePath(iter.Current()))
 			}
-		case TextNode:
-			// Escape text content for XML
-			escapedData := escapeXMLText(n.Data)
-			sb.WriteString(escapedData + "\n") // No quotes around text nodes
-		case CommentNode:
-			// Ensure comment data doesn't contain "--"
-			commentData := strings.ReplaceAll(n.Data, "--", "- -")
-			sb.WriteString(fmt.Sprintf("<!--%s-->\n", commentData))
-		// case RootNode: // RootNode is handled by the <doc> wrapper now
-		// We shouldn't generate RootNode types directly inside the tree anyway
-		default:
-			// Ignore unknown node types for XML serialization
-			// sb.WriteString(fmt.Sprintf("<!-- Unknown Node Type %d: %s -->\n", n.Type, n.Data))
+			return list
+		}
+		// The evaluations that fail, such as that of sum() of a string
+		// that is not a number, are not compared.
+		iter := exp.SelectWithOptions(dom.CreateNavigator(doc), xpath.EvalOptions{RecoverPanics: true})
+		selected := paths(iter)
+		if err := iter.Err(); err != nil {
+			t.Skip(err)
+		}
+		if d := xpath.DiffPaths(selected, paths(exp.Select(dom.CreateNavigator(doc)))); !d.Empty() {
+			t.Fatalf("%s: Select differs from SelectWithOptions:\n%s%s", expr, d, doc.OutputXML(false))
+		}
+		evaluated := paths(exp.Evaluate(dom.CreateNavigator(doc)).(*xpath.NodeIterator))
+		if d := xpath.DiffPaths(selected, evaluated); !d.Empty() {
+			t.Fatalf("%s: Evaluate differs from Select:\n%s%s", expr, d, doc.OutputXML(false))
+		}
+		v, err := exp.EvaluateWithOptions(dom.CreateNavigator(doc), xpath.EvalOptions{})
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if d := xpath.DiffPaths(selected, paths(v.(*xpath.NodeIterator))); !d.Empty() {
+			t.Fatalf("%s: EvaluateWithOptions differs from Select:\n%s%s", expr, d, doc.OutputXML(false))
 		}
-	}
-
-	// Start printing from the actual generated root node, indented under <doc>
-	printNode(node, 1)
 
-	sb.WriteString("</doc>\n") // Close wrapper root element
-	return sb.String()
-}
+		if got := xpath.MustCompile("count(" + expr + ")").Evaluate(dom.CreateNavigator(doc)); got != float64(len(selected)) {
+			t.Fatalf("count(%s) = %v, want %d\n%s", expr, got, len(selected), doc.OutputXML(false))
+		}
+		if got := xpath.MustCompile("boolean(" + expr + ")").Evaluate(dom.CreateNavigator(doc)); got != (len(selected) > 0) {
+			t.Fatalf("boolean(%s) = %v, want %v\n%s", expr, got, len(selected) > 0, doc.OutputXML(false))
+		}
 
-// escapeXMLText escapes characters problematic for XML text nodes.
-func escapeXMLText(s string) string {
-	var buf bytes.Buffer
-	for _, r := range s {
-		switch r {
-		case '&':
-			buf.WriteString("&amp;")
-		case '<':
-			buf.WriteString("&lt;")
-		case '>':
-			buf.WriteString("&gt;")
-		// Standard Go %q handles quotes, but they are allowed in text nodes.
-		// Only strictly need to escape &, <, > in text content.
-		default:
-			buf.WriteRune(r)
+		for _, path := range selected {
+			if got := paths(xpath.MustCompile(path).Select(dom.CreateNavigator(doc))); len(got) != 1 || got[0] != path {
+				t.Fatalf("%s: path %s of a node selects %v\n%s", expr, path, got, doc.OutputXML(false))
+			}
 		}
-	}
-	return buf.String()
+	})
 }
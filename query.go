@@ -2,10 +2,12 @@ package xpath
 
 import (
 	"bytes"
-	"fmt"
 	"hash/fnv"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
+	"time"
 )
 
 // The return type of the XPath expression.
@@ -46,10 +48,114 @@ var queryProps = struct {
 	Merge:    16,
 }
 
+// cursor is a navigator that a query moves to each of its context nodes
+// in turn, so that it does not copy every one of them.
+type cursor struct {
+	nav NodeNavigator
+}
+
+// moveTo moves the cursor to n and returns it. Nodes returned by Select
+// are only valid until the next call, so the previous context node can be
+// reused.
+func (c *cursor) moveTo(n NodeNavigator) NodeNavigator {
+	if c.nav == nil || !c.nav.MoveTo(n) {
+		c.nav = n.Copy()
+	}
+	return c.nav
+}
+
 type iterator interface {
 	Current() NodeNavigator
 }
 
+// limiter counts the nodes buffered and the bytes of the strings computed
+// during an evaluation with EvalOptions, and panics with a
+// *ResourceLimitError once a limit is exceeded. It also fills the Stats of
+// the options. A nil limiter has no limits.
+type limiter struct {
+	opts         EvalOptions
+	nodes, bytes int
+
+	// stats are opts.Stats, or the counters of the Progress of the errors
+	// without it, and base their values when the evaluation started.
+	stats   *Stats
+	base    Stats
+	start   time.Time
+	results int // the nodes returned by the iterator
+	// stopped is the error that stopped the evaluation with
+	// EvalOptions.PartialResults, returned once the nodes held are.
+	stopped error
+	// docs are the documents resolved by doc() and document(), by URI.
+	docs map[string]NodeNavigator
+	// compat, numbers and strictNumbers are the CompileOptions.Compat,
+	// NumberStrings and StrictNumbers of the expression.
+	compat        Compat
+	numbers       NumberStrings
+	strictNumbers bool
+}
+
+func newLimiter(opts EvalOptions) *limiter {
+	l := &limiter{opts: opts, stats: opts.Stats, start: time.Now()}
+	if l.stats == nil {
+		l.stats = new(Stats)
+	}
+	l.base = *l.stats
+	return l
+}
+
+func (l *limiter) addNodes(n int) {
+	if l == nil {
+		return
+	}
+	l.stats.BufferedNodes += n
+	if l.opts.MaxBufferedNodes <= 0 {
+		return
+	}
+	l.nodes += n
+	if l.nodes > l.opts.MaxBufferedNodes {
+		panic(&ResourceLimitError{Limit: "MaxBufferedNodes", Max: l.opts.MaxBufferedNodes, Progress: l.progress()})
+	}
+}
+
+func (l *limiter) addBytes(n int) {
+	if l == nil || l.opts.MaxStringBytes <= 0 {
+		return
+	}
+	l.bytes += n
+	if l.bytes > l.opts.MaxStringBytes {
+		panic(&ResourceLimitError{Limit: "MaxStringBytes", Max: l.opts.MaxStringBytes, Progress: l.progress()})
+	}
+}
+
+// limitedIterator is implemented by the iterators of an evaluation with
+// limits.
+type limitedIterator interface {
+	limits() *limiter
+}
+
+// limitsOf returns the limiter of the evaluation t belongs to, or nil.
+func limitsOf(t iterator) *limiter {
+	if l, ok := t.(limitedIterator); ok {
+		return l.limits()
+	}
+	return nil
+}
+
+// nodeValue returns the string value of n, counted against the limits of
+// the evaluation.
+func nodeValue(t iterator, n NodeNavigator) string {
+	s := n.Value()
+	limitsOf(t).addBytes(len(s))
+	return s
+}
+
+// limitString counts the string s computed by a function against the
+// limits of the evaluation, and returns it.
+func limitString(t iterator, s string) string {
+	limitsOf(t).addBytes(len(s))
+	return s
+}
+
 // An XPath query interface.
 type query interface {
 	// Select traversing iterator returns a query matched node NodeNavigator.
@@ -84,6 +190,7 @@ func (nopQuery) Properties() queryProp {
 // contextQuery is returns current node on the iterator object query.
 type contextQuery struct {
 	count int
+	node  cursor
 }
 
 func (c *contextQuery) Select(t iterator) NodeNavigator {
@@ -91,7 +198,7 @@ func (c *contextQuery) Select(t iterator) NodeNavigator {
 		return nil
 	}
 	c.count++
-	return t.Current().Copy()
+	return c.node.moveTo(t.Current())
 }
 
 func (c *contextQuery) Evaluate(iterator) interface{} {
@@ -113,6 +220,7 @@ func (c *contextQuery) Properties() queryProp {
 
 type absoluteQuery struct {
 	count int
+	node  cursor
 }
 
 func (a *absoluteQuery) Select(t iterator) (n NodeNavigator) {
@@ -120,7 +228,7 @@ func (a *absoluteQuery) Select(t iterator) (n NodeNavigator) {
 		return
 	}
 	a.count++
-	n = t.Current().Copy()
+	n = a.node.moveTo(t.Current())
 	n.MoveToRoot()
 	return
 }
@@ -144,11 +252,22 @@ func (a *absoluteQuery) Properties() queryProp {
 
 // ancestorQuery is an XPath ancestor node query.(ancestor::*|ancestor-self::*)
 type ancestorQuery struct {
-	name     string
-	iterator func() NodeNavigator
-	table    map[uint64]bool
-
-	Self      bool
+	name  string
+	table map[uint64]bool // the nodes walked over, by pathHashes
+
+	// node is on the context node or one of its ancestors while active,
+	// and paths[level] identifies it.
+	node   cursor
+	active bool
+	first  bool
+	paths  []uint64
+	level  int
+	posit  int
+
+	Self bool
+	// Repeat is set if a predicate on the step depends on the positions:
+	// the ancestors are then selected again for each context node.
+	Repeat    bool
 	Input     query
 	Predicate func(NodeNavigator) bool
 }
@@ -159,43 +278,63 @@ func (a *ancestorQuery) Select(t iterator) NodeNavigator {
 	}
 
 	for {
-		if a.iterator == nil {
+		if !a.active {
 			node := a.Input.Select(t)
 			if node == nil {
 				return nil
 			}
-			first := true
-			node = node.Copy()
-			a.iterator = func() NodeNavigator {
-				if first {
-					first = false
-					if a.Self && a.Predicate(node) {
-						return node
-					}
-				}
-				for node.MoveToParent() {
-					if a.Predicate(node) {
-						return node
-					}
-				}
-				return nil
-			}
+			a.paths = pathHashes(a.paths[:0], nodeOrderKey(node.Copy()))
+			a.level = len(a.paths) - 1
+			a.node.moveTo(node)
+			a.active, a.first = true, true
+			a.posit = 0
 		}
 
-		for node := a.iterator(); node != nil; node = a.iterator() {
-			node_id := getHashCode(node.Copy())
-			if _, ok := a.table[node_id]; !ok {
-				a.table[node_id] = true
-				return node
+		if node := a.next(); node != nil {
+			a.posit++
+			return node
+		}
+		a.active = false
+	}
+}
+
+// next returns the next ancestor that was not walked over for a previous
+// context node. Once one was, so were all of its ancestors.
+func (a *ancestorQuery) next() NodeNavigator {
+	node := a.node.nav
+	for {
+		if a.first && a.Self {
+			a.first = false
+		} else if node.MoveToParent() {
+			a.level--
+		} else {
+			return nil
+		}
+		if !a.Repeat {
+			if a.table[a.paths[a.level]] {
+				return nil
 			}
+			a.table[a.paths[a.level]] = true
+		}
+		if a.Predicate(node) {
+			return node
 		}
-		a.iterator = nil
 	}
 }
 
+// position returns the position of the current ancestor, the nearest
+// being the first.
+func (a *ancestorQuery) position() int {
+	return a.posit
+}
+
 func (a *ancestorQuery) Evaluate(t iterator) interface{} {
 	a.Input.Evaluate(t)
-	a.iterator = nil
+	a.active = false
+	// The nodes walked over are those of the previous evaluation.
+	for path := range a.table {
+		delete(a.table, path)
+	}
 	return a
 }
 
@@ -204,7 +343,7 @@ func (a *ancestorQuery) Test(n NodeNavigator) bool {
 }
 
 func (a *ancestorQuery) Clone() query {
-	return &ancestorQuery{name: a.name, Self: a.Self, Input: a.Input.Clone(), Predicate: a.Predicate}
+	return &ancestorQuery{name: a.name, Self: a.Self, Repeat: a.Repeat, Input: a.Input.Clone(), Predicate: a.Predicate}
 }
 
 func (a *ancestorQuery) ValueType() resultType {
@@ -217,8 +356,14 @@ func (a *ancestorQuery) Properties() queryProp {
 
 // attributeQuery is an XPath attribute node query.(@*)
 type attributeQuery struct {
-	name     string
-	iterator func() NodeNavigator
+	name string
+
+	// node is on the element whose attributes are selected, or on one of
+	// them, while active.
+	node    cursor
+	active  bool
+	matched bool
+	posit   int
 
 	Input     query
 	Predicate func(NodeNavigator) bool
@@ -226,35 +371,59 @@ type attributeQuery struct {
 
 func (a *attributeQuery) Select(t iterator) NodeNavigator {
 	for {
-		if a.iterator == nil {
+		if !a.active {
 			node := a.Input.Select(t)
 			if node == nil {
 				return nil
 			}
-			node = node.Copy()
-			a.iterator = func() NodeNavigator {
-				for {
-					onAttr := node.MoveToNextAttribute()
-					if !onAttr {
-						return nil
-					}
-					if a.Predicate(node) {
-						return node
-					}
-				}
+			if node.NodeType() == AttributeNode {
+				// The attributes have none.
+				continue
 			}
+			a.node.moveTo(node)
+			a.active, a.matched = true, false
+			a.posit = 0
+		}
+
+		if node := a.next(); node != nil {
+			a.posit++
+			return node
 		}
+		a.active = false
+	}
+}
 
-		if node := a.iterator(); node != nil {
+func (a *attributeQuery) next() NodeNavigator {
+	node := a.node.nav
+	for node.MoveToNextAttribute() {
+		if a.Predicate(node) {
+			a.matched = true
 			return node
 		}
-		a.iterator = nil
 	}
+	// Fall back to a computed attribute when no real attribute matched
+	// the name test.
+	if !a.matched && a.name != "" {
+		a.matched = true
+		if node.NodeType() == AttributeNode {
+			node.MoveToParent()
+		}
+		if v, ok := node.(VirtualAttributeNavigator); ok && v.MoveToVirtualAttribute(a.name) && a.Predicate(node) {
+			return node
+		}
+	}
+	return nil
+}
+
+// position returns the position of the current attribute among those
+// selected of its element.
+func (a *attributeQuery) position() int {
+	return a.posit
 }
 
 func (a *attributeQuery) Evaluate(t iterator) interface{} {
 	a.Input.Evaluate(t)
-	a.iterator = nil
+	a.active = false
 	return a
 }
 
@@ -276,9 +445,13 @@ func (a *attributeQuery) Properties() queryProp {
 
 // childQuery is an XPath child node query.(child::*)
 type childQuery struct {
-	name     string
-	posit    int
-	iterator func() NodeNavigator
+	name  string
+	posit int
+
+	// node is on the context node or one of its children while active.
+	node   cursor
+	active bool
+	first  bool
 
 	Input     query
 	Predicate func(NodeNavigator) bool
@@ -286,38 +459,40 @@ type childQuery struct {
 
 func (c *childQuery) Select(t iterator) NodeNavigator {
 	for {
-		if c.iterator == nil {
+		if !c.active {
 			c.posit = 0
 			node := c.Input.Select(t)
 			if node == nil {
 				return nil
 			}
-			node = node.Copy()
-			first := true
-			c.iterator = func() NodeNavigator {
-				for {
-					if (first && !node.MoveToChild()) || (!first && !node.MoveToNext()) {
-						return nil
-					}
-					first = false
-					if c.Predicate(node) {
-						return node
-					}
-				}
-			}
+			c.node.moveTo(node)
+			c.active, c.first = true, true
 		}
 
-		if node := c.iterator(); node != nil {
+		if node := c.next(); node != nil {
 			c.posit++
 			return node
 		}
-		c.iterator = nil
+		c.active = false
+	}
+}
+
+func (c *childQuery) next() NodeNavigator {
+	node := c.node.nav
+	for {
+		if (c.first && !node.MoveToChild()) || (!c.first && !node.MoveToNext()) {
+			return nil
+		}
+		c.first = false
+		if c.Predicate(node) {
+			return node
+		}
 	}
 }
 
 func (c *childQuery) Evaluate(t iterator) interface{} {
 	c.Input.Evaluate(t)
-	c.iterator = nil
+	c.active = false
 	return c
 }
 
@@ -325,6 +500,10 @@ func (c *childQuery) Test(n NodeNavigator) bool {
 	return c.Predicate(n)
 }
 
+func (c *childQuery) skipContext() {
+	c.active = false
+}
+
 func (c *childQuery) Clone() query {
 	return &childQuery{name: c.name, Input: c.Input.Clone(), Predicate: c.Predicate}
 }
@@ -345,6 +524,7 @@ func (c *childQuery) position() int {
 type cachedChildQuery struct {
 	name     string
 	posit    int
+	node     cursor
 	iterator func() NodeNavigator
 
 	Input     query
@@ -359,7 +539,7 @@ func (c *cachedChildQuery) Select(t iterator) NodeNavigator {
 			if node == nil {
 				return nil
 			}
-			node = node.Copy()
+			node = c.node.moveTo(node)
 			first := true
 			c.iterator = func() NodeNavigator {
 				for {
@@ -396,6 +576,10 @@ func (c *cachedChildQuery) Test(n NodeNavigator) bool {
 	return c.Predicate(n)
 }
 
+func (c *cachedChildQuery) skipContext() {
+	c.iterator = nil
+}
+
 func (c *cachedChildQuery) Clone() query {
 	return &childQuery{name: c.name, Input: c.Input.Clone(), Predicate: c.Predicate}
 }
@@ -410,10 +594,14 @@ func (c *cachedChildQuery) Properties() queryProp {
 
 // descendantQuery is an XPath descendant node query.(descendant::* | descendant-or-self::*)
 type descendantQuery struct {
-	name     string
-	iterator func() NodeNavigator
-	posit    int
-	level    int
+	name  string
+	posit int
+	level int
+
+	// node is on the context node or one of its descendants while active.
+	node   cursor
+	active bool
+	first  bool
 
 	Self      bool
 	Input     query
@@ -422,56 +610,58 @@ type descendantQuery struct {
 
 func (d *descendantQuery) Select(t iterator) NodeNavigator {
 	for {
-		if d.iterator == nil {
+		if !d.active {
 			d.posit = 0
 			node := d.Input.Select(t)
 			if node == nil {
 				return nil
 			}
-			node = node.Copy()
+			d.node.moveTo(node)
 			d.level = 0
-			first := true
-			d.iterator = func() NodeNavigator {
-				if first {
-					first = false
-					if d.Self && d.Predicate(node) {
-						return node
-					}
-				}
+			d.active, d.first = true, true
+		}
 
-				for {
-					if node.MoveToChild() {
-						d.level = d.level + 1
-					} else {
-						for {
-							if d.level == 0 {
-								return nil
-							}
-							if node.MoveToNext() {
-								break
-							}
-							node.MoveToParent()
-							d.level = d.level - 1
-						}
-					}
-					if d.Predicate(node) {
-						return node
-					}
+		if node := d.next(); node != nil {
+			d.posit++
+			return node
+		}
+		d.active = false
+	}
+}
+
+func (d *descendantQuery) next() NodeNavigator {
+	node := d.node.nav
+	if d.first {
+		d.first = false
+		if d.Self && d.Predicate(node) {
+			return node
+		}
+	}
+
+	for {
+		if node.MoveToChild() {
+			d.level = d.level + 1
+		} else {
+			for {
+				if d.level == 0 {
+					return nil
+				}
+				if node.MoveToNext() {
+					break
 				}
+				node.MoveToParent()
+				d.level = d.level - 1
 			}
 		}
-
-		if node := d.iterator(); node != nil {
-			d.posit++
+		if d.Predicate(node) {
 			return node
 		}
-		d.iterator = nil
 	}
 }
 
 func (d *descendantQuery) Evaluate(t iterator) interface{} {
 	d.Input.Evaluate(t)
-	d.iterator = nil
+	d.active = false
 	return d
 }
 
@@ -484,8 +674,8 @@ func (d *descendantQuery) position() int {
 	return d.posit
 }
 
-func (d *descendantQuery) depth() int {
-	return d.level
+func (d *descendantQuery) skipContext() {
+	d.active = false
 }
 
 func (d *descendantQuery) Clone() query {
@@ -503,6 +693,7 @@ func (d *descendantQuery) Properties() queryProp {
 // followingQuery is an XPath following node query.(following::*|following-sibling::*)
 type followingQuery struct {
 	posit    int
+	node     cursor
 	iterator func() NodeNavigator
 
 	Input     query
@@ -518,7 +709,7 @@ func (f *followingQuery) Select(t iterator) NodeNavigator {
 			if node == nil {
 				return nil
 			}
-			node = node.Copy()
+			node = f.node.moveTo(node)
 			if f.Sibling {
 				f.iterator = func() NodeNavigator {
 					for {
@@ -532,27 +723,23 @@ func (f *followingQuery) Select(t iterator) NodeNavigator {
 					}
 				}
 			} else {
-				var q *descendantQuery // descendant query
+				// The nodes are walked in document order, from the node
+				// after the descendants of the context node.
+				down := false
 				f.iterator = func() NodeNavigator {
 					for {
-						if q == nil {
+						if !down || !node.MoveToChild() {
 							for !node.MoveToNext() {
 								if !node.MoveToParent() {
 									return nil
 								}
 							}
-							q = &descendantQuery{
-								Self:      true,
-								Input:     &contextQuery{},
-								Predicate: f.Predicate,
-							}
-							t.Current().MoveTo(node)
 						}
-						if node := q.Select(t); node != nil {
-							f.posit = q.posit
+						down = true
+						if f.Predicate(node) {
+							f.posit++
 							return node
 						}
-						q = nil
 					}
 				}
 			}
@@ -567,6 +754,7 @@ func (f *followingQuery) Select(t iterator) NodeNavigator {
 
 func (f *followingQuery) Evaluate(t iterator) interface{} {
 	f.Input.Evaluate(t)
+	f.iterator = nil
 	return f
 }
 
@@ -574,6 +762,13 @@ func (f *followingQuery) Test(n NodeNavigator) bool {
 	return f.Predicate(n)
 }
 
+func (f *followingQuery) skipContext() {
+	// Positions of the following axis are not counted per context node.
+	if f.Sibling {
+		f.iterator = nil
+	}
+}
+
 func (f *followingQuery) Clone() query {
 	return &followingQuery{Input: f.Input.Clone(), Sibling: f.Sibling, Predicate: f.Predicate}
 }
@@ -592,8 +787,14 @@ func (f *followingQuery) position() int {
 
 // precedingQuery is an XPath preceding node query.(preceding::*)
 type precedingQuery struct {
-	iterator  func() NodeNavigator
-	posit     int
+	posit int
+
+	// node is on the context node, one of its ancestors or one of its
+	// preceding nodes while active, depth levels below the ancestors.
+	node   cursor
+	active bool
+	depth  int
+
 	Input     query
 	Sibling   bool // The matching sibling node of current node.
 	Predicate func(NodeNavigator) bool
@@ -601,61 +802,62 @@ type precedingQuery struct {
 
 func (p *precedingQuery) Select(t iterator) NodeNavigator {
 	for {
-		if p.iterator == nil {
+		if !p.active {
 			p.posit = 0
 			node := p.Input.Select(t)
 			if node == nil {
 				return nil
 			}
-			node = node.Copy()
-			if p.Sibling {
-				p.iterator = func() NodeNavigator {
-					for {
-						for !node.MoveToPrevious() {
-							return nil
-						}
-						if p.Predicate(node) {
-							p.posit++
-							return node
-						}
-					}
-				}
-			} else {
-				var q query
-				p.iterator = func() NodeNavigator {
-					for {
-						if q == nil {
-							for !node.MoveToPrevious() {
-								if !node.MoveToParent() {
-									return nil
-								}
-								p.posit = 0
-							}
-							q = &descendantQuery{
-								Self:      true,
-								Input:     &contextQuery{},
-								Predicate: p.Predicate,
-							}
-							t.Current().MoveTo(node)
-						}
-						if node := q.Select(t); node != nil {
-							p.posit++
-							return node
-						}
-						q = nil
-					}
+			p.node.moveTo(node)
+			p.active, p.depth = true, 0
+		}
+		if node := p.next(); node != nil {
+			p.posit++
+			return node
+		}
+		p.active = false
+	}
+}
+
+func (p *precedingQuery) next() NodeNavigator {
+	node := p.node.nav
+	if p.Sibling {
+		for node.MoveToPrevious() {
+			if p.Predicate(node) {
+				return node
+			}
+		}
+		return nil
+	}
+	// The nodes are walked in reverse document order: the last descendant
+	// of the previous sibling comes before it, and a node after its
+	// children.
+	for {
+		switch {
+		case node.MoveToPrevious():
+			for node.MoveToChild() {
+				p.depth++
+				for node.MoveToNext() {
 				}
 			}
+		case p.depth > 0:
+			node.MoveToParent()
+			p.depth--
+		case node.MoveToParent():
+			// An ancestor of the context node.
+			continue
+		default:
+			return nil
 		}
-		if node := p.iterator(); node != nil {
+		if p.Predicate(node) {
 			return node
 		}
-		p.iterator = nil
 	}
 }
 
 func (p *precedingQuery) Evaluate(t iterator) interface{} {
 	p.Input.Evaluate(t)
+	p.active = false
 	return p
 }
 
@@ -681,6 +883,8 @@ func (p *precedingQuery) position() int {
 
 // parentQuery is an XPath parent node query.(parent::*)
 type parentQuery struct {
+	node cursor
+
 	Input     query
 	Predicate func(NodeNavigator) bool
 }
@@ -691,13 +895,18 @@ func (p *parentQuery) Select(t iterator) NodeNavigator {
 		if node == nil {
 			return nil
 		}
-		node = node.Copy()
+		node = p.node.moveTo(node)
 		if node.MoveToParent() && p.Predicate(node) {
 			return node
 		}
 	}
 }
 
+// position returns 1, as the parent is the only node of the axis.
+func (p *parentQuery) position() int {
+	return 1
+}
+
 func (p *parentQuery) Evaluate(t iterator) interface{} {
 	p.Input.Evaluate(t)
 	return p
@@ -738,6 +947,11 @@ func (s *selfQuery) Select(t iterator) NodeNavigator {
 	}
 }
 
+// position returns 1, as the node itself is the only node of the axis.
+func (s *selfQuery) position() int {
+	return 1
+}
+
 func (s *selfQuery) Evaluate(t iterator) interface{} {
 	s.Input.Evaluate(t)
 	return s
@@ -764,12 +978,31 @@ type filterQuery struct {
 	Input      query
 	Predicate  query
 	NoPosition bool
-
-	posit    int
-	positmap map[int]int
+	// MaxPosition, if greater than 0, is the largest position that can
+	// match the predicate. Once it is reached, the rest of the axis for the
+	// current context node is skipped.
+	MaxPosition int
+	// Position, if greater than 0, is the number the predicate consists
+	// of, as in [3]. The nodes are then matched by their position, without
+	// evaluating the predicate.
+	Position int
+
+	// posit is the position of the node among those that match for the
+	// current context node of the axis, which starts over when the
+	// position of Input does not increase.
+	posit     int
+	lastInput int
+	context   predicateIterator
+	node      cursor
+	current   cursor // the context node of the filter expression
 }
 
 func (f *filterQuery) do(t iterator) bool {
+	f.context = predicateIterator{iterator: t}
+	if hasPosition(f.Input) {
+		f.context.position = getNodePosition(f.Input)
+	}
+	t = &f.context
 	val := reflect.ValueOf(f.Predicate.Evaluate(t))
 	switch val.Kind() {
 	case reflect.Bool:
@@ -781,7 +1014,7 @@ func (f *filterQuery) do(t iterator) bool {
 		return int(val.Float()) == pt
 	default:
 		if f.Predicate != nil {
-			return f.Predicate.Select(t) != nil
+			return exists(f.Predicate, t)
 		}
 	}
 	return false
@@ -792,36 +1025,50 @@ func (f *filterQuery) position() int {
 }
 
 func (f *filterQuery) Select(t iterator) NodeNavigator {
-	if f.positmap == nil {
-		f.positmap = make(map[int]int)
-	}
 	for {
-
 		node := f.Input.Select(t)
 		if node == nil {
 			return nil
 		}
-		node = node.Copy()
-
-		t.Current().MoveTo(node)
-		if f.do(t) {
-			// fix https://github.com/antchfx/htmlquery/issues/26
-			// Calculate and keep the each of matching node's position in the same depth.
-			level := getNodeDepth(f.Input)
-			f.positmap[level]++
-			f.posit = f.positmap[level]
-			return node
+		if hasPosition(f.Input) {
+			p := getNodePosition(f.Input)
+			if p <= f.lastInput {
+				f.posit = 0
+			}
+			f.lastInput = p
+		}
+		var matched bool
+		if f.Position > 0 {
+			matched = getNodePosition(f.Input) == f.Position
+		} else {
+			// The predicate is evaluated for the node, then the context
+			// node is restored for the queries of the expression after.
+			context := f.current.moveTo(t.Current())
+			node = f.node.moveTo(node)
+			t.Current().MoveTo(node)
+			matched = f.do(t)
+			t.Current().MoveTo(context)
+		}
+		if f.MaxPosition > 0 && getNodePosition(f.Input) >= f.MaxPosition {
+			if q, ok := f.Input.(contextSkipper); ok {
+				q.skipContext()
+			}
+		}
+		if matched {
+			f.posit++
+			return f.node.moveTo(node)
 		}
 	}
 }
 
 func (f *filterQuery) Evaluate(t iterator) interface{} {
 	f.Input.Evaluate(t)
+	f.posit, f.lastInput = 0, 0
 	return f
 }
 
 func (f *filterQuery) Clone() query {
-	return &filterQuery{Input: f.Input.Clone(), Predicate: f.Predicate.Clone()}
+	return &filterQuery{Input: f.Input.Clone(), Predicate: f.Predicate.Clone(), NoPosition: f.NoPosition, MaxPosition: f.MaxPosition, Position: f.Position}
 }
 
 func (f *filterQuery) ValueType() resultType {
@@ -847,6 +1094,7 @@ func (f *functionQuery) Select(t iterator) NodeNavigator {
 // Evaluate call a specified function that will returns the
 // following value type: number,string,boolean.
 func (f *functionQuery) Evaluate(t iterator) interface{} {
+	limitsOf(t).addCall()
 	return f.Func(f.Input, t)
 }
 
@@ -865,6 +1113,62 @@ func (f *functionQuery) Properties() queryProp {
 	return queryProps.Merge
 }
 
+// indexQuery selects the elements of the document whose attribute
+// prefix:localName has the value and that match Predicate. It looks them
+// up when the navigator has an index for the attribute (see IndexAttr),
+// and otherwise selects them with Input, which scans the document.
+type indexQuery struct {
+	prefix, localName, value string
+
+	nodes   []indexedNode
+	indexed bool
+	started bool
+	root    cursor
+
+	Input     query
+	Predicate func(NodeNavigator) bool
+}
+
+func (q *indexQuery) Select(t iterator) NodeNavigator {
+	if !q.started {
+		q.started = true
+		root := q.root.moveTo(t.Current())
+		root.MoveToRoot()
+		if ix, ok := root.(attrIndexer); ok {
+			q.nodes, q.indexed = ix.lookupAttr(q.prefix, q.localName, q.value)
+		}
+	}
+	if !q.indexed {
+		return q.Input.Select(t)
+	}
+	for len(q.nodes) > 0 {
+		node := q.nodes[0].node
+		q.nodes = q.nodes[1:]
+		if q.Predicate(node) {
+			return node
+		}
+	}
+	return nil
+}
+
+func (q *indexQuery) Evaluate(t iterator) interface{} {
+	q.Input.Evaluate(t)
+	q.nodes, q.indexed, q.started = nil, false, false
+	return q
+}
+
+func (q *indexQuery) Clone() query {
+	return &indexQuery{prefix: q.prefix, localName: q.localName, value: q.value, Input: q.Input.Clone(), Predicate: q.Predicate}
+}
+
+func (q *indexQuery) ValueType() resultType {
+	return xpathResultType.NodeSet
+}
+
+func (q *indexQuery) Properties() queryProp {
+	return q.Input.Properties()
+}
+
 // transformFunctionQuery diffs from functionQuery where the latter computes a scalar
 // value (number,string,boolean) for the current NodeNavigator node while the former
 // (transformFunctionQuery) performs a mapping or transform of the current NodeNavigator
@@ -878,6 +1182,7 @@ type transformFunctionQuery struct {
 
 func (f *transformFunctionQuery) Select(t iterator) NodeNavigator {
 	if f.iterator == nil {
+		limitsOf(t).addCall()
 		f.iterator = f.Func(f.Input, t)
 	}
 	return f.iterator()
@@ -901,6 +1206,51 @@ func (f *transformFunctionQuery) Properties() queryProp {
 	return queryProps.Merge
 }
 
+// contextValueQuery is the string value of the context node, for a `.`
+// that is only used as a string. Within a predicate the value is computed
+// once for each node the predicate is evaluated for.
+type contextValueQuery struct{}
+
+func (c *contextValueQuery) Select(t iterator) NodeNavigator {
+	return nil
+}
+
+func (c *contextValueQuery) Evaluate(t iterator) interface{} {
+	if p, ok := t.(*predicateIterator); ok {
+		if !p.cached {
+			p.value, p.cached = nodeValue(t, p.Current()), true
+		}
+		return p.value
+	}
+	return nodeValue(t, t.Current())
+}
+
+func (c *contextValueQuery) Clone() query {
+	return c
+}
+
+func (c *contextValueQuery) ValueType() resultType {
+	return xpathResultType.String
+}
+
+func (c *contextValueQuery) Properties() queryProp {
+	return queryProps.Position | queryProps.Count | queryProps.Cached | queryProps.Merge
+}
+
+// predicateIterator is the iterator a predicate is evaluated with. It
+// keeps the string value of the node the predicate is evaluated for, and
+// its position among the nodes of the step, or 0 if the step has none.
+type predicateIterator struct {
+	iterator
+	value    string
+	cached   bool
+	position int
+}
+
+func (p *predicateIterator) limits() *limiter {
+	return limitsOf(p.iterator)
+}
+
 // constantQuery is an XPath constant operand.
 type constantQuery struct {
 	Val interface{}
@@ -941,7 +1291,16 @@ func (g *groupQuery) Select(t iterator) NodeNavigator {
 	return node
 }
 
+func (g *groupQuery) exists(t iterator) bool {
+	return exists(g.Input, t)
+}
+
+func (g *groupQuery) count(t iterator) int {
+	return countNodes(g.Input, t)
+}
+
 func (g *groupQuery) Evaluate(t iterator) interface{} {
+	g.posit = 0
 	return g.Input.Evaluate(t)
 }
 
@@ -964,13 +1323,14 @@ func (g *groupQuery) position() int {
 // logicalQuery is an XPath logical expression.
 type logicalQuery struct {
 	Left, Right query
+	node        cursor
 
 	Do func(iterator, interface{}, interface{}) interface{}
 }
 
 func (l *logicalQuery) Select(t iterator) NodeNavigator {
 	// When a XPath expr is logical expression.
-	node := t.Current().Copy()
+	node := l.node.moveTo(t.Current())
 	val := l.Evaluate(t)
 	switch val.(type) {
 	case bool:
@@ -1031,6 +1391,7 @@ func (n *numericQuery) Properties() queryProp {
 type booleanQuery struct {
 	IsOr        bool
 	Left, Right query
+	root        cursor // the context node, restored before evaluating Right
 	iterator    func() NodeNavigator
 }
 
@@ -1038,13 +1399,14 @@ func (b *booleanQuery) Select(t iterator) NodeNavigator {
 	if b.iterator == nil {
 		var list []NodeNavigator
 		i := 0
-		root := t.Current().Copy()
+		root := b.root.moveTo(t.Current())
 		if b.IsOr {
 			for {
 				node := b.Left.Select(t)
 				if node == nil {
 					break
 				}
+				limitsOf(t).addNodes(1)
 				node = node.Copy()
 				list = append(list, node)
 			}
@@ -1054,6 +1416,7 @@ func (b *booleanQuery) Select(t iterator) NodeNavigator {
 				if node == nil {
 					break
 				}
+				limitsOf(t).addNodes(1)
 				node = node.Copy()
 				list = append(list, node)
 			}
@@ -1065,6 +1428,7 @@ func (b *booleanQuery) Select(t iterator) NodeNavigator {
 				if node == nil {
 					break
 				}
+				limitsOf(t).addNodes(1)
 				node = node.Copy()
 				list = append(m, node)
 			}
@@ -1074,6 +1438,7 @@ func (b *booleanQuery) Select(t iterator) NodeNavigator {
 				if node == nil {
 					break
 				}
+				limitsOf(t).addNodes(1)
 				node = node.Copy()
 				list = append(n, node)
 			}
@@ -1099,7 +1464,7 @@ func (b *booleanQuery) Select(t iterator) NodeNavigator {
 }
 
 func (b *booleanQuery) Evaluate(t iterator) interface{} {
-	n := t.Current().Copy()
+	n := b.root.moveTo(t.Current())
 
 	m := b.Left.Evaluate(t)
 	left := asBool(t, m)
@@ -1128,43 +1493,28 @@ func (b *booleanQuery) Properties() queryProp {
 
 type unionQuery struct {
 	Left, Right query
+	root        cursor // the context node, restored before selecting Right
 	iterator    func() NodeNavigator
+	partial     bool // the node-set of the result, see markPartial
 }
 
 func (u *unionQuery) Select(t iterator) NodeNavigator {
 	if u.iterator == nil {
-		var list []NodeNavigator
-		var m = make(map[uint64]bool)
-		root := t.Current().Copy()
-		for {
-			node := u.Left.Select(t)
-			if node == nil {
-				break
-			}
-			code := getHashCode(node.Copy())
-			if _, ok := m[code]; !ok {
-				m[code] = true
-				list = append(list, node.Copy())
-			}
+		nodes := orderedNodes
+		if u.partial {
+			nodes = partialNodes
 		}
+		root := u.root.moveTo(t.Current())
+		left := nodes(u.Left, t, true)
 		t.Current().MoveTo(root)
-		for {
-			node := u.Right.Select(t)
-			if node == nil {
-				break
-			}
-			code := getHashCode(node.Copy())
-			if _, ok := m[code]; !ok {
-				m[code] = true
-				list = append(list, node.Copy())
-			}
-		}
+		right := nodes(u.Right, t, true)
+		list := mergeOrderedNodes(left, right)
 		var i int
 		u.iterator = func() NodeNavigator {
 			if i >= len(list) {
 				return nil
 			}
-			node := list[i]
+			node := list[i].node
 			i++
 			return node
 		}
@@ -1172,6 +1522,23 @@ func (u *unionQuery) Select(t iterator) NodeNavigator {
 	return u.iterator()
 }
 
+func (u *unionQuery) exists(t iterator) bool {
+	root := u.root.moveTo(t.Current())
+	if exists(u.Left, t) {
+		return true
+	}
+	t.Current().MoveTo(root)
+	return exists(u.Right, t)
+}
+
+func (u *unionQuery) count(t iterator) int {
+	root := u.root.moveTo(t.Current())
+	left := orderedNodes(u.Left, t, false)
+	t.Current().MoveTo(root)
+	right := orderedNodes(u.Right, t, false)
+	return len(mergeOrderedNodes(left, right))
+}
+
 func (u *unionQuery) Evaluate(t iterator) interface{} {
 	u.iterator = nil
 	u.Left.Evaluate(t)
@@ -1209,6 +1576,7 @@ func (q *lastFuncQuery) Evaluate(t iterator) interface{} {
 			if node == nil {
 				break
 			}
+			limitsOf(t).addNodes(1)
 			q.buffer = append(q.buffer, node.Copy())
 		}
 		q.counted = true
@@ -1228,10 +1596,106 @@ func (q *lastFuncQuery) Properties() queryProp {
 	return queryProps.Merge
 }
 
+// lastQuery selects the nodes of Input that are among the last ones of
+// their context, for predicates such as [last()] or
+// [position() > last() - 3]: those for which last() - position() is
+// between from and to. Input is enumerated once, keeping only the last
+// to+1 nodes of the context until its end is reached.
+type lastQuery struct {
+	from, to int
+
+	ring    []cursor // the last nodes of the context, by position modulo to+1
+	next    cursor   // first node of the next context
+	pending bool     // whether next holds a node
+	done    bool
+	out     []NodeNavigator
+	posit   int
+
+	Input query
+}
+
+func (q *lastQuery) Select(t iterator) NodeNavigator {
+	for q.posit >= len(q.out) {
+		if q.done {
+			return nil
+		}
+		q.fill(t)
+	}
+	q.posit++
+	return q.out[q.posit-1]
+}
+
+// fill reads the nodes of the next context from Input and puts the ones
+// that are selected in out.
+func (q *lastQuery) fill(t iterator) {
+	lim := limitsOf(t)
+	// slot returns the cursor for the nth node of the context. The ring
+	// grows up to to+1 cursors, as far as the contexts are long.
+	slot := func(n int) *cursor {
+		i := n % (q.to + 1)
+		if i == len(q.ring) {
+			lim.addNodes(1)
+			q.ring = append(q.ring, cursor{})
+		}
+		return &q.ring[i]
+	}
+	n := 0
+	if q.pending {
+		slot(n).moveTo(q.next.nav)
+		q.pending = false
+		n++
+	}
+	for {
+		node := q.Input.Select(t)
+		if node == nil {
+			q.done = true
+			break
+		}
+		if n > 0 && getNodePosition(q.Input) == 1 {
+			q.next.moveTo(node)
+			q.pending = true
+			break
+		}
+		slot(n).moveTo(node)
+		n++
+	}
+
+	q.out, q.posit = q.out[:0], 0
+	for p := n - q.to; p <= n-q.from; p++ {
+		if p >= 1 {
+			q.out = append(q.out, q.ring[(p-1)%(q.to+1)].nav)
+		}
+	}
+}
+
+func (q *lastQuery) position() int {
+	return q.posit
+}
+
+func (q *lastQuery) Evaluate(t iterator) interface{} {
+	q.Input.Evaluate(t)
+	q.pending, q.done = false, false
+	q.out, q.posit = q.out[:0], 0
+	return q
+}
+
+func (q *lastQuery) Clone() query {
+	return &lastQuery{from: q.from, to: q.to, Input: q.Input.Clone()}
+}
+
+func (q *lastQuery) ValueType() resultType {
+	return xpathResultType.NodeSet
+}
+
+func (q *lastQuery) Properties() queryProp {
+	return (queryProps.Position | q.Input.Properties()) & (queryProps.Reverse | queryProps.Merge)
+}
+
 type descendantOverDescendantQuery struct {
 	name        string
 	level       int
 	posit       int
+	node        cursor
 	currentNode NodeNavigator
 
 	Input     query
@@ -1265,13 +1729,15 @@ func (d *descendantOverDescendantQuery) Select(t iterator) NodeNavigator {
 			if node == nil {
 				return nil
 			}
-			d.currentNode = node.Copy()
+			d.currentNode = d.node.moveTo(node)
 			d.posit = 0
 			if d.MatchSelf && d.Predicate(d.currentNode) {
 				d.posit = 1
 				return d.currentNode
 			}
-			d.moveToFirstChild()
+			if !d.moveToFirstChild() {
+				continue
+			}
 		} else if !d.moveUpUntilNext() {
 			continue
 		}
@@ -1286,6 +1752,7 @@ func (d *descendantOverDescendantQuery) Select(t iterator) NodeNavigator {
 
 func (d *descendantOverDescendantQuery) Evaluate(t iterator) interface{} {
 	d.Input.Evaluate(t)
+	d.level = 0
 	return d
 }
 
@@ -1305,46 +1772,60 @@ func (d *descendantOverDescendantQuery) position() int {
 	return d.posit
 }
 
+// mergeQuery selects the nodes Child selects from each node of Input, one
+// at a time, so the nodes selected between the steps of a path are never
+// kept.
 type mergeQuery struct {
 	Input query
 	Child query
 
-	iterator func() NodeNavigator
+	active bool // Child is selecting from a node of Input
 }
 
 func (m *mergeQuery) Select(t iterator) NodeNavigator {
 	for {
-		if m.iterator == nil {
+		if !m.active {
 			root := m.Input.Select(t)
 			if root == nil {
 				return nil
 			}
 			m.Child.Evaluate(t)
-			root = root.Copy()
 			t.Current().MoveTo(root)
-			var list []NodeNavigator
-			for node := m.Child.Select(t); node != nil; node = m.Child.Select(t) {
-				list = append(list, node.Copy())
-			}
-			i := 0
-			m.iterator = func() NodeNavigator {
-				if i >= len(list) {
-					return nil
-				}
-				result := list[i]
-				i++
-				return result
-			}
+			m.active = true
 		}
-
-		if node := m.iterator(); node != nil {
+		if node := m.Child.Select(t); node != nil {
 			return node
 		}
-		m.iterator = nil
+		m.active = false
+	}
+}
+
+func (m *mergeQuery) exists(t iterator) bool {
+	for {
+		root := m.Input.Select(t)
+		if root == nil {
+			return false
+		}
+		m.Child.Evaluate(t)
+		t.Current().MoveTo(root)
+		if exists(m.Child, t) {
+			return true
+		}
 	}
 }
 
+func (m *mergeQuery) count(t iterator) int {
+	n := 0
+	for root := m.Input.Select(t); root != nil; root = m.Input.Select(t) {
+		m.Child.Evaluate(t)
+		t.Current().MoveTo(root)
+		n += countNodes(m.Child, t)
+	}
+	return n
+}
+
 func (m *mergeQuery) Evaluate(t iterator) interface{} {
+	m.active = false
 	m.Input.Evaluate(t)
 	return m
 }
@@ -1406,6 +1887,232 @@ func getHashCode(n NodeNavigator) uint64 {
 	return h.Sum64()
 }
 
+// orderedNode is a node of a node-set being put in document order.
+type orderedNode struct {
+	node NodeNavigator
+	key  []int
+}
+
+// compare returns the document order of n and m as -1, 0 or 1.
+func (n *orderedNode) compare(m *orderedNode) int {
+	if o, ok := n.node.(DocumentOrderNavigator); ok && m.node != nil {
+		if c, ok := o.ComparePosition(m.node); ok {
+			return c
+		}
+	}
+	return compareOrderKeys(n.orderKey(), m.orderKey())
+}
+
+func (n *orderedNode) orderKey() []int {
+	if n.key == nil {
+		n.key = nodeOrderKey(n.node.Copy())
+	}
+	return n.key
+}
+
+// orderedNodes returns the nodes selected by q in document order without
+// duplicates. Most queries already select their nodes in document order,
+// and those of the reverse axes in reverse document order, which is
+// checked in linear time before sorting. If copy is false the nodes are
+// only kept when they are needed to compare them.
+func orderedNodes(q query, t iterator, copy bool) []orderedNode {
+	var list []orderedNode
+	collectNodes(q, t, copy, &list)
+	return sortNodes(list)
+}
+
+// collectNodes appends the nodes selected by q to list, for orderedNodes.
+func collectNodes(q query, t iterator, copy bool, list *[]orderedNode) {
+	lim := limitsOf(t)
+	for node := q.Select(t); node != nil; node = q.Select(t) {
+		lim.addNodes(1)
+		n := orderedNode{node: node.Copy()}
+		if _, ok := node.(DocumentOrderNavigator); !ok && !copy {
+			n.key, n.node = nodeOrderKey(n.node), nil
+		}
+		*list = append(*list, n)
+	}
+}
+
+// sortNodes puts list in document order without duplicates, for
+// orderedNodes.
+func sortNodes(list []orderedNode) []orderedNode {
+	less := func(i, j int) bool { return list[i].compare(&list[j]) < 0 }
+	if !sort.SliceIsSorted(list, less) {
+		if reverseSorted(list) {
+			// There are no duplicates either.
+			for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+				list[i], list[j] = list[j], list[i]
+			}
+			return list
+		}
+		sort.SliceStable(list, less)
+	}
+	return mergeOrderedNodes(list, nil)
+}
+
+// reverseSorted reports whether list is in reverse document order without
+// duplicates, as the nodes of a reverse axis are. Whether a node is the
+// parent of the node before it is checked first, as comparing two nodes
+// may walk up to the root.
+func reverseSorted(list []orderedNode) bool {
+	for i := 1; i < len(list); i++ {
+		a, b := &list[i-1], &list[i]
+		if a.node != nil && b.node != nil {
+			parent := a.node.Copy()
+			if parent.MoveToParent() && (&orderedNode{node: parent}).compare(b) == 0 {
+				continue
+			}
+		}
+		if a.compare(b) <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeOrderedNodes merges two lists in document order into one, dropping
+// duplicate nodes, in linear time.
+func mergeOrderedNodes(a, b []orderedNode) []orderedNode {
+	list := make([]orderedNode, 0, len(a)+len(b))
+	add := func(n orderedNode) {
+		if len(list) == 0 || list[len(list)-1].compare(&n) != 0 {
+			list = append(list, n)
+		}
+	}
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].compare(&b[0]) <= 0 {
+			add(a[0])
+			a = a[1:]
+		} else {
+			add(b[0])
+			b = b[1:]
+		}
+	}
+	for _, n := range a {
+		add(n)
+	}
+	for _, n := range b {
+		add(n)
+	}
+	return list
+}
+
+// nodeOrderKey returns the position of the node n and each of its
+// ancestors among their siblings, from the root down. Comparing the keys
+// of two nodes with compareOrderKeys gives their document order. The
+// attributes of an element get negative positions, so they sort after the
+// element and before its children. n is moved to the root.
+func nodeOrderKey(n NodeNavigator) []int {
+	key := make([]int, 0, 8)
+	if n.NodeType() == AttributeNode {
+		prefix, name := n.Prefix(), n.LocalName()
+		n.MoveToParent()
+		attr := n.Copy()
+		i := 0
+		for attr.MoveToNextAttribute() && (attr.Prefix() != prefix || attr.LocalName() != name) {
+			i++
+		}
+		key = append(key, math.MinInt32+i)
+	}
+	for {
+		i := 0
+		for n.MoveToPrevious() {
+			i++
+		}
+		if !n.MoveToParent() {
+			break
+		}
+		key = append(key, i)
+	}
+	for i, j := 0, len(key)-1; i < j; i, j = i+1, j-1 {
+		key[i], key[j] = key[j], key[i]
+	}
+	return key
+}
+
+// pathHashes appends to dst the hash of each prefix of the node key,
+// from the root to the node, so that dst[i] identifies the ancestor of the
+// node at depth i, without walking from the root for each of them.
+func pathHashes(dst []uint64, key []int) []uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	dst = append(dst, h)
+	for _, k := range key {
+		for i := 0; i < 64; i += 8 {
+			h ^= uint64(byte(k >> i))
+			h *= prime64
+		}
+		dst = append(dst, h)
+	}
+	return dst
+}
+
+// compareOrderKeys returns -1, 0 or 1 as the node with key a comes
+// before, is the same as, or comes after the node with key b.
+func compareOrderKeys(a, b []int) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// existenceQuery is implemented by node-set queries that can tell whether
+// they select any node more cheaply than by selecting the first one, such
+// as queries that otherwise materialize the whole node-set.
+type existenceQuery interface {
+	exists(t iterator) bool
+}
+
+// exists returns the effective boolean value of the node-set query q,
+// stopping at the first selected node.
+func exists(q query, t iterator) bool {
+	if e, ok := q.(existenceQuery); ok {
+		return e.exists(t)
+	}
+	return q.Select(t) != nil
+}
+
+// countingQuery is implemented by node-set queries that can count their
+// nodes without buffering them.
+type countingQuery interface {
+	count(t iterator) int
+}
+
+// countNodes returns the number of nodes selected by the node-set query q.
+func countNodes(q query, t iterator) int {
+	if c, ok := q.(countingQuery); ok {
+		return c.count(t)
+	}
+	n := 0
+	for node := q.Select(t); node != nil; node = q.Select(t) {
+		n++
+	}
+	return n
+}
+
+// contextSkipper is implemented by forward axis queries whose positions
+// are counted per context node.
+type contextSkipper interface {
+	// skipContext skips the remaining nodes of the axis for the current
+	// context node.
+	skipContext()
+}
+
 func getNodePosition(q query) int {
 	type Position interface {
 		position() int
@@ -1416,14 +2123,17 @@ func getNodePosition(q query) int {
 	return 1
 }
 
-func getNodeDepth(q query) int {
-	type Depth interface {
-		depth() int
+// hasPosition reports whether q has the positions of its nodes that
+// getNodePosition returns, rather than 1 for every node.
+func hasPosition(q query) bool {
+	type positioned interface {
+		hasPosition() bool
 	}
-	if count, ok := q.(Depth); ok {
-		return count.depth()
+	if p, ok := q.(positioned); ok {
+		return p.hasPosition()
 	}
-	return 0
+	_, ok := q.(interface{ position() int })
+	return ok
 }
 
 func getXPathType(i interface{}) resultType {
@@ -1440,5 +2150,5 @@ func getXPathType(i interface{}) resultType {
 			return xpathResultType.NodeSet
 		}
 	}
-	panic(fmt.Errorf("xpath unknown value type: %v", v.Kind()))
+	panic(newError(ErrTypeMismatch, nil, "xpath unknown value type: %v", v.Kind()))
 }
@@ -0,0 +1,124 @@
+package xpath
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Quote returns an XPath expression of the string s, for embedding a
+// value in an expression: s between apostrophes, or between quotes if it
+// has an apostrophe, or concat() of both kinds of literals if it has
+// both, such as concat('it', "'", 's "q"') for it's "q". Unlike the
+// literals written by hand, the result is valid for any s.
+func Quote(s string) string {
+	switch {
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'"
+	case !strings.Contains(s, `"`):
+		return `"` + s + `"`
+	}
+	// s has both quotes, it is built with concat.
+	parts := strings.Split(s, "'")
+	for i, p := range parts {
+		parts[i] = "'" + p + "'"
+	}
+	return "concat(" + strings.Join(parts, `, "'", `) + ")"
+}
+
+// Bind returns expr with the references to the variables of params, such
+// as $id, replaced by the expressions of their values, so that the values
+// of an application are not quoted by hand:
+//
+//	expr, err := xpath.Bind("//user[@name = $name and @age > $age]", map[string]interface{}{"name": name, "age": 18})
+//
+// A string is replaced by its Quote, a number by the number, and a bool
+// by true() or false(). The references in string literals, and those to
+// the variables not in params, are left as they are; Compile reports the
+// latter as unbound.
+func Bind(expr string, params map[string]interface{}) (string, error) {
+	var b strings.Builder
+	last := 0
+	toks := lintTokens(expr)
+	for i := 0; i+1 < len(toks); i++ {
+		tok := toks[i+1]
+		// The text of a name with a prefix is its local name, and such a
+		// variable is not bound.
+		if toks[i].typ != itemDollar || tok.typ != itemName || !strings.HasPrefix(expr[tok.start:], tok.text) {
+			continue
+		}
+		// The name may go on with the * of a multiplication, as in $x*2.
+		name := tok.text
+		if k := strings.IndexByte(name, '*'); k >= 0 {
+			name = name[:k]
+		}
+		v, ok := params[name]
+		if !ok {
+			continue
+		}
+		s, err := bindValue(v)
+		if err != nil {
+			return "", fmt.Errorf("xpath: binding $%s: %v", name, err)
+		}
+		end := tok.start + len(name)
+		// The value is kept apart from the name or the operator written
+		// right before or after the reference, as div in div$n.
+		if r, _ := utf8.DecodeLastRuneInString(expr[:toks[i].start]); isName(r) {
+			s = " " + s
+		}
+		if r, _ := utf8.DecodeRuneInString(expr[end:]); isName(r) {
+			s += " "
+		}
+		b.WriteString(expr[last:toks[i].start])
+		b.WriteString(s)
+		last = end
+	}
+	b.WriteString(expr[last:])
+	return b.String(), nil
+}
+
+// bindValue returns the expression of the value v of a parameter of Bind.
+func bindValue(v interface{}) (string, error) {
+	var f float64
+	switch v := v.(type) {
+	case string:
+		return Quote(v), nil
+	case bool:
+		if v {
+			return "true()", nil
+		}
+		return "false()", nil
+	case float64:
+		f = v
+	case float32:
+		f = float64(v)
+	case int:
+		f = float64(v)
+	case int64:
+		f = float64(v)
+	case int32:
+		f = float64(v)
+	case uint:
+		f = float64(v)
+	case uint64:
+		f = float64(v)
+	case uint32:
+		f = float64(v)
+	default:
+		return "", fmt.Errorf("cannot bind a value of type %T", v)
+	}
+	switch {
+	case math.IsNaN(f):
+		return "(0 div 0)", nil
+	case math.IsInf(f, 1):
+		return "(1 div 0)", nil
+	case math.IsInf(f, -1):
+		return "(-1 div 0)", nil
+	case f < 0:
+		// Keep a - $b apart when $b is negative.
+		return "(" + strconv.FormatFloat(f, 'f', -1, 64) + ")", nil
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64), nil
+}
@@ -0,0 +1,63 @@
+package xpath
+
+import "testing"
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"", "abc", "it's", `say "hi"`, `it's "q"`, `'"'"`, "'", `"`, "a'b'c\"d"} {
+		q := Quote(s)
+		if v := MustCompile("string(" + q + ")").Evaluate(nil); v != s {
+			t.Errorf("Quote(%q) = %s, evaluates to %q", s, q, v)
+		}
+	}
+	assertEqual(t, `concat('it', "'", 's "q"')`, Quote(`it's "q"`))
+}
+
+func TestBind(t *testing.T) {
+	nav := createNavigator(book_example)
+	for _, tc := range []struct {
+		expr   string
+		params map[string]interface{}
+		want   string
+	}{
+		{"//book[@category = $c]", map[string]interface{}{"c": "web"}, "//book[@category = 'web']"},
+		{"//book[title = $t]", map[string]interface{}{"t": `it's "q"`}, `//book[title = concat('it', "'", 's "q"')]`},
+		{"count(//book) - $n", map[string]interface{}{"n": -1}, "count(//book) - (-1)"},
+		{"$a and $b", map[string]interface{}{"a": true, "b": false}, "true() and false()"},
+		{"$x + $x*$y", map[string]interface{}{"x": 1.5, "y": uint(2)}, "1.5 + 1.5 * 2"},
+		// The references in literals and those not bound are left.
+		{"concat('$c', $c, $d)", map[string]interface{}{"c": "x"}, "concat('$c', 'x', $d)"},
+		{"$n div 0", map[string]interface{}{"n": 0}, "0 div 0"},
+		{"$p:c", map[string]interface{}{"c": "x"}, "$p:c"},
+	} {
+		got, err := Bind(tc.expr, tc.params)
+		assertNoErr(t, err)
+		assertEqual(t, tc.want, got)
+	}
+
+	// The values are not glued to the names around them.
+	for _, tc := range []struct {
+		expr   string
+		params map[string]interface{}
+		want   interface{}
+	}{
+		{"count(//book) div$n", map[string]interface{}{"n": 2}, float64(2)},
+		{"count(//book) mod$n", map[string]interface{}{"n": 3}, float64(1)},
+		{"$y and$y", map[string]interface{}{"y": true}, true},
+		{"$y or$n", map[string]interface{}{"y": false, "n": 1}, true},
+		{"count(//book[$n*price > 60])", map[string]interface{}{"n": 2}, float64(2)},
+	} {
+		expr, err := Bind(tc.expr, tc.params)
+		assertNoErr(t, err)
+		exp, err := Compile(expr)
+		assertNoErr(t, err)
+		assertEqual(t, tc.want, exp.Evaluate(nav))
+	}
+
+	// A value cannot close its literal.
+	expr, err := Bind("count(//book[@category = $c])", map[string]interface{}{"c": "web'] | //*[@x='"})
+	assertNoErr(t, err)
+	assertEqual(t, float64(0), MustCompile(expr).Evaluate(nav))
+
+	_, err = Bind("$c", map[string]interface{}{"c": []string{"a"}})
+	assertErr(t, err)
+}
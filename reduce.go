@@ -0,0 +1,147 @@
+package xpath
+
+import (
+	"errors"
+	"sort"
+)
+
+// Reductions returns the expressions one step simpler than expr, as
+// Format prints them, shortest first: expr with a subexpression replaced
+// by one of its operands or arguments, a predicate or a step of a path
+// removed, the parentheses of a group removed, or a string literal
+// emptied. Only the reductions that are valid XPath are returned; they
+// need not have the value of expr. A minimizer tries them in turn and
+// keeps the first that still exhibits a failure, until none does. If
+// expr is not valid XPath, the error is a *SyntaxError.
+func Reductions(expr string) (list []string, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch x := e.(type) {
+			case string:
+				err = errors.New(x)
+			case error:
+				err = x
+			default:
+				err = errors.New("unknown panic")
+			}
+		}
+	}()
+	if expr == "" {
+		return nil, newError(ErrSyntax, nil, "expr expression is nil")
+	}
+	root := parse(expr, nil, nil)
+	seen := map[string]bool{formatNode(root): true}
+	for _, n := range reductions(root) {
+		s := formatNode(n)
+		if !seen[s] && isValid(s) {
+			seen[s] = true
+			list = append(list, s)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return len(list[i]) < len(list[j]) })
+	return list, nil
+}
+
+// isValid reports whether expr parses.
+func isValid(expr string) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	parse(expr, nil, nil)
+	return true
+}
+
+// reductions returns the trees one step simpler than n. They share the
+// subtrees of n that are not changed.
+func reductions(n node) []node {
+	var list []node
+	switch n := n.(type) {
+	case *operatorNode:
+		if v, ok := n.Right.(*operandNode); ok && n.Op == "*" && v.Val == float64(-1) {
+			// -x, see formatter.format.
+			list = append(list, n.Left)
+			for _, r := range reductions(n.Left) {
+				c := *n
+				c.Left = r
+				list = append(list, &c)
+			}
+			break
+		}
+		list = append(list, n.Left, n.Right)
+		for _, r := range reductions(n.Left) {
+			c := *n
+			c.Left = r
+			list = append(list, &c)
+		}
+		for _, r := range reductions(n.Right) {
+			c := *n
+			c.Right = r
+			list = append(list, &c)
+		}
+	case *axisNode:
+		// The second slash of // is not a step of its own.
+		canStart := !isAbbreviated(n) || n.AxisType != "descendant-or-self"
+		switch input := n.Input.(type) {
+		case nil:
+		case *rootNode:
+			// /a is a.
+			list = append(list, input)
+			if canStart {
+				c := *n
+				c.Input = nil
+				list = append(list, &c)
+			}
+		case *axisNode:
+			// a/b is a, or b without the step a.
+			list = append(list, input)
+			if input.Input != nil || canStart {
+				c := *n
+				c.Input = input.Input
+				list = append(list, &c)
+			}
+		default:
+			list = append(list, input)
+		}
+		if n.Input != nil {
+			for _, r := range reductions(n.Input) {
+				c := *n
+				c.Input = r
+				list = append(list, &c)
+			}
+		}
+	case *filterNode:
+		list = append(list, n.Input)
+		for _, r := range reductions(n.Input) {
+			c := *n
+			c.Input = r
+			list = append(list, &c)
+		}
+		for _, r := range reductions(n.Condition) {
+			c := *n
+			c.Condition = r
+			list = append(list, &c)
+		}
+	case *groupNode:
+		list = append(list, n.Input)
+		for _, r := range reductions(n.Input) {
+			list = append(list, newGroupNode(r))
+		}
+	case *functionNode:
+		list = append(list, n.Args...)
+		for i, arg := range n.Args {
+			for _, r := range reductions(arg) {
+				c := *n
+				c.Args = append([]node(nil), n.Args...)
+				c.Args[i] = r
+				list = append(list, &c)
+			}
+		}
+	case *operandNode:
+		if s, ok := n.Val.(string); ok && s != "" {
+			list = append(list, newOperandNode(""))
+		}
+	}
+	return list
+}
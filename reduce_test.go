@@ -0,0 +1,35 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReductions(t *testing.T) {
+	for _, tc := range []struct {
+		expr       string
+		reductions []string
+	}{
+		{`/a`, []string{`/`, `a`}},
+		{`a/b/c`, []string{`a/b`, `a/c`, `b/c`}},
+		{`//a`, []string{`/`, `/a`}},
+		{`a[@x = 'y']`, []string{`a`, `a[@x]`, `a['y']`, `a[@x = '']`}},
+		{`-count(a) + 1`, []string{`1`, `-a + 1`, `-count(a)`, `count(a) + 1`}},
+		{`concat('a', string(b))`, []string{`'a'`, `string(b)`, `concat('a', b)`, `concat('', string(b))`}},
+		{`(a | b)[1]`, []string{`(a)[1]`, `(b)[1]`, `(a | b)`, `a | b[1]`}},
+		{`1`, nil},
+	} {
+		list, err := Reductions(tc.expr)
+		assertNoErr(t, err)
+		assertEqual(t, len(tc.reductions), len(list))
+		for i := range list {
+			if i < len(tc.reductions) {
+				assertEqual(t, tc.reductions[i], list[i])
+			}
+		}
+	}
+
+	_, err := Reductions(`//a[`)
+	var syntaxErr *SyntaxError
+	assertTrue(t, errors.As(err, &syntaxErr))
+}
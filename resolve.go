@@ -0,0 +1,119 @@
+package xpath
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// A DocumentResolver loads the documents and the text of the URIs of
+// doc(), document() and unparsed-text(), for the evaluations with
+// EvalOptions.Resolver. The dom package has resolvers over an fs.FS and
+// an http.Client.
+type DocumentResolver interface {
+	// ResolveDocument returns a navigator of the document at uri.
+	ResolveDocument(ctx context.Context, uri string) (NodeNavigator, error)
+	// ResolveText returns the text of the resource at uri.
+	ResolveText(ctx context.Context, uri string) (string, error)
+}
+
+// ErrNoResolver is the error of the ResolveError of a doc(), document() or
+// unparsed-text() call evaluated without EvalOptions.Resolver.
+var ErrNoResolver = errors.New("xpath: no document resolver")
+
+// A ResolveError is returned by EvaluateWithOptions, and panicked with by
+// Evaluate, when a document or a text cannot be resolved.
+type ResolveError struct {
+	// Func is the function that resolved the URI, such as doc.
+	Func string
+	URI  string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("xpath: %s(%q): %v", e.Func, e.URI, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// resolveDocument returns the root of the document at uri, resolved once
+// for each evaluation, or panics with a *ResolveError.
+func resolveDocument(t iterator, name, uri string) NodeNavigator {
+	lim := limitsOf(t)
+	if lim == nil || lim.opts.Resolver == nil {
+		panic(&ResolveError{Func: name, URI: uri, Err: ErrNoResolver})
+	}
+	if doc, ok := lim.docs[uri]; ok {
+		return doc.Copy()
+	}
+	doc, err := lim.opts.Resolver.ResolveDocument(lim.context(), uri)
+	if err != nil {
+		panic(&ResolveError{Func: name, URI: uri, Err: err})
+	}
+	doc = lim.wrap(doc.Copy(), uri)
+	doc.MoveToRoot()
+	if lim.docs == nil {
+		lim.docs = make(map[string]NodeNavigator)
+	}
+	lim.docs[uri] = doc
+	return doc.Copy()
+}
+
+// docFunc is doc(uri), the document at the URI.
+func docFunc(q query, t iterator) func() NodeNavigator {
+	doc := resolveDocument(t, "doc", asString(t, functionArgs(q).Evaluate(t)))
+	return func() NodeNavigator {
+		n := doc
+		doc = nil
+		return n
+	}
+}
+
+// documentFunc is document(object), the documents at the URI of a string,
+// or at the string values of the nodes of a node-set, each once.
+func documentFunc(q query, t iterator) func() NodeNavigator {
+	var uris []string
+	switch v := functionArgs(q).Evaluate(t).(type) {
+	case query:
+		for node := v.Select(t); node != nil; node = v.Select(t) {
+			uris = append(uris, nodeValue(t, node))
+		}
+	default:
+		uris = append(uris, asString(t, v))
+	}
+	var list []NodeNavigator
+	seen := make(map[string]bool)
+	for _, uri := range uris {
+		if !seen[uri] {
+			seen[uri] = true
+			list = append(list, resolveDocument(t, "document", uri))
+		}
+	}
+	limitsOf(t).addNodes(len(list))
+	return func() NodeNavigator {
+		if len(list) == 0 {
+			return nil
+		}
+		n := list[0]
+		list = list[1:]
+		return n
+	}
+}
+
+// unparsedTextFunc is unparsed-text(uri), the text at the URI.
+func unparsedTextFunc(arg query) func(query, iterator) interface{} {
+	return func(_ query, t iterator) interface{} {
+		uri := asString(t, functionArgs(arg).Evaluate(t))
+		lim := limitsOf(t)
+		if lim == nil || lim.opts.Resolver == nil {
+			panic(&ResolveError{Func: "unparsed-text", URI: uri, Err: ErrNoResolver})
+		}
+		s, err := lim.opts.Resolver.ResolveText(lim.context(), uri)
+		if err != nil {
+			panic(&ResolveError{Func: "unparsed-text", URI: uri, Err: err})
+		}
+		return limitString(t, s)
+	}
+}
@@ -0,0 +1,73 @@
+package xpath
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// mapResolver resolves the documents and texts of its maps, counting the
+// documents resolved.
+type mapResolver struct {
+	docs     map[string]string
+	texts    map[string]string
+	resolved int
+}
+
+func (r *mapResolver) ResolveDocument(_ context.Context, uri string) (NodeNavigator, error) {
+	s, ok := r.docs[uri]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	r.resolved++
+	return createNavigator(parseXML(s)), nil
+}
+
+func (r *mapResolver) ResolveText(_ context.Context, uri string) (string, error) {
+	s, ok := r.texts[uri]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return s, nil
+}
+
+func TestDocumentResolver(t *testing.T) {
+	doc := parseXML(`<refs><ref>a.xml</ref><ref>b.xml</ref><ref>a.xml</ref></refs>`)
+	r := &mapResolver{
+		docs:  map[string]string{"a.xml": `<a><n>1</n><n>2</n></a>`, "b.xml": `<b><n>3</n></b>`},
+		texts: map[string]string{"t.txt": "some text"},
+	}
+	for _, tc := range []struct {
+		expr string
+		want interface{}
+	}{
+		{`count(doc('a.xml')//n)`, float64(2)},
+		{`string(doc('b.xml')/b/n)`, "3"},
+		{`count(document(//ref))`, float64(2)},
+		{`count(document('b.xml')//n) + count(doc('a.xml')/a)`, float64(2)},
+		{`unparsed-text('t.txt')`, "some text"},
+		{`count(//ref[doc(.)/a])`, float64(2)},
+	} {
+		r.resolved = 0
+		got, err := MustCompile(tc.expr).EvaluateWithOptions(createNavigator(doc), EvalOptions{Resolver: r})
+		assertNoErr(t, err)
+		if got != tc.want {
+			t.Errorf("%s = %#v, want %#v", tc.expr, got, tc.want)
+		}
+		// Each document is resolved once for the evaluation.
+		if r.resolved > 2 {
+			t.Errorf("%s resolved %d documents", tc.expr, r.resolved)
+		}
+	}
+
+	_, err := MustCompile(`count(doc('c.xml'))`).EvaluateWithOptions(createNavigator(doc), EvalOptions{Resolver: r})
+	var rerr *ResolveError
+	assertTrue(t, errors.As(err, &rerr))
+	assertEqual(t, "c.xml", rerr.URI)
+	assertEqual(t, "doc", rerr.Func)
+	iter := MustCompile(`doc('c.xml')`).SelectWithOptions(createNavigator(doc), EvalOptions{Resolver: r})
+	assertFalse(t, iter.MoveNext())
+	assertTrue(t, errors.As(iter.Err(), &rerr))
+	_, err = MustCompile(`unparsed-text('a.xml')`).EvaluateWithOptions(createNavigator(doc), EvalOptions{})
+	assertTrue(t, errors.Is(err, ErrNoResolver))
+}
@@ -0,0 +1,185 @@
+package xpath
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A ResultFormat is a format of MarshalResults.
+type ResultFormat int
+
+const (
+	// ResultJSON is a JSON value: a number, a string or a boolean, or, for
+	// a node-set, an array of the objects of its nodes, with their path
+	// and their string-value, or the values of the Columns. JSON has no
+	// NaN and infinite numbers, they are the strings NaN, Infinity and
+	// -Infinity.
+	ResultJSON ResultFormat = iota
+	// ResultCSV is a CSV table with a header: a value column and a row
+	// for a number, a string or a boolean, or, for a node-set, the path and
+	// value columns, or the Columns, and a row for each node.
+	ResultCSV
+)
+
+// A Column is a field of the records of the nodes of a node-set, the
+// value of Expr evaluated from the node, converted to a string if it is a
+// node-set.
+type Column struct {
+	Name string
+	Expr *Expr
+}
+
+// MarshalOptions is the format of MarshalResults.
+type MarshalOptions struct {
+	Format ResultFormat
+
+	// Columns, if not empty, are the fields of the records of the nodes,
+	// in place of their path and value.
+	Columns []Column
+}
+
+// MarshalResults returns v, a result of Evaluate or EvaluateWithOptions,
+// or a []NodeNavigator, in the format of opts. A *NodeIterator is read to
+// its end, and its error, if any, is returned.
+func MarshalResults(v interface{}, opts MarshalOptions) ([]byte, error) {
+	var header []string
+	var rows [][]interface{}
+	switch v := v.(type) {
+	case float64, string, bool:
+		if opts.Format == ResultJSON {
+			return json.Marshal(jsonValue(v))
+		}
+		header, rows = []string{"value"}, [][]interface{}{{v}}
+	case *NodeIterator, []NodeNavigator:
+		var nodes []NodeNavigator
+		if iter, ok := v.(*NodeIterator); ok {
+			for iter.MoveNext() {
+				nodes = append(nodes, iter.Current().Copy())
+			}
+			if err := iter.Err(); err != nil {
+				return nil, err
+			}
+		} else {
+			nodes = v.([]NodeNavigator)
+		}
+		header = []string{"path", "value"}
+		if len(opts.Columns) > 0 {
+			header = header[:0]
+			for _, c := range opts.Columns {
+				header = append(header, c.Name)
+			}
+		}
+		for _, n := range nodes {
+			row, err := resultRow(n, opts.Columns)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		}
+	default:
+		return nil, fmt.Errorf("xpath: cannot marshal a result of type %T", v)
+	}
+	if opts.Format == ResultCSV {
+		return marshalCSV(header, rows)
+	}
+	return marshalRecords(header, rows)
+}
+
+// resultRow returns the fields of the record of the node n.
+func resultRow(n NodeNavigator, columns []Column) ([]interface{}, error) {
+	if len(columns) == 0 {
+		return []interface{}{NodePath(n), n.Value()}, nil
+	}
+	row := make([]interface{}, len(columns))
+	for i, c := range columns {
+		v, err := c.Expr.EvaluateWithOptions(n.Copy(), EvalOptions{RecoverPanics: true})
+		if err != nil {
+			return nil, fmt.Errorf("xpath: column %s: %w", c.Name, err)
+		}
+		if iter, ok := v.(*NodeIterator); ok {
+			v = ""
+			if iter.MoveNext() {
+				v = iter.Current().Value()
+			}
+		}
+		row[i] = v
+	}
+	return row, nil
+}
+
+// marshalRecords returns the JSON array of the objects of rows, whose
+// fields are in the order of header.
+func marshalRecords(header []string, rows [][]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('[')
+	for i, row := range rows {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('{')
+		for j, v := range row {
+			if j > 0 {
+				b.WriteByte(',')
+			}
+			name, _ := json.Marshal(header[j])
+			value, err := json.Marshal(jsonValue(v))
+			if err != nil {
+				return nil, err
+			}
+			b.Write(name)
+			b.WriteByte(':')
+			b.Write(value)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(']')
+	return b.Bytes(), nil
+}
+
+// marshalCSV returns the CSV table of header and rows.
+func marshalCSV(header []string, rows [][]interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(header)
+	for _, row := range rows {
+		fields := make([]string, len(row))
+		for i, v := range row {
+			fields[i] = resultString(v)
+		}
+		w.Write(fields)
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// jsonValue returns v as it is marshaled to JSON.
+func jsonValue(v interface{}) interface{} {
+	if f, ok := v.(float64); ok && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		return resultString(f)
+	}
+	return v
+}
+
+// resultString returns a number, a string or a boolean as string()
+// converts it.
+func resultString(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,39 @@
+package xpath
+
+import "testing"
+
+func TestMarshalResults(t *testing.T) {
+	doc := parseXML(`<items><item id="1"><name>a, b</name><price>2.5</price></item><item id="2"><name>"c"</name></item></items>`)
+	columns := []Column{
+		{"id", MustCompile("number(@id)")},
+		{"name", MustCompile("name")},
+		{"priced", MustCompile("boolean(price)")},
+		{"price", MustCompile("number(price)")},
+	}
+	for _, tc := range []struct {
+		expr string
+		opts MarshalOptions
+		want string
+	}{
+		{`count(//item)`, MarshalOptions{}, `2`},
+		{`1 div 0`, MarshalOptions{}, `"Infinity"`},
+		{`string(//name)`, MarshalOptions{}, `"a, b"`},
+		{`//item/@id`, MarshalOptions{}, `[{"path":"/items[1]/item[1]/@id","value":"1"},{"path":"/items[1]/item[2]/@id","value":"2"}]`},
+		{`//nothing`, MarshalOptions{}, `[]`},
+		{`//item`, MarshalOptions{Columns: columns}, `[{"id":1,"name":"a, b","priced":true,"price":2.5},{"id":2,"name":"\"c\"","priced":false,"price":"NaN"}]`},
+		{`//item/@id`, MarshalOptions{Format: ResultCSV}, "path,value\n/items[1]/item[1]/@id,1\n/items[1]/item[2]/@id,2\n"},
+		{`//item`, MarshalOptions{Format: ResultCSV, Columns: columns}, "id,name,priced,price\n1,\"a, b\",true,2.5\n2,\"\"\"c\"\"\",false,NaN\n"},
+		{`0 > 1`, MarshalOptions{Format: ResultCSV}, "value\nfalse\n"},
+	} {
+		b, err := MarshalResults(MustCompile(tc.expr).Evaluate(createNavigator(doc)), tc.opts)
+		assertNoErr(t, err)
+		if string(b) != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.expr, b, tc.want)
+		}
+	}
+
+	_, err := MarshalResults(MustCompile(`//item`).Evaluate(createNavigator(doc)), MarshalOptions{Columns: []Column{{"bad", MustCompile("count(doc(name))")}}})
+	assertErr(t, err)
+	_, err = MarshalResults(1, MarshalOptions{})
+	assertErr(t, err)
+}
@@ -0,0 +1,245 @@
+package xpath
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Rule is a Schematron rule: the assertions checked for each node its
+// Context pattern matches, and the reports made for them.
+type Rule struct {
+	// Group is the pattern of the rule, in the Schematron sense: a node
+	// is checked by the first rule of each group whose context matches
+	// it. The rules of the empty group are all checked.
+	Group string
+
+	// Context is the XSLT match pattern of the nodes, see CompilePattern.
+	Context string
+
+	// Asserts are the assertions that must hold; one fails when its
+	// test is false.
+	Asserts []Assertion
+
+	// Reports are the assertions reported when their test is true.
+	Reports []Assertion
+}
+
+// An Assertion is a test of a Rule, evaluated from the node of the rule
+// and converted to a boolean as boolean() does, with the message reported
+// for it. The expressions of the message between braces, as in
+// "{name()} has no @id", are replaced by their string values, and {{ and
+// }} are braces.
+type Assertion struct {
+	ID      string
+	Role    string // such as error or warning
+	Test    string
+	Message string
+}
+
+// A RuleResult is a failed assertion, or a report, of a node.
+type RuleResult struct {
+	// Rule is the index of the rule in the rules of CompileRules.
+	Rule   int
+	Group  string
+	ID     string
+	Role   string
+	Test   string
+	Report bool // whether it is a report rather than a failed assertion
+
+	// Message is the message of the assertion, for the node.
+	Message string
+	// Path is the path of the node, see NodePath.
+	Path string
+	Node NodeNavigator
+}
+
+// A RuleSet is a set of rules compiled by CompileRules.
+type RuleSet struct {
+	rules []*compiledRule
+}
+
+type compiledRule struct {
+	Rule
+	context          *Pattern
+	asserts, reports []*compiledAssertion
+}
+
+type compiledAssertion struct {
+	Assertion
+	test *Expr
+	// message is the message as the concatenation of its expressions and
+	// literals.
+	message *Expr
+}
+
+// CompileRules compiles the patterns and the expressions of rules with
+// opts. The error of a rule that is not valid, such as a *SyntaxError,
+// is returned wrapped with the rule and the expression.
+func CompileRules(rules []Rule, opts CompileOptions) (*RuleSet, error) {
+	rs := &RuleSet{}
+	for i, r := range rules {
+		c := &compiledRule{Rule: r}
+		var err error
+		if c.context, err = CompilePatternWithNS(r.Context, opts.Namespaces); err != nil {
+			return nil, fmt.Errorf("xpath: rule %d: context %s: %w", i, r.Context, err)
+		}
+		for _, list := range []struct {
+			in  []Assertion
+			out *[]*compiledAssertion
+		}{{r.Asserts, &c.asserts}, {r.Reports, &c.reports}} {
+			for _, a := range list.in {
+				ca, err := compileAssertion(a, opts)
+				if err != nil {
+					return nil, fmt.Errorf("xpath: rule %d: %w", i, err)
+				}
+				*list.out = append(*list.out, ca)
+			}
+		}
+		rs.rules = append(rs.rules, c)
+	}
+	return rs, nil
+}
+
+func compileAssertion(a Assertion, opts CompileOptions) (*compiledAssertion, error) {
+	test, err := compile(a.Test, opts)
+	if err != nil {
+		return nil, fmt.Errorf("test %s: %w", a.Test, err)
+	}
+	msg, err := messageExpr(a.Message)
+	if err != nil {
+		return nil, fmt.Errorf("message %q: %w", a.Message, err)
+	}
+	message, err := compile(msg, opts)
+	if err != nil {
+		return nil, fmt.Errorf("message %q: %w", a.Message, err)
+	}
+	return &compiledAssertion{Assertion: a, test: test, message: message}, nil
+}
+
+// messageExpr returns the expression of the message template s, the
+// concatenation of its literals and expressions.
+func messageExpr(s string) (string, error) {
+	var parts []string
+	var lit strings.Builder
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "{{"), strings.HasPrefix(s, "}}"):
+			lit.WriteByte(s[0])
+			s = s[2:]
+		case s[0] == '{':
+			i := strings.IndexByte(s, '}')
+			if i < 0 {
+				return "", newError(ErrSyntax, nil, "xpath: unclosed { in message")
+			}
+			if lit.Len() > 0 {
+				parts = append(parts, Quote(lit.String()))
+			}
+			parts = append(parts, "string("+s[1:i]+")")
+			lit.Reset()
+			s = s[i+1:]
+		case s[0] == '}':
+			return "", newError(ErrSyntax, nil, "xpath: unopened } in message")
+		default:
+			lit.WriteByte(s[0])
+			s = s[1:]
+		}
+	}
+	if lit.Len() > 0 || len(parts) == 0 {
+		parts = append(parts, Quote(lit.String()))
+	}
+	if len(parts) == 1 {
+		return parts[0], nil
+	}
+	return "concat(" + strings.Join(parts, ", ") + ")", nil
+}
+
+// Check checks the rules on the nodes of the subtree of nav, attributes
+// included, in document order, evaluating the expressions with opts, and
+// returns the failed assertions and the reports, in document order, then
+// in the order of the rules and of their assertions. An error of an
+// evaluation stops the check.
+func (rs *RuleSet) Check(nav NodeNavigator, opts EvalOptions) ([]RuleResult, error) {
+	var results []RuleResult
+	n := nav.Copy()
+	for depth := 0; ; {
+		var err error
+		if results, err = rs.checkNode(n, opts, results); err != nil {
+			return results, err
+		}
+		if n.NodeType() == ElementNode {
+			attr := n.Copy()
+			for attr.MoveToNextAttribute() {
+				if results, err = rs.checkNode(attr, opts, results); err != nil {
+					return results, err
+				}
+			}
+		}
+		if n.MoveToChild() {
+			depth++
+			continue
+		}
+		for depth > 0 && !n.MoveToNext() {
+			n.MoveToParent()
+			depth--
+		}
+		if depth == 0 {
+			return results, nil
+		}
+	}
+}
+
+// checkNode appends the results of the rules for the node n to results.
+func (rs *RuleSet) checkNode(n NodeNavigator, opts EvalOptions, results []RuleResult) ([]RuleResult, error) {
+	fired := make(map[string]bool)
+	for i, r := range rs.rules {
+		if r.Group != "" && fired[r.Group] || !r.context.Matches(n) {
+			continue
+		}
+		fired[r.Group] = true
+		for _, list := range []struct {
+			assertions []*compiledAssertion
+			report     bool
+		}{{r.asserts, false}, {r.reports, true}} {
+			for _, a := range list.assertions {
+				v, err := a.test.EvaluateWithOptions(n.Copy(), opts)
+				if err != nil {
+					return results, fmt.Errorf("xpath: rule %d: test %s: %w", i, a.Test, err)
+				}
+				ok, err := truth(v)
+				if err != nil {
+					return results, fmt.Errorf("xpath: rule %d: test %s: %w", i, a.Test, err)
+				}
+				if ok != list.report {
+					continue
+				}
+				msg, err := a.message.EvaluateWithOptions(n.Copy(), opts)
+				if err != nil {
+					return results, fmt.Errorf("xpath: rule %d: message %q: %w", i, a.Message, err)
+				}
+				results = append(results, RuleResult{
+					Rule:    i,
+					Group:   r.Group,
+					ID:      a.ID,
+					Role:    a.Role,
+					Test:    a.Test,
+					Report:  list.report,
+					Message: msg.(string),
+					Path:    NodePath(n),
+					Node:    n.Copy(),
+				})
+			}
+		}
+	}
+	return results, nil
+}
+
+// truth returns the value v of an evaluation as boolean() converts it.
+func truth(v interface{}) (bool, error) {
+	switch v := v.(type) {
+	case *NodeIterator:
+		return v.MoveNext(), v.Err()
+	case float64:
+		return v != 0 && v == v, nil
+	}
+	return asBool(nil, v), nil
+}
@@ -0,0 +1,55 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRuleSet(t *testing.T) {
+	doc := parseXML(`<order><item sku="a1" qty="2"/><item qty="0"/><item sku="b'2" qty="x"/><note/></order>`)
+	rs, err := CompileRules([]Rule{
+		{Group: "items", Context: "item[@sku]", Asserts: []Assertion{
+			{ID: "qty", Role: "error", Test: "number(@qty) > 0", Message: "item {@sku} has a quantity of '{@qty}'"},
+		}},
+		// Only the first rule of a group checks a node.
+		{Group: "items", Context: "item", Asserts: []Assertion{
+			{ID: "sku", Test: "@sku", Message: "{{item}} {count(preceding-sibling::item) + 1} has no sku"},
+		}},
+		{Context: "order", Reports: []Assertion{
+			{ID: "count", Role: "info", Test: "count(item)", Message: "{count(item)} items"},
+		}},
+		{Context: "@qty", Asserts: []Assertion{{Test: "string(number(.)) != 'NaN'", Message: "not a number"}}},
+	}, CompileOptions{})
+	assertNoErr(t, err)
+	results, err := rs.Check(createNavigator(doc), EvalOptions{})
+	assertNoErr(t, err)
+	var got []string
+	for _, r := range results {
+		got = append(got, r.ID+"|"+r.Role+"|"+r.Message+"|"+r.Path)
+	}
+	assertEqual(t, []string{
+		"count|info|3 items|/order[1]",
+		"sku||{item} 2 has no sku|/order[1]/item[2]",
+		"qty|error|item b'2 has a quantity of 'x'|/order[1]/item[3]",
+		"||not a number|/order[1]/item[3]/@qty",
+	}, got)
+	assertTrue(t, results[0].Report)
+	assertFalse(t, results[1].Report)
+	assertEqual(t, 1, results[1].Rule)
+
+	for _, rule := range []Rule{
+		{Context: "item[", Asserts: nil},
+		{Context: "ancestor::item"},
+		{Context: "item", Asserts: []Assertion{{Test: "@sku =", Message: "m"}}},
+		{Context: "item", Reports: []Assertion{{Test: "@sku", Message: "{@sku"}}},
+		{Context: "item", Reports: []Assertion{{Test: "@sku", Message: "{@sku = }"}}},
+	} {
+		_, err := CompileRules([]Rule{rule}, CompileOptions{})
+		assertTrue(t, errors.Is(err, ErrSyntax))
+	}
+
+	rs, err = CompileRules([]Rule{{Context: "item", Asserts: []Assertion{{Test: "doc('x')", Message: "m"}}}}, CompileOptions{})
+	assertNoErr(t, err)
+	_, err = rs.Check(createNavigator(doc), EvalOptions{})
+	assertTrue(t, errors.Is(err, ErrNoResolver))
+}
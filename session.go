@@ -0,0 +1,260 @@
+package xpath
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Session is an evaluation of an expression recorded in production,
+// with the options, the document and the result, to be replayed locally
+// when an application selects the wrong nodes: write it with WriteTo,
+// read it back with ReadSession, parse Doc and call Replay, or run the
+// sessions of a directory as tests with xpathtest.RunSessions.
+type Session struct {
+	Expr    string
+	Options CompileOptions
+	// DocHash is the DocumentHash of the document the expression was
+	// evaluated on, to check that the document replayed is the same.
+	DocHash string
+	// Doc is the document as the application read it, if it was
+	// recorded, and HTML whether it is HTML rather than XML.
+	Doc  []byte
+	HTML bool
+	// Result is the result of the expression, such as
+	// "nodes /a[1] /a[1]/b[2]", "number 2", `string "a"`, "boolean
+	// true" or "error xpath: expression must evaluate to a node-set".
+	Result string
+}
+
+// RecordSession evaluates expr from root, with EvalOptions.RecoverPanics,
+// and returns the session of the evaluation, with the document doc that
+// root is the document node of. doc may be nil; set HTML if it is HTML.
+func RecordSession(expr *Expr, root NodeNavigator, doc []byte) *Session {
+	return &Session{
+		Expr:    expr.s,
+		Options: expr.opts,
+		DocHash: DocumentHash(root),
+		Doc:     doc,
+		Result:  sessionResult(expr, root),
+	}
+}
+
+// Replay compiles and evaluates the expression of the session again from
+// root, such as the document node of Doc parsed again, and returns the
+// session of that evaluation, to be compared with s.
+func (s *Session) Replay(root NodeNavigator) *Session {
+	r := &Session{Expr: s.Expr, Options: s.Options, DocHash: DocumentHash(root), Doc: s.Doc, HTML: s.HTML}
+	expr, err := compile(s.Expr, s.Options)
+	if err != nil {
+		r.Result = "error " + err.Error()
+	} else {
+		r.Result = sessionResult(expr, root)
+	}
+	return r
+}
+
+// sessionResult returns the result of expr from root, for
+// Session.Result.
+func sessionResult(expr *Expr, root NodeNavigator) string {
+	v, err := expr.EvaluateWithOptions(root, EvalOptions{RecoverPanics: true})
+	if iter, ok := v.(*NodeIterator); ok && err == nil {
+		paths := []string{"nodes"}
+		for iter.MoveNext() {
+			paths = append(paths, NodePath(iter.Current()))
+		}
+		if err = iter.Err(); err == nil {
+			return strings.Join(paths, " ")
+		}
+	}
+	if err != nil {
+		return "error " + err.Error()
+	}
+	switch v := v.(type) {
+	case float64:
+		return "number " + strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return "string " + strconv.Quote(v)
+	case bool:
+		return "boolean " + strconv.FormatBool(v)
+	}
+	return fmt.Sprintf("%T %v", v, v)
+}
+
+// DocumentHash returns the first 16 hexadecimal digits of a SHA-256 hash
+// of the tree of the document of nav: the types, names, namespace URIs
+// and values of its nodes, in document order. The documents parsed from
+// the same text by two parsers have the same hash if their trees are the
+// same, such as if both have the whitespace text nodes. nav is not moved.
+func DocumentHash(nav NodeNavigator) string {
+	nav = nav.Copy()
+	nav.MoveToRoot()
+	h := sha256.New()
+	var b []byte
+	write := func(s string) {
+		b = strconv.AppendInt(b[:0], int64(len(s)), 10)
+		b = append(b, ':')
+		b = append(b, s...)
+		h.Write(b)
+	}
+	var walk func()
+	walk = func() {
+		typ := nav.NodeType()
+		write(strconv.Itoa(int(typ)))
+		switch typ {
+		case ElementNode:
+			write(navNamespaceURL(nav))
+			write(nav.LocalName())
+			for attr := nav.Copy(); attr.MoveToNextAttribute(); {
+				write(navNamespaceURL(attr))
+				write(attr.LocalName())
+				write(attr.Value())
+			}
+		case TextNode, CommentNode:
+			write(nav.Value())
+		}
+		if nav.MoveToChild() {
+			for {
+				walk()
+				if !nav.MoveToNext() {
+					break
+				}
+			}
+			nav.MoveToParent()
+		}
+		write(")")
+	}
+	walk()
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// WriteTo writes the session to w: a header of a line per field, such as
+// expr "//a" or namespace "b" "urn:b", with the strings quoted as Go
+// strings, then a blank line and the document.
+func (s *Session) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "expr %s\n", strconv.Quote(s.Expr))
+	prefixes := make([]string, 0, len(s.Options.Namespaces))
+	for prefix := range s.Options.Namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&b, "namespace %s %s\n", strconv.Quote(prefix), strconv.Quote(s.Options.Namespaces[prefix]))
+	}
+	c := s.Options.Compat
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"reorder-predicates", s.Options.ReorderPredicates},
+		{"compare-dates", s.Options.CompareDates},
+		{"strict-numbers", s.Options.StrictNumbers},
+		{"anchor-root", s.Options.Anchor == AnchorRoot},
+		{"characters", c.Characters},
+		{"double-numbers", c.DoubleNumbers},
+		{"xsd-regexps", c.XSDRegexps},
+	} {
+		if opt.set {
+			fmt.Fprintf(&b, "option %s\n", strconv.Quote(opt.name))
+		}
+	}
+	if c.Engine != "" {
+		fmt.Fprintf(&b, "engine %s\n", strconv.Quote(c.Engine))
+	}
+	if ns := s.Options.NumberStrings; ns != (NumberStrings{}) {
+		fmt.Fprintf(&b, "numbers %s %s %s\n", strconv.Quote(ns.NaN), strconv.Quote(ns.Infinity), strconv.Quote(ns.NegativeInfinity))
+	}
+	fmt.Fprintf(&b, "hash %s\n", strconv.Quote(s.DocHash))
+	if s.HTML {
+		b.WriteString("html\n")
+	}
+	fmt.Fprintf(&b, "result %s\n", strconv.Quote(s.Result))
+	b.WriteString("\n")
+	b.Write(s.Doc)
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+// ReadSession reads a session written by Session.WriteTo.
+func ReadSession(rd io.Reader) (*Session, error) {
+	s := &Session{}
+	br := bufio.NewReader(rd)
+	for line := 1; ; line++ {
+		text, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("xpath: session line %d: want a header and a document", line)
+		}
+		text = strings.TrimSuffix(text, "\n")
+		if text == "" {
+			break
+		}
+		fields := strings.SplitN(text, " ", 2)
+		var args []string
+		if len(fields) == 2 {
+			for v := fields[1]; v != ""; v = strings.TrimPrefix(v, " ") {
+				q, err := strconv.QuotedPrefix(v)
+				if err != nil {
+					return nil, fmt.Errorf("xpath: session line %d: %v", line, err)
+				}
+				arg, _ := strconv.Unquote(q)
+				args = append(args, arg)
+				v = v[len(q):]
+			}
+		}
+		want := map[string]int{"expr": 1, "namespace": 2, "option": 1, "engine": 1, "numbers": 3, "hash": 1, "html": 0, "result": 1}
+		if n, ok := want[fields[0]]; !ok || n != len(args) {
+			return nil, fmt.Errorf("xpath: session line %d: unknown field %q", line, text)
+		}
+		switch fields[0] {
+		case "expr":
+			s.Expr = args[0]
+		case "namespace":
+			if s.Options.Namespaces == nil {
+				s.Options.Namespaces = make(map[string]string)
+			}
+			s.Options.Namespaces[args[0]] = args[1]
+		case "option":
+			switch args[0] {
+			case "reorder-predicates":
+				s.Options.ReorderPredicates = true
+			case "compare-dates":
+				s.Options.CompareDates = true
+			case "strict-numbers":
+				s.Options.StrictNumbers = true
+			case "anchor-root":
+				s.Options.Anchor = AnchorRoot
+			case "characters":
+				s.Options.Compat.Characters = true
+			case "double-numbers":
+				s.Options.Compat.DoubleNumbers = true
+			case "xsd-regexps":
+				s.Options.Compat.XSDRegexps = true
+			default:
+				return nil, fmt.Errorf("xpath: session line %d: unknown option %q", line, args[0])
+			}
+		case "engine":
+			s.Options.Compat.Engine = args[0]
+		case "numbers":
+			s.Options.NumberStrings = NumberStrings{NaN: args[0], Infinity: args[1], NegativeInfinity: args[2]}
+		case "hash":
+			s.DocHash = args[0]
+		case "html":
+			s.HTML = true
+		case "result":
+			s.Result = args[0]
+		}
+	}
+	doc, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	s.Doc = doc
+	return s, nil
+}
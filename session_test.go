@@ -0,0 +1,72 @@
+package xpath
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	doc := createNode("", RootNode)
+	r := doc.createChildNode("r", ElementNode)
+	a := r.createChildNode("a", ElementNode)
+	a.addAttribute("id", "1")
+	a.createChildNode("x", TextNode)
+	r.createChildNode("a", ElementNode).createChildNode("y", TextNode)
+	nav := createNavigator(doc)
+
+	expr, err := CompileWithOptions(`//a[@id = 1] | //b:a`, CompileOptions{
+		Namespaces:    map[string]string{"b": "urn:b"},
+		Compat:        SaxonHE,
+		NumberStrings: XPathNumberStrings,
+		StrictNumbers: true,
+		Anchor:        AnchorRoot,
+	})
+	assertNoErr(t, err)
+	s := RecordSession(expr, nav, []byte(`<r><a id="1">x</a><a>y</a></r>`))
+	assertEqual(t, "nodes /r[1]/a[1]", s.Result)
+	assertEqual(t, DocumentHash(nav), s.DocHash)
+	assertEqual(t, 16, len(s.DocHash))
+
+	var b bytes.Buffer
+	s.WriteTo(&b)
+	assertTrue(t, strings.HasPrefix(b.String(), `expr "//a[@id = 1] | //b:a"`+"\n"+`namespace "b" "urn:b"`+"\n"))
+	read, err := ReadSession(&b)
+	assertNoErr(t, err)
+	if !reflect.DeepEqual(read, s) {
+		t.Fatalf("ReadSession = %+v, want %+v", read, s)
+	}
+	assertTrue(t, reflect.DeepEqual(s, s.Replay(nav)))
+
+	// A changed document has another hash and result.
+	a.addAttribute("lang", "en")
+	a.FirstChild.Data = "z"
+	replayed := s.Replay(nav)
+	assertEqual(t, s.Result, replayed.Result)
+	assertTrue(t, replayed.DocHash != s.DocHash)
+	a.Attr[0].Value = "2"
+	assertEqual(t, "nodes", s.Replay(nav).Result)
+
+	for expr, want := range map[string]string{
+		`count(//a)`:  "number 2",
+		`string(//a)`: `string "z"`,
+		`//a = 'y'`:   "boolean true",
+	} {
+		assertEqual(t, want, RecordSession(MustCompile(expr), nav, nil).Result)
+	}
+	assertTrue(t, strings.HasPrefix(RecordSession(MustCompile(`sum('a')`), nav, nil).Result, "error "))
+	s.Expr = "//a["
+	assertTrue(t, strings.HasPrefix(s.Replay(nav).Result, "error "))
+
+	for _, data := range []string{
+		`expr "//a"`,
+		"expr //a\n\n",
+		"option \"fast\"\n\n",
+		"html \"1\"\n\n",
+	} {
+		if _, err := ReadSession(strings.NewReader(data)); err == nil {
+			t.Errorf("expected error reading session %q", data)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package xpath
+
+// Union returns the nodes that are in a or b, in document order and each
+// of them once, as a | b selects them. Two navigators are the same node
+// if they are at the same position in the document, as ComparePosition or
+// the positions among the siblings tell, not if they are equal as Go
+// values, so the results of different queries can be combined.
+func Union(a, b []NodeNavigator) []NodeNavigator {
+	return combineNodes(a, b, true, true, true)
+}
+
+// Intersect returns the nodes that are in both a and b, in document order
+// and each of them once. The nodes are the same as for Union.
+func Intersect(a, b []NodeNavigator) []NodeNavigator {
+	return combineNodes(a, b, false, true, false)
+}
+
+// Except returns the nodes of a that are not in b, in document order and
+// each of them once. The nodes are the same as for Union.
+func Except(a, b []NodeNavigator) []NodeNavigator {
+	return combineNodes(a, b, true, false, false)
+}
+
+// combineNodes returns the nodes only in a, in both a and b, and only in
+// b, for those of onlyA, both and onlyB that are true, in document order.
+func combineNodes(a, b []NodeNavigator, onlyA, both, onlyB bool) []NodeNavigator {
+	x, y := setNodes(a), setNodes(b)
+	var nodes []NodeNavigator
+	add := func(n orderedNode, keep bool) {
+		if keep {
+			nodes = append(nodes, n.node)
+		}
+	}
+	for len(x) > 0 && len(y) > 0 {
+		switch c := x[0].compare(&y[0]); {
+		case c < 0:
+			add(x[0], onlyA)
+			x = x[1:]
+		case c > 0:
+			add(y[0], onlyB)
+			y = y[1:]
+		default:
+			add(x[0], both)
+			x, y = x[1:], y[1:]
+		}
+	}
+	for _, n := range x {
+		add(n, onlyA)
+	}
+	for _, n := range y {
+		add(n, onlyB)
+	}
+	return nodes
+}
+
+// setNodes returns copies of nodes in document order without duplicates.
+func setNodes(nodes []NodeNavigator) []orderedNode {
+	list := make([]orderedNode, len(nodes))
+	for i, n := range nodes {
+		list[i].node = n.Copy()
+	}
+	return sortNodes(list)
+}
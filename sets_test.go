@@ -0,0 +1,37 @@
+package xpath
+
+import "testing"
+
+func TestNodeSetOperations(t *testing.T) {
+	nav := createNavigator(book_example)
+	nodes := func(expr string) []NodeNavigator {
+		var list []NodeNavigator
+		iter := MustCompile(expr).Select(nav)
+		for iter.MoveNext() {
+			list = append(list, iter.Current().Copy())
+		}
+		return list
+	}
+	paths := func(list []NodeNavigator) []string {
+		s := []string{}
+		for _, n := range list {
+			s = append(s, NodePath(n))
+		}
+		return s
+	}
+	book := func(i string) string { return "/bookstore[1]/book[" + i + "]" }
+
+	// The navigators of different queries are not equal Go values.
+	web, cheap := nodes("//book[@category = 'web']"), nodes("//book[price < 40]")
+	assertEqual(t, []string{book("1"), book("2"), book("3"), book("4")}, paths(Union(web, cheap)))
+	assertEqual(t, []string{book("4")}, paths(Intersect(web, cheap)))
+	assertEqual(t, []string{book("3")}, paths(Except(web, cheap)))
+	assertEqual(t, []string{book("1"), book("2")}, paths(Except(cheap, web)))
+
+	// The nodes are sorted, and each of them is once in the result.
+	reversed := nodes("reverse(//book)")
+	assertEqual(t, []string{book("1"), book("2"), book("3"), book("4")}, paths(Union(reversed, reversed)))
+	assertEqual(t, paths(nodes("//book/@category | //book/title")), paths(Union(nodes("//book/title"), nodes("//book/@category"))))
+	assertEqual(t, []string{}, paths(Intersect(nodes("//book"), nodes("//title"))))
+	assertEqual(t, []string{}, paths(Except(nil, web)))
+}
@@ -0,0 +1,156 @@
+package xpath
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A signature is the number of arguments of a built-in function and the
+// types they can have, checked when the expression is compiled.
+type signature struct {
+	min, max int // number of arguments; max is -1 if there is no maximum
+	// params are the types each argument can have, nil for any type. The
+	// last one is that of the arguments after it.
+	params [][]staticType
+	result staticType // the type of the value, or unknownType
+}
+
+// The types of the arguments of the signatures. The functions of XPath 1.0
+// convert their string, number and boolean arguments, so these take any
+// type, and only the node-set arguments are checked. text is for the
+// arguments of the other functions that are only used for their string
+// value but are not converted to a string first, so that a number or a
+// boolean would be taken as "".
+var (
+	anyArg     []staticType
+	nodeSetArg = []staticType{nodeSetType}
+	stringArg  = []staticType{stringType}
+	textArg    = []staticType{stringType, nodeSetType}
+)
+
+// functionSignatures are the signatures of the built-in functions, by
+// name.
+var functionSignatures = map[string]signature{
+	"boolean":          {1, 1, [][]staticType{anyArg}, boolType},
+	"ceiling":          {1, 1, [][]staticType{anyArg}, numberType},
+	"concat":           {2, -1, [][]staticType{anyArg}, stringType},
+	"compare":          {2, 3, [][]staticType{textArg, textArg, stringArg}, numberType},
+	"contains":         {2, 3, [][]staticType{anyArg, anyArg, stringArg}, boolType},
+	"count":            {1, 1, [][]staticType{nodeSetArg}, numberType},
+	"doc":              {1, 1, [][]staticType{anyArg}, nodeSetType},
+	"document":         {1, 1, [][]staticType{anyArg}, nodeSetType},
+	"ends-with":        {2, 3, [][]staticType{anyArg, anyArg, stringArg}, boolType},
+	"false":            {0, 0, nil, boolType},
+	"floor":            {1, 1, [][]staticType{anyArg}, numberType},
+	"id":               {1, 1, [][]staticType{anyArg}, nodeSetType},
+	"last":             {0, 0, nil, numberType},
+	"local-name":       {0, 1, [][]staticType{nodeSetArg}, stringType},
+	"lower-case":       {1, 1, [][]staticType{anyArg}, stringType},
+	"matches":          {2, 3, [][]staticType{textArg, stringArg}, boolType},
+	"name":             {0, 1, [][]staticType{nodeSetArg}, stringType},
+	"namespace-uri":    {0, 1, [][]staticType{nodeSetArg}, stringType},
+	"normalize-space":  {0, 1, [][]staticType{anyArg}, stringType},
+	"not":              {1, 1, [][]staticType{anyArg}, boolType},
+	"number":           {0, 1, [][]staticType{anyArg}, numberType},
+	"position":         {0, 0, nil, numberType},
+	"replace":          {3, 4, [][]staticType{anyArg}, stringType},
+	"reverse":          {1, 1, [][]staticType{nodeSetArg}, nodeSetType},
+	"round":            {1, 1, [][]staticType{anyArg}, numberType},
+	"starts-with":      {2, 3, [][]staticType{anyArg, anyArg, stringArg}, boolType},
+	"string":           {0, 1, [][]staticType{anyArg}, stringType},
+	"string-join":      {2, 2, [][]staticType{textArg}, stringType},
+	"string-length":    {1, 1, [][]staticType{anyArg}, numberType},
+	"substring":        {2, 3, [][]staticType{anyArg}, stringType},
+	"substring-after":  {2, 2, [][]staticType{anyArg}, stringType},
+	"substring-before": {2, 2, [][]staticType{anyArg}, stringType},
+	"sum":              {1, 1, [][]staticType{{nodeSetType, numberType, stringType}}, numberType},
+	"translate":        {3, 3, [][]staticType{anyArg}, stringType},
+	"true":             {0, 0, nil, boolType},
+	"unparsed-text":    {1, 1, [][]staticType{anyArg}, stringType},
+}
+
+// builtinFunctions are the functions processFunction builds.
+var builtinFunctions = func() map[string]bool {
+	m := make(map[string]bool, len(functionSignatures))
+	for name := range functionSignatures {
+		m[name] = true
+	}
+	return m
+}()
+
+func (t staticType) String() string {
+	switch t {
+	case nodeSetType:
+		return "node-set"
+	case numberType:
+		return "number"
+	case stringType:
+		return "string"
+	case boolType:
+		return "boolean"
+	}
+	return "value"
+}
+
+// checkCall returns an error if the call n of a built-in function has too
+// few or too many arguments, or an argument of a type the function does
+// not take.
+func checkCall(n *functionNode) error {
+	sig, ok := functionSignatures[n.FuncName]
+	if !ok {
+		return nil
+	}
+	return sig.check(n, n.FuncName)
+}
+
+// check returns the error of checkCall for the call n of the function
+// name with the signature sig.
+func (sig signature) check(n *functionNode, name string) error {
+	if len(n.Args) < sig.min || sig.max >= 0 && len(n.Args) > sig.max {
+		return newError(ErrArgumentCount, nil, "xpath: %s() takes %s, got %d", name, sig.arity(), len(n.Args))
+	}
+	for i, arg := range n.Args {
+		types := sig.params[len(sig.params)-1]
+		if i < len(sig.params) {
+			types = sig.params[i]
+		}
+		typ := typeOf(arg)
+		if types == nil || typ == unknownType {
+			continue
+		}
+		ok := false
+		names := make([]string, len(types))
+		for k, t := range types {
+			ok = ok || t == typ
+			names[k] = t.String()
+		}
+		if !ok {
+			return newError(ErrTypeMismatch, nil, "xpath: argument %d of %s() must be a %s, not a %s", i+1, name, strings.Join(names, " or "), typ)
+		}
+	}
+	return nil
+}
+
+// arity describes the number of arguments of sig, such as "2 or 3
+// arguments".
+func (sig signature) arity() string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+	switch {
+	case sig.max == 0:
+		return "no arguments"
+	case sig.max < 0:
+		return "at least " + plural(sig.min)
+	case sig.min == sig.max:
+		return plural(sig.min)
+	case sig.min == 0:
+		return "at most " + plural(sig.max)
+	case sig.max == sig.min+1:
+		return fmt.Sprintf("%d or %s", sig.min, plural(sig.max))
+	}
+	return fmt.Sprintf("%d to %s", sig.min, plural(sig.max))
+}
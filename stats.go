@@ -0,0 +1,182 @@
+package xpath
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Stats are the counters of the evaluations with EvalOptions.Stats. The
+// counters add up over the evaluations sharing a Stats, which must not be
+// concurrent.
+type Stats struct {
+	// NodesVisited is the number of moves of the navigators from a node
+	// to another, other than by MoveTo.
+	NodesVisited int
+	// Copies is the number of calls of Copy on the navigators.
+	Copies int
+	// FunctionCalls is the number of calls of XPath functions.
+	FunctionCalls int
+	// BufferedNodes is the number of nodes held in intermediate
+	// node-sets, counted as for EvalOptions.MaxBufferedNodes.
+	BufferedNodes int
+	// Duration is the time spent evaluating, including the calls of
+	// MoveNext on the iterators of the results.
+	Duration time.Duration
+}
+
+// since adds the time since start to the duration of s, if not nil.
+func (s *Stats) since(start time.Time) {
+	if s != nil {
+		s.Duration += time.Since(start)
+	}
+}
+
+func (l *limiter) addCall() {
+	if l != nil {
+		l.stats.FunctionCalls++
+	}
+}
+
+// contextInterval is the number of moves of the navigators between two
+// checks of EvalOptions.Context.
+const contextInterval = 256
+
+// visit counts a move of the navigators, and stops the evaluation if its
+// context is done, checked every contextInterval moves.
+func (l *limiter) visit() {
+	l.stats.NodesVisited++
+	if l.opts.Context != nil && l.stats.NodesVisited%contextInterval == 0 {
+		l.checkContext()
+	}
+}
+
+// checkContext panics with a *TimeoutError or a *CanceledError if the
+// context of the evaluation is done.
+func (l *limiter) checkContext() {
+	if l == nil || l.opts.Context == nil || l.stopped != nil {
+		return
+	}
+	switch err := l.opts.Context.Err(); {
+	case err == nil:
+	case errors.Is(err, context.DeadlineExceeded):
+		panic(&TimeoutError{Progress: l.progress()})
+	default:
+		panic(&CanceledError{Progress: l.progress()})
+	}
+}
+
+// context returns the context of the evaluation, for the resolver.
+func (l *limiter) context() context.Context {
+	if l.opts.Context == nil {
+		return context.Background()
+	}
+	return l.opts.Context
+}
+
+// progress returns the progress of the evaluation so far.
+func (l *limiter) progress() Progress {
+	return Progress{
+		Results:       l.results,
+		NodesVisited:  l.stats.NodesVisited - l.base.NodesVisited,
+		BufferedNodes: l.stats.BufferedNodes - l.base.BufferedNodes,
+		FunctionCalls: l.stats.FunctionCalls - l.base.FunctionCalls,
+		Elapsed:       time.Since(l.start),
+	}
+}
+
+// wrap returns root wrapped to count its moves and to check its
+// comparisons, if the options need them. expr is the expression evaluated.
+func (l *limiter) wrap(root NodeNavigator, expr string) NodeNavigator {
+	if l.opts.VerifyComparisons {
+		root = &comparingNavigator{NodeNavigator: root, expr: expr}
+	}
+	if l.opts.Stats != nil || l.opts.Context != nil {
+		root = &statsNavigator{NodeNavigator: root, lim: l}
+	}
+	return root
+}
+
+// statsNavigator counts the moves and copies of a navigator.
+type statsNavigator struct {
+	NodeNavigator
+	lim *limiter
+}
+
+// Unwrap returns the underlying navigator.
+func (s *statsNavigator) Unwrap() NodeNavigator {
+	return s.NodeNavigator
+}
+
+func (s *statsNavigator) NamespaceURL() string {
+	return navNamespaceURL(s.NodeNavigator)
+}
+
+func (s *statsNavigator) Copy() NodeNavigator {
+	s.lim.stats.Copies++
+	return &statsNavigator{NodeNavigator: s.NodeNavigator.Copy(), lim: s.lim}
+}
+
+func (s *statsNavigator) visit(moved bool) bool {
+	if moved {
+		s.lim.visit()
+	}
+	return moved
+}
+
+func (s *statsNavigator) MoveToRoot() {
+	s.NodeNavigator.MoveToRoot()
+	s.lim.visit()
+}
+
+func (s *statsNavigator) MoveToParent() bool {
+	return s.visit(s.NodeNavigator.MoveToParent())
+}
+
+func (s *statsNavigator) MoveToNextAttribute() bool {
+	return s.visit(s.NodeNavigator.MoveToNextAttribute())
+}
+
+func (s *statsNavigator) MoveToChild() bool {
+	return s.visit(s.NodeNavigator.MoveToChild())
+}
+
+func (s *statsNavigator) MoveToFirst() bool {
+	return s.visit(s.NodeNavigator.MoveToFirst())
+}
+
+func (s *statsNavigator) MoveToNext() bool {
+	return s.visit(s.NodeNavigator.MoveToNext())
+}
+
+func (s *statsNavigator) MoveToPrevious() bool {
+	return s.visit(s.NodeNavigator.MoveToPrevious())
+}
+
+func (s *statsNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*statsNavigator); ok {
+		other = o.NodeNavigator
+	}
+	return s.NodeNavigator.MoveTo(other)
+}
+
+func (s *statsNavigator) ComparePosition(other NodeNavigator) (int, bool) {
+	d, ok := s.NodeNavigator.(DocumentOrderNavigator)
+	o, same := other.(*statsNavigator)
+	if !ok || !same {
+		return 0, false
+	}
+	return d.ComparePosition(o.NodeNavigator)
+}
+
+func (s *statsNavigator) lookupAttr(prefix, localName, value string) ([]indexedNode, bool) {
+	ix, ok := s.NodeNavigator.(attrIndexer)
+	if !ok {
+		return nil, false
+	}
+	list, ok := ix.lookupAttr(prefix, localName, value)
+	for i := range list {
+		list[i].node = &statsNavigator{NodeNavigator: list[i].node, lim: s.lim}
+	}
+	return list, ok
+}
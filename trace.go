@@ -0,0 +1,168 @@
+package xpath
+
+// A Trace evaluates an expression like Expr.Evaluate, and records how many
+// nodes each step and filter of the expression produce, to find the
+// predicate that filtered out the nodes that were expected. The counts
+// are those of all the evaluations of the trace, see Trace.Plan.
+//
+// A traced evaluation selects the nodes one at a time where an untraced
+// one may stop early or count them without selecting them, so it is
+// slower. A Trace is not safe for concurrent use.
+type Trace struct {
+	expr *Expr
+	info *planInfo
+	q    query
+}
+
+// Trace returns a new trace of the expression.
+func (expr *Expr) Trace() *Trace {
+	// The expression is built again, as for Plan, so that the counters
+	// are not in the queries of the compiled expression.
+	info := newPlanInfo()
+	q, err := build(expr.s, expr.opts, info)
+	if err != nil || q == nil {
+		info, q = nil, expr.q.Clone()
+	}
+	return &Trace{expr: expr, info: info, q: rewriteQuery(q, traceQuery)}
+}
+
+// Evaluate is Expr.Evaluate, recording the nodes produced. When the result
+// is a node-set, the nodes are recorded as it is iterated.
+func (t *Trace) Evaluate(root NodeNavigator) interface{} {
+	q := t.q.Clone()
+	val := q.Evaluate(iteratorFunc(func() NodeNavigator { return root }))
+	switch val.(type) {
+	case query:
+		return &NodeIterator{query: q, node: root}
+	}
+	return val
+}
+
+// Plan returns the plan of the expression, see Expr.Plan, with the number
+// of nodes each operation produced in the evaluations so far.
+func (t *Trace) Plan() *Plan {
+	p := t.info.describe(t.q)
+	if t.info != nil {
+		p.Notes = append(p.Notes, t.info.exprNotes...)
+	}
+	return p
+}
+
+// tracedQuery counts the nodes selected by a node-set query. It does not
+// implement existenceQuery and countingQuery, so that the nodes are
+// selected and counted.
+type tracedQuery struct {
+	query
+	nodes *int
+}
+
+func (q *tracedQuery) Select(t iterator) NodeNavigator {
+	node := q.query.Select(t)
+	if node != nil {
+		*q.nodes++
+	}
+	return node
+}
+
+func (q *tracedQuery) Evaluate(t iterator) interface{} {
+	v := q.query.Evaluate(t)
+	if v == interface{}(q.query) {
+		// The node-set is the query itself, it is selected through q.
+		return q
+	}
+	return v
+}
+
+func (q *tracedQuery) Clone() query {
+	return &tracedQuery{query: q.query.Clone(), nodes: q.nodes}
+}
+
+func (q *tracedQuery) position() int {
+	return getNodePosition(q.query)
+}
+
+func (q *tracedQuery) hasPosition() bool {
+	return hasPosition(q.query)
+}
+
+// Test is the node test of q, for position() and last(), see predicate.
+func (q *tracedQuery) Test(n NodeNavigator) bool {
+	return predicate(q.query)(n)
+}
+
+func (q *tracedQuery) skipContext() {
+	if s, ok := q.query.(contextSkipper); ok {
+		s.skipContext()
+	}
+}
+
+// traceQuery returns q counting its nodes, if it is a node-set query.
+func traceQuery(q query) query {
+	if q.ValueType() != xpathResultType.NodeSet {
+		return q
+	}
+	return &tracedQuery{query: q, nodes: new(int)}
+}
+
+// rewriteQuery returns q with each query in it replaced by wrap of it,
+// inputs first. The arguments of function calls are not rewritten.
+func rewriteQuery(q query, wrap func(query) query) query {
+	if q == nil {
+		return nil
+	}
+	switch q := q.(type) {
+	case *ancestorQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *attributeQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *childQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *cachedChildQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *orderedChildQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *descendantQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *descendantOverDescendantQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *followingQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *precedingQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *parentQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *hasChildQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *selfQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *filterQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+		q.Predicate = rewriteQuery(q.Predicate, wrap)
+	case *lastQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *indexQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *mergeQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+		q.Child = rewriteQuery(q.Child, wrap)
+	case *groupQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *documentOrderQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	case *unionQuery:
+		q.Left = rewriteQuery(q.Left, wrap)
+		q.Right = rewriteQuery(q.Right, wrap)
+	case *booleanQuery:
+		q.Left = rewriteQuery(q.Left, wrap)
+		q.Right = rewriteQuery(q.Right, wrap)
+	case *logicalQuery:
+		q.Left = rewriteQuery(q.Left, wrap)
+		q.Right = rewriteQuery(q.Right, wrap)
+	case *numericQuery:
+		q.Left = rewriteQuery(q.Left, wrap)
+		q.Right = rewriteQuery(q.Right, wrap)
+	case *transformFunctionQuery:
+		q.Input = rewriteQuery(q.Input, wrap)
+	}
+	return wrap(q)
+}
@@ -0,0 +1,40 @@
+package xpath
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	tr := MustCompile(`//book[@category='web'][price > 40]/title`).Trace()
+	iter := tr.Evaluate(createNavigator(book_example)).(*NodeIterator)
+	var titles []string
+	for iter.MoveNext() {
+		titles = append(titles, iter.Current().Value())
+	}
+	assertEqual(t, []string{"XQuery Kick Start"}, titles)
+
+	// The nodes entering and surviving each filter.
+	title := tr.Plan()
+	assertEqual(t, "child::title", title.Op)
+	assertEqual(t, 1, title.Nodes)
+	price := title.Inputs[0]
+	assertEqual(t, "filter", price.Op)
+	assertEqual(t, 1, price.Nodes)
+	category := price.Inputs[0]
+	assertEqual(t, "filter", category.Op)
+	assertEqual(t, 2, category.Nodes)
+	assertEqual(t, "descendant::book", category.Inputs[0].Op)
+	assertEqual(t, 4, category.Inputs[0].Nodes)
+	assertTrue(t, strings.Contains(tr.Plan().String(), "filter: 2 nodes\n"))
+	assertFalse(t, strings.Contains(MustCompile(`//book`).Plan().String(), "nodes"))
+
+	// The counts add up over the evaluations.
+	tr = MustCompile(`//book[price > 35]`).Trace()
+	for i := 0; i < 2; i++ {
+		for iter := tr.Evaluate(createNavigator(book_example)).(*NodeIterator); iter.MoveNext(); {
+		}
+	}
+	assertEqual(t, 4, tr.Plan().Nodes)
+	assertEqual(t, 8, tr.Plan().Inputs[0].Nodes)
+}
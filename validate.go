@@ -0,0 +1,76 @@
+package xpath
+
+// ValidateOptions declares what an expression checked by Validate may
+// refer to.
+type ValidateOptions struct {
+	// Namespaces maps the prefixes the expression may use to namespace
+	// URIs, as CompileOptions.Namespaces.
+	Namespaces map[string]string
+
+	// Variables are the names of the variables the expression may
+	// reference, with their prefix if any, such as "limit" or "app:limit".
+	// The application binds them itself, for example by replacing them
+	// with literals before compiling the expression.
+	Variables []string
+
+	// Functions are the functions the expression may call besides the
+	// built-in ones, by name with their prefix if any, such as
+	// "app:price".
+	Functions map[string]FunctionDecl
+}
+
+// A FunctionDecl declares a function for Validate by its number of
+// arguments.
+type FunctionDecl struct {
+	MinArgs int
+	// MaxArgs is the largest number of arguments, or -1 if there is no
+	// maximum.
+	MaxArgs int
+}
+
+// Validate reports whether expr is an expression that compiles with the
+// namespaces, variables and functions of opts, as for the expressions of
+// a configuration file checked when an application starts. The error is
+// that Compile would return, such as a *SyntaxError, an
+// *UnknownFunctionError or one wrapping ErrUnboundVariable or
+// ErrArgumentCount. The expression is neither kept nor evaluated.
+func Validate(expr string, opts ValidateOptions) error {
+	if expr == "" {
+		return newError(ErrSyntax, nil, "expr expression is nil")
+	}
+	b := &builder{decl: &opts}
+	q, err := b.build(expr, CompileOptions{Namespaces: opts.Namespaces})
+	if err == nil && q == nil {
+		err = newError(ErrUnboundVariable, nil, "undeclared variable in XPath expression: %s", expr)
+	}
+	return err
+}
+
+// declares reports whether v is one of the declared variables.
+func (opts *ValidateOptions) declares(v *variableNode) bool {
+	name := qualifiedName(v.Prefix, v.Name)
+	for _, s := range opts.Variables {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+// declaredCall checks the call n of the declared function f, whose
+// arguments are checked as those of the built-in functions. The query
+// built is not evaluated.
+func (b *builder) declaredCall(n *functionNode, name string, f FunctionDecl, props *builderProp) (query, error) {
+	sig := signature{min: f.MinArgs, max: f.MaxArgs}
+	if len(n.Args) < sig.min || sig.max >= 0 && len(n.Args) > sig.max {
+		return nil, newError(ErrArgumentCount, nil, "xpath: %s() takes %s, got %d", name, sig.arity(), len(n.Args))
+	}
+	for _, arg := range n.Args {
+		var argProps builderProp
+		if _, err := b.processNode(arg, flagsEnum.None, &argProps); err != nil {
+			return nil, err
+		}
+		*props |= argProps
+	}
+	return nopQuery{}, nil
+}
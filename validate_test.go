@@ -0,0 +1,53 @@
+package xpath
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	opts := ValidateOptions{
+		Namespaces: map[string]string{"app": "urn:app"},
+		Variables:  []string{"limit", "app:name"},
+		Functions: map[string]FunctionDecl{
+			"app:price": {MinArgs: 1, MaxArgs: 2},
+			"app:join":  {MinArgs: 1, MaxArgs: -1},
+		},
+	}
+	for _, expr := range []string{
+		`//app:book[price > $limit]`,
+		`//book[@name = $app:name]/title`,
+		`sum(app:price(//book, 'EUR')) > $limit`,
+		`app:join(//a, //b, concat($limit, 'x'))`,
+	} {
+		if err := Validate(expr, opts); err != nil {
+			t.Errorf("%s: %v", expr, err)
+		}
+	}
+	for _, tc := range []struct {
+		expr   string
+		target error
+	}{
+		{`//other:book`, ErrSyntax},
+		{`//book[`, ErrSyntax},
+		{`//book[price > $max]`, ErrUnboundVariable},
+		{`app:price()`, ErrArgumentCount},
+		{`app:price(1, 2, 3)`, ErrArgumentCount},
+		{`app:join(count('a'))`, ErrTypeMismatch},
+		{`app:cost(//book)`, ErrUnknownFunction},
+		{`matches(., '(')`, ErrInvalidRegexp},
+	} {
+		err := Validate(tc.expr, opts)
+		if !errors.Is(err, tc.target) {
+			t.Errorf("%s: expected %v, got %v", tc.expr, tc.target, err)
+		}
+	}
+	assertEqual(t, "xpath: app:price() takes 1 or 2 arguments, got 3", Validate(`app:price(1, 2, 3)`, opts).Error())
+
+	// Without declarations, the errors are those of Compile.
+	for _, expr := range []string{`//book[price > $limit]`, `app:price(1)`, `count(//a, //b)`} {
+		_, want := Compile(expr)
+		assertEqual(t, want.Error(), Validate(expr, ValidateOptions{}).Error())
+	}
+	assertNoErr(t, Validate(`count(//book)`, ValidateOptions{}))
+}
@@ -0,0 +1,77 @@
+package xpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// warns reports whether the evaluation reports warnings, for the checks
+// that cost more than the operation itself.
+func (l *limiter) warns() bool {
+	return l != nil && l.opts.Warn != nil
+}
+
+// warn reports the result of op applied to the string value that XPath
+// 1.0 would not give, as described by format.
+func (l *limiter) warn(op, value, format string, args ...interface{}) {
+	if l.warns() {
+		msg := fmt.Sprintf("%s of %s ", op, Quote(value)) + fmt.Sprintf(format, args...)
+		l.opts.Warn(Warning{Kind: NonStandard, Msg: msg})
+	}
+}
+
+// xpathNumber converts s to a number as XPath 1.0 does: an optional minus
+// sign followed by digits with an optional decimal point, between
+// whitespace, and NaN otherwise.
+func xpathNumber(s string) (float64, bool) {
+	s = strings.Trim(s, " \t\r\n")
+	digits := strings.TrimPrefix(s, "-")
+	whole, frac := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		whole, frac = digits[:i], digits[i+1:]
+	}
+	if whole == "" && frac == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	return v, err == nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// warnNumber reports the conversion of s to v, or to NaN if !ok, by op if
+// XPath 1.0 converts it to another number.
+func warnNumber(t iterator, op, s string, v float64, ok bool) {
+	lim := limitsOf(t)
+	if !lim.warns() {
+		return
+	}
+	want, wantOK := xpathNumber(s)
+	switch {
+	case ok && !wantOK:
+		lim.warn(op, s, "is %v, XPath 1.0 gives NaN", v)
+	case !ok && wantOK:
+		lim.warn(op, s, "is NaN, XPath 1.0 gives %v", want)
+	}
+}
+
+// warnBytes reports that op counts the bytes of s, if s has characters of
+// more than one byte that XPath 1.0 counts as one.
+func warnBytes(t iterator, op, s string) {
+	lim := limitsOf(t)
+	if !lim.warns() {
+		return
+	}
+	if n := utf8.RuneCountInString(s); n != len(s) {
+		lim.warn(op, s, "counts %d bytes, XPath 1.0 counts %d characters", len(s), n)
+	}
+}
@@ -0,0 +1,45 @@
+package xpath
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEvalWarnings(t *testing.T) {
+	doc := createNode("", RootNode)
+	r := doc.createChildNode("r", ElementNode)
+	r.addAttribute("v", " 2 ")
+	r.createChildNode("n", ElementNode).createChildNode("1e3", TextNode)
+	r.createChildNode("n", ElementNode).createChildNode("2", TextNode)
+	r.createChildNode("n", ElementNode).createChildNode("x", TextNode)
+	r.createChildNode("s", ElementNode).createChildNode("héllo", TextNode)
+
+	for _, tc := range []struct {
+		expr     string
+		want     interface{}
+		warnings []string
+	}{
+		{`number(//n[1])`, math.NaN(), nil},
+		{`number(/r/@v)`, float64(2), nil},
+		{`number('-1.5') + number('.5')`, float64(-1), nil},
+		{`sum(//n)`, math.NaN(), nil},
+		{`string-length(//s)`, float64(6), []string{"string-length() of 'héllo' counts 6 bytes, XPath 1.0 counts 5 characters"}},
+		{`substring('abc', 2)`, "bc", nil},
+		{`substring(//s, 4)`, "llo", []string{"substring() of 'héllo' counts 6 bytes, XPath 1.0 counts 5 characters"}},
+	} {
+		var got []string
+		v, err := MustCompile(tc.expr).EvaluateWithOptions(createNavigator(doc), EvalOptions{Warn: func(w Warning) {
+			assertEqual(t, NonStandard, w.Kind)
+			got = append(got, w.String())
+		}})
+		assertNoErr(t, err)
+		if f, ok := tc.want.(float64); ok && math.IsNaN(f) {
+			if !math.IsNaN(v.(float64)) {
+				t.Errorf("%s = %v, want NaN", tc.expr, v)
+			}
+		} else {
+			assertEqual(t, tc.want, v)
+		}
+		assertEqual(t, tc.warnings, got)
+	}
+}
@@ -1,8 +1,13 @@
 package xpath
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // NodeType represents a type of XPath node.
@@ -43,6 +48,11 @@ type NodeNavigator interface {
 	Value() string
 
 	// Copy does a deep copy of the NodeNavigator and all its components.
+	//
+	// The engine copies a navigator only to keep a position while it moves
+	// another navigator, and otherwise moves its own copies with MoveTo, so
+	// a query makes a few copies rather than one per node. Navigators for
+	// which Copy is expensive can be wrapped with CopyOnWrite.
 	Copy() NodeNavigator
 
 	// MoveToRoot moves the NodeNavigator to the root node of the current node.
@@ -70,10 +80,46 @@ type NodeNavigator interface {
 	MoveTo(NodeNavigator) bool
 }
 
+// VirtualAttributeNavigator is an optional interface that a NodeNavigator
+// can implement to expose computed attributes, such as @xpath-depth or
+// host-specific metadata, that are not stored on the node.
+//
+// When an attribute name test like @name matches no real attribute of an
+// element, MoveToVirtualAttribute is called with the local name. If it
+// returns true the navigator must be positioned on an attribute node with
+// that name, and behave as it does on a real attribute: NodeType returns
+// AttributeNode, Value returns the computed value, and MoveToParent moves
+// back to the element. Virtual attributes are never returned by @*.
+type VirtualAttributeNavigator interface {
+	NodeNavigator
+
+	// MoveToVirtualAttribute moves the NodeNavigator to the computed
+	// attribute with the given local name on the current node.
+	MoveToVirtualAttribute(name string) bool
+}
+
+// DocumentOrderNavigator is an optional interface that a NodeNavigator
+// can implement to compare positions in document order cheaply. Without
+// it, node-sets are put in document order by counting the siblings of
+// each node and its ancestors.
+type DocumentOrderNavigator interface {
+	NodeNavigator
+
+	// ComparePosition returns -1, 0 or 1 as the current node comes
+	// before, is the same as, or comes after the current node of other.
+	// It returns false if the two nodes cannot be compared, for example
+	// because they belong to different documents.
+	ComparePosition(other NodeNavigator) (int, bool)
+}
+
 // NodeIterator holds all matched Node object.
 type NodeIterator struct {
 	node  NodeNavigator
 	query query
+
+	lim  *limiter
+	err  error
+	expr string // for the errors of EvalOptions.RecoverPanics
 }
 
 // Current returns current node which matched.
@@ -83,6 +129,54 @@ func (t *NodeIterator) Current() NodeNavigator {
 
 // MoveNext moves Navigator to the next match node.
 func (t *NodeIterator) MoveNext() bool {
+	if t.lim != nil {
+		return t.moveNextLimited()
+	}
+	return t.moveNext()
+}
+
+// moveNextLimited is MoveNext for an iterator returned by SelectWithOptions
+// or EvaluateWithOptions, which stops at the first limit exceeded.
+func (t *NodeIterator) moveNextLimited() (ok bool) {
+	if t.err != nil {
+		return false
+	}
+	if s := t.lim.opts.Stats; s != nil {
+		defer s.since(time.Now())
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			t.err, ok = recovered(e, t.expr, t.lim.opts), false
+		}
+	}()
+	t.lim.checkContext()
+	if !t.moveNext() {
+		if err := t.lim.stopped; err != nil {
+			progressOf(err).Results = t.lim.results
+			t.err = err
+		}
+		return false
+	}
+	t.lim.results++
+	return true
+}
+
+// Err returns the error that stopped the iteration, a *ResourceLimitError
+// if a limit of EvalOptions was exceeded, a *TimeoutError or a
+// *CanceledError if its Context was done, an *EvalError if the evaluation
+// panicked with EvalOptions.RecoverPanics, an *OrderError if a step was
+// found out of order with EvalOptions.VerifyOrder, a *ComparisonError if
+// two nodes compared out of order with EvalOptions.VerifyComparisons, or
+// nil if all the nodes were returned.
+func (t *NodeIterator) Err() error {
+	return t.err
+}
+
+func (t *NodeIterator) limits() *limiter {
+	return t.lim
+}
+
+func (t *NodeIterator) moveNext() bool {
 	n := t.query.Select(t)
 	if n == nil {
 		return false
@@ -104,9 +198,18 @@ func Select(root NodeNavigator, expr string) *NodeIterator {
 }
 
 // Expr is an XPath expression for query.
+//
+// An Expr is safe for concurrent use by multiple goroutines: Select and
+// Evaluate run on a private copy of the compiled query. A NodeIterator and
+// the NodeNavigator passed to Select or Evaluate are not, they are moved
+// during evaluation; give each goroutine its own navigator, for example
+// with Copy. If the document behind the navigators does not support
+// concurrent reads, wrap the navigator with SyncNavigator.
 type Expr struct {
-	s string
-	q query
+	s       string
+	q       query
+	opts    CompileOptions
+	rebuild *rebuiltQueries
 }
 
 type iteratorFunc func() NodeNavigator
@@ -117,8 +220,17 @@ func (f iteratorFunc) Current() NodeNavigator {
 
 // Evaluate returns the result of the expression.
 // The result type of the expression is one of the follow: bool,float64,string,NodeIterator).
+// The nodes of a NodeIterator are in document order, each of them once,
+// except for those of reverse().
 func (expr *Expr) Evaluate(root NodeNavigator) interface{} {
-	val := expr.q.Evaluate(iteratorFunc(func() NodeNavigator { return root }))
+	if lim := expr.compatLimiter(); lim != nil {
+		val := expr.q.Clone().Evaluate(&NodeIterator{node: root, lim: lim})
+		if _, ok := val.(query); ok {
+			return &NodeIterator{query: expr.q.Clone(), node: root, lim: lim}
+		}
+		return val
+	}
+	val := expr.q.Clone().Evaluate(iteratorFunc(func() NodeNavigator { return root }))
 	switch val.(type) {
 	case query:
 		return &NodeIterator{query: expr.q.Clone(), node: root}
@@ -126,9 +238,470 @@ func (expr *Expr) Evaluate(root NodeNavigator) interface{} {
 	return val
 }
 
-// Select selects a node set using the specified XPath expression.
+// Select selects a node set using the specified XPath expression. The
+// nodes are in document order, each of them once, except for those of
+// reverse(). Paths that may select them otherwise, such as //*/../.. or the
+// ancestor and preceding axes from several nodes, sort them, see Plan.
 func (expr *Expr) Select(root NodeNavigator) *NodeIterator {
-	return &NodeIterator{query: expr.q.Clone(), node: root}
+	return &NodeIterator{query: expr.q.Clone(), node: root, lim: expr.compatLimiter()}
+}
+
+// EvalOptions limits the memory an evaluation may use, for expressions or
+// documents that are not trusted, and how it fails. A limit of 0 means no
+// limit.
+type EvalOptions struct {
+	// MaxBufferedNodes is the number of nodes the evaluation may hold in
+	// intermediate node-sets, such as those of unions, reverse(), id() and
+	// last(), and those sorted in document order. Nodes streamed from one
+	// step to the next are not counted, and the count is not lowered when
+	// a node-set is released.
+	MaxBufferedNodes int
+
+	// MaxStringBytes is the total length of the string values read from
+	// nodes and of the strings built by functions such as concat() during
+	// the evaluation.
+	MaxStringBytes int
+
+	// RecoverPanics returns the panics of the evaluation, such as that of
+	// a function called with an argument of the wrong type, as an
+	// *EvalError rather than panicking, for servers evaluating
+	// expressions they do not control.
+	RecoverPanics bool
+
+	// Stats, if not nil, is filled with the counters of the evaluation,
+	// for monitoring. The navigators are then wrapped to count their
+	// moves, so the nodes of the iterators are wrappers too; use their
+	// Unwrap method to get back the navigator passed in.
+	Stats *Stats
+
+	// VerifyOrder checks that each step selects its nodes in document
+	// order, each of them once, as the evaluation relies on, and stops it
+	// with an *OrderError otherwise. It is for testing NodeNavigator
+	// implementations, whose MoveToChild, MoveToNext and ComparePosition
+	// must agree; it costs a comparison for each node of each step. The
+	// steps in the arguments of function calls are not checked.
+	VerifyOrder bool
+
+	// VerifyComparisons checks each comparison of two nodes in document
+	// order, made to sort node-sets and to select the children of nested
+	// nodes, against their positions among their siblings, and stops the
+	// evaluation with a *ComparisonError if they disagree. The positions
+	// are checked too, by walking down to each node from the root. It is
+	// for testing NodeNavigator implementations, whose ComparePosition,
+	// moves and Copy must agree; each comparison walks the ancestors of
+	// both nodes. The navigators are wrapped as for Stats.
+	VerifyComparisons bool
+
+	// Warn, if not nil, is called for each result of the evaluation that
+	// is not the one XPath 1.0 specifies, such as the numbers in the
+	// syntax that Compat.DoubleNumbers accepts, or the bytes that
+	// string-length() and substring() count rather than characters.
+	Warn func(Warning)
+
+	// Context, if not nil, stops the evaluation once it is done, with a
+	// *TimeoutError if its deadline passed and a *CanceledError if it was
+	// canceled. It is checked before each node of the result and as the
+	// navigators move, so they are wrapped as for Stats.
+	Context context.Context
+
+	// PartialResults returns the nodes of a node-set found before a limit
+	// was exceeded or the Context was done, rather than only those
+	// returned before: the nodes held to be sorted in document order or
+	// merged by a union are returned too, then the iterator's Err method
+	// returns the error. The nodes are in the result, in document order,
+	// but others may be missing.
+	PartialResults bool
+
+	// Resolver, if not nil, loads the documents of doc() and document()
+	// and the text of unparsed-text(), with the Context. A document is
+	// loaded once for each evaluation. Without it, and when it fails, the
+	// calls fail with a *ResolveError.
+	Resolver DocumentResolver
+}
+
+// The categories of the errors returned by Compile, and panicked with by
+// Evaluate, for use with errors.Is.
+var (
+	// ErrSyntax is the error a *SyntaxError wraps.
+	ErrSyntax = errors.New("xpath: syntax error")
+	// ErrUnknownFunction is returned for a call of a function that is not
+	// supported.
+	ErrUnknownFunction = errors.New("xpath: unknown function")
+	// ErrArgumentCount is returned for a function call with too few or too
+	// many arguments.
+	ErrArgumentCount = errors.New("xpath: wrong number of arguments")
+	// ErrUnboundVariable is returned for a variable reference such as $x,
+	// as there is no way to bind variables.
+	ErrUnboundVariable = errors.New("xpath: unbound variable")
+	// ErrTypeMismatch is returned for a function argument of a type the
+	// function does not take, such as count('a'), and panicked with when
+	// a value does not convert, such as that of sum('a').
+	ErrTypeMismatch = errors.New("xpath: type mismatch")
+	// ErrInvalidRegexp is returned for a pattern of matches() or replace()
+	// that is not a valid regular expression. The error of the regexp
+	// package is wrapped too.
+	ErrInvalidRegexp = errors.New("xpath: invalid regular expression")
+	// ErrTooComplex is returned for an expression nested too deeply.
+	ErrTooComplex = errors.New("xpath: expression is too complex")
+	// ErrUnknownCollation is returned for a collation argument of
+	// contains(), starts-with(), ends-with() or compare() that is not the
+	// URI of a collation, such as CollationCaseFold, and panicked with
+	// when it is computed.
+	ErrUnknownCollation = errors.New("xpath: unknown collation")
+	// ErrNotANumber is panicked with, with CompileOptions.StrictNumbers,
+	// when a value that is not a number is converted to one.
+	ErrNotANumber = errors.New("xpath: not a number")
+)
+
+// categoryError is an error of one of the categories above, with its own
+// message and the error that caused it, if any.
+type categoryError struct {
+	category error
+	msg      string
+	err      error
+}
+
+func newError(category, cause error, format string, args ...interface{}) error {
+	return &categoryError{category: category, msg: fmt.Sprintf(format, args...), err: cause}
+}
+
+func (e *categoryError) Error() string {
+	return e.msg
+}
+
+func (e *categoryError) Is(target error) bool {
+	return target == e.category
+}
+
+func (e *categoryError) Unwrap() error {
+	return e.err
+}
+
+// ErrResourceLimit is the error a *ResourceLimitError wraps, for use with
+// errors.Is.
+var ErrResourceLimit = errors.New("xpath: resource limit exceeded")
+
+// ResourceLimitError is returned when an evaluation exceeds a limit of
+// EvalOptions.
+type ResourceLimitError struct {
+	// Limit is the name of the EvalOptions field that was exceeded.
+	Limit string
+	// Max is the value of the limit.
+	Max int
+	// Progress is how far the evaluation went.
+	Progress Progress
+}
+
+func (e *ResourceLimitError) Error() string {
+	return fmt.Sprintf("xpath: resource limit exceeded: %s is %d", e.Limit, e.Max)
+}
+
+func (e *ResourceLimitError) Unwrap() error {
+	return ErrResourceLimit
+}
+
+// Progress is how far an evaluation went before it was stopped by an
+// error of EvalOptions, for deciding whether the nodes returned so far
+// are usable.
+type Progress struct {
+	// Results is the number of nodes of the result the iterator returned,
+	// which are the first ones in the order of the expression.
+	Results int
+	// NodesVisited, BufferedNodes and FunctionCalls are the counters of
+	// Stats for the evaluation. The navigators are only counted with
+	// EvalOptions.Stats or Context.
+	NodesVisited  int
+	BufferedNodes int
+	FunctionCalls int
+	// Elapsed is the time since SelectWithOptions or EvaluateWithOptions
+	// was called.
+	Elapsed time.Duration
+}
+
+// TimeoutError is returned when the deadline of EvalOptions.Context
+// passes during an evaluation. It wraps context.DeadlineExceeded.
+type TimeoutError struct {
+	// Progress is how far the evaluation went.
+	Progress Progress
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("xpath: evaluation deadline exceeded after %v, %d nodes returned", e.Progress.Elapsed, e.Progress.Results)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// CanceledError is returned when EvalOptions.Context is canceled during
+// an evaluation. It wraps context.Canceled.
+type CanceledError struct {
+	// Progress is how far the evaluation went.
+	Progress Progress
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("xpath: evaluation canceled after %v, %d nodes returned", e.Progress.Elapsed, e.Progress.Results)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return context.Canceled
+}
+
+// ErrDocumentOrder is the error an *OrderError wraps, for use with
+// errors.Is.
+var ErrDocumentOrder = errors.New("xpath: nodes out of document order")
+
+// OrderError is returned by an evaluation with EvalOptions.VerifyOrder
+// when a step selects a node before the previous one in document order,
+// or twice, usually because the navigator moves in an order its
+// ComparePosition does not agree with.
+type OrderError struct {
+	// Expr is the expression evaluated.
+	Expr string
+	// Op is the operation of the step, as in its Plan, such as
+	// "child::book".
+	Op string
+	// Duplicate is true if the node was the previous one again.
+	Duplicate bool
+}
+
+func (e *OrderError) Error() string {
+	if e.Duplicate {
+		return fmt.Sprintf("xpath: %s in %s selected a node twice", e.Op, e.Expr)
+	}
+	return fmt.Sprintf("xpath: %s in %s selected a node before the previous one", e.Op, e.Expr)
+}
+
+func (e *OrderError) Unwrap() error {
+	return ErrDocumentOrder
+}
+
+// ComparisonError is returned by an evaluation with
+// EvalOptions.VerifyComparisons when two nodes compare in an order other
+// than that of their positions in the document, or when walking down
+// from the root does not reach a node. It wraps ErrDocumentOrder.
+type ComparisonError struct {
+	// Expr is the expression evaluated.
+	Expr string
+	// Nodes are the NodePath of the nodes compared.
+	Nodes [2]string
+	// Msg describes the disagreement, such as "ComparePosition returns 1,
+	// their positions -1".
+	Msg string
+}
+
+func (e *ComparisonError) Error() string {
+	return fmt.Sprintf("xpath: comparing %s with %s in %s: %s", e.Nodes[0], e.Nodes[1], e.Expr, e.Msg)
+}
+
+func (e *ComparisonError) Unwrap() error {
+	return ErrDocumentOrder
+}
+
+// EvalError is an error of an evaluation with EvalOptions.RecoverPanics,
+// which would otherwise have panicked.
+type EvalError struct {
+	// Expr is the expression evaluated.
+	Expr string
+	// Op is the operation of the expression that panicked, as in its
+	// Plan, such as "sum()" or "child::book", or "" if it is not known.
+	Op string
+	// Offset and End are the byte offsets in Expr of the operator or
+	// function call that panicked, such as that of sum('a') in
+	// sum(//price) + sum('a'). Offset is -1 for the other operations.
+	Offset, End int
+	// Value is the value the evaluation panicked with.
+	Value interface{}
+}
+
+// Source returns the subexpression of Expr that panicked, or "" if it is
+// not known.
+func (e *EvalError) Source() string {
+	if e.Offset < 0 || e.End > len(e.Expr) || e.Offset >= e.End {
+		return ""
+	}
+	return e.Expr[e.Offset:e.End]
+}
+
+func (e *EvalError) Error() string {
+	if s := e.Source(); s != "" {
+		return fmt.Sprintf("xpath: evaluating %s at offset %d of %s: %v", s, e.Offset, e.Expr, e.Value)
+	}
+	if e.Op == "" {
+		return fmt.Sprintf("xpath: evaluating %s: %v", e.Expr, e.Value)
+	}
+	return fmt.Sprintf("xpath: evaluating %s in %s: %v", e.Op, e.Expr, e.Value)
+}
+
+// Unwrap returns Value if it is an error, so that errors.Is finds its
+// category, such as ErrTypeMismatch.
+func (e *EvalError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// SyntaxError is returned by Compile when the expression is not valid
+// XPath. Its message shows the expression with a caret under the
+// offending token.
+type SyntaxError struct {
+	// Expr is the expression that was compiled.
+	Expr string
+	// Offset is the byte offset of Token in Expr.
+	Offset int
+	// Token is the offending token, or "" at the end of the expression.
+	Token string
+	// Expected lists what the parser expected instead of Token, such as
+	// "']'" or "name", if Msg is empty.
+	Expected []string
+	// Msg describes why Token cannot be scanned, such as an unclosed
+	// string.
+	Msg string
+	// Suggestion is what Token was probably meant to be, such as
+	// "descendant::" for "decendant::", if any.
+	Suggestion string
+}
+
+func (e *SyntaxError) Error() string {
+	found := "end of expression"
+	if e.Token != "" {
+		found = strconv.Quote(e.Token)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "xpath: syntax error at offset %d: ", e.Offset)
+	if e.Msg != "" {
+		fmt.Fprintf(&b, "%s %s", e.Msg, found)
+	} else {
+		b.WriteString("expected ")
+		for i, s := range e.Expected {
+			if i > 0 && i == len(e.Expected)-1 {
+				b.WriteString(" or ")
+			} else if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(s)
+		}
+		fmt.Fprintf(&b, ", found %s", found)
+	}
+	if e.Suggestion != "" {
+		fmt.Fprintf(&b, ", did you mean %q?", e.Suggestion)
+	}
+	// The line of the expression the token is on, with a caret under it.
+	start := strings.LastIndexByte(e.Expr[:e.Offset], '\n') + 1
+	end := strings.IndexByte(e.Expr[e.Offset:], '\n')
+	if end < 0 {
+		end = len(e.Expr)
+	} else {
+		end += e.Offset
+	}
+	line := e.Expr[start:end]
+	fmt.Fprintf(&b, "\n\t%s\n\t%s^", line, strings.Repeat(" ", utf8.RuneCountInString(e.Expr[start:e.Offset])))
+	return b.String()
+}
+
+func (e *SyntaxError) Unwrap() error {
+	return ErrSyntax
+}
+
+// UnknownFunctionError is returned by Compile for a call of a function
+// that is not supported. It wraps ErrUnknownFunction.
+type UnknownFunctionError struct {
+	// Name is the name of the function, with its prefix if any.
+	Name string
+	// Suggestion is the supported function closest to Name, such as
+	// "lower-case" for "lowercase", or "" if none is close.
+	Suggestion string
+}
+
+func (e *UnknownFunctionError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("xpath: unknown function %s(), did you mean %s()?", e.Name, e.Suggestion)
+	}
+	return fmt.Sprintf("xpath: unknown function %s()", e.Name)
+}
+
+func (e *UnknownFunctionError) Unwrap() error {
+	return ErrUnknownFunction
+}
+
+// EvaluateWithOptions is Evaluate within the limits of opts. If a limit
+// is exceeded it returns a *ResourceLimitError, if opts.Context is done a
+// *TimeoutError or a *CanceledError, and if the evaluation panics with
+// opts.RecoverPanics an *EvalError. When the result is a node-set, the
+// limits continue to apply as it is iterated, and the iterator stops with
+// the error returned by its Err method.
+func (expr *Expr) EvaluateWithOptions(root NodeNavigator, opts EvalOptions) (v interface{}, err error) {
+	lim := newLimiter(opts)
+	lim.setOptions(expr.opts)
+	if opts.Stats != nil {
+		defer opts.Stats.since(time.Now())
+	}
+	root = lim.wrap(root, expr.s)
+	defer func() {
+		if e := recover(); e != nil {
+			v, err = nil, recovered(e, expr.s, opts)
+		}
+	}()
+	lim.checkContext()
+	val := expr.evalQuery(opts).Evaluate(&NodeIterator{node: root, lim: lim})
+	switch val.(type) {
+	case query:
+		return &NodeIterator{query: expr.evalQuery(opts), node: root, lim: lim, expr: expr.s}, nil
+	}
+	return val, nil
+}
+
+// evalQuery returns a copy of the query of expr to evaluate with opts.
+func (expr *Expr) evalQuery(opts EvalOptions) query {
+	var q query
+	if opts.RecoverPanics || opts.VerifyOrder {
+		q = expr.rebuilt(opts.RecoverPanics, opts.VerifyOrder)
+	} else {
+		q = expr.q.Clone()
+	}
+	if opts.PartialResults {
+		markPartial(q)
+	}
+	return q
+}
+
+// recovered returns the error for the panic e of an evaluation of expr
+// with opts, or panics again with e if the evaluation is to panic.
+func recovered(e interface{}, expr string, opts EvalOptions) error {
+	switch e := e.(type) {
+	case *ResourceLimitError:
+		return e
+	case *TimeoutError:
+		return e
+	case *CanceledError:
+		return e
+	case *OrderError:
+		return e
+	case *ComparisonError:
+		return e
+	case *ResolveError:
+		return e
+	case *EvalError:
+		if opts.RecoverPanics {
+			return e
+		}
+	default:
+		if opts.RecoverPanics {
+			return &EvalError{Expr: expr, Offset: -1, Value: e}
+		}
+	}
+	panic(e)
+}
+
+// SelectWithOptions is Select within the limits of opts. The iteration
+// stops once a limit is exceeded, and the iterator's Err method returns a
+// *ResourceLimitError, once opts.Context is done, and Err returns a
+// *TimeoutError or a *CanceledError, or once the evaluation panics with
+// opts.RecoverPanics, and Err returns an *EvalError.
+func (expr *Expr) SelectWithOptions(root NodeNavigator, opts EvalOptions) *NodeIterator {
+	lim := newLimiter(opts)
+	lim.setOptions(expr.opts)
+	return &NodeIterator{query: expr.evalQuery(opts), node: lim.wrap(root, expr.s), lim: lim, expr: expr.s}
 }
 
 // String returns XPath expression string.
@@ -136,19 +709,10 @@ func (expr *Expr) String() string {
 	return expr.s
 }
 
-// Compile compiles an XPath expression string.
+// Compile compiles an XPath expression string. If the expression is not
+// valid XPath, the error is a *SyntaxError.
 func Compile(expr string) (*Expr, error) {
-	if expr == "" {
-		return nil, errors.New("expr expression is nil")
-	}
-	qy, err := build(expr, nil)
-	if err != nil {
-		return nil, err
-	}
-	if qy == nil {
-		return nil, fmt.Errorf(fmt.Sprintf("undeclared variable in XPath expression: %s", expr))
-	}
-	return &Expr{s: expr, q: qy}, nil
+	return CompileWithOptions(expr, CompileOptions{})
 }
 
 // MustCompile compiles an XPath expression string and ignored error.
@@ -162,15 +726,80 @@ func MustCompile(expr string) *Expr {
 
 // CompileWithNS compiles an XPath expression string, using given namespaces map.
 func CompileWithNS(expr string, namespaces map[string]string) (*Expr, error) {
+	return CompileWithOptions(expr, CompileOptions{Namespaces: namespaces})
+}
+
+// CompileOptions controls how an expression is compiled.
+type CompileOptions struct {
+	// Namespaces maps the prefixes used in the expression to namespace
+	// URIs. If it is not nil, the names of no prefix select the nodes in
+	// no namespace only, as in XPath 1.0, if the navigator has their
+	// NamespaceURL.
+	Namespaces map[string]string
+
+	// ReorderPredicates evaluates the cheap predicates of a step, such as
+	// attribute comparisons, before the expensive ones, such as // paths
+	// or regular expressions. Predicates are not moved across a predicate
+	// that depends on position() or last(), or that may fail at runtime,
+	// such as a doc() call or a comparison with StrictNumbers, and the
+	// operands of and and or keep their order, so results and errors do
+	// not change.
+	ReorderPredicates bool
+
+	// Compat reproduces behaviors of another engine, such as SaxonHE,
+	// for the expressions written for it.
+	Compat Compat
+
+	// CompareDates compares the strings that are ISO-8601 dates, such as
+	// 2024-01-31, or date-times, such as 2024-01-31T09:30:00+01:00,
+	// chronologically in the comparisons of strings and node-sets, so
+	// that @published > '2024-01-01' selects the later dates. A date or
+	// date-time without a time zone is in UTC. The other strings compare
+	// as they do without the option, as strings.
+	CompareDates bool
+
+	// NumberStrings are the strings of NaN and the infinities when
+	// numbers are converted to strings, such as XPathNumberStrings for
+	// the Infinity of XPath 1.0 rather than the +Inf of Go.
+	NumberStrings NumberStrings
+
+	// StrictNumbers stops the evaluation with an ErrNotANumber error,
+	// panicked with as the other errors of the evaluation, when a string
+	// that is not a number, or an empty node-set, is converted to NaN by
+	// number(), sum(), the arithmetic or the comparisons with a number,
+	// such as in @price > 10 for a price of 'n/a', rather than compare
+	// NaN, which is false.
+	StrictNumbers bool
+
+	// Anchor is where the relative paths of the expression start: at the
+	// node it is evaluated from, with AnchorContext, the default, or at
+	// the root of its document, with AnchorRoot, for the expressions
+	// written for tools that evaluate them from the root whatever the
+	// node.
+	Anchor Anchor
+}
+
+// CompileWithOptions compiles an XPath expression string with the given
+// options.
+func CompileWithOptions(expr string, opts CompileOptions) (*Expr, error) {
+	if Recorder != nil {
+		Recorder(expr)
+	}
+	return compile(expr, opts)
+}
+
+// compile is CompileWithOptions without the Recorder, for the expressions
+// the package compiles itself.
+func compile(expr string, opts CompileOptions) (*Expr, error) {
 	if expr == "" {
-		return nil, errors.New("expr expression is nil")
+		return nil, newError(ErrSyntax, nil, "expr expression is nil")
 	}
-	qy, err := build(expr, namespaces)
+	qy, err := build(expr, opts, nil)
 	if err != nil {
 		return nil, err
 	}
 	if qy == nil {
-		return nil, fmt.Errorf(fmt.Sprintf("undeclared variable in XPath expression: %s", expr))
+		return nil, newError(ErrUnboundVariable, nil, "undeclared variable in XPath expression: %s", expr)
 	}
-	return &Expr{s: expr, q: qy}, nil
+	return &Expr{s: expr, q: qy, opts: opts, rebuild: new(rebuiltQueries)}, nil
 }
@@ -1,6 +1,9 @@
 package xpath
 
-import "testing"
+import (
+	"strconv"
+	"testing"
+)
 
 func Test_self(t *testing.T) {
 	test_xpath_elements(t, employee_example, `//name/self::*`, 4, 9, 14)
@@ -16,25 +19,72 @@ func Test_child(t *testing.T) {
 func Test_descendant(t *testing.T) {
 	test_xpath_elements(t, employee_example, `//employee/descendant::*`, 4, 5, 6, 9, 10, 11, 14, 15, 16)
 	test_xpath_count(t, employee_example, `//descendant::employee`, 3)
-
+	// The descendants of the nodes a predicate rejects are not skipped.
+	doc := parseXML(`<r><d id="x"><a/><b/></d></r>`)
+	test_xpath_count(t, doc, `descendant::*[@id]/descendant::*`, 2)
+	// A node of no descendants is not one of its descendants.
+	test_xpath_count(t, doc, `//a/descendant::*/descendant-or-self::*`, 0)
 }
 
 func Test_descendant_or_self(t *testing.T) {
 	test_xpath_tags(t, employee_example.FirstChild, `self::*`, "empinfo")
 	test_xpath_elements(t, employee_example, `//employee/descendant-or-self::*`, 3, 4, 5, 6, 8, 9, 10, 11, 13, 14, 15, 16)
 	test_xpath_count(t, employee_example, `//descendant-or-self::employee`, 3)
+	test_xpath_count(t, book_example, `/descendant-or-self::bookstore/book`, 4)
+	test_xpath_count(t, book_example, `/descendant-or-self::book/bookstore`, 0)
+	test_xpath_count(t, book_example, `/descendant-or-self::text()/bookstore`, 0)
+}
+
+func Test_descendant_or_self_child(t *testing.T) {
+	// `//x` is evaluated as descendant::x when its predicates do not
+	// depend on the position.
+	for _, expr := range []string{`//author`, `//author[. = "Per Bothner"]`, `/bookstore//author[@x or true()]`} {
+		q := MustCompile(expr).q
+		if f, ok := q.(*filterQuery); ok {
+			q = f.Input
+		}
+		if _, ok := q.(*descendantQuery); !ok {
+			t.Errorf("%s: expected a descendant scan, got %T", expr, q)
+		}
+	}
+	test_xpath_count(t, book_example, `//author[. = "Per Bothner"]`, 1)
+	test_xpath_count(t, book_example, `//author[1]`, 4)
+	test_xpath_count(t, book_example, `//author[position() < 3]`, 5)
+	test_xpath_count(t, book_example, `//author[not(position() = 1)]`, 4)
+	test_xpath_count(t, book_example, `//author[last() = 1 or @x]`, 3)
+	test_xpath_count(t, book_example, `(//author[string-length(.) > 10])[1]`, 1)
+
+	// A positional predicate keeps the step per parent even when a later
+	// predicate does not depend on the position.
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 2; i++ {
+		a := root.createChildNode("a", ElementNode)
+		a.createChildNode("b", ElementNode)
+		a.createChildNode("b", ElementNode)
+	}
+	test_xpath_count(t, doc, `//b[position() = 1][not(@z)]`, 2)
+	test_xpath_count(t, doc, `//b[1][not(@z)]`, 2)
+	test_xpath_count(t, doc, `//b[not(@z)][not(@y)]`, 4)
 }
 
 func Test_ancestor(t *testing.T) {
 	test_xpath_tags(t, employee_example, `//employee/ancestor::*`, "empinfo")
 	test_xpath_tags(t, employee_example, `//employee/ancestor::empinfo`, "empinfo")
+	// The ancestors walked over for a node are walked over again for the
+	// next one.
+	doc := parseXML(`<r><a id="1"><b/><b/></a><a id="3"><c><b/></c></a></r>`)
+	test_xpath_count(t, doc, `//b[ancestor::a][ancestor::*[@id]]`, 3)
+	// The positions are counted from the context node.
+	test_xpath_tags(t, doc, `//b/ancestor::*[1]`, "a", "c")
+	test_xpath_tags(t, doc, `//c/b/ancestor::*[last()]`, "r")
+	test_xpath_count(t, doc, `//b[ancestor::*[2] = ../..]`, 3)
 	// Test Panic
 	//test_xpath_elements(t, employee_example, `//ancestor::name`, 4, 9, 14)
 }
 
 func Test_ancestor_or_self(t *testing.T) {
-	// Expected the value is [2, 3, 8, 13], but got [3, 2, 8, 13]
-	test_xpath_elements(t, employee_example, `//employee/ancestor-or-self::*`, 3, 2, 8, 13)
+	test_xpath_elements(t, employee_example, `//employee/ancestor-or-self::*`, 2, 3, 8, 13)
 	test_xpath_elements(t, employee_example, `//name/ancestor-or-self::employee`, 3, 8, 13)
 }
 
@@ -46,6 +96,11 @@ func Test_parent(t *testing.T) {
 func Test_attribute(t *testing.T) {
 	test_xpath_values(t, employee_example, `//attribute::id`, "1", "2", "3")
 	test_xpath_count(t, employee_example, `//attribute::*`, 9)
+	doc := parseXML(`<r><a id="1" x="2"/><a id="3"/></r>`)
+	test_xpath_values(t, doc, `//a/@*[position() = 2]`, "2")
+	test_xpath_values(t, doc, `//a/@*[last()]`, "2", "3")
+	// The attributes have no attributes.
+	test_xpath_count(t, doc, `//@*/@*`, 0)
 
 	// test failed
 	//test_xpath_tags(t, employee_example, `//attribute::*[1]`, "id", "discipline", "id", "from", "discipline", "id", "discipline")
@@ -56,6 +111,14 @@ func Test_attribute(t *testing.T) {
 
 func Test_following(t *testing.T) {
 	test_xpath_elements(t, employee_example, `//employee[@id=1]/following::*`, 8, 9, 10, 11, 13, 14, 15, 16)
+	test_xpath_elements(t, employee_example, `//employee[@id=1]/following::*[2]`, 9)
+	test_xpath_elements(t, employee_example, `//employee[@id=1]/following::*[last()]`, 16)
+
+	// An expression selects all the nodes again after a partial iteration.
+	expr := MustCompile(`//employee[@id=1]/following::*`)
+	iter := expr.Select(createNavigator(employee_example))
+	iter.MoveNext()
+	assertEqual(t, 8, len(iterateNodes(expr.Select(createNavigator(employee_example)))))
 }
 
 func Test_following_sibling(t *testing.T) {
@@ -66,13 +129,189 @@ func Test_following_sibling(t *testing.T) {
 func Test_preceding(t *testing.T) {
 	//testXPath3(t, html, "//li[last()]/preceding-sibling::*[2]", selectNode(html, "//li[position()=2]"))
 	//testXPath3(t, html, "//li/preceding::*[1]", selectNode(html, "//h1"))
-	test_xpath_elements(t, employee_example, `//employee[@id=3]/preceding::*`, 8, 9, 10, 11, 3, 4, 5, 6)
+	test_xpath_elements(t, employee_example, `//employee[@id=3]/preceding::*`, 3, 4, 5, 6, 8, 9, 10, 11)
+	test_xpath_elements(t, employee_example, `//employee[@id=3]/preceding::*[1]`, 11)
+	test_xpath_elements(t, employee_example, `//employee[@id=3]/preceding::*[last()]`, 3)
 }
 
 func Test_preceding_sibling(t *testing.T) {
-	test_xpath_elements(t, employee_example, `//employee[@id=3]/preceding-sibling::*`, 8, 3)
+	test_xpath_elements(t, employee_example, `//employee[@id=3]/preceding-sibling::*`, 3, 8)
 }
 
 func Test_namespace(t *testing.T) {
 	// TODO
 }
+
+// depthNavigator exposes the depth of an element as the virtual attribute
+// @xpath-depth.
+type depthNavigator struct {
+	*TNodeNavigator
+	virtual bool
+}
+
+func (n *depthNavigator) MoveToVirtualAttribute(name string) bool {
+	if name != "xpath-depth" || n.attr != -1 || n.curr.Type != ElementNode {
+		return false
+	}
+	n.virtual = true
+	return true
+}
+
+func (n *depthNavigator) NodeType() NodeType {
+	if n.virtual {
+		return AttributeNode
+	}
+	return n.TNodeNavigator.NodeType()
+}
+
+func (n *depthNavigator) LocalName() string {
+	if n.virtual {
+		return "xpath-depth"
+	}
+	return n.TNodeNavigator.LocalName()
+}
+
+func (n *depthNavigator) Prefix() string {
+	if n.virtual {
+		return ""
+	}
+	return n.TNodeNavigator.Prefix()
+}
+
+func (n *depthNavigator) Value() string {
+	if n.virtual {
+		depth := 0
+		for p := n.curr.Parent; p != nil; p = p.Parent {
+			depth++
+		}
+		return strconv.Itoa(depth)
+	}
+	return n.TNodeNavigator.Value()
+}
+
+func (n *depthNavigator) Copy() NodeNavigator {
+	return &depthNavigator{TNodeNavigator: n.TNodeNavigator.Copy().(*TNodeNavigator), virtual: n.virtual}
+}
+
+func (n *depthNavigator) MoveToParent() bool {
+	if n.virtual {
+		n.virtual = false
+		return true
+	}
+	return n.TNodeNavigator.MoveToParent()
+}
+
+func (n *depthNavigator) MoveToRoot() {
+	n.virtual = false
+	n.TNodeNavigator.MoveToRoot()
+}
+
+func (n *depthNavigator) MoveToNextAttribute() bool {
+	return !n.virtual && n.TNodeNavigator.MoveToNextAttribute()
+}
+
+func (n *depthNavigator) MoveToChild() bool {
+	return !n.virtual && n.TNodeNavigator.MoveToChild()
+}
+
+func (n *depthNavigator) MoveToFirst() bool {
+	return !n.virtual && n.TNodeNavigator.MoveToFirst()
+}
+
+func (n *depthNavigator) MoveToNext() bool {
+	return !n.virtual && n.TNodeNavigator.MoveToNext()
+}
+
+func (n *depthNavigator) MoveToPrevious() bool {
+	return !n.virtual && n.TNodeNavigator.MoveToPrevious()
+}
+
+func (n *depthNavigator) MoveTo(other NodeNavigator) bool {
+	node, ok := other.(*depthNavigator)
+	if !ok || !n.TNodeNavigator.MoveTo(node.TNodeNavigator) {
+		return false
+	}
+	n.virtual = node.virtual
+	return true
+}
+
+func Test_virtual_attribute(t *testing.T) {
+	nav := func() NodeNavigator {
+		return &depthNavigator{TNodeNavigator: createNavigator(employee_example)}
+	}
+	var depths []string
+	for iter := MustCompile(`//name/@xpath-depth`).Select(nav()); iter.MoveNext(); {
+		depths = append(depths, iter.Current().Value())
+	}
+	assertEqual(t, []string{"3", "3", "3"}, depths)
+
+	assertEqual(t, float64(3), MustCompile(`count(//*[@xpath-depth=2])`).Evaluate(nav()))
+	assertEqual(t, "3", MustCompile(`string(//name[1]/@xpath-depth)`).Evaluate(nav()))
+	// Real attributes win and wildcards don't include virtual ones.
+	assertEqual(t, float64(9), MustCompile(`count(//@*)`).Evaluate(nav()))
+	assertEqual(t, float64(3), MustCompile(`count(//@id)`).Evaluate(nav()))
+	assertEqual(t, float64(0), MustCompile(`count(//@missing)`).Evaluate(nav()))
+}
+
+// createDeepDocument returns a document nesting depth d elements, each
+// after a sibling s element, around a single leaf element.
+func createDeepDocument(depth int) *TNode {
+	doc := createNode("", RootNode)
+	n := doc
+	for i := 0; i < depth; i++ {
+		n.createChildNode("s", ElementNode)
+		n = n.createChildNode("d", ElementNode)
+	}
+	n.createChildNode("leaf", ElementNode)
+	return doc
+}
+
+func Test_reverse_axes_deep(t *testing.T) {
+	doc := createDeepDocument(100)
+	test_xpath_count(t, doc, `//leaf/ancestor::d`, 100)
+	test_xpath_count(t, doc, `//leaf/ancestor-or-self::*`, 101)
+	test_xpath_count(t, doc, `//d/ancestor::d`, 99)
+	test_xpath_count(t, doc, `//s/ancestor::*`, 99)
+	test_xpath_count(t, doc, `//leaf/preceding::s`, 100)
+	test_xpath_count(t, doc, `//d/preceding-sibling::s`, 100)
+}
+
+func BenchmarkReverseAxes(b *testing.B) {
+	doc := createDeepDocument(10000)
+	for _, expr := range []string{
+		`//leaf/ancestor::d`,
+		`//leaf/ancestor-or-self::*`,
+		`//leaf/preceding::s`,
+		`//d/preceding-sibling::s`,
+	} {
+		exp := MustCompile(expr)
+		b.Run(expr, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for iter := exp.Select(createNavigator(doc)); iter.MoveNext(); {
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParentAxis(b *testing.B) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 1000; i++ {
+		n := root.createChildNode("section", ElementNode)
+		for j := 0; j < 10; j++ {
+			n.createChildNode("x", ElementNode)
+			n.createChildNode("y", ElementNode).createChildNode("x", ElementNode)
+		}
+	}
+	for _, expr := range []string{`//x/..`, `//x/parent::section`, `//y/x/..`} {
+		b.Run(expr, func(b *testing.B) {
+			b.ReportAllocs()
+			exp := MustCompile(expr)
+			for i := 0; i < b.N; i++ {
+				for iter := exp.Select(createNavigator(doc)); iter.MoveNext(); {
+				}
+			}
+		})
+	}
+}
@@ -1,31 +1,20 @@
 package xpath
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
 )
 
 func Test_descendant_issue(t *testing.T) {
 	// Issue #93 https://github.com/antchfx/xpath/issues/93
-	/*
-	   <div id="wrapper">
-	     <span>span one</span>
-	     <div>
-	       <span>span two</span>
-	     </div>
-	   </div>
-	*/
-	doc := createNode("", RootNode)
-	div := doc.createChildNode("div", ElementNode)
-	div.lines = 1
-	div.addAttribute("id", "wrapper")
-	span := div.createChildNode("span", ElementNode)
-	span.lines = 2
-	span.createChildNode("span one", TextNode)
-	div = div.createChildNode("div", ElementNode)
-	div.lines = 3
-	span = div.createChildNode("span", ElementNode)
-	span.lines = 4
-	span.createChildNode("span two", TextNode)
+	doc := parseXML(`<div id="wrapper">
+  <span>span one</span>
+  <div>
+    <span>span two</span>
+  </div>
+</div>`)
 
 	test_xpath_elements(t, doc, `//div[@id='wrapper']/descendant::span[1]`, 2)
 	test_xpath_elements(t, doc, `//div[@id='wrapper']//descendant::span[1]`, 2, 4)
@@ -69,7 +58,23 @@ func TestAttributes(t *testing.T) {
 
 func TestExpressions(t *testing.T) {
 	test_xpath_elements(t, book_example, `//book[@category = "cooking"] | //book[@category = "children"]`, 3, 9)
+	// Unions are in document order whatever the order of the operands.
+	test_xpath_elements(t, book_example, `//title | //book`, 3, 4, 9, 10, 15, 16, 25, 26)
+	test_xpath_elements(t, book_example, `(//book)[4] | (//book)[1] | //book[title="XQuery Kick Start"]`, 3, 15, 25)
+	test_xpath_elements(t, book_example, `//book[1] | //book/..`, 2, 3)
+	// Without DocumentOrderNavigator.
+	nav := FilterNavigator(createNavigator(book_example), func(NodeNavigator) bool { return true })
+	var lines []int
+	for iter := MustCompile(`//title | //book | (//book)[4] | //@lang`).Select(nav); iter.MoveNext(); {
+		lines = append(lines, iter.Current().(interface{ Unwrap() NodeNavigator }).Unwrap().(*TNodeNavigator).curr.lines)
+	}
+	assertEqual(t, []int{3, 4, 4, 9, 10, 10, 15, 16, 16, 25, 26, 26}, lines)
 	test_xpath_elements(t, book_example, `//book[@category = "web"] and //book[price = "39.95"]`, 25)
+	// The node values are the left operand, the string the right one.
+	test_xpath_elements(t, book_example, `//book[@category < "d"]`, 3, 9)
+	// Each minus converts its operand to a number.
+	test_xpath_eval(t, empty_example, `--"3"`, float64(3))
+	test_xpath_eval(t, empty_example, `string(---"a")`, "NaN")
 	test_xpath_count(t, html_example, `//ul/*`, 3)
 	test_xpath_count(t, html_example, `//ul/*/a`, 3)
 	// Sequence
@@ -231,3 +236,517 @@ func TestSubstringZeroIndex(t *testing.T) {
 	// substring("abc", 0.4, 3.7) -> round(0.4)=0->1, round(3.7)=4. Start=1, Length=4 -> "abc"
 	test_xpath_values(t, doc2, `substring(., 0.4, 3.7)`, "abc")
 }
+
+func BenchmarkUnion(b *testing.B) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 1000; i++ {
+		n := root.createChildNode("section", ElementNode)
+		for j := 0; j < 50; j++ {
+			n.createChildNode("a", ElementNode)
+			n.createChildNode("b", ElementNode)
+		}
+	}
+	navs := []struct {
+		name string
+		nav  func() NodeNavigator
+	}{
+		{"DocumentOrderNavigator", func() NodeNavigator { return createNavigator(doc) }},
+		{"NodeNavigator", func() NodeNavigator { return &orderlessNavigator{createNavigator(doc)} }},
+	}
+	for _, nav := range navs {
+		for _, expr := range []string{`//a | //b`, `//b | //a`, `//a | //a`} {
+			b.Run(nav.name+"/"+expr, func(b *testing.B) {
+				b.ReportAllocs()
+				exp := MustCompile(expr)
+				for i := 0; i < b.N; i++ {
+					for iter := exp.Select(nav.nav()); iter.MoveNext(); {
+					}
+				}
+			})
+		}
+	}
+}
+
+// orderlessNavigator hides the DocumentOrderNavigator implementation of
+// TNodeNavigator.
+type orderlessNavigator struct {
+	*TNodeNavigator
+}
+
+func (n *orderlessNavigator) ComparePosition(NodeNavigator) (int, bool) {
+	return 0, false
+}
+
+func (n *orderlessNavigator) Copy() NodeNavigator {
+	return &orderlessNavigator{n.TNodeNavigator.Copy().(*TNodeNavigator)}
+}
+
+func (n *orderlessNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*orderlessNavigator); ok {
+		return n.TNodeNavigator.MoveTo(o.TNodeNavigator)
+	}
+	return false
+}
+
+func TestEvalLimits(t *testing.T) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 100; i++ {
+		root.createChildNode("x", ElementNode).createChildNode("0123456789", TextNode)
+	}
+	nav := createNavigator(doc)
+	for _, tc := range []struct {
+		expr  string
+		opts  EvalOptions
+		limit string
+	}{
+		{`count(//x | //text())`, EvalOptions{MaxBufferedNodes: 150}, "MaxBufferedNodes"},
+		{`count(reverse(//x))`, EvalOptions{MaxBufferedNodes: 99}, "MaxBufferedNodes"},
+		{`count(//x[last()-100000])`, EvalOptions{MaxBufferedNodes: 50}, "MaxBufferedNodes"},
+		{`string-length(string-join(//x, ""))`, EvalOptions{MaxStringBytes: 999}, "MaxStringBytes"},
+		{`count(//x[. = "x"])`, EvalOptions{MaxStringBytes: 500}, "MaxStringBytes"},
+		{`count(//x[concat(., .) = "x"])`, EvalOptions{MaxStringBytes: 2500}, "MaxStringBytes"},
+	} {
+		v, err := MustCompile(tc.expr).EvaluateWithOptions(nav, tc.opts)
+		var limitErr *ResourceLimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: expected a resource limit error, got %v, %v", tc.expr, v, err)
+			continue
+		}
+		assertTrue(t, errors.Is(err, ErrResourceLimit))
+		assertEqual(t, tc.limit, limitErr.Limit)
+		assertNil(t, v)
+
+		// Within the limits the result is the same as without.
+		tc.opts.MaxBufferedNodes *= 10
+		tc.opts.MaxStringBytes *= 10
+		v, err = MustCompile(tc.expr).EvaluateWithOptions(nav, tc.opts)
+		assertNoErr(t, err)
+		assertEqual(t, MustCompile(tc.expr).Evaluate(nav), v)
+	}
+
+	// Node-sets stop at the limit, with the nodes returned so far.
+	iter := MustCompile(`//x | //y`).SelectWithOptions(nav, EvalOptions{MaxBufferedNodes: 50})
+	assertFalse(t, iter.MoveNext())
+	assertTrue(t, errors.Is(iter.Err(), ErrResourceLimit))
+	assertFalse(t, iter.MoveNext())
+
+	v, err := MustCompile(`/r/x/text()[reverse(/r/x)]`).EvaluateWithOptions(nav, EvalOptions{MaxBufferedNodes: 1000})
+	assertNoErr(t, err)
+	iter = v.(*NodeIterator)
+	n := 0
+	for iter.MoveNext() {
+		n++
+	}
+	assertEqual(t, 10, n)
+	assertTrue(t, errors.Is(iter.Err(), ErrResourceLimit))
+
+	iter = MustCompile(`//x | //y`).SelectWithOptions(nav, EvalOptions{})
+	n = 0
+	for iter.MoveNext() {
+		n++
+	}
+	assertEqual(t, 100, n)
+	assertNil(t, iter.Err())
+}
+
+func TestRecoverPanics(t *testing.T) {
+	nav := createNavigator(book_example)
+	opts := EvalOptions{RecoverPanics: true}
+	v, err := MustCompile(`//book[sum(concat(title, 'x'))]`).EvaluateWithOptions(nav, opts)
+	assertNoErr(t, err)
+	iter := v.(*NodeIterator)
+	assertFalse(t, iter.MoveNext())
+	var evalErr *EvalError
+	assertTrue(t, errors.As(iter.Err(), &evalErr))
+	assertEqual(t, "sum()", evalErr.Op)
+	assertTrue(t, errors.Is(iter.Err(), ErrTypeMismatch))
+	assertEqual(t, "xpath: evaluating sum(concat(title, 'x')) at offset 7 of //book[sum(concat(title, 'x'))]: sum() function argument type must be a node-set or number", iter.Err().Error())
+
+	v, err = MustCompile(`sum(//price) + sum( 'a' ) `).EvaluateWithOptions(nav, opts)
+	assertNil(t, v)
+	assertTrue(t, errors.As(err, &evalErr))
+	assertEqual(t, "sum()", evalErr.Op)
+	assertEqual(t, 15, evalErr.Offset)
+	assertEqual(t, 25, evalErr.End)
+	assertEqual(t, "sum( 'a' )", evalErr.Source())
+	_, err = MustCompile(`1 + (2 * sum(string(title)))`).EvaluateWithOptions(nav, opts)
+	assertTrue(t, errors.As(err, &evalErr))
+	assertEqual(t, "sum(string(title))", evalErr.Source())
+
+	// The results and the limits are those of an evaluation without it.
+	v, err = MustCompile(`count(//book[price > 35]/title)`).EvaluateWithOptions(nav, opts)
+	assertNoErr(t, err)
+	assertEqual(t, float64(2), v)
+	test_xpath_elements(t, book_example, `//book[position() = last()]/title`, 26)
+	iter = MustCompile(`//book[position() = last()]/title`).SelectWithOptions(nav, opts)
+	assertTrue(t, iter.MoveNext())
+	assertEqual(t, "Learning XML", iter.Current().Value())
+	assertFalse(t, iter.MoveNext())
+	assertNil(t, iter.Err())
+	_, err = MustCompile(`count(//* | //text())`).EvaluateWithOptions(nav, EvalOptions{MaxBufferedNodes: 10, RecoverPanics: true})
+	assertTrue(t, errors.Is(err, ErrResourceLimit))
+
+	// Without it, the evaluation panics.
+	assertPanic(t, func() {
+		MustCompile(`count(//book[position() = sum('a')])`).EvaluateWithOptions(nav, EvalOptions{})
+	})
+}
+
+func TestEvalStats(t *testing.T) {
+	nav := createNavigator(book_example)
+	var stats Stats
+	v, err := MustCompile(`count(//book[contains(title, 'X')] | //title)`).EvaluateWithOptions(nav, EvalOptions{Stats: &stats})
+	assertNoErr(t, err)
+	assertEqual(t, float64(6), v)
+	assertTrue(t, stats.NodesVisited > 40)
+	assertTrue(t, stats.Copies > 0)
+	// count() and contains() for each of the 4 books.
+	assertEqual(t, 5, stats.FunctionCalls)
+	assertEqual(t, 6, stats.BufferedNodes)
+	assertTrue(t, stats.Duration > 0)
+
+	// The counters add up, and include the iteration of the result.
+	visited := stats.NodesVisited
+	iter := MustCompile(`//book/title`).SelectWithOptions(nav, EvalOptions{Stats: &stats})
+	var titles []string
+	for iter.MoveNext() {
+		titles = append(titles, iter.Current().Value())
+		_, ok := iter.Current().(interface{ Unwrap() NodeNavigator }).Unwrap().(*TNodeNavigator)
+		assertTrue(t, ok)
+	}
+	assertEqual(t, 4, len(titles))
+	assertTrue(t, stats.NodesVisited > visited)
+	assertEqual(t, 5, stats.FunctionCalls)
+}
+
+// cancelingContext is canceled once its Err method was called checks
+// times.
+type cancelingContext struct {
+	context.Context
+	checks int
+}
+
+func (c *cancelingContext) Err() error {
+	if c.checks == 0 {
+		return context.Canceled
+	}
+	c.checks--
+	return nil
+}
+
+func TestEvalContext(t *testing.T) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 1000; i++ {
+		root.createChildNode("x", ElementNode)
+	}
+	nav := createNavigator(doc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	v, err := MustCompile(`count(//x)`).EvaluateWithOptions(nav, EvalOptions{Context: ctx})
+	assertNil(t, v)
+	var canceled *CanceledError
+	assertTrue(t, errors.As(err, &canceled))
+	assertTrue(t, errors.Is(err, context.Canceled))
+	assertFalse(t, errors.Is(err, context.DeadlineExceeded))
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = MustCompile(`count(//x)`).EvaluateWithOptions(nav, EvalOptions{Context: ctx})
+	var timeout *TimeoutError
+	assertTrue(t, errors.As(err, &timeout))
+	assertTrue(t, errors.Is(err, context.DeadlineExceeded))
+
+	// The iteration stops with the nodes returned so far.
+	ctx, cancel = context.WithCancel(context.Background())
+	iter := MustCompile(`//x`).SelectWithOptions(nav, EvalOptions{Context: ctx})
+	for i := 0; i < 3; i++ {
+		assertTrue(t, iter.MoveNext())
+	}
+	cancel()
+	assertFalse(t, iter.MoveNext())
+	assertTrue(t, errors.As(iter.Err(), &canceled))
+	assertEqual(t, 3, canceled.Progress.Results)
+	assertTrue(t, canceled.Progress.NodesVisited > 3)
+	assertEqual(t, "xpath: evaluation canceled after "+canceled.Progress.Elapsed.String()+", 3 nodes returned", canceled.Error())
+
+	// The context is checked as the navigators move.
+	_, err = MustCompile(`count(//x)`).EvaluateWithOptions(nav, EvalOptions{Context: &cancelingContext{Context: context.Background(), checks: 1}})
+	assertTrue(t, errors.As(err, &canceled))
+	assertEqual(t, 0, canceled.Progress.Results)
+	assertEqual(t, contextInterval, canceled.Progress.NodesVisited)
+
+	// The limits report their progress too.
+	v, err = MustCompile(`/r/x[reverse(/r/x)]`).EvaluateWithOptions(nav, EvalOptions{MaxBufferedNodes: 2500})
+	assertNoErr(t, err)
+	iter = v.(*NodeIterator)
+	for iter.MoveNext() {
+	}
+	var limitErr *ResourceLimitError
+	assertTrue(t, errors.As(iter.Err(), &limitErr))
+	assertEqual(t, 2, limitErr.Progress.Results)
+	assertTrue(t, limitErr.Progress.BufferedNodes > 2500)
+	assertEqual(t, 0, limitErr.Progress.NodesVisited)
+}
+
+func TestPartialResults(t *testing.T) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 1000; i++ {
+		root.createChildNode("x", ElementNode)
+	}
+	nav := createNavigator(doc)
+	paths := func(iter *NodeIterator) []string {
+		var s []string
+		for iter.MoveNext() {
+			s = append(s, NodePath(iter.Current()))
+		}
+		return s
+	}
+
+	// Without it, the nodes of a union are lost.
+	opts := EvalOptions{MaxBufferedNodes: 500}
+	iter := MustCompile(`//x | //y`).SelectWithOptions(nav, opts)
+	assertEqual(t, 0, len(paths(iter)))
+	assertTrue(t, errors.Is(iter.Err(), ErrResourceLimit))
+
+	opts.PartialResults = true
+	iter = MustCompile(`//x | //y`).SelectWithOptions(nav, opts)
+	got := paths(iter)
+	assertEqual(t, 500, len(got))
+	assertEqual(t, "/r[1]/x[1]", got[0])
+	var limitErr *ResourceLimitError
+	assertTrue(t, errors.As(iter.Err(), &limitErr))
+	assertEqual(t, 500, limitErr.Progress.Results)
+	assertFalse(t, iter.MoveNext())
+
+	// The nodes sorted in document order, with the context.
+	v, err := MustCompile(`//x/following-sibling::*[1]`).EvaluateWithOptions(nav, EvalOptions{
+		Context:        &cancelingContext{Context: context.Background(), checks: 2},
+		PartialResults: true,
+	})
+	assertNoErr(t, err)
+	got = paths(v.(*NodeIterator))
+	assertTrue(t, len(got) > 0 && len(got) < 999)
+	assertEqual(t, "/r[1]/x[2]", got[0])
+	var canceled *CanceledError
+	assertTrue(t, errors.As(v.(*NodeIterator).Err(), &canceled))
+	assertEqual(t, len(got), canceled.Progress.Results)
+
+	// The nodes of the subexpressions are not the result.
+	_, err = MustCompile(`count(//x | //y)`).EvaluateWithOptions(nav, opts)
+	assertTrue(t, errors.Is(err, ErrResourceLimit))
+	iter = MustCompile(`//y | //r[count(//x | //y) = 500]`).SelectWithOptions(nav, opts)
+	assertEqual(t, 0, len(paths(iter)))
+	assertTrue(t, errors.Is(iter.Err(), ErrResourceLimit))
+}
+
+func TestCompileReusesParseTree(t *testing.T) {
+	// The nodes of the parse tree are reused by the next compilation,
+	// which must not change the expressions compiled before.
+	exprs := []struct {
+		expr string
+		want interface{}
+	}{
+		{`count(//book[@category = "web"]/title)`, float64(2)},
+		{`count(//book[contains(title, "XML")][1]/author)`, float64(1)},
+		{`sum(//book[year = 2005]/price) > 50`, true},
+		{`count(/bookstore/book[last()]/preceding-sibling::*)`, float64(3)},
+	}
+	compiled := make([]*Expr, len(exprs))
+	for i, tc := range exprs {
+		compiled[i] = MustCompile(tc.expr)
+	}
+	done := make(chan bool)
+	for g := 0; g < 4; g++ {
+		go func() {
+			for i := 0; i < 100; i++ {
+				MustCompile(`//a[@b = 'c' and d/e[f()] or g(h, i) - 1 > 2]/descendant::j | //k`)
+			}
+			done <- true
+		}()
+	}
+	for g := 0; g < 4; g++ {
+		<-done
+	}
+	for i, tc := range exprs {
+		assertEqual(t, tc.want, compiled[i].Evaluate(createNavigator(book_example)))
+	}
+}
+
+func TestDocumentOrder(t *testing.T) {
+	// <r><a x="1" y="1"><b/><a x="2"><b/></a><b/></a><b/></r>
+	doc := createNode("", RootNode)
+	r := doc.createChildNode("r", ElementNode)
+	r.lines = 1
+	a1 := r.createChildNode("a", ElementNode)
+	a1.lines = 2
+	a1.createChildNode("b", ElementNode).lines = 3
+	a1.addAttribute("x", "1")
+	a1.addAttribute("y", "1")
+	a2 := a1.createChildNode("a", ElementNode)
+	a2.lines = 4
+	a2.addAttribute("x", "2")
+	a2.createChildNode("b", ElementNode).lines = 5
+	a1.createChildNode("b", ElementNode).lines = 6
+	r.createChildNode("b", ElementNode).lines = 7
+
+	for _, tc := range []struct {
+		expr string
+		want []int
+	}{
+		{`//a/b`, []int{3, 5, 6}},
+		{`//a[b]/b`, []int{3, 5, 6}},
+		{`//b/..`, []int{1, 2, 4}},
+		{`//a/b/..`, []int{2, 4}},
+		{`//b/parent::a`, []int{2, 4}},
+		{`//b/..[1]`, []int{1, 2, 4}},
+		{`/r/a/../b/..`, []int{1}},
+		{`//@*/..`, []int{2, 4}},
+		{`//b/ancestor::*`, []int{1, 2, 4}},
+		{`//b/preceding-sibling::*`, []int{2, 3, 4}},
+		{`//a//b`, []int{3, 5, 6}},
+		{`reverse(//a/b)`, []int{6, 5, 3}},
+	} {
+		test_xpath_elements(t, doc, tc.expr, tc.want...)
+		for iter := MustCompile(tc.expr).Select(&orderlessNavigator{createNavigator(doc)}); iter.MoveNext(); {
+			assertEqual(t, tc.want[0], iter.Current().(*orderlessNavigator).curr.lines)
+			tc.want = tc.want[1:]
+		}
+		assertEqual(t, 0, len(tc.want))
+	}
+	test_xpath_eval(t, doc, `count(//b/..)`, float64(3))
+	test_xpath_eval(t, doc, `string(//b/ancestor::*)`, "")
+	// The children of nested nodes are not collected to be sorted, nor
+	// the parents of the nodes of the child and descendant axes.
+	assertTrue(t, MustCompile(`//a/b`).Plan().Streamable)
+	assertTrue(t, MustCompile(`//b/..`).Plan().Streamable)
+	assertTrue(t, MustCompile(`//a/b/..`).Plan().Streamable)
+}
+
+func TestVerifyOrder(t *testing.T) {
+	opts := EvalOptions{VerifyOrder: true}
+	iter := MustCompile(`//book/title`).SelectWithOptions(createNavigator(book_example), opts)
+	n := 0
+	for iter.MoveNext() {
+		n++
+	}
+	assertNoErr(t, iter.Err())
+	assertEqual(t, 4, n)
+
+	// A navigator comparing the nodes the other way round.
+	iter = MustCompile(`//book/title`).SelectWithOptions(&misorderedNavigator{createNavigator(book_example), -1}, opts)
+	assertFalse(t, iter.MoveNext())
+	var orderErr *OrderError
+	assertTrue(t, errors.As(iter.Err(), &orderErr))
+	assertTrue(t, errors.Is(iter.Err(), ErrDocumentOrder))
+	assertEqual(t, "descendant::book", orderErr.Op)
+	assertFalse(t, orderErr.Duplicate)
+	assertEqual(t, "xpath: descendant::book in //book/title selected a node before the previous one", orderErr.Error())
+
+	// One taking all the nodes for the same.
+	v, err := MustCompile(`//title[. != '']`).EvaluateWithOptions(&misorderedNavigator{createNavigator(book_example), 0}, opts)
+	assertNoErr(t, err)
+	iter = v.(*NodeIterator)
+	assertTrue(t, iter.MoveNext())
+	assertFalse(t, iter.MoveNext())
+	assertTrue(t, errors.As(iter.Err(), &orderErr))
+	assertTrue(t, orderErr.Duplicate)
+	iter = MustCompile(`//title[. != '']`).SelectWithOptions(&misorderedNavigator{createNavigator(book_example), 0}, EvalOptions{VerifyOrder: true, RecoverPanics: true})
+	for iter.MoveNext() {
+	}
+	assertTrue(t, errors.As(iter.Err(), &orderErr))
+
+	// Without it, the order is not checked.
+	iter = MustCompile(`//title[. != '']`).Select(&misorderedNavigator{createNavigator(book_example), 0})
+	for n = 0; iter.MoveNext(); n++ {
+	}
+	assertEqual(t, 4, n)
+}
+
+func TestVerifyComparisons(t *testing.T) {
+	opts := EvalOptions{VerifyComparisons: true}
+	for _, expr := range []string{`//title | //price`, `//book/ancestor::*`, `//*/@lang/..`, `//book[last()]/preceding::*`} {
+		var want []string
+		for iter := MustCompile(expr).Select(createNavigator(book_example)); iter.MoveNext(); {
+			want = append(want, NodePath(iter.Current()))
+		}
+		var got []string
+		iter := MustCompile(expr).SelectWithOptions(createNavigator(book_example), opts)
+		for iter.MoveNext() {
+			got = append(got, NodePath(iter.Current()))
+		}
+		assertNoErr(t, iter.Err())
+		assertEqual(t, "", DiffPaths(want, got).String())
+	}
+
+	// A navigator comparing its nodes backwards.
+	iter := MustCompile(`//title | //price`).SelectWithOptions(&misorderedNavigator{createNavigator(book_example), -1}, opts)
+	assertFalse(t, iter.MoveNext())
+	var cmpErr *ComparisonError
+	assertTrue(t, errors.As(iter.Err(), &cmpErr))
+	assertTrue(t, errors.Is(iter.Err(), ErrDocumentOrder))
+	assertEqual(t, "ComparePosition returns -1, their positions 1", cmpErr.Msg)
+	assertEqual(t, `xpath: comparing /bookstore[1]/book[4]/title[1] with /bookstore[1]/book[3]/title[1] in //title | //price: ComparePosition returns -1, their positions 1`, cmpErr.Error())
+
+	// One whose MoveToPrevious does not move, so that the positions are
+	// wrong.
+	_, err := MustCompile(`count(//title | //price)`).EvaluateWithOptions(&noPreviousNavigator{createNavigator(book_example)}, opts)
+	assertTrue(t, errors.As(err, &cmpErr))
+	assertEqual(t, "walking down from the root to its position /1/1/1 reaches another node", cmpErr.Msg)
+	// Without it, the nodes are silently taken for the same one.
+	v, err := MustCompile(`count(//title | //price)`).EvaluateWithOptions(&noPreviousNavigator{createNavigator(book_example)}, EvalOptions{})
+	assertNoErr(t, err)
+	assertEqual(t, float64(1), v)
+}
+
+// noPreviousNavigator is a TNodeNavigator whose MoveToPrevious does not
+// move.
+type noPreviousNavigator struct {
+	*TNodeNavigator
+}
+
+func (n *noPreviousNavigator) MoveToPrevious() bool {
+	return false
+}
+
+func (n *noPreviousNavigator) Copy() NodeNavigator {
+	return &noPreviousNavigator{n.TNodeNavigator.Copy().(*TNodeNavigator)}
+}
+
+func (n *noPreviousNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*noPreviousNavigator); ok {
+		return n.TNodeNavigator.MoveTo(o.TNodeNavigator)
+	}
+	return false
+}
+
+// misorderedNavigator is a TNodeNavigator whose ComparePosition returns
+// the order of TNodeNavigator multiplied by sign.
+type misorderedNavigator struct {
+	*TNodeNavigator
+	sign int
+}
+
+func (n *misorderedNavigator) ComparePosition(other NodeNavigator) (int, bool) {
+	o, ok := other.(*misorderedNavigator)
+	if !ok {
+		return 0, false
+	}
+	c, ok := n.TNodeNavigator.ComparePosition(o.TNodeNavigator)
+	return c * n.sign, ok
+}
+
+func (n *misorderedNavigator) Copy() NodeNavigator {
+	return &misorderedNavigator{n.TNodeNavigator.Copy().(*TNodeNavigator), n.sign}
+}
+
+func (n *misorderedNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*misorderedNavigator); ok {
+		return n.TNodeNavigator.MoveTo(o.TNodeNavigator)
+	}
+	return false
+}
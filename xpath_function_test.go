@@ -54,12 +54,19 @@ func Test_func_contains(t *testing.T) {
 	//test_xpath_eval(t, empty_example, `contains("", ())`, true)
 	test_xpath_elements(t, book_example, `//book[contains(title, "Potter")]`, 9)
 	test_xpath_elements(t, book_example, `//book[contains(year, "2005")]`, 3, 9)
-	assertPanic(t, func() { selectNode(html_example, "//*[contains(0, 0)]") })
+	// The arguments are converted to strings.
+	test_xpath_eval(t, empty_example, `contains(10, 0)`, true)
+	test_xpath_eval(t, empty_example, `contains(//none, "")`, true)
+	test_xpath_eval(t, empty_example, `contains(true(), 'ru')`, true)
 }
 
 func Test_func_count(t *testing.T) {
 	test_xpath_eval(t, book_example, `count(//book)`, float64(4))
 	test_xpath_eval(t, book_example, `count(//book[3]/author)`, float64(5))
+	test_xpath_eval(t, book_example, `count(//book | //title)`, float64(8))
+	test_xpath_eval(t, book_example, `count(//book | //book)`, float64(4))
+	test_xpath_eval(t, book_example, `count((//book)[price > 35])`, float64(2))
+	test_xpath_eval(t, book_example, `count(//book/author)`, float64(8))
 }
 
 func Test_func_ends_with(t *testing.T) {
@@ -67,8 +74,9 @@ func Test_func_ends_with(t *testing.T) {
 	test_xpath_eval(t, empty_example, `ends-with("tattoo", "atto")`, false)
 	test_xpath_elements(t, book_example, `//book[ends-with(@category,'ing')]`, 3)
 	test_xpath_elements(t, book_example, `//book[ends-with(./price,'.99')]`, 9, 15)
-	assertPanic(t, func() { selectNode(html_example, `//*[ends-with(0, 0)]`) }) // arg must be start with string
-	assertPanic(t, func() { selectNode(html_example, `//*[ends-with(name(), 0)]`) })
+	test_xpath_eval(t, empty_example, `ends-with(10, 0)`, true)
+	test_xpath_eval(t, empty_example, `ends-with('a', 0)`, false)
+	test_xpath_eval(t, empty_example, `ends-with(//none, "")`, true)
 }
 
 func Test_func_last(t *testing.T) {
@@ -80,6 +88,29 @@ func Test_func_last(t *testing.T) {
 	test_xpath_elements(t, book_example, `//bookstore/book[year = 2005][last()]`, 9)
 	test_xpath_elements(t, html_example, `//ul/li[last()]`, 15)
 	test_xpath_elements(t, html_example, `(//ul/li)[last()]`, 15)
+	test_xpath_elements(t, book_example, `//book[last() - 1]`, 15)
+	test_xpath_elements(t, book_example, `//book[position() = last() - 2]`, 9)
+	test_xpath_elements(t, book_example, `//book[position() > last() - 2]`, 15, 25)
+	test_xpath_elements(t, book_example, `//book[last() - 1 <= position()]`, 15, 25)
+	test_xpath_elements(t, book_example, `//book[last() - 4]`)
+	test_xpath_elements(t, book_example, `//book[last()]/title`, 26)
+	test_xpath_elements(t, employee_example, `//employee/*[last()]`, 6, 11, 16)
+	test_xpath_elements(t, employee_example, `//employee/*[last()][last()]`, 6, 11, 16)
+	test_xpath_elements(t, employee_example, `//employee[last()]/*[last() - 1]`, 15)
+}
+
+func Benchmark_LastFunc(b *testing.B) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("root", ElementNode)
+	for i := 0; i < 1000; i++ {
+		root.createChildNode("item", ElementNode)
+	}
+	exp := MustCompile(`/root/item[last()]`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for iter := exp.Select(createNavigator(doc)); iter.MoveNext(); {
+		}
+	}
 }
 
 func Test_func_local_name(t *testing.T) {
@@ -91,13 +122,16 @@ func Test_func_starts_with(t *testing.T) {
 	test_xpath_eval(t, employee_example, `starts-with("tattoo", "tat")`, true)
 	test_xpath_eval(t, employee_example, `starts-with("tattoo", "att")`, false)
 	test_xpath_elements(t, book_example, `//book[starts-with(title,'Everyday')]`, 3)
-	assertPanic(t, func() { selectNode(html_example, `//*[starts-with(0, 0)]`) })
-	assertPanic(t, func() { selectNode(html_example, `//*[starts-with(name(), 0)]`) })
+	test_xpath_eval(t, empty_example, `starts-with(0, 0)`, true)
+	test_xpath_eval(t, empty_example, `starts-with(12, 2)`, false)
+	// An empty node-set is the empty string.
+	test_xpath_eval(t, empty_example, `starts-with(//none, "")`, true)
 }
 
 func Test_func_string(t *testing.T) {
 	test_xpath_eval(t, empty_example, `string(1.23)`, "1.23")
 	test_xpath_eval(t, empty_example, `string(3)`, "3")
+	test_xpath_eval(t, empty_example, `string(-0)`, "0")
 	test_xpath_eval(t, book_example, `string(//book/@category)`, "cooking")
 }
 func Test_func_string_empty(t *testing.T) {
@@ -137,6 +171,8 @@ func Test_func_substring(t *testing.T) {
 	test_xpath_eval(t, empty_example, `substring("12345", 0, 5)`, "1234")
 	test_xpath_eval(t, empty_example, `substring("12345", 1, 5)`, "12345")
 	test_xpath_eval(t, empty_example, `substring("12345", 1, 6)`, "12345")
+	test_xpath_eval(t, empty_example, `substring("12345", -1, 43)`, "12345")
+	test_xpath_eval(t, empty_example, `substring("12345", -42, 1 div 0)`, "12345")
 	test_xpath_eval(t, html_example, `substring(//title/child::node(), 1)`, "My page")
 	//assertPanic(t, func() { selectNode(empty_example, `substring("12345", 5, "")`) })
 }
@@ -144,6 +180,8 @@ func Test_func_substring(t *testing.T) {
 func Test_func_substring_after(t *testing.T) {
 	test_xpath_eval(t, empty_example, `substring-after("tattoo", "tat")`, "too")
 	test_xpath_eval(t, empty_example, `substring-after("tattoo", "tattoo")`, "")
+	test_xpath_eval(t, empty_example, `substring-after("tattoo", "")`, "tattoo")
+	test_xpath_eval(t, empty_example, `substring-after("tattoo", //none)`, "tattoo")
 }
 
 func Test_func_substring_before(t *testing.T) {
@@ -164,6 +202,23 @@ func Test_func_translate(t *testing.T) {
 	test_xpath_eval(t, empty_example, `translate("--aaa--","abc-","ABC")`, "AAA")
 	test_xpath_eval(t, empty_example, `translate("abcdabc", "abc", "AB")`, "ABdAB")
 	test_xpath_eval(t, empty_example, `translate('The quick brown fox', 'brown', 'red')`, "The quick red fdx")
+	test_xpath_eval(t, empty_example, `translate("aXbXc", "XX", "-+")`, "a-b-c")
+	test_xpath_eval(t, empty_example, `translate("abc", "", "xyz")`, "abc")
+	test_xpath_eval(t, empty_example, `translate("naïve", "a", "A")`, "nAïve")
+	test_xpath_eval(t, empty_example, `translate("αβγ", "αγ", "ΑΓ")`, "ΑβΓ")
+	test_xpath_eval(t, empty_example, `translate("café", "éf", "e")`, "cae")
+	test_xpath_eval(t, empty_example, `translate("cooking", substring("xo", 2), "0")`, "c00king")
+	test_xpath_eval(t, book_example, `translate(//book[1]/title, "abcdefghijklmnopqrstuvwxyz", "ABCDEFGHIJKLMNOPQRSTUVWXYZ")`, "EVERYDAY ITALIAN")
+}
+
+func TestTranslateNormalizeAllocations(t *testing.T) {
+	upper := newTranslator("abc", "ABC")
+	if n := testing.AllocsPerRun(10, func() { upper("XYZ, done") }); n != 0 {
+		t.Errorf("translate: expected no allocations, got %v", n)
+	}
+	if n := testing.AllocsPerRun(10, func() { normalizeSpace("already normalized text") }); n != 0 {
+		t.Errorf("normalize-space: expected no allocations, got %v", n)
+	}
 }
 
 func Test_func_matches(t *testing.T) {
@@ -171,6 +226,7 @@ func Test_func_matches(t *testing.T) {
 	test_xpath_eval(t, empty_example, `matches("abracadabra", "(?i)^A.*A$")`, true)
 	test_xpath_eval(t, empty_example, `matches("abracadabra", "^a.*a$")`, true)
 	test_xpath_eval(t, empty_example, `matches("abracadabra", "^bra")`, false)
+	test_xpath_eval(t, empty_example, `matches(//none, "^$")`, true)
 	assertPanic(t, func() { selectNode(html_example, `//*[matches()]`) })                   // arg len check failure
 	assertPanic(t, func() { selectNode(html_example, "//*[matches(substring(), 0)]") })     // first arg processing failure
 	assertPanic(t, func() { selectNode(html_example, "//*[matches(@href, substring())]") }) // second arg processing failure
@@ -219,6 +275,9 @@ func Test_func_replace(t *testing.T) {
 	test_xpath_eval(t, empty_example, `replace("abcd", "(ab)|(a)", "[1=$1][2=$2]")`, "[1=ab][2=]cd")
 	test_xpath_eval(t, empty_example, `replace("1/1/c11/1", "(.*)/[^/]+$", "$1")`, "1/1/c11")
 	test_xpath_eval(t, empty_example, `replace("A/B/C/D/E/F/G/H/I/J/K/L", "([^/]*)/([^/]*)/([^/]*)/([^/]*)/([^/]*)/([^/]*)/([^/]*)/([^/]*)/([^/]*)/(.*)", "$1-$2-$3-$4-$5-$6-$7-$8-$9-$10")`, "A-B-C-D-E-F-G-H-I-J/K/L")
+	// An invalid literal pattern is reported at compile time.
+	_, err := Compile(`//*[replace(@href, "[invalid", "") = ""]`)
+	assertErr(t, err)
 }
 
 func Test_func_reverse(t *testing.T) {
@@ -230,9 +289,10 @@ func Test_func_reverse(t *testing.T) {
 }
 
 func Test_func_round(t *testing.T) {
-	test_xpath_eval(t, employee_example, `round(2.5)`, 3) // int
-	test_xpath_eval(t, employee_example, `round(2.5)`, 3)
-	test_xpath_eval(t, employee_example, `round(2.4999)`, 2)
+	test_xpath_eval(t, employee_example, `round(2.5)`, float64(3))
+	test_xpath_eval(t, employee_example, `round(-2.5)`, float64(-2))
+	test_xpath_eval(t, employee_example, `round(2.4999)`, float64(2))
+	test_xpath_eval(t, employee_example, `string(round(0 div 0))`, "NaN")
 }
 
 func Test_func_namespace_uri(t *testing.T) {
@@ -250,6 +310,10 @@ func Test_func_normalize_space(t *testing.T) {
 	test_xpath_eval(t, n, `normalize-space(.)`, "Opal Kole")
 	test_xpath_eval(t, book_example, `normalize-space(//book/title)`, "Everyday Italian")
 	test_xpath_eval(t, book_example, `normalize-space(//book[1]/title)`, "Everyday Italian")
+	test_xpath_eval(t, empty_example, `normalize-space("a b")`, "a b")
+	test_xpath_eval(t, empty_example, `normalize-space("a`+"\u00a0"+`b")`, "a b")
+	test_xpath_eval(t, empty_example, `normalize-space("a ")`, "a")
+	test_xpath_eval(t, empty_example, `normalize-space("")`, "")
 
 }
 
@@ -268,6 +332,14 @@ func Benchmark_NormalizeSpaceFunc(b *testing.B) {
 	}
 }
 
+func Benchmark_TranslateFunc(b *testing.B) {
+	b.ReportAllocs()
+	f := translateFunc(testQuery("The Quick Brown Fox"), &constantQuery{Val: "ABCDEFGHIJKLMNOPQRSTUVWXYZ"}, &constantQuery{Val: "abcdefghijklmnopqrstuvwxyz"})
+	for i := 0; i < b.N; i++ {
+		_ = f(nil, nil)
+	}
+}
+
 func Benchmark_ConcatFunc(b *testing.B) {
 	b.ReportAllocs()
 	for i := 0; i < b.N; i++ {
@@ -28,6 +28,13 @@ func TestPositions(t *testing.T) {
 	test_xpath_elements(t, employee_example, `//employee[position() = last()]`, 13)
 	test_xpath_elements(t, book_example, `//book[@category = "web"][2]`, 25)
 	test_xpath_elements(t, book_example, `(//book[@category = "web"])[2]`, 25)
+	// Number predicates are matched by position.
+	test_xpath_elements(t, book_example, `//book[3]/preceding-sibling::book[2]`, 3)
+	test_xpath_elements(t, book_example, `//book[4]/ancestor::*[1]`, 2)
+	test_xpath_elements(t, book_example, `/bookstore/book/*[1]`, 4, 10, 16, 26)
+	test_xpath_elements(t, book_example, `//book[3]/author[4]`, 20)
+	test_xpath_elements(t, book_example, `//book[0]`)
+	test_xpath_elements(t, book_example, `//book[5]`)
 }
 
 func TestPredicates(t *testing.T) {
@@ -40,6 +47,12 @@ func TestPredicates(t *testing.T) {
 	test_xpath_elements(t, book_example, `//year[text() = 2005]`, 6, 12)
 	test_xpath_elements(t, employee_example, `/empinfo/employee[1][@id=1]`, 3)
 	test_xpath_elements(t, employee_example, `/empinfo/employee[@id][2]`, 8)
+	// The right operand is evaluated from the same context node as the
+	// left one.
+	doc := parseXML(`<r><a id="1"><b>1</b><b>2</b></a><a id="3"><c><b>3</b></c></a></r>`)
+	test_xpath_values(t, doc, `//b[(preceding::b)[1] != ancestor::a/@id]`, "3")
+	test_xpath_values(t, doc, `//b[following::*[. = 3] != ../@id]`, "1", "2")
+	test_xpath_values(t, doc, `//b[(preceding::b)[1]]`, "2", "3")
 }
 
 func TestOperators(t *testing.T) {
@@ -55,3 +68,260 @@ func TestNestedPredicates(t *testing.T) {
 	test_xpath_elements(t, employee_example, `//employee[./name[@from]]`, 8)
 	test_xpath_elements(t, employee_example, `//employee[.//name[@from = "CA"]]`, 8)
 }
+
+// countingNavigator counts the sibling and child moves made through it
+// and its copies.
+type countingNavigator struct {
+	*TNodeNavigator
+	moves *int
+}
+
+func (n *countingNavigator) MoveToChild() bool {
+	*n.moves++
+	return n.TNodeNavigator.MoveToChild()
+}
+
+func (n *countingNavigator) MoveToNext() bool {
+	*n.moves++
+	return n.TNodeNavigator.MoveToNext()
+}
+
+func (n *countingNavigator) Copy() NodeNavigator {
+	return &countingNavigator{TNodeNavigator: n.TNodeNavigator.Copy().(*TNodeNavigator), moves: n.moves}
+}
+
+func (n *countingNavigator) MoveTo(other NodeNavigator) bool {
+	o, ok := other.(*countingNavigator)
+	return ok && n.TNodeNavigator.MoveTo(o.TNodeNavigator)
+}
+
+func TestPositionEarlyTermination(t *testing.T) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 1000; i++ {
+		root.createChildNode("x", ElementNode).lines = i + 1
+	}
+	for _, tc := range []struct {
+		expr string
+		want []int
+	}{
+		{`/r/x[1]`, []int{1}},
+		{`/r/x[position() <= 3]`, []int{1, 2, 3}},
+		{`/r/x[position() < 3]`, []int{1, 2}},
+		{`/r/x[2 >= position()]`, []int{1, 2}},
+		{`/r/x[position() = 2]`, []int{2}},
+		{`/r/descendant::x[1]`, []int{1}},
+		{`/r/x[1]/following-sibling::x[1]`, []int{2}},
+	} {
+		moves := 0
+		nav := &countingNavigator{TNodeNavigator: createNavigator(doc), moves: &moves}
+		var got []int
+		for iter := MustCompile(tc.expr).Select(nav); iter.MoveNext(); {
+			got = append(got, iter.Current().(*countingNavigator).curr.lines)
+		}
+		assertEqual(t, tc.want, got)
+		if moves > 20 {
+			t.Errorf("%s: expected early termination, made %d moves", tc.expr, moves)
+		}
+	}
+	// Unbounded conditions still visit every node.
+	test_xpath_count(t, doc, `/r/x[position() > 998]`, 2)
+}
+
+func TestExistenceShortCircuit(t *testing.T) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 1000; i++ {
+		root.createChildNode("x", ElementNode)
+	}
+	root.createChildNode("y", ElementNode)
+	for _, tc := range []struct {
+		expr string
+		want interface{}
+	}{
+		{`boolean(//x | //y)`, true},
+		{`not(//x | //y)`, false},
+		{`boolean((//x | //y))`, true},
+		{`count(/r[//x | //y])`, float64(1)},
+		{`//x | //y and true()`, true},
+	} {
+		moves := 0
+		nav := &countingNavigator{TNodeNavigator: createNavigator(doc), moves: &moves}
+		assertEqual(t, tc.want, MustCompile(tc.expr).Evaluate(nav))
+		if moves > 20 {
+			t.Errorf("%s: expected short-circuit, made %d moves", tc.expr, moves)
+		}
+	}
+	test_xpath_eval(t, doc, `boolean(//z | //y)`, true)
+	test_xpath_eval(t, doc, `boolean(//z | //w)`, false)
+	test_xpath_eval(t, doc, `not(//z | //w)`, true)
+}
+
+func TestChainedStepsStream(t *testing.T) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 1000; i++ {
+		x := root.createChildNode("x", ElementNode)
+		x.lines = i + 1
+		x.createChildNode("y", ElementNode).createChildNode("z", ElementNode)
+	}
+	for _, tc := range []struct {
+		expr  string
+		first int
+		count int
+	}{
+		{`/r/x[position() > 1]`, 2, 999},
+		{`/r/x[position() < 1001]/y/z/..`, 0, 1000},
+		{`/r/x[1]/y`, 0, 1},
+	} {
+		moves := 0
+		nav := &countingNavigator{TNodeNavigator: createNavigator(doc), moves: &moves}
+		iter := MustCompile(tc.expr).SelectWithOptions(nav, EvalOptions{MaxBufferedNodes: 1})
+		assertTrue(t, iter.MoveNext())
+		if tc.first > 0 {
+			assertEqual(t, tc.first, iter.Current().(*countingNavigator).curr.lines)
+		}
+		// The first node is returned before the following steps are
+		// selected from the other nodes.
+		if moves > 20 {
+			t.Errorf("%s: expected the first node to stream, made %d moves", tc.expr, moves)
+		}
+		n := 1
+		for iter.MoveNext() {
+			n++
+		}
+		assertNil(t, iter.Err())
+		assertEqual(t, tc.count, n)
+	}
+}
+
+func TestCountWithoutMaterialization(t *testing.T) {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("r", ElementNode)
+	for i := 0; i < 100; i++ {
+		root.createChildNode("x", ElementNode).createChildNode("y", ElementNode)
+	}
+	for _, tc := range []struct {
+		expr string
+		want float64
+	}{
+		{`count(//x | //y)`, 200},
+		{`count(//x | //x)`, 100},
+		{`count(/r/x/y)`, 100},
+		{`count((//x))`, 100},
+	} {
+		test_xpath_eval(t, doc, tc.expr, tc.want)
+	}
+
+	nav := FilterNavigator(createNavigator(doc), func(NodeNavigator) bool { return true })
+	count := MustCompile(`count(//x | //y)`)
+	union := MustCompile(`//x | //y`)
+	counted := testing.AllocsPerRun(10, func() { count.Evaluate(nav) })
+	selected := testing.AllocsPerRun(10, func() {
+		for iter := union.Select(nav); iter.MoveNext(); {
+		}
+	})
+	if counted >= selected {
+		t.Errorf("count() made %v allocations, iterating the node-set made %v", counted, selected)
+	}
+}
+
+func TestPredicateContextValue(t *testing.T) {
+	// The string value of the context node is computed once for each
+	// node a predicate is evaluated for.
+	for _, tc := range []struct {
+		expr          string
+		count, values int
+	}{
+		{`//title[contains(., "a") and not(starts-with(., "H"))]`, 3, 4},
+		{`//title[. != "" and string-length(.) > 12 and normalize-space(.) = string(.)]`, 2, 4},
+		{`//title[. = "Harry Potter" or . = "Learning XML"]`, 2, 4},
+		{`//author[substring-before(., " ") = "James"][contains(concat(., "!"), "n!")]`, 2, 10},
+	} {
+		values := 0
+		nav := &valueCountingNavigator{TNodeNavigator: createNavigator(book_example), values: &values}
+		n := 0
+		for iter := MustCompile(tc.expr).Select(nav); iter.MoveNext(); {
+			n++
+		}
+		assertEqual(t, tc.count, n)
+		assertEqual(t, tc.values, values)
+	}
+}
+
+// valueCountingNavigator counts the calls to Value.
+type valueCountingNavigator struct {
+	*TNodeNavigator
+	values *int
+}
+
+func (n *valueCountingNavigator) Value() string {
+	*n.values++
+	return n.TNodeNavigator.Value()
+}
+
+func (n *valueCountingNavigator) Copy() NodeNavigator {
+	return &valueCountingNavigator{TNodeNavigator: n.TNodeNavigator.Copy().(*TNodeNavigator), values: n.values}
+}
+
+func (n *valueCountingNavigator) MoveTo(other NodeNavigator) bool {
+	if o, ok := other.(*valueCountingNavigator); ok {
+		return n.TNodeNavigator.MoveTo(o.TNodeNavigator)
+	}
+	return false
+}
+
+// createDivDocument returns a document with n div elements, every tenth of
+// which has class x.
+func createDivDocument(n int) *TNode {
+	doc := createNode("", RootNode)
+	root := doc.createChildNode("html", ElementNode)
+	for i := 0; i < n; i++ {
+		div := root.createChildNode("div", ElementNode)
+		if i%10 == 0 {
+			div.addAttribute("class", "x")
+		} else {
+			div.addAttribute("class", "y")
+		}
+		div.createChildNode("p", ElementNode).createChildNode("text", TextNode)
+	}
+	return doc
+}
+
+func TestPredicateAllocations(t *testing.T) {
+	// The steps reuse their navigators, so the allocations do not grow
+	// with the number of nodes visited.
+	doc := createDivDocument(1000)
+	exp := MustCompile(`//div[@class='x']`)
+	n := 0
+	allocs := testing.AllocsPerRun(10, func() {
+		n = 0
+		for iter := exp.Select(createNavigator(doc)); iter.MoveNext(); {
+			n++
+		}
+	})
+	assertEqual(t, 100, n)
+	if allocs > 50 {
+		t.Errorf("expected a bounded number of allocations, got %v", allocs)
+	}
+}
+
+func BenchmarkPredicateAttribute(b *testing.B) {
+	doc := createDivDocument(1000)
+	exp := MustCompile(`//div[@class='x']`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for iter := exp.Select(createNavigator(doc)); iter.MoveNext(); {
+		}
+	}
+}
+
+func BenchmarkPredicatePosition(b *testing.B) {
+	doc := createDivDocument(1000)
+	exp := MustCompile(`/html/div[1000]`)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for iter := exp.Select(createNavigator(doc)); iter.MoveNext(); {
+		}
+	}
+}
@@ -2,8 +2,12 @@ package xpath
 
 import (
 	"bytes"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"regexp/syntax"
 	"sort"
 	"strings"
 	"testing"
@@ -176,6 +180,146 @@ func TestInvalidXPath(t *testing.T) {
 	assertErr(t, err)
 }
 
+func TestSyntaxError(t *testing.T) {
+	for _, tc := range []struct {
+		expr     string
+		offset   int
+		token    string
+		expected []string
+		msg      string
+	}{
+		{`//a[1`, 5, "", []string{"']'"}, ""},
+		{`//a[@id=1)`, 9, ")", []string{"']'"}, ""},
+		{`//a]`, 3, "]", []string{"end of expression"}, ""},
+		{`1 +`, 3, "", []string{"expression"}, ""},
+		{`child::`, 7, "", []string{"name", "'*'", "node type test"}, ""},
+		{`//a[@id='x]`, 8, "'x]", nil, "unclosed string"},
+		{`a/#`, 2, "#", nil, "invalid character"},
+		{`sideways::a`, 0, "sideways::", nil, "unknown axis"},
+		{`/u:foo`, 1, "u:foo", nil, "undefined namespace prefix"},
+	} {
+		_, err := CompileWithNS(tc.expr, map[string]string{"a": "b"})
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: expected a syntax error, got %v", tc.expr, err)
+			continue
+		}
+		assertEqual(t, tc.expr, syntaxErr.Expr)
+		assertEqual(t, tc.offset, syntaxErr.Offset)
+		assertEqual(t, tc.token, syntaxErr.Token)
+		assertEqual(t, tc.expected, syntaxErr.Expected)
+		assertEqual(t, tc.msg, syntaxErr.Msg)
+	}
+
+	_, err := Compile(`//é[1)`)
+	assertEqual(t, "xpath: syntax error at offset 6: expected ']', found \")\"\n\t//é[1)\n\t     ^", err.Error())
+	_, err = Compile("//a\n  [@id='x]")
+	assertEqual(t, "xpath: syntax error at offset 11: unclosed string \"'x]\"\n\t  [@id='x]\n\t       ^", err.Error())
+}
+
+func TestErrorCategories(t *testing.T) {
+	for _, tc := range []struct {
+		expr string
+		want error
+	}{
+		{`//a[`, ErrSyntax},
+		{``, ErrSyntax},
+		{`foo()`, ErrUnknownFunction},
+		{`substring('a')`, ErrArgumentCount},
+		{`starts-with('a')`, ErrArgumentCount},
+		{`count()`, ErrArgumentCount},
+		{`count(a, b)`, ErrArgumentCount},
+		{`last(1)`, ErrArgumentCount},
+		{`boolean()`, ErrArgumentCount},
+		{`count('a')`, ErrTypeMismatch},
+		{`sum(true())`, ErrTypeMismatch},
+		{`local-name(1)`, ErrTypeMismatch},
+		{`contains(., 'a', 1)`, ErrTypeMismatch},
+		{`$x`, ErrUnboundVariable},
+		{`//a[@id = $x]`, ErrUnboundVariable},
+		{`matches(., '[')`, ErrInvalidRegexp},
+		{strings.Repeat("(", 300) + "1" + strings.Repeat(")", 300), ErrTooComplex},
+	} {
+		_, err := Compile(tc.expr)
+		if !errors.Is(err, tc.want) {
+			t.Errorf("%s: expected %v, got %v", tc.expr, tc.want, err)
+		}
+	}
+
+	_, err := Compile(`replace(., '[', 'x')`)
+	var regexpErr *syntax.Error
+	assertTrue(t, errors.As(err, &regexpErr))
+	assertEqual(t, "replace() got error. error parsing regexp: missing closing ]: `[`", err.Error())
+
+	// Functions called with a value of the wrong type panic with
+	// ErrTypeMismatch.
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			assertTrue(t, errors.Is(err, ErrTypeMismatch))
+		}()
+		MustCompile(`sum('a')`).Evaluate(createNavigator(book_example))
+	}()
+}
+
+func TestFunctionSignatures(t *testing.T) {
+	_, err := Compile(`substring('abc')`)
+	assertEqual(t, "xpath: substring() takes 2 or 3 arguments, got 1", err.Error())
+	_, err = Compile(`concat('a')`)
+	assertEqual(t, "xpath: concat() takes at least 2 arguments, got 1", err.Error())
+	_, err = Compile(`//a[position(1)]`)
+	assertEqual(t, "xpath: position() takes no arguments, got 1", err.Error())
+	_, err = Compile(`//a[starts-with(@id, 'a', //b)]`)
+	assertEqual(t, "xpath: argument 3 of starts-with() must be a string, not a node-set", err.Error())
+	_, err = Compile(`count(1 + 1)`)
+	assertEqual(t, "xpath: argument 1 of count() must be a node-set, not a number", err.Error())
+
+	// XPath 1.0 converts the other arguments to the types the functions
+	// take.
+	for _, expr := range []string{`not(1)`, `not('x')`, `concat('n=', count(//p))`, `substring(., @start)`, `contains(@id, 1)`, `string-length(1 + 1)`, `starts-with(@id, //b)`} {
+		_, err := Compile(expr)
+		assertNoErr(t, err)
+	}
+	test_xpath_eval(t, book_example, `concat('n=', count(//book))`, "n=4")
+	test_xpath_eval(t, book_example, `not(0)`, true)
+	test_xpath_eval(t, book_example, `not('x')`, false)
+	test_xpath_eval(t, book_example, `substring(12345, '2', 3)`, "234")
+	test_xpath_eval(t, book_example, `substring('abc', 'x')`, "")
+	test_xpath_eval(t, book_example, `contains(//book[1]/price, 30)`, true)
+	test_xpath_eval(t, book_example, `string-length(1 + 1)`, float64(1))
+
+	// The optional arguments default to the context node.
+	test_xpath_eval(t, book_example, `//book[number() = 0]`)
+	test_xpath_eval(t, book_example, `count(//price[number() > 35])`, float64(2))
+}
+
+func TestUnknownNameSuggestions(t *testing.T) {
+	_, err := Compile(`lowercase(.)`)
+	var fe *UnknownFunctionError
+	assertTrue(t, errors.As(err, &fe))
+	assertEqual(t, "lowercase", fe.Name)
+	assertEqual(t, "lower-case", fe.Suggestion)
+	assertEqual(t, "xpath: unknown function lowercase(), did you mean lower-case()?", err.Error())
+	assertTrue(t, errors.Is(err, ErrUnknownFunction))
+
+	_, err = Compile(`foo(.)`)
+	assertTrue(t, errors.As(err, &fe))
+	assertEqual(t, "", fe.Suggestion)
+
+	_, err = Compile(`//a/decendant::b`)
+	var se *SyntaxError
+	assertTrue(t, errors.As(err, &se))
+	assertEqual(t, "decendant::", se.Token)
+	assertEqual(t, "descendant::", se.Suggestion)
+	assertTrue(t, strings.Contains(err.Error(), `did you mean "descendant::"?`))
+
+	// Every function in the registry is built.
+	for name := range builtinFunctions {
+		_, err := Compile(name + "()")
+		assertFalse(t, errors.Is(err, ErrUnknownFunction))
+	}
+}
+
 func TestCompileWithNS(t *testing.T) {
 	_, err := CompileWithNS("/foo", nil)
 	assertNil(t, err)
@@ -190,28 +334,13 @@ func TestCompileWithNS(t *testing.T) {
 }
 
 func TestNamespacePrefixQuery(t *testing.T) {
-	/*
-		<?xml version="1.0" encoding="UTF-8"?>
-		<books>
-			<book>book1</book>
-			<b:book xmlns:b="ns">book2</b:book>
-			<c:book xmlns:c="ns">book3</c:book>
-		</books>
-	*/
-	doc := createNode("", RootNode)
-	books := doc.createChildNode("books", ElementNode)
-	books.lines = 2
-	book1 := books.createChildNode("book", ElementNode)
-	book1.lines = 3
-	book1.createChildNode("book1", TextNode)
-	book2 := books.createChildNode("b:book", ElementNode)
-	book2.lines = 4
-	book2.addAttribute("xmlns:b", "ns")
-	book2.createChildNode("book2", TextNode)
-	book3 := books.createChildNode("c:book", ElementNode)
-	book3.lines = 5
-	book3.addAttribute("xmlns:c", "ns")
-	book3.createChildNode("book3", TextNode)
+	doc := parseXML(`<?xml version="1.0" encoding="UTF-8"?>
+<books>
+	<book>book1</book>
+	<b:book xmlns:b="ns">book2</b:book>
+	<c:book xmlns:c="ns">book3</c:book>
+</books>`)
+	book1 := selectNode(doc, "//book")
 
 	test_xpath_elements(t, doc, `//b:book`, 4) // expected [4 , 5]
 
@@ -221,6 +350,43 @@ func TestNamespacePrefixQuery(t *testing.T) {
 	assertEqual(t, 2, len(nodes))
 	assertEqual(t, "book2", nodes[0].Value())
 	assertEqual(t, "book3", nodes[1].Value())
+
+	// With namespace bindings, a name of no prefix selects the elements
+	// in no namespace only.
+	exp, _ = CompileWithNS("//b", map[string]string{"x": "ns"})
+	assertEqual(t, 0, len(iterateNodes(exp.Select(createNavigator(parseXML(`<r xmlns="ns"><b/></r>`))))))
+	assertEqual(t, 1, len(iterateNodes(exp.Select(createNavigator(parseXML(`<r xmlns:x="ns"><b/></r>`))))))
+
+	// prefix:* selects any element in the namespace.
+	exp, _ = CompileWithNS("//x:*", map[string]string{"x": "ns"})
+	assertEqual(t, 2, len(iterateNodes(exp.Select(createNavigator(doc)))))
+	exp, _ = CompileWithNS("//x:* and true()", map[string]string{"x": "ns"})
+	assertEqual(t, true, exp.Evaluate(createNavigator(doc)))
+	test_xpath_elements(t, doc, `//c:*`, 5)
+
+	// Namespaced attributes.
+	book1.addAttributeNS("b", "id", "ns", "1")
+	exp, _ = CompileWithNS("string(//@x:id)", map[string]string{"x": "ns"})
+	assertEqual(t, "1", exp.Evaluate(createNavigator(doc)))
+	test_xpath_count(t, doc, `//@id`, 0)
+}
+
+func TestParseXML(t *testing.T) {
+	doc := parseXML(`<r xmlns="urn:d" xmlns:a="urn:a">
+  <a:b a:id="1" id="2">x<![CDATA[<y>]]></a:b>
+  <!--c-->
+  <b/>
+</r>`)
+	test_xpath_elements(t, doc, `//*`, 1, 2, 4)
+	test_xpath_values(t, doc, `//text()`, "x<y>")
+	test_xpath_eval(t, doc, `string(//comment())`, "c")
+	// The xmlns attributes are attributes of TNode.
+	test_xpath_eval(t, doc, `count(//@*)`, float64(4))
+	exp, err := CompileWithNS(`//x:b/@x:id | //d:b`, map[string]string{"x": "urn:a", "d": "urn:d"})
+	assertNoErr(t, err)
+	assertEqual(t, 2, len(iterateNodes(exp.Select(createNavigator(doc)))))
+	assertPanic(t, func() { parseXML(`<r><b></r>`) })
+	assertPanic(t, func() { parseXML(`<r>`) })
 }
 
 func TestMustCompile(t *testing.T) {
@@ -258,6 +424,15 @@ func Test_minusFunc(t *testing.T) {
 	assertTrue(t, math.IsNaN(v.(float64)))
 }
 
+func Test_modFunc(t *testing.T) {
+	// The operands are not truncated, and the remainder has the sign of
+	// the dividend.
+	assertEqual(t, float64(1.5), modFunc(nil, float64(5.5), float64(2)))
+	assertEqual(t, float64(-1), modFunc(nil, float64(-5), float64(2)))
+	v := modFunc(nil, float64(5), float64(0))
+	assertTrue(t, math.IsNaN(v.(float64)))
+}
+
 func TestNodeType(t *testing.T) {
 	tests := []struct {
 		expr     string
@@ -351,6 +526,9 @@ func createNavigator(n *TNode) *TNodeNavigator {
 
 type Attribute struct {
 	Key, Value string
+	// Prefix and NamespaceURL are set for namespaced attributes, whose Key
+	// is then the local name.
+	Prefix, NamespaceURL string
 }
 
 type TNode struct {
@@ -416,6 +594,9 @@ func (n *TNodeNavigator) LocalName() string {
 }
 
 func (n *TNodeNavigator) Prefix() string {
+	if n.attr != -1 && n.curr.Attr[n.attr].Prefix != "" {
+		return n.curr.Attr[n.attr].Prefix
+	}
 	if n.attr == -1 && strings.Contains(n.curr.Data, ":") {
 		return strings.Split(n.curr.Data, ":")[0]
 	}
@@ -423,14 +604,40 @@ func (n *TNodeNavigator) Prefix() string {
 }
 
 func (n *TNodeNavigator) NamespaceURL() string {
-	if n.Prefix() != "" {
-		for _, a := range n.curr.Attr {
-			if a.Key == "xmlns:"+n.Prefix() {
+	if n.attr != -1 {
+		if attr := n.curr.Attr[n.attr]; attr.Prefix != "" {
+			if attr.NamespaceURL != "" {
+				return attr.NamespaceURL
+			}
+			return n.curr.lookupNamespaceURL(attr.Prefix)
+		}
+		// Unprefixed attributes are in no namespace.
+		return ""
+	}
+	if n.curr.NamespaceURL != "" {
+		return n.curr.NamespaceURL
+	}
+	if n.attr == -1 && n.curr.Type == ElementNode {
+		return n.curr.lookupNamespaceURL(n.Prefix())
+	}
+	return ""
+}
+
+// lookupNamespaceURL returns the namespace URL bound to prefix by the
+// xmlns attributes of n or its ancestors.
+func (n *TNode) lookupNamespaceURL(prefix string) string {
+	key := "xmlns"
+	if prefix != "" {
+		key += ":" + prefix
+	}
+	for node := n; node != nil; node = node.Parent {
+		for _, a := range node.Attr {
+			if a.Prefix == "" && a.Key == key {
 				return a.Value
 			}
 		}
 	}
-	return n.curr.NamespaceURL
+	return ""
 }
 
 func (n *TNodeNavigator) Value() string {
@@ -546,6 +753,59 @@ func (n *TNodeNavigator) MoveTo(other NodeNavigator) bool {
 	return true
 }
 
+func (n *TNodeNavigator) ComparePosition(other NodeNavigator) (int, bool) {
+	node, ok := other.(*TNodeNavigator)
+	if !ok || node.root != n.root {
+		return 0, false
+	}
+	a, b := n.curr, node.curr
+	if a == b {
+		switch {
+		case n.attr < node.attr:
+			return -1, true
+		case n.attr > node.attr:
+			return 1, true
+		}
+		return 0, true
+	}
+	depth := func(n *TNode) int {
+		d := 0
+		for ; n.Parent != nil; n = n.Parent {
+			d++
+		}
+		return d
+	}
+	da, db := depth(a), depth(b)
+	for ; da > db; da-- {
+		if a = a.Parent; a == b {
+			return 1, true
+		}
+	}
+	for ; db > da; db-- {
+		if b = b.Parent; b == a {
+			return -1, true
+		}
+	}
+	for a.Parent != b.Parent {
+		a, b = a.Parent, b.Parent
+	}
+	for next, prev := a.NextSibling, a.PrevSibling; next != nil || prev != nil; {
+		if next == b {
+			return -1, true
+		}
+		if prev == b {
+			return 1, true
+		}
+		if next != nil {
+			next = next.NextSibling
+		}
+		if prev != nil {
+			prev = prev.PrevSibling
+		}
+	}
+	return 0, false
+}
+
 func createNode(data string, typ NodeType) *TNode {
 	return &TNode{Data: data, Type: typ, Attr: make([]Attribute, 0)}
 }
@@ -590,7 +850,25 @@ func (n *TNode) AddChild(child *TNode) {
 }
 
 func (n *TNode) addAttribute(k, v string) {
-	n.Attr = append(n.Attr, Attribute{k, v})
+	n.Attr = append(n.Attr, Attribute{Key: k, Value: v})
+}
+
+// createChildNodeNS creates a child element with the given prefix, local
+// name and namespace URL.
+func (n *TNode) createChildNodeNS(prefix, local, url string) *TNode {
+	name := local
+	if prefix != "" {
+		name = prefix + ":" + local
+	}
+	m := n.createChildNode(name, ElementNode)
+	m.NamespaceURL = url
+	return m
+}
+
+// addAttributeNS adds an attribute with the given prefix, local name and
+// namespace URL.
+func (n *TNode) addAttributeNS(prefix, local, url, v string) {
+	n.Attr = append(n.Attr, Attribute{Key: local, Value: v, Prefix: prefix, NamespaceURL: url})
 }
 
 func (n *TNode) getAttribute(key string) string {
@@ -602,6 +880,63 @@ func (n *TNode) getAttribute(key string) string {
 	return ""
 }
 
+// parseXML returns the document node of the tree of the XML literal s,
+// whose elements have the line of their start tag in s as lines, for
+// test_xpath_elements. The text nodes of whitespace only, such as the
+// indentation, are dropped; the xmlns attributes are kept, as with
+// addAttribute("xmlns:b", "ns"). It panics if s is not well-formed.
+func parseXML(s string) *TNode {
+	doc := createNode("", RootNode)
+	curr := doc
+	d := xml.NewDecoder(strings.NewReader(s))
+	for {
+		line := strings.Count(s[:d.InputOffset()], "\n") + 1
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			panic(fmt.Sprintf("parseXML: %v", err))
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			name := tok.Name.Local
+			if tok.Name.Space != "" {
+				name = tok.Name.Space + ":" + name
+			}
+			curr = curr.createChildNode(name, ElementNode)
+			curr.lines = line
+			for _, a := range tok.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					curr.addAttribute("xmlns:"+a.Name.Local, a.Value)
+				case a.Name.Space != "":
+					curr.addAttributeNS(a.Name.Space, a.Name.Local, "", a.Value)
+				default:
+					curr.addAttribute(a.Name.Local, a.Value)
+				}
+			}
+		case xml.EndElement:
+			curr = curr.Parent
+		case xml.CharData:
+			switch {
+			case strings.TrimSpace(string(tok)) == "":
+			case curr.LastChild != nil && curr.LastChild.Type == TextNode:
+				// Text and CDATA sections are tokens of their own.
+				curr.LastChild.Data += string(tok)
+			default:
+				curr.createChildNode(string(tok), TextNode)
+			}
+		case xml.Comment:
+			curr.createChildNode(string(tok), CommentNode)
+		}
+	}
+	if curr != doc {
+		panic("parseXML: unclosed element " + curr.Data)
+	}
+	return doc
+}
+
 func createBookExample() *TNode {
 	/*
 	   <?xml version="1.0" encoding="UTF-8"?>
@@ -754,6 +1089,23 @@ func TestNumericComparisonEmptyNode(t *testing.T) {
 	test_xpath_count(t, doc, `//nonempty[. != 123]`, 0) // 123 != 123 is false
 }
 
+func TestChainedComparisons(t *testing.T) {
+	// The result of a comparison is a boolean, compared as a boolean with
+	// = and !=, and as a number with the other operators.
+	test_xpath_eval(t, empty_example, `1=1=1`, true)
+	test_xpath_eval(t, empty_example, `'a'='a'='a'`, true)
+	test_xpath_eval(t, empty_example, `1=2=0`, true)
+	test_xpath_eval(t, empty_example, `1=2!=''`, false)
+	test_xpath_eval(t, empty_example, `(1=1)=(2=2)`, true)
+	test_xpath_eval(t, empty_example, `1<2<3`, true)
+	test_xpath_eval(t, empty_example, `3>2>1`, false)
+	test_xpath_eval(t, empty_example, `(1=1) > '0.5'`, true)
+	test_xpath_eval(t, book_example, `//book = (1=1)`, true)
+	test_xpath_eval(t, book_example, `//none < (1=1)`, true)
+	test_xpath_elements(t, book_example, `//book[@category='web'=true()]`, 15, 25)
+	test_xpath_count(t, book_example, `*[local-name()=''='']`, 1)
+}
+
 func Test_StringFunctionPredicatePanic(t *testing.T) {
 	/*
 		Reproduces panic reported in issue:
@@ -0,0 +1,521 @@
+package xpathtest
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// ConformanceOptions selects the test cases RunConformance runs.
+type ConformanceOptions struct {
+	// Specs are the values of the spec dependencies of the test cases to
+	// run, such as "XP10+"; the test cases depending on other specs are
+	// left out of the report. If nil, they are XP10 and XP10+, for the
+	// test cases that apply to XPath 1.0.
+	Specs []string
+	// Sets are the names of the test sets to run, or nil for all of them.
+	Sets []string
+}
+
+// A ConformanceReport is the result of the test cases of a conformance
+// suite, by test set.
+type ConformanceReport struct {
+	Sets []SetReport
+}
+
+// A SetReport is the result of the test cases of a test set.
+type SetReport struct {
+	Name string
+	// Skipped are the test cases that need what the runner does not
+	// provide, such as schemas, variables, or assertions on the types of
+	// XPath 2.0.
+	Passed, Failed, Skipped int
+	// Failures are the test cases failed, in the order of the test set.
+	Failures []CaseFailure
+}
+
+// A CaseFailure is a test case that failed.
+type CaseFailure struct {
+	Name, Expr string
+	// Msg is the assertion that failed and the result, such as
+	// "assert-count 2: 3 nodes".
+	Msg string
+}
+
+// Total returns the sums of the test sets of r, under the name "total".
+func (r *ConformanceReport) Total() SetReport {
+	total := SetReport{Name: "total"}
+	for _, s := range r.Sets {
+		total.Passed += s.Passed
+		total.Failed += s.Failed
+		total.Skipped += s.Skipped
+		total.Failures = append(total.Failures, s.Failures...)
+	}
+	return total
+}
+
+// String returns the matrix of the test sets and the number of test cases
+// passed, failed and skipped, with the total.
+func (r *ConformanceReport) String() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 8, 1, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "test set\tpassed\tfailed\tskipped\t")
+	for _, s := range append(r.Sets, r.Total()) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t\n", s.Name, s.Passed, s.Failed, s.Skipped)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// RunConformance runs the test cases of the conformance suite whose
+// catalog file is catalog, in the format of the W3C XQuery and XPath test
+// suite (QT3), https://github.com/w3c/qt3tests, on the xpath package and
+// the dom package. The expressions are evaluated on the source documents
+// of their environments, or on an empty document, and checked with the
+// assertions of the test cases. The error is that of reading the files of
+// the suite.
+func RunConformance(catalog string, opts ConformanceOptions) (*ConformanceReport, error) {
+	if opts.Specs == nil {
+		opts.Specs = []string{"XP10", "XP10+"}
+	}
+	var cat qt3Catalog
+	if err := readXML(catalog, &cat); err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(catalog)
+	envs := environments(cat.Environments, dir, nil)
+
+	report := new(ConformanceReport)
+	for _, ref := range cat.TestSets {
+		if opts.Sets != nil && !contains(opts.Sets, ref.Name) {
+			continue
+		}
+		file := filepath.Join(dir, ref.File)
+		var set qt3TestSet
+		if err := readXML(file, &set); err != nil {
+			return nil, err
+		}
+		r := runSet(&set, filepath.Dir(file), environments(set.Environments, filepath.Dir(file), envs), opts)
+		r.Name = ref.Name
+		report.Sets = append(report.Sets, r)
+	}
+	return report, nil
+}
+
+type qt3Catalog struct {
+	Environments []qt3Environment `xml:"environment"`
+	TestSets     []struct {
+		Name string `xml:"name,attr"`
+		File string `xml:"file,attr"`
+	} `xml:"test-set"`
+}
+
+type qt3TestSet struct {
+	Dependencies []qt3Dependency  `xml:"dependency"`
+	Environments []qt3Environment `xml:"environment"`
+	Cases        []qt3Case        `xml:"test-case"`
+}
+
+type qt3Case struct {
+	Name         string          `xml:"name,attr"`
+	Dependencies []qt3Dependency `xml:"dependency"`
+	Environment  *qt3Environment `xml:"environment"`
+	Test         string          `xml:"test"`
+	Result       qt3Assertion    `xml:"result"`
+}
+
+type qt3Dependency struct {
+	Type      string `xml:"type,attr"`
+	Value     string `xml:"value,attr"`
+	Satisfied string `xml:"satisfied,attr"`
+}
+
+type qt3Environment struct {
+	Name    string `xml:"name,attr"`
+	Ref     string `xml:"ref,attr"`
+	Sources []struct {
+		Role    string `xml:"role,attr"`
+		File    string `xml:"file,attr"`
+		Content string `xml:"content"`
+	} `xml:"source"`
+	Namespaces []struct {
+		Prefix string `xml:"prefix,attr"`
+		URI    string `xml:"uri,attr"`
+	} `xml:"namespace"`
+	// The environments with variables, schemas or collections are not
+	// supported.
+	Params      []struct{} `xml:"param"`
+	Schemas     []struct{} `xml:"schema"`
+	Collections []struct{} `xml:"collection"`
+
+	dir string // the directory of the files of the sources
+}
+
+// A qt3Assertion is the result element of a test case, or an assertion.
+type qt3Assertion struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr     `xml:",any,attr"`
+	Text     string         `xml:",chardata"`
+	Inner    string         `xml:",innerxml"`
+	Children []qt3Assertion `xml:",any"`
+}
+
+func (a *qt3Assertion) attr(name string) string {
+	for _, attr := range a.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func readXML(file string, v interface{}) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("xpathtest: %s: %v", file, err)
+	}
+	return nil
+}
+
+// environments returns the environments list by name, defined in dir,
+// added to those of parent.
+func environments(list []qt3Environment, dir string, parent map[string]*qt3Environment) map[string]*qt3Environment {
+	envs := make(map[string]*qt3Environment)
+	for name, env := range parent {
+		envs[name] = env
+	}
+	for i := range list {
+		list[i].dir = dir
+		envs[list[i].Name] = &list[i]
+	}
+	return envs
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// applies reports whether the test cases with the dependencies deps are
+// to be run, and, if they are, the reason they cannot be, if any.
+func applies(deps []qt3Dependency, opts ConformanceOptions) (run bool, unsupported string) {
+	for _, d := range deps {
+		switch d.Type {
+		case "spec":
+			run := false
+			for _, spec := range strings.Fields(d.Value) {
+				run = run || contains(opts.Specs, spec)
+			}
+			if !run {
+				return false, ""
+			}
+		case "feature":
+			if d.Satisfied != "false" {
+				unsupported = "feature " + d.Value
+			}
+		}
+	}
+	return true, unsupported
+}
+
+func runSet(set *qt3TestSet, dir string, envs map[string]*qt3Environment, opts ConformanceOptions) SetReport {
+	var r SetReport
+	run, setUnsupported := applies(set.Dependencies, opts)
+	if !run {
+		return r
+	}
+	for i := range set.Cases {
+		c := &set.Cases[i]
+		run, unsupported := applies(c.Dependencies, opts)
+		if !run {
+			continue
+		}
+		if unsupported == "" {
+			unsupported = setUnsupported
+		}
+		var msg string
+		if unsupported == "" {
+			msg, unsupported = runCase(c, dir, envs)
+		}
+		switch {
+		case unsupported != "":
+			r.Skipped++
+		case msg != "":
+			r.Failed++
+			r.Failures = append(r.Failures, CaseFailure{Name: c.Name, Expr: strings.TrimSpace(c.Test), Msg: msg})
+		default:
+			r.Passed++
+		}
+	}
+	return r
+}
+
+// errUnsupported is the error of the environments and assertions the
+// runner does not support.
+var errUnsupported = errors.New("unsupported")
+
+// runCase returns why the test case c failed, or "" if it passed, or what
+// it needs that is not supported.
+func runCase(c *qt3Case, dir string, envs map[string]*qt3Environment) (msg, unsupported string) {
+	env := c.Environment
+	if env != nil && env.Ref != "" {
+		env = envs[env.Ref]
+		if env == nil {
+			return "", "environment " + c.Environment.Ref
+		}
+	} else if env != nil {
+		env.dir = dir
+	}
+	doc := dom.NewDocument()
+	var namespaces map[string]string
+	if env != nil {
+		if len(env.Params) > 0 || len(env.Schemas) > 0 || len(env.Collections) > 0 {
+			return "", "environment with params, schemas or collections"
+		}
+		for _, s := range env.Sources {
+			if s.Role != "." {
+				return "", "source " + s.Role
+			}
+			var err error
+			if s.File != "" {
+				doc, err = readDocument(filepath.Join(env.dir, s.File))
+			} else {
+				doc, err = dom.ParseBytes([]byte(s.Content))
+			}
+			if err != nil {
+				return "", "source: " + err.Error()
+			}
+		}
+		for _, ns := range env.Namespaces {
+			if namespaces == nil {
+				namespaces = make(map[string]string)
+			}
+			namespaces[ns.Prefix] = ns.URI
+		}
+	}
+
+	if len(c.Result.Children) != 1 {
+		return "", "result with no assertion"
+	}
+	res := evaluate(doc, strings.TrimSpace(c.Test), namespaces)
+	msg, err := res.check(&c.Result.Children[0], namespaces)
+	if err != nil {
+		return "", err.Error()
+	}
+	return msg, ""
+}
+
+func readDocument(file string) (*dom.Node, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return dom.Parse(f)
+}
+
+// A caseResult is the value or the error of the expression of a test
+// case.
+type caseResult struct {
+	doc   *dom.Node
+	expr  *xpath.Expr
+	value interface{}
+	nodes []xpath.NodeNavigator // the nodes of a node-set value
+	str   string                // the string() of another value
+	err   error
+}
+
+func evaluate(doc *dom.Node, expr string, namespaces map[string]string) *caseResult {
+	r := &caseResult{doc: doc}
+	r.expr, r.err = xpath.CompileWithNS(expr, namespaces)
+	if r.err != nil {
+		return r
+	}
+	r.value, r.err = r.expr.EvaluateWithOptions(dom.CreateNavigator(doc), xpath.EvalOptions{RecoverPanics: true})
+	if iter, ok := r.value.(*xpath.NodeIterator); ok {
+		for iter.MoveNext() {
+			r.nodes = append(r.nodes, iter.Current().Copy())
+		}
+		r.err = iter.Err()
+	} else if r.err == nil {
+		str, err := xpath.CompileWithNS("string("+expr+")", namespaces)
+		if err == nil {
+			v, _ := str.EvaluateWithOptions(dom.CreateNavigator(doc), xpath.EvalOptions{RecoverPanics: true})
+			r.str, _ = v.(string)
+		}
+	}
+	return r
+}
+
+// check returns why the result r does not satisfy the assertion a, or ""
+// if it does.
+func (r *caseResult) check(a *qt3Assertion, namespaces map[string]string) (string, error) {
+	name := a.XMLName.Local
+	if name == "error" {
+		if r.err == nil {
+			return fmt.Sprintf("error %s: %s", a.attr("code"), r.describe()), nil
+		}
+		return "", nil
+	}
+	switch name {
+	case "any-of", "all-of", "not":
+		var msgs []string
+		for i := range a.Children {
+			msg, err := r.check(&a.Children[i], namespaces)
+			if err != nil {
+				return "", err
+			}
+			switch {
+			case name == "any-of" && msg == "":
+				return "", nil
+			case name == "not" && msg == "":
+				return "not " + a.Children[i].XMLName.Local + ": " + r.describe(), nil
+			case name != "not" && msg != "":
+				msgs = append(msgs, msg)
+			}
+		}
+		if name == "any-of" || len(msgs) > 0 {
+			return strings.Join(msgs, "; "), nil
+		}
+		return "", nil
+	}
+	if r.err != nil {
+		return fmt.Sprintf("%s: error %v", name, r.err), nil
+	}
+	text := strings.TrimSpace(a.Text)
+	fail := func() (string, error) {
+		return fmt.Sprintf("%s %s: %s", name, text, r.describe()), nil
+	}
+	switch name {
+	case "assert-true", "assert-false":
+		if b, ok := r.value.(bool); !ok || b != (name == "assert-true") {
+			return fail()
+		}
+	case "assert-empty":
+		if _, ok := r.value.(*xpath.NodeIterator); !ok || len(r.nodes) > 0 {
+			return fail()
+		}
+	case "assert-count":
+		n := 1
+		if _, ok := r.value.(*xpath.NodeIterator); ok {
+			n = len(r.nodes)
+		}
+		if strconv.Itoa(n) != text {
+			return fail()
+		}
+	case "assert-string-value":
+		s := r.stringValue()
+		if a.attr("normalize-space") == "true" || a.attr("normalize-space") == "1" {
+			s, text = strings.Join(strings.Fields(s), " "), strings.Join(strings.Fields(text), " ")
+		}
+		if s != a.Text && s != text {
+			return fail()
+		}
+	case "assert-eq", "assert-deep-eq":
+		want, err := xpath.CompileWithNS(text, namespaces)
+		if err != nil {
+			return "", fmt.Errorf("%s %s: %w", name, text, errUnsupported)
+		}
+		if !r.equals(want.Evaluate(dom.CreateNavigator(r.doc))) {
+			return fail()
+		}
+	case "assert-xml":
+		if a.attr("file") != "" {
+			return "", fmt.Errorf("assert-xml file: %w", errUnsupported)
+		}
+		nodes, err := dom.Select(r.doc, r.expr)
+		if err != nil {
+			return fail()
+		}
+		var b strings.Builder
+		for _, n := range nodes {
+			b.WriteString(n.OutputXML(true))
+		}
+		want := a.Inner
+		if len(a.Children) == 0 {
+			// The XML is in a CDATA section.
+			want = a.Text
+		}
+		if b.String() != strings.TrimSpace(want) {
+			return fail()
+		}
+	default:
+		return "", fmt.Errorf("%s: %w", name, errUnsupported)
+	}
+	return "", nil
+}
+
+// stringValue returns the values of the nodes of r, separated by spaces,
+// or its other value as string() converts it.
+func (r *caseResult) stringValue() string {
+	if _, ok := r.value.(*xpath.NodeIterator); ok {
+		values := make([]string, len(r.nodes))
+		for i, n := range r.nodes {
+			values[i] = n.Value()
+		}
+		return strings.Join(values, " ")
+	}
+	return r.str
+}
+
+// equals reports whether the value of r is want, a number, string or
+// boolean; a node-set must have one node, whose value is converted.
+func (r *caseResult) equals(want interface{}) bool {
+	got := r.value
+	if _, ok := got.(*xpath.NodeIterator); ok {
+		if len(r.nodes) != 1 {
+			return false
+		}
+		got = r.nodes[0].Value()
+	}
+	switch want := want.(type) {
+	case float64:
+		switch g := got.(type) {
+		case float64:
+			return g == want || math.IsNaN(g) && math.IsNaN(want)
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(g), 64)
+			return err == nil && f == want
+		}
+	case string:
+		return got == want
+	case bool:
+		return got == want
+	}
+	return false
+}
+
+// describe returns the result for the failure messages, such as
+// "3 nodes" or "number 2".
+func (r *caseResult) describe() string {
+	switch v := r.value.(type) {
+	case *xpath.NodeIterator:
+		return fmt.Sprintf("%d nodes", len(r.nodes))
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return fmt.Sprintf("boolean %v", v)
+	}
+	if r.err != nil {
+		return "error " + r.err.Error()
+	}
+	return fmt.Sprint(r.value)
+}
@@ -0,0 +1,79 @@
+package xpathtest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var qt3 = flag.String("qt3", "", "catalog.xml of a checkout of the W3C QT3 test suite to run too, such as qt3tests/catalog.xml")
+
+// knownFailures are the test cases of testdata/xpath10 the xpath package
+// fails, by name.
+var knownFailures = map[string]bool{
+	"fn-string-number":   true, // Infinity is formatted as +Inf
+	"fn-lang":            true, // lang() is not supported
+	"err-unbound-prefix": true, // unbound prefixes match no nodes
+}
+
+func TestConformance(t *testing.T) {
+	report, err := RunConformance("testdata/xpath10/catalog.xml", ConformanceOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log("\n" + report.String())
+	failed := make(map[string]bool)
+	for _, f := range report.Total().Failures {
+		failed[f.Name] = true
+		if !knownFailures[f.Name] {
+			t.Errorf("%s: %s: %s", f.Name, f.Expr, f.Msg)
+		}
+	}
+	for name := range knownFailures {
+		if !failed[name] {
+			t.Errorf("%s passes, remove it from knownFailures", name)
+		}
+	}
+
+	if *qt3 != "" {
+		report, err := RunConformance(*qt3, ConformanceOptions{Specs: []string{"XP10", "XP10+", "XP20", "XP20+"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log("\n" + report.String())
+	}
+}
+
+func TestConformanceAssertions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("catalog.xml", `<catalog xmlns="http://www.w3.org/2010/09/qt-fots-catalog"><test-set name="s" file="s.xml"/></catalog>`)
+	write("s.xml", `<test-set xmlns="http://www.w3.org/2010/09/qt-fots-catalog" name="s">
+  <environment name="e"><source role="."><content><![CDATA[<a><b>1</b><b>2</b></a>]]></content></source></environment>
+  <test-case name="any-of"><environment ref="e"/><test>count(//b)</test><result><any-of><assert-eq>3</assert-eq><assert-eq>2</assert-eq></any-of></result></test-case>
+  <test-case name="not"><environment ref="e"/><test>//b</test><result><not><assert-empty/></not></result></test-case>
+  <test-case name="all-of"><environment ref="e"/><test>//b</test><result><all-of><assert-count>2</assert-count><assert-string-value>1 3</assert-string-value></all-of></result></test-case>
+  <test-case name="assert-xml"><environment ref="e"/><test>//b[2]</test><result><assert-xml><![CDATA[<b>2</b>]]></assert-xml></result></test-case>
+  <test-case name="assert-type"><test>1</test><result><assert-type>xs:integer</assert-type></result></test-case>
+  <test-case name="xpath30"><dependency type="spec" value="XP30+"/><test>1</test><result><assert-eq>1</assert-eq></result></test-case>
+</test-set>`)
+
+	report, err := RunConformance(filepath.Join(dir, "catalog.xml"), ConformanceOptions{Specs: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := report.Sets[0]
+	if s.Passed != 3 || s.Skipped != 1 {
+		t.Errorf("%d passed and %d skipped, want 3 and 1", s.Passed, s.Skipped)
+	}
+	want := []CaseFailure{{Name: "all-of", Expr: "//b", Msg: "assert-string-value 1 3: 2 nodes"}}
+	if !reflect.DeepEqual(s.Failures, want) {
+		t.Errorf("failures %v, want %v", s.Failures, want)
+	}
+}
@@ -0,0 +1,109 @@
+package xpathtest
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// A GoldenCase is an expression to evaluate on a document, for RunGolden
+// and CheckGolden.
+type GoldenCase struct {
+	// Doc is the name of the document.
+	Doc  string
+	Expr string
+	// Namespaces are bound to the prefixes of Expr, as by
+	// xpath.CompileWithNS.
+	Namespaces map[string]string
+}
+
+// RunGolden evaluates the expression of each case on its document of
+// docs, by name, from its document node. The results are those of a
+// Replay, for the cases only; the cases of an expression on a document
+// that is not in docs are ignored.
+func RunGolden(docs map[string]*dom.Node, cases []GoldenCase) *Replay {
+	r := &Replay{Exprs: make(map[string]string), Results: make(map[string]map[string]string)}
+	for _, c := range cases {
+		doc, ok := docs[c.Doc]
+		if !ok {
+			continue
+		}
+		hash := xpath.ExprHash(c.Expr)
+		r.Exprs[hash] = c.Expr
+		if r.Results[hash] == nil {
+			r.Results[hash] = make(map[string]string)
+		}
+		exp, err := xpath.CompileWithNS(c.Expr, c.Namespaces)
+		if err != nil {
+			r.Results[hash][c.Doc] = "error " + err.Error()
+			continue
+		}
+		r.Results[hash][c.Doc] = replayResult(exp, doc)
+	}
+	return r
+}
+
+// CheckGolden compares the results of the cases, by RunGolden, with
+// those of the golden file, written by Replay.WriteTo, and reports the
+// differences as errors of t. If update is true, it writes the results to
+// the file instead, creating its directory. It is for locking in the
+// behavior the tests of a program depend on before upgrading the xpath
+// package:
+//
+//	var update = flag.Bool("update", false, "update the golden files")
+//
+//	func TestSelectors(t *testing.T) {
+//		docs := map[string]*dom.Node{"page": doc}
+//		xpathtest.CheckGolden(t, "testdata/selectors.golden", *update, docs, []xpathtest.GoldenCase{
+//			{Doc: "page", Expr: "//a/@href"},
+//		})
+//	}
+func CheckGolden(t testing.TB, file string, update bool, docs map[string]*dom.Node, cases []GoldenCase) {
+	t.Helper()
+	r := RunGolden(docs, cases)
+	if update {
+		var b bytes.Buffer
+		r.WriteTo(&b)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, b.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("%v; write it with update", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := ReadReplay(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("%s: %v", file, err)
+	}
+	for _, c := range DiffReplays(golden, r) {
+		t.Error(c)
+	}
+	for _, hash := range keys(r.Exprs) {
+		for _, name := range keys(r.Results[hash]) {
+			if _, ok := golden.Results[hash][name]; !ok {
+				t.Errorf("%s %s on %s: not in %s", hash, r.Exprs[hash], name, file)
+			}
+		}
+	}
+	for _, hash := range keys(golden.Exprs) {
+		for _, name := range keys(golden.Results[hash]) {
+			if _, ok := r.Results[hash][name]; !ok {
+				t.Errorf("%s %s on %s: in %s, not a case", hash, golden.Exprs[hash], name, file)
+			}
+		}
+	}
+}
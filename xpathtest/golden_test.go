@@ -0,0 +1,67 @@
+package xpathtest
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/xpath/dom"
+)
+
+// errorsT records the errors of CheckGolden.
+type errorsT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *errorsT) Helper() {}
+
+func (t *errorsT) Error(args ...interface{}) { t.errors = append(t.errors, fmt.Sprint(args...)) }
+
+func (t *errorsT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCheckGolden(t *testing.T) {
+	doc, err := dom.Parse(strings.NewReader(`<r xmlns:p="urn:p"><a>1</a><p:a>2</p:a></r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := map[string]*dom.Node{"r": doc}
+	cases := []GoldenCase{
+		{Doc: "r", Expr: "//a"},
+		{Doc: "r", Expr: "//q:a", Namespaces: map[string]string{"q": "urn:p"}},
+		{Doc: "r", Expr: "sum(//a)"},
+		{Doc: "r", Expr: "//a["},
+	}
+	r := RunGolden(docs, cases)
+	want := map[string]string{
+		"//a":      "nodes /r[1]/a[1]",
+		"//q:a":    "nodes /r[1]/p:a[1]",
+		"sum(//a)": "number 1",
+	}
+	for hash, expr := range r.Exprs {
+		if got := r.Results[hash]["r"]; want[expr] != "" && got != want[expr] {
+			t.Errorf("%s = %s, want %s", expr, got, want[expr])
+		}
+	}
+
+	file := filepath.Join(t.TempDir(), "testdata", "r.golden")
+	CheckGolden(t, file, true, docs, cases)
+	CheckGolden(t, file, false, docs, cases)
+
+	doc.FirstChild.FirstChild.FirstChild.Data = "3"
+	et := &errorsT{TB: t}
+	CheckGolden(et, file, false, docs, append(cases[1:3], GoldenCase{Doc: "r", Expr: "count(//a)"}))
+	wantErrors := []string{
+		"ba8f78bc75910c98 sum(//a) on r: number 3, was number 1",
+		"8e525c45b6d79343 count(//a) on r: not in " + file,
+		"5c8a2365a30a1b8d //a on r: in " + file + ", not a case",
+		"c7c385831d71980e //a[ on r: in " + file + ", not a case",
+	}
+	if !reflect.DeepEqual(et.errors, wantErrors) {
+		t.Errorf("CheckGolden errors = %q, want %q", et.errors, wantErrors)
+	}
+}
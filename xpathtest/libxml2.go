@@ -0,0 +1,178 @@
+//go:build libxml2 && cgo
+// +build libxml2,cgo
+
+package xpathtest
+
+/*
+#cgo pkg-config: libxml-2.0
+#include <stdlib.h>
+#include <libxml/parser.h>
+#include <libxml/xpath.h>
+#include <libxml/xpathInternals.h>
+
+static void silence(void *ctx, const char *msg, ...) {}
+
+static void init_libxml2(void) {
+	xmlInitParser();
+	xmlSetGenericErrorFunc(NULL, silence);
+}
+
+static xmlNodePtr node_at(xmlNodeSetPtr s, int i) {
+	return s->nodeTab[i];
+}
+
+static void free_string(xmlChar *s) {
+	xmlFree(s);
+}
+
+// dump_node returns the serialization of n, without the XML declaration
+// for the document node.
+static xmlChar *dump_node(xmlDocPtr doc, xmlNodePtr n) {
+	xmlBufferPtr buf = xmlBufferCreate();
+	if (n->type == XML_DOCUMENT_NODE) {
+		for (xmlNodePtr c = n->children; c != NULL; c = c->next) {
+			xmlNodeDump(buf, doc, c, 0, 0);
+		}
+	} else {
+		xmlNodeDump(buf, doc, n, 0, 0);
+	}
+	xmlChar *s = xmlStrdup(xmlBufferContent(buf));
+	xmlBufferFree(buf);
+	return s;
+}
+
+static int node_count(xmlNodeSetPtr s) {
+	return s == NULL ? 0 : s->nodeNr;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+var initLibxml2 sync.Once
+
+// Libxml2 returns an Oracle that evaluates the expressions with libxml2
+// through cgo, without starting a process per expression. Without the
+// libxml2 build tag, it returns nil.
+func Libxml2() Oracle {
+	initLibxml2.Do(func() { C.init_libxml2() })
+	return libxml2{}
+}
+
+type libxml2 struct{}
+
+func (libxml2) Name() string { return "libxml2" }
+
+func (libxml2) Evaluate(doc []byte, expr string, namespaces map[string]string) (Result, error) {
+	if len(doc) == 0 {
+		return Result{}, errors.New("xpathtest: libxml2: empty document")
+	}
+	d := C.xmlReadMemory((*C.char)(unsafe.Pointer(&doc[0])), C.int(len(doc)), nil, nil, C.XML_PARSE_NONET)
+	if d == nil {
+		return Result{}, errors.New("xpathtest: libxml2: document not well-formed")
+	}
+	defer C.xmlFreeDoc(d)
+	ctx := C.xmlXPathNewContext(d)
+	defer C.xmlXPathFreeContext(ctx)
+	ctx.node = (C.xmlNodePtr)(unsafe.Pointer(d))
+	for prefix, uri := range namespaces {
+		if prefix == "" {
+			continue
+		}
+		cprefix, curi := C.CString(prefix), C.CString(uri)
+		C.xmlXPathRegisterNs(ctx, (*C.xmlChar)(unsafe.Pointer(cprefix)), (*C.xmlChar)(unsafe.Pointer(curi)))
+		C.free(unsafe.Pointer(cprefix))
+		C.free(unsafe.Pointer(curi))
+	}
+
+	cexpr := C.CString(expr)
+	defer C.free(unsafe.Pointer(cexpr))
+	obj := C.xmlXPathEvalExpression((*C.xmlChar)(unsafe.Pointer(cexpr)), ctx)
+	if obj == nil {
+		return Result{}, ErrRejected
+	}
+	defer C.xmlXPathFreeObject(obj)
+
+	var r Result
+	if obj._type == C.XPATH_NODESET {
+		r.NodeSet = true
+		for i := 0; i < int(C.node_count(obj.nodesetval)); i++ {
+			n := C.node_at(obj.nodesetval, C.int(i))
+			r.Nodes = append(r.Nodes, nodePath(n))
+			// Attributes are serialized after a space.
+			r.Fragments = append(r.Fragments, strings.TrimPrefix(goString(C.dump_node(d, n)), " "))
+		}
+	}
+	r.String = goString(C.xmlXPathCastToString(obj))
+	return r, nil
+}
+
+// goString returns the string s allocated by libxml2, and frees it.
+func goString(s *C.xmlChar) string {
+	defer C.free_string(s)
+	return C.GoString((*C.char)(unsafe.Pointer(s)))
+}
+
+// nodePath returns the xpath.NodePath of the libxml2 node n.
+func nodePath(n C.xmlNodePtr) string {
+	var steps []string
+	for ; n != nil && n._type != C.XML_DOCUMENT_NODE; n = n.parent {
+		steps = append(steps, pathStep(n))
+	}
+	if len(steps) == 0 {
+		return "/"
+	}
+	var b strings.Builder
+	for i := len(steps) - 1; i >= 0; i-- {
+		b.WriteString("/" + steps[i])
+	}
+	return b.String()
+}
+
+// pathStep is the xpath.NodePath step of n, whose siblings of the same
+// name or type it is counted among.
+func pathStep(n C.xmlNodePtr) string {
+	var test string
+	switch nodeKind(n) {
+	case C.XML_ATTRIBUTE_NODE:
+		return "@" + qualifiedName(n)
+	case C.XML_TEXT_NODE:
+		test = "text()"
+	case C.XML_COMMENT_NODE:
+		test = "comment()"
+	default:
+		test = qualifiedName(n)
+	}
+	i := 1
+	for sib := n.prev; sib != nil; sib = sib.prev {
+		if nodeKind(sib) == nodeKind(n) && (n._type != C.XML_ELEMENT_NODE || qualifiedName(sib) == test) {
+			i++
+		}
+	}
+	return test + "[" + strconv.Itoa(i) + "]"
+}
+
+// nodeKind is the type of n, CDATA sections being text nodes.
+func nodeKind(n C.xmlNodePtr) C.xmlElementType {
+	if n._type == C.XML_CDATA_SECTION_NODE {
+		return C.XML_TEXT_NODE
+	}
+	return n._type
+}
+
+// qualifiedName is the name of the element or attribute n, with its
+// prefix.
+func qualifiedName(n C.xmlNodePtr) string {
+	name := C.GoString((*C.char)(unsafe.Pointer(n.name)))
+	// The namespace of xmlAttr is at the same offset as that of xmlNode.
+	if n.ns != nil && n.ns.prefix != nil {
+		return C.GoString((*C.char)(unsafe.Pointer(n.ns.prefix))) + ":" + name
+	}
+	return name
+}
@@ -0,0 +1,163 @@
+package xpathtest
+
+import (
+	"errors"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// A Failure reports whether the expression expr on the document doc
+// exhibits a failure, such as a mismatch with an oracle or a panic.
+type Failure func(doc *dom.Node, expr string) bool
+
+// Minimize shrinks a failing case, the document doc and the expression
+// expr, to a small case that still fails, for a bug report: it removes
+// the subtrees and the attributes of the document, replaces its elements
+// by their children and empties its text nodes, and replaces expr by its
+// xpath.Reductions, as long as fails still reports a failure. Unlike the
+// shrinking of rapid, it needs no generator, so it reduces the cases
+// reported from production too:
+//
+//	doc, expr = xpathtest.Minimize(doc, expr, xpathtest.Panics(nil))
+//
+// The document of the result is a copy; doc is not changed. If the case
+// does not fail, Minimize returns it unchanged.
+func Minimize(doc *dom.Node, expr string, fails Failure) (*dom.Node, string) {
+	if !fails(doc, expr) {
+		return doc, expr
+	}
+	for changed := true; changed; {
+		changed = false
+		if list, err := xpath.Reductions(expr); err == nil {
+			for _, e := range list {
+				if fails(doc, e) {
+					expr, changed = e, true
+					break
+				}
+			}
+		}
+		// The edits are in document order, so that the subtrees are
+		// removed before their descendants are tried. After an edit, the
+		// next one has the same index.
+		for i := 0; ; {
+			d := editDocument(doc, i)
+			if d == nil {
+				break
+			}
+			if fails(d, expr) {
+				doc, changed = d, true
+			} else {
+				i++
+			}
+		}
+	}
+	return doc, expr
+}
+
+// editDocument returns a copy of doc with its edit i applied, or nil if
+// doc has fewer edits. The edits of each node, in document order, are its
+// removal, the removal of each attribute but the namespace declarations,
+// the replacement of an element by its children, and the emptying of a
+// text node.
+func editDocument(doc *dom.Node, i int) *dom.Node {
+	var copyNode func(n *dom.Node, parent *dom.Node)
+	copyNode = func(n *dom.Node, parent *dom.Node) {
+		if n != doc {
+			if i == 0 {
+				// Removed.
+				i--
+				return
+			}
+			i--
+		}
+		c := &dom.Node{Type: n.Type, Data: n.Data, Prefix: n.Prefix, NamespaceURI: n.NamespaceURI}
+		for _, a := range n.Attr {
+			if !isNamespaceDecl(a) {
+				if i == 0 {
+					i--
+					continue
+				}
+				i--
+			}
+			c.Attr = append(c.Attr, a)
+		}
+		unwrap := false
+		switch {
+		case n.Type == dom.ElementNode && n.FirstChild != nil:
+			unwrap = i == 0
+			i--
+		case n.Type == dom.TextNode && n.Data != "":
+			if i == 0 {
+				c.Data = ""
+			}
+			i--
+		}
+		if unwrap {
+			c = parent
+		} else if parent != nil {
+			parent.AppendChild(c)
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			copyNode(child, c)
+		}
+	}
+	root := &dom.Node{Type: doc.Type}
+	for child := doc.FirstChild; child != nil; child = child.NextSibling {
+		copyNode(child, root)
+	}
+	if i >= 0 {
+		return nil
+	}
+	return root
+}
+
+func isNamespaceDecl(a dom.Attr) bool {
+	return a.Prefix == "xmlns" || a.Prefix == "" && a.Name == "xmlns"
+}
+
+// Panics returns the Failure of the expressions whose evaluation panics,
+// the prefixes being bound to the URIs of namespaces. The expressions
+// that do not compile do not fail.
+func Panics(namespaces map[string]string) Failure {
+	return func(doc *dom.Node, expr string) bool {
+		exp, err := xpath.CompileWithNS(expr, namespaces)
+		if err != nil {
+			return false
+		}
+		v, err := exp.EvaluateWithOptions(dom.CreateNavigator(doc), xpath.EvalOptions{RecoverPanics: true})
+		if iter, ok := v.(*xpath.NodeIterator); ok && err == nil {
+			for iter.MoveNext() {
+			}
+			err = iter.Err()
+		}
+		var evalErr *xpath.EvalError
+		return errors.As(err, &evalErr)
+	}
+}
+
+// Mismatch returns the Failure of the expressions whose Result differs
+// from that of the oracle o, as Diff reports it, the prefixes being bound
+// to the URIs of namespaces. The document is serialized for o and parsed
+// back, as the differential tests do. The expressions that o rejects or
+// that panic do not fail.
+func Mismatch(o Oracle, namespaces map[string]string) Failure {
+	return func(doc *dom.Node, expr string) (failed bool) {
+		defer func() {
+			if recover() != nil {
+				failed = false
+			}
+		}()
+		data := []byte(doc.OutputXML(false))
+		parsed, err := dom.ParseBytes(data)
+		if err != nil {
+			return false
+		}
+		want, err := o.Evaluate(data, expr, namespaces)
+		if err != nil {
+			return false
+		}
+		got, err := Evaluate(parsed, expr, namespaces)
+		return err != nil || Diff(want, got) != ""
+	}
+}
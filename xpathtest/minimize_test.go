@@ -0,0 +1,72 @@
+package xpathtest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/antchfx/xpath/dom"
+)
+
+// fakeOracle returns the Result of Evaluate, without the nodes named c.
+type fakeOracle struct{}
+
+func (fakeOracle) Name() string { return "fake" }
+
+func (fakeOracle) Evaluate(data []byte, expr string, namespaces map[string]string) (Result, error) {
+	doc, err := dom.ParseBytes(data)
+	if err != nil {
+		return Result{}, err
+	}
+	r, err := Evaluate(doc, expr, namespaces)
+	var nodes, fragments []string
+	for i, path := range r.Nodes {
+		if !strings.HasSuffix(path, "/c[1]") {
+			nodes = append(nodes, path)
+			fragments = append(fragments, r.Fragments[i])
+		}
+	}
+	r.Nodes, r.Fragments = nodes, fragments
+	return r, err
+}
+
+func TestMinimize(t *testing.T) {
+	const data = `<r x="1"><a y="2">t<b/></a><!--z--><c>x</c><b>2</b></r>`
+	doc, err := dom.Parse(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		expr  string
+		fails Failure
+		doc   string
+		want  string
+	}{
+		{"//a[@y = 2]/b | //c", func(doc *dom.Node, expr string) bool {
+			r, err := Evaluate(doc, expr, nil)
+			return err == nil && len(r.Nodes) > 0 && strings.HasSuffix(r.Nodes[0], "/b[1]")
+		}, `<b/>`, `b`},
+		{"//b | //c", Mismatch(fakeOracle{}, nil), `<c/>`, `c`},
+		{"//b", Mismatch(fakeOracle{}, nil), data, "//b"},
+	} {
+		d, expr := Minimize(doc, test.expr, test.fails)
+		if got := d.OutputXML(false); got != test.doc || expr != test.want {
+			t.Errorf("Minimize(%s) = %s, %s, want %s, %s", test.expr, got, expr, test.doc, test.want)
+		}
+	}
+	if got := doc.OutputXML(false); got != data {
+		t.Errorf("Minimize changed the document to %s", got)
+	}
+}
+
+func TestPanics(t *testing.T) {
+	doc, err := dom.Parse(strings.NewReader(`<r><a/></r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fails := Panics(map[string]string{"p": "urn:p"})
+	for _, expr := range []string{"//a", "//p:a", "count(//a) + 1", "//a[", "sum(//a) | //a"} {
+		if fails(doc, expr) {
+			t.Errorf("Panics(%s) = true", expr)
+		}
+	}
+}
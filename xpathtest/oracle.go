@@ -0,0 +1,280 @@
+package xpathtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// An Oracle is another XPath 1.0 engine, whose results the differential
+// tests compare with those of the xpath package.
+type Oracle interface {
+	// Name is the name of the engine, for the test messages.
+	Name() string
+	// Evaluate returns the value of the expression expr on the XML
+	// document doc, with the document node as the context node. The
+	// prefixes of expr are bound to the URIs of namespaces, as by
+	// xpath.CompileWithNS; the prefix "" is ignored. If the engine does not
+	// accept expr, the error is ErrRejected.
+	Evaluate(doc []byte, expr string, namespaces map[string]string) (Result, error)
+}
+
+// ErrRejected is the error of Oracle.Evaluate for the expressions that
+// are not valid for the engine, such as the calls of functions it does
+// not have, or whose value it cannot return.
+var ErrRejected = errors.New("xpathtest: expression rejected")
+
+// A Result is the value of an expression computed by an Oracle.
+type Result struct {
+	// NodeSet reports whether the value is a node-set, in which case Nodes
+	// are the xpath.NodePath of its nodes, in document order. Oracles
+	// without access to the nodes, such as Xmllint, return no node-sets.
+	NodeSet bool
+	Nodes   []string
+	// Fragments are the XML serializations of the nodes of a node-set, as
+	// dom.Node.OutputXML gives them, if the oracle has them.
+	Fragments []string
+	// String is the value converted by the string function: for a
+	// node-set, the string-value of its first node.
+	String string
+}
+
+// DefaultOracle returns Libxml2 if the package is built with the libxml2
+// build tag, or else Xmllint. It returns nil if neither is available.
+func DefaultOracle() Oracle {
+	if o := Libxml2(); o != nil {
+		return o
+	}
+	return Xmllint()
+}
+
+// Xmllint returns an Oracle that runs the xmllint command of libxml2 for
+// each expression, or nil if it is not in the PATH. Its results are the
+// string of the values only, never node-sets.
+//
+// The expressions xmllint cannot evaluate, such as those with a syntax
+// error, are ErrRejected: it exits with the status 10 and prints an
+// "XPath error". Before libxml2 2.13, it exited with the same status for
+// the empty strings, without the error; since then, they are a blank
+// line.
+//
+// The --xpath option of xmllint binds no prefixes: the expressions with
+// namespaces are evaluated in its shell, after the setns command. The
+// shell prints the first 40 bytes of the strings, with their blanks as
+// spaces; the longer strings are ErrRejected. It does not sort the
+// elements and the other nodes in document order: the node-sets with both
+// are ErrRejected too.
+func Xmllint() Oracle {
+	path, err := exec.LookPath("xmllint")
+	if err != nil {
+		return nil
+	}
+	return xmllint(path)
+}
+
+type xmllint string
+
+func (xmllint) Name() string { return "xmllint" }
+
+func (path xmllint) Evaluate(doc []byte, expr string, namespaces map[string]string) (Result, error) {
+	for prefix := range namespaces {
+		if prefix != "" {
+			return path.shell(doc, expr, namespaces)
+		}
+	}
+	cmd := exec.Command(string(path), "--xpath", "string("+expr+")", "-")
+	cmd.Stdin = bytes.NewReader(doc)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		switch exitErr.ExitCode() {
+		case 10:
+			// An error of the expression, or the exit status of the
+			// empty results before libxml2 2.13, which print no error.
+			if strings.Contains(stderr.String(), "XPath error") {
+				return Result{}, ErrRejected
+			}
+			err = nil
+		case 11:
+			return Result{}, ErrRejected
+		}
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("xpathtest: xmllint %q: %v: %s", expr, err, stderr.String())
+	}
+	return Result{String: strings.TrimSuffix(stdout.String(), "\n")}, nil
+}
+
+// shellString and shellBoolean are what the xmllint shell prints before
+// a string and a boolean value.
+const (
+	shellString  = "Object is a string : "
+	shellBoolean = "Object is a Boolean : "
+)
+
+// shellLine is the length of the commands the xmllint shell reads whole.
+const shellLine = 500
+
+// shell evaluates expr with the commands of the xmllint shell, which
+// reads the document from a file and the commands from stdin.
+func (path xmllint) shell(doc []byte, expr string, namespaces map[string]string) (Result, error) {
+	if strings.ContainsAny(expr, "\r\n") {
+		// A command is a line.
+		return Result{}, ErrRejected
+	}
+	var commands strings.Builder
+	for _, prefix := range keys(namespaces) {
+		if prefix != "" {
+			fmt.Fprintf(&commands, "setns %s=%s\n", prefix, namespaces[prefix])
+		}
+	}
+	for _, command := range []string{
+		fmt.Sprintf("xpath string(%s)", expr),
+		fmt.Sprintf("xpath boolean((%[1]s)/self::*) and boolean((%[1]s)/self::node()[not(self::*)])", expr),
+	} {
+		if len(command) >= shellLine {
+			// The shell cuts the longer lines.
+			return Result{}, ErrRejected
+		}
+		commands.WriteString(command + "\n")
+	}
+	f, err := os.CreateTemp("", "xpathtest-*.xml")
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(doc)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Result{}, err
+	}
+
+	cmd := exec.Command(string(path), "--shell", f.Name())
+	cmd.Stdin = strings.NewReader(commands.String())
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return Result{}, fmt.Errorf("xpathtest: xmllint --shell %q: %v: %s", expr, err, stderr.String())
+	}
+
+	// The output is the prompts, and the value of the string on a line,
+	// or "Object is empty (NULL)" if the expression is not valid, then
+	// whether it is a node-set with elements and other nodes.
+	out := stdout.String()
+	if strings.Contains(out, shellBoolean+"true") {
+		return Result{}, ErrRejected
+	}
+	i := strings.Index(out, shellString)
+	if i < 0 {
+		return Result{}, ErrRejected
+	}
+	s := out[i+len(shellString):]
+	if j := strings.IndexByte(s, '\n'); j >= 0 {
+		s = s[:j]
+	}
+	if len(s) >= 43 && strings.HasSuffix(s, "...") {
+		// The string is truncated.
+		return Result{}, ErrRejected
+	}
+	return Result{String: s}, nil
+}
+
+// Evaluate returns the Result of the xpath package for the expression
+// expr on the document doc, to compare with those of the oracles, the
+// prefixes of expr being bound to the URIs of namespaces. The document
+// should be parsed from the XML given to the oracles, which have no empty
+// or adjacent text nodes. The infinities are the strings of
+// xpath.XPathNumberStrings.
+func Evaluate(doc *dom.Node, expr string, namespaces map[string]string) (Result, error) {
+	opts := xpath.CompileOptions{Namespaces: namespaces, NumberStrings: xpath.XPathNumberStrings}
+	exp, err := xpath.CompileWithOptions(expr, opts)
+	if err != nil {
+		return Result{}, err
+	}
+	str, err := xpath.CompileWithOptions("string("+expr+")", opts)
+	if err != nil {
+		return Result{}, err
+	}
+	var r Result
+	r.String = str.Evaluate(dom.CreateNavigator(doc)).(string)
+	iter, ok := exp.Evaluate(dom.CreateNavigator(doc)).(*xpath.NodeIterator)
+	if !ok {
+		return r, nil
+	}
+	r.NodeSet = true
+	for iter.MoveNext() {
+		r.Nodes = append(r.Nodes, xpath.NodePath(iter.Current()))
+	}
+	nodes, err := dom.Select(doc, exp)
+	if err != nil {
+		return Result{}, err
+	}
+	for _, n := range nodes {
+		r.Fragments = append(r.Fragments, n.OutputXML(true))
+	}
+	return r, nil
+}
+
+// Diff returns the differences between the Result want, such as that of
+// an Oracle, and the Result got, such as that of Evaluate, one per line,
+// or "" if there are none. The node-sets are compared by the number of
+// nodes, by the paths of the nodes missing, extra or out of order, as
+// given by xpath.DiffPaths, and by the serializations of the nodes, if
+// both results have them. The strings of numbers are compared to 15
+// significant digits, which libxml2 prints.
+func Diff(want, got Result) string {
+	var b strings.Builder
+	if got.String != want.String && !sameNumber(got.String, want.String) {
+		fmt.Fprintf(&b, "string %q, want %q\n", got.String, want.String)
+	}
+	switch {
+	case !want.NodeSet:
+		return b.String()
+	case !got.NodeSet:
+		fmt.Fprintf(&b, "not a node-set, want %d nodes\n", len(want.Nodes))
+		return b.String()
+	}
+	if len(got.Nodes) != len(want.Nodes) {
+		fmt.Fprintf(&b, "%d nodes, want %d\n", len(got.Nodes), len(want.Nodes))
+	}
+	if d := xpath.DiffPaths(want.Nodes, got.Nodes); !d.Empty() {
+		b.WriteString(d.String())
+		return b.String()
+	}
+	if want.Fragments != nil && got.Fragments != nil {
+		for i, path := range got.Nodes {
+			if got.Fragments[i] != want.Fragments[i] {
+				fmt.Fprintf(&b, "node %s is %s, want %s\n", path, got.Fragments[i], want.Fragments[i])
+			}
+		}
+	}
+	return b.String()
+}
+
+// sameNumber reports whether a and b are the strings of two finite numbers
+// equal to 15 significant digits.
+func sameNumber(a, b string) bool {
+	x, err := strconv.ParseFloat(a, 64)
+	if err != nil || math.IsInf(x, 0) || math.IsNaN(x) {
+		return false
+	}
+	y, err := strconv.ParseFloat(b, 64)
+	if err != nil || math.IsInf(y, 0) || math.IsNaN(y) {
+		return false
+	}
+	return strconv.FormatFloat(x, 'g', 15, 64) == strconv.FormatFloat(y, 'g', 15, 64)
+}
@@ -0,0 +1,148 @@
+package xpathtest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/xpath/dom"
+)
+
+func TestOracle(t *testing.T) {
+	oracle := DefaultOracle()
+	if oracle == nil {
+		t.Skip("xmllint command not found in PATH and libxml2 build tag not set")
+	}
+	data := []byte(`<r xmlns:a="urn:a"><a:b id="1">x</a:b><b/>y<!--c--><b id="2">z</b></r>`)
+	doc, err := dom.ParseBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expr := range []string{"//*", "//@id", "/r/text() | //comment()", "/", "count(//b)", "//b[2]", "//missing"} {
+		want, err := oracle.Evaluate(data, expr, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		got, err := Evaluate(doc, expr, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		if d := Diff(want, got); d != "" {
+			t.Errorf("%s:\n%s", expr, d)
+		}
+	}
+
+	if _, err := oracle.Evaluate(data, "//b[", nil); !errors.Is(err, ErrRejected) {
+		t.Errorf("//b[: error %v, want ErrRejected", err)
+	}
+}
+
+func TestOracleNamespaces(t *testing.T) {
+	oracle := DefaultOracle()
+	if oracle == nil {
+		t.Skip("xmllint command not found in PATH and libxml2 build tag not set")
+	}
+	data := []byte(`<r xmlns="urn:d" xmlns:a="urn:a"><a:b a:id="1">x</a:b><b>y</b><c xmlns="">z</c></r>`)
+	doc, err := dom.ParseBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := map[string]string{"x": "urn:a", "d": "urn:d"}
+
+	for _, test := range []struct {
+		expr, want string
+	}{
+		{"//x:b", "x"},
+		{"//@x:id", "1"},
+		{"//d:b", "y"},
+		{"count(//d:*)", "2"},
+		{"//c", "z"},
+	} {
+		want, err := oracle.Evaluate(data, test.expr, namespaces)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expr, err)
+		}
+		if want.String != test.want {
+			t.Errorf("%s: %s got %q, want %q", test.expr, oracle.Name(), want.String, test.want)
+		}
+		got, err := Evaluate(doc, test.expr, namespaces)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expr, err)
+		}
+		if d := Diff(want, got); d != "" {
+			t.Errorf("%s:\n%s", test.expr, d)
+		}
+	}
+
+	if _, err := oracle.Evaluate(data, "//y:b", namespaces); !errors.Is(err, ErrRejected) {
+		t.Errorf("//y:b: error %v, want ErrRejected", err)
+	}
+	// The shell of xmllint does not sort the elements with the text nodes.
+	if o := Xmllint(); o != nil {
+		if _, err := o.Evaluate(data, "//x:b/text() | //x:b", namespaces); !errors.Is(err, ErrRejected) {
+			t.Errorf("//x:b/text() | //x:b: error %v, want ErrRejected", err)
+		}
+		// The shell cuts the commands of the longer expressions.
+		long := "//x:b" + strings.Repeat(" | //x:b", 40)
+		if _, err := o.Evaluate(data, long, namespaces); !errors.Is(err, ErrRejected) {
+			t.Errorf("%s: error %v, want ErrRejected", long, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	doc, err := dom.ParseBytes([]byte(`<r><b id="1">x</b><b id="2">x</b></r>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Evaluate(doc, "//b | //@id", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{
+		NodeSet:   true,
+		Nodes:     []string{"/r[1]/b[1]", "/r[1]/b[1]/@id", "/r[1]/b[2]", "/r[1]/b[2]/@id"},
+		Fragments: []string{`<b id="1">x</b>`, `id="1"`, `<b id="2">x</b>`, `id="2"`},
+		String:    "x",
+	}
+	for _, test := range []struct {
+		want Result
+		diff string
+	}{
+		{want, ""},
+		// The first node has the same string value: only the node-sets
+		// differ.
+		{Result{NodeSet: true, Nodes: want.Nodes[:1], String: "x"}, "4 nodes, want 1\nextra /r[1]/b[1]/@id\nextra /r[1]/b[2]\nextra /r[1]/b[2]/@id\n"},
+		{Result{NodeSet: true, Nodes: []string{want.Nodes[2], want.Nodes[0], want.Nodes[1], want.Nodes[3]}, String: "x"}, "moved /r[1]/b[2]\n"},
+		{Result{NodeSet: true, Nodes: want.Nodes, Fragments: []string{`<b id="1">x</b>`, `id="1"`, `<b id="2">y</b>`, `id="2"`}, String: "x"}, "node /r[1]/b[2] is <b id=\"2\">x</b>, want <b id=\"2\">y</b>\n"},
+		{Result{String: "y"}, "string \"x\", want \"y\"\n"},
+	} {
+		if d := Diff(test.want, got); d != test.diff {
+			t.Errorf("Diff(%v) = %q, want %q", test.want, d, test.diff)
+		}
+	}
+
+	got, err = Evaluate(doc, "count(//b)", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := Diff(want, got); d != "string \"2\", want \"x\"\nnot a node-set, want 4 nodes\n" {
+		t.Errorf("Diff = %q", d)
+	}
+
+	for _, test := range []struct {
+		want, got string
+		same      bool
+	}{
+		{"-10.6666666666667", "-10.666666666666666", true},
+		{"3.22322322322322e+17", "3.223223223223223e+17", true},
+		{"0.5", "0.6", false},
+		{"Infinity", "+Inf", false},
+		{"NaN", "NaN", true},
+	} {
+		if d := Diff(Result{String: test.want}, Result{String: test.got}); (d == "") != test.same {
+			t.Errorf("Diff(%q, %q) = %q", test.want, test.got, d)
+		}
+	}
+}
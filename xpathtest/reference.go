@@ -0,0 +1,1178 @@
+package xpathtest
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/antchfx/xpath/dom"
+)
+
+// Reference returns an Oracle that evaluates the expressions with the
+// interpreter of this package, a direct reading of the XPath 1.0
+// recommendation written independently of the xpath package: it has its
+// own parser, and walks the nodes of dom.Node trees rather than
+// navigators. It is always available, so that the differential tests can
+// run when neither libxml2 nor xmllint is, with the -reference flag of the
+// tests of the xpath package. It is slow, and is not meant for anything but
+// testing.
+//
+// The interpreter has the functions of XPath 1.0 only, and rejects the
+// others; id returns no nodes, as the documents have no DTD. The
+// processing instructions are nodes, unlike in the navigators of dom. The
+// name tests of no prefix select the names in no namespace, as the
+// recommendation says. Variables are rejected, as the Oracle interface
+// binds none.
+func Reference() Oracle {
+	return reference{}
+}
+
+type reference struct{}
+
+func (reference) Name() string { return "reference" }
+
+func (reference) Evaluate(doc []byte, expr string, namespaces map[string]string) (r Result, err error) {
+	d, err := dom.ParseBytes(doc)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(refError); !ok {
+				panic(e)
+			}
+			r, err = Result{}, ErrRejected
+		}
+	}()
+	p := &refParser{namespaces: namespaces}
+	p.tokens = refLex(expr)
+	e := p.expr()
+	if p.peek().kind != tokEOF {
+		p.fail("unexpected %q", p.peek().text)
+	}
+
+	root := newRefTree(d)
+	v := e.eval(refContext{node: root, pos: 1, size: 1})
+	r.String = refString(v)
+	if nodes, ok := v.(refNodeSet); ok {
+		r.NodeSet = true
+		for _, n := range nodes {
+			r.Nodes = append(r.Nodes, n.path())
+			r.Fragments = append(r.Fragments, n.fragment())
+		}
+	}
+	return r, nil
+}
+
+// refError is the panic of the interpreter for the expressions it rejects,
+// such as syntax errors or arguments of the wrong type.
+type refError string
+
+func refFail(format string, args ...interface{}) {
+	panic(refError(fmt.Sprintf(format, args...)))
+}
+
+// The tree
+
+// A refNode is a node of the data model: a dom.Node, or one of its
+// attributes.
+type refNode struct {
+	n        *dom.Node
+	attr     int // the index in n.Attr, or -1
+	parent   *refNode
+	children []*refNode
+	attrs    []*refNode
+	order    int // in document order
+	all      []*refNode
+}
+
+// newRefTree returns the document node of the tree of d.
+func newRefTree(d *dom.Node) *refNode {
+	var all []*refNode
+	var build func(n *dom.Node, parent *refNode) *refNode
+	build = func(n *dom.Node, parent *refNode) *refNode {
+		r := &refNode{n: n, attr: -1, parent: parent, order: len(all)}
+		all = append(all, r)
+		for i, a := range n.Attr {
+			if !isNamespaceDecl(a) {
+				ra := &refNode{n: n, attr: i, parent: r, order: len(all)}
+				all = append(all, ra)
+				r.attrs = append(r.attrs, ra)
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			r.children = append(r.children, build(c, r))
+		}
+		return r
+	}
+	root := build(d, nil)
+	for _, n := range all {
+		n.all = all
+	}
+	return root
+}
+
+// kind is the type of the node in the data model.
+func (n *refNode) kind() dom.NodeType {
+	if n.attr >= 0 {
+		return dom.AttributeNode
+	}
+	switch n.n.Type {
+	case dom.DocumentNode:
+		return dom.DocumentNode
+	case dom.TextNode, dom.CDATASectionNode:
+		return dom.TextNode
+	case dom.CommentNode:
+		return dom.CommentNode
+	case dom.ProcessingInstructionNode:
+		return dom.ProcessingInstructionNode
+	}
+	return dom.ElementNode
+}
+
+// name returns the prefix, the local name and the namespace URI of the
+// expanded name of the node, the target for a processing instruction.
+func (n *refNode) name() (prefix, local, uri string) {
+	switch n.kind() {
+	case dom.AttributeNode:
+		a := n.n.Attr[n.attr]
+		return a.Prefix, a.Name, a.NamespaceURI
+	case dom.ElementNode:
+		return n.n.Prefix, n.n.Data, n.n.NamespaceURI
+	case dom.ProcessingInstructionNode:
+		target, _, _ := strings.Cut(n.n.Data, " ")
+		return "", target, ""
+	}
+	return "", "", ""
+}
+
+func (n *refNode) qualifiedName() string {
+	prefix, local, _ := n.name()
+	if prefix == "" {
+		return local
+	}
+	return prefix + ":" + local
+}
+
+// value returns the string-value of the node.
+func (n *refNode) value() string {
+	switch n.kind() {
+	case dom.AttributeNode:
+		return n.n.Attr[n.attr].Value
+	case dom.TextNode, dom.CommentNode:
+		return n.n.Data
+	case dom.ProcessingInstructionNode:
+		_, content, _ := strings.Cut(n.n.Data, " ")
+		return content
+	}
+	var b strings.Builder
+	var text func(n *refNode)
+	text = func(n *refNode) {
+		if n.kind() == dom.TextNode {
+			b.WriteString(n.n.Data)
+		}
+		for _, c := range n.children {
+			text(c)
+		}
+	}
+	text(n)
+	return b.String()
+}
+
+// path returns the xpath.NodePath of the node.
+func (n *refNode) path() string {
+	var steps []string
+	for ; n.parent != nil; n = n.parent {
+		if n.kind() == dom.AttributeNode {
+			steps = append(steps, "@"+n.qualifiedName())
+			continue
+		}
+		test := n.qualifiedName()
+		switch n.kind() {
+		case dom.TextNode:
+			test = "text()"
+		case dom.CommentNode:
+			test = "comment()"
+		}
+		i := 1
+		for _, sib := range n.parent.children {
+			if sib == n {
+				break
+			}
+			if sib.kind() == n.kind() && (n.kind() != dom.ElementNode || sib.n.Prefix == n.n.Prefix && sib.n.Data == n.n.Data) {
+				i++
+			}
+		}
+		steps = append(steps, test+"["+strconv.Itoa(i)+"]")
+	}
+	if len(steps) == 0 {
+		return "/"
+	}
+	var b strings.Builder
+	for i := len(steps) - 1; i >= 0; i-- {
+		b.WriteString("/" + steps[i])
+	}
+	return b.String()
+}
+
+// fragment returns the serialization of the node, as Evaluate gives it.
+func (n *refNode) fragment() string {
+	if n.attr < 0 {
+		return n.n.OutputXML(true)
+	}
+	a := n.n.Attr[n.attr]
+	attr := &dom.Node{Type: dom.AttributeNode, Data: a.Name, Prefix: a.Prefix, NamespaceURI: a.NamespaceURI}
+	attr.AppendChild(dom.NewText(a.Value))
+	return attr.OutputXML(true)
+}
+
+// isAncestor reports whether n is an ancestor of m.
+func (n *refNode) isAncestor(m *refNode) bool {
+	for p := m.parent; p != nil; p = p.parent {
+		if p == n {
+			return true
+		}
+	}
+	return false
+}
+
+// axis returns the nodes of the axis from n, in the order of proximity:
+// the reverse document order for the reverse axes.
+func (n *refNode) axis(name string) []*refNode {
+	var list []*refNode
+	var descendants func(n *refNode)
+	descendants = func(n *refNode) {
+		for _, c := range n.children {
+			list = append(list, c)
+			descendants(c)
+		}
+	}
+	switch name {
+	case "child":
+		list = append(list, n.children...)
+	case "descendant":
+		descendants(n)
+	case "descendant-or-self":
+		list = append(list, n)
+		descendants(n)
+	case "parent":
+		if n.parent != nil {
+			list = append(list, n.parent)
+		}
+	case "ancestor", "ancestor-or-self":
+		if name == "ancestor-or-self" {
+			list = append(list, n)
+		}
+		for p := n.parent; p != nil; p = p.parent {
+			list = append(list, p)
+		}
+	case "following-sibling", "preceding-sibling":
+		if n.attr >= 0 || n.parent == nil {
+			break
+		}
+		sibs := n.parent.children
+		i := 0
+		for sibs[i] != n {
+			i++
+		}
+		if name == "following-sibling" {
+			list = append(list, sibs[i+1:]...)
+		} else {
+			for j := i - 1; j >= 0; j-- {
+				list = append(list, sibs[j])
+			}
+		}
+	case "following":
+		for _, m := range n.all[n.order+1:] {
+			if m.attr < 0 && !n.isAncestor(m) {
+				list = append(list, m)
+			}
+		}
+	case "preceding":
+		for i := n.order - 1; i >= 0; i-- {
+			if m := n.all[i]; m.attr < 0 && !m.isAncestor(n) {
+				list = append(list, m)
+			}
+		}
+	case "attribute":
+		list = append(list, n.attrs...)
+	case "self":
+		list = append(list, n)
+	default:
+		refFail("unsupported axis %s", name)
+	}
+	return list
+}
+
+// The values
+
+// A refNodeSet is a node-set, in document order without duplicates.
+type refNodeSet []*refNode
+
+// newRefNodeSet returns the nodes of list in document order, without the
+// duplicates.
+func newRefNodeSet(list []*refNode) refNodeSet {
+	seen := make(map[*refNode]bool, len(list))
+	set := refNodeSet{}
+	for _, n := range list {
+		if !seen[n] {
+			seen[n] = true
+			set = append(set, n)
+		}
+	}
+	sort.Slice(set, func(i, j int) bool { return set[i].order < set[j].order })
+	return set
+}
+
+func refString(v interface{}) string {
+	switch v := v.(type) {
+	case refNodeSet:
+		if len(v) == 0 {
+			return ""
+		}
+		return v[0].value()
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		case v == 0:
+			return "0"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return v.(string)
+}
+
+func refNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		return parseRefNumber(v)
+	}
+	return parseRefNumber(refString(v))
+}
+
+// parseRefNumber converts s as the number function: optional whitespace,
+// an optional minus sign, digits with an optional decimal point, and
+// optional whitespace, or else NaN.
+func parseRefNumber(s string) float64 {
+	s = strings.Trim(s, " \t\r\n")
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || digits == "." || strings.Count(digits, ".") > 1 || strings.Trim(digits, "0123456789.") != "" {
+		return math.NaN()
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func refBoolean(v interface{}) bool {
+	switch v := v.(type) {
+	case refNodeSet:
+		return len(v) > 0
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	}
+	return v.(bool)
+}
+
+func refNodes(v interface{}) refNodeSet {
+	nodes, ok := v.(refNodeSet)
+	if !ok {
+		refFail("%s is not a node-set", refString(v))
+	}
+	return nodes
+}
+
+// The lexer
+
+type refTokenKind int
+
+const (
+	tokEOF refTokenKind = iota
+	tokNumber
+	tokLiteral
+	tokName     // a name test, a function name, an axis name or a node type
+	tokOperator // including the operator names and *
+	tokSymbol   // ( ) [ ] . .. @ , :: $
+)
+
+type refToken struct {
+	kind refTokenKind
+	text string
+}
+
+// refLex splits expr into its tokens, telling the operator names and *
+// from the names by the token before them, as section 3.7 of the
+// recommendation does.
+func refLex(expr string) []refToken {
+	var tokens []refToken
+	isOperand := func() bool {
+		// The token before is not one of @ :: ( [ , or an operator.
+		if len(tokens) == 0 {
+			return false
+		}
+		last := tokens[len(tokens)-1]
+		switch {
+		case last.kind == tokOperator:
+			return false
+		case last.kind == tokSymbol:
+			return last.text == ")" || last.text == "]" || last.text == "." || last.text == ".."
+		}
+		return true
+	}
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+			continue
+		case c == '\'' || c == '"':
+			j := strings.IndexByte(expr[i+1:], c)
+			if j < 0 {
+				refFail("unterminated literal")
+			}
+			tokens = append(tokens, refToken{tokLiteral, expr[i+1 : i+1+j]})
+			i += j + 2
+			continue
+		case '0' <= c && c <= '9' || c == '.' && i+1 < len(expr) && '0' <= expr[i+1] && expr[i+1] <= '9':
+			j := i
+			for j < len(expr) && ('0' <= expr[j] && expr[j] <= '9' || expr[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, refToken{tokNumber, expr[i:j]})
+			i = j
+			continue
+		case c == '*':
+			if isOperand() {
+				tokens = append(tokens, refToken{tokOperator, "*"})
+			} else {
+				tokens = append(tokens, refToken{tokName, "*"})
+			}
+			i++
+			continue
+		}
+		if r, _ := utf8.DecodeRuneInString(expr[i:]); isNameStart(r) {
+			j := nameEnd(expr, i)
+			name := expr[i:j]
+			if isOperand() && (name == "and" || name == "or" || name == "mod" || name == "div") {
+				tokens = append(tokens, refToken{tokOperator, name})
+				i = j
+				continue
+			}
+			// A QName, or a prefix:* name test.
+			if j+1 < len(expr) && expr[j] == ':' && expr[j+1] != ':' {
+				if expr[j+1] == '*' {
+					name, j = expr[i:j+2], j+2
+				} else if r, _ := utf8.DecodeRuneInString(expr[j+1:]); isNameStart(r) {
+					k := nameEnd(expr, j+1)
+					name, j = expr[i:k], k
+				}
+			}
+			tokens = append(tokens, refToken{tokName, name})
+			i = j
+			continue
+		}
+		for _, s := range []string{"//", "!=", "<=", ">=", "::", "..", "/", "|", "+", "-", "=", "<", ">", "(", ")", "[", "]", ".", "@", ",", "$"} {
+			if strings.HasPrefix(expr[i:], s) {
+				kind := tokOperator
+				switch s {
+				case "(", ")", "[", "]", ".", "..", "@", ",", "$", "::":
+					kind = tokSymbol
+				}
+				tokens = append(tokens, refToken{kind, s})
+				i += len(s)
+				goto next
+			}
+		}
+		refFail("unexpected character %q", c)
+	next:
+	}
+	return append(tokens, refToken{kind: tokEOF})
+}
+
+func isNameStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// nameEnd returns the end of the NCName of expr at i.
+func nameEnd(expr string, i int) int {
+	for i < len(expr) {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+		if !(isNameStart(r) || r == '-' || r == '.' || unicode.IsDigit(r)) {
+			break
+		}
+		i += size
+	}
+	return i
+}
+
+// The parser and the evaluator
+
+type refContext struct {
+	node      *refNode
+	pos, size int
+}
+
+type refExpr interface {
+	eval(c refContext) interface{}
+}
+
+type refParser struct {
+	tokens     []refToken
+	i          int
+	namespaces map[string]string
+}
+
+func (p *refParser) fail(format string, args ...interface{}) {
+	refFail(format, args...)
+}
+
+func (p *refParser) peek() refToken {
+	return p.tokens[p.i]
+}
+
+func (p *refParser) peekAt(i int) refToken {
+	if p.i+i < len(p.tokens) {
+		return p.tokens[p.i+i]
+	}
+	return refToken{kind: tokEOF}
+}
+
+func (p *refParser) next() refToken {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// isSymbol reports whether t is the symbol s.
+func isSymbol(t refToken, s string) bool {
+	return t.kind == tokSymbol && t.text == s
+}
+
+// accept consumes the operator or symbol s, and reports whether it was
+// next.
+func (p *refParser) accept(s string) bool {
+	if t := p.peek(); (t.kind == tokOperator || t.kind == tokSymbol) && t.text == s {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *refParser) expect(s string) {
+	if !p.accept(s) {
+		p.fail("expected %q, found %q", s, p.peek().text)
+	}
+}
+
+type refBinary struct {
+	op          string
+	left, right refExpr
+}
+
+// binary parses the operands of the operators ops, with next parsing the
+// operands.
+func (p *refParser) binary(next func() refExpr, ops ...string) refExpr {
+	e := next()
+	for {
+		t := p.peek()
+		found := false
+		for _, op := range ops {
+			if t.kind == tokOperator && t.text == op {
+				found = true
+			}
+		}
+		if !found {
+			return e
+		}
+		p.next()
+		e = &refBinary{op: t.text, left: e, right: next()}
+	}
+}
+
+func (p *refParser) expr() refExpr {
+	return p.or()
+}
+
+func (p *refParser) or() refExpr { return p.binary(p.and, "or") }
+
+func (p *refParser) and() refExpr { return p.binary(p.equality, "and") }
+
+func (p *refParser) equality() refExpr { return p.binary(p.relational, "=", "!=") }
+
+func (p *refParser) relational() refExpr { return p.binary(p.additive, "<", "<=", ">", ">=") }
+
+func (p *refParser) additive() refExpr { return p.binary(p.multiplicative, "+", "-") }
+
+func (p *refParser) multiplicative() refExpr { return p.binary(p.unary, "*", "div", "mod") }
+
+type refNegation struct{ e refExpr }
+
+func (e *refNegation) eval(c refContext) interface{} {
+	return -refNumber(e.e.eval(c))
+}
+
+func (p *refParser) unary() refExpr {
+	if p.accept("-") {
+		return &refNegation{p.unary()}
+	}
+	return p.binary(p.path, "|")
+}
+
+func (e *refBinary) eval(c refContext) interface{} {
+	switch e.op {
+	case "or":
+		return refBoolean(e.left.eval(c)) || refBoolean(e.right.eval(c))
+	case "and":
+		return refBoolean(e.left.eval(c)) && refBoolean(e.right.eval(c))
+	case "|":
+		l, r := refNodes(e.left.eval(c)), refNodes(e.right.eval(c))
+		return newRefNodeSet(append(append([]*refNode(nil), l...), r...))
+	case "=", "!=", "<", "<=", ">", ">=":
+		return refCompare(e.op, e.left.eval(c), e.right.eval(c))
+	}
+	l, r := refNumber(e.left.eval(c)), refNumber(e.right.eval(c))
+	switch e.op {
+	case "+":
+		return l + r
+	case "-":
+		return l - r
+	case "*":
+		return l * r
+	case "div":
+		return l / r
+	}
+	return math.Mod(l, r)
+}
+
+// refCompare compares the values a and b by op, as section 3.4 of the
+// recommendation does.
+func refCompare(op string, a, b interface{}) bool {
+	if nodes, ok := a.(refNodeSet); ok {
+		if _, ok := b.(bool); ok {
+			return refCompare(op, len(nodes) > 0, b)
+		}
+		for _, n := range nodes {
+			if refCompare(op, n.value(), b) {
+				return true
+			}
+		}
+		return false
+	}
+	if nodes, ok := b.(refNodeSet); ok {
+		if _, ok := a.(bool); ok {
+			return refCompare(op, a, len(nodes) > 0)
+		}
+		for _, n := range nodes {
+			if refCompare(op, a, n.value()) {
+				return true
+			}
+		}
+		return false
+	}
+	if op == "=" || op == "!=" {
+		var equal bool
+		_, aBool := a.(bool)
+		_, bBool := b.(bool)
+		_, aNumber := a.(float64)
+		_, bNumber := b.(float64)
+		switch {
+		case aBool || bBool:
+			equal = refBoolean(a) == refBoolean(b)
+		case aNumber || bNumber:
+			equal = refNumber(a) == refNumber(b)
+		default:
+			equal = refString(a) == refString(b)
+		}
+		return equal == (op == "=")
+	}
+	x, y := refNumber(a), refNumber(b)
+	switch op {
+	case "<":
+		return x < y
+	case "<=":
+		return x <= y
+	case ">":
+		return x > y
+	}
+	return x >= y
+}
+
+// A refPath is a location path, or a filter expression followed by steps.
+type refPath struct {
+	filter   refExpr // nil for a location path
+	absolute bool
+	steps    []*refStep
+}
+
+type refStep struct {
+	axis  string
+	test  func(n *refNode) bool
+	preds []refExpr
+}
+
+// isStepStart reports whether the next token starts a step.
+func (p *refParser) isStepStart() bool {
+	t := p.peek()
+	switch t.kind {
+	case tokName:
+		return true
+	case tokSymbol:
+		return t.text == "." || t.text == ".." || t.text == "@"
+	}
+	return false
+}
+
+func (p *refParser) path() refExpr {
+	path := &refPath{}
+	t := p.peek()
+	switch {
+	case t.kind == tokOperator && t.text == "/":
+		p.next()
+		path.absolute = true
+		if !p.isStepStart() {
+			return path
+		}
+	case t.kind == tokOperator && t.text == "//":
+		p.next()
+		path.absolute = true
+		path.steps = append(path.steps, p.descendantOrSelf())
+	case t.kind == tokName && isSymbol(p.peekAt(1), "(") && !isNodeType(t.text),
+		t.kind == tokLiteral, t.kind == tokNumber,
+		t.kind == tokSymbol && (t.text == "(" || t.text == "$"):
+		path.filter = p.filter()
+		if !p.accept("/") {
+			if !p.accept("//") {
+				return path.filter
+			}
+			path.steps = append(path.steps, p.descendantOrSelf())
+		}
+	}
+	path.steps = append(path.steps, p.step())
+	for {
+		if p.accept("/") {
+		} else if p.accept("//") {
+			path.steps = append(path.steps, p.descendantOrSelf())
+		} else {
+			return path
+		}
+		path.steps = append(path.steps, p.step())
+	}
+}
+
+func (p *refParser) descendantOrSelf() *refStep {
+	return &refStep{axis: "descendant-or-self", test: func(*refNode) bool { return true }}
+}
+
+func isNodeType(name string) bool {
+	switch name {
+	case "comment", "text", "processing-instruction", "node":
+		return true
+	}
+	return false
+}
+
+var refAxes = map[string]bool{
+	"ancestor": true, "ancestor-or-self": true, "attribute": true, "child": true,
+	"descendant": true, "descendant-or-self": true, "following": true,
+	"following-sibling": true, "namespace": true, "parent": true,
+	"preceding": true, "preceding-sibling": true, "self": true,
+}
+
+func (p *refParser) step() *refStep {
+	any := func(*refNode) bool { return true }
+	if p.accept(".") {
+		return &refStep{axis: "self", test: any}
+	}
+	if p.accept("..") {
+		return &refStep{axis: "parent", test: any}
+	}
+	s := &refStep{axis: "child"}
+	if p.accept("@") {
+		s.axis = "attribute"
+	} else if t := p.peek(); t.kind == tokName && isSymbol(p.peekAt(1), "::") {
+		if !refAxes[t.text] {
+			p.fail("unknown axis %s", t.text)
+		}
+		s.axis = t.text
+		p.next()
+		p.next()
+	}
+	principal := dom.ElementNode
+	if s.axis == "attribute" {
+		principal = dom.AttributeNode
+	}
+
+	t := p.next()
+	if t.kind != tokName {
+		p.fail("expected a node test, found %q", t.text)
+	}
+	if isNodeType(t.text) && isSymbol(p.peek(), "(") {
+		p.next()
+		var target *string
+		if t.text == "processing-instruction" && p.peek().kind == tokLiteral {
+			s := p.next().text
+			target = &s
+		}
+		p.expect(")")
+		switch t.text {
+		case "node":
+			s.test = any
+		case "text":
+			s.test = func(n *refNode) bool { return n.kind() == dom.TextNode }
+		case "comment":
+			s.test = func(n *refNode) bool { return n.kind() == dom.CommentNode }
+		default:
+			s.test = func(n *refNode) bool {
+				if n.kind() != dom.ProcessingInstructionNode {
+					return false
+				}
+				_, local, _ := n.name()
+				return target == nil || local == *target
+			}
+		}
+	} else {
+		// The names of no prefix are in no namespace, and * is any name.
+		prefix, local, found := strings.Cut(t.text, ":")
+		uri := ""
+		if !found {
+			local = t.text
+		} else if uri, found = p.namespaces[prefix]; !found || prefix == "" {
+			p.fail("unbound prefix %s", prefix)
+		}
+		s.test = func(n *refNode) bool {
+			if n.kind() != principal {
+				return false
+			}
+			_, l, u := n.name()
+			return t.text == "*" || u == uri && (local == "*" || l == local)
+		}
+	}
+	for p.accept("[") {
+		s.preds = append(s.preds, p.expr())
+		p.expect("]")
+	}
+	return s
+}
+
+// filterNodes returns the nodes of list, in the order of the position of
+// pred, for which pred is true.
+func filterNodes(list []*refNode, pred refExpr) []*refNode {
+	var kept []*refNode
+	for i, n := range list {
+		v := pred.eval(refContext{node: n, pos: i + 1, size: len(list)})
+		if f, ok := v.(float64); ok {
+			if f == float64(i+1) {
+				kept = append(kept, n)
+			}
+		} else if refBoolean(v) {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+func (e *refPath) eval(c refContext) interface{} {
+	var nodes refNodeSet
+	switch {
+	case e.filter != nil:
+		nodes = refNodes(e.filter.eval(c))
+	case e.absolute:
+		root := c.node
+		for root.parent != nil {
+			root = root.parent
+		}
+		nodes = refNodeSet{root}
+	default:
+		nodes = refNodeSet{c.node}
+	}
+	for _, s := range e.steps {
+		var list []*refNode
+		for _, n := range nodes {
+			var selected []*refNode
+			for _, m := range n.axis(s.axis) {
+				if s.test(m) {
+					selected = append(selected, m)
+				}
+			}
+			for _, pred := range s.preds {
+				selected = filterNodes(selected, pred)
+			}
+			list = append(list, selected...)
+		}
+		nodes = newRefNodeSet(list)
+	}
+	return nodes
+}
+
+type refFilter struct {
+	primary refExpr
+	preds   []refExpr
+}
+
+func (e *refFilter) eval(c refContext) interface{} {
+	v := e.primary.eval(c)
+	nodes := refNodes(v)
+	for _, pred := range e.preds {
+		// The positions are in document order.
+		nodes = filterNodes(nodes, pred)
+	}
+	return refNodeSet(nodes)
+}
+
+type refLiteral struct{ v interface{} }
+
+func (e *refLiteral) eval(refContext) interface{} { return e.v }
+
+func (p *refParser) filter() refExpr {
+	var e refExpr
+	t := p.next()
+	switch {
+	case t.kind == tokLiteral:
+		e = &refLiteral{t.text}
+	case t.kind == tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.fail("bad number %s", t.text)
+		}
+		e = &refLiteral{f}
+	case t.text == "(":
+		e = p.expr()
+		p.expect(")")
+	case t.text == "$":
+		p.fail("unbound variable %s", p.next().text)
+	default:
+		p.expect("(")
+		call := &refCall{name: t.text}
+		if !p.accept(")") {
+			for {
+				call.args = append(call.args, p.expr())
+				if p.accept(")") {
+					break
+				}
+				p.expect(",")
+			}
+		}
+		sig, ok := refFunctions[call.name]
+		if !ok {
+			p.fail("unknown function %s", call.name)
+		}
+		if len(call.args) < sig.min || sig.max >= 0 && len(call.args) > sig.max {
+			p.fail("%s takes %d to %d arguments", call.name, sig.min, sig.max)
+		}
+		e = call
+	}
+	if !isSymbol(p.peek(), "[") {
+		return e
+	}
+	f := &refFilter{primary: e}
+	for p.accept("[") {
+		f.preds = append(f.preds, p.expr())
+		p.expect("]")
+	}
+	return f
+}
+
+type refCall struct {
+	name string
+	args []refExpr
+}
+
+// refFunctions are the functions of XPath 1.0, by name: the number of
+// their arguments, -1 for any, and their value from the context and the
+// arguments.
+var refFunctions map[string]struct {
+	min, max int
+	f        func(c refContext, args []interface{}) interface{}
+}
+
+func init() {
+	str := func(c refContext, args []interface{}, i int) string {
+		if i < len(args) {
+			return refString(args[i])
+		}
+		return c.node.value()
+	}
+	// node is the first node of the argument, or the context node.
+	node := func(c refContext, args []interface{}) *refNode {
+		if len(args) == 0 {
+			return c.node
+		}
+		if nodes := refNodes(args[0]); len(nodes) > 0 {
+			return nodes[0]
+		}
+		return nil
+	}
+	type fn = func(c refContext, args []interface{}) interface{}
+	refFunctions = map[string]struct {
+		min, max int
+		f        fn
+	}{
+		"last":     {0, 0, func(c refContext, args []interface{}) interface{} { return float64(c.size) }},
+		"position": {0, 0, func(c refContext, args []interface{}) interface{} { return float64(c.pos) }},
+		"count":    {1, 1, func(c refContext, args []interface{}) interface{} { return float64(len(refNodes(args[0]))) }},
+		"id":       {1, 1, func(c refContext, args []interface{}) interface{} { return refNodeSet{} }},
+		"local-name": {0, 1, func(c refContext, args []interface{}) interface{} {
+			if n := node(c, args); n != nil {
+				_, local, _ := n.name()
+				return local
+			}
+			return ""
+		}},
+		"namespace-uri": {0, 1, func(c refContext, args []interface{}) interface{} {
+			if n := node(c, args); n != nil {
+				_, _, uri := n.name()
+				return uri
+			}
+			return ""
+		}},
+		"name": {0, 1, func(c refContext, args []interface{}) interface{} {
+			if n := node(c, args); n != nil {
+				return n.qualifiedName()
+			}
+			return ""
+		}},
+		"string": {0, 1, func(c refContext, args []interface{}) interface{} { return str(c, args, 0) }},
+		"concat": {2, -1, func(c refContext, args []interface{}) interface{} {
+			var b strings.Builder
+			for _, a := range args {
+				b.WriteString(refString(a))
+			}
+			return b.String()
+		}},
+		"starts-with": {2, 2, func(c refContext, args []interface{}) interface{} {
+			return strings.HasPrefix(refString(args[0]), refString(args[1]))
+		}},
+		"contains": {2, 2, func(c refContext, args []interface{}) interface{} {
+			return strings.Contains(refString(args[0]), refString(args[1]))
+		}},
+		"substring-before": {2, 2, func(c refContext, args []interface{}) interface{} {
+			s, sep := refString(args[0]), refString(args[1])
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[:i]
+			}
+			return ""
+		}},
+		"substring-after": {2, 2, func(c refContext, args []interface{}) interface{} {
+			s, sep := refString(args[0]), refString(args[1])
+			if i := strings.Index(s, sep); i >= 0 {
+				return s[i+len(sep):]
+			}
+			return ""
+		}},
+		"substring": {2, 3, func(c refContext, args []interface{}) interface{} {
+			start := refRound(refNumber(args[1]))
+			end := math.Inf(1)
+			if len(args) == 3 {
+				end = start + refRound(refNumber(args[2]))
+			}
+			var b strings.Builder
+			for i, r := range []rune(refString(args[0])) {
+				if p := float64(i + 1); p >= start && p < end {
+					b.WriteRune(r)
+				}
+			}
+			return b.String()
+		}},
+		"string-length": {0, 1, func(c refContext, args []interface{}) interface{} {
+			return float64(utf8.RuneCountInString(str(c, args, 0)))
+		}},
+		"normalize-space": {0, 1, func(c refContext, args []interface{}) interface{} {
+			return strings.Join(strings.FieldsFunc(str(c, args, 0), func(r rune) bool {
+				return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+			}), " ")
+		}},
+		"translate": {3, 3, func(c refContext, args []interface{}) interface{} {
+			from, to := []rune(refString(args[1])), []rune(refString(args[2]))
+			var b strings.Builder
+			for _, r := range refString(args[0]) {
+				i := 0
+				for i < len(from) && from[i] != r {
+					i++
+				}
+				switch {
+				case i == len(from):
+					b.WriteRune(r)
+				case i < len(to):
+					b.WriteRune(to[i])
+				}
+			}
+			return b.String()
+		}},
+		"boolean": {1, 1, func(c refContext, args []interface{}) interface{} { return refBoolean(args[0]) }},
+		"not":     {1, 1, func(c refContext, args []interface{}) interface{} { return !refBoolean(args[0]) }},
+		"true":    {0, 0, func(c refContext, args []interface{}) interface{} { return true }},
+		"false":   {0, 0, func(c refContext, args []interface{}) interface{} { return false }},
+		"lang": {1, 1, func(c refContext, args []interface{}) interface{} {
+			lang := strings.ToLower(refString(args[0]))
+			for n := c.node; n != nil; n = n.parent {
+				for _, a := range n.attrs {
+					if attr := a.n.Attr[a.attr]; attr.Name == "lang" && (attr.Prefix == "xml" || attr.NamespaceURI == "http://www.w3.org/XML/1998/namespace") {
+						v := strings.ToLower(attr.Value)
+						return v == lang || strings.HasPrefix(v, lang+"-")
+					}
+				}
+			}
+			return false
+		}},
+		"number": {0, 1, func(c refContext, args []interface{}) interface{} {
+			if len(args) == 0 {
+				return parseRefNumber(c.node.value())
+			}
+			return refNumber(args[0])
+		}},
+		"sum": {1, 1, func(c refContext, args []interface{}) interface{} {
+			sum := 0.0
+			for _, n := range refNodes(args[0]) {
+				sum += parseRefNumber(n.value())
+			}
+			return sum
+		}},
+		"floor":   {1, 1, func(c refContext, args []interface{}) interface{} { return math.Floor(refNumber(args[0])) }},
+		"ceiling": {1, 1, func(c refContext, args []interface{}) interface{} { return math.Ceil(refNumber(args[0])) }},
+		"round":   {1, 1, func(c refContext, args []interface{}) interface{} { return refRound(refNumber(args[0])) }},
+	}
+}
+
+// refRound rounds f to the closest integer, toward positive infinity for
+// the halves, keeping the sign of zero.
+func refRound(f float64) float64 {
+	switch {
+	case math.IsNaN(f) || math.IsInf(f, 0) || f == 0:
+		return f
+	case f < 0 && f >= -0.5:
+		return math.Copysign(0, -1)
+	}
+	return math.Floor(f + 0.5)
+}
+
+func (e *refCall) eval(c refContext) interface{} {
+	args := make([]interface{}, len(e.args))
+	for i, a := range e.args {
+		args[i] = a.eval(c)
+	}
+	return refFunctions[e.name].f(c, args)
+}
@@ -0,0 +1,45 @@
+package xpathtest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReference(t *testing.T) {
+	oracle := Reference()
+	data := []byte(`<r xmlns:a="urn:a" xml:lang="en-GB"><a:b id="1">x</a:b><b/>y<!--c--><?p q?><b id="2">z</b></r>`)
+	for _, test := range []struct {
+		expr   string
+		nodes  []string
+		String string
+	}{
+		{"//b", []string{"/r[1]/b[1]", "/r[1]/b[2]"}, ""},
+		{"//a:b/@id", []string{"/r[1]/a:b[1]/@id"}, "1"},
+		{"/r/text() | //comment()", []string{"/r[1]/text()[1]", "/r[1]/comment()[1]"}, "y"},
+		{"//processing-instruction('p')", []string{"/r[1]/p[1]"}, "q"},
+		{"//b[last()]/preceding-sibling::*[1]", []string{"/r[1]/b[1]"}, ""},
+		{"count(//node())", nil, "9"},
+		{"1 div 3", nil, "0.3333333333333333"},
+		{"-(1 div 0)", nil, "-Infinity"},
+		{"substring('12345', 1.5, 2.6)", nil, "234"},
+		{"translate('bar', 'abc', 'ABC')", nil, "BAr"},
+		{"boolean(//b[lang('en')])", nil, "true"},
+		{"//b[2] = 'z' and not(//b = 'w')", nil, "true"},
+	} {
+		r, err := oracle.Evaluate(data, test.expr, map[string]string{"a": "urn:a"})
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(r.Nodes, test.nodes) || r.String != test.String {
+			t.Errorf("%s = %q, %q, want %q, %q", test.expr, r.Nodes, r.String, test.nodes, test.String)
+		}
+	}
+
+	for _, expr := range []string{"//b[", "$x", "//c:b", "upper-case('a')", "count()"} {
+		if _, err := oracle.Evaluate(data, expr, nil); !errors.Is(err, ErrRejected) {
+			t.Errorf("%s: error %v, want ErrRejected", expr, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package xpathtest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// A Replay is the results of the expressions of an xpath.Corpus on a set
+// of documents, to find the changes of behavior between two versions of
+// the xpath package: the replay of the previous version, written with
+// WriteTo, is compared with that of the current one by DiffReplays.
+type Replay struct {
+	// Exprs are the expressions, by their xpath.ExprHash.
+	Exprs map[string]string
+	// Results are the results of the expressions by hash, and then by
+	// the name of the document, such as "nodes /a[1] /a[1]/b[2]",
+	// "number 2", `string "a"` or "error xpath: expression must
+	// evaluate to a node-set".
+	Results map[string]map[string]string
+}
+
+// RunReplay evaluates each expression of corpus on each document of docs,
+// by name, from its document node.
+func RunReplay(corpus *xpath.Corpus, docs map[string]*dom.Node) *Replay {
+	r := &Replay{Exprs: make(map[string]string), Results: make(map[string]map[string]string)}
+	for _, e := range corpus.Entries() {
+		r.Exprs[e.Hash] = e.Expr
+		r.Results[e.Hash] = make(map[string]string)
+		exp, err := xpath.Compile(e.Expr)
+		for name, doc := range docs {
+			if err != nil {
+				r.Results[e.Hash][name] = "error " + err.Error()
+				continue
+			}
+			r.Results[e.Hash][name] = replayResult(exp, doc)
+		}
+	}
+	return r
+}
+
+// replayResult returns the result of exp on doc, for Replay.Results.
+func replayResult(exp *xpath.Expr, doc *dom.Node) string {
+	v, err := exp.EvaluateWithOptions(dom.CreateNavigator(doc), xpath.EvalOptions{RecoverPanics: true})
+	if iter, ok := v.(*xpath.NodeIterator); ok && err == nil {
+		paths := []string{"nodes"}
+		for iter.MoveNext() {
+			paths = append(paths, xpath.NodePath(iter.Current()))
+		}
+		if err = iter.Err(); err == nil {
+			return strings.Join(paths, " ")
+		}
+	}
+	if err != nil {
+		return "error " + err.Error()
+	}
+	switch v := v.(type) {
+	case float64:
+		return "number " + strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		return "string " + strconv.Quote(v)
+	case bool:
+		return "boolean " + strconv.FormatBool(v)
+	}
+	return fmt.Sprintf("%T %v", v, v)
+}
+
+// keys returns the keys of m, sorted.
+func keys(m map[string]string) []string {
+	list := make([]string, 0, len(m))
+	for k := range m {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// WriteTo writes the replay to w, a line per expression and document
+// with the hash, the name of the document, and the expression and the
+// result quoted as Go strings, separated by tabs, sorted by hash and
+// name.
+func (r *Replay) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, hash := range keys(r.Exprs) {
+		results := r.Results[hash]
+		for _, name := range keys(results) {
+			m, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", hash, name, strconv.Quote(r.Exprs[hash]), strconv.Quote(results[name]))
+			n += int64(m)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// ReadReplay reads a replay written by Replay.WriteTo.
+func ReadReplay(rd io.Reader) (*Replay, error) {
+	r := &Replay{Exprs: make(map[string]string), Results: make(map[string]map[string]string)}
+	s := bufio.NewScanner(rd)
+	s.Buffer(nil, 1<<20)
+	for line := 1; s.Scan(); line++ {
+		if s.Text() == "" {
+			continue
+		}
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("xpathtest: replay line %d: want a hash, a document, an expression and a result", line)
+		}
+		expr, err := strconv.Unquote(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("xpathtest: replay line %d: expression: %v", line, err)
+		}
+		result, err := strconv.Unquote(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("xpathtest: replay line %d: result: %v", line, err)
+		}
+		hash := fields[0]
+		r.Exprs[hash] = expr
+		if r.Results[hash] == nil {
+			r.Results[hash] = make(map[string]string)
+		}
+		r.Results[hash][fields[1]] = result
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// A ReplayChange is a result of an expression on a document that differs
+// between two replays.
+type ReplayChange struct {
+	Hash, Expr, Doc string
+	Old, New        string
+}
+
+func (c ReplayChange) String() string {
+	return fmt.Sprintf("%s %s on %s: %s, was %s", c.Hash, c.Expr, c.Doc, c.New, c.Old)
+}
+
+// DiffReplays returns the results that differ between the replays old
+// and new, for the expressions and documents in both, sorted by hash and
+// document name.
+func DiffReplays(old, new *Replay) []ReplayChange {
+	var changes []ReplayChange
+	for _, hash := range keys(new.Exprs) {
+		results := new.Results[hash]
+		for _, name := range keys(results) {
+			was, ok := old.Results[hash][name]
+			if ok && was != results[name] {
+				changes = append(changes, ReplayChange{Hash: hash, Expr: new.Exprs[hash], Doc: name, Old: was, New: results[name]})
+			}
+		}
+	}
+	return changes
+}
@@ -0,0 +1,84 @@
+package xpathtest
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/replay/results.txt with the results of the current version")
+
+// TestReplay replays the expressions of testdata/replay/corpus.txt and
+// compares their results with those of the version that wrote
+// testdata/replay/results.txt. Run it with -update to accept the changes.
+func TestReplay(t *testing.T) {
+	f, err := os.Open("testdata/replay/corpus.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	corpus, err := xpath.ReadCorpus(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := make(map[string]*dom.Node)
+	for name, file := range map[string]string{"books": "testdata/xpath10/books.xml", "page": "testdata/replay/page.html"} {
+		if docs[name], err = readDocument(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	replay := RunReplay(corpus, docs)
+
+	if *update {
+		var b bytes.Buffer
+		replay.WriteTo(&b)
+		if err := os.WriteFile("testdata/replay/results.txt", b.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	data, err := os.ReadFile("testdata/replay/results.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := ReadReplay(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range DiffReplays(old, replay) {
+		t.Error(c)
+	}
+	if len(old.Exprs) != len(replay.Exprs) {
+		t.Errorf("%d expressions replayed, %d in results.txt; run with -update", len(replay.Exprs), len(old.Exprs))
+	}
+}
+
+func TestDiffReplays(t *testing.T) {
+	old := &Replay{
+		Exprs:   map[string]string{"1": "a", "2": "b"},
+		Results: map[string]map[string]string{"1": {"d": "nodes", "e": "nodes /a[1]"}, "2": {"d": "number 1"}},
+	}
+	var b bytes.Buffer
+	old.WriteTo(&b)
+	read, err := ReadReplay(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, old) {
+		t.Errorf("ReadReplay = %v, want %v", read, old)
+	}
+
+	new := &Replay{
+		Exprs:   map[string]string{"1": "a", "3": "c"},
+		Results: map[string]map[string]string{"1": {"d": "nodes", "e": "nodes /a[1] /a[2]"}, "3": {"d": "number 1"}},
+	}
+	want := []ReplayChange{{Hash: "1", Expr: "a", Doc: "e", Old: "nodes /a[1]", New: "nodes /a[1] /a[2]"}}
+	if got := DiffReplays(old, new); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffReplays = %v, want %v", got, want)
+	}
+}
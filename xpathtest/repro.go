@@ -0,0 +1,269 @@
+package xpathtest
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// A Repro is a failing case of a differential test, exported by a
+// ReproExporter to be run again by RunRepros, with the result of the
+// oracle, so that it needs none.
+type Repro struct {
+	// Test is the name of the test, and Rerun the flags that reproduce
+	// the failure with rapid, such as -rapid.seed=12.
+	Test, Rerun string
+	Doc         []byte
+	Expr        string
+	Namespaces  map[string]string
+	// Want is the Result of the oracle; its Fragments are not kept.
+	Want Result
+}
+
+// WriteTo writes the repro to w: a header of a line per field, such as
+// expr "//a" or node "/a[1]", with the strings quoted as Go strings, then
+// a blank line and the document.
+func (r *Repro) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "test %s\n", strconv.Quote(r.Test))
+	if r.Rerun != "" {
+		fmt.Fprintf(&b, "rerun %s\n", strconv.Quote(r.Rerun))
+	}
+	fmt.Fprintf(&b, "expr %s\n", strconv.Quote(r.Expr))
+	for _, prefix := range keys(r.Namespaces) {
+		fmt.Fprintf(&b, "namespace %s %s\n", strconv.Quote(prefix), strconv.Quote(r.Namespaces[prefix]))
+	}
+	fmt.Fprintf(&b, "string %s\n", strconv.Quote(r.Want.String))
+	if r.Want.NodeSet {
+		b.WriteString("nodeset\n")
+	}
+	for _, path := range r.Want.Nodes {
+		fmt.Fprintf(&b, "node %s\n", strconv.Quote(path))
+	}
+	b.WriteString("\n")
+	b.Write(r.Doc)
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+// ReadRepro reads a repro written by Repro.WriteTo.
+func ReadRepro(rd io.Reader) (*Repro, error) {
+	r := &Repro{}
+	br := bufio.NewReader(rd)
+	for line := 1; ; line++ {
+		s, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("xpathtest: repro line %d: want a header and a document", line)
+		}
+		s = strings.TrimSuffix(s, "\n")
+		if s == "" {
+			break
+		}
+		fields := strings.SplitN(s, " ", 2)
+		var args []string
+		if len(fields) == 2 {
+			for v := fields[1]; v != ""; v = strings.TrimPrefix(v, " ") {
+				q, err := strconv.QuotedPrefix(v)
+				if err != nil {
+					return nil, fmt.Errorf("xpathtest: repro line %d: %v", line, err)
+				}
+				arg, _ := strconv.Unquote(q)
+				args = append(args, arg)
+				v = v[len(q):]
+			}
+		}
+		want := map[string]int{"test": 1, "rerun": 1, "expr": 1, "namespace": 2, "string": 1, "nodeset": 0, "node": 1}
+		if n, ok := want[fields[0]]; !ok || n != len(args) {
+			return nil, fmt.Errorf("xpathtest: repro line %d: unknown field %q", line, s)
+		}
+		switch fields[0] {
+		case "test":
+			r.Test = args[0]
+		case "rerun":
+			r.Rerun = args[0]
+		case "expr":
+			r.Expr = args[0]
+		case "namespace":
+			if r.Namespaces == nil {
+				r.Namespaces = make(map[string]string)
+			}
+			r.Namespaces[args[0]] = args[1]
+		case "string":
+			r.Want.String = args[0]
+		case "nodeset":
+			r.Want.NodeSet = true
+		case "node":
+			r.Want.Nodes = append(r.Want.Nodes, args[0])
+		}
+	}
+	doc, err := io.ReadAll(br)
+	if err != nil {
+		return nil, err
+	}
+	r.Doc = doc
+	return r, nil
+}
+
+// reproFile is the name of the file of r in a directory of repros.
+func reproFile(r *Repro) string {
+	name := regexp.MustCompile(`[^A-Za-z0-9_-]+`).ReplaceAllString(r.Test, "_")
+	return name + "-" + xpath.ExprHash(r.Expr+"\n"+string(r.Doc)) + ".txt"
+}
+
+// A ReproExporter is a rapid.TB for rapid.Check in place of the *testing.T
+// of a differential test that, if the test fails, exports the last case
+// recorded with Record to a directory, to be run by RunRepros. As
+// rapid runs the property again with the shrunk case after a failure, that
+// case is the last one:
+//
+//	e := xpathtest.NewReproExporter(t, "testdata/repro")
+//	rapid.Check(e, func(t *rapid.T) {
+//		...
+//		if d := xpathtest.Diff(want, got); d != "" {
+//			e.Record(data, expr, namespaces, want)
+//			t.Fatal(d)
+//		}
+//	})
+type ReproExporter struct {
+	*testing.T
+	dir string
+
+	mu    sync.Mutex
+	last  *Repro
+	rerun string
+}
+
+// rerunFlags finds the flags of the message of rapid for reproducing a
+// failure.
+var rerunFlags = regexp.MustCompile(`To reproduce, specify -run=\S+ (.*)`)
+
+// NewReproExporter returns a ReproExporter of the test t, exporting to
+// dir, which it creates if needed.
+func NewReproExporter(t *testing.T, dir string) *ReproExporter {
+	e := &ReproExporter{T: t, dir: dir}
+	t.Cleanup(func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if !t.Failed() || e.last == nil {
+			return
+		}
+		e.last.Rerun = e.rerun
+		file, err := WriteRepro(e.dir, e.last)
+		if err != nil {
+			t.Log(err)
+			return
+		}
+		t.Logf("exported the failing case to %s", file)
+	})
+	return e
+}
+
+// Errorf is that of testing.T, keeping the flags of rapid for reproducing
+// a failure.
+func (e *ReproExporter) Errorf(format string, args ...interface{}) {
+	e.T.Helper()
+	if m := rerunFlags.FindStringSubmatch(fmt.Sprintf(format, args...)); m != nil {
+		e.mu.Lock()
+		e.rerun = m[1]
+		e.mu.Unlock()
+	}
+	e.T.Errorf(format, args...)
+}
+
+// Record records a failing case: the expression expr on the document
+// data, and the Result want of the oracle.
+func (e *ReproExporter) Record(data []byte, expr string, namespaces map[string]string, want Result) {
+	want.Fragments = nil
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last = &Repro{Test: e.Name(), Doc: data, Expr: expr, Namespaces: namespaces, Want: want}
+}
+
+// WriteRepro writes r to a file of dir, creating it if needed, and returns
+// the name of the file.
+func WriteRepro(dir string, r *Repro) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	r.WriteTo(&b)
+	file := filepath.Join(dir, reproFile(r))
+	return file, os.WriteFile(file, b.Bytes(), 0o644)
+}
+
+// ReadRepros reads the repros of dir, by file name. A dir that does not
+// exist has none.
+func ReadRepros(dir string) (map[string]*Repro, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	repros := make(map[string]*Repro)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".txt") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		r, err := ReadRepro(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", entry.Name(), err)
+		}
+		repros[entry.Name()] = r
+	}
+	return repros, nil
+}
+
+// RunRepros runs the repros of dir as subtests of t, by file name: each
+// checks that the Result of Evaluate is the Want of the repro.
+func RunRepros(t *testing.T, dir string) {
+	repros, err := ReadRepros(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(repros))
+	for name := range repros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := repros[name]
+		t.Run(strings.TrimSuffix(name, ".txt"), func(t *testing.T) {
+			defer func() {
+				if e := recover(); e != nil {
+					t.Fatalf("%s of %s %s: panic: %v\nDocument:\n%s", r.Expr, r.Test, r.Rerun, e, r.Doc)
+				}
+			}()
+			doc, err := dom.ParseBytes(r.Doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := Evaluate(doc, r.Expr, r.Namespaces)
+			if err != nil {
+				t.Fatalf("%s: %v", r.Expr, err)
+			}
+			if d := Diff(r.Want, got); d != "" {
+				t.Errorf("%s of %s %s:\n%sDocument:\n%s", r.Expr, r.Test, r.Rerun, d, r.Doc)
+			}
+		})
+	}
+}
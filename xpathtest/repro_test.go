@@ -0,0 +1,48 @@
+package xpathtest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestRepro(t *testing.T) {
+	r := &Repro{
+		Test:       "TestPropertyDifferential",
+		Rerun:      `-rapid.failfile="a b.fail" (or -rapid.seed=12)`,
+		Doc:        []byte("<r xmlns=\"urn:a\"><a>x\ny</a></r>"),
+		Expr:       `//d:a | //b`,
+		Namespaces: map[string]string{"": "urn:a", "d": "urn:a"},
+		Want:       Result{NodeSet: true, Nodes: []string{"/r[1]/a[1]"}, String: "x\ny"},
+	}
+	var b bytes.Buffer
+	r.WriteTo(&b)
+	read, err := ReadRepro(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(read, r) {
+		t.Errorf("ReadRepro = %+v, want %+v", read, r)
+	}
+
+	dir := t.TempDir()
+	file, err := WriteRepro(dir, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repros, err := ReadRepros(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repros) != 1 {
+		t.Fatalf("ReadRepros = %v, want %s", repros, file)
+	}
+	RunRepros(t, dir)
+
+	if repros, err := ReadRepros(dir + "/none"); repros != nil || err != nil {
+		t.Errorf("ReadRepros of no directory = %v, %v", repros, err)
+	}
+	if _, err := ReadRepro(bytes.NewReader([]byte("expr \"a\"\nfoo\n\n<a/>"))); err == nil {
+		t.Error("ReadRepro of an unknown field: no error")
+	}
+}
@@ -0,0 +1,65 @@
+package xpathtest
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+// RunSessions replays the xpath.Session files of dir, those with the
+// .session extension written by Session.WriteTo, as subtests of t by file
+// name: each parses the document of the session with the dom package and
+// checks that the replayed result is the recorded one. A session
+// recorded on a document parsed by another package may have another
+// DocumentHash, as the trees may differ, such as in whitespace text
+// nodes: the hashes are only reported with a difference of results. A
+// dir that does not exist has no sessions.
+func RunSessions(t *testing.T, dir string) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".session") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name := name
+		t.Run(strings.TrimSuffix(name, ".session"), func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, err := xpath.ReadSession(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc, err := dom.ParseWithOptions(bytes.NewReader(s.Doc), dom.ParseOptions{HTML: s.HTML})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Replay(dom.CreateNavigator(doc))
+			if got.Result != s.Result {
+				var note string
+				if got.DocHash != s.DocHash {
+					note = " (the document hash differs, " + got.DocHash + " for " + s.DocHash + ")"
+				}
+				t.Errorf("%s: recorded %s\nreplayed %s%s", s.Expr, s.Result, got.Result, note)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package xpathtest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+)
+
+func TestRunSessions(t *testing.T) {
+	data := []byte(`<html><body><p class="price">3</p><br><p>4</p></body></html>`)
+	doc, err := dom.ParseWithOptions(bytes.NewReader(data), dom.ParseOptions{HTML: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := xpath.RecordSession(xpath.MustCompile(`//p[@class = 'price']`), dom.CreateNavigator(doc), data)
+	s.HTML = true
+	if s.Result != "nodes /html[1]/body[1]/p[1]" {
+		t.Fatalf("unexpected result %q", s.Result)
+	}
+	dir := t.TempDir()
+	var b bytes.Buffer
+	s.WriteTo(&b)
+	if err := os.WriteFile(filepath.Join(dir, "price.session"), b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	RunSessions(t, dir)
+
+	RunSessions(t, filepath.Join(dir, "missing"))
+}
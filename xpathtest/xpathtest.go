@@ -0,0 +1,544 @@
+// Package xpathtest provides rapid generators of documents and XPath 1.0
+// expressions, for the property tests of the xpath package and of the
+// packages that implement xpath.NodeNavigator or wrap the engine.
+//
+// The generators draw their names and values from the small alphabets of
+// a Config, so that the expressions often select nodes of the documents:
+//
+//	cfg := xpathtest.DefaultConfig()
+//	rapid.Check(t, func(t *rapid.T) {
+//		doc := cfg.Document().Draw(t, "doc")
+//		expr := cfg.Expr().Draw(t, "expr")
+//		...
+//	})
+//
+// An Oracle is another engine the results are compared with: Xmllint runs
+// the xmllint command, and Libxml2, built with the libxml2 build tag and
+// the libxml-2.0 pkg-config package, calls libxml2 through cgo. Reference
+// is an interpreter of this package, for the environments without either.
+//
+// RunConformance runs the test cases of a conformance suite in the format
+// of the W3C QT3 test suite, such as those of XPath 1.0 in testdata:
+//
+//	go test ./xpathtest -run TestConformance -v [-qt3 qt3tests/catalog.xml]
+//
+// RunReplay evaluates the expressions of an xpath.Corpus, such as those
+// recorded in production with xpath.Recorder, on a fixed set of documents,
+// and DiffReplays compares the results with those written by a previous
+// version of the package. TestReplay does so with the corpus in testdata;
+// run it with -update to accept the changes of behavior. CheckGolden does
+// the same in the tests of a program, for the expressions it evaluates on
+// its documents, with a golden file of their results.
+//
+// Minimize shrinks a failing case, generated or reported from production,
+// to a small document and expression that still exhibit the Failure, such
+// as a Mismatch with an Oracle or a panic.
+//
+// A ReproExporter exports the failing case of a differential test, with
+// the result of the oracle and the flags of rapid that reproduce it, to a
+// directory that RunRepros runs as subtests, needing no oracle.
+package xpathtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+	"pgregory.net/rapid"
+)
+
+// Config is the alphabets and sizes of the generated documents and
+// expressions.
+type Config struct {
+	// Tags are the local names of the elements, and of the element name
+	// tests.
+	Tags []string
+	// Attrs are the local names of the attributes, and of the attribute
+	// name tests.
+	Attrs []string
+	// Texts are the values of the text nodes and attributes, and the
+	// contents of the string literals.
+	Texts []string
+	// Namespaces maps the prefixes of the elements, attributes and name
+	// tests to their namespace URIs. If it is empty, all the names are in
+	// no namespace; otherwise they are in one of the namespaces or in
+	// none, and the expressions are compiled with xpath.CompileWithNS.
+	//
+	// The prefix "" maps to the default namespace of the root element,
+	// which the other elements may redeclare as another of the URIs or
+	// undeclare. The elements of no prefix are in the default namespace,
+	// but the name tests of no prefix select the names in no namespace
+	// only: to select the others, map a prefix to the same URI too, such
+	// as {"": "urn:a", "a": "urn:a"}.
+	Namespaces map[string]string
+
+	// MaxDepth is the number of levels of elements below the root element
+	// of a document, and MaxChildren the number of children of an element.
+	MaxDepth, MaxChildren int
+	// MaxAttrs is the number of attributes of an element.
+	MaxAttrs int
+	// MaxSteps is the number of steps of a path, and MaxPredicates the
+	// number of predicates of a step.
+	MaxSteps, MaxPredicates int
+	// MaxNesting is the number of predicates a predicate can be nested
+	// in, such as 1 for a[b[c]].
+	MaxNesting int
+	// MaxOperators is the number of operators and parentheses an operand
+	// can be nested in, such as 2 for (a | b) = 1.
+	MaxOperators int
+
+	// Coverage, if not nil, biases the axes, functions, operators and
+	// node tests of the expressions toward those it counts the least, so
+	// that a run exercises all of them: the more a feature was recorded,
+	// the less often it is drawn. The test records the expressions it
+	// draws, or sets xpath.Recorder to Coverage.Record. The values then
+	// depend on the expressions drawn before, so the failures rapid saves
+	// in testdata do not replay exactly; use Minimize to reduce them.
+	Coverage *xpath.Coverage
+}
+
+// DefaultConfig returns a Config of some HTML tags and attributes, in no
+// namespace.
+func DefaultConfig() Config {
+	return Config{
+		Tags:          []string{"div", "p", "span", "a", "b", "i", "table", "tr", "td"},
+		Attrs:         []string{"id", "class", "href", "title", "style"},
+		Texts:         []string{"", "foo", "bar"},
+		MaxDepth:      4,
+		MaxChildren:   5,
+		MaxAttrs:      3,
+		MaxSteps:      3,
+		MaxPredicates: 2,
+		MaxNesting:    2,
+		MaxOperators:  2,
+	}
+}
+
+const xmlnsURI = "http://www.w3.org/2000/xmlns/"
+
+// prefixes returns the prefixes of the names, with "" for no prefix.
+func (c Config) prefixes() []string {
+	p := []string{""}
+	for prefix := range c.Namespaces {
+		if prefix != "" {
+			p = append(p, prefix)
+		}
+	}
+	sort.Strings(p[1:])
+	return p
+}
+
+// defaultNamespaces returns the URIs the default namespace can be
+// redeclared as, with "" to undeclare it, or nil if the Namespaces have
+// no default namespace.
+func (c Config) defaultNamespaces() []string {
+	if _, ok := c.Namespaces[""]; !ok {
+		return nil
+	}
+	uris := []string{""}
+	seen := map[string]bool{"": true}
+	for _, prefix := range keys(c.Namespaces) {
+		if uri := c.Namespaces[prefix]; !seen[uri] {
+			seen[uri] = true
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// Document generates a document node with a root element from Element.
+// The root element declares the default namespace and the prefixes of
+// Namespaces, so that the document serialized by OutputXML is
+// well-formed.
+func (c Config) Document() *rapid.Generator[*dom.Node] {
+	return rapid.Custom(func(t *rapid.T) *dom.Node {
+		root := c.Element().Draw(t, "root")
+		var decls []dom.Attr
+		if uri, ok := c.Namespaces[""]; ok {
+			decls = append(decls, dom.Attr{Name: "xmlns", NamespaceURI: xmlnsURI, Value: uri})
+		}
+		for _, prefix := range c.prefixes()[1:] {
+			decls = append(decls, dom.Attr{Prefix: "xmlns", Name: prefix, NamespaceURI: xmlnsURI, Value: c.Namespaces[prefix]})
+		}
+		// The declarations come first, where libxml2 serializes them.
+		root.Attr = append(decls, root.Attr...)
+		doc := dom.NewDocument()
+		doc.AppendChild(root)
+		return doc
+	})
+}
+
+// Element generates an element with attributes and children down to
+// MaxDepth levels, the children being elements or text nodes. The
+// elements of no prefix are in the default namespace of Namespaces, or in
+// that the element or its ancestors redeclare.
+func (c Config) Element() *rapid.Generator[*dom.Node] {
+	return rapid.Custom(func(t *rapid.T) *dom.Node {
+		return c.element(t, c.MaxDepth, c.Namespaces[""])
+	})
+}
+
+func (c Config) element(t *rapid.T, depth int, defaultNamespace string) *dom.Node {
+	n := dom.NewElement(rapid.SampledFrom(c.Tags).Draw(t, "tag"))
+	if uris := c.defaultNamespaces(); uris != nil && depth < c.MaxDepth && rapid.Bool().Draw(t, "redeclare") {
+		defaultNamespace = rapid.SampledFrom(uris).Draw(t, "defaultNamespace")
+		n.Attr = append(n.Attr, dom.Attr{Name: "xmlns", NamespaceURI: xmlnsURI, Value: defaultNamespace})
+	}
+	n.Prefix = rapid.SampledFrom(c.prefixes()).Draw(t, "prefix")
+	n.NamespaceURI = c.Namespaces[n.Prefix]
+	if n.Prefix == "" {
+		n.NamespaceURI = defaultNamespace
+	}
+
+	numAttrs := rapid.IntRange(0, c.MaxAttrs).Draw(t, "numAttrs")
+	seen := make(map[string]bool)
+	for i := 0; i < numAttrs; i++ {
+		a := dom.Attr{
+			Prefix: rapid.SampledFrom(c.prefixes()).Draw(t, fmt.Sprintf("attrPrefix%d", i)),
+			Name:   rapid.SampledFrom(c.Attrs).Draw(t, fmt.Sprintf("attrName%d", i)),
+			Value:  rapid.SampledFrom(c.Texts).Draw(t, fmt.Sprintf("attrVal%d", i)),
+		}
+		if a.Prefix != "" {
+			a.NamespaceURI = c.Namespaces[a.Prefix]
+		}
+		// Attribute names must be unique in well-formed XML, and so must
+		// their namespace URIs and local names.
+		if seen[a.QName()] || seen["{"+a.NamespaceURI+"}"+a.Name] {
+			continue
+		}
+		seen[a.QName()] = true
+		seen["{"+a.NamespaceURI+"}"+a.Name] = true
+		n.Attr = append(n.Attr, a)
+	}
+
+	if depth > 0 {
+		numChildren := rapid.IntRange(0, c.MaxChildren).Draw(t, "numChildren")
+		for i := 0; i < numChildren; i++ {
+			if rapid.Bool().Draw(t, fmt.Sprintf("isElement%d", i)) {
+				n.AppendChild(c.element(t, depth-1, defaultNamespace))
+			} else {
+				n.AppendChild(dom.NewText(rapid.SampledFrom(c.Texts).Draw(t, fmt.Sprintf("text%d", i))))
+			}
+		}
+	}
+	return n
+}
+
+// Expr generates a path, a call of a built-in function, a literal, or
+// an operation on them, such as a union, a comparison, an arithmetic or
+// a boolean operation, or a negation, with parentheses, down to
+// MaxOperators levels.
+func (c Config) Expr() *rapid.Generator[string] {
+	return c.expr(0)
+}
+
+func (c Config) expr(level int) *rapid.Generator[string] {
+	if level >= c.MaxOperators {
+		return c.primary()
+	}
+	return rapid.Custom(func(t *rapid.T) string {
+		return rapid.OneOf(
+			c.primary(),
+			c.union(level),
+			rapid.Custom(func(t *rapid.T) string {
+				op := c.sample("operator:", []string{
+					"or", "and", "=", "!=", "<", "<=", ">", ">=",
+					"+", "-", "*", "div", "mod",
+				}).Draw(t, "op")
+				right := c.expr(level + 1)
+				if relational(op) {
+					right = c.NumberLiteral()
+				}
+				return c.expr(level+1).Draw(t, "left") + " " + op + " " + right.Draw(t, "right")
+			}),
+			rapid.Custom(func(t *rapid.T) string { return "-" + c.expr(level+1).Draw(t, "operand") }),
+			rapid.Custom(func(t *rapid.T) string { return "(" + c.expr(level+1).Draw(t, "inner") + ")" }),
+		).Draw(t, "expr")
+	})
+}
+
+// relational reports whether op is <, <=, > or >=. The xpath package
+// compares two strings or node-sets with them as strings, see
+// cmpStringStringF and CompileOptions.CompareDates, where XPath 1.0
+// compares their numbers: the expressions compare them with a number.
+func relational(op string) bool {
+	switch op {
+	case "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+// primary generates the operands of the operators of Expr.
+func (c Config) primary() *rapid.Generator[string] {
+	return rapid.OneOf(c.Path(), c.FunctionCall(), c.StringLiteral(), c.NumberLiteral())
+}
+
+// Union generates the union of 2 to 3 paths, or of unions in parentheses,
+// down to MaxOperators levels.
+func (c Config) Union() *rapid.Generator[string] {
+	return c.union(0)
+}
+
+func (c Config) union(level int) *rapid.Generator[string] {
+	return rapid.Custom(func(t *rapid.T) string {
+		operands := make([]string, rapid.IntRange(2, 3).Draw(t, "numOperands"))
+		for i := range operands {
+			if level+1 < c.MaxOperators && rapid.Bool().Draw(t, fmt.Sprintf("parens%d", i)) {
+				operands[i] = "(" + c.union(level+1).Draw(t, fmt.Sprintf("operand%d", i)) + ")"
+			} else {
+				operands[i] = c.Path().Draw(t, fmt.Sprintf("operand%d", i))
+			}
+		}
+		return strings.Join(operands, " | ")
+	})
+}
+
+// Path generates an absolute path, starting with / or //, or a
+// RelativePath.
+func (c Config) Path() *rapid.Generator[string] {
+	return rapid.Custom(func(t *rapid.T) string {
+		start := rapid.SampledFrom([]string{"/", "//", ""}).Draw(t, "start")
+		return start + c.RelativePath().Draw(t, "relativePath")
+	})
+}
+
+// RelativePath generates a path of 1 to MaxSteps steps, joined by / or //.
+func (c Config) RelativePath() *rapid.Generator[string] {
+	return c.relativePath(0)
+}
+
+func (c Config) relativePath(nesting int) *rapid.Generator[string] {
+	return rapid.Custom(func(t *rapid.T) string {
+		steps := make([]string, rapid.IntRange(1, c.MaxSteps).Draw(t, "numSteps"))
+		for i := range steps {
+			steps[i] = c.step(nesting).Draw(t, fmt.Sprintf("step%d", i))
+		}
+		return strings.Join(steps, rapid.SampledFrom([]string{"/", "//"}).Draw(t, "separator"))
+	})
+}
+
+// Step generates a step, such as child::div, @id, or p[1], with up to
+// MaxPredicates predicates.
+func (c Config) Step() *rapid.Generator[string] {
+	return c.step(0)
+}
+
+func (c Config) step(nesting int) *rapid.Generator[string] {
+	return rapid.Custom(func(t *rapid.T) string {
+		axis := c.Axis().Draw(t, "axis")
+		var step string
+		if axis == "attribute" {
+			test := rapid.OneOf(c.NameTest(true), rapid.Just("node()")).Draw(t, "nodeTest")
+			if test != "node()" && rapid.Bool().Draw(t, "useAbbreviation") {
+				step = "@" + test
+			} else {
+				step = axis + "::" + test
+			}
+		} else {
+			test := c.NodeTest().Draw(t, "nodeTest")
+			if axis == "child" && rapid.Bool().Draw(t, "useAbbreviation") {
+				step = test
+			} else {
+				step = axis + "::" + test
+			}
+		}
+		if nesting < c.MaxNesting {
+			numPredicates := rapid.IntRange(0, c.MaxPredicates).Draw(t, "numPredicates")
+			for i := 0; i < numPredicates; i++ {
+				step += c.predicate(nesting).Draw(t, fmt.Sprintf("predicate%d", i))
+			}
+		}
+		return step
+	})
+}
+
+// Predicate generates a predicate, such as [1], [last()], [b],
+// [@id = 'foo'] or [contains(., 'bar')].
+func (c Config) Predicate() *rapid.Generator[string] {
+	return c.predicate(0)
+}
+
+func (c Config) predicate(nesting int) *rapid.Generator[string] {
+	operand := rapid.OneOf(
+		rapid.Just("."),
+		rapid.Just("text()"),
+		rapid.Custom(func(t *rapid.T) string { return "@" + c.NameTest(true).Draw(t, "attr") }),
+	)
+	return rapid.Custom(func(t *rapid.T) string {
+		content := rapid.OneOf(
+			rapid.Just("last()"),
+			c.NumberLiteral(),
+			c.relativePath(nesting+1),
+			rapid.Custom(func(t *rapid.T) string {
+				lhs := rapid.OneOf(operand, c.NameTest(false)).Draw(t, "lhs")
+				op := c.sample("operator:", []string{"=", "!=", "<", "<=", ">", ">="}).Draw(t, "op")
+				rhs := c.NumberLiteral()
+				if !relational(op) {
+					rhs = rapid.OneOf(c.StringLiteral(), c.NumberLiteral())
+				}
+				return lhs + " " + op + " " + rhs.Draw(t, "rhs")
+			}),
+			rapid.Custom(func(t *rapid.T) string {
+				name := c.sample("function:", []string{"contains", "starts-with"}).Draw(t, "funcName")
+				return name + "(" + operand.Draw(t, "arg1") + ", " + c.StringLiteral().Draw(t, "arg2") + ")"
+			}),
+		).Draw(t, "content")
+		return "[" + content + "]"
+	})
+}
+
+// Axis generates the name of an axis, except namespace.
+func (c Config) Axis() *rapid.Generator[string] {
+	return c.sample("axis:", []string{
+		"child", "descendant", "parent", "ancestor", "following-sibling",
+		"preceding-sibling", "following", "preceding", "attribute", "self",
+		"descendant-or-self", "ancestor-or-self",
+	})
+}
+
+// NodeTest generates a node test of an element axis: an element name
+// test, node(), text() or comment().
+func (c Config) NodeTest() *rapid.Generator[string] {
+	return rapid.OneOf(
+		c.NameTest(false),
+		c.sample("node-test:", []string{"node()", "text()", "comment()"}),
+	)
+}
+
+// NameTest generates a name test of the Attrs, if attr is true, or of
+// the Tags, such as *, div or, with a prefix of Namespaces, a:div or a:*.
+func (c Config) NameTest(attr bool) *rapid.Generator[string] {
+	names := c.Tags
+	if attr {
+		names = c.Attrs
+	}
+	return rapid.Custom(func(t *rapid.T) string {
+		prefix := rapid.SampledFrom(c.prefixes()).Draw(t, "prefix")
+		name := rapid.SampledFrom(append([]string{"*"}, names...)).Draw(t, "name")
+		if prefix == "" {
+			return name
+		}
+		return prefix + ":" + name
+	})
+}
+
+// StringLiteral generates a string literal of the Texts, or of a number
+// written as XPath 1.0 converts it or almost, such as ' 12 ', '+1' or
+// '1_000', quoted with ' or " but by the quote they do not contain.
+func (c Config) StringLiteral() *rapid.Generator[string] {
+	return quoted(rapid.OneOf(rapid.SampledFrom(c.Texts), numberString()))
+}
+
+// quoted generates the string literals of the strings of content.
+func quoted(content *rapid.Generator[string]) *rapid.Generator[string] {
+	return rapid.Custom(func(t *rapid.T) string {
+		quote := rapid.SampledFrom([]string{"'", `"`}).Draw(t, "quote")
+		s := content.Draw(t, "content")
+		if strings.Contains(s, quote) {
+			quote = strings.Trim(`'"`, quote)
+		}
+		return quote + s + quote
+	})
+}
+
+// numberString generates the strings of numbers, padded with spaces or
+// signed, and those of the other syntaxes, which are NaN in XPath 1.0.
+// The exponents are left out: libxml2 converts them.
+func numberString() *rapid.Generator[string] {
+	return rapid.Custom(func(t *rapid.T) string {
+		pad := []string{"", " ", "  "}
+		return rapid.SampledFrom(pad).Draw(t, "before") +
+			rapid.SampledFrom([]string{"", "-", "+"}).Draw(t, "sign") +
+			rapid.SampledFrom([]string{"0", "12", "1.5", ".5", "7.", "1_000", "Inf", "0x10", "NaN"}).Draw(t, "digits") +
+			rapid.SampledFrom(pad).Draw(t, "after")
+	})
+}
+
+// NumberLiteral generates an integer from -10 to 100.
+func (c Config) NumberLiteral() *rapid.Generator[string] {
+	return rapid.Custom(func(t *rapid.T) string {
+		return fmt.Sprint(rapid.IntRange(-10, 100).Draw(t, "number"))
+	})
+}
+
+// FunctionCall generates a call of a built-in function, with arguments
+// of the types given by its xpath.FunctionSignature.
+func (c Config) FunctionCall() *rapid.Generator[string] {
+	sigs := xpath.Capabilities().Signatures
+	var names []string
+	for name := range sigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return rapid.Custom(func(t *rapid.T) string {
+		name := c.sample("function:", names).Draw(t, "funcName")
+		sig := sigs[name]
+		max := sig.MaxArgs
+		if max < 0 {
+			max = sig.MinArgs + 2
+		}
+		args := make([]string, rapid.IntRange(sig.MinArgs, max).Draw(t, "numArgs"))
+		for i := range args {
+			if name == "matches" && i == 2 || name == "replace" && i == 3 {
+				// The flags of the regular expression.
+				args[i] = rapid.SampledFrom([]string{"''", "'i'", "'q'", "'smx'"}).Draw(t, fmt.Sprintf("arg%d", i))
+				continue
+			}
+			if i == 2 && (name == "compare" || name == "contains" || name == "starts-with" || name == "ends-with") {
+				// The URI of a collation.
+				args[i] = rapid.SampledFrom([]string{"'" + xpath.CollationCodepoint + "'", "'" + xpath.CollationCaseFold + "'"}).Draw(t, fmt.Sprintf("arg%d", i))
+				continue
+			}
+			types := sig.Params[len(sig.Params)-1]
+			if i < len(sig.Params) {
+				types = sig.Params[i]
+			}
+			if types == nil {
+				types = []string{"node-set", "number", "string"}
+			}
+			var gens []*rapid.Generator[string]
+			for _, typ := range types {
+				switch typ {
+				case "node-set":
+					gens = append(gens, rapid.Just("."), c.RelativePath())
+				case "number":
+					gens = append(gens, c.NumberLiteral())
+				case "string":
+					if i > 0 && (name == "matches" || name == "replace") {
+						// The Texts are valid patterns and replacements,
+						// unlike +1.
+						gens = append(gens, quoted(rapid.SampledFrom(c.Texts)))
+					} else {
+						gens = append(gens, c.StringLiteral())
+					}
+				case "boolean":
+					gens = append(gens, rapid.SampledFrom([]string{"true()", "false()"}))
+				}
+			}
+			args[i] = rapid.OneOf(gens...).Draw(t, fmt.Sprintf("arg%d", i))
+		}
+		return name + "(" + strings.Join(args, ", ") + ")"
+	})
+}
+
+// sample generates an element of list, as rapid.SampledFrom. With a
+// Coverage, the list is sorted by the number of times each feature, named
+// prefix and the element, was recorded: rapid draws the small indices
+// more often, and so the features recorded the least.
+func (c Config) sample(prefix string, list []string) *rapid.Generator[string] {
+	if c.Coverage == nil {
+		return rapid.SampledFrom(list)
+	}
+	return rapid.Custom(func(t *rapid.T) string {
+		counts := make(map[string]int, len(list))
+		for _, s := range list {
+			counts[s] = c.Coverage.Count(prefix + s)
+		}
+		sorted := append([]string(nil), list...)
+		sort.SliceStable(sorted, func(i, j int) bool { return counts[sorted[i]] < counts[sorted[j]] })
+		return rapid.SampledFrom(sorted).Draw(t, "sampled")
+	})
+}
@@ -0,0 +1,112 @@
+package xpathtest
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/antchfx/xpath"
+	"github.com/antchfx/xpath/dom"
+	"pgregory.net/rapid"
+)
+
+func depth(n *dom.Node) int {
+	d := 0
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == dom.ElementNode && depth(c)+1 > d {
+			d = depth(c) + 1
+		}
+	}
+	return d
+}
+
+func TestDocument(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Namespaces = map[string]string{"": "urn:d", "a": "urn:a"}
+	cfg.MaxDepth = 2
+	rapid.Check(t, func(t *rapid.T) {
+		doc := cfg.Document().Draw(t, "doc")
+		if d := depth(doc.FirstChild); d > 2 {
+			t.Fatalf("depth %d, want at most 2", d)
+		}
+		// The serialized document is well-formed, with the same elements
+		// in the same namespaces.
+		parsed, err := dom.Parse(strings.NewReader(doc.OutputXML(false)))
+		if err != nil {
+			t.Fatalf("%v\n%s", err, doc.OutputXML(false))
+		}
+		for _, expr := range []string{"count(//*)", "count(//*[namespace-uri() = 'urn:d'])", "count(//*[namespace-uri() = ''])", "count(//@*[namespace-uri() = 'urn:a'])"} {
+			count := xpath.MustCompile(expr)
+			if got, want := count.Evaluate(dom.CreateNavigator(parsed)), count.Evaluate(dom.CreateNavigator(doc)); got != want {
+				t.Fatalf("%s = %v parsed, want %v\n%s", expr, got, want, doc.OutputXML(false))
+			}
+		}
+	})
+}
+
+func TestExpr(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Namespaces = map[string]string{"a": "urn:a", "b": "urn:b"}
+	cfg.Tags = []string{"x"}
+	cfg.MaxSteps = 1
+	rapid.Check(t, func(t *rapid.T) {
+		expr := cfg.Expr().Draw(t, "expr")
+		if _, err := xpath.CompileWithNS(expr, cfg.Namespaces); err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		for _, name := range []string{"span", "table"} {
+			if strings.Contains(expr, name) {
+				t.Fatalf("%s has a name not in the Tags", expr)
+			}
+		}
+	})
+}
+
+func TestExprOperators(t *testing.T) {
+	cfg := DefaultConfig()
+	seen := make(map[string]bool)
+	// The examples of fixed seeds make the test deterministic.
+	for seed := 0; seed < 1000; seed++ {
+		expr := cfg.Expr().Example(seed)
+		if _, err := xpath.Compile(expr); err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		for _, op := range []string{" | ", " or ", " = ", " + ", " div ", "(-"} {
+			if strings.Contains(expr, op) {
+				seen[op] = true
+			}
+		}
+	}
+	for _, op := range []string{" | ", " or ", " = ", " + ", " div ", "(-"} {
+		if !seen[op] {
+			t.Errorf("no expression with %q", op)
+		}
+	}
+}
+
+func TestUnion(t *testing.T) {
+	cfg := DefaultConfig()
+	rapid.Check(t, func(t *rapid.T) {
+		expr := cfg.Union().Draw(t, "union")
+		exp, err := xpath.Compile(expr)
+		if err != nil {
+			t.Fatalf("%s: %v", expr, err)
+		}
+		doc := cfg.Document().Draw(t, "doc")
+		if _, ok := exp.Evaluate(dom.CreateNavigator(doc)).(*xpath.NodeIterator); !ok {
+			t.Fatalf("%s is not a node-set", expr)
+		}
+	})
+}
+
+func TestCoverage(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Coverage = new(xpath.Coverage)
+	for seed := 0; seed < 300; seed++ {
+		cfg.Coverage.Record(cfg.Expr().Example(seed))
+	}
+	// The generators have no processing instructions.
+	if got := cfg.Coverage.Uncovered(); !reflect.DeepEqual(got, []string{"node-test:processing-instruction()"}) {
+		t.Errorf("not covered: %v", got)
+	}
+}